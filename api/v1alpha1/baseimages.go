@@ -0,0 +1,130 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	BaseImageWatchGVK = schema.FromAPIVersionAndKind(Group+"/"+Version, "BaseImageWatch")
+)
+
+// BaseImageWatch watches the base images of Dockerfiles. When the digest of a base image changes e.g. because
+// a tag like debian:bookworm was updated with security fixes, or a base image is affected by a security
+// advisory, the images built from the Dockerfile are rebuilt.
+type BaseImageWatch struct {
+	APIVersion string             `yaml:"apiVersion" yamltags:"required"`
+	Kind       string             `yaml:"kind" yamltags:"required"`
+	Metadata   Metadata           `yaml:"metadata,omitempty"`
+	Spec       BaseImageWatchSpec `yaml:"spec,omitempty"`
+}
+
+type BaseImageWatchSpec struct {
+	// Dockerfiles are the Dockerfiles whose base images are watched.
+	Dockerfiles []WatchedDockerfile `yaml:"dockerfiles,omitempty"`
+
+	// Advisories are security advisories e.g. CVEs affecting base images. The images built from a Dockerfile with
+	// an affected base image are rebuilt once for each advisory.
+	Advisories []Advisory `yaml:"advisories,omitempty"`
+
+	// StateURI is the local file or GCS object where the digests of the base images and the advisories that were
+	// acted on are recorded between reconciles.
+	StateURI string `yaml:"stateURI,omitempty"`
+
+	// Issues, if true, opens an issue in the repository listing the images that were rebuilt and why.
+	Issues bool `yaml:"issues,omitempty"`
+
+	// Owners is a list of the teams or people who own the images e.g. GitHub teams or email addresses.
+	Owners []string `yaml:"owners,omitempty"`
+	// Notify specifies where to send notifications when rebuilding the images fails.
+	Notify *NotifyConfig `yaml:"notify,omitempty"`
+}
+
+// WatchedDockerfile is a Dockerfile whose base images are watched.
+type WatchedDockerfile struct {
+	// Path is the path of the Dockerfile. Relative paths are interpreted relative to the location of the YAML file
+	// containing the BaseImageWatch.
+	Path string `yaml:"path,omitempty"`
+	// Images are the paths of the YAML files containing the Image resources built from the Dockerfile. Relative
+	// paths are interpreted relative to the location of the YAML file containing the BaseImageWatch.
+	Images []string `yaml:"images,omitempty"`
+}
+
+// Advisory is a security advisory affecting base images.
+type Advisory struct {
+	// ID identifies the advisory e.g. CVE-2024-3094.
+	ID string `yaml:"id,omitempty"`
+	// Images are prefixes of the affected base images e.g. debian:bookworm or docker.io/library/debian.
+	Images []string `yaml:"images,omitempty"`
+	// Digests optionally restricts the advisory to base images with one of these digests e.g. sha256:1234.
+	// If empty every digest is affected.
+	Digests []string `yaml:"digests,omitempty"`
+}
+
+// Matches returns true if the base image with the given digest is affected by the advisory.
+func (a Advisory) Matches(image string, digest string) bool {
+	matched := false
+	for _, prefix := range a.Images {
+		if strings.HasPrefix(image, prefix) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false
+	}
+	if len(a.Digests) == 0 {
+		return true
+	}
+	for _, d := range a.Digests {
+		if d == digest {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValid returns true if the config is valid.
+// For invalid config the string will be a message of validation errors
+func (w *BaseImageWatch) IsValid() (string, bool) {
+	errors := make([]string, 0, 10)
+
+	if len(w.Spec.Dockerfiles) == 0 {
+		errors = append(errors, "Spec.Dockerfiles must be specified")
+	}
+	for i, d := range w.Spec.Dockerfiles {
+		if d.Path == "" {
+			errors = append(errors, fmt.Sprintf("Spec.Dockerfiles[%d].Path must be specified", i))
+		}
+		if len(d.Images) == 0 {
+			errors = append(errors, fmt.Sprintf("Spec.Dockerfiles[%d].Images must be specified", i))
+		}
+	}
+
+	for i, a := range w.Spec.Advisories {
+		if a.ID == "" {
+			errors = append(errors, fmt.Sprintf("Spec.Advisories[%d].ID must be specified", i))
+		}
+		if len(a.Images) == 0 {
+			errors = append(errors, fmt.Sprintf("Spec.Advisories[%d].Images must be specified", i))
+		}
+	}
+
+	if w.Spec.StateURI == "" {
+		errors = append(errors, "Spec.StateURI must be specified")
+	} else if u, err := url.Parse(w.Spec.StateURI); err != nil || (u.Scheme != "" && u.Scheme != "file" && u.Scheme != "gs") {
+		errors = append(errors, "Spec.StateURI must be a local file or a GCS object")
+	}
+
+	if err := w.Spec.Notify.IsValid(); err != nil {
+		errors = append(errors, fmt.Sprintf("Spec.Notify is invalid; %v", err))
+	}
+
+	if len(errors) > 0 {
+		return "BaseImageWatch is invalid. " + strings.Join(errors, ". "), false
+	}
+	return "", true
+}
@@ -0,0 +1,47 @@
+package v1alpha1
+
+import (
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// Callback is an HTTP endpoint that is sent the result of reconciling a resource when the reconcile finishes
+// e.g. when a ManifestSync finishes syncing or an Image finishes building. This lets external systems such as
+// deployment pipelines chain off hydros without polling.
+type Callback struct {
+	// URL is the endpoint the result is POSTed to as JSON.
+	URL string `json:"url,omitempty" yaml:"url,omitempty"`
+	// Secret is the URI of the secret used to sign the payload e.g. a local file or
+	// gcpSecretManager:///projects/acme/secrets/callback/versions/latest. The hex encoded HMAC-SHA256 of the body
+	// is sent in the X-Hydros-Signature-256 header prefixed with sha256=. If it is empty the payload isn't signed.
+	Secret string `json:"secret,omitempty" yaml:"secret,omitempty"`
+}
+
+// IsValid returns an error if the callback is invalid.
+func (c Callback) IsValid() error {
+	if c.URL == "" {
+		return errors.New("Callback.URL is required")
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return errors.Wrapf(err, "Callback.URL %v is invalid", c.URL)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.Errorf("Callback.URL %v is invalid; the scheme must be http or https", c.URL)
+	}
+	if u.Host == "" {
+		return errors.Errorf("Callback.URL %v is invalid; it must include the host", c.URL)
+	}
+	return nil
+}
+
+// validateCallbacks returns an error if any of the callbacks are invalid.
+func validateCallbacks(callbacks []Callback) error {
+	for i, c := range callbacks {
+		if err := c.IsValid(); err != nil {
+			return errors.Wrapf(err, "Callbacks[%d] is invalid", i)
+		}
+	}
+	return nil
+}
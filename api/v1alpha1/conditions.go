@@ -0,0 +1,106 @@
+package v1alpha1
+
+import (
+	"time"
+)
+
+type (
+	// ConditionType is an enum for the aspects of a resource's state reported by its conditions.
+	ConditionType string
+	// ConditionStatus is an enum for the status of a condition.
+	ConditionStatus string
+)
+
+const (
+	// ReadyCondition is true when the latest reconcile of the resource succeeded.
+	ReadyCondition ConditionType = "Ready"
+	// SyncedCondition is true when the resource is up to date with its source e.g. the hydrated manifests of a
+	// ManifestSync were hydrated from the latest source commit.
+	SyncedCondition ConditionType = "Synced"
+	// BuildingCondition is true while an image is being built.
+	BuildingCondition ConditionType = "Building"
+	// BlockedCondition is true when the resource can't make progress without intervention e.g. a PR is waiting
+	// for approval or the hydrated manifests violate a policy.
+	BlockedCondition ConditionType = "Blocked"
+	// EmergencyCondition is true when the latest sync of a ManifestSync was an emergency sync. Its message records
+	// the reason for the emergency and any pauses that were bypassed.
+	EmergencyCondition ConditionType = "Emergency"
+
+	ConditionTrue    ConditionStatus = "True"
+	ConditionFalse   ConditionStatus = "False"
+	ConditionUnknown ConditionStatus = "Unknown"
+
+	// ReconcileSucceededReason is the reason of Ready conditions when the reconcile succeeded.
+	ReconcileSucceededReason = "ReconcileSucceeded"
+	// ReconcileFailedReason is the reason of conditions when the reconcile failed.
+	ReconcileFailedReason = "ReconcileFailed"
+)
+
+// Condition reports an aspect of the state of a resource. The conditions of the hydros resources follow the
+// Kubernetes conventions.
+// See: https://github.com/kubernetes/community/blob/master/contributors/devel/sig-architecture/api-conventions.md#typical-status-properties
+type Condition struct {
+	// Type is the aspect of the state reported by the condition e.g. Ready.
+	Type ConditionType `json:"type" yaml:"type"`
+	// Status is True, False or Unknown.
+	Status ConditionStatus `json:"status" yaml:"status"`
+	// Reason is a CamelCase identifier for why the condition has its status e.g. AwaitingApproval.
+	Reason string `json:"reason,omitempty" yaml:"reason,omitempty"`
+	// Message is a human readable description of the condition.
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+	// LastTransitionTime is when the status of the condition last changed.
+	LastTransitionTime time.Time `json:"lastTransitionTime,omitempty" yaml:"lastTransitionTime,omitempty"`
+}
+
+// NewCondition returns a condition whose LastTransitionTime is now.
+func NewCondition(t ConditionType, status ConditionStatus, reason string, message string) Condition {
+	return Condition{
+		Type:               t,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// SetCondition sets the condition with the type of c replacing the existing condition of that type if there is
+// one. If the status of the condition didn't change its LastTransitionTime is kept.
+func SetCondition(conditions []Condition, c Condition) []Condition {
+	for i, existing := range conditions {
+		if existing.Type != c.Type {
+			continue
+		}
+		if existing.Status == c.Status && !existing.LastTransitionTime.IsZero() {
+			c.LastTransitionTime = existing.LastTransitionTime
+		}
+		conditions[i] = c
+		return conditions
+	}
+	return append(conditions, c)
+}
+
+// GetCondition returns the condition with type t or nil if there isn't one.
+func GetCondition(conditions []Condition, t ConditionType) *Condition {
+	for i := range conditions {
+		if conditions[i].Type == t {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// MergeConditions returns the current conditions keeping the LastTransitionTime of the previous conditions whose
+// status didn't change.
+func MergeConditions(previous []Condition, current []Condition) []Condition {
+	if len(current) == 0 {
+		return nil
+	}
+	merged := make([]Condition, 0, len(previous)+len(current))
+	merged = append(merged, previous...)
+	result := make([]Condition, 0, len(current))
+	for _, c := range current {
+		merged = SetCondition(merged, c)
+		result = append(result, *GetCondition(merged, c.Type))
+	}
+	return result
+}
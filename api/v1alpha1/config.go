@@ -32,6 +32,11 @@ type InPlaceConfig struct {
 	// Paths is the relative paths of the directories to search for KRMFunctions
 	// If this is blank then the entire repo will be search.
 	Paths []string `yaml:"paths"`
+	// FunctionKinds restricts the kinds of KRMFunctions that are applied.
+	FunctionKinds *FunctionKinds `yaml:"functionKinds,omitempty"`
+	// ExternalFunctions selects the containerized and exec KRM functions that are applied. Only functions also
+	// allowed by the functions of the hydros config are run. If it isn't set no external functions are run.
+	ExternalFunctions *ExternalFunctions `yaml:"externalFunctions,omitempty"`
 }
 
 // IsValid returns true if the config is valid.
@@ -1,11 +1,20 @@
 package v1alpha1
 
 import (
+	"bytes"
 	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime/schema"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -14,6 +23,14 @@ type (
 	Strategy string
 	// RepoMatchType is an enum for how repo matching rules should be applied.
 	RepoMatchType string
+	// SourceFormat is an enum for the format of the manifests at the source path.
+	SourceFormat string
+	// GuardAction is an enum for what to do when hydrated files violate the FileGuards.
+	GuardAction string
+	// DestLayoutType is an enum for how hydrated kustomizations are laid out in the dest path.
+	DestLayoutType string
+	// HydrationMode is an enum for how the manifests in the dest path are updated.
+	HydrationMode string
 )
 
 const (
@@ -34,17 +51,77 @@ const (
 	// TODO(jeremy): Should we get rid of this? This was for Primer specific tagging.
 	LatestTagPrefix Strategy = "latestTagPrefix"
 
+	// NewestMatchingTagStrategy means you should list the tags in the registry and pin the newest tag matching
+	// TagPattern. Tags are ordered by semantic version e.g. to track upstream images like nginx 1.25.x.
+	NewestMatchingTagStrategy Strategy = "newestMatchingTag"
+
+	// SemverStrategy means you should list the tags in the registry and pin the highest semantic version
+	// satisfying Constraint e.g. ">=1.2.0 <2.0.0".
+	SemverStrategy Strategy = "semver"
+
+	// PromotedTagStrategy means you should pin the image with the PromotionTag instead of the tag of the image e.g.
+	// the tag of an environment applied by an ImagePromotion.
+	PromotedTagStrategy Strategy = "promotedTag"
+
 	// IncludeRepo is the enum value indicating a repo list is an include list.
 	IncludeRepo RepoMatchType = "include"
 	// ExcludeRepo is the enum value indicating a repo list is an exclude list.
 	ExcludeRepo RepoMatchType = "exclude"
 
+	// KustomizeSourceFormat means the source path contains kustomize packages which are hydrated using
+	// kustomize build. This is the default.
+	KustomizeSourceFormat SourceFormat = "kustomize"
+	// YAMLSourceFormat means the source path contains plain YAML manifests which are copied as is.
+	YAMLSourceFormat SourceFormat = "yaml"
+
+	// FailGuardAction means the sync fails and nothing is committed.
+	FailGuardAction GuardAction = "fail"
+	// WarnGuardAction means the files are committed and listed as warnings in the PR description.
+	WarnGuardAction GuardAction = "warn"
+
+	// StripOverlayDestLayout means each kustomization is hydrated into the directory of the kustomization
+	// without the final directory, which is assumed to be the overlay e.g. a/b/dev/kustomization.yaml is hydrated
+	// into a/b. This is the default.
+	StripOverlayDestLayout DestLayoutType = "stripOverlay"
+	// KeepOverlayDestLayout means each kustomization is hydrated into the directory of the kustomization
+	// e.g. a/b/dev/kustomization.yaml is hydrated into a/b/dev.
+	KeepOverlayDestLayout DestLayoutType = "keepOverlay"
+	// FlattenDestLayout means all kustomizations are hydrated directly into the dest path.
+	FlattenDestLayout DestLayoutType = "flatten"
+	// TemplateDestLayout means the directory is generated by executing the template of the DestLayout.
+	TemplateDestLayout DestLayoutType = "template"
+
+	// FullHydrationMode means the manifests are hydrated from the source and replace the dest path. This is the
+	// default.
+	FullHydrationMode HydrationMode = "full"
+	// PinOnlyHydrationMode means hydration is skipped; only the images pinned by the last sync in the files already
+	// in the dest path are updated.
+	PinOnlyHydrationMode HydrationMode = "pinOnly"
+
+	// DefaultMaxFileSize is the default size of the largest file that can be committed.
+	DefaultMaxFileSize = "1Mi"
+
 	// PauseAnnotation is the annotation used to pause a sync.
 	PauseAnnotation    = "hydros.dev/pauseUntil"
 	TakeoverAnnotation = "hydros.dev/takeover"
+	// TakeoverByAnnotation records who is doing the takeover e.g. the email of a developer.
+	TakeoverByAnnotation = "hydros.dev/takeoverBy"
+	// EmergencyAnnotation marks a ManifestSync as an emergency e.g. an urgent security fix. Emergency syncs bypass
+	// pauses. The reason must be given with EmergencyReasonAnnotation and when the emergency ends with
+	// EmergencyExpiresAnnotation.
+	EmergencyAnnotation = "hydros.dev/emergency"
+	// EmergencyReasonAnnotation is the reason for an emergency sync. It is recorded in the PR, the conditions and
+	// the logs.
+	EmergencyReasonAnnotation = "hydros.dev/emergencyReason"
+	// EmergencyExpiresAnnotation is when the emergency ends as an RFC3339 timestamp. The EmergencyAnnotation is
+	// ignored once it has passed so a forgotten annotation doesn't keep bypassing pauses.
+	EmergencyExpiresAnnotation = "hydros.dev/emergencyExpires"
 )
 
 var (
+	// validDestinationName matches valid names of destinations. Names are used in directory names.
+	validDestinationName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
 	ManifestSyncGVK = schema.FromAPIVersionAndKind(Group+"/"+Version, ManifestSyncKind)
 )
 
@@ -72,7 +149,14 @@ type ManifestSyncSpec struct {
 	// to search for manifests to hydrate
 	SourcePath string `yaml:"sourcePath,omitempty"`
 
+	// SourceFormat is the format of the manifests at SourcePath. Defaults to kustomize.
+	// When it is yaml, all the resources in YAML files below SourcePath are copied to DestPath preserving their
+	// relative paths. Images are pinned and functions applied as they are for kustomize packages.
+	SourceFormat SourceFormat `yaml:"sourceFormat,omitempty"`
+
 	// Selector selects which kustomizations to be used by matching kustomize labels.
+	// When SourceFormat is yaml, it selects the resources to hydrate by matching their labels. If it isn't
+	// specified all resources are hydrated.
 	Selector *LabelSelector `yaml:"selector,omitempty"`
 
 	// Only kustomizations which include these annotations as commonAnnotations will be hydrated.
@@ -83,6 +167,17 @@ type ManifestSyncSpec struct {
 	// This directory will be deleted and recreated for each PR to ensure pruned resources are removed
 	DestPath string `yaml:"destPath,omitempty"`
 
+	// DestRepos is a list of destinations to hydrate the manifests to e.g. one per regional cluster.
+	// Each destination gets its own PR and its own status. If DestRepos is specified then ForkRepo, DestRepo and
+	// DestPath must not be.
+	DestRepos []Destination `yaml:"destRepos,omitempty"`
+
+	// Environments is a matrix of environments e.g. dev, staging and prod. Each environment is hydrated into its
+	// own DestPath in DestRepo using its own selector, image strategies and functions. Each environment is synced
+	// independently and gets its own PR from ForkRepo.Branch suffixed with the name of the environment.
+	// If Environments is specified DestPath and DestRepos must not be.
+	Environments []Environment `yaml:"environments,omitempty"`
+
 	// ImageTagsToPin is a list of image tags whose images should be pinned.
 	// It is a replacement for ImageTags.
 	ImageTagsToPin []ImageTagToPin `yaml:"imageTagsToPin,omitempty"`
@@ -94,6 +189,13 @@ type ManifestSyncSpec struct {
 	// ImageBuilder configures the image building.
 	ImageBuilder *ImageBuilder `yaml:"imageBuilder,omitempty"`
 
+	// SourceCommitTag configures how the tag of images using the sourceCommit strategy is computed from the
+	// commit. If not specified the full commit hash is used.
+	SourceCommitTag *SourceCommitTag `yaml:"sourceCommitTag,omitempty"`
+
+	// ImagePolicy configures the policies images must satisfy before they are pinned.
+	ImagePolicy *ImagePolicy `yaml:"imagePolicy,omitempty"`
+
 	// ExcludeDirs is a list of paths relative to the repo root exclude. This is typically directories that
 	// store templates. These directories will not be considered at all; e.g.
 	//  1. Manifests are not eligible for image replacement
@@ -105,8 +207,69 @@ type ManifestSyncSpec struct {
 	// PrLabels is a list of labels to add to the PR.
 	PrLabels []string `yaml:"prLabels,omitempty"`
 
+	// CommitPerOverlay splits the hydrated changes into one commit per overlay (i.e. per directory in DestPath)
+	// so the history of each overlay in the dest repo is meaningful. The PR is merged by rebasing rather than
+	// squashing to preserve the commits so rebase merging must be allowed in the dest repo.
+	CommitPerOverlay bool `yaml:"commitPerOverlay,omitempty"`
+
+	// Merge configures how the PRs created by hydros are merged. By default hydros merges PRs as soon as they
+	// can be merged.
+	Merge *MergeConfig `yaml:"merge,omitempty"`
+
+	// FileGuards guards against committing binaries and large files to the dest repo. If it isn't specified
+	// the defaults are used and violations are only warnings.
+	FileGuards *FileGuards `yaml:"fileGuards,omitempty"`
+
+	// Policies checks the hydrated manifests against policies in the source repo before they land in the dest
+	// repo. If it isn't specified no policies are checked.
+	Policies *PolicyCheck `yaml:"policies,omitempty"`
+
+	// Apply optionally applies the hydrated manifests directly to a cluster instead of or in addition to
+	// committing them to the dest repo.
+	Apply *ApplyConfig `yaml:"apply,omitempty"`
+
+	// ArgoCD optionally writes an ArgoCD Application to the dest repo which deploys the hydrated manifests in
+	// DestPath so clusters deploy them without hand-written Applications.
+	ArgoCD *ArgoCDApplication `yaml:"argoCD,omitempty"`
+
+	// DestLayout controls the directories in DestPath that kustomizations are hydrated into. If it isn't
+	// specified the overlay directory is stripped e.g. a/b/dev/kustomization.yaml is hydrated into a/b.
+	DestLayout *DestLayout `yaml:"destLayout,omitempty"`
+
+	// VersionSkew is what to do when the manifests in DestPath were hydrated by a different major or minor version
+	// of hydros. Different versions of hydros can hydrate the same source differently so the PR may contain
+	// changes that aren't due to the source. Defaults to warn which notes the skew in the PR description; fail
+	// fails the sync unless it is forced.
+	VersionSkew GuardAction `yaml:"versionSkew,omitempty"`
+
+	// Hydration is how the manifests in DestPath are updated; full or pinOnly. Defaults to full which hydrates the
+	// source and replaces DestPath. pinOnly skips hydration and only updates the images pinned by the last sync in
+	// the files already in DestPath, including the images of kustomizations, to the newly resolved images. It is
+	// for environments where re-hydrating is too disruptive but new images need to roll out quickly. Changes to
+	// the source aren't synced until the mode is switched back to full.
+	Hydration HydrationMode `yaml:"hydration,omitempty"`
+
 	// Functions is a list of kustomize functions to apply to the hydrated manifests
 	Functions []Function `yaml:"functions,omitempty"`
+
+	// FunctionKinds restricts the kinds of functions that are applied to the hydrated manifests.
+	FunctionKinds *FunctionKinds `yaml:"functionKinds,omitempty"`
+
+	// ExternalFunctions selects the containerized and exec KRM functions that are applied to the hydrated
+	// manifests. Only functions also allowed by the functions of the hydros config are run. If it isn't set no
+	// external functions are run.
+	ExternalFunctions *ExternalFunctions `yaml:"externalFunctions,omitempty"`
+
+	// SyncPeriod overrides how often the ManifestSync is synced when it is applied periodically or resynced by the
+	// hydros server e.g. "30m". Defaults to the period hydros is run with.
+	SyncPeriod string `yaml:"syncPeriod,omitempty"`
+
+	// Owners is a list of the teams or people who own the ManifestSync e.g. GitHub teams or email addresses.
+	Owners []string `yaml:"owners,omitempty"`
+	// Notify specifies where to send notifications when syncing fails.
+	Notify *NotifyConfig `yaml:"notify,omitempty"`
+	// Callbacks are sent the result of each sync that hydrates the manifests or fails.
+	Callbacks []Callback `yaml:"callbacks,omitempty"`
 }
 
 // GitHubRepo represents a GitHub repo.
@@ -120,16 +283,122 @@ type GitHubRepo struct {
 // ManifestSyncStatus is the status for ManifestSync resources.
 type ManifestSyncStatus struct {
 	// PausedUntil is a timestamp indicating when the sync should be paused until.
-	PausedUntil  *metav1.Time  `yaml:"pausedUntil,omitempty"`
+	PausedUntil *metav1.Time `yaml:"pausedUntil,omitempty"`
+	// PausedBy is who paused the sync e.g. the developer doing a takeover.
+	PausedBy     string        `yaml:"pausedBy,omitempty"`
 	SourceURL    string        `yaml:"sourceUrl,omitempty"`
 	SourceCommit string        `yaml:"sourceCommit,omitempty"`
 	PinnedImages []PinnedImage `yaml:"pinnedImages,omitempty"`
+	// Destination is the name of the destination the status is for. It is empty unless spec.destRepos is used.
+	Destination string `yaml:"destination,omitempty"`
+	// HydrosVersion is the version of hydros that hydrated the manifests.
+	HydrosVersion string `yaml:"hydrosVersion,omitempty"`
+	// Conditions report the result of the latest sync. They aren't recorded in the lastsync file.
+	Conditions []Condition `yaml:"conditions,omitempty"`
+}
+
+// Destination is a location to hydrate manifests to.
+type Destination struct {
+	// Name uniquely identifies the destination e.g. us-west-2.
+	Name string `yaml:"name,omitempty"`
+	// ForkRepo is the repo into which the hydrated manifests will be pushed
+	ForkRepo GitHubRepo `yaml:"forkRepo,omitempty"`
+	// DestRepo is the repo into which a PR will be created to merge hydrated
+	// manifests from the ForkRepo
+	DestRepo GitHubRepo `yaml:"destRepo,omitempty"`
+	// DestPath is the directory in the destination repo where hydrated manifests should be emitted.
+	DestPath string `yaml:"destPath,omitempty"`
+}
+
+// Destinations returns the destinations to hydrate the manifests to. If DestRepos isn't specified this is a single
+// unnamed destination made up of ForkRepo, DestRepo and DestPath.
+func (m *ManifestSync) Destinations() []Destination {
+	if len(m.Spec.DestRepos) > 0 {
+		return m.Spec.DestRepos
+	}
+	return []Destination{
+		{
+			ForkRepo: m.Spec.ForkRepo,
+			DestRepo: m.Spec.DestRepo,
+			DestPath: m.Spec.DestPath,
+		},
+	}
+}
+
+// IsEmergency returns true if the ManifestSync is annotated as an emergency.
+func (m *ManifestSync) IsEmergency() bool {
+	v, ok := m.Metadata.Annotations[EmergencyAnnotation]
+	if !ok {
+		return false
+	}
+	emergency, err := strconv.ParseBool(strings.TrimSpace(v))
+	return err == nil && emergency
+}
+
+// EmergencyExpires returns when the emergency of the ManifestSync ends. An error is returned if the
+// EmergencyExpiresAnnotation is missing or isn't an RFC3339 timestamp.
+func (m *ManifestSync) EmergencyExpires() (time.Time, error) {
+	v := strings.TrimSpace(m.Metadata.Annotations[EmergencyExpiresAnnotation])
+	if v == "" {
+		return time.Time{}, fmt.Errorf("ManifestSync annotation %v must be set to when the emergency ends when %v is true", EmergencyExpiresAnnotation, EmergencyAnnotation)
+	}
+	expires, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ManifestSync annotation %v has invalid value %v; it must be an RFC3339 timestamp e.g. 2023-06-01T17:00:00Z", EmergencyExpiresAnnotation, v)
+	}
+	return expires, nil
+}
+
+// GetSyncPeriod returns how often the ManifestSync should be synced. defaultPeriod is returned if SyncPeriod
+// isn't set or isn't valid.
+func (m *ManifestSync) GetSyncPeriod(defaultPeriod time.Duration) time.Duration {
+	return parseSyncPeriod(m.Spec.SyncPeriod, defaultPeriod)
+}
+
+// Environment is an overlay of a ManifestSync that is hydrated into its own directory.
+type Environment struct {
+	// Name uniquely identifies the environment e.g. staging.
+	Name string `yaml:"name,omitempty"`
+	// Selector selects the kustomizations or resources to hydrate for this environment. If it isn't specified
+	// the selector of the ManifestSync is used.
+	Selector *LabelSelector `yaml:"selector,omitempty"`
+	// DestPath is the directory in the destination repo where the hydrated manifests of the environment should
+	// be emitted.
+	DestPath string `yaml:"destPath,omitempty"`
+	// Functions is a list of additional kustomize functions to apply to the hydrated manifests of the
+	// environment. They are applied after the functions of the ManifestSync.
+	Functions []Function `yaml:"functions,omitempty"`
+	// ImageTagsToPin overrides the ImageTagsToPin of the ManifestSync for this environment
+	// e.g. to track the newest build in dev but a semantic version in prod.
+	ImageTagsToPin []ImageTagToPin `yaml:"imageTagsToPin,omitempty"`
+}
+
+// ForEnvironment returns a copy of the ManifestSync which syncs only the environment e.
+func (m *ManifestSync) ForEnvironment(e Environment) *ManifestSync {
+	n := *m
+	n.Metadata.Name = m.Metadata.Name + "-" + e.Name
+	n.Spec.Environments = nil
+	n.Spec.DestPath = e.DestPath
+	// Each environment needs its own fork branch since each sync force pushes to it.
+	n.Spec.ForkRepo.Branch = m.Spec.ForkRepo.Branch + "-" + e.Name
+	if e.Selector != nil {
+		n.Spec.Selector = e.Selector
+	}
+	if len(e.ImageTagsToPin) > 0 {
+		n.Spec.ImageTagsToPin = e.ImageTagsToPin
+	}
+	n.Spec.Functions = make([]Function, 0, len(m.Spec.Functions)+len(e.Functions))
+	n.Spec.Functions = append(n.Spec.Functions, m.Spec.Functions...)
+	n.Spec.Functions = append(n.Spec.Functions, e.Functions...)
+	return &n
 }
 
 // PinnedImage represents the mapping of an image to the value it should be pinned to.
 type PinnedImage struct {
 	Image    string `yaml:"image,omitempty"`
 	NewImage string `yaml:"newImage,omitempty"`
+	// Version is the semantic version chosen for images pinned using the SemverStrategy.
+	Version string `yaml:"version,omitempty"`
 }
 
 // ImageTagToPin describes an image tag to pin.
@@ -139,11 +408,57 @@ type ImageTagToPin struct {
 	// Strategy is an enum indicating how the image should be pinned.
 	Strategy Strategy `yaml:"strategy,omitempty"`
 
+	// TagPattern is a regex the tags must match to be pinned. Only used by the NewestMatchingTagStrategy.
+	// e.g. ^1\.25\.[0-9]+$
+	TagPattern string `yaml:"tagPattern,omitempty"`
+
+	// Constraint is the semantic version constraint the tags must satisfy to be pinned. Only used by the
+	// SemverStrategy. e.g. ">=1.2.0 <2.0.0" or "~1.25"
+	Constraint string `yaml:"constraint,omitempty"`
+
+	// PromotionTag is the tag of the images to pin e.g. prod. Only used by the PromotedTagStrategy.
+	PromotionTag string `yaml:"promotionTag,omitempty"`
+
 	// ImageRepoMatch describes the image repos to match
 	// If nil all repos are matched.
 	ImageRepoMatch *ImageRepoMatch `yaml:"imageRepoMatch,omitempty"`
 }
 
+// IsValid checks if the ImageTagToPin is valid.
+func (t *ImageTagToPin) IsValid() error {
+	if t.Strategy != NewestMatchingTagStrategy && t.TagPattern != "" {
+		return errors.Errorf("tagPattern can only be used with strategy %v", NewestMatchingTagStrategy)
+	}
+	if t.Strategy != SemverStrategy && t.Constraint != "" {
+		return errors.Errorf("constraint can only be used with strategy %v", SemverStrategy)
+	}
+	if t.Strategy != PromotedTagStrategy && t.PromotionTag != "" {
+		return errors.Errorf("promotionTag can only be used with strategy %v", PromotedTagStrategy)
+	}
+
+	switch t.Strategy {
+	case NewestMatchingTagStrategy:
+		if t.TagPattern == "" {
+			return errors.Errorf("strategy %v requires a tagPattern", NewestMatchingTagStrategy)
+		}
+		if _, err := regexp.Compile(t.TagPattern); err != nil {
+			return errors.Wrapf(err, "tagPattern %v isn't a valid regex", t.TagPattern)
+		}
+	case SemverStrategy:
+		if t.Constraint == "" {
+			return errors.Errorf("strategy %v requires a constraint", SemverStrategy)
+		}
+		if _, err := semver.NewConstraint(t.Constraint); err != nil {
+			return errors.Wrapf(err, "constraint %v isn't a valid semantic version constraint", t.Constraint)
+		}
+	case PromotedTagStrategy:
+		if t.PromotionTag == "" {
+			return errors.Errorf("strategy %v requires a promotionTag", PromotedTagStrategy)
+		}
+	}
+	return nil
+}
+
 // ImageRepoMatch describes how to match repos.
 type ImageRepoMatch struct {
 	Repos []string `yaml:"repos,omitempty"`
@@ -151,11 +466,129 @@ type ImageRepoMatch struct {
 	Type RepoMatchType `yaml:"type,omitempty"`
 }
 
+// SourceCommitTag configures how image tags are computed from the source commit. This needs to match the
+// tags produced by the CI system building the images otherwise images can't be resolved.
+// The same abbreviation length is used for the short hashes in the git repositories.
+type SourceCommitTag struct {
+	// AbbrevLength is the number of characters of the commit hash to use e.g. 8 or 12.
+	// If 0 the full commit hash is used.
+	AbbrevLength int `yaml:"abbrevLength,omitempty"`
+	// Template is a go template for the tag. The template can use .Commit which is the commit abbreviated to
+	// AbbrevLength characters and .FullCommit which is the full commit hash.
+	// e.g. "v1-{{.Commit}}". Defaults to "{{.Commit}}".
+	Template string `yaml:"template,omitempty"`
+}
+
+const (
+	// defaultAbbrevLength is the length used for short hashes when AbbrevLength isn't set.
+	defaultAbbrevLength = 7
+	// minAbbrevLength is the shortest abbreviation git supports.
+	minAbbrevLength = 4
+	// fullCommitLength is the length of a SHA1 commit hash
+	fullCommitLength = 40
+)
+
+// GetAbbrevLength returns the length to use for short hashes in git e.g. the value of core.abbrev.
+func (t *SourceCommitTag) GetAbbrevLength() int {
+	if t == nil || t.AbbrevLength == 0 {
+		return defaultAbbrevLength
+	}
+	return t.AbbrevLength
+}
+
+// Tag computes the image tag for the commit.
+func (t *SourceCommitTag) Tag(commit string) (string, error) {
+	if t == nil {
+		return commit, nil
+	}
+
+	short := commit
+	if t.AbbrevLength > 0 && len(commit) > t.AbbrevLength {
+		short = commit[:t.AbbrevLength]
+	}
+
+	if t.Template == "" {
+		return short, nil
+	}
+
+	tmpl, err := template.New("tag").Option("missingkey=error").Parse(t.Template)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to parse tag template %v", t.Template)
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, map[string]string{"Commit": short, "FullCommit": commit}); err != nil {
+		return "", errors.Wrapf(err, "Failed to execute tag template %v", t.Template)
+	}
+	return b.String(), nil
+}
+
+// IsValid returns an error if the configuration is invalid.
+func (t *SourceCommitTag) IsValid() error {
+	if t == nil {
+		return nil
+	}
+	if t.AbbrevLength != 0 && (t.AbbrevLength < minAbbrevLength || t.AbbrevLength > fullCommitLength) {
+		return fmt.Errorf("AbbrevLength must be between %d and %d; got %d", minAbbrevLength, fullCommitLength, t.AbbrevLength)
+	}
+	if _, err := t.Tag(strings.Repeat("0", fullCommitLength)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ImagePolicy configures the policies images must satisfy before the Syncer pins them.
+type ImagePolicy struct {
+	// RequireSignature, if true, requires the resolved digest of every image to be signed by one of Identities.
+	// Images that aren't signed aren't pinned and the sync fails.
+	RequireSignature bool `yaml:"requireSignature,omitempty"`
+	// Identities are the cosign identities trusted to sign images. An image is signed if its signature can be
+	// verified with any one of them.
+	Identities []SignatureIdentity `yaml:"identities,omitempty"`
+}
+
+// SignatureIdentity is an identity trusted to sign images. It is either a key, or the issuer and subject of the
+// certificates issued for keyless signing.
+type SignatureIdentity struct {
+	// KeyRef is the cosign key used to verify signatures e.g.
+	// gcpkms://projects/<project>/locations/global/keyRings/<ring>/cryptoKeys/<key> or the path to a public key.
+	KeyRef string `yaml:"keyRef,omitempty"`
+	// Issuer is the OIDC issuer of keyless signing certificates e.g. https://accounts.google.com
+	Issuer string `yaml:"issuer,omitempty"`
+	// Subject is the identity in keyless signing certificates e.g. the email of a service account.
+	Subject string `yaml:"subject,omitempty"`
+}
+
+// IsValid returns an error if the policy is invalid.
+func (p *ImagePolicy) IsValid() error {
+	if p == nil {
+		return nil
+	}
+	if p.RequireSignature && len(p.Identities) == 0 {
+		return fmt.Errorf("Identities must be specified when requireSignature is true")
+	}
+	for i, id := range p.Identities {
+		keyless := id.Issuer != "" || id.Subject != ""
+		if id.KeyRef != "" && keyless {
+			return fmt.Errorf("Identities[%d] must specify either keyRef or issuer and subject but not both", i)
+		}
+		if id.KeyRef == "" && (id.Issuer == "" || id.Subject == "") {
+			return fmt.Errorf("Identities[%d] must specify keyRef or both issuer and subject", i)
+		}
+	}
+	return nil
+}
+
 // ImageBuilder configures the image builder.
 type ImageBuilder struct {
-	// Enabled is a boolean indicating whether the image builder is enabled or not
+	// Enabled is a boolean indicating whether the image builder is enabled or not.
+	// If enabled, Image resources found under SourcePath are built at the source commit (if they don't
+	// already exist) before images are pinned. skaffold.yaml files are no longer used; syncs fail if there are
+	// skaffold.yaml files but no Image resources. See docs/hydrating_manifests.md for how to migrate them.
 	Enabled bool `yaml:"enabled,omitempty"`
 	// Registry is the registry to use with the images.
+	// Deprecated; this was only used by the skaffold based builder. Images are now built using the
+	// registry specified in each Image resource.
 	Registry string `yaml:"registry,omitempty"`
 }
 
@@ -168,14 +601,412 @@ type Function struct {
 	Paths []string `yaml:"paths,omitempty"`
 }
 
+// MergeConfig configures how hydros merges the PRs it creates.
+type MergeConfig struct {
+	// RequireApproval means hydros waits for humans to approve PRs before merging them. PRs are still created
+	// but aren't merged right away; a later sync merges the PR once it has the required approvals and labels.
+	// Until then the sync is reported as awaiting approval rather than failing.
+	RequireApproval bool `yaml:"requireApproval,omitempty"`
+	// Approvals is the number of approving reviews a PR needs before it is merged. Defaults to 1.
+	Approvals int `yaml:"approvals,omitempty"`
+	// Labels is a list of labels the PR must have before it is merged e.g. "approved".
+	Labels []string `yaml:"labels,omitempty"`
+}
+
+// RequiresApproval returns true if PRs need to be approved before they are merged. A nil MergeConfig doesn't
+// require approval.
+func (m *MergeConfig) RequiresApproval() bool {
+	return m != nil && m.RequireApproval
+}
+
+// RequiredApprovals returns the number of approving reviews needed before a PR is merged.
+func (m *MergeConfig) RequiredApprovals() int {
+	if !m.RequiresApproval() {
+		return 0
+	}
+	if m.Approvals <= 0 {
+		return 1
+	}
+	return m.Approvals
+}
+
+// FileGuards guards against committing files that are unlikely to be manifests to the dest repo. Binaries and
+// large files in the hydrated output are often symptoms of misconfigured helm charts vendoring tarballs.
+type FileGuards struct {
+	// MaxFileSize is the size of the largest file that can be committed e.g. "512Ki". Defaults to 1Mi.
+	MaxFileSize string `yaml:"maxFileSize,omitempty"`
+	// AllowBinary allows binary files to be committed.
+	AllowBinary bool `yaml:"allowBinary,omitempty"`
+	// Action is what to do when files violate the guards; fail or warn. Defaults to fail.
+	Action GuardAction `yaml:"action,omitempty"`
+}
+
+// MaxFileSizeBytes returns the size in bytes of the largest file that can be committed.
+func (g *FileGuards) MaxFileSizeBytes() (int64, error) {
+	size := DefaultMaxFileSize
+	if g != nil && g.MaxFileSize != "" {
+		size = g.MaxFileSize
+	}
+	q, err := resource.ParseQuantity(size)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to parse maxFileSize %v", size)
+	}
+	return q.Value(), nil
+}
+
+// Fails returns true if violating the guards should fail the sync. A nil FileGuards only warns so that syncs
+// which didn't opt in aren't broken.
+func (g *FileGuards) Fails() bool {
+	if g == nil {
+		return false
+	}
+	return g.Action == "" || g.Action == FailGuardAction
+}
+
+// IsValid returns an error if the guards are invalid.
+func (g *FileGuards) IsValid() error {
+	if g == nil {
+		return nil
+	}
+	if _, err := g.MaxFileSizeBytes(); err != nil {
+		return err
+	}
+	switch g.Action {
+	case "", FailGuardAction, WarnGuardAction:
+	default:
+		return fmt.Errorf("action %v is invalid; it must be %v or %v", g.Action, FailGuardAction, WarnGuardAction)
+	}
+	return nil
+}
+
+// PolicyCheck checks the hydrated manifests against Rego policies so platform teams can gate what lands in the
+// dest repo. Policies follow the conventions of conftest; each resource is the input of the policies and the
+// rules named deny in package main return the messages of its violations.
+type PolicyCheck struct {
+	// Path is the directory in the source repo containing the .rego files of the policies. It is relative to
+	// the root of the source repo.
+	Path string `yaml:"path,omitempty"`
+	// Action is what to do when manifests violate the policies; fail or warn. Defaults to fail which lists the
+	// violations in the PR but doesn't merge it and fails the sync. warn lists the violations and merges the PR.
+	Action GuardAction `yaml:"action,omitempty"`
+}
+
+// Fails returns true if violating the policies should fail the sync.
+func (p *PolicyCheck) Fails() bool {
+	if p == nil {
+		return false
+	}
+	return p.Action == "" || p.Action == FailGuardAction
+}
+
+// IsValid returns an error if the check is invalid.
+func (p *PolicyCheck) IsValid() error {
+	if p == nil {
+		return nil
+	}
+	if p.Path == "" {
+		return fmt.Errorf("path must be specified")
+	}
+	switch p.Action {
+	case "", FailGuardAction, WarnGuardAction:
+	default:
+		return fmt.Errorf("action %v is invalid; it must be %v or %v", p.Action, FailGuardAction, WarnGuardAction)
+	}
+	return nil
+}
+
+// ApplyConfig configures applying the hydrated manifests directly.
+type ApplyConfig struct {
+	// Cluster is the cluster to apply the hydrated manifests to with server-side apply.
+	Cluster *ClusterApply `yaml:"cluster,omitempty"`
+}
+
+// ClusterApply applies the hydrated manifests to a cluster with server-side apply. Each applied resource is
+// labeled with AppliedByLabel so resources which are no longer in the hydrated manifests can be pruned.
+type ClusterApply struct {
+	// Kubeconfig is the path of the kubeconfig file of the cluster. Defaults to the standard kubeconfig loading
+	// rules i.e. KUBECONFIG or ~/.kube/config and, if there isn't one, the in cluster config.
+	Kubeconfig string `yaml:"kubeconfig,omitempty"`
+	// Context is the context in the kubeconfig to use. Defaults to the current context.
+	Context string `yaml:"context,omitempty"`
+	// FieldManager is the field manager of the server-side apply. Defaults to hydros.
+	FieldManager string `yaml:"fieldManager,omitempty"`
+	// Prune deletes the resources applied by previous syncs of the ManifestSync which are no longer in the
+	// hydrated manifests.
+	Prune bool `yaml:"prune,omitempty"`
+	// Mode is whether the hydrated manifests are also committed; commitAndApply or applyOnly. Defaults to
+	// commitAndApply which applies the manifests in the dest branch whenever its head changes e.g. once the PR in
+	// the dest repo is merged. applyOnly applies the manifests once they pass the validation and policy checks and
+	// doesn't create a PR.
+	Mode ApplyMode `yaml:"mode,omitempty"`
+}
+
+// ApplyMode is whether the hydrated manifests are committed as well as applied.
+type ApplyMode string
+
+const (
+	// CommitAndApplyMode commits the hydrated manifests and applies them once they are merged into the dest branch.
+	CommitAndApplyMode ApplyMode = "commitAndApply"
+	// ApplyOnlyMode applies the hydrated manifests without committing them.
+	ApplyOnlyMode ApplyMode = "applyOnly"
+
+	// AppliedByLabel is the label on the resources applied to a cluster whose value is the name of the
+	// ManifestSync which applied them.
+	AppliedByLabel = "hydros.dev/applied-by"
+
+	// DefaultFieldManager is the default field manager of the server-side apply.
+	DefaultFieldManager = "hydros"
+)
+
+// ApplyOnly returns true if the hydrated manifests are applied instead of committed.
+func (a *ApplyConfig) ApplyOnly() bool {
+	return a != nil && a.Cluster != nil && a.Cluster.Mode == ApplyOnlyMode
+}
+
+// IsValid returns an error if the config is invalid.
+func (a *ApplyConfig) IsValid() error {
+	if a == nil {
+		return nil
+	}
+	if a.Cluster == nil {
+		return fmt.Errorf("cluster must be specified")
+	}
+	switch a.Cluster.Mode {
+	case "", CommitAndApplyMode, ApplyOnlyMode:
+	default:
+		return fmt.Errorf("cluster.mode %v is invalid; it must be %v or %v", a.Cluster.Mode, CommitAndApplyMode, ApplyOnlyMode)
+	}
+	return nil
+}
+
+// ArgoCDApplication configures the ArgoCD Application written to the dest repo. The Application syncs the hydrated
+// manifests in DestPath of the dest branch automatically.
+type ArgoCDApplication struct {
+	// Path is the file in the dest repo the Application is written to. It is relative to the root of the repo and
+	// can't be in DestPath. Defaults to argocd/<name>.yaml.
+	Path string `yaml:"path,omitempty"`
+	// Name is the name of the Application. Defaults to the name of the ManifestSync; when DestRepos is used the
+	// name of the destination is appended e.g. hydros-us-west-2.
+	Name string `yaml:"name,omitempty"`
+	// Namespace is the namespace of the Application i.e. the namespace ArgoCD runs in. Defaults to argocd.
+	Namespace string `yaml:"namespace,omitempty"`
+	// Project is the ArgoCD project of the Application. Defaults to default.
+	Project string `yaml:"project,omitempty"`
+	// Server is the URL of the cluster to deploy to. Defaults to https://kubernetes.default.svc i.e. the cluster
+	// ArgoCD runs in.
+	Server string `yaml:"server,omitempty"`
+	// DestinationNamespace is the namespace resources which don't specify one are deployed to.
+	DestinationNamespace string `yaml:"destinationNamespace,omitempty"`
+	// Prune deletes resources which are no longer in the hydrated manifests.
+	Prune bool `yaml:"prune,omitempty"`
+	// SelfHeal reverts changes made to the resources in the cluster.
+	SelfHeal bool `yaml:"selfHeal,omitempty"`
+}
+
+const (
+	// DefaultArgoCDNamespace is the default namespace of ArgoCD Applications.
+	DefaultArgoCDNamespace = "argocd"
+	// DefaultArgoCDProject is the default project of ArgoCD Applications.
+	DefaultArgoCDProject = "default"
+	// DefaultArgoCDServer is the default cluster ArgoCD Applications deploy to.
+	DefaultArgoCDServer = "https://kubernetes.default.svc"
+)
+
+// ApplicationName returns the name of the Application for the destination of the ManifestSync named sync.
+func (a *ArgoCDApplication) ApplicationName(sync string, d Destination) string {
+	name := a.Name
+	if name == "" {
+		name = sync
+	}
+	if d.Name != "" {
+		name = name + "-" + d.Name
+	}
+	return name
+}
+
+// ApplicationPath returns the path, relative to the root of the dest repo, of the Application for the destination
+// of the ManifestSync named sync.
+func (a *ArgoCDApplication) ApplicationPath(sync string, d Destination) string {
+	p := a.Path
+	if p == "" {
+		p = path.Join("argocd", a.ApplicationName(sync, d)+".yaml")
+	}
+	return path.Clean(RootRelative(p))
+}
+
+// isValid returns an error if the Application can't be written for the destinations of the ManifestSync.
+func (a *ArgoCDApplication) isValid(m *ManifestSync) error {
+	if a == nil {
+		return nil
+	}
+	if len(m.Spec.DestRepos) > 1 && a.Path != "" {
+		return fmt.Errorf("path can't be used with multiple destRepos; each destination needs its own Application")
+	}
+	for _, d := range m.Destinations() {
+		p := a.ApplicationPath(m.Metadata.Name, d)
+		if escapesRoot(p) {
+			return fmt.Errorf("path %v is invalid; it is relative to the root of the dest repo and can't be above it", a.Path)
+		}
+		destPath := path.Clean(RootRelative(d.DestPath))
+		if destPath == "." || strings.HasPrefix(p, destPath+"/") {
+			return fmt.Errorf("path %v is in destPath %v; the Application would deploy itself and be deleted when the manifests are hydrated", p, d.DestPath)
+		}
+	}
+	return nil
+}
+
+// DestLayout controls where kustomizations are hydrated in the dest path.
+type DestLayout struct {
+	// Type is the layout; stripOverlay, keepOverlay, flatten or template. Defaults to stripOverlay.
+	Type DestLayoutType `yaml:"type,omitempty"`
+	// Template is a Go template for the directory, relative to the dest path, a kustomization is hydrated into.
+	// It is required if Type is template. The variables are the path of the directory of the kustomization
+	// relative to the source path (.Path), that path without the overlay directory (.Dir) and the name of the
+	// overlay directory (.Overlay) e.g. "{{.Overlay}}/{{.Dir}}".
+	Template string `yaml:"template,omitempty"`
+}
+
+// GetType returns the layout type taking into account the default.
+func (l *DestLayout) GetType() DestLayoutType {
+	if l == nil || l.Type == "" {
+		return StripOverlayDestLayout
+	}
+	return l.Type
+}
+
+// IsValid returns an error if the layout is invalid.
+func (l *DestLayout) IsValid() error {
+	if l == nil {
+		return nil
+	}
+	switch l.GetType() {
+	case StripOverlayDestLayout, KeepOverlayDestLayout, FlattenDestLayout:
+		if l.Template != "" {
+			return fmt.Errorf("template can only be specified when type is %v", TemplateDestLayout)
+		}
+	case TemplateDestLayout:
+		if l.Template == "" {
+			return fmt.Errorf("template must be specified when type is %v", TemplateDestLayout)
+		}
+		if _, err := template.New("destLayout").Option("missingkey=error").Parse(l.Template); err != nil {
+			return errors.Wrapf(err, "Failed to parse template %v", l.Template)
+		}
+	default:
+		return fmt.Errorf("type %v is invalid; it must be one of %v, %v, %v or %v", l.Type, StripOverlayDestLayout, KeepOverlayDestLayout, FlattenDestLayout, TemplateDestLayout)
+	}
+	return nil
+}
+
+// FunctionKinds restricts which kinds of functions are allowed to run e.g. to disallow functions that call
+// external services when hydrating.
+type FunctionKinds struct {
+	// Allow is a list of the kinds that are allowed to run. If it is empty all kinds are allowed unless they are
+	// denied.
+	Allow []string `yaml:"allow,omitempty"`
+	// Deny is a list of kinds that aren't allowed to run. Deny takes precedence over Allow.
+	Deny []string `yaml:"deny,omitempty"`
+}
+
+// IsAllowed returns true if functions of the kind are allowed to run. A nil FunctionKinds allows all kinds.
+func (k *FunctionKinds) IsAllowed(kind string) bool {
+	if k == nil {
+		return true
+	}
+	for _, d := range k.Deny {
+		if d == kind {
+			return false
+		}
+	}
+	if len(k.Allow) == 0 {
+		return true
+	}
+	for _, a := range k.Allow {
+		if a == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// ExternalFunctions selects the external KRM functions e.g. from the kpt catalog that a resource runs. External
+// functions are function configs with the config.kubernetes.io/function annotation; they run as a container or an
+// executable. Since they can run arbitrary code a function is only run if it matches both ExternalFunctions and
+// the allowlist in the functions of the hydros config, which is owned by the operator rather than the repository.
+//
+// Entries are patterns matched with path.Match e.g. "gcr.io/kpt-fn/*" allows all images in gcr.io/kpt-fn.
+type ExternalFunctions struct {
+	// Images are patterns of the container images that are allowed to run. Images are matched including their
+	// tag or digest.
+	Images []string `yaml:"images,omitempty"`
+	// Execs are patterns of the paths of the executables that are allowed to run. Paths are matched as written in
+	// the function annotation.
+	Execs []string `yaml:"execs,omitempty"`
+	// Network allows container functions to request network access. The hydros config must also allow it.
+	Network bool `yaml:"network,omitempty"`
+}
+
+// IsImageAllowed returns true if functions using the container image are allowed to run. A nil ExternalFunctions
+// doesn't allow any images.
+func (e *ExternalFunctions) IsImageAllowed(image string) bool {
+	if e == nil {
+		return false
+	}
+	return matchesAny(e.Images, image)
+}
+
+// IsExecAllowed returns true if functions using the executable are allowed to run. A nil ExternalFunctions
+// doesn't allow any executables.
+func (e *ExternalFunctions) IsExecAllowed(p string) bool {
+	if e == nil {
+		return false
+	}
+	return matchesAny(e.Execs, p)
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if match, err := path.Match(p, value); err == nil && match {
+			return true
+		}
+	}
+	return false
+}
+
 // IsValid verifies this is a fully valid manifest
 func (m *ManifestSync) IsValid() error {
 	if m.Metadata.Name == "" {
 		return fmt.Errorf("ManifestSync must include a name")
 	}
 
-	if (m.Spec.MatchAnnotations == nil || len(m.Spec.MatchAnnotations) == 0) && m.Spec.Selector == nil {
-		return fmt.Errorf("ManifestSync.Spec must include matchAnnotations or Selector")
+	if v, ok := m.Metadata.Annotations[EmergencyAnnotation]; ok {
+		if _, err := strconv.ParseBool(strings.TrimSpace(v)); err != nil {
+			return fmt.Errorf("ManifestSync annotation %v has invalid value %v; it must be true or false", EmergencyAnnotation, v)
+		}
+	}
+	if m.IsEmergency() && strings.TrimSpace(m.Metadata.Annotations[EmergencyReasonAnnotation]) == "" {
+		return fmt.Errorf("ManifestSync annotation %v must be set to the reason for the emergency when %v is true", EmergencyReasonAnnotation, EmergencyAnnotation)
+	}
+	if m.IsEmergency() {
+		if _, err := m.EmergencyExpires(); err != nil {
+			return err
+		}
+	}
+
+	if len(m.Spec.Environments) > 0 {
+		return m.validateEnvironments()
+	}
+
+	switch m.Spec.SourceFormat {
+	case "", KustomizeSourceFormat:
+		if (m.Spec.MatchAnnotations == nil || len(m.Spec.MatchAnnotations) == 0) && m.Spec.Selector == nil {
+			return fmt.Errorf("ManifestSync.Spec must include matchAnnotations or Selector")
+		}
+	case YAMLSourceFormat:
+		if len(m.Spec.MatchAnnotations) > 0 {
+			return fmt.Errorf("ManifestSync.Spec.MatchAnnotations can't be used with sourceFormat %v; use selector instead", YAMLSourceFormat)
+		}
+	default:
+		return fmt.Errorf("ManifestSync.Spec.SourceFormat %v is invalid; it must be %v or %v", m.Spec.SourceFormat, KustomizeSourceFormat, YAMLSourceFormat)
 	}
 
 	if m.Spec.Selector != nil {
@@ -184,16 +1015,156 @@ func (m *ManifestSync) IsValid() error {
 		}
 	}
 
-	for key, r := range map[string]GitHubRepo{"ForkRepo": m.Spec.ForkRepo, "SourceRepo": m.Spec.SourceRepo, "DestRepo": m.Spec.DestRepo} {
-		if err := r.IsValid(); err != nil {
-			return errors.Wrapf(err, "ManifestSync has invalid %v", key)
+	if err := m.Spec.SourceRepo.IsValid(); err != nil {
+		return errors.Wrapf(err, "ManifestSync has invalid SourceRepo")
+	}
+
+	if len(m.Spec.DestRepos) > 0 {
+		if m.Spec.ForkRepo != (GitHubRepo{}) || m.Spec.DestRepo != (GitHubRepo{}) || m.Spec.DestPath != "" {
+			return fmt.Errorf("ManifestSync.Spec.DestRepos can't be used with ForkRepo, DestRepo or DestPath")
+		}
+	}
+
+	names := map[string]bool{}
+	forks := map[GitHubRepo]bool{}
+	for _, d := range m.Destinations() {
+		// Destinations can't share a fork branch because each destination force pushes to it.
+		if forks[d.ForkRepo] {
+			return fmt.Errorf("ManifestSync.Spec.DestRepos has multiple destinations using forkRepo %v/%v branch %v", d.ForkRepo.Org, d.ForkRepo.Repo, d.ForkRepo.Branch)
+		}
+		forks[d.ForkRepo] = true
+
+		if len(m.Spec.DestRepos) > 0 {
+			if d.Name == "" {
+				return fmt.Errorf("ManifestSync.Spec.DestRepos must specify a name for each destination")
+			}
+			if !validDestinationName.MatchString(d.Name) {
+				return fmt.Errorf("ManifestSync.Spec.DestRepos has invalid name %v; names must consist of alphanumeric characters, '-', '_' or '.'", d.Name)
+			}
+			if names[d.Name] {
+				return fmt.Errorf("ManifestSync.Spec.DestRepos has duplicate destination %v", d.Name)
+			}
+			names[d.Name] = true
+		}
+		for key, r := range map[string]GitHubRepo{"ForkRepo": d.ForkRepo, "DestRepo": d.DestRepo} {
+			if err := r.IsValid(); err != nil {
+				if d.Name != "" {
+					return errors.Wrapf(err, "ManifestSync destination %v has invalid %v", d.Name, key)
+				}
+				return errors.Wrapf(err, "ManifestSync has invalid %v", key)
+			}
+		}
+	}
+
+	if err := m.Spec.FileGuards.IsValid(); err != nil {
+		return errors.Wrapf(err, "ManifestSync.Spec.FileGuards is invalid")
+	}
+
+	if err := m.Spec.Policies.IsValid(); err != nil {
+		return errors.Wrapf(err, "ManifestSync.Spec.Policies is invalid")
+	}
+
+	if err := m.Spec.Apply.IsValid(); err != nil {
+		return errors.Wrapf(err, "ManifestSync.Spec.Apply is invalid")
+	}
+	if m.Spec.Apply != nil {
+		// N.B. Each destination would prune the resources applied by the others.
+		if len(m.Spec.DestRepos) > 0 {
+			return fmt.Errorf("ManifestSync.Spec.Apply can't be used with DestRepos")
+		}
+	}
+
+	if err := m.Spec.ArgoCD.isValid(m); err != nil {
+		return errors.Wrapf(err, "ManifestSync.Spec.ArgoCD is invalid")
+	}
+
+	if err := m.Spec.DestLayout.IsValid(); err != nil {
+		return errors.Wrapf(err, "ManifestSync.Spec.DestLayout is invalid")
+	}
+
+	switch m.Spec.VersionSkew {
+	case "", FailGuardAction, WarnGuardAction:
+	default:
+		return fmt.Errorf("ManifestSync.Spec.VersionSkew %v is invalid; it must be %v or %v", m.Spec.VersionSkew, FailGuardAction, WarnGuardAction)
+	}
+
+	switch m.Spec.Hydration {
+	case "", FullHydrationMode, PinOnlyHydrationMode:
+	default:
+		return fmt.Errorf("ManifestSync.Spec.Hydration %v is invalid; it must be %v or %v", m.Spec.Hydration, FullHydrationMode, PinOnlyHydrationMode)
+	}
+
+	if m.Spec.SyncPeriod != "" {
+		if d, err := time.ParseDuration(m.Spec.SyncPeriod); err != nil || d <= 0 {
+			return fmt.Errorf("ManifestSync.Spec.SyncPeriod %v is invalid; it must be a positive duration e.g. 30m", m.Spec.SyncPeriod)
 		}
 	}
 
+	if m.Spec.Merge != nil && m.Spec.Merge.Approvals < 0 {
+		return fmt.Errorf("ManifestSync.Spec.Merge.Approvals %v is invalid; it can't be negative", m.Spec.Merge.Approvals)
+	}
+
 	for _, s := range m.Spec.ImageTagsToPin {
 		if s.Strategy == "" {
 			return fmt.Errorf("ManifestSync.Spec.ImageTagsToPin must specify a strategy; %v", s)
 		}
+		if err := s.IsValid(); err != nil {
+			return errors.Wrapf(err, "ManifestSync.Spec.ImageTagsToPin is invalid")
+		}
+	}
+
+	if err := m.Spec.SourceCommitTag.IsValid(); err != nil {
+		return errors.Wrapf(err, "ManifestSync.Spec.SourceCommitTag is invalid")
+	}
+
+	if err := m.Spec.ImagePolicy.IsValid(); err != nil {
+		return errors.Wrapf(err, "ManifestSync.Spec.ImagePolicy is invalid")
+	}
+
+	if err := m.Spec.Notify.IsValid(); err != nil {
+		return errors.Wrapf(err, "ManifestSync.Spec.Notify is invalid")
+	}
+
+	if err := validateCallbacks(m.Spec.Callbacks); err != nil {
+		return errors.Wrapf(err, "ManifestSync.Spec is invalid")
+	}
+	return nil
+}
+
+// validateEnvironments checks that the environments are valid. Each environment is validated as the
+// ManifestSync it expands to.
+func (m *ManifestSync) validateEnvironments() error {
+	if len(m.Spec.DestRepos) > 0 || m.Spec.DestPath != "" {
+		return fmt.Errorf("ManifestSync.Spec.Environments can't be used with DestRepos or DestPath")
+	}
+
+	names := map[string]bool{}
+	paths := map[string]bool{}
+	for _, e := range m.Spec.Environments {
+		if e.Name == "" {
+			return fmt.Errorf("ManifestSync.Spec.Environments must specify a name for each environment")
+		}
+		if !validDestinationName.MatchString(e.Name) {
+			return fmt.Errorf("ManifestSync.Spec.Environments has invalid name %v; names must consist of alphanumeric characters, '-', '_' or '.'", e.Name)
+		}
+		if names[e.Name] {
+			return fmt.Errorf("ManifestSync.Spec.Environments has duplicate environment %v", e.Name)
+		}
+		names[e.Name] = true
+
+		if e.DestPath == "" {
+			return fmt.Errorf("ManifestSync environment %v must specify a destPath", e.Name)
+		}
+		// Each sync deletes and recreates its destPath so environments can't share one.
+		p := path.Clean(e.DestPath)
+		if paths[p] {
+			return fmt.Errorf("ManifestSync.Spec.Environments has multiple environments using destPath %v", e.DestPath)
+		}
+		paths[p] = true
+
+		if err := m.ForEnvironment(e).IsValid(); err != nil {
+			return errors.Wrapf(err, "ManifestSync environment %v is invalid", e.Name)
+		}
 	}
 	return nil
 }
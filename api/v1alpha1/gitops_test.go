@@ -117,3 +117,455 @@ spec:
 		}
 	}
 }
+
+func Test_SourceCommitTag(t *testing.T) {
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	type testCase struct {
+		name     string
+		input    *SourceCommitTag
+		expected string
+		abbrev   int
+	}
+
+	testCases := []testCase{
+		{
+			name:     "nil",
+			input:    nil,
+			expected: commit,
+			abbrev:   7,
+		},
+		{
+			name:     "abbrev",
+			input:    &SourceCommitTag{AbbrevLength: 12},
+			expected: "0123456789ab",
+			abbrev:   12,
+		},
+		{
+			name:     "template",
+			input:    &SourceCommitTag{AbbrevLength: 8, Template: "v1-{{.Commit}}"},
+			expected: "v1-01234567",
+			abbrev:   8,
+		},
+		{
+			name:     "full-commit",
+			input:    &SourceCommitTag{Template: "sha-{{.FullCommit}}"},
+			expected: "sha-" + commit,
+			abbrev:   7,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.input.IsValid(); err != nil {
+				t.Fatalf("Expected config to be valid; got error %v", err)
+			}
+			actual, err := c.input.Tag(commit)
+			if err != nil {
+				t.Fatalf("Failed to compute tag; %v", err)
+			}
+			if actual != c.expected {
+				t.Errorf("Got tag %v; want %v", actual, c.expected)
+			}
+			if a := c.input.GetAbbrevLength(); a != c.abbrev {
+				t.Errorf("Got abbrev length %v; want %v", a, c.abbrev)
+			}
+		})
+	}
+
+	for _, invalid := range []*SourceCommitTag{
+		{AbbrevLength: 2},
+		{AbbrevLength: 41},
+		{Template: "{{.Missing}}"},
+		{Template: "{{.Commit"},
+	} {
+		if err := invalid.IsValid(); err == nil {
+			t.Errorf("Expected %+v to be invalid", invalid)
+		}
+	}
+}
+
+func Test_ImagePolicyIsValid(t *testing.T) {
+	for _, valid := range []*ImagePolicy{
+		nil,
+		{},
+		{RequireSignature: true, Identities: []SignatureIdentity{{KeyRef: "cosign.pub"}}},
+		{RequireSignature: true, Identities: []SignatureIdentity{{Issuer: "https://accounts.google.com", Subject: "builder@acme.iam.gserviceaccount.com"}}},
+	} {
+		if err := valid.IsValid(); err != nil {
+			t.Errorf("Expected %+v to be valid; got %v", valid, err)
+		}
+	}
+
+	for _, invalid := range []*ImagePolicy{
+		{RequireSignature: true},
+		{RequireSignature: true, Identities: []SignatureIdentity{{Issuer: "https://accounts.google.com"}}},
+		{RequireSignature: true, Identities: []SignatureIdentity{{KeyRef: "cosign.pub", Subject: "builder@acme.iam.gserviceaccount.com"}}},
+	} {
+		if err := invalid.IsValid(); err == nil {
+			t.Errorf("Expected %+v to be invalid", invalid)
+		}
+	}
+}
+
+func Test_ImageTagToPinIsValid(t *testing.T) {
+	for _, valid := range []ImageTagToPin{
+		{Tags: []string{"latest"}, Strategy: MutableTagStrategy},
+		{Tags: []string{"1.25"}, Strategy: NewestMatchingTagStrategy, TagPattern: `^1\.25\.[0-9]+$`},
+		{Tags: []string{"1.x"}, Strategy: SemverStrategy, Constraint: ">=1.2.0 <2.0.0"},
+		{Tags: []string{"latest"}, Strategy: PromotedTagStrategy, PromotionTag: "prod"},
+	} {
+		if err := valid.IsValid(); err != nil {
+			t.Errorf("Expected %+v to be valid; got %v", valid, err)
+		}
+	}
+
+	for _, invalid := range []ImageTagToPin{
+		{Tags: []string{"1.25"}, Strategy: NewestMatchingTagStrategy},
+		{Tags: []string{"1.25"}, Strategy: NewestMatchingTagStrategy, TagPattern: `^1\.25\.(`},
+		{Tags: []string{"latest"}, Strategy: MutableTagStrategy, TagPattern: `^1\.25\.[0-9]+$`},
+		{Tags: []string{"1.x"}, Strategy: SemverStrategy},
+		{Tags: []string{"1.x"}, Strategy: SemverStrategy, Constraint: ">=one"},
+		{Tags: []string{"latest"}, Strategy: MutableTagStrategy, Constraint: ">=1.2.0"},
+		{Tags: []string{"latest"}, Strategy: PromotedTagStrategy},
+		{Tags: []string{"latest"}, Strategy: MutableTagStrategy, PromotionTag: "prod"},
+	} {
+		if err := invalid.IsValid(); err == nil {
+			t.Errorf("Expected %+v to be invalid", invalid)
+		}
+	}
+}
+
+func Test_ManifestSyncDestinations(t *testing.T) {
+	newManifest := func(dests ...Destination) *ManifestSync {
+		return &ManifestSync{
+			Metadata: Metadata{Name: "test"},
+			Spec: ManifestSyncSpec{
+				SourceRepo: GitHubRepo{Org: "acme", Repo: "src", Branch: "main"},
+				Selector:   &LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+				DestRepos:  dests,
+			},
+		}
+	}
+	dest := func(name string, branch string) Destination {
+		return Destination{
+			Name:     name,
+			ForkRepo: GitHubRepo{Org: "acme", Repo: "hydrated", Branch: branch},
+			DestRepo: GitHubRepo{Org: "acme", Repo: "hydrated", Branch: "main"},
+			DestPath: name,
+		}
+	}
+
+	valid := newManifest(dest("us-west-2", "hydros/us-west-2"), dest("eu-west-1", "hydros/eu-west-1"))
+	if err := valid.IsValid(); err != nil {
+		t.Errorf("Expected manifest to be valid; got %v", err)
+	}
+	if d := cmp.Diff(valid.Spec.DestRepos, valid.Destinations()); d != "" {
+		t.Errorf("Unexpected destinations; diff:\n%v", d)
+	}
+
+	legacy := newManifest()
+	legacy.Spec.ForkRepo = GitHubRepo{Org: "acme", Repo: "hydrated", Branch: "hydros/prod"}
+	legacy.Spec.DestRepo = GitHubRepo{Org: "acme", Repo: "hydrated", Branch: "main"}
+	legacy.Spec.DestPath = "prod"
+	expected := []Destination{{ForkRepo: legacy.Spec.ForkRepo, DestRepo: legacy.Spec.DestRepo, DestPath: "prod"}}
+	if d := cmp.Diff(expected, legacy.Destinations()); d != "" {
+		t.Errorf("Unexpected destinations; diff:\n%v", d)
+	}
+
+	both := newManifest(dest("us-west-2", "hydros/us-west-2"))
+	both.Spec.DestPath = "prod"
+
+	for name, invalid := range map[string]*ManifestSync{
+		"duplicate-name": newManifest(dest("us-west-2", "hydros/a"), dest("us-west-2", "hydros/b")),
+		"shared-fork":    newManifest(dest("us-west-2", "hydros/a"), dest("eu-west-1", "hydros/a")),
+		"missing-name":   newManifest(dest("", "hydros/a")),
+		"invalid-name":   newManifest(dest("us/west", "hydros/a")),
+		"missing-repo":   newManifest(Destination{Name: "us-west-2"}),
+		"both":           both,
+	} {
+		if err := invalid.IsValid(); err == nil {
+			t.Errorf("%v: expected manifest to be invalid", name)
+		}
+	}
+}
+
+func Test_ArgoCDApplication(t *testing.T) {
+	newManifest := func(app *ArgoCDApplication, dests ...Destination) *ManifestSync {
+		m := &ManifestSync{
+			Metadata: Metadata{Name: "hydros"},
+			Spec: ManifestSyncSpec{
+				SourceRepo: GitHubRepo{Org: "acme", Repo: "src", Branch: "main"},
+				Selector:   &LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+				DestRepos:  dests,
+				ArgoCD:     app,
+			},
+		}
+		if len(dests) == 0 {
+			m.Spec.ForkRepo = GitHubRepo{Org: "acme", Repo: "hydrated", Branch: "hydros/prod"}
+			m.Spec.DestRepo = GitHubRepo{Org: "acme", Repo: "hydrated", Branch: "main"}
+			m.Spec.DestPath = "/prod"
+		}
+		return m
+	}
+	dest := func(name string) Destination {
+		return Destination{
+			Name:     name,
+			ForkRepo: GitHubRepo{Org: "acme", Repo: "hydrated", Branch: "hydros/" + name},
+			DestRepo: GitHubRepo{Org: "acme", Repo: "hydrated", Branch: "main"},
+			DestPath: name,
+		}
+	}
+
+	m := newManifest(&ArgoCDApplication{})
+	if err := m.IsValid(); err != nil {
+		t.Errorf("Expected manifest to be valid; got %v", err)
+	}
+	d := m.Destinations()[0]
+	if p := m.Spec.ArgoCD.ApplicationPath(m.Metadata.Name, d); p != "argocd/hydros.yaml" {
+		t.Errorf("Unexpected path; got %v", p)
+	}
+
+	multi := newManifest(&ArgoCDApplication{Name: "app"}, dest("us-west-2"), dest("eu-west-1"))
+	if err := multi.IsValid(); err != nil {
+		t.Errorf("Expected manifest to be valid; got %v", err)
+	}
+	d = multi.Destinations()[1]
+	if n := multi.Spec.ArgoCD.ApplicationName(multi.Metadata.Name, d); n != "app-eu-west-1" {
+		t.Errorf("Unexpected name; got %v", n)
+	}
+	if p := multi.Spec.ArgoCD.ApplicationPath(multi.Metadata.Name, d); p != "argocd/app-eu-west-1.yaml" {
+		t.Errorf("Unexpected path; got %v", p)
+	}
+
+	for name, invalid := range map[string]*ManifestSync{
+		"in-dest-path":   newManifest(&ArgoCDApplication{Path: "/prod/argocd/app.yaml"}),
+		"above-root":     newManifest(&ArgoCDApplication{Path: "../app.yaml"}),
+		"path-and-dests": newManifest(&ArgoCDApplication{Path: "app.yaml"}, dest("us-west-2"), dest("eu-west-1")),
+	} {
+		if err := invalid.IsValid(); err == nil {
+			t.Errorf("%v: expected manifest to be invalid", name)
+		}
+	}
+}
+
+func Test_ManifestSyncEnvironments(t *testing.T) {
+	newManifest := func(envs ...Environment) *ManifestSync {
+		return &ManifestSync{
+			Metadata: Metadata{Name: "test"},
+			Spec: ManifestSyncSpec{
+				SourceRepo: GitHubRepo{Org: "acme", Repo: "src", Branch: "main"},
+				ForkRepo:   GitHubRepo{Org: "acme", Repo: "hydrated", Branch: "hydros/test"},
+				DestRepo:   GitHubRepo{Org: "acme", Repo: "hydrated", Branch: "main"},
+				Functions:  []Function{{RepoKey: "source", Paths: []string{"fns/common"}}},
+				ImageTagsToPin: []ImageTagToPin{
+					{Tags: []string{"latest"}, Strategy: MutableTagStrategy},
+				},
+				Environments: envs,
+			},
+		}
+	}
+	env := func(name string, destPath string) Environment {
+		return Environment{
+			Name:     name,
+			Selector: &LabelSelector{MatchLabels: map[string]string{"env": name}},
+			DestPath: destPath,
+		}
+	}
+
+	prod := env("prod", "prod")
+	prod.Functions = []Function{{RepoKey: "source", Paths: []string{"fns/prod"}}}
+	prod.ImageTagsToPin = []ImageTagToPin{{Tags: []string{"latest"}, Strategy: SemverStrategy, Constraint: "~1.2"}}
+
+	valid := newManifest(env("dev", "dev"), prod)
+	if err := valid.IsValid(); err != nil {
+		t.Errorf("Expected manifest to be valid; got %v", err)
+	}
+
+	actual := valid.ForEnvironment(prod)
+	expected := newManifest()
+	expected.Metadata.Name = "test-prod"
+	expected.Spec.Environments = nil
+	expected.Spec.ForkRepo.Branch = "hydros/test-prod"
+	expected.Spec.DestPath = "prod"
+	expected.Spec.Selector = prod.Selector
+	expected.Spec.ImageTagsToPin = prod.ImageTagsToPin
+	expected.Spec.Functions = []Function{
+		{RepoKey: "source", Paths: []string{"fns/common"}},
+		{RepoKey: "source", Paths: []string{"fns/prod"}},
+	}
+	if d := cmp.Diff(expected, actual); d != "" {
+		t.Errorf("Unexpected manifest for environment; diff:\n%v", d)
+	}
+
+	if d := cmp.Diff([]Function{{RepoKey: "source", Paths: []string{"fns/common"}}}, valid.Spec.Functions); d != "" {
+		t.Errorf("ForEnvironment modified the functions of the manifest; diff:\n%v", d)
+	}
+
+	withDestPath := newManifest(env("dev", "dev"))
+	withDestPath.Spec.DestPath = "all"
+
+	for name, invalid := range map[string]*ManifestSync{
+		"duplicate-name":     newManifest(env("dev", "a"), env("dev", "b")),
+		"duplicate-destPath": newManifest(env("dev", "a"), env("prod", "a/")),
+		"missing-name":       newManifest(env("", "a")),
+		"invalid-name":       newManifest(env("dev/a", "a")),
+		"missing-destPath":   newManifest(env("dev", "")),
+		"missing-selector":   newManifest(Environment{Name: "dev", DestPath: "dev"}),
+		"destPath":           withDestPath,
+	} {
+		if err := invalid.IsValid(); err == nil {
+			t.Errorf("%v: expected manifest to be invalid", name)
+		}
+	}
+}
+
+func Test_FunctionKindsIsAllowed(t *testing.T) {
+	type testCase struct {
+		name     string
+		kinds    *FunctionKinds
+		kind     string
+		expected bool
+	}
+
+	cases := []testCase{
+		{name: "nil", kinds: nil, kind: "PodEnvs", expected: true},
+		{name: "empty", kinds: &FunctionKinds{}, kind: "PodEnvs", expected: true},
+		{name: "allowed", kinds: &FunctionKinds{Allow: []string{"PodEnvs"}}, kind: "PodEnvs", expected: true},
+		{name: "not-allowed", kinds: &FunctionKinds{Allow: []string{"PodEnvs"}}, kind: "HydrosAI", expected: false},
+		{name: "denied", kinds: &FunctionKinds{Deny: []string{"HydrosAI"}}, kind: "HydrosAI", expected: false},
+		{name: "not-denied", kinds: &FunctionKinds{Deny: []string{"HydrosAI"}}, kind: "PodEnvs", expected: true},
+		{name: "deny-wins", kinds: &FunctionKinds{Allow: []string{"HydrosAI"}, Deny: []string{"HydrosAI"}}, kind: "HydrosAI", expected: false},
+	}
+
+	for _, c := range cases {
+		if actual := c.kinds.IsAllowed(c.kind); actual != c.expected {
+			t.Errorf("%v: IsAllowed(%v) = %v; want %v", c.name, c.kind, actual, c.expected)
+		}
+	}
+}
+
+func Test_ExternalFunctionsIsAllowed(t *testing.T) {
+	type testCase struct {
+		name     string
+		external *ExternalFunctions
+		image    string
+		exec     string
+		expected bool
+	}
+
+	allowlist := &ExternalFunctions{
+		Images: []string{"gcr.io/kpt-fn/*"},
+		Execs:  []string{"/usr/local/bin/kpt-fn-*"},
+	}
+	cases := []testCase{
+		{name: "nil-image", external: nil, image: "gcr.io/kpt-fn/set-labels:v0.1", expected: false},
+		{name: "nil-exec", external: nil, exec: "/usr/local/bin/kpt-fn-labels", expected: false},
+		{name: "allowed-image", external: allowlist, image: "gcr.io/kpt-fn/set-labels:v0.1", expected: true},
+		{name: "other-registry", external: allowlist, image: "gcr.io/kpt-fn-evil/set-labels:v0.1", expected: false},
+		{name: "nested-image", external: allowlist, image: "gcr.io/kpt-fn/contrib/set-labels:v0.1", expected: false},
+		{name: "allowed-exec", external: allowlist, exec: "/usr/local/bin/kpt-fn-labels", expected: true},
+		{name: "other-exec", external: allowlist, exec: "/tmp/kpt-fn-labels", expected: false},
+	}
+
+	for _, c := range cases {
+		var actual bool
+		if c.image != "" {
+			actual = c.external.IsImageAllowed(c.image)
+		} else {
+			actual = c.external.IsExecAllowed(c.exec)
+		}
+		if actual != c.expected {
+			t.Errorf("%v: got %v; want %v", c.name, actual, c.expected)
+		}
+	}
+}
+
+func Test_MergeConfigRequiredApprovals(t *testing.T) {
+	type testCase struct {
+		name     string
+		merge    *MergeConfig
+		expected int
+	}
+
+	cases := []testCase{
+		{name: "nil", merge: nil, expected: 0},
+		{name: "not-required", merge: &MergeConfig{Approvals: 2}, expected: 0},
+		{name: "default", merge: &MergeConfig{RequireApproval: true}, expected: 1},
+		{name: "configured", merge: &MergeConfig{RequireApproval: true, Approvals: 2}, expected: 2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if actual := c.merge.RequiredApprovals(); actual != c.expected {
+				t.Errorf("Got %v; want %v", actual, c.expected)
+			}
+		})
+	}
+}
+
+func Test_ManifestSyncGetSyncPeriod(t *testing.T) {
+	type testCase struct {
+		name     string
+		period   string
+		expected time.Duration
+	}
+
+	cases := []testCase{
+		{name: "default", period: "", expected: 5 * time.Minute},
+		{name: "override", period: "30m", expected: 30 * time.Minute},
+		{name: "invalid", period: "-1m", expected: 5 * time.Minute},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &ManifestSync{Spec: ManifestSyncSpec{SyncPeriod: c.period}}
+			if actual := m.GetSyncPeriod(5 * time.Minute); actual != c.expected {
+				t.Errorf("Got %v; want %v", actual, c.expected)
+			}
+		})
+	}
+}
+
+func Test_ManifestSyncEmergency(t *testing.T) {
+	type testCase struct {
+		name        string
+		annotations map[string]string
+		emergency   bool
+		valid       bool
+	}
+
+	cases := []testCase{
+		{name: "none", annotations: nil, emergency: false, valid: true},
+		{name: "emergency", annotations: map[string]string{EmergencyAnnotation: "true", EmergencyReasonAnnotation: "CVE-2023-1234", EmergencyExpiresAnnotation: "2023-06-01T17:00:00Z"}, emergency: true, valid: true},
+		{name: "not-emergency", annotations: map[string]string{EmergencyAnnotation: "false"}, emergency: false, valid: true},
+		{name: "missing-reason", annotations: map[string]string{EmergencyAnnotation: "true", EmergencyExpiresAnnotation: "2023-06-01T17:00:00Z"}, emergency: true, valid: false},
+		{name: "missing-expires", annotations: map[string]string{EmergencyAnnotation: "true", EmergencyReasonAnnotation: "CVE-2023-1234"}, emergency: true, valid: false},
+		{name: "invalid-expires", annotations: map[string]string{EmergencyAnnotation: "true", EmergencyReasonAnnotation: "CVE-2023-1234", EmergencyExpiresAnnotation: "tomorrow"}, emergency: true, valid: false},
+		{name: "invalid-value", annotations: map[string]string{EmergencyAnnotation: "yes", EmergencyReasonAnnotation: "CVE-2023-1234"}, emergency: false, valid: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &ManifestSync{
+				Metadata: Metadata{Name: "test", Annotations: c.annotations},
+				Spec: ManifestSyncSpec{
+					SourceRepo: GitHubRepo{Org: "acme", Repo: "src", Branch: "main"},
+					ForkRepo:   GitHubRepo{Org: "acme", Repo: "hydrated", Branch: "hydros/prod"},
+					DestRepo:   GitHubRepo{Org: "acme", Repo: "hydrated", Branch: "main"},
+					DestPath:   "prod",
+					Selector:   &LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+				},
+			}
+			if actual := m.IsEmergency(); actual != c.emergency {
+				t.Errorf("IsEmergency got %v; want %v", actual, c.emergency)
+			}
+			err := m.IsValid()
+			if c.valid && err != nil {
+				t.Errorf("Expected manifest to be valid; got %v", err)
+			}
+			if !c.valid && err == nil {
+				t.Errorf("Expected manifest to be invalid")
+			}
+		})
+	}
+}
@@ -2,8 +2,11 @@ package v1alpha1
 
 import (
 	"fmt"
+	"path"
 	"strings"
 
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
@@ -39,6 +42,35 @@ type ImageSpec struct {
 	// Source are the source for the image
 	Source  []*ImageSource   `yaml:"source,omitempty"`
 	Builder *ArtifactBuilder `yaml:"builder,omitempty"`
+
+	// Platforms is an optional list of platforms to build the image for e.g. linux/amd64, linux/arm64.
+	// If specified a multi-arch image is built using docker buildx and the tags point at a manifest list
+	// containing an image for each platform. If not specified the image is built with kaniko for the platform
+	// of the builder.
+	Platforms []string `yaml:"platforms,omitempty"`
+
+	// Attestations optionally configures attestations, e.g. an SBOM and SLSA provenance, that are generated
+	// for the image and attached to it with cosign after it is built.
+	Attestations *Attestations `yaml:"attestations,omitempty"`
+
+	// Signing optionally configures signing the image with cosign after it is built.
+	Signing *Signing `yaml:"signing,omitempty"`
+
+	// StrictMappings, if true, fails the build if any mapping of any source doesn't match any files. It is
+	// equivalent to setting strict on every source.
+	StrictMappings bool `yaml:"strictMappings,omitempty"`
+
+	// ReproducibleContext, if true, builds the context in reproducible mode; the timestamps and ownership of all
+	// entries, including entries copied from tarballs and images, are cleared and the entries are sorted by name.
+	// This way identical sources produce identical contexts.
+	ReproducibleContext bool `yaml:"reproducibleContext,omitempty"`
+
+	// Owners is a list of the teams or people who own the image e.g. GitHub teams or email addresses.
+	Owners []string `yaml:"owners,omitempty"`
+	// Notify specifies where to send notifications when building the image fails.
+	Notify *NotifyConfig `yaml:"notify,omitempty"`
+	// Callbacks are sent the result of each build of the image and of reconciles that fail.
+	Callbacks []Callback `yaml:"callbacks,omitempty"`
 }
 
 type ImageSource struct {
@@ -50,9 +82,20 @@ type ImageSource struct {
 	// Use file:// to specify a local path e.g. file:///path/to/dir. Note the third "/" indicates its an absolute path
 	// If its "//" then its a relative path. I'm not sure it makes sense to support relative paths because what
 	// would they be relative to?
+	//
+	// Use gs:// or s3:// to include objects stored in GCS or S3 e.g. gs://bucket/path/to/dir. The URI is treated
+	// as a directory and the mappings are matched against the names of the objects relative to the URI.
 	// TODO(jeremy): If the tag isn't specified we should look for the same tag at which the new image is being built
 	URI      string           `yaml:"uri,omitempty"`
 	Mappings []*SourceMapping `yaml:"mappings,omitempty"`
+
+	// DockerIgnore, if true, excludes any files matched by the .dockerignore file at the root of the source.
+	// This is only supported for local sources.
+	DockerIgnore bool `yaml:"dockerIgnore,omitempty"`
+
+	// Strict, if true, fails the build if any of the mappings doesn't match any files. Otherwise a mapping
+	// that matches nothing (e.g. because of a typo) only adds nothing to the tarball.
+	Strict bool `yaml:"strict,omitempty"`
 }
 
 // SourceMapping specifies how source files are mapped into the destination artifact
@@ -67,20 +110,112 @@ type ImageSource struct {
 // tarball
 type SourceMapping struct {
 	// Src is a glob pattern to match local paths against. Directories should be delimited by / on all platforms.
-	// e.g. "css/**/*.css"
+	// e.g. "css/**/*.css". Paths are relative to the root of the source; a leading / also refers to the root of
+	// the source (e.g. the directory, tarball or image) and not the root of the filesystem.
 	Src string `yaml:"src,omitempty"`
 	// Dest is the path to copy the files to in the artifact.
-	// e.g. "ghapp"
+	// e.g. "ghapp". It is relative to the root of the artifact; a leading / also refers to the root.
 	Dest string `yaml:"dest,omitempty"`
-	// Strip is the path prefix to strip from all paths
+	// Strip is the path prefix to strip from all paths. Like Src it is relative to the root of the source.
 	Strip string `yaml:"strip,omitempty"`
+	// Exclude is a list of glob patterns for files to exclude. Patterns are interpreted relative to the same
+	// directory as Src. A pattern matching a directory excludes everything in that directory.
+	// e.g. "**/testdata"
+	Exclude []string `yaml:"exclude,omitempty"`
+}
+
+// Normalized returns a copy of the mapping with Src, Dest, Strip and Exclude relative to the root; see
+// RootRelative.
+func (m *SourceMapping) Normalized() *SourceMapping {
+	n := *m
+	n.Src = RootRelative(m.Src)
+	n.Dest = RootRelative(m.Dest)
+	n.Strip = RootRelative(m.Strip)
+	if len(m.Exclude) > 0 {
+		n.Exclude = make([]string, len(m.Exclude))
+		for i, e := range m.Exclude {
+			n.Exclude[i] = RootRelative(e)
+		}
+	}
+	return &n
+}
+
+// RootRelative returns the path relative to the root of a source (e.g. the directory, tarball or image) or of
+// the build context. The paths of mappings and the Dockerfile are always relative to the root; a leading "/"
+// refers to the root and not the root of the filesystem e.g. "/app/kustomize" and "app/kustomize" are
+// equivalent.
+// https://github.com/jlewi/hydros/issues/69
+func RootRelative(p string) string {
+	return strings.TrimLeft(p, "/")
+}
+
+// escapesRoot returns true if the path, relative to the root, refers to a location above the root.
+func escapesRoot(p string) bool {
+	c := path.Clean(RootRelative(p))
+	return c == ".." || strings.HasPrefix(c, "../")
+}
+
+// Signing configures how images are signed with cosign.
+type Signing struct {
+	// KeyRef is the cosign key used to sign the image e.g.
+	// gcpkms://projects/<project>/locations/global/keyRings/<ring>/cryptoKeys/<key>
+	// The build's service account must be able to sign with the key.
+	KeyRef string `yaml:"keyRef,omitempty"`
+	// Keyless, if true, signs the image using keyless signing with the identity of the build's service account.
+	// Exactly one of KeyRef and Keyless must be specified.
+	Keyless bool `yaml:"keyless,omitempty"`
+}
+
+// Attestations configures the attestations generated for an image.
+type Attestations struct {
+	// SBOM, if true, generates an SPDX software bill of materials for the image with syft and attaches it as
+	// a cosign attestation.
+	SBOM bool `yaml:"sbom,omitempty"`
+	// Provenance, if true, generates SLSA provenance for the image and attaches it as a cosign attestation.
+	Provenance bool `yaml:"provenance,omitempty"`
+	// Key is the cosign key used to sign the attestations e.g.
+	// gcpkms://projects/<project>/locations/global/keyRings/<ring>/cryptoKeys/<key>
+	// Required if any attestations are enabled. The build's service account must be able to sign with the key.
+	Key string `yaml:"key,omitempty"`
+}
+
+// Enabled returns true if any attestations should be generated.
+func (a *Attestations) Enabled() bool {
+	return a != nil && (a.SBOM || a.Provenance)
 }
 
 type ArtifactBuilder struct {
 	// GCB is the configuration to build with GoogleCloud Build
 	GCB *GCBConfig `yaml:"gcb,omitempty"`
+
+	// BuildArgs are passed to the docker build as build args e.g. {"ENV": "prod"} so the same Dockerfile can
+	// produce different variants. DATE, COMMIT and VERSION are set by hydros and can't be overridden.
+	BuildArgs map[string]string `yaml:"buildArgs,omitempty"`
+
+	// Target is the stage of a multi-stage Dockerfile to build e.g. prod. Defaults to the last stage.
+	Target string `yaml:"target,omitempty"`
+
+	// MaxContextSize is the largest the build context can be before it is compressed e.g. "500Mi". If the context
+	// is larger the build fails before the context is uploaded; this catches accidentally including .git,
+	// node_modules or data files. Defaults to no limit.
+	MaxContextSize string `yaml:"maxContextSize,omitempty"`
+}
+
+// MaxContextSizeBytes returns the size in bytes of the largest build context or 0 if there is no limit.
+func (b *ArtifactBuilder) MaxContextSizeBytes() (int64, error) {
+	if b == nil || b.MaxContextSize == "" {
+		return 0, nil
+	}
+	q, err := resource.ParseQuantity(b.MaxContextSize)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to parse maxContextSize %v", b.MaxContextSize)
+	}
+	return q.Value(), nil
 }
 
+// ReservedBuildArgs are the build args hydros sets on every build.
+var ReservedBuildArgs = []string{"DATE", "COMMIT", "VERSION"}
+
 // GCBConfig is the configuration for building with GoogleCloud Build
 type GCBConfig struct {
 	// Project is the GCP project to use for building
@@ -90,9 +225,12 @@ type GCBConfig struct {
 	// e.g. 10m
 	Timeout string `yaml:"timeout,omitempty"`
 
+	// WaitTimeout is how long hydros should wait for the build to complete. It is a string understood by
+	// time.ParseDuration e.g. 1h. If the build hasn't completed by then it is cancelled. Defaults to 1h.
+	WaitTimeout string `yaml:"waitTimeout,omitempty"`
+
 	// Bucket where to store the build logs
-	Bucket string `yaml:"bucket,omitempty"
-`
+	Bucket string `yaml:"bucket,omitempty"`
 	// MachineType is optional. If specified its the machine type to use for building.
 	// Increasing VCPU can increase build times but also comes with a provisioning
 	// delay since they are only started on demand (they are also more expensive).
@@ -104,8 +242,73 @@ type GCBConfig struct {
 	MachineType string `yaml:"machineType,omitempty"`
 
 	// Dockerfile is the path to the Dockerfile to use for building the image
-	// This should be the path inside the context
+	// This should be the path inside the context. A leading / refers to the root of the context.
 	Dockerfile string `yaml:"dockerfile,omitempty"`
+
+	// TestCommand is optional. If specified its a command to run tests inside the build before the image is built
+	// e.g. ["go", "test", "./..."]. It runs as a separate step in the same workspace as the build context.
+	// If the tests fail the build fails and the image isn't pushed. The result is recorded in the status and, when
+	// the Image is reconciled from a RepoConfig e.g. by the server, in a check run on the source commit.
+	TestCommand []string `yaml:"testCommand,omitempty"`
+
+	// TestImage is the image in which to run TestCommand e.g. golang:1.21. Required if TestCommand is specified.
+	TestImage string `yaml:"testImage,omitempty"`
+
+	// Substitutions are user-defined substitutions for the build e.g. {"_REGION": "us-west1"}. Keys must start
+	// with an underscore. They can be referenced in BuilderArgs e.g. --build-arg=REGION=${_REGION}.
+	Substitutions map[string]string `yaml:"substitutions,omitempty"`
+
+	// SecretEnv are Secret Manager secrets to set as environment variables in the test step and the step that
+	// builds the image.
+	SecretEnv []SecretEnv `yaml:"secretEnv,omitempty"`
+
+	// WorkerPool is the private worker pool to run the build in e.g.
+	// projects/<project>/locations/<location>/workerPools/<pool>. The machine type is determined by the pool
+	// so MachineType can't be used with it.
+	WorkerPool string `yaml:"workerPool,omitempty"`
+
+	// ServiceAccount is the service account the build runs as e.g. builder@<project>.iam.gserviceaccount.com or
+	// projects/<project>/serviceAccounts/<account>. Defaults to the Cloud Build service account of Project.
+	ServiceAccount string `yaml:"serviceAccount,omitempty"`
+
+	// BuilderImage overrides the image of the step that builds the image e.g. to use a pinned version or a mirror
+	// of the builder. It must be compatible with the default builder; kaniko or, for multi-arch images or images
+	// using CacheFrom, docker.
+	BuilderImage string `yaml:"builderImage,omitempty"`
+
+	// BuilderArgs are additional arguments for the step that builds the image e.g. ["--cache-ttl=24h"].
+	BuilderArgs []string `yaml:"builderArgs,omitempty"`
+
+	// CacheFrom optionally uses the previously built image as a layer cache so layers which haven't changed
+	// aren't rebuilt. Kaniko can't use an image as a cache so images for a single platform are built with
+	// docker build instead of kaniko when CacheFrom is set.
+	CacheFrom *CacheFrom `yaml:"cacheFrom,omitempty"`
+}
+
+// DefaultCacheTag is the tag of the image used as the layer cache if CacheFrom doesn't specify one.
+const DefaultCacheTag = "latest"
+
+// CacheFrom configures the previously built image used as a layer cache.
+type CacheFrom struct {
+	// Tag is the tag of the image, i.e. Spec.Image, to use as the cache e.g. cache. The image is also pushed with
+	// this tag so the next build uses it. Defaults to latest.
+	Tag string `yaml:"tag,omitempty"`
+}
+
+// CacheTag returns the tag of the image to use as the cache.
+func (c *CacheFrom) CacheTag() string {
+	if c.Tag == "" {
+		return DefaultCacheTag
+	}
+	return c.Tag
+}
+
+// SecretEnv sets an environment variable in the build to the value of a Secret Manager secret.
+type SecretEnv struct {
+	// Env is the name of the environment variable e.g. NPM_TOKEN.
+	Env string `yaml:"env,omitempty"`
+	// Version is the version of the secret e.g. projects/<project>/secrets/<secret>/versions/latest.
+	Version string `yaml:"version,omitempty"`
 }
 
 type ImageStatus struct {
@@ -114,7 +317,51 @@ type ImageStatus struct {
 	// URI is the URI of the image
 	URI string `yaml:"uri,omitempty"`
 	// SHA is the SHA of the image
+	// For multi-arch images this is the digest of the manifest list.
 	SHA string `yaml:"sha,omitempty"`
+	// TestStatus is the status of the test step in the most recent build e.g. SUCCESS or FAILURE.
+	// It is empty if no tests were run.
+	TestStatus string `yaml:"testStatus,omitempty"`
+	// BuildLogsURL is the URL of the logs for the most recent build
+	BuildLogsURL string `yaml:"buildLogsURL,omitempty"`
+	// Signature is the reference of the cosign signature of the image if the image was signed
+	// e.g. us-west1-docker.pkg.dev/some-project/images/hydros:sha256-<digest>.sig
+	Signature string `yaml:"signature,omitempty"`
+	// Mappings is the number of files matched by each mapping of the sources when the build context was
+	// last created.
+	Mappings []MappingMatch `yaml:"mappings,omitempty"`
+	// Context is the size of the build context when it was last created.
+	Context *ContextStatus `yaml:"context,omitempty"`
+	// Conditions report whether the image is being built and the result of the latest reconcile.
+	Conditions []Condition `yaml:"conditions,omitempty"`
+}
+
+// ContextStatus is the size of a build context.
+type ContextStatus struct {
+	// Size is the total size in bytes of the files in the context before it is compressed.
+	Size int64 `yaml:"size"`
+	// NumFiles is the number of files in the context.
+	NumFiles int `yaml:"numFiles"`
+	// LargestFiles are the largest files in the context sorted by size; largest first.
+	LargestFiles []ContextFile `yaml:"largestFiles,omitempty"`
+}
+
+// ContextFile is a file in the build context.
+type ContextFile struct {
+	// Path is the path of the file in the context.
+	Path string `yaml:"path,omitempty"`
+	// Size is the size of the file in bytes.
+	Size int64 `yaml:"size"`
+}
+
+// MappingMatch is the number of files matched by a mapping of an image source.
+type MappingMatch struct {
+	// Source is the URI of the source.
+	Source string `yaml:"source,omitempty"`
+	// Src is the glob of the mapping.
+	Src string `yaml:"src,omitempty"`
+	// Matches is the number of files the mapping matched.
+	Matches int `yaml:"matches"`
 }
 
 // IsValid returns true if the config is valid.
@@ -133,14 +380,42 @@ func (c *Image) IsValid() (string, bool) {
 		if len(source.Mappings) == 0 {
 			errors = append(errors, fmt.Sprintf("Source[%d].Mappings must be specified", i))
 		}
+		for j, m := range source.Mappings {
+			if m == nil {
+				continue
+			}
+			if escapesRoot(m.Dest) {
+				errors = append(errors, fmt.Sprintf("Source[%d].Mappings[%d].Dest %v is invalid; dest is relative to the root of the build context, a leading / refers to that root and not the root of the filesystem, and it can't be above the root", i, j, m.Dest))
+			}
+		}
 	}
 
-	if c.Spec.Builder.GCB.Bucket == "" {
-		errors = append(errors, "Spec.Builder.GCB.Bucket must be specified")
+	if c.Spec.Builder == nil || c.Spec.Builder.GCB == nil {
+		errors = append(errors, "Spec.Builder.GCB must be specified")
+	} else {
+		errors = append(errors, c.Spec.Builder.problems()...)
 	}
 
-	if c.Spec.Builder.GCB.Project == "" {
-		errors = append(errors, "Spec.Builder.GCB.Project must be specified")
+	for _, p := range c.Spec.Platforms {
+		if pieces := strings.Split(p, "/"); len(pieces) < 2 || len(pieces) > 3 {
+			errors = append(errors, fmt.Sprintf("Spec.Platforms has invalid platform %v; platforms should be of the form os/arch[/variant] e.g. linux/arm64", p))
+		}
+	}
+
+	if c.Spec.Signing != nil && (c.Spec.Signing.KeyRef == "") == !c.Spec.Signing.Keyless {
+		errors = append(errors, "Exactly one of Spec.Signing.KeyRef and Spec.Signing.Keyless must be specified")
+	}
+
+	if c.Spec.Attestations.Enabled() && c.Spec.Attestations.Key == "" {
+		errors = append(errors, "Spec.Attestations.Key must be specified when attestations are enabled")
+	}
+
+	if err := c.Spec.Notify.IsValid(); err != nil {
+		errors = append(errors, fmt.Sprintf("Spec.Notify is invalid; %v", err))
+	}
+
+	if err := validateCallbacks(c.Spec.Callbacks); err != nil {
+		errors = append(errors, fmt.Sprintf("Spec is invalid; %v", err))
 	}
 
 	if len(errors) > 0 {
@@ -149,13 +424,65 @@ func (c *Image) IsValid() (string, bool) {
 	return "", true
 }
 
+// problems returns the validation errors of the builder. The GCB config must be non-nil.
+func (b *ArtifactBuilder) problems() []string {
+	problems := []string{}
+	if b.GCB.Bucket == "" {
+		problems = append(problems, "Spec.Builder.GCB.Bucket must be specified")
+	}
+
+	if b.GCB.Project == "" {
+		problems = append(problems, "Spec.Builder.GCB.Project must be specified")
+	}
+
+	if escapesRoot(b.GCB.Dockerfile) {
+		problems = append(problems, fmt.Sprintf("Spec.Builder.GCB.Dockerfile %v is invalid; the Dockerfile is a path in the build context relative to its root, a leading / refers to that root and not the root of the filesystem, and it can't be above the root", b.GCB.Dockerfile))
+	}
+
+	if len(b.GCB.TestCommand) > 0 && b.GCB.TestImage == "" {
+		problems = append(problems, "Spec.Builder.GCB.TestImage must be specified when Spec.Builder.GCB.TestCommand is specified")
+	}
+
+	for _, k := range ReservedBuildArgs {
+		if _, ok := b.BuildArgs[k]; ok {
+			problems = append(problems, fmt.Sprintf("Spec.Builder.BuildArgs can't set %v; it is set by hydros", k))
+		}
+	}
+
+	for k := range b.GCB.Substitutions {
+		if !strings.HasPrefix(k, "_") {
+			problems = append(problems, fmt.Sprintf("Spec.Builder.GCB.Substitutions has invalid key %v; user-defined substitutions must start with _", k))
+		}
+	}
+
+	for i, s := range b.GCB.SecretEnv {
+		if s.Env == "" || s.Version == "" {
+			problems = append(problems, fmt.Sprintf("Spec.Builder.GCB.SecretEnv[%d] must specify env and version", i))
+		}
+	}
+
+	if _, err := b.MaxContextSizeBytes(); err != nil {
+		problems = append(problems, fmt.Sprintf("Spec.Builder.MaxContextSize %v is invalid; it must be a quantity e.g. 500Mi", b.MaxContextSize))
+	}
+
+	if b.GCB.CacheFrom != nil && strings.ContainsAny(b.GCB.CacheFrom.Tag, ":/@") {
+		problems = append(problems, fmt.Sprintf("Spec.Builder.GCB.CacheFrom.Tag %v is invalid; it must be a tag of Spec.Image e.g. cache", b.GCB.CacheFrom.Tag))
+	}
+
+	if b.GCB.WorkerPool != "" && b.GCB.MachineType != "" {
+		problems = append(problems, "Spec.Builder.GCB.MachineType can't be used with Spec.Builder.GCB.WorkerPool; the machine type is set by the pool")
+	}
+	return problems
+}
+
 // ReplicatedImage replicates an image to one or more locations.
 // This is useful for using Artifact registry to build images and then copying them to GHCR.
 type ReplicatedImage struct {
-	APIVersion string              `yaml:"apiVersion" yamltags:"required"`
-	Kind       string              `yaml:"kind" yamltags:"required"`
-	Metadata   Metadata            `yaml:"metadata,omitempty"`
-	Spec       ReplicatedImageSpec `yaml:"spec,omitempty"`
+	APIVersion string                `yaml:"apiVersion" yamltags:"required"`
+	Kind       string                `yaml:"kind" yamltags:"required"`
+	Metadata   Metadata              `yaml:"metadata,omitempty"`
+	Spec       ReplicatedImageSpec   `yaml:"spec,omitempty"`
+	Status     ReplicatedImageStatus `yaml:"status,omitempty"`
 }
 
 type ReplicatedImageSpec struct {
@@ -173,3 +500,11 @@ type ReplicatedImageSource struct {
 	// TODO(jeremy): Should we add a selector or policy to determine which images to replicate?
 	// Default would always to be the latest
 }
+
+// ReplicatedImageStatus is the status of a ReplicatedImage.
+type ReplicatedImageStatus struct {
+	// SHA is the digest of the image that was last replicated.
+	SHA string `yaml:"sha,omitempty"`
+	// Conditions report whether the image was replicated to all the destinations.
+	Conditions []Condition `yaml:"conditions,omitempty"`
+}
@@ -0,0 +1,79 @@
+package v1alpha1
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	ImagePromotionGVK = schema.FromAPIVersionAndKind(Group+"/"+Version, "ImagePromotion")
+)
+
+// ImagePromotion promotes an image that was already built to one or more environments e.g. staging and prod.
+// The image is identified by its digest and is tagged with the tags of each environment and copied to the
+// repositories of each environment without rebuilding it. A ManifestSync can pin images to the tags of an
+// environment using the PromotedTagStrategy.
+type ImagePromotion struct {
+	APIVersion string             `yaml:"apiVersion" yamltags:"required"`
+	Kind       string             `yaml:"kind" yamltags:"required"`
+	Metadata   Metadata           `yaml:"metadata,omitempty"`
+	Spec       ImagePromotionSpec `yaml:"spec,omitempty"`
+}
+
+type ImagePromotionSpec struct {
+	// Image is the image to promote including its digest
+	// e.g. us-west1-docker.pkg.dev/some-project/images/hydros@sha256:1234
+	Image string `yaml:"image,omitempty"`
+	// Environments are the environments to promote the image to.
+	Environments []PromotionEnvironment `yaml:"environments,omitempty"`
+}
+
+// PromotionEnvironment is an environment an image is promoted to.
+type PromotionEnvironment struct {
+	// Name uniquely identifies the environment e.g. prod.
+	Name string `yaml:"name,omitempty"`
+	// Tags are the tags applied to the image in the environment. Defaults to the name of the environment.
+	Tags []string `yaml:"tags,omitempty"`
+	// Repositories are the repositories of the environment the image is copied to
+	// e.g. us-west1-docker.pkg.dev/prod-project/images/hydros. Defaults to the repository of the image.
+	Repositories []string `yaml:"repositories,omitempty"`
+}
+
+// GetTags returns the tags applied to the image in the environment.
+func (e PromotionEnvironment) GetTags() []string {
+	if len(e.Tags) == 0 {
+		return []string{e.Name}
+	}
+	return e.Tags
+}
+
+// IsValid returns an error if the ImagePromotion isn't valid.
+func (p *ImagePromotion) IsValid() error {
+	if p.Spec.Image == "" {
+		return errors.New("ImagePromotion.Spec.Image is required")
+	}
+	if !strings.Contains(p.Spec.Image, "@sha256:") {
+		return errors.Errorf("ImagePromotion.Spec.Image %v must include the digest of the image e.g. @sha256:1234", p.Spec.Image)
+	}
+	if len(p.Spec.Environments) == 0 {
+		return errors.New("ImagePromotion.Spec.Environments must include at least one environment")
+	}
+	names := map[string]bool{}
+	for _, e := range p.Spec.Environments {
+		if e.Name == "" {
+			return errors.New("ImagePromotion.Spec.Environments[].Name is required")
+		}
+		if names[e.Name] {
+			return errors.Errorf("ImagePromotion.Spec.Environments has more than one environment named %v", e.Name)
+		}
+		names[e.Name] = true
+		for _, r := range e.Repositories {
+			if strings.Contains(r, "@") || strings.Contains(r[strings.LastIndex(r, "/")+1:], ":") {
+				return errors.Errorf("Repository %v of environment %v must not include a tag or digest", r, e.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package v1alpha1
 
 import (
+	"net/url"
 	"strings"
 	"time"
 )
@@ -16,10 +17,19 @@ var (
 // RepoConfig specifies a repository that should be checked out and periodically sync'd.
 // TODO(jeremy): RepoConfig is a terrible name.
 type RepoConfig struct {
-	APIVersion string   `yaml:"apiVersion"`
-	Kind       string   `yaml:"kind"`
-	Metadata   Metadata `yaml:"metadata"`
-	Spec       RepoSpec `yaml:"spec"`
+	APIVersion string           `yaml:"apiVersion"`
+	Kind       string           `yaml:"kind"`
+	Metadata   Metadata         `yaml:"metadata"`
+	Spec       RepoSpec         `yaml:"spec"`
+	Status     RepoConfigStatus `yaml:"status,omitempty"`
+}
+
+// RepoConfigStatus is the status of a RepoConfig.
+type RepoConfigStatus struct {
+	// Commit is the commit of the repository that was last reconciled.
+	Commit string `yaml:"commit,omitempty"`
+	// Conditions report whether all the resources of the repository were reconciled successfully.
+	Conditions []Condition `yaml:"conditions,omitempty"`
 }
 
 // RepoSpec is the spec for a repository to synchronize
@@ -44,6 +54,23 @@ type RepoSpec struct {
 	// This is used to rewrite the sourceRepositories in ManifestSync resources in order to hydrate from a
 	// branch.
 	RepoMappings []RepoMapping `yaml:"repoMappings,omitempty"`
+
+	// SyncPeriod overrides how often the repository is reconciled when it is applied periodically e.g. "30m".
+	// Defaults to the period hydros is run with.
+	SyncPeriod string `yaml:"syncPeriod,omitempty"`
+
+	// Status configures where the results of reconciling the resources are reported.
+	Status *RepoStatusConfig `yaml:"status,omitempty"`
+}
+
+// RepoStatusConfig configures where the results of reconciling the resources of a RepoConfig are reported.
+type RepoStatusConfig struct {
+	// URI is the local file or GCS object the result of the latest reconcile of each resource is written to
+	// e.g. /var/lib/hydros/status.yaml or gs://bucket/hydros/status.yaml. Use hydros status to display it.
+	URI string `yaml:"uri,omitempty"`
+	// CommitStatus reports the result of reconciling each resource as a GitHub commit status on the commit of the
+	// repository that was reconciled. The context of each status is hydros/<kind>/<name>.
+	CommitStatus bool `yaml:"commitStatus,omitempty"`
 }
 
 // RepoMapping is a mapping from a repository to a directory
@@ -83,8 +110,38 @@ func (c *RepoConfig) IsValid() (string, bool) {
 		}
 	}
 
+	if c.Spec.SyncPeriod != "" {
+		if d, err := time.ParseDuration(c.Spec.SyncPeriod); err != nil || d <= 0 {
+			errors = append(errors, "SyncPeriod must be a positive duration")
+		}
+	}
+
+	if c.Spec.Status != nil && c.Spec.Status.URI != "" {
+		if u, err := url.Parse(c.Spec.Status.URI); err != nil || (u.Scheme != "" && u.Scheme != "file" && u.Scheme != "gs") {
+			errors = append(errors, "Status.URI must be a local file or a GCS object")
+		}
+	}
+
 	if len(errors) > 0 {
 		return "RepoConfig is invalid. " + strings.Join(errors, ". "), false
 	}
 	return "", true
 }
+
+// GetSyncPeriod returns how often the repository should be reconciled. defaultPeriod is returned if SyncPeriod
+// isn't set or isn't valid.
+func (c *RepoConfig) GetSyncPeriod(defaultPeriod time.Duration) time.Duration {
+	return parseSyncPeriod(c.Spec.SyncPeriod, defaultPeriod)
+}
+
+// parseSyncPeriod parses a sync period returning defaultPeriod if period is empty or invalid.
+func parseSyncPeriod(period string, defaultPeriod time.Duration) time.Duration {
+	if period == "" {
+		return defaultPeriod
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return defaultPeriod
+	}
+	return d
+}
@@ -1,5 +1,10 @@
 package v1alpha1
 
+import (
+	"fmt"
+	"strings"
+)
+
 const (
 	// Group for MLP tasks.
 	Group = "hydros.dev"
@@ -17,6 +22,61 @@ type Metadata struct {
 	// Ref: https://github.com/kubernetes/community/blob/master/contributors/devel/sig-architecture/api-conventions.md#metadata
 	// This should be treated as an opaque value by clients.
 	ResourceVersion string `yaml:"resourceVersion,omitempty"`
+	// DependsOn lists resources that must be applied successfully before this one e.g. the Image a ManifestSync
+	// pins. It is only used when the resources are applied by a RepoConfig; the resources must be selected by the
+	// same RepoConfig.
+	DependsOn []ResourceRef `yaml:"dependsOn,omitempty"`
+}
+
+// ResourceRef refers to another hydros resource by kind and name.
+type ResourceRef struct {
+	Kind string `yaml:"kind,omitempty"`
+	Name string `yaml:"name,omitempty"`
+}
+
+// String returns the reference as kind/name.
+func (r ResourceRef) String() string {
+	return r.Kind + "/" + r.Name
+}
+
+// NotifyConfig specifies where notifications about a resource should be sent e.g. when reconciling it fails.
+type NotifyConfig struct {
+	// SlackChannels is a list of Slack channels to notify e.g. "#team-alerts"
+	SlackChannels []string `json:"slackChannels,omitempty" yaml:"slackChannels,omitempty"`
+	// Emails is a list of email addresses to notify
+	Emails []string `json:"emails,omitempty" yaml:"emails,omitempty"`
+}
+
+// IsValid returns an error if the config is invalid.
+func (n *NotifyConfig) IsValid() error {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.SlackChannels {
+		if !strings.HasPrefix(c, "#") {
+			return fmt.Errorf("Slack channel %v is invalid; channels should start with #", c)
+		}
+	}
+	for _, e := range n.Emails {
+		if !strings.Contains(e, "@") {
+			return fmt.Errorf("Email %v is invalid", e)
+		}
+	}
+	return nil
+}
+
+// LogValues returns key value pairs identifying the owners and notification targets of a resource.
+// These are attached to the logger for the resource so that failures and audit records can be routed
+// to the owning team e.g. using log based alerts.
+func LogValues(owners []string, notify *NotifyConfig) []interface{} {
+	values := make([]interface{}, 0, 4)
+	if len(owners) > 0 {
+		values = append(values, "owners", owners)
+	}
+	if notify != nil {
+		values = append(values, "notify", notify)
+	}
+	return values
 }
 
 // TODO: This should be a k8s.io/apimachinery/pkg/runtime/schema.GroupVersionKind
@@ -0,0 +1,202 @@
+package v1alpha1
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_NotifyConfigIsValid(t *testing.T) {
+	type testCase struct {
+		name    string
+		input   *NotifyConfig
+		isValid bool
+	}
+
+	testCases := []testCase{
+		{
+			name:    "nil",
+			input:   nil,
+			isValid: true,
+		},
+		{
+			name: "valid",
+			input: &NotifyConfig{
+				SlackChannels: []string{"#team-alerts"},
+				Emails:        []string{"team@acme.com"},
+			},
+			isValid: true,
+		},
+		{
+			name: "bad-channel",
+			input: &NotifyConfig{
+				SlackChannels: []string{"team-alerts"},
+			},
+			isValid: false,
+		},
+		{
+			name: "bad-email",
+			input: &NotifyConfig{
+				Emails: []string{"team"},
+			},
+			isValid: false,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.input.IsValid()
+			if c.isValid && err != nil {
+				t.Errorf("Expected config to be valid; got error %v", err)
+			}
+			if !c.isValid && err == nil {
+				t.Errorf("Expected config to be invalid")
+			}
+		})
+	}
+}
+
+func Test_ImageIsValidPaths(t *testing.T) {
+	type testCase struct {
+		name       string
+		dest       string
+		dockerfile string
+		isValid    bool
+	}
+
+	testCases := []testCase{
+		{name: "relative", dest: "static", dockerfile: "app/Dockerfile", isValid: true},
+		{name: "leading-slash", dest: "/static", dockerfile: "/app/Dockerfile", isValid: true},
+		{name: "dest-above-root", dest: "/../static", isValid: false},
+		{name: "dockerfile-above-root", dockerfile: "app/../../Dockerfile", isValid: false},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			image := &Image{}
+			image.Spec.Image = "us-west1-docker.pkg.dev/acme/images/app"
+			image.Spec.Builder = &ArtifactBuilder{
+				GCB: &GCBConfig{Bucket: "builds", Project: "acme", Dockerfile: c.dockerfile},
+			}
+			image.Spec.Source = []*ImageSource{
+				{
+					URI:      "file:///src",
+					Mappings: []*SourceMapping{{Src: "**/*", Dest: c.dest}},
+				},
+			}
+			msg, valid := image.IsValid()
+			if valid != c.isValid {
+				t.Errorf("IsValid() = %v; want %v; message: %v", valid, c.isValid, msg)
+			}
+		})
+	}
+}
+
+func Test_SourceMappingNormalized(t *testing.T) {
+	m := &SourceMapping{Src: "/app/**", Dest: "/static", Strip: "/app", Exclude: []string{"/app/testdata"}}
+	n := m.Normalized()
+	if n.Src != "app/**" || n.Dest != "static" || n.Strip != "app" || n.Exclude[0] != "app/testdata" {
+		t.Errorf("Normalized() = %+v; want paths without a leading /", n)
+	}
+	if m.Src != "/app/**" || m.Exclude[0] != "/app/testdata" {
+		t.Errorf("Normalized() modified the original mapping; got %+v", m)
+	}
+}
+
+func Test_ImagePromotionIsValid(t *testing.T) {
+	newPromotion := func(image string, envs ...PromotionEnvironment) *ImagePromotion {
+		return &ImagePromotion{Spec: ImagePromotionSpec{Image: image, Environments: envs}}
+	}
+	image := "us-west1-docker.pkg.dev/acme/images/web@sha256:1234"
+
+	valid := newPromotion(image, PromotionEnvironment{Name: "staging"}, PromotionEnvironment{Name: "prod", Repositories: []string{"localhost:5000/prod/web"}})
+	if err := valid.IsValid(); err != nil {
+		t.Errorf("Expected promotion to be valid; got %v", err)
+	}
+	if tags := valid.Spec.Environments[0].GetTags(); len(tags) != 1 || tags[0] != "staging" {
+		t.Errorf("Expected the tags to default to the name of the environment; got %v", tags)
+	}
+
+	for name, invalid := range map[string]*ImagePromotion{
+		"no-digest":       newPromotion("us-west1-docker.pkg.dev/acme/images/web:latest", PromotionEnvironment{Name: "prod"}),
+		"no-environments": newPromotion(image),
+		"no-name":         newPromotion(image, PromotionEnvironment{}),
+		"duplicate-name":  newPromotion(image, PromotionEnvironment{Name: "prod"}, PromotionEnvironment{Name: "prod"}),
+		"tagged-repo":     newPromotion(image, PromotionEnvironment{Name: "prod", Repositories: []string{"localhost:5000/prod/web:prod"}}),
+	} {
+		if err := invalid.IsValid(); err == nil {
+			t.Errorf("%v: expected promotion to be invalid", name)
+		}
+	}
+}
+
+func Test_SetCondition(t *testing.T) {
+	first := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	conditions := []Condition{{Type: ReadyCondition, Status: ConditionTrue, Reason: ReconcileSucceededReason, LastTransitionTime: first}}
+
+	// The status didn't change so the transition time should be kept.
+	conditions = SetCondition(conditions, NewCondition(ReadyCondition, ConditionTrue, ReconcileSucceededReason, "second run"))
+	if c := GetCondition(conditions, ReadyCondition); c == nil || !c.LastTransitionTime.Equal(first) || c.Message != "second run" {
+		t.Errorf("Expected the condition to be updated keeping its transition time; got %+v", c)
+	}
+
+	conditions = SetCondition(conditions, NewCondition(ReadyCondition, ConditionFalse, ReconcileFailedReason, "failed"))
+	if c := GetCondition(conditions, ReadyCondition); c == nil || c.LastTransitionTime.Equal(first) || c.Status != ConditionFalse {
+		t.Errorf("Expected the transition time to be updated when the status changed; got %+v", c)
+	}
+
+	conditions = SetCondition(conditions, NewCondition(BlockedCondition, ConditionTrue, "AwaitingApproval", ""))
+	if len(conditions) != 2 || GetCondition(conditions, BlockedCondition) == nil {
+		t.Errorf("Expected the Blocked condition to be added; got %+v", conditions)
+	}
+	if c := GetCondition(conditions, SyncedCondition); c != nil {
+		t.Errorf("Expected no Synced condition; got %+v", c)
+	}
+}
+
+func Test_MergeConditions(t *testing.T) {
+	first := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	previous := []Condition{
+		{Type: ReadyCondition, Status: ConditionTrue, LastTransitionTime: first},
+		{Type: SyncedCondition, Status: ConditionTrue, LastTransitionTime: first},
+		{Type: BuildingCondition, Status: ConditionFalse, LastTransitionTime: first},
+	}
+	current := []Condition{
+		{Type: ReadyCondition, Status: ConditionTrue, Message: "latest", LastTransitionTime: second},
+		{Type: SyncedCondition, Status: ConditionFalse, LastTransitionTime: second},
+	}
+
+	merged := MergeConditions(previous, current)
+	expected := []Condition{
+		{Type: ReadyCondition, Status: ConditionTrue, Message: "latest", LastTransitionTime: first},
+		{Type: SyncedCondition, Status: ConditionFalse, LastTransitionTime: second},
+	}
+	if !reflect.DeepEqual(expected, merged) {
+		t.Errorf("Unexpected conditions; got %+v; want %+v", merged, expected)
+	}
+	if !previous[0].LastTransitionTime.Equal(first) || previous[0].Message != "" {
+		t.Errorf("MergeConditions modified the previous conditions; got %+v", previous[0])
+	}
+}
+
+func Test_CallbackIsValid(t *testing.T) {
+	for _, valid := range []Callback{
+		{URL: "https://ci.acme.com/hooks/hydros"},
+		{URL: "http://localhost:8080/callback", Secret: "gcpSecretManager:///projects/acme/secrets/callback/versions/latest"},
+	} {
+		if err := valid.IsValid(); err != nil {
+			t.Errorf("Expected callback %+v to be valid; got %v", valid, err)
+		}
+	}
+	for _, invalid := range []Callback{
+		{},
+		{URL: "ci.acme.com/hooks/hydros"},
+		{URL: "ftp://ci.acme.com/hooks/hydros"},
+		{URL: "https:///hooks/hydros"},
+	} {
+		if err := invalid.IsValid(); err == nil {
+			t.Errorf("Expected callback %+v to be invalid", invalid)
+		}
+	}
+}
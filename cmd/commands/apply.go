@@ -13,6 +13,12 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	selectorFlag = "selector"
+	selectorHelp = "Only apply hydros resources whose labels match the selector e.g. team=payments. Resources found via a RepoConfig must match both the RepoConfig's selectors and this selector."
 )
 
 type applyOptions struct {
@@ -21,6 +27,11 @@ type applyOptions struct {
 	githubAppID int
 	period      time.Duration
 	force       bool
+	// allowExisting allows the work directory to be an existing directory that wasn't created by hydros.
+	allowExisting bool
+	selector      string
+	// emergencyReason is the reason for an emergency sync; emergency syncs bypass pauses.
+	emergencyReason string
 }
 
 // NewApplyCmd create an apply command
@@ -33,7 +44,27 @@ func NewApplyCmd() *cobra.Command {
 		Short: "Apply the specified resource.",
 		Run: func(cmd *cobra.Command, args []string) {
 			err := func() error {
+				// N.B. Resolve the flags before loading the config since the config is bound to the flags.
+				if err := resolvePaths(&aOptions.workDir, &aOptions.secret); err != nil {
+					return err
+				}
+				paths := make([]string, len(args))
+				for i, a := range args {
+					p, err := resolvePath(a)
+					if err != nil {
+						return err
+					}
+					paths[i] = p
+				}
+				selector, err := parseSelector(aOptions.selector)
+				if err != nil {
+					return err
+				}
 				app := app.NewApp()
+				app.AllowExistingWorkDir = aOptions.allowExisting
+				app.HydrosVersion = version
+				app.Selector = selector
+				app.EmergencyReason = aOptions.emergencyReason
 				defer app.Shutdown()
 				if err := app.LoadConfig(cmd); err != nil {
 					return err
@@ -41,18 +72,22 @@ func NewApplyCmd() *cobra.Command {
 				if err := app.SetupLogging(); err != nil {
 					return err
 				}
+				if err := app.SetupHTTP(); err != nil {
+					return err
+				}
 				log := zapr.NewLogger(zap.L())
 				if len(args) == 0 {
 					log.Info("apply takes at least one argument which should be the file or directory YAML to apply.")
 					return errors.New("apply takes at least one argument which should be the file or directory YAML to apply.")
 				}
 				logVersion()
+				checkVersion(app.Config)
 
 				if err := app.SetupRegistry(); err != nil {
 					return err
 				}
 
-				return app.ApplyPaths(context.Background(), args, aOptions.period, aOptions.force)
+				return app.ApplyPaths(context.Background(), paths, aOptions.period, aOptions.force)
 			}()
 			if err != nil {
 				fmt.Printf("Error running apply;\n %+v\n", err)
@@ -66,6 +101,21 @@ func NewApplyCmd() *cobra.Command {
 	applyCmd.Flags().IntVarP(&aOptions.githubAppID, config.AppIDFlagName, "", 0, "GitHubAppId.")
 	applyCmd.Flags().DurationVarP(&aOptions.period, "period", "p", 0*time.Minute, "The period with which to reapply. If zero run once and exit.")
 	applyCmd.Flags().BoolVarP(&aOptions.force, "force", "", false, "Force a sync even if one isn't needed.")
+	applyCmd.Flags().BoolVarP(&aOptions.allowExisting, allowExistingFlag, "", false, allowExistingHelp)
+	applyCmd.Flags().StringVarP(&aOptions.selector, selectorFlag, "l", "", selectorHelp)
+	applyCmd.Flags().StringVarP(&aOptions.emergencyReason, "emergency-reason", "", "", "Run an emergency sync that bypasses pauses e.g. to roll out an urgent security fix. The value is the reason for the emergency; it is logged and recorded in the PRs.")
 
 	return applyCmd
 }
+
+// parseSelector parses the value of the selector flag. It returns nil if the flag isn't set.
+func parseSelector(s string) (labels.Selector, error) {
+	if s == "" {
+		return nil, nil
+	}
+	selector, err := labels.Parse(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid --%v %v", selectorFlag, s)
+	}
+	return selector, nil
+}
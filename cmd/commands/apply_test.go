@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func Test_parseSelector(t *testing.T) {
+	type testCase struct {
+		name    string
+		input   string
+		labels  labels.Set
+		matches bool
+	}
+
+	cases := []testCase{
+		{name: "match", input: "team=payments", labels: labels.Set{"team": "payments", "env": "prod"}, matches: true},
+		{name: "no-match", input: "team=payments", labels: labels.Set{"team": "search"}, matches: false},
+		{name: "multiple", input: "team=payments,env!=prod", labels: labels.Set{"team": "payments", "env": "prod"}, matches: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			selector, err := parseSelector(c.input)
+			if err != nil {
+				t.Fatalf("parseSelector failed; %v", err)
+			}
+			if actual := selector.Matches(c.labels); actual != c.matches {
+				t.Errorf("Matches(%v) = %v; want %v", c.labels, actual, c.matches)
+			}
+		})
+	}
+
+	if selector, err := parseSelector(""); err != nil || selector != nil {
+		t.Errorf("Expected no selector when the flag isn't set; got %v, %v", selector, err)
+	}
+	if _, err := parseSelector("team in (payments"); err == nil {
+		t.Errorf("Expected an error for an invalid selector")
+	}
+}
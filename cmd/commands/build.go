@@ -1,17 +1,26 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 
 	"github.com/jlewi/hydros/pkg/app"
 
 	"github.com/jlewi/hydros/pkg/images"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
 type BuildArgs struct {
 	File string
+	// Output is the directory to write the build contexts to instead of building the images.
+	Output string
+	// List writes the list of entries in each build context along with it.
+	List bool
+	// FollowLogs copies the logs of the builds into the output.
+	FollowLogs bool
 }
 
 func NewBuildCmd() *cobra.Command {
@@ -29,8 +38,29 @@ func NewBuildCmd() *cobra.Command {
 				if err := app.SetupLogging(); err != nil {
 					return err
 				}
+				if err := app.SetupHTTP(); err != nil {
+					return err
+				}
 				logVersion()
-				return images.ReconcileFile(opts.File)
+				checkVersion(app.Config)
+				if err := resolvePaths(&opts.File, &opts.Output); err != nil {
+					return err
+				}
+				if opts.List && opts.Output == "" {
+					return errors.New("--list can only be used with --output")
+				}
+				// Cancel any builds in progress if the user interrupts the command.
+				ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+				defer stop()
+				mirrors := images.WithRegistryMirrors(app.Config.RegistryMirrors)
+				if opts.Output != "" {
+					return images.BuildContextFile(ctx, opts.File, opts.Output, opts.List, mirrors)
+				}
+				controllerOpts := []images.ControllerOption{mirrors}
+				if opts.FollowLogs {
+					controllerOpts = append(controllerOpts, images.WithFollowLogs())
+				}
+				return images.ReconcileFile(ctx, opts.File, controllerOpts...)
 			}()
 
 			if err != nil {
@@ -41,6 +71,9 @@ func NewBuildCmd() *cobra.Command {
 	}
 
 	cmd.Flags().StringVarP(&opts.File, "file", "f", "", "The file containing the images to apply")
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", "", "Write the build context of each image to <output>/<name>.tgz instead of building the images. Use this to check which files end up in the build context.")
+	cmd.Flags().BoolVarP(&opts.FollowLogs, "follow-logs", "", false, "Stream the logs of the builds into the output while waiting for them. Requires the builds to write their logs to GCS.")
+	cmd.Flags().BoolVarP(&opts.List, "list", "", false, "With --output also write the list of files in each build context to <output>/<name>.txt")
 
 	cmd.MarkFlagRequired("file")
 	cmd.MarkFlagRequired("private-key")
@@ -9,8 +9,8 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/go-logr/zapr"
-	"github.com/jlewi/monogo/files"
 	"github.com/jlewi/hydros/pkg/github"
+	"github.com/jlewi/hydros/pkg/keychain"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
@@ -37,7 +37,10 @@ func NewCloneCmd() *cobra.Command {
 					return err
 				}
 				config := GetConfig()
-				privateKey, err := files.Read(privateKeyFile)
+				if err := resolvePaths(&workDir, &privateKeyFile); err != nil {
+					return err
+				}
+				privateKey, err := keychain.Read(privateKeyFile)
 				if err != nil {
 					return err
 				}
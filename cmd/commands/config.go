@@ -6,12 +6,16 @@ import (
 	"strings"
 
 	"github.com/jlewi/hydros/pkg/config"
+	"github.com/jlewi/hydros/pkg/keychain"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"gopkg.in/yaml.v3"
 )
 
+// keychainService is the service secrets stored by hydros config set-secret are stored under in the keychain.
+const keychainService = "hydros"
+
 // NewConfigCmd adds commands to deal with configuration
 func NewConfigCmd() *cobra.Command {
 	cmd := &cobra.Command{
@@ -20,6 +24,7 @@ func NewConfigCmd() *cobra.Command {
 
 	cmd.AddCommand(NewGetConfigCmd())
 	cmd.AddCommand(NewSetConfigCmd())
+	cmd.AddCommand(NewSetSecretCmd())
 	return cmd
 }
 
@@ -67,6 +72,63 @@ func NewSetConfigCmd() *cobra.Command {
 	return cmd
 }
 
+// NewSetSecretCmd stores a secret in the OS keychain and sets a configuration value to its URI.
+func NewSetSecretCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set-secret <name> <file>",
+		Short: "Store the secret in file in the OS keychain and configure name to read it from there",
+		Long: `Store the secret in file in the OS keychain and configure name to read it from there.
+
+The secret is stored in the macOS keychain, the Windows Credential Manager or the Secret Service on Linux
+(which requires secret-tool) so it doesn't need to be kept in a plaintext file. For example
+
+    hydros config set-secret github.privateKey ~/Downloads/my-app.private-key.pem
+
+stores the private key of the GitHub App and sets github.privateKey to keychain://hydros/github.privateKey.
+The file can be deleted afterwards.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := func() error {
+				if err := config.InitViper(cmd); err != nil {
+					return err
+				}
+				cfgName := args[0]
+				p, err := resolvePath(args[1])
+				if err != nil {
+					return err
+				}
+				secret, err := os.ReadFile(p)
+				if err != nil {
+					return errors.Wrapf(err, "Failed to read %v", p)
+				}
+				if err := keychain.Set(keychainService, cfgName, secret); err != nil {
+					return err
+				}
+				uri := keychain.URI(keychainService, cfgName)
+				viper.Set(cfgName, uri)
+				fConfig := config.GetConfig()
+
+				file := viper.ConfigFileUsed()
+				if file == "" {
+					file = config.DefaultConfigFile()
+				}
+				if err := fConfig.Write(file); err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stdout, "Stored the secret in the keychain; %v=%v\n", cfgName, uri)
+				return nil
+			}()
+
+			if err != nil {
+				fmt.Printf("Failed to set secret;\n %+v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	return cmd
+}
+
 // NewGetConfigCmd  prints out the configuration
 func NewGetConfigCmd() *cobra.Command {
 	cmd := &cobra.Command{
@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/jlewi/hydros/pkg/app"
+	"github.com/jlewi/hydros/pkg/images"
+	"github.com/jlewi/hydros/pkg/tarutil"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// NewContextCmd creates the context command which groups commands for the build contexts of images.
+func NewContextCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "context",
+		Short: "Commands for the build contexts of images",
+	}
+	cmd.AddCommand(newContextCreateCmd())
+	return cmd
+}
+
+func newContextCreateCmd() *cobra.Command {
+	var file string
+	var output string
+	var name string
+	var list bool
+	cmd := &cobra.Command{
+		Use:   "create -f <images.yaml> -o <context.tgz>",
+		Short: "Assemble the build context of an image into a tarball without building the image.",
+		Long: `Assemble the build context of an image into a gzipped tarball without building the image.
+
+The file can contain Image resources or a snippet with only the sources of the context e.g.
+
+  mappings:
+  - src: Dockerfile
+  - src: "src/**/*.go"
+
+The mappings of a snippet are applied to the directory containing it. Local sources without a scheme are
+resolved relative to the directory containing the file. The output can be a local path or a GCS URI e.g.
+gs://bucket/context.tgz so the context can be used by other build systems.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := func() error {
+				app := app.NewApp()
+				defer app.Shutdown()
+				if err := app.LoadConfig(cmd); err != nil {
+					return err
+				}
+				if err := app.SetupLogging(); err != nil {
+					return err
+				}
+				if err := app.SetupHTTP(); err != nil {
+					return err
+				}
+				if err := resolvePaths(&file, &output); err != nil {
+					return err
+				}
+				if list && strings.Contains(output, "://") {
+					return errors.New("--list can only be used when the output is a local path")
+				}
+				ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+				defer stop()
+				image, err := images.CreateContextFile(ctx, file, output, name, images.WithRegistryMirrors(app.Config.RegistryMirrors))
+				if err != nil {
+					return err
+				}
+				for _, m := range image.Status.Mappings {
+					fmt.Fprintf(os.Stdout, "%v %v: %d files\n", m.Source, m.Src, m.Matches)
+				}
+				if !list {
+					return nil
+				}
+				entries, err := tarutil.List(output)
+				if err != nil {
+					return err
+				}
+				for _, e := range entries {
+					fmt.Fprintln(os.Stdout, e)
+				}
+				return nil
+			}()
+			if err != nil {
+				fmt.Printf("Error creating build context;\n %+v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", "", "The file containing the Image or the context snippet.")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "The local path or GCS URI to write the context tarball to.")
+	cmd.Flags().StringVarP(&name, "image", "", "", "The name of the image to create the context of if the file contains multiple images.")
+	cmd.Flags().BoolVarP(&list, "list", "", false, "Print the files in the context.")
+	cmd.MarkFlagRequired("file")
+	cmd.MarkFlagRequired("output")
+	return cmd
+}
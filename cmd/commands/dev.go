@@ -0,0 +1,160 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/zapr"
+	"github.com/jlewi/hydros/pkg/app"
+	"github.com/jlewi/hydros/pkg/config"
+	"github.com/jlewi/hydros/pkg/github/fake"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+type devServerOptions struct {
+	fakeGitHub bool
+	reposDir   string
+	port       int
+	period     time.Duration
+	force      bool
+	selector   string
+}
+
+// NewDevCmd creates the dev command which groups commands for local development.
+func NewDevCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dev",
+		Short: "Commands for developing hydros locally",
+	}
+	cmd.AddCommand(newDevServerCmd())
+	return cmd
+}
+
+func newDevServerCmd() *cobra.Command {
+	opts := devServerOptions{}
+	cmd := &cobra.Command{
+		Use:   "server <resource.yaml> <resourceDir> ...",
+		Short: "Apply the specified resources; optionally against a fake GitHub backed by local bare repositories.",
+		Long: `Apply the specified resources; optionally against a fake GitHub backed by local bare repositories.
+
+With --fake-github, hydros talks to an in-process fake of GitHub rather than github.com. Repositories
+are bare git repositories in --repos-dir stored as <org>/<repo>.git; e.g. the repository
+https://github.com/acme/manifests.git is served from <repos-dir>/acme/manifests.git. Pull requests
+live in memory and are merged as soon as they are up to date with their base branch.
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := func() error {
+				selector, err := parseSelector(opts.selector)
+				if err != nil {
+					return err
+				}
+				a := app.NewApp()
+				a.HydrosVersion = version
+				a.Selector = selector
+				defer a.Shutdown()
+				if err := a.LoadConfig(cmd); err != nil {
+					return err
+				}
+				if err := a.SetupLogging(); err != nil {
+					return err
+				}
+				if err := a.SetupHTTP(); err != nil {
+					return err
+				}
+				if len(args) == 0 {
+					return errors.New("server takes at least one argument which should be the file or directory YAML to apply.")
+				}
+				logVersion()
+				checkVersion(a.Config)
+
+				if opts.fakeGitHub {
+					if err := setupFakeGitHub(a, opts); err != nil {
+						return err
+					}
+				}
+
+				if err := a.SetupRegistry(); err != nil {
+					return err
+				}
+
+				return a.ApplyPaths(context.Background(), args, opts.period, opts.force)
+			}()
+			if err != nil {
+				fmt.Printf("Error running dev server;\n %+v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opts.fakeGitHub, "fake-github", "", false, "Use an in-process fake of GitHub backed by the bare repositories in --repos-dir.")
+	cmd.Flags().StringVarP(&opts.reposDir, "repos-dir", "", "", "Directory containing the bare repositories served by the fake as <org>/<repo>.git. Required with --fake-github.")
+	cmd.Flags().IntVarP(&opts.port, "port", "", 0, "Port the fake serves git on. If zero a free port is chosen.")
+	cmd.Flags().DurationVarP(&opts.period, "period", "p", 0*time.Minute, "The period with which to reapply. If zero run once and exit.")
+	cmd.Flags().BoolVarP(&opts.force, "force", "", false, "Force a sync even if one isn't needed.")
+	cmd.Flags().StringVarP(&opts.selector, selectorFlag, "l", "", selectorHelp)
+	return cmd
+}
+
+// setupFakeGitHub starts the fake and routes all GitHub traffic to it. API requests are served in-process by
+// replacing the default HTTP transport. The git CLI can't use the transport so the fake is also served on a
+// local port and git is configured to rewrite github.com URLs to it.
+func setupFakeGitHub(a *app.App, opts devServerOptions) error {
+	log := zapr.NewLogger(zap.L())
+	if opts.reposDir == "" {
+		return errors.New("--repos-dir is required when using --fake-github")
+	}
+	reposDir, err := filepath.Abs(opts.reposDir)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get absolute path for %v", opts.reposDir)
+	}
+
+	s, err := fake.NewServer(reposDir)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", opts.port))
+	if err != nil {
+		return errors.Wrapf(err, "Failed to listen on port %v", opts.port)
+	}
+	go func() {
+		if err := s.Serve(listener); err != nil {
+			log.Error(err, "Fake GitHub server exited")
+		}
+	}()
+
+	baseURL := fmt.Sprintf("http://%v/", listener.Addr().String())
+	for _, kv := range fake.GitConfigEnv(baseURL) {
+		k, v, _ := strings.Cut(kv, "=")
+		if err := os.Setenv(k, v); err != nil {
+			return errors.Wrapf(err, "Failed to set environment variable %v", k)
+		}
+	}
+
+	http.DefaultTransport = &fake.Transport{Server: s, Next: http.DefaultTransport}
+
+	keyFile, err := os.CreateTemp("", "hydros-fake-github-*.pem")
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create file for the fake GitHub App private key")
+	}
+	defer keyFile.Close()
+	if _, err := keyFile.Write(s.PrivateKey()); err != nil {
+		return errors.Wrapf(err, "Failed to write the fake GitHub App private key to %v", keyFile.Name())
+	}
+
+	a.Config.GitHub = &config.GitHubConfig{
+		AppID:      fake.AppID,
+		PrivateKey: keyFile.Name(),
+	}
+
+	log.Info("Using fake GitHub", "reposDir", reposDir, "gitURL", baseURL)
+	return nil
+}
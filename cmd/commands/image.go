@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jlewi/hydros/pkg/app"
+	"github.com/jlewi/hydros/pkg/images"
+	"github.com/jlewi/hydros/pkg/tarutil"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// NewImageCmd creates the image command which groups commands for Image resources.
+func NewImageCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "image",
+		Short: "Commands for Image resources",
+	}
+	cmd.AddCommand(newImageLintCmd())
+	return cmd
+}
+
+func newImageLintCmd() *cobra.Command {
+	var file string
+	var name string
+	var list bool
+	cmd := &cobra.Command{
+		Use:   "lint -f <image.yaml>",
+		Short: "Check the Images in a file without building them.",
+		Long: `Check the Images in a file without building them so mistakes are caught before a build is started.
+
+The spec of each image is validated, the mappings of its local sources are previewed against the local checkout,
+the size and largest files of the build context are reported and the Dockerfile is checked to be in the build
+context. Git sources for the repository containing the file are replaced with the local checkout like they are
+when the image is built. Remote sources aren't downloaded so their mappings aren't previewed.
+
+The command exits with a non-zero status if any image has errors.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := func() error {
+				app := app.NewApp()
+				defer app.Shutdown()
+				if err := app.LoadConfig(cmd); err != nil {
+					return err
+				}
+				if err := app.SetupLogging(); err != nil {
+					return err
+				}
+				if err := app.SetupHTTP(); err != nil {
+					return err
+				}
+				if err := resolvePaths(&file); err != nil {
+					return err
+				}
+				results, err := images.LintFile(context.Background(), file, name)
+				if err != nil {
+					return err
+				}
+				failed := 0
+				for _, r := range results {
+					printLintResult(os.Stdout, r, list)
+					if len(r.Errors) > 0 {
+						failed++
+					}
+				}
+				if failed > 0 {
+					return errors.Errorf("%d of %d images have errors", failed, len(results))
+				}
+				return nil
+			}()
+			if err != nil {
+				fmt.Printf("Error linting images;\n %+v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", "", "The file containing the Images.")
+	cmd.Flags().StringVarP(&name, "image", "", "", "The name of the image to lint. Defaults to all the images in the file.")
+	cmd.Flags().BoolVarP(&list, "list", "", false, "Print the files in the build context.")
+	cmd.MarkFlagRequired("file")
+	return cmd
+}
+
+// printLintResult prints the result of linting an image to w.
+func printLintResult(w io.Writer, r *images.LintResult, list bool) {
+	fmt.Fprintf(w, "Image %v:\n", r.Image.Metadata.Name)
+	for _, m := range r.Mappings {
+		fmt.Fprintf(w, "  %v %v: %d files\n", m.Source, m.Src, m.Matches)
+	}
+	if r.Context != nil {
+		fmt.Fprintf(w, "  Context: %v in %d files\n", tarutil.FormatSize(r.Context.Size), r.Context.NumFiles)
+		for _, f := range r.Context.LargestFiles {
+			fmt.Fprintf(w, "    %v %v\n", tarutil.FormatSize(f.Size), f.Path)
+		}
+	}
+	if list {
+		for _, f := range r.Files {
+			fmt.Fprintf(w, "  %v\n", f)
+		}
+	}
+	for _, warning := range r.Warnings {
+		fmt.Fprintf(w, "  Warning: %v\n", warning)
+	}
+	for _, e := range r.Errors {
+		fmt.Fprintf(w, "  Error: %v\n", e)
+	}
+	if len(r.Errors) == 0 {
+		fmt.Fprintln(w, "  OK")
+	}
+}
@@ -0,0 +1,168 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jlewi/hydros/pkg/scaffold"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// NewInitCmd creates the init command.
+func NewInitCmd() *cobra.Command {
+	var dir string
+	var yes bool
+	var force bool
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Generate starter ManifestSync, Image and hydros.yaml files for a repository",
+		Long: `Generate starter ManifestSync, Image and hydros.yaml files for a repository.
+
+init inspects the repository to find its kustomize overlays, Dockerfiles and the image registries its
+manifests use. It then asks for anything it couldn't infer, using what it found as the defaults, and
+writes commented manifestsync.yaml, images.yaml and hydros.yaml files to the root of the repository.
+images.yaml is only written if the repository has Dockerfiles. Existing files aren't overwritten
+unless --force is set.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := func() error {
+				root, err := resolveRepoDir(dir, ".")
+				if err != nil {
+					return err
+				}
+				repo, err := scaffold.Detect(root)
+				if err != nil {
+					return err
+				}
+				printDetected(os.Stdout, repo)
+
+				opts := scaffold.DefaultOptions(repo)
+				if !yes {
+					if err := promptOptions(bufio.NewReader(os.Stdin), os.Stdout, &opts); err != nil {
+						return err
+					}
+				}
+
+				files, err := scaffold.Generate(repo, opts)
+				if err != nil {
+					return err
+				}
+				return writeScaffold(os.Stdout, root, files, force)
+			}()
+			if err != nil {
+				fmt.Printf("init failed; error %+v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&dir, "dir", "", "", "The repository to initialize. Defaults to the repository containing the current directory.")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Don't prompt; use the inferred values.")
+	cmd.Flags().BoolVarP(&force, "force", "", false, "Overwrite files that already exist.")
+	return cmd
+}
+
+// printDetected summarizes what was found in the repository.
+func printDetected(w io.Writer, repo *scaffold.Repo) {
+	fmt.Fprintf(w, "Inspected %v\n", repo.Root)
+	if repo.Org != "" {
+		fmt.Fprintf(w, "  GitHub repository: %v/%v\n", repo.Org, repo.Name)
+	}
+	list := func(name string, items []string) {
+		if len(items) == 0 {
+			fmt.Fprintf(w, "  %v: none\n", name)
+			return
+		}
+		fmt.Fprintf(w, "  %v:\n", name)
+		for _, i := range items {
+			fmt.Fprintf(w, "    %v\n", i)
+		}
+	}
+	list("Kustomize overlays", repo.Overlays)
+	list("Dockerfiles", repo.Dockerfiles)
+	list("Image registries", repo.Registries)
+}
+
+// promptOptions asks for each option using the current values as the defaults. The defaults of derived options
+// are recomputed from the earlier answers.
+func promptOptions(r *bufio.Reader, w io.Writer, opts *scaffold.Options) error {
+	questions := []struct {
+		question string
+		value    *string
+		derived  bool
+	}{
+		{"Name of the ManifestSync", &opts.Name, false},
+		{"GitHub organization of this repository", &opts.Org, false},
+		{"Name of this repository", &opts.Repo, false},
+		{"Branch to hydrate", &opts.Branch, false},
+		{"GitHub organization to check the hydrated manifests into", &opts.DestOrg, false},
+		{"Repository to check the hydrated manifests into", &opts.DestRepo, true},
+		{"Branch to check the hydrated manifests into", &opts.DestBranch, false},
+		{"Directory to write the hydrated manifests to", &opts.DestPath, true},
+		{"Repository to push images to e.g. us-west1-docker.pkg.dev/acme/images", &opts.ImageRepository, false},
+		{"GCP project to build images in", &opts.Project, true},
+	}
+	for i, q := range questions {
+		if q.derived {
+			*q.value = ""
+			opts.SetDefaults()
+		}
+		if _, err := fmt.Fprintf(w, "%v [%v]: ", q.question, *q.value); err != nil {
+			return errors.Wrapf(err, "Failed to write prompt")
+		}
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return errors.Wrapf(err, "Failed to read answer")
+		}
+		if answer := strings.TrimSpace(line); answer != "" {
+			*q.value = answer
+		}
+		if err == io.EOF {
+			// Use the defaults for the rest e.g. when stdin isn't a terminal.
+			fmt.Fprintln(w)
+			for _, rest := range questions[i+1:] {
+				if rest.derived {
+					*rest.value = ""
+				}
+			}
+			opts.SetDefaults()
+			return nil
+		}
+	}
+	return nil
+}
+
+// writeScaffold writes files to root. It refuses to overwrite existing files unless force is true.
+func writeScaffold(w io.Writer, root string, files map[string]string, force bool) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if !force {
+		existing := []string{}
+		for _, name := range names {
+			if _, err := os.Stat(filepath.Join(root, name)); err == nil {
+				existing = append(existing, name)
+			}
+		}
+		if len(existing) > 0 {
+			return errors.Errorf("%v already exist in %v; use --force to overwrite them", strings.Join(existing, ", "), root)
+		}
+	}
+
+	for _, name := range names {
+		p := filepath.Join(root, name)
+		if err := os.WriteFile(p, []byte(files[name]), 0o644); err != nil {
+			return errors.Wrapf(err, "Failed to write %v", p)
+		}
+		fmt.Fprintf(w, "Wrote %v\n", p)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jlewi/hydros/pkg/gitutil"
+	"github.com/pkg/errors"
+)
+
+const (
+	allowExistingFlag = "allow-existing"
+	allowExistingHelp = "Allow the work directory to be an existing directory that wasn't created by hydros. Hydros discards local changes in its work directory so never point it at a checkout with local work."
+)
+
+// resolvePath returns the absolute, cleaned form of the path flag p so commands behave the same regardless of
+// whether paths are passed as e.g. ./manifestsync.yaml, manifestsync.yaml or /abs/manifestsync.yaml.
+// A leading ~ is expanded to the home directory and relative paths are resolved against the current directory.
+// Empty values and URIs (e.g. gcpSecretManager:///...) aren't local paths so they are returned unchanged.
+func resolvePath(p string) (string, error) {
+	if p == "" || strings.Contains(p, "://") {
+		return p, nil
+	}
+
+	if p == "~" || strings.HasPrefix(p, "~"+string(os.PathSeparator)) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.Wrapf(err, "Could not get home directory to resolve %v", p)
+		}
+		p = filepath.Join(home, strings.TrimPrefix(p, "~"))
+	}
+
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to get absolute path for %v", p)
+	}
+	return abs, nil
+}
+
+// resolvePaths resolves each of the path flags in place using resolvePath.
+func resolvePaths(paths ...*string) error {
+	for _, p := range paths {
+		resolved, err := resolvePath(*p)
+		if err != nil {
+			return err
+		}
+		*p = resolved
+	}
+	return nil
+}
+
+// resolveRepoDir returns the root of the git repository containing repoDir. If repoDir is empty the repository
+// containing file is used.
+func resolveRepoDir(repoDir string, file string) (string, error) {
+	if repoDir == "" {
+		repoDir = file
+	}
+	resolved, err := resolvePath(repoDir)
+	if err != nil {
+		return "", err
+	}
+	root, err := gitutil.LocateRoot(resolved)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to locate the git repository containing %v", resolved)
+	}
+	if root == "" {
+		return "", errors.Errorf("%v isn't in a git repository", resolved)
+	}
+	return root, nil
+}
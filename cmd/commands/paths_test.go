@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_resolvePath(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get cwd; %v", err)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatalf("Failed to get home directory; %v", err)
+	}
+
+	type testCase struct {
+		name     string
+		input    string
+		expected string
+	}
+
+	cases := []testCase{
+		{name: "empty", input: "", expected: ""},
+		{name: "dot-relative", input: "./manifestsync.yaml", expected: filepath.Join(cwd, "manifestsync.yaml")},
+		{name: "relative", input: "manifestsync.yaml", expected: filepath.Join(cwd, "manifestsync.yaml")},
+		{name: "parent", input: "../configs/", expected: filepath.Join(filepath.Dir(cwd), "configs")},
+		{name: "absolute", input: "/tmp/a/../b", expected: "/tmp/b"},
+		{name: "home", input: "~/.ssh/id_ed25519", expected: filepath.Join(home, ".ssh", "id_ed25519")},
+		{name: "uri", input: "gcpSecretManager:///projects/acme/secrets/key/versions/latest", expected: "gcpSecretManager:///projects/acme/secrets/key/versions/latest"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := resolvePath(c.input)
+			if err != nil {
+				t.Fatalf("resolvePath failed; %v", err)
+			}
+			if actual != c.expected {
+				t.Errorf("Got %v; want %v", actual, c.expected)
+			}
+		})
+	}
+}
+
+func Test_resolveRepoDir(t *testing.T) {
+	dir, err := os.MkdirTemp("", "testResolveRepoDir")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Resolve symlinks (e.g. /tmp on macOS) so the expected paths match the paths found by walking the tree.
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatalf("Failed to resolve symlinks; %v", err)
+	}
+
+	configs := filepath.Join(dir, "configs")
+	for _, d := range []string{filepath.Join(dir, ".git"), configs} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatalf("Failed to create %v; %v", d, err)
+		}
+	}
+	file := filepath.Join(configs, "manifestsync.yaml")
+	if err := os.WriteFile(file, []byte("kind: ManifestSync\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write %v; %v", file, err)
+	}
+
+	// Relative paths are resolved against the current directory.
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get cwd; %v", err)
+	}
+	if err := os.Chdir(configs); err != nil {
+		t.Fatalf("Failed to change directory; %v", err)
+	}
+	defer os.Chdir(cwd)
+
+	for _, args := range [][2]string{{"", "./manifestsync.yaml"}, {".", file}, {"..", ""}} {
+		actual, err := resolveRepoDir(args[0], args[1])
+		if err != nil {
+			t.Fatalf("resolveRepoDir(%q, %q) failed; %v", args[0], args[1], err)
+		}
+		if actual != dir {
+			t.Errorf("resolveRepoDir(%q, %q) = %v; want %v", args[0], args[1], actual, dir)
+		}
+	}
+}
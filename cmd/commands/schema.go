@@ -0,0 +1,54 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jlewi/hydros/pkg/validate"
+	"github.com/spf13/cobra"
+)
+
+// NewSchemaCmd creates the schema command which groups commands for the JSON schemas of the hydros API.
+func NewSchemaCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Commands for the JSON schemas of hydros resources",
+	}
+	cmd.AddCommand(newSchemaExportCmd())
+	return cmd
+}
+
+func newSchemaExportCmd() *cobra.Command {
+	var outputDir string
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write the JSON schemas of all the hydros kinds to a directory.",
+		Long: fmt.Sprintf(`Write the JSON schemas of all the hydros kinds to a directory.
+
+A schema is written for each kind e.g. ManifestSync.json along with %v which accepts a resource of
+any kind. Editors can use the schemas to validate resources as you type; e.g. for the YAML language
+server add the following comment to the top of a file
+
+  # yaml-language-server: $schema=<output-dir>/%v
+`, validate.AllKindsSchemaFile, validate.AllKindsSchemaFile),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := func() error {
+				paths, err := validate.Export(outputDir)
+				if err != nil {
+					return err
+				}
+				for _, p := range paths {
+					fmt.Fprintf(os.Stdout, "Wrote %v\n", p)
+				}
+				return nil
+			}()
+			if err != nil {
+				fmt.Printf("Error exporting schemas;\n %+v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputDir, "output-dir", "o", "schemas", "Directory to write the schemas to.")
+	return cmd
+}
@@ -1,16 +1,32 @@
 package commands
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/jlewi/hydros/pkg/keychain"
 	"github.com/jlewi/monogo/files"
 
+	"cloud.google.com/go/storage"
 	"github.com/go-logr/zapr"
 	"github.com/gregjones/httpcache"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/app"
+	hconfig "github.com/jlewi/hydros/pkg/config"
 	"github.com/jlewi/hydros/pkg/ghapp"
 	hGithub "github.com/jlewi/hydros/pkg/github"
+	"github.com/jlewi/hydros/pkg/github/ghrepo"
+	"github.com/jlewi/hydros/pkg/gitops"
 	"github.com/jlewi/hydros/pkg/hydros"
+	"github.com/jlewi/hydros/pkg/images"
+	"github.com/jlewi/hydros/pkg/janitor"
+	"github.com/jlewi/hydros/pkg/kube"
+	"github.com/jlewi/hydros/pkg/leader"
+	"github.com/jlewi/hydros/pkg/util"
 	"github.com/palantir/go-githubapp/githubapp"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -19,23 +35,56 @@ import (
 
 const (
 	defaultWebhookSecret = "gcpSecretManager:///projects/chat-lewi/secrets/hydros-webhook/versions/latest"
+	// leaderLeaseName is the name of the Kubernetes Lease used for leader election.
+	leaderLeaseName = "hydros-leader"
 )
 
+type serverOptions struct {
+	port             int
+	webhookSecret    string
+	privateKeySecret string
+	githubAppID      int64
+	workDir          string
+	numWorkers       int
+	baseHREF         string
+	statusConfigMaps bool
+	manifestSyncs    []string
+
+	registryEventsToken string
+	cancelToken         string
+	leaderElection      string
+	queue               string
+
+	janitorRetention    time.Duration
+	janitorPeriod       time.Duration
+	janitorRepos        []string
+	janitorBranchPrefix string
+	janitorBuckets      []string
+}
+
 // NewHydrosServerCmd creates a command to run the server
 func NewHydrosServerCmd() *cobra.Command {
-	var port int
-	var webhookSecret string
-	var privateKeySecret string
-	var githubAppID int64
-	var workDir string
-	var numWorkers int
-	var baseHREF string
+	opts := serverOptions{}
 	cmd := &cobra.Command{
-		Use:   "serve",
-		Short: "Run the hydros server",
+		Use:     "serve",
+		Aliases: []string{"server"},
+		Short:   "Run the hydros server",
 		Run: func(cmd *cobra.Command, args []string) {
 			log := zapr.NewLogger(zap.L())
-			err := run(baseHREF, port, webhookSecret, privateKeySecret, githubAppID, workDir, numWorkers)
+			err := func() error {
+				a := app.NewApp()
+				defer a.Shutdown()
+				if err := a.LoadConfig(cmd); err != nil {
+					return err
+				}
+				if err := a.SetupLogging(); err != nil {
+					return err
+				}
+				if err := a.SetupHTTP(); err != nil {
+					return err
+				}
+				return run(opts, a.Config)
+			}()
 			if err != nil {
 				log.Error(err, "Error running hydros")
 				os.Exit(1)
@@ -43,23 +92,254 @@ func NewHydrosServerCmd() *cobra.Command {
 		},
 	}
 
-	cmd.Flags().IntVarP(&port, "port", "p", 8080, "Port to serve on")
-	cmd.Flags().StringVarP(&baseHREF, "base-href", "", "/hydros/", "The base prefix for all URLs should end with a slash or empty string to use no prefix")
-	cmd.Flags().StringVarP(&webhookSecret, "webhook-secret", "", defaultWebhookSecret, "The URI of the HMAC secret used to sign GitHub webhooks. Can be a secret in GCP secret manager")
-	cmd.Flags().StringVarP(&privateKeySecret, "private-key", "", "", "The URI of the GitHub App private key. Can be a secret in GCP secret manager")
-	cmd.Flags().Int64VarP(&githubAppID, "ghapp-id", "", hydros.HydrosGitHubAppID, "GitHubAppId.")
-	cmd.Flags().StringVarP(&workDir, "work-dir", "", "", "(Optional) work directory where repositories should be checked out. Leave blank to use a temporary directory.")
-	cmd.Flags().IntVarP(&numWorkers, "num-workers", "", 10, "Number of workers to handle events.")
+	cmd.Flags().IntVarP(&opts.port, "port", "p", 8080, "Port to serve on")
+	cmd.Flags().StringVarP(&opts.baseHREF, "base-href", "", "/hydros/", "The base prefix for all URLs should end with a slash or empty string to use no prefix")
+	cmd.Flags().StringVarP(&opts.webhookSecret, "webhook-secret", "", defaultWebhookSecret, "The URI of the HMAC secret used to sign GitHub webhooks. Can be a secret in GCP secret manager")
+	cmd.Flags().StringVarP(&opts.privateKeySecret, "private-key", "", "", "The URI of the GitHub App private key. Can be a secret in GCP secret manager")
+	cmd.Flags().Int64VarP(&opts.githubAppID, "ghapp-id", "", hydros.HydrosGitHubAppID, "GitHubAppId.")
+	cmd.Flags().StringVarP(&opts.workDir, "work-dir", "", "", "(Optional) work directory where repositories should be checked out. Leave blank to use a temporary directory.")
+	cmd.Flags().IntVarP(&opts.numWorkers, "num-workers", "", 10, "Number of workers to handle events.")
+	cmd.Flags().BoolVarP(&opts.statusConfigMaps, "status-configmaps", "", false, "When running in Kubernetes, also store the result of the latest reconcile of each reconciler in a ConfigMap.")
+	cmd.Flags().StringSliceVarP(&opts.manifestSyncs, "manifest-syncs", "", []string{}, "Files containing ManifestSyncs to run. Each ManifestSync is synced whenever its source branch is pushed and periodically.")
+	cmd.Flags().StringVarP(&opts.registryEventsToken, "registry-events-token", "", "", "The URI of the token that notifications about pushed images must include e.g. a secret in GCP secret manager. If blank notifications aren't handled.")
+	cmd.Flags().StringVarP(&opts.cancelToken, "cancel-token", "", "", "The URI of the bearer token requests to cancel runs must include e.g. a secret in GCP secret manager. If blank runs can't be cancelled.")
+	cmd.Flags().StringVarP(&opts.leaderElection, "leader-election", "", "", "Enables leader election so multiple replicas can run with only the leader running reconcilers. Either \"lease\" to use a Kubernetes Lease named "+leaderLeaseName+" or the URI of a GCS object to use as the lock e.g. gs://bucket/hydros/leader.json. If blank leader election is disabled. Replicas which aren't the leader requeue the events they receive locally so use --queue to share the queue between replicas.")
+	cmd.Flags().StringVarP(&opts.queue, "queue", "", "", "The URI of a GCS directory to store reconcile events in e.g. gs://bucket/hydros/queue so replicas share the events and they survive restarts. If blank events are kept in memory.")
+	cmd.Flags().DurationVarP(&opts.janitorRetention, "janitor-retention", "", 0, "How long to keep hydration branches, build contexts and exported images before garbage collecting them. If zero nothing is garbage collected.")
+	cmd.Flags().DurationVarP(&opts.janitorPeriod, "janitor-period", "", time.Hour, "How often to run garbage collection.")
+	cmd.Flags().StringSliceVarP(&opts.janitorRepos, "janitor-repos", "", []string{}, "Repositories, as org/repo, whose hydration branches should be garbage collected.")
+	cmd.Flags().StringVarP(&opts.janitorBranchPrefix, "janitor-branch-prefix", "", "hydros/", "Only branches with this prefix are garbage collected. Branches with open PRs are never deleted.")
+	cmd.Flags().StringSliceVarP(&opts.janitorBuckets, "janitor-buckets", "", []string{}, "GCS buckets whose image build contexts should be garbage collected.")
+	cmd.AddCommand(newReplayCmd())
 	return cmd
 }
 
-func run(baseHREF string, port int, webhookSecret string, privateKeySecret string, githubAppID int64, workDir string, numWorkers int) error {
+func run(opts serverOptions, hydrosConfig *hconfig.Config) error {
 	log := zapr.NewLogger(zap.L())
-	config, err := ghapp.BuildConfig(githubAppID, webhookSecret, privateKeySecret)
+	// ctx is cancelled on shutdown so the leader releases the lease rather than followers waiting for it to expire.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	config, err := ghapp.BuildConfig(opts.githubAppID, opts.webhookSecret, opts.privateKeySecret)
 	if err != nil {
 		return errors.Wrapf(err, "Error building config")
 	}
 
+	transports, err := newTransports(opts.privateKeySecret, opts.githubAppID)
+	if err != nil {
+		return err
+	}
+
+	managerOpts := []gitops.ManagerOption{}
+	if kube.InCluster() {
+		// Report the results of reconciles as Kubernetes Events so they can be inspected with kubectl.
+		recorderOpts := []kube.RecorderOption{}
+		if opts.statusConfigMaps {
+			recorderOpts = append(recorderOpts, kube.WithStatusConfigMaps())
+		}
+		recorder, err := kube.NewInClusterRecorder(recorderOpts...)
+		if err != nil {
+			return err
+		}
+		log.Info("Running in Kubernetes; reconcile results will be recorded as events", "statusConfigMaps", opts.statusConfigMaps)
+		managerOpts = append(managerOpts, gitops.WithResultRecorder(recorder))
+	}
+
+	serverOpts := []ghapp.ServerOption{}
+	var elector *leader.Elector
+	if opts.leaderElection != "" {
+		elector, err = newElector(opts.leaderElection)
+		if err != nil {
+			return err
+		}
+		if err := elector.Start(ctx); err != nil {
+			return err
+		}
+		log.Info("Leader election enabled; only the leader will run reconcilers", "lock", opts.leaderElection)
+		managerOpts = append(managerOpts, gitops.WithLeaderCheck(elector.IsLeader))
+		serverOpts = append(serverOpts, ghapp.WithLeaderCheck(elector.IsLeader))
+	}
+
+	if opts.queue != "" {
+		q, err := newQueue(opts.queue)
+		if err != nil {
+			return err
+		}
+		log.Info("Reconcile events will be stored in GCS", "queue", opts.queue)
+		managerOpts = append(managerOpts, gitops.WithQueue(q))
+	}
+
+	handler, err := newHandler(config, transports, opts.workDir, opts.numWorkers, managerOpts...)
+	if err != nil {
+		return err
+	}
+	handler.Functions = hydrosConfig.Functions
+
+	if elector != nil {
+		// Cancel the runs in progress when leadership is lost so they don't race with the runs of the new leader
+		// e.g. by creating duplicate PRs.
+		elector.OnStoppedLeading(func() {
+			cancelled := handler.Manager.CancelAll()
+			log.Info("Stopped leading; cancelled the runs in progress", "reconcilers", cancelled)
+		})
+	}
+
+	for _, f := range opts.manifestSyncs {
+		if err := addSyncers(handler, transports, f, opts.workDir); err != nil {
+			return err
+		}
+	}
+
+	if opts.janitorRetention > 0 {
+		jOpts := []janitor.Option{}
+		if elector != nil {
+			jOpts = append(jOpts, janitor.WithLeaderCheck(elector.IsLeader))
+		}
+		j, err := newJanitor(opts, transports, jOpts...)
+		if err != nil {
+			return err
+		}
+		j.Start(ctx, opts.janitorPeriod)
+	}
+
+	if opts.registryEventsToken != "" {
+		token, err := files.Read(opts.registryEventsToken)
+		if err != nil {
+			return errors.Wrapf(err, "Could not read registry events token: %v", opts.registryEventsToken)
+		}
+		serverOpts = append(serverOpts, ghapp.WithRegistryEvents(strings.TrimSpace(string(token))))
+	}
+
+	if opts.cancelToken != "" {
+		token, err := files.Read(opts.cancelToken)
+		if err != nil {
+			return errors.Wrapf(err, "Could not read cancel token: %v", opts.cancelToken)
+		}
+		serverOpts = append(serverOpts, ghapp.WithCancelToken(strings.TrimSpace(string(token))))
+	}
+
+	server, err := ghapp.NewServer(opts.baseHREF, opts.port, *config, handler, serverOpts...)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create server")
+	}
+
+	server.StartAndBlock()
+	stop()
+	if elector != nil {
+		// Wait for the lease to be released before exiting.
+		elector.Wait()
+	}
+	return nil
+}
+
+// addSyncers adds a Syncer to the handler for each ManifestSync in the file.
+func addSyncers(handler *ghapp.HydrosHandler, transports *hGithub.TransportManager, path string, workDir string) error {
+	log := zapr.NewLogger(zap.L())
+	nodes, err := util.ReadYaml(path)
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		if n.GetKind() != v1alpha1.ManifestSyncKind {
+			continue
+		}
+		m := &v1alpha1.ManifestSync{}
+		if err := n.Document().Decode(m); err != nil {
+			return errors.Wrapf(err, "Failed to decode ManifestSync from %v", path)
+		}
+		syncer, err := gitops.NewSyncer(m, transports, gitops.SyncWithWorkDir(workDir), gitops.SyncWithLogger(log), gitops.SyncWithHydrosVersion(version), gitops.SyncWithMergeQueueEvents(), gitops.SyncWithFunctions(handler.Functions))
+		if err != nil {
+			return errors.Wrapf(err, "Failed to create syncer for ManifestSync %v", m.Metadata.Name)
+		}
+		if err := handler.AddSyncer(syncer); err != nil {
+			return err
+		}
+		log.Info("Added syncer", "name", syncer.Name(), "source", syncer.SourceRepo())
+	}
+	return nil
+}
+
+// newJanitor creates the janitor to garbage collect the artifacts left behind by the server.
+func newJanitor(opts serverOptions, transports *hGithub.TransportManager, extra ...janitor.Option) (*janitor.Janitor, error) {
+	jOpts := []janitor.Option{
+		janitor.WithTempDirs(os.TempDir(), images.ExportDirPrefix),
+	}
+	jOpts = append(jOpts, extra...)
+	clients := janitor.TransportClientFactory(transports)
+	for _, name := range opts.janitorRepos {
+		repo, err := ghrepo.FromFullName(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid repository %v; repositories should be org/repo", name)
+		}
+		jOpts = append(jOpts, janitor.WithBranches(repo, opts.janitorBranchPrefix, clients))
+	}
+	if len(opts.janitorBuckets) > 0 {
+		client, err := storage.NewClient(context.Background())
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to create GCS client")
+		}
+		for _, bucket := range opts.janitorBuckets {
+			jOpts = append(jOpts, janitor.WithBuildContexts(client, bucket))
+		}
+	}
+	return janitor.New(opts.janitorRetention, jOpts...)
+}
+
+// newElector creates the elector for leader election. lock is either "lease" or the URI of a GCS object.
+func newElector(lock string) (*leader.Elector, error) {
+	if lock == "lease" {
+		l, err := kube.NewInClusterLeaseLock(leaderLeaseName)
+		if err != nil {
+			return nil, err
+		}
+		return leader.New(l)
+	}
+
+	if !strings.HasPrefix(lock, "gs://") {
+		return nil, errors.Errorf("Unsupported leader election lock %v; it should be \"lease\" or a GCS URI", lock)
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get hostname to use as the leader election identity")
+	}
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create GCS client")
+	}
+	l, err := leader.NewGCSLock(client, lock, identity)
+	if err != nil {
+		return nil, err
+	}
+	return leader.New(l)
+}
+
+// newQueue creates a queue for reconcile events stored in the GCS directory uri.
+func newQueue(uri string) (*gitops.GCSQueue, error) {
+	if !strings.HasPrefix(uri, "gs://") {
+		return nil, errors.Errorf("Unsupported queue %v; it should be a GCS URI", uri)
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get hostname to identify this replica in the queue")
+	}
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create GCS client")
+	}
+	return gitops.NewGCSQueue(client, uri, identity)
+}
+
+// newTransports creates the transports for the GitHub App.
+func newTransports(privateKeySecret string, githubAppID int64) (*hGithub.TransportManager, error) {
+	secret, err := keychain.Read(privateKeySecret)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not read secret: %v", privateKeySecret)
+	}
+	return hGithub.NewTransportManager(githubAppID, secret, zapr.NewLogger(zap.L()))
+}
+
+// newHandler creates the handler for GitHub webhooks.
+func newHandler(config *githubapp.Config, transports *hGithub.TransportManager, workDir string, numWorkers int, opts ...gitops.ManagerOption) (*ghapp.HydrosHandler, error) {
 	cc, err := githubapp.NewDefaultCachingClientCreator(
 		*config,
 		githubapp.WithClientUserAgent(ghapp.UserAgent),
@@ -68,28 +348,133 @@ func run(baseHREF string, port int, webhookSecret string, privateKeySecret strin
 	)
 
 	if err != nil {
-		return errors.Wrapf(err, "Error creating client creator")
+		return nil, errors.Wrapf(err, "Error creating client creator")
 	}
 
-	secret, err := files.Read(privateKeySecret)
+	return ghapp.NewHandler(cc, transports, workDir, numWorkers, opts...)
+}
+
+// newReplayCmd creates a command to replay a recorded webhook.
+func newReplayCmd() *cobra.Command {
+	var payloadFile string
+	var eventType string
+	var deliveryID string
+	var privateKeySecret string
+	var githubAppID int64
+	var workDir string
+	cmd := &cobra.Command{
+		Use:   "replay --payload <payload.json>",
+		Short: "Replay a recorded GitHub webhook through the hydros handler",
+		Long: `Replay a recorded GitHub webhook through the hydros handler.
+
+This is intended for debugging the handler e.g. how events are matched to InPlaceConfigs without having to push
+to GitHub. The payload is the JSON body of the webhook e.g. as shown for recent deliveries in the settings of the
+GitHub App. Any resulting reconcile runs to completion before the command exits.
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := zapr.NewLogger(zap.L())
+			err := func() error {
+				a := app.NewApp()
+				defer a.Shutdown()
+				if err := a.LoadConfig(cmd); err != nil {
+					return err
+				}
+				if err := a.SetupLogging(); err != nil {
+					return err
+				}
+				if err := a.SetupHTTP(); err != nil {
+					return err
+				}
+				return replay(payloadFile, eventType, deliveryID, privateKeySecret, githubAppID, workDir)
+			}()
+			if err != nil {
+				log.Error(err, "Error replaying webhook")
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&payloadFile, "payload", "", "", "Path of the file containing the JSON payload of the webhook")
+	cmd.Flags().StringVarP(&eventType, "event-type", "", "push", "The type of the event as sent in the X-GitHub-Event header")
+	cmd.Flags().StringVarP(&deliveryID, "delivery-id", "", "replay", "The delivery id to use for the event")
+	cmd.Flags().StringVarP(&privateKeySecret, "private-key", "", "", "The URI of the GitHub App private key. Can be a secret in GCP secret manager")
+	cmd.Flags().Int64VarP(&githubAppID, "ghapp-id", "", hydros.HydrosGitHubAppID, "GitHubAppId.")
+	cmd.Flags().StringVarP(&workDir, "work-dir", "", "", "(Optional) work directory where repositories should be checked out. Leave blank to use a temporary directory.")
+	return cmd
+}
+
+func replay(payloadFile string, eventType string, deliveryID string, privateKeySecret string, githubAppID int64, workDir string) error {
+	log := zapr.NewLogger(zap.L())
+	if payloadFile == "" {
+		return errors.New("--payload is required")
+	}
+	payload, err := os.ReadFile(payloadFile)
 	if err != nil {
-		return errors.Wrapf(err, "Could not read secret: %v", privateKeySecret)
+		return errors.Wrapf(err, "Failed to read payload from %v", payloadFile)
 	}
-	transports, err := hGithub.NewTransportManager(githubAppID, secret, log)
+
+	config, err := ghapp.BuildConfig(githubAppID, "", privateKeySecret)
 	if err != nil {
-		return err
+		return errors.Wrapf(err, "Error building config")
 	}
 
-	handler, err := ghapp.NewHandler(cc, transports, workDir, 10)
+	transports, err := newTransports(privateKeySecret, githubAppID)
 	if err != nil {
 		return err
 	}
 
-	server, err := ghapp.NewServer(baseHREF, port, *config, handler)
+	handler, err := newHandler(config, transports, workDir, 1)
 	if err != nil {
-		return errors.Wrapf(err, "Failed to create server")
+		return err
 	}
 
-	server.StartAndBlock()
+	log.Info("Replaying webhook", "payload", payloadFile, "eventType", eventType, "deliveryID", deliveryID)
+	return replayEvent(handler, handler.Manager, eventType, deliveryID, payload)
+}
+
+// webhookHandler handles GitHub webhooks. It is implemented by ghapp.HydrosHandler.
+type webhookHandler interface {
+	Handles() []string
+	Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error
+}
+
+// replayEvent passes the webhook to the handler and waits for the reconciles it triggers to finish. An error is
+// returned if the handler doesn't handle the event type, if the event isn't routed to a reconciler or if any of
+// the reconciles fail.
+func replayEvent(handler webhookHandler, manager *gitops.Manager, eventType string, deliveryID string, payload []byte) error {
+	log := zapr.NewLogger(zap.L())
+	handled := false
+	for _, t := range handler.Handles() {
+		if t == eventType {
+			handled = true
+		}
+	}
+	if !handled {
+		return errors.Errorf("The handler doesn't handle %v events; it handles %v", eventType, handler.Handles())
+	}
+
+	handleErr := handler.Handle(context.Background(), eventType, deliveryID, payload)
+
+	// Wait for any reconciles triggered by the event to finish.
+	manager.Shutdown()
+	if handleErr != nil {
+		return handleErr
+	}
+
+	results := manager.Results()
+	if len(results) == 0 {
+		return errors.Errorf("The %v event wasn't routed to any reconciler", eventType)
+	}
+	allErrors := &util.ListOfErrors{}
+	for _, r := range results {
+		log.Info("Reconcile finished", "name", r.Name, "event", r.Event, "duration", r.Duration, "err", r.Err)
+		if r.Err != nil {
+			allErrors.AddCause(errors.Wrapf(r.Err, "Reconciler %v failed", r.Name))
+		}
+	}
+	if len(allErrors.Causes) > 0 {
+		allErrors.Final = errors.Errorf("%d of %d reconciles failed", len(allErrors.Causes), len(results))
+		return allErrors
+	}
 	return nil
 }
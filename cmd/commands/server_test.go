@@ -0,0 +1,146 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+	"github.com/jlewi/hydros/pkg/gitops"
+	"github.com/pkg/errors"
+)
+
+// recordingReconciler records the events it is run with.
+type recordingReconciler struct {
+	name   string
+	events []any
+	err    error
+}
+
+func (r *recordingReconciler) Name() string {
+	return r.name
+}
+
+func (r *recordingReconciler) Run(event any) error {
+	r.events = append(r.events, event)
+	return r.err
+}
+
+// pushHandler is a fake handler which enqueues a SyncEvent for the reconciler named after the repository that
+// was pushed to.
+type pushHandler struct {
+	manager *gitops.Manager
+}
+
+func (h *pushHandler) Handles() []string {
+	return []string{"push"}
+}
+
+func (h *pushHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	event := &github.PushEvent{}
+	if err := json.Unmarshal(payload, event); err != nil {
+		return errors.Wrapf(err, "Failed to unmarshal push event")
+	}
+	return h.manager.Enqueue(event.GetRepo().GetFullName(), gitops.SyncEvent{Commit: event.GetAfter()})
+}
+
+// ignoringHandler is a fake handler which doesn't route events to any reconciler.
+type ignoringHandler struct{}
+
+func (h *ignoringHandler) Handles() []string {
+	return []string{"push"}
+}
+
+func (h *ignoringHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	return nil
+}
+
+func Test_replayEvent(t *testing.T) {
+	payload := []byte(`{"ref": "refs/heads/main", "after": "1234abcd", "repository": {"full_name": "acme/app"}}`)
+
+	type testCase struct {
+		name      string
+		handler   func(m *gitops.Manager) webhookHandler
+		eventType string
+		runErr    error
+		expected  []any
+		// wantErr is a substring of the expected error. It is empty if replaying should succeed.
+		wantErr string
+	}
+
+	cases := []testCase{
+		{
+			name:      "push",
+			handler:   func(m *gitops.Manager) webhookHandler { return &pushHandler{manager: m} },
+			eventType: "push",
+			expected:  []any{gitops.SyncEvent{Commit: "1234abcd"}},
+		},
+		{
+			name:      "reconcile-fails",
+			handler:   func(m *gitops.Manager) webhookHandler { return &pushHandler{manager: m} },
+			eventType: "push",
+			runErr:    errors.New("sync failed"),
+			expected:  []any{gitops.SyncEvent{Commit: "1234abcd"}},
+			wantErr:   "Reconciler acme/app failed: sync failed",
+		},
+		{
+			name:      "unhandled-event-type",
+			handler:   func(m *gitops.Manager) webhookHandler { return &pushHandler{manager: m} },
+			eventType: "pull_request",
+			wantErr:   "doesn't handle pull_request events",
+		},
+		{
+			name:      "not-routed",
+			handler:   func(m *gitops.Manager) webhookHandler { return &ignoringHandler{} },
+			eventType: "push",
+			wantErr:   "wasn't routed to any reconciler",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &recordingReconciler{name: "acme/app", err: c.runErr}
+			m, err := gitops.NewManager([]gitops.Reconciler{r})
+			if err != nil {
+				t.Fatalf("Failed to create manager; %v", err)
+			}
+			if err := m.Start(1, time.Hour); err != nil {
+				t.Fatalf("Failed to start manager; %v", err)
+			}
+
+			err = replayEvent(c.handler(m), m, c.eventType, "replay", payload)
+			if c.wantErr == "" && err != nil {
+				t.Fatalf("replayEvent failed; %+v", err)
+			}
+			if c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)) {
+				t.Fatalf("Expected error containing %q; got %v", c.wantErr, err)
+			}
+			if len(r.events) != len(c.expected) {
+				t.Fatalf("Expected events %v; got %v", c.expected, r.events)
+			}
+			for i := range c.expected {
+				if r.events[i] != c.expected[i] {
+					t.Errorf("Expected event %v; got %v", c.expected[i], r.events[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_replayEventUnknownReconciler(t *testing.T) {
+	m, err := gitops.NewManager([]gitops.Reconciler{&recordingReconciler{name: "acme/other"}})
+	if err != nil {
+		t.Fatalf("Failed to create manager; %v", err)
+	}
+	if err := m.Start(1, time.Hour); err != nil {
+		t.Fatalf("Failed to start manager; %v", err)
+	}
+
+	payload := []byte(`{"ref": "refs/heads/main", "after": "1234abcd", "repository": {"full_name": "acme/app"}}`)
+	err = replayEvent(&pushHandler{manager: m}, m, "push", "replay", payload)
+	if err == nil || !strings.Contains(err.Error(), "There is no reconciler named acme/app") {
+		t.Fatalf("Expected an error because there is no reconciler for acme/app; got %v", err)
+	}
+}
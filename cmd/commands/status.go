@@ -0,0 +1,124 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/gitops"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// NewStatusCmd creates the status command.
+func NewStatusCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status <repoconfig.yaml | status uri> ...",
+		Short: "Display the status of the resources reconciled by a RepoConfig",
+		Long: `Display the status of the resources reconciled by a RepoConfig.
+
+RepoConfigs with spec.status.uri set record the result of reconciling each resource. Arguments can be
+files containing RepoConfigs, in which case the status is read from spec.status.uri, or the status URI
+itself i.e. a local file or a GCS object (gs://bucket/path).`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := func() error {
+				if len(args) == 0 {
+					return errors.New("status takes at least one argument which should be a RepoConfig file or a status URI.")
+				}
+				uris, err := statusURIs(args)
+				if err != nil {
+					return err
+				}
+				return printStatus(context.Background(), os.Stdout, uris)
+			}()
+			if err != nil {
+				fmt.Printf("status failed; error %+v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	return cmd
+}
+
+// statusURIs returns the URIs of the statuses to display. Arguments that are files containing RepoConfigs are
+// replaced by the status URIs of the RepoConfigs.
+func statusURIs(args []string) ([]string, error) {
+	uris := []string{}
+	for _, a := range args {
+		if strings.HasPrefix(a, "gs://") {
+			uris = append(uris, a)
+			continue
+		}
+		nodes, err := util.ReadYaml(strings.TrimPrefix(a, "file://"))
+		if err != nil {
+			return nil, err
+		}
+		isRepoConfig := false
+		for _, n := range nodes {
+			if n.GetKind() != v1alpha1.RepoGVK.Kind {
+				continue
+			}
+			isRepoConfig = true
+			repo := &v1alpha1.RepoConfig{}
+			if err := n.YNode().Decode(repo); err != nil {
+				return nil, errors.Wrapf(err, "Failed to decode RepoConfig in %v", a)
+			}
+			if repo.Spec.Status == nil || repo.Spec.Status.URI == "" {
+				return nil, errors.Errorf("RepoConfig %v in %v doesn't set spec.status.uri", repo.Metadata.Name, a)
+			}
+			uris = append(uris, repo.Spec.Status.URI)
+		}
+		if !isRepoConfig {
+			uris = append(uris, a)
+		}
+	}
+	return uris, nil
+}
+
+// printStatus prints a table with the status of the resources recorded at each uri.
+func printStatus(ctx context.Context, w io.Writer, uris []string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPOCONFIG\tKIND\tNAME\tCOMMIT\tLAST RECONCILE\tLAST SUCCESS\tCONDITIONS\tLAST ERROR")
+	for _, uri := range uris {
+		status, err := gitops.ReadRepoStatus(ctx, uri)
+		if err != nil {
+			return err
+		}
+		if len(status.Conditions) > 0 {
+			// The conditions of the RepoConfig summarize the status of its resources.
+			fmt.Fprintf(tw, "%v\t%v\t%v\t\t%v\t\t%v\t\n", status.RepoConfig, v1alpha1.RepoGVK.Kind, status.RepoConfig, status.UpdateTime.Local().Format(time.RFC3339), formatConditions(status.Conditions))
+		}
+		for _, r := range status.Resources {
+			commit := r.Commit
+			if len(commit) > 7 {
+				commit = commit[:7]
+			}
+			lastSuccess := "never"
+			if r.LastSuccessTime != nil {
+				lastSuccess = r.LastSuccessTime.Local().Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", status.RepoConfig, r.Kind, r.Name, commit, r.LastReconcileTime.Local().Format(time.RFC3339), lastSuccess, formatConditions(r.Conditions), r.LastError)
+		}
+	}
+	return tw.Flush()
+}
+
+// formatConditions formats the conditions as a comma separated list of type=status(reason)
+// e.g. Ready=False(ReconcileFailed),Blocked=True(AwaitingApproval).
+func formatConditions(conditions []v1alpha1.Condition) string {
+	formatted := make([]string, 0, len(conditions))
+	for _, c := range conditions {
+		f := fmt.Sprintf("%v=%v", c.Type, c.Status)
+		if c.Reason != "" {
+			f += "(" + c.Reason + ")"
+		}
+		formatted = append(formatted, f)
+	}
+	return strings.Join(formatted, ",")
+}
@@ -1,17 +1,27 @@
 package commands
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/user"
 	"path/filepath"
+	"text/tabwriter"
 	"time"
 
+	"github.com/go-git/go-git/v5"
+	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
 	"github.com/jlewi/hydros/api/v1alpha1"
-	"github.com/jlewi/monogo/files"
+	"github.com/jlewi/hydros/pkg/app"
+	"github.com/jlewi/hydros/pkg/config"
 	"github.com/jlewi/hydros/pkg/github"
 	"github.com/jlewi/hydros/pkg/gitops"
+	"github.com/jlewi/hydros/pkg/gitutil"
 	"github.com/jlewi/hydros/pkg/hydros"
+	"github.com/jlewi/hydros/pkg/keychain"
+	"github.com/jlewi/hydros/pkg/util"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
@@ -31,6 +41,10 @@ type TakeOverArgs struct {
 	KeyFile     string
 	RepoDir     string
 	Pause       time.Duration
+	// AllowExisting allows the work directory to be an existing directory that wasn't created by hydros.
+	AllowExisting bool
+	// Functions are the external KRM functions allowed by the hydros config.
+	Functions *config.FunctionsConfig
 }
 
 func NewTakeOverCmd() *cobra.Command {
@@ -39,6 +53,10 @@ func NewTakeOverCmd() *cobra.Command {
 		Use:   "takeover -f <resource.yaml>",
 		Short: "Take over the dev environment by applying the specified configuration.",
 		Run: func(cmd *cobra.Command, args []string) {
+			if err := loadConfig(cmd, opts); err != nil {
+				fmt.Printf("takeover failed; error %+v\n", err)
+				os.Exit(1)
+			}
 			if err := TakeOver(opts); err != nil {
 				fmt.Printf("takeover failed; error %+v\n", err)
 			}
@@ -53,19 +71,224 @@ func NewTakeOverCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&opts.KeyFile, "ssh-key-file", "", "", "(Optional) Path of PEM file containing ssh key used to push current changes. If blank will try to find key in ${HOME}/.ssh.")
 	cmd.Flags().StringVarP(&opts.RepoDir, "repo-dir", "", "", "(Optional) Directory containing the source repo that should be pushed. If blank it is inferred based on the path of the --file argument")
 	cmd.Flags().DurationVarP(&opts.Pause, "pause", "", 2*time.Hour, "How long to pause regular syncs. Maximum is 2 hours")
+	cmd.Flags().BoolVarP(&opts.AllowExisting, allowExistingFlag, "", false, allowExistingHelp)
 	cmd.MarkFlagRequired("file")
 	cmd.MarkFlagRequired("private-key")
+
+	cmd.AddCommand(newReleaseCmd())
+	cmd.AddCommand(newTakeoverStatusCmd())
 	return cmd
 }
 
-func TakeOver(args *TakeOverArgs) error {
+func newReleaseCmd() *cobra.Command {
+	opts := &TakeOverArgs{}
+	cmd := &cobra.Command{
+		Use:   "release --file <resource.yaml>",
+		Short: "End a takeover early and resume regular syncs.",
+		Long: `End a takeover early and resume regular syncs.
+
+Open PRs from the fork branch are closed and the manifests are hydrated from the source branch in the
+configuration ignoring the pause set by the takeover. Regular syncs resume once the PR is merged.
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := loadConfig(cmd, opts); err != nil {
+				fmt.Printf("release failed; error %+v\n", err)
+				os.Exit(1)
+			}
+			if err := Release(opts); err != nil {
+				fmt.Printf("release failed; error %+v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.WorkDir, "work-dir", "", "", "Directory where repos should be checked out")
+	cmd.Flags().StringVarP(&opts.Secret, "private-key", "", "", "Path to the file containing the secret for the GitHub App to Authenticate as.")
+	cmd.Flags().Int64VarP(&opts.GithubAppID, "ghapp-id", "", hydros.HydrosGitHubAppID, "GitHubAppId.")
+	cmd.Flags().StringVarP(&opts.File, "file", "", "", "The file containing the configuration whose takeover should be released.")
+	cmd.Flags().BoolVarP(&opts.AllowExisting, allowExistingFlag, "", false, allowExistingHelp)
+	cmd.MarkFlagRequired("file")
+	cmd.MarkFlagRequired("private-key")
+	return cmd
+}
+
+type takeoverStatusArgs struct {
+	Secret      string
+	GithubAppID int64
+	File        string
+	Dir         string
+}
+
+func newTakeoverStatusCmd() *cobra.Command {
+	opts := &takeoverStatusArgs{}
+	cmd := &cobra.Command{
+		Use:   "status [--file <resource.yaml> | --dir <resourceDir>]",
+		Short: "Report which ManifestSyncs are paused e.g. by a takeover.",
+		Long: `Report which ManifestSyncs are paused e.g. by a takeover.
+
+The status is read from the sync files in the destination repositories using the GitHub API so the
+repositories aren't cloned.
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := TakeoverStatus(opts, os.Stdout); err != nil {
+				fmt.Printf("status failed; error %+v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.Secret, "private-key", "", "", "Path to the file containing the secret for the GitHub App to Authenticate as.")
+	cmd.Flags().Int64VarP(&opts.GithubAppID, "ghapp-id", "", hydros.HydrosGitHubAppID, "GitHubAppId.")
+	cmd.Flags().StringVarP(&opts.File, "file", "", "", "The file containing the ManifestSync.")
+	cmd.Flags().StringVarP(&opts.Dir, "dir", "", "", "Directory to search for ManifestSyncs.")
+	cmd.MarkFlagRequired("private-key")
+	return cmd
+}
+
+// TakeoverStatus writes a table of the destinations of the ManifestSyncs in args.File or args.Dir to w
+// reporting which are paused, by whom and until when.
+func TakeoverStatus(args *takeoverStatusArgs, w io.Writer) error {
 	log := zapr.NewLogger(zap.L())
 
-	if args.Pause > maxPause {
-		return errors.Errorf("Pause duration is too long; maximum is %v", maxPause)
+	if (args.File == "") == (args.Dir == "") {
+		return errors.New("Exactly one of --file and --dir must be specified")
+	}
+	if err := resolvePaths(&args.File, &args.Dir, &args.Secret); err != nil {
+		return err
+	}
+
+	secret, err := keychain.Read(args.Secret)
+	if err != nil {
+		return errors.Wrapf(err, "Could not read file: %v", args.Secret)
+	}
+	manager, err := github.NewTransportManager(int64(args.GithubAppID), secret, log)
+	if err != nil {
+		log.Error(err, "TransportManager creation failed")
+		return err
+	}
+
+	syncs := []*v1alpha1.ManifestSync{}
+	if args.File != "" {
+		_, m, err := readManifestSync(args.File)
+		if err != nil {
+			return err
+		}
+		syncs = append(syncs, m)
+	} else {
+		syncs, err = findManifestSyncs(args.Dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tDESTINATION\tREPO\tPATH\tPAUSED\tUNTIL\tBY")
+	for _, m := range syncs {
+		for _, p := range gitops.GetPauseStatus(context.Background(), m, manager) {
+			if p.Err != nil {
+				log.Error(p.Err, "Failed to read the sync status", "name", p.Name, "destination", p.Destination)
+			}
+			paused := "no"
+			until := ""
+			switch {
+			case p.IsPaused(now):
+				paused = "yes"
+				until = p.PausedUntil.Local().Format(time.RFC3339)
+			case p.Err != nil:
+				paused = "unknown"
+			case !p.Synced:
+				paused = "never synced"
+			}
+			by := ""
+			if p.IsPaused(now) {
+				by = p.PausedBy
+			}
+			repo := fmt.Sprintf("%v/%v@%v", p.DestRepo.Org, p.DestRepo.Repo, p.DestRepo.Branch)
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", p.Name, p.Destination, repo, p.DestPath, paused, until, by)
+		}
+	}
+	return tw.Flush()
+}
+
+// findManifestSyncs returns the ManifestSyncs in the YAML files in dir.
+func findManifestSyncs(dir string) ([]*v1alpha1.ManifestSync, error) {
+	paths, err := util.FindYamlFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	syncs := []*v1alpha1.ManifestSync{}
+	for _, p := range paths {
+		nodes, err := util.ReadYaml(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range nodes {
+			if n.GetKind() != v1alpha1.ManifestSyncKind {
+				continue
+			}
+			m := &v1alpha1.ManifestSync{}
+			if err := n.YNode().Decode(m); err != nil {
+				return nil, errors.Wrapf(err, "Failed to decode ManifestSync %v in %v", n.GetName(), p)
+			}
+			syncs = append(syncs, m)
+		}
 	}
+	return syncs, nil
+}
 
-	secret, err := files.Read(args.Secret)
+// takeoverUser returns who is doing the takeover. It is the email configured in git for the repository in
+// repoDir if there is one and the name of the current user otherwise.
+func takeoverUser(repoDir string) string {
+	log := zapr.NewLogger(zap.L())
+	if root, err := gitutil.LocateRoot(repoDir); err == nil {
+		if r, err := git.PlainOpen(root); err == nil {
+			if u, err := gitutil.LoadUser(r); err == nil && u.Email != "" {
+				return u.Email
+			}
+		}
+	}
+	u, err := user.Current()
+	if err != nil {
+		log.Error(err, "Failed to determine the current user")
+		return ""
+	}
+	return u.Username
+}
+
+// loadConfig loads the hydros config, configures the default HTTP transports using it and sets the external KRM
+// functions in args to those allowed by it.
+func loadConfig(cmd *cobra.Command, args *TakeOverArgs) error {
+	a := app.NewApp()
+	if err := a.LoadConfig(cmd); err != nil {
+		return err
+	}
+	if err := a.SetupHTTP(); err != nil {
+		return err
+	}
+	args.Functions = a.Config.Functions
+	return nil
+}
+
+// syncerOptions returns the options for the Syncer used by the takeover commands.
+func (args *TakeOverArgs) syncerOptions(log logr.Logger) []gitops.SyncerOption {
+	opts := []gitops.SyncerOption{gitops.SyncWithWorkDir(args.WorkDir), gitops.SyncWithLogger(log), gitops.SyncWithHydrosVersion(version), gitops.SyncWithFunctions(args.Functions)}
+	if args.AllowExisting {
+		opts = append(opts, gitops.SyncWithAllowExistingWorkDir())
+	}
+	return opts
+}
+
+// Release ends the takeover of the ManifestSync in args.File.
+func Release(args *TakeOverArgs) error {
+	log := zapr.NewLogger(zap.L())
+
+	if err := resolvePaths(&args.File, &args.WorkDir, &args.Secret); err != nil {
+		return err
+	}
+
+	secret, err := keychain.Read(args.Secret)
 	if err != nil {
 		return errors.Wrapf(err, "Could not read file: %v", args.Secret)
 	}
@@ -75,39 +298,85 @@ func TakeOver(args *TakeOverArgs) error {
 		return err
 	}
 
-	manifestPath, err := filepath.Abs(args.File)
+	_, m, err := readManifestSync(args.File)
 	if err != nil {
-		return errors.Wrapf(err, "Failed to get absolute path for %v", args.File)
+		return err
+	}
+
+	log.Info("Releasing takeover")
+	syncer, err := gitops.NewSyncer(m, manager, args.syncerOptions(log)...)
+	if err != nil {
+		return err
+	}
+	return syncer.Release()
+}
+
+// readManifestSync reads the ManifestSync in path. It returns the absolute path of the file.
+func readManifestSync(path string) (string, *v1alpha1.ManifestSync, error) {
+	log := zapr.NewLogger(zap.L())
+	manifestPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "Failed to get absolute path for %v", path)
 	}
 
 	log.Info("Resolved manifest path", "manifestPath", manifestPath)
 
 	f, err := os.Open(manifestPath)
 	if err != nil {
-		return errors.Wrapf(err, "Failed to open file: %v", manifestPath)
+		return "", nil, errors.Wrapf(err, "Failed to open file: %v", manifestPath)
 	}
+	defer f.Close()
 
 	d := yaml.NewDecoder(f)
 
 	m := &v1alpha1.ManifestSync{}
 
 	if err := d.Decode(m); err != nil {
-		return errors.Wrapf(err, "Failed to decode ManifestSync from file %v", manifestPath)
+		return "", nil, errors.Wrapf(err, "Failed to decode ManifestSync from file %v", manifestPath)
 	}
+	return manifestPath, m, nil
+}
 
-	if err := gitops.SetTakeOverAnnotations(m, args.Pause); err != nil {
-		return errors.Wrapf(err, "Failed to set takeover annotations")
+func TakeOver(args *TakeOverArgs) error {
+	log := zapr.NewLogger(zap.L())
+
+	if args.Pause > maxPause {
+		return errors.Errorf("Pause duration is too long; maximum is %v", maxPause)
 	}
 
-	log.Info("Pausing automatic syncs")
-	syncer, err := gitops.NewSyncer(m, manager, gitops.SyncWithWorkDir(args.WorkDir), gitops.SyncWithLogger(log))
+	if err := resolvePaths(&args.File, &args.WorkDir, &args.Secret, &args.KeyFile); err != nil {
+		return err
+	}
+
+	secret, err := keychain.Read(args.Secret)
+	if err != nil {
+		return errors.Wrapf(err, "Could not read file: %v", args.Secret)
+	}
+	manager, err := github.NewTransportManager(int64(args.GithubAppID), secret, log)
+	if err != nil {
+		log.Error(err, "TransportManager creation failed")
+		return err
+	}
+
+	_, m, err := readManifestSync(args.File)
+	if err != nil {
+		return err
+	}
+
+	args.RepoDir, err = resolveRepoDir(args.RepoDir, args.File)
 	if err != nil {
 		return err
 	}
+	log.Info("Resolved repo dir", "repoDir", args.RepoDir)
+
+	if err := gitops.SetTakeOverAnnotations(m, args.Pause, takeoverUser(args.RepoDir)); err != nil {
+		return errors.Wrapf(err, "Failed to set takeover annotations")
+	}
 
-	if args.RepoDir == "" {
-		args.RepoDir = filepath.Dir(manifestPath)
-		log.Info("RepoDir is using default", "repoDir", args.RepoDir)
+	log.Info("Pausing automatic syncs")
+	syncer, err := gitops.NewSyncer(m, manager, args.syncerOptions(log)...)
+	if err != nil {
+		return err
 	}
 
 	if err := syncer.PushLocal(args.RepoDir, args.KeyFile); err != nil {
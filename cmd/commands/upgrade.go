@@ -0,0 +1,81 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/jlewi/hydros/pkg/app"
+	"github.com/jlewi/hydros/pkg/upgrade"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// NewUpgradeCmd creates the upgrade command.
+func NewUpgradeCmd() *cobra.Command {
+	var check bool
+	var force bool
+	cmd := &cobra.Command{
+		Use:   "upgrade",
+		Short: "Upgrade hydros to the latest release",
+		Long: `Upgrade hydros to the latest release.
+
+upgrade compares the running version of hydros to the latest GitHub release and, if it is older, replaces
+the binary with the latest release. Different versions of hydros can produce different hydrated manifests
+so the CLI and the server should be kept at the same version. Use --check to only report the versions.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := func() error {
+				app := app.NewApp()
+				defer app.Shutdown()
+				if err := app.LoadConfig(cmd); err != nil {
+					return err
+				}
+				if err := app.SetupLogging(); err != nil {
+					return err
+				}
+				if err := app.SetupHTTP(); err != nil {
+					return err
+				}
+
+				ctx := context.Background()
+				checker := upgrade.NewChecker()
+				release, err := checker.LatestRelease(ctx)
+				if err != nil {
+					return err
+				}
+				latest := release.GetTagName()
+
+				skew, compareErr := upgrade.Compare(version, latest, upgrade.DefaultMaxPatchSkew)
+				switch {
+				case compareErr != nil && !force:
+					return errors.Wrapf(compareErr, "Can't compare version %v to the latest release %v; use --force to install %v anyway", version, latest, latest)
+				case compareErr == nil && !skew.Behind && !force:
+					fmt.Fprintf(os.Stdout, "hydros %v is up to date\n", version)
+					return nil
+				}
+				fmt.Fprintf(os.Stdout, "hydros %v is installed; the latest release is %v\n", version, latest)
+				if check {
+					return nil
+				}
+
+				exe, err := os.Executable()
+				if err != nil {
+					return errors.Wrapf(err, "Failed to locate the hydros binary")
+				}
+				if err := checker.Upgrade(ctx, release, exe); err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stdout, "Upgraded %v to %v\n", exe, latest)
+				return nil
+			}()
+			if err != nil {
+				fmt.Printf("upgrade failed; error %+v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().BoolVarP(&check, "check", "", false, "Only report whether a newer release is available; don't upgrade.")
+	cmd.Flags().BoolVarP(&force, "force", "", false, "Install the latest release even if the running version is a development build or is up to date.")
+	return cmd
+}
@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/validate"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// NewValidateCmd creates the validate command.
+func NewValidateCmd() *cobra.Command {
+	var schemaKind string
+	cmd := &cobra.Command{
+		Use:   "validate <resource.yaml> <resourceDir> ...",
+		Short: "Validate hydros resources and function configs against their schemas.",
+		Long: fmt.Sprintf(`Validate hydros resources and function configs against their schemas.
+
+Unknown fields, missing required fields and invalid enum values are reported along with their line
+and column. The command exits with a non-zero status if any resource is invalid. Resources of other
+kinds are ignored.
+
+Schemas are generated from the Go types; use --schema to print the JSON schema of a kind.
+Supported kinds: %v
+`, strings.Join(validate.Kinds(), ", ")),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := func() error {
+				if schemaKind != "" {
+					s := validate.SchemaFor(v1alpha1.Group+"/"+v1alpha1.Version, schemaKind)
+					if s == nil {
+						return errors.Errorf("Unknown kind %v; supported kinds are %v", schemaKind, strings.Join(validate.Kinds(), ", "))
+					}
+					e := json.NewEncoder(os.Stdout)
+					e.SetIndent("", "  ")
+					return e.Encode(s)
+				}
+
+				if len(args) == 0 {
+					return errors.New("validate takes at least one argument which should be the file or directory YAML to validate.")
+				}
+				fieldErrs, err := validate.Paths(args)
+				if err != nil {
+					return err
+				}
+				for _, e := range fieldErrs {
+					fmt.Fprintln(os.Stdout, e.Error())
+				}
+				if len(fieldErrs) > 0 {
+					return fmt.Errorf("Found %v validation errors", len(fieldErrs))
+				}
+				return nil
+			}()
+			if err != nil {
+				fmt.Printf("Error running validate;\n %+v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&schemaKind, "schema", "", "", "Print the JSON schema for the kind instead of validating resources.")
+	return cmd
+}
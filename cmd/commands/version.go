@@ -1,15 +1,23 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/go-logr/zapr"
+	"github.com/jlewi/hydros/pkg/config"
+	"github.com/jlewi/hydros/pkg/upgrade"
+	"github.com/jlewi/hydros/pkg/util"
 	"go.uber.org/zap"
 
 	"github.com/spf13/cobra"
 )
 
+// versionCheckTimeout bounds how long the version check can delay a command.
+const versionCheckTimeout = 5 * time.Second
+
 // N.B these will get set by goreleaser
 // https://goreleaser.com/cookbooks/using-main.version/?h=using+main.version
 var (
@@ -35,3 +43,29 @@ func logVersion() {
 	log := zapr.NewLogger(zap.L())
 	log.Info("binary version", "version", version, "commit", commit, "date", date, "builtBy", builtBy)
 }
+
+// checkVersion warns if the binary is significantly behind the latest release of hydros. The check is best effort;
+// failures e.g. because GitHub can't be reached are only logged at debug level.
+func checkVersion(cfg *config.Config) {
+	log := zapr.NewLogger(zap.L())
+	opts := []upgrade.Option{upgrade.WithCacheDir(cfg.GetConfigDir())}
+	if vc := cfg.VersionCheck; vc != nil {
+		if vc.Disabled {
+			return
+		}
+		if vc.MaxPatchSkew > 0 {
+			opts = append(opts, upgrade.WithMaxPatchSkew(vc.MaxPatchSkew))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), versionCheckTimeout)
+	defer cancel()
+	skew, err := upgrade.NewChecker(opts...).Check(ctx, version)
+	if err != nil {
+		log.V(util.Debug).Info("Failed to check for a newer version of hydros", "err", err)
+		return
+	}
+	if skew.Significant {
+		log.Info("WARNING: hydros is out of date; different versions can produce different hydrated manifests. Run hydros upgrade to update it.", "version", skew.Current, "latest", skew.Latest)
+	}
+}
@@ -6,7 +6,7 @@ import (
 	"io"
 	"os"
 
-	"github.com/jlewi/monogo/files"
+	"github.com/jlewi/hydros/pkg/keychain"
 
 	"github.com/jlewi/hydros/pkg/github"
 	"github.com/jlewi/hydros/pkg/hydros"
@@ -29,7 +29,7 @@ func NewAppTokenCmd(w io.Writer, level *string, devLogger *bool) *cobra.Command
 		Run: func(cmd *cobra.Command, args []string) {
 			log := util.SetupLogger(*level, *devLogger)
 			err := func() error {
-				secretByte, err := files.Read(secret)
+				secretByte, err := keychain.Read(secret)
 				if err != nil {
 					return err
 				}
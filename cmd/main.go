@@ -125,11 +125,19 @@ func init() {
 	rootCmd.AddCommand(newVersionCmd(os.Stdout))
 	rootCmd.AddCommand(githubCmds.NewAppTokenCmd(os.Stdout, &gOptions.level, &gOptions.devLogger))
 	rootCmd.AddCommand(commands.NewBuildCmd())
+	rootCmd.AddCommand(commands.NewContextCmd())
+	rootCmd.AddCommand(commands.NewImageCmd())
 	rootCmd.AddCommand(commands.NewTakeOverCmd())
 	rootCmd.AddCommand(commands.NewHydrosServerCmd())
 	rootCmd.AddCommand(commands.NewCloneCmd())
 	rootCmd.AddCommand(commands.NewVersionCmd("hydros", os.Stdout))
 	rootCmd.AddCommand(commands.NewConfigCmd())
+	rootCmd.AddCommand(commands.NewDevCmd())
+	rootCmd.AddCommand(commands.NewValidateCmd())
+	rootCmd.AddCommand(commands.NewSchemaCmd())
+	rootCmd.AddCommand(commands.NewInitCmd())
+	rootCmd.AddCommand(commands.NewUpgradeCmd())
+	rootCmd.AddCommand(commands.NewStatusCmd())
 
 	rootCmd.PersistentFlags().BoolVar(&gOptions.devLogger, "dev-logger", false, "If true configure the logger for development; i.e. non-json output")
 	rootCmd.PersistentFlags().StringVarP(&gOptions.level, config.LevelFlagName, "", "info", "Log level: error info or debug")
@@ -10,10 +10,12 @@ import (
 	"go.uber.org/zap/zapcore"
 
 	"github.com/jlewi/hydros/pkg/controllers"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
 	"github.com/jlewi/hydros/api/v1alpha1"
 	"github.com/jlewi/hydros/pkg/config"
@@ -21,8 +23,8 @@ import (
 	"github.com/jlewi/hydros/pkg/github"
 	"github.com/jlewi/hydros/pkg/gitops"
 	"github.com/jlewi/hydros/pkg/images"
+	"github.com/jlewi/hydros/pkg/keychain"
 	"github.com/jlewi/hydros/pkg/util"
-	"github.com/jlewi/monogo/files"
 	"github.com/jlewi/monogo/gcp/logging"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -35,6 +37,16 @@ type App struct {
 	Config     *config.Config
 	Registry   *controllers.Registry
 	logClosers []logCloser
+	// AllowExistingWorkDir allows ManifestSyncs to use work directories that weren't created by hydros.
+	AllowExistingWorkDir bool
+	// HydrosVersion is the version of hydros. It is recorded in the status of the manifests it hydrates.
+	HydrosVersion string
+	// Selector restricts ApplyPaths to the hydros resources whose labels match it. RepoConfigs are always applied
+	// but only reconcile the resources that match it. If it is nil all resources are applied.
+	Selector labels.Selector
+	// EmergencyReason if set runs the ManifestSyncs as emergency syncs which bypass pauses. It is the reason for
+	// the emergency; it is logged and recorded in the PRs.
+	EmergencyReason string
 }
 
 type logCloser func()
@@ -195,6 +207,15 @@ func (a *App) createJSONCoreLogger(paths []string) (zapcore.Core, error) {
 	return core, nil
 }
 
+// SetupHTTP configures the default HTTP transports based on the config.
+// This should be called before any clients are created so they all pick up the same proxy and TLS settings.
+func (a *App) SetupHTTP() error {
+	if a.Config == nil {
+		return errors.New("Config is nil; call LoadConfig first")
+	}
+	return util.SetDefaultTransport(a.Config.HTTP)
+}
+
 // SetupRegistry sets up the registry with a list of registered controllers
 func (a *App) SetupRegistry() error {
 	if a.Config == nil {
@@ -203,7 +224,7 @@ func (a *App) SetupRegistry() error {
 	a.Registry = &controllers.Registry{}
 
 	// Register controllers
-	image, err := images.NewController()
+	image, err := images.NewController(images.WithRegistryMirrors(a.Config.RegistryMirrors))
 	if err != nil {
 		return err
 	}
@@ -219,6 +240,14 @@ func (a *App) SetupRegistry() error {
 		return err
 	}
 
+	promoter, err := images.NewPromoter()
+	if err != nil {
+		return err
+	}
+	if err := a.Registry.Register(v1alpha1.ImagePromotionGVK, promoter); err != nil {
+		return err
+	}
+
 	releaser, err := github.NewReleaser(*a.Config)
 	if err != nil {
 		return err
@@ -258,10 +287,19 @@ func (a *App) ApplyPaths(ctx context.Context, inPaths []string, period time.Dura
 		paths = append(paths, newPaths...)
 	}
 
+	var scheduler *gitops.Scheduler
+	if period > 0 {
+		var err error
+		scheduler, err = gitops.NewScheduler(a.Config.Scheduler)
+		if err != nil {
+			return err
+		}
+	}
+
 	syncNames := map[string]string{}
 
 	for _, path := range paths {
-		err := a.apply(ctx, path, syncNames, period, force)
+		err := a.apply(ctx, path, syncNames, scheduler, period, force)
 		if err != nil {
 			log.Error(err, "Apply failed", "path", path)
 		}
@@ -279,7 +317,9 @@ func (a *App) ApplyPaths(ctx context.Context, inPaths []string, period time.Dura
 	return nil
 }
 
-func (a *App) apply(ctx context.Context, path string, syncNames map[string]string, period time.Duration, force bool) error {
+// apply applies the resources in path. If period > 0 ManifestSyncs and RepoConfigs are run periodically
+// using scheduler.
+func (a *App) apply(ctx context.Context, path string, syncNames map[string]string, scheduler *gitops.Scheduler, period time.Duration, force bool) error {
 	if a.Registry == nil {
 		return errors.New("Registry is nil; call SetupRegistry first")
 	}
@@ -302,6 +342,10 @@ func (a *App) apply(ctx context.Context, path string, syncNames map[string]strin
 			continue
 		}
 		log.Info("Read resource", "meta", m)
+		if a.Selector != nil && m.Kind != v1alpha1.RepoGVK.Kind && !a.Selector.Matches(labels.Set(m.Labels)) {
+			log.Info("Skipping resource because it doesn't match the selector", "kind", m.Kind, "name", m.Name, "selector", a.Selector.String())
+			continue
+		}
 		switch m.Kind {
 		case v1alpha1.ManifestSyncKind:
 			manifestSync := &v1alpha1.ManifestSync{}
@@ -320,7 +364,7 @@ func (a *App) apply(ctx context.Context, path string, syncNames map[string]strin
 			}
 			syncNames[name] = path
 
-			secret, err := files.Read(a.Config.GitHub.PrivateKey)
+			secret, err := keychain.Read(a.Config.GitHub.PrivateKey)
 			if err != nil {
 				return errors.Wrapf(err, "Could not read file: %v", a.Config.GitHub.PrivateKey)
 			}
@@ -330,7 +374,15 @@ func (a *App) apply(ctx context.Context, path string, syncNames map[string]strin
 				return err
 			}
 
-			syncer, err := gitops.NewSyncer(manifestSync, manager, gitops.SyncWithWorkDir(a.Config.GetWorkDir()), gitops.SyncWithLogger(log))
+			syncOpts := []gitops.SyncerOption{gitops.SyncWithWorkDir(a.Config.GetWorkDir()), gitops.SyncWithLogger(log), gitops.SyncWithImageOptions(images.WithRegistryMirrors(a.Config.RegistryMirrors)), gitops.SyncWithFunctions(a.Config.Functions)}
+			if a.AllowExistingWorkDir {
+				syncOpts = append(syncOpts, gitops.SyncWithAllowExistingWorkDir())
+			}
+			syncOpts = append(syncOpts, gitops.SyncWithHydrosVersion(a.HydrosVersion))
+			if a.EmergencyReason != "" {
+				syncOpts = append(syncOpts, gitops.SyncWithEmergency(a.EmergencyReason))
+			}
+			syncer, err := gitops.NewSyncer(manifestSync, manager, syncOpts...)
 			if err != nil {
 				log.Error(err, "Failed to create syncer")
 				allErrors.AddCause(err)
@@ -338,7 +390,13 @@ func (a *App) apply(ctx context.Context, path string, syncNames map[string]strin
 			}
 
 			if period > 0 {
-				go syncer.RunPeriodically(period)
+				scheduler.Start(ctx, gitops.Job{
+					Name: syncer.Name(),
+					Keys: syncer.ForkBranches(),
+					Run: func() error {
+						return syncer.RunOnce(false)
+					},
+				}, manifestSync.GetSyncPeriod(period))
 			} else {
 				if err := syncer.RunOnce(force); err != nil {
 					log.Error(err, "Failed to run Sync")
@@ -354,13 +412,23 @@ func (a *App) apply(ctx context.Context, path string, syncNames map[string]strin
 				continue
 			}
 
-			c, err := gitops.NewRepoController(*a.Config, a.Registry, repo)
+			c, err := gitops.NewRepoController(*a.Config, a.Registry, repo, gitops.SyncWithHydrosVersion(a.HydrosVersion))
 			if err != nil {
 				return err
 			}
+			if a.Selector != nil {
+				c.Restrict(a.Selector)
+			}
 
 			if period > 0 {
-				go c.RunPeriodically(context.Background(), period)
+				rCtx := logr.NewContext(context.Background(), log.WithValues("repoConfig", repo.Metadata.Name))
+				scheduler.Start(ctx, gitops.Job{
+					Name: repo.Metadata.Name,
+					Keys: []string{repo.Spec.Repo},
+					Run: func() error {
+						return c.Reconcile(rCtx)
+					},
+				}, repo.GetSyncPeriod(period))
 			} else {
 				if err := c.Reconcile(context.Background()); err != nil {
 					return err
@@ -0,0 +1,313 @@
+// Package apply applies hydrated manifests to a cluster with server-side apply.
+package apply
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/kustomize/kyaml/kio/filters"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// defaultNamespace is the namespace of namespaced resources which don't specify one.
+	defaultNamespace = "default"
+	// maxLabelLength is the maximum length of a label value.
+	maxLabelLength = 63
+)
+
+var invalidLabelChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// ApplierOption is an option for the Applier.
+type ApplierOption func(a *Applier) error
+
+// WithFieldManager sets the field manager of the server-side apply. Defaults to v1alpha1.DefaultFieldManager.
+func WithFieldManager(manager string) ApplierOption {
+	return func(a *Applier) error {
+		if manager != "" {
+			a.fieldManager = manager
+		}
+		return nil
+	}
+}
+
+// WithPrune deletes the resources labeled as applied by the inventory which aren't in the applied manifests.
+func WithPrune() ApplierOption {
+	return func(a *Applier) error {
+		a.prune = true
+		return nil
+	}
+}
+
+// Applier applies manifests to a cluster with server-side apply. Every applied resource is labeled with
+// v1alpha1.AppliedByLabel set to the inventory, typically the name of the ManifestSync, so the resources which
+// were applied previously but are no longer in the manifests can be pruned.
+type Applier struct {
+	client       dynamic.Interface
+	discovery    discovery.DiscoveryInterface
+	inventory    string
+	fieldManager string
+	prune        bool
+	log          logr.Logger
+}
+
+// Result is the result of applying manifests.
+type Result struct {
+	// Applied are the resources that were applied as <kind>/<namespace>/<name> or, if they aren't namespaced,
+	// <kind>/<name>.
+	Applied []string
+	// Pruned are the resources that were deleted.
+	Pruned []string
+}
+
+// NewApplier creates an applier for the cluster accessed with the clients.
+func NewApplier(client dynamic.Interface, disc discovery.DiscoveryInterface, inventory string, opts ...ApplierOption) (*Applier, error) {
+	if client == nil {
+		return nil, errors.New("client is required")
+	}
+	if disc == nil {
+		return nil, errors.New("discovery is required")
+	}
+	if inventory == "" {
+		return nil, errors.New("inventory is required")
+	}
+	a := &Applier{
+		client:       client,
+		discovery:    disc,
+		inventory:    labelValue(inventory),
+		fieldManager: v1alpha1.DefaultFieldManager,
+		log:          zapr.NewLogger(zap.L()),
+	}
+	for _, o := range opts {
+		if err := o(a); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// NewApplierForCluster creates an applier for the cluster in the config.
+func NewApplierForCluster(cluster *v1alpha1.ClusterApply, inventory string) (*Applier, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = cluster.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: cluster.Context}
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to load the config of the cluster")
+	}
+	client, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create Kubernetes client")
+	}
+	disc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create Kubernetes discovery client")
+	}
+	opts := []ApplierOption{WithFieldManager(cluster.FieldManager)}
+	if cluster.Prune {
+		opts = append(opts, WithPrune())
+	}
+	return NewApplier(client, disc, inventory, opts...)
+}
+
+// Apply applies the resources. Resources annotated as local config aren't applied. Namespaces and
+// CustomResourceDefinitions are applied first so the resources using them can be applied. If pruning is enabled
+// the resources applied previously which aren't in nodes are deleted once all the resources have been applied.
+func (a *Applier) Apply(ctx context.Context, nodes []*yaml.RNode) (*Result, error) {
+	nodes, err := (&filters.IsLocalConfig{IncludeLocalConfig: false, ExcludeNonLocalConfig: false}).Filter(nodes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to filter local config")
+	}
+	nodes = sortForApply(nodes)
+
+	groupResources, err := restmapper.GetAPIGroupResources(a.discovery)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to discover the resources of the cluster")
+	}
+	mapper := restmapper.NewDiscoveryRESTMapper(groupResources)
+
+	result := &Result{}
+	applied := map[string]bool{}
+	for _, n := range nodes {
+		if n.GetKind() == "" || n.GetName() == "" {
+			continue
+		}
+		obj := n.Copy()
+		if err := obj.PipeE(yaml.SetLabel(v1alpha1.AppliedByLabel, a.inventory)); err != nil {
+			return result, errors.Wrapf(err, "Failed to label %v/%v", n.GetKind(), n.GetName())
+		}
+		for _, k := range []string{kioutil.PathAnnotation, kioutil.LegacyPathAnnotation, kioutil.IndexAnnotation, kioutil.LegacyIndexAnnotation, kioutil.IdAnnotation, kioutil.LegacyIdAnnotation} {
+			if err := obj.PipeE(yaml.ClearAnnotation(k)); err != nil {
+				return result, errors.Wrapf(err, "Failed to clear annotation %v", k)
+			}
+		}
+		if err := yaml.ClearEmptyAnnotations(obj); err != nil {
+			return result, errors.Wrapf(err, "Failed to clear empty annotations")
+		}
+
+		gvk := schema.FromAPIVersionAndKind(obj.GetApiVersion(), obj.GetKind())
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return result, errors.Wrapf(err, "Failed to find the resource for %v", gvk)
+		}
+		namespace := ""
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			namespace = obj.GetNamespace()
+			if namespace == "" {
+				namespace = defaultNamespace
+			}
+		}
+
+		data, err := obj.MarshalJSON()
+		if err != nil {
+			return result, errors.Wrapf(err, "Failed to marshal %v/%v", n.GetKind(), n.GetName())
+		}
+		force := true
+		_, err = a.client.Resource(mapping.Resource).Namespace(namespace).Patch(ctx, obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+			FieldManager: a.fieldManager,
+			Force:        &force,
+		})
+		id := resourceID(gvk.Kind, namespace, obj.GetName())
+		if err != nil {
+			return result, errors.Wrapf(err, "Failed to apply %v", id)
+		}
+		a.log.V(1).Info("Applied resource", "resource", id)
+		result.Applied = append(result.Applied, id)
+		applied[gvk.Group+"/"+id] = true
+	}
+
+	if !a.prune {
+		return result, nil
+	}
+	pruned, err := a.pruneResources(ctx, applied)
+	result.Pruned = pruned
+	return result, err
+}
+
+// pruneResources deletes the resources labeled as applied by the inventory which aren't in applied. The keys of
+// applied are <group>/<kind>/<namespace>/<name>.
+func (a *Applier) pruneResources(ctx context.Context, applied map[string]bool) ([]string, error) {
+	lists, err := discovery.ServerPreferredResources(a.discovery)
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, errors.Wrapf(err, "Failed to discover the resources of the cluster")
+		}
+		// N.B. Resources in the groups that couldn't be discovered e.g. because an aggregated API server is
+		// down aren't pruned.
+		a.log.Error(err, "Failed to discover some resources of the cluster; they won't be pruned")
+	}
+
+	selector := v1alpha1.AppliedByLabel + "=" + a.inventory
+	pruned := []string{}
+	for _, l := range lists {
+		gv, err := schema.ParseGroupVersion(l.GroupVersion)
+		if err != nil {
+			return pruned, errors.Wrapf(err, "Failed to parse group version %v", l.GroupVersion)
+		}
+		for _, r := range l.APIResources {
+			// N.B. Subresources e.g. deployments/status can't be listed.
+			if strings.Contains(r.Name, "/") || !hasVerbs(r, "list", "delete") {
+				continue
+			}
+			gvr := gv.WithResource(r.Name)
+			items, err := a.client.Resource(gvr).List(ctx, metav1.ListOptions{LabelSelector: selector})
+			if err != nil {
+				return pruned, errors.Wrapf(err, "Failed to list %v", gvr)
+			}
+			for _, item := range items.Items {
+				id := resourceID(r.Kind, item.GetNamespace(), item.GetName())
+				if applied[gv.Group+"/"+id] {
+					continue
+				}
+				if err := a.delete(ctx, gvr, item); err != nil {
+					return pruned, errors.Wrapf(err, "Failed to prune %v", id)
+				}
+				a.log.Info("Pruned resource", "resource", id)
+				pruned = append(pruned, id)
+			}
+		}
+	}
+	return pruned, nil
+}
+
+func (a *Applier) delete(ctx context.Context, gvr schema.GroupVersionResource, item unstructured.Unstructured) error {
+	policy := metav1.DeletePropagationBackground
+	err := a.client.Resource(gvr).Namespace(item.GetNamespace()).Delete(ctx, item.GetName(), metav1.DeleteOptions{
+		PropagationPolicy: &policy,
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// sortForApply returns the nodes with the Namespaces first followed by the CustomResourceDefinitions. The order
+// of the other resources is preserved.
+func sortForApply(nodes []*yaml.RNode) []*yaml.RNode {
+	sorted := make([]*yaml.RNode, len(nodes))
+	copy(sorted, nodes)
+	rank := func(n *yaml.RNode) int {
+		switch n.GetKind() {
+		case "Namespace":
+			return 0
+		case "CustomResourceDefinition":
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rank(sorted[i]) < rank(sorted[j])
+	})
+	return sorted
+}
+
+func hasVerbs(r metav1.APIResource, verbs ...string) bool {
+	for _, v := range verbs {
+		found := false
+		for _, rv := range r.Verbs {
+			found = found || rv == v
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// resourceID returns the id of the resource as <kind>/<namespace>/<name> or, if it isn't namespaced, <kind>/<name>.
+func resourceID(kind string, namespace string, name string) string {
+	if namespace == "" {
+		return fmt.Sprintf("%v/%v", kind, name)
+	}
+	return fmt.Sprintf("%v/%v/%v", kind, namespace, name)
+}
+
+// labelValue converts the inventory into a valid label value.
+func labelValue(inventory string) string {
+	v := invalidLabelChars.ReplaceAllString(inventory, "-")
+	if len(v) > maxLabelLength {
+		v = v[:maxLabelLength]
+	}
+	// Label values must begin and end with an alphanumeric character.
+	return strings.Trim(v, "-_.")
+}
@@ -0,0 +1,174 @@
+package apply
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+)
+
+const manifests = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+  annotations:
+    config.kubernetes.io/path: prod/web.yaml
+spec:
+  replicas: 1
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+data:
+  key: value
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: prod
+---
+apiVersion: hydros.dev/v1alpha1
+kind: ManifestSync
+metadata:
+  name: local
+  annotations:
+    config.kubernetes.io/local-config: "true"
+`
+
+func configMap(namespace string, name string, appliedBy string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion("v1")
+	u.SetKind("ConfigMap")
+	u.SetNamespace(namespace)
+	u.SetName(name)
+	u.SetLabels(map[string]string{v1alpha1.AppliedByLabel: appliedBy})
+	return u
+}
+
+func Test_Applier(t *testing.T) {
+	disc := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{}}
+	disc.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "namespaces", Kind: "Namespace", Namespaced: false, Verbs: []string{"list", "delete", "patch"}},
+				{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: []string{"list", "delete", "patch"}},
+			},
+		},
+		{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "deployments", Kind: "Deployment", Namespaced: true, Verbs: []string{"list", "delete", "patch"}},
+				{Name: "deployments/status", Kind: "Deployment", Namespaced: true, Verbs: []string{"get", "patch"}},
+			},
+		},
+	}
+
+	listKinds := map[schema.GroupVersionResource]string{
+		{Version: "v1", Resource: "namespaces"}:                 "NamespaceList",
+		{Version: "v1", Resource: "configmaps"}:                 "ConfigMapList",
+		{Group: "apps", Version: "v1", Resource: "deployments"}: "DeploymentList",
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds,
+		configMap("default", "web", "sync"),
+		configMap("prod", "stale", "sync"),
+		configMap("prod", "other", "other-sync"),
+	)
+
+	// The fake client doesn't support server-side apply so record the patches instead.
+	type patch struct {
+		Resource  string
+		Namespace string
+		Name      string
+		Labels    map[string]string
+	}
+	patches := []patch{}
+	client.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		p := action.(clienttesting.PatchAction)
+		if p.GetPatchType() != types.ApplyPatchType {
+			t.Errorf("Expected an apply patch; got %v", p.GetPatchType())
+		}
+		u := &unstructured.Unstructured{}
+		if err := u.UnmarshalJSON(p.GetPatch()); err != nil {
+			t.Fatalf("Failed to unmarshal patch; %v", err)
+		}
+		if _, ok := u.GetAnnotations()["config.kubernetes.io/path"]; ok {
+			t.Errorf("Annotations added when reading the manifests should be removed; got %v", u.GetAnnotations())
+		}
+		patches = append(patches, patch{Resource: p.GetResource().Resource, Namespace: p.GetNamespace(), Name: p.GetName(), Labels: u.GetLabels()})
+		return true, u, nil
+	})
+
+	nodes, err := (&kio.ByteReader{Reader: strings.NewReader(manifests)}).Read()
+	if err != nil {
+		t.Fatalf("Failed to read manifests; %v", err)
+	}
+
+	a, err := NewApplier(client, disc, "sync", WithPrune())
+	if err != nil {
+		t.Fatalf("Failed to create applier; %v", err)
+	}
+	result, err := a.Apply(context.Background(), nodes)
+	if err != nil {
+		t.Fatalf("Apply failed; %+v", err)
+	}
+
+	labels := map[string]string{v1alpha1.AppliedByLabel: "sync"}
+	expectedPatches := []patch{
+		{Resource: "namespaces", Name: "prod", Labels: labels},
+		{Resource: "deployments", Namespace: "prod", Name: "web", Labels: labels},
+		{Resource: "configmaps", Namespace: "default", Name: "web", Labels: labels},
+	}
+	if d := cmp.Diff(expectedPatches, patches); d != "" {
+		t.Errorf("Unexpected patches; diff:\n%v", d)
+	}
+
+	expected := &Result{
+		Applied: []string{"Namespace/prod", "Deployment/prod/web", "ConfigMap/default/web"},
+		Pruned:  []string{"ConfigMap/prod/stale"},
+	}
+	if d := cmp.Diff(expected, result); d != "" {
+		t.Errorf("Unexpected result; diff:\n%v", d)
+	}
+
+	remaining, err := client.Resource(schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list configmaps; %v", err)
+	}
+	names := []string{}
+	for _, i := range remaining.Items {
+		names = append(names, i.GetNamespace()+"/"+i.GetName())
+	}
+	sort.Strings(names)
+	if d := cmp.Diff([]string{"default/web", "prod/other"}, names); d != "" {
+		t.Errorf("Unexpected configmaps after pruning; diff:\n%v", d)
+	}
+}
+
+func Test_labelValue(t *testing.T) {
+	cases := map[string]string{
+		"sync":      "sync",
+		"team/sync": "team-sync",
+		"-sync-":    "sync",
+		"a-very-long-name-that-is-longer-than-the-maximum-length-of-a-label": "a-very-long-name-that-is-longer-than-the-maximum-length-of-a-la",
+	}
+	for in, expected := range cases {
+		if actual := labelValue(in); actual != expected {
+			t.Errorf("labelValue(%v) = %v; want %v", in, actual, expected)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// Package azure provides helpers for working with Azure e.g. resolving images in Azure Container Registry (ACR).
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/go-logr/zapr"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	acrRegistrySuffix = ".azurecr.io"
+
+	// acrScope is the scope of the AAD token that is exchanged for an ACR refresh token.
+	acrScope = "https://containerregistry.azure.net/.default"
+)
+
+// manifestMediaTypes are the manifest types accepted when resolving a tag. Multi-arch images are resolved to
+// the digest of the index.
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// excludedTags are tags that are likely to be mutable. When resolving images using the MutableTagStrategy these
+// tags are never used as the tag of the resolved image.
+var excludedTags = map[string]bool{"latest": true, "live": true, "prod": true, "dev": true, "staging": true}
+
+// IsACR returns true if the registry is an Azure Container Registry.
+func IsACR(registry string) bool {
+	return strings.HasSuffix(registry, acrRegistrySuffix)
+}
+
+// ImageResolverOption is an option for the ImageResolver.
+type ImageResolverOption func(r *ImageResolver) error
+
+// WithCredential sets the credential used to get AAD tokens. Defaults to azidentity.DefaultAzureCredential.
+func WithCredential(cred azcore.TokenCredential) ImageResolverOption {
+	return func(r *ImageResolver) error {
+		r.cred = cred
+		return nil
+	}
+}
+
+// WithHTTPClient sets the client used to talk to the registry. Defaults to http.DefaultClient.
+func WithHTTPClient(client *http.Client) ImageResolverOption {
+	return func(r *ImageResolver) error {
+		r.client = client
+		return nil
+	}
+}
+
+// ImageResolver resolves images in ACR to their digests using the registry's v2 API.
+type ImageResolver struct {
+	cred   azcore.TokenCredential
+	client *http.Client
+}
+
+// NewImageResolver creates a new resolver.
+func NewImageResolver(opts ...ImageResolverOption) (*ImageResolver, error) {
+	r := &ImageResolver{
+		client: http.DefaultClient,
+	}
+	for _, o := range opts {
+		if err := o(r); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.cred == nil {
+		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to create the default Azure credential")
+		}
+		r.cred = cred
+	}
+	return r, nil
+}
+
+// ResolveImageToSha resolves the image to a sha. If the strategy is MutableTagStrategy the tag of the resolved
+// image is replaced with another tag of the same image that is less likely to be mutable, if there is one.
+func (r *ImageResolver) ResolveImageToSha(ctx context.Context, ref util.DockerImageRef, strategy v1alpha1.Strategy) (util.DockerImageRef, error) {
+	if !IsACR(ref.Registry) {
+		return ref, errors.Errorf("Registry %v isn't an Azure Container Registry; registry must end with %v", ref.Registry, acrRegistrySuffix)
+	}
+	log := zapr.NewLogger(zap.L())
+
+	token, err := r.accessToken(ctx, ref.Registry, ref.Repo)
+	if err != nil {
+		return ref, err
+	}
+
+	resolved := ref
+	u := fmt.Sprintf("https://%v/v2/%v/manifests/%v", ref.Registry, ref.Repo, ref.Tag)
+	log.Info("Getting manifest", "url", u)
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return ref, errors.Wrapf(err, "Failed to create request for %v", u)
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return ref, errors.Wrapf(err, "Failed to get manifest %v", u)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ref, errors.Errorf("Failed to get manifest %v; %v", u, resp.Status)
+	}
+
+	resolved.Sha = resp.Header.Get("Docker-Content-Digest")
+	if resolved.Sha == "" {
+		return ref, errors.Errorf("Response for manifest %v is missing the Docker-Content-Digest header", u)
+	}
+
+	if strategy != v1alpha1.MutableTagStrategy {
+		return resolved, nil
+	}
+
+	// Replace the mutable tag with another tag for the same image. The tag is just helpful for humans; the sha
+	// takes precedence.
+	tags, err := r.tags(ctx, token, ref.Registry, ref.Repo, resolved.Sha)
+	if err != nil {
+		return resolved, err
+	}
+	for _, t := range tags {
+		if t == ref.Tag {
+			continue
+		}
+		if !excludedTags[t] {
+			resolved.Tag = t
+			break
+		}
+	}
+	return resolved, nil
+}
+
+// accessToken gets a token for pulling repo from the registry. The AAD token for the credential is exchanged
+// for an ACR refresh token which is then exchanged for an access token scoped to the repository.
+// See https://github.com/Azure/acr/blob/main/docs/AAD-OAuth.md
+func (r *ImageResolver) accessToken(ctx context.Context, registry string, repo string) (string, error) {
+	aadToken, err := r.cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{acrScope}})
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to get AAD token for registry %v", registry)
+	}
+
+	exchange := struct {
+		RefreshToken string `json:"refresh_token"`
+	}{}
+	if err := r.postForm(ctx, fmt.Sprintf("https://%v/oauth2/exchange", registry), url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {registry},
+		"access_token": {aadToken.Token},
+	}, &exchange); err != nil {
+		return "", err
+	}
+
+	token := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := r.postForm(ctx, fmt.Sprintf("https://%v/oauth2/token", registry), url.Values{
+		"grant_type":    {"refresh_token"},
+		"service":       {registry},
+		"scope":         {fmt.Sprintf("repository:%v:pull,metadata_read", repo)},
+		"refresh_token": {exchange.RefreshToken},
+	}, &token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// tags returns the tags of the image with the digest.
+func (r *ImageResolver) tags(ctx context.Context, token string, registry string, repo string, digest string) ([]string, error) {
+	u := fmt.Sprintf("https://%v/acr/v1/%v/_manifests/%v", registry, repo, digest)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create request for %v", u)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get manifest attributes %v", u)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Failed to get manifest attributes %v; %v", u, resp.Status)
+	}
+
+	attributes := struct {
+		Manifest struct {
+			Tags []string `json:"tags"`
+		} `json:"manifest"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&attributes); err != nil {
+		return nil, errors.Wrapf(err, "Failed to decode manifest attributes %v", u)
+	}
+	return attributes.Manifest.Tags, nil
+}
+
+// postForm posts the form to u and decodes the JSON response into result.
+func (r *ImageResolver) postForm(ctx context.Context, u string, form url.Values, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create request for %v", u)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to post %v", u)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return errors.Errorf("Failed to post %v; %v: %s", u, resp.Status, body)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return errors.Wrapf(err, "Failed to decode response from %v", u)
+	}
+	return nil
+}
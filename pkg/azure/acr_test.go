@@ -0,0 +1,116 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/util"
+)
+
+type fakeCredential struct{}
+
+func (c *fakeCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "aad-token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// handlerTransport serves all requests with the handler.
+type handlerTransport struct {
+	h http.Handler
+}
+
+func (t *handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.h.ServeHTTP(rec, req)
+	return rec.Result(), nil
+}
+
+func Test_ResolveImageToSha(t *testing.T) {
+	const digest = "sha256:1eaea2d03772c90f262bc17879e7a98129cec0d1db89611ed1ec6b206f5f1609"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/exchange", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("access_token") != "aad-token" || r.FormValue("service") != "acme.azurecr.io" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"refresh_token": "refresh-token"})
+	})
+	mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("refresh_token") != "refresh-token" || r.FormValue("scope") != "repository:images/hercules:pull,metadata_read" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access-token"})
+	})
+	mux.HandleFunc("/v2/images/hercules/manifests/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/images/hercules/manifests/latest", "/v2/images/hercules/manifests/d891862":
+			w.Header().Set("Docker-Content-Digest", digest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	mux.HandleFunc("/acr/v1/images/hercules/_manifests/"+digest, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"manifest": map[string]interface{}{
+				"digest": digest,
+				"tags":   []string{"latest", "prod", "d891862"},
+			},
+		})
+	})
+
+	resolver, err := NewImageResolver(WithCredential(&fakeCredential{}), WithHTTPClient(&http.Client{Transport: &handlerTransport{h: mux}}))
+	if err != nil {
+		t.Fatalf("Failed to create resolver; %v", err)
+	}
+
+	type testCase struct {
+		name     string
+		ref      util.DockerImageRef
+		strategy v1alpha1.Strategy
+		expected util.DockerImageRef
+	}
+
+	testCases := []testCase{
+		{
+			name:     "mutable-tag",
+			ref:      util.DockerImageRef{Registry: "acme.azurecr.io", Repo: "images/hercules", Tag: "latest"},
+			strategy: v1alpha1.MutableTagStrategy,
+			expected: util.DockerImageRef{Registry: "acme.azurecr.io", Repo: "images/hercules", Tag: "d891862", Sha: digest},
+		},
+		{
+			name:     "source-commit",
+			ref:      util.DockerImageRef{Registry: "acme.azurecr.io", Repo: "images/hercules", Tag: "d891862"},
+			strategy: v1alpha1.SourceCommitStrategy,
+			expected: util.DockerImageRef{Registry: "acme.azurecr.io", Repo: "images/hercules", Tag: "d891862", Sha: digest},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := resolver.ResolveImageToSha(context.Background(), c.ref, c.strategy)
+			if err != nil {
+				t.Fatalf("Failed to resolve image; %v", err)
+			}
+			if d := cmp.Diff(c.expected, actual); d != "" {
+				t.Errorf("Unexpected diff:\n%v", d)
+			}
+		})
+	}
+
+	if _, err := resolver.ResolveImageToSha(context.Background(), util.DockerImageRef{Registry: "acme.azurecr.io", Repo: "images/hercules", Tag: "missing"}, v1alpha1.SourceCommitStrategy); err == nil {
+		t.Errorf("Expected an error resolving a missing tag")
+	}
+}
@@ -0,0 +1,190 @@
+// Package callbacks sends the results of reconciles to the HTTP endpoints configured in the callbacks of hydros
+// resources. This lets external systems e.g. deployment pipelines or ticketing systems chain off hydros events
+// without polling.
+package callbacks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/keychain"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ResultKind is the kind of the payload sent to callbacks.
+	ResultKind = "ReconcileResult"
+
+	// SignatureHeader is the header containing the signature of the payload.
+	SignatureHeader = "X-Hydros-Signature-256"
+	// EventHeader is the header containing the kind of the resource that was reconciled.
+	EventHeader = "X-Hydros-Event"
+
+	signaturePrefix = "sha256="
+	userAgent       = "hydros-callbacks"
+	defaultTimeout  = 30 * time.Second
+	// maxErrorBody is how much of the body of a failed response is included in the error.
+	maxErrorBody = 512
+)
+
+// Result is the payload POSTed to callbacks when reconciling a resource finishes.
+type Result struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// Resource is the resource that was reconciled.
+	Resource Resource `json:"resource"`
+	// Succeeded is true if the reconcile succeeded.
+	Succeeded bool `json:"succeeded"`
+	// Error is the error of the reconcile if it failed.
+	Error string `json:"error,omitempty"`
+	// StartTime is when the reconcile started.
+	StartTime time.Time `json:"startTime"`
+	// FinishTime is when the reconcile finished.
+	FinishTime time.Time `json:"finishTime"`
+	// SourceCommit is the commit of the source that was hydrated or built.
+	SourceCommit string `json:"sourceCommit,omitempty"`
+	// PullRequests are the URLs of the PRs created by a sync.
+	PullRequests []string `json:"pullRequests,omitempty"`
+	// Image is the image that was built including its digest.
+	Image string `json:"image,omitempty"`
+	// Conditions are the conditions of the resource after the reconcile.
+	Conditions []v1alpha1.Condition `json:"conditions,omitempty"`
+}
+
+// Resource identifies the resource that was reconciled.
+type Resource struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// NewResult creates the result of reconciling the resource. err is the error, if any, of the reconcile.
+func NewResult(kind string, metadata v1alpha1.Metadata, start time.Time, err error) Result {
+	r := Result{
+		APIVersion: v1alpha1.Group + "/" + v1alpha1.Version,
+		Kind:       ResultKind,
+		Resource: Resource{
+			Kind:      kind,
+			Name:      metadata.Name,
+			Namespace: metadata.Namespace,
+		},
+		Succeeded:  err == nil,
+		StartTime:  start.UTC(),
+		FinishTime: time.Now().UTC(),
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// Sign returns the value of the SignatureHeader for body signed with secret i.e. sha256= followed by the hex
+// encoded HMAC-SHA256 of body. Receivers verify the payload by computing the signature and comparing it using
+// hmac.Equal.
+func Sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sender sends results to callbacks.
+type Sender struct {
+	client     *http.Client
+	readSecret func(uri string) ([]byte, error)
+}
+
+// SenderOption is an option for the Sender.
+type SenderOption func(s *Sender) error
+
+// WithHTTPClient sets the client used to send results. Defaults to a client with a 30 second timeout.
+func WithHTTPClient(c *http.Client) SenderOption {
+	return func(s *Sender) error {
+		if c == nil {
+			return errors.New("client is required")
+		}
+		s.client = c
+		return nil
+	}
+}
+
+// NewSender creates a new Sender.
+func NewSender(opts ...SenderOption) (*Sender, error) {
+	s := &Sender{
+		client:     &http.Client{Timeout: defaultTimeout},
+		readSecret: keychain.Read,
+	}
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Send POSTs the result to each of the callbacks. A failure to notify one callback doesn't prevent the others
+// from being notified.
+func (s *Sender) Send(ctx context.Context, callbacks []v1alpha1.Callback, result Result) error {
+	if len(callbacks) == 0 {
+		return nil
+	}
+	log := util.LogFromContext(ctx)
+	body, err := json.Marshal(result)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to serialize the result of %v/%v", result.Resource.Kind, result.Resource.Name)
+	}
+
+	allErrors := &util.ListOfErrors{}
+	for _, c := range callbacks {
+		if err := s.send(ctx, c, result.Resource.Kind, body); err != nil {
+			log.Error(err, "Failed to send the result to the callback", "url", c.URL)
+			allErrors.AddCause(err)
+			continue
+		}
+		log.Info("Sent the result to the callback", "url", c.URL, "succeeded", result.Succeeded)
+	}
+	if len(allErrors.Causes) == 0 {
+		return nil
+	}
+	allErrors.Final = fmt.Errorf("failed to send the result to %d of %d callbacks", len(allErrors.Causes), len(callbacks))
+	return allErrors
+}
+
+func (s *Sender) send(ctx context.Context, c v1alpha1.Callback, kind string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create request for callback %v", c.URL)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set(EventHeader, kind)
+	if c.Secret != "" {
+		secret, err := s.readSecret(c.Secret)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to read the secret of callback %v", c.URL)
+		}
+		// Trailing newlines e.g. at the end of files aren't part of the secret.
+		req.Header.Set(SignatureHeader, Sign([]byte(strings.TrimSpace(string(secret))), body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to send the result to callback %v", c.URL)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return errors.Errorf("Callback %v returned %v: %v", c.URL, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
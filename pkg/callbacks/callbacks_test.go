@@ -0,0 +1,87 @@
+package callbacks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/pkg/errors"
+)
+
+func Test_Send(t *testing.T) {
+	type request struct {
+		signature string
+		event     string
+		result    Result
+	}
+	requests := []request{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read body; %v", err)
+			return
+		}
+		if r.Header.Get(SignatureHeader) != "" && r.Header.Get(SignatureHeader) != Sign([]byte("secret"), body) {
+			t.Errorf("Signature %v doesn't match the body", r.Header.Get(SignatureHeader))
+		}
+		req := request{signature: r.Header.Get(SignatureHeader), event: r.Header.Get(EventHeader)}
+		if err := json.Unmarshal(body, &req.result); err != nil {
+			t.Errorf("Failed to decode result; %v", err)
+		}
+		requests = append(requests, req)
+	}))
+	defer server.Close()
+
+	sender, err := NewSender()
+	if err != nil {
+		t.Fatalf("Failed to create sender; %v", err)
+	}
+	sender.readSecret = func(uri string) ([]byte, error) {
+		if uri != "file:///secret" {
+			return nil, errors.Errorf("unexpected secret %v", uri)
+		}
+		return []byte("secret\n"), nil
+	}
+
+	start := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	result := NewResult(v1alpha1.ManifestSyncGVK.Kind, v1alpha1.Metadata{Name: "app", Namespace: "hydros"}, start, errors.New("sync failed"))
+	result.SourceCommit = "1234"
+
+	err = sender.Send(context.Background(), []v1alpha1.Callback{
+		{URL: server.URL + "/signed", Secret: "file:///secret"},
+		{URL: server.URL + "/unsigned"},
+		{URL: server.URL + "/fail"},
+	}, result)
+	if err == nil {
+		t.Errorf("Expected an error because one of the callbacks failed")
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 callbacks to be notified; got %d", len(requests))
+	}
+	if requests[0].signature == "" {
+		t.Errorf("Expected the result to be signed")
+	}
+	if requests[1].signature != "" {
+		t.Errorf("Expected the result not to be signed; got signature %v", requests[1].signature)
+	}
+	if requests[0].event != "ManifestSync" {
+		t.Errorf("Got event %v; want ManifestSync", requests[0].event)
+	}
+	if d := cmp.Diff(result, requests[0].result); d != "" {
+		t.Errorf("Unexpected result; diff:\n%v", d)
+	}
+	if requests[0].result.Succeeded || requests[0].result.Error != "sync failed" {
+		t.Errorf("Expected the result to report the failure; got %+v", requests[0].result)
+	}
+}
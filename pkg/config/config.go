@@ -3,10 +3,13 @@ package config
 import (
 	"fmt"
 	"io/fs"
+	"net/url"
 	"os"
 	"os/user"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/go-logr/zapr"
 	"github.com/pkg/errors"
@@ -41,6 +44,16 @@ type Config struct {
 	GitHub  *GitHubConfig `json:"gitHub,omitempty" yaml:"gitHub,omitempty"`
 	// WorkDir is the working directory for hydros where repositories should be checked out
 	WorkDir string `json:"workDir,omitempty" yaml:"workDir,omitempty"`
+	// HTTP configures the transport used for outbound HTTP requests.
+	HTTP *HTTPConfig `json:"http,omitempty" yaml:"http,omitempty"`
+	// RegistryMirrors configures mirrors to pull docker images through e.g. in air-gapped environments.
+	RegistryMirrors []RegistryMirror `json:"registryMirrors,omitempty" yaml:"registryMirrors,omitempty"`
+	// Scheduler configures how resources that are applied periodically are scheduled.
+	Scheduler *SchedulerConfig `json:"scheduler,omitempty" yaml:"scheduler,omitempty"`
+	// VersionCheck configures the check for newer releases of hydros when commands start.
+	VersionCheck *VersionCheckConfig `json:"versionCheck,omitempty" yaml:"versionCheck,omitempty"`
+	// Functions is the allowlist of the external KRM functions hydros may run while hydrating manifests.
+	Functions *FunctionsConfig `json:"functions,omitempty" yaml:"functions,omitempty"`
 }
 
 // Logging configures the logging.
@@ -63,10 +76,136 @@ type LogSink struct {
 type GitHubConfig struct {
 	// AppID is the ID of the GitHub App
 	AppID int64 `json:"appID,omitempty" yaml:"appID,omitempty"`
-	// PrivateKey is the private key for the GitHub App
+	// PrivateKey is the private key for the GitHub App. It can be a local file, a GCP secret
+	// (gcpSecretManager:///...) or a secret in the OS keychain (keychain://<service>/<account>).
 	PrivateKey string `json:"privateKey,omitempty" yaml:"privateKey,omitempty"`
 }
 
+// HTTPConfig configures the transport used for all outbound HTTP requests; e.g. to GitHub, container registries
+// and GCS. This is primarily intended for environments where traffic has to go through a corporate proxy.
+type HTTPConfig struct {
+	// Proxy is the URL of the proxy to use for HTTP and HTTPS requests e.g. http://proxy.corp.com:3128.
+	// If not set the HTTP_PROXY and HTTPS_PROXY environment variables are used.
+	Proxy string `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+	// NoProxy is a comma separated list of hosts that shouldn't go through the proxy. It uses the same format as
+	// the NO_PROXY environment variable which is used if this isn't set.
+	NoProxy string `json:"noProxy,omitempty" yaml:"noProxy,omitempty"`
+	// Timeout is the maximum amount of time to wait to establish a connection, complete the TLS handshake or
+	// receive the response headers e.g. "30s". If not set the defaults of the go http package are used.
+	Timeout string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// CAFile is the path to a PEM file containing additional root certificates to trust. This is useful if the
+	// proxy terminates TLS using a certificate signed by a private CA.
+	CAFile string `json:"caFile,omitempty" yaml:"caFile,omitempty"`
+	// InsecureSkipVerify disables verification of server certificates. This should only be used for debugging.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty" yaml:"insecureSkipVerify,omitempty"`
+}
+
+// RegistryMirror configures pulling images in an upstream registry through a mirror or pull through cache.
+type RegistryMirror struct {
+	// Registry is the upstream registry e.g. registry.k8s.io or docker.io
+	Registry string `json:"registry,omitempty" yaml:"registry,omitempty"`
+	// Mirror is the registry, optionally followed by a repository prefix, to pull images from instead.
+	// e.g. if Mirror is mirror.corp.com/registry.k8s.io then registry.k8s.io/pause:3.9 will be pulled from
+	// mirror.corp.com/registry.k8s.io/pause:3.9
+	Mirror string `json:"mirror,omitempty" yaml:"mirror,omitempty"`
+	// Username is the username to use to authenticate to the mirror.
+	// If not set the default keychain is used to obtain credentials.
+	Username string `json:"username,omitempty" yaml:"username,omitempty"`
+	// PasswordFile is the path of the file containing the password to authenticate to the mirror.
+	// This can also be a secret in GCP secret manager e.g. gcpSecretManager:///projects/acme/secrets/mirror/versions/latest
+	PasswordFile string `json:"passwordFile,omitempty" yaml:"passwordFile,omitempty"`
+}
+
+// VersionCheckConfig configures the check for newer releases of hydros. Skew matters because different versions
+// of hydros can produce different hydrated manifests.
+type VersionCheckConfig struct {
+	// Disabled turns off the check e.g. in air-gapped environments.
+	Disabled bool `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	// MaxPatchSkew is how many patch releases hydros can be behind the latest release before it warns. It always
+	// warns if the major or minor versions differ. Defaults to 10.
+	MaxPatchSkew int `json:"maxPatchSkew,omitempty" yaml:"maxPatchSkew,omitempty"`
+}
+
+// SchedulerConfig configures how resources that are applied periodically are scheduled.
+type SchedulerConfig struct {
+	// MaxConcurrency is the maximum number of resources that are reconciled at the same time. Defaults to 4.
+	MaxConcurrency int `json:"maxConcurrency,omitempty" yaml:"maxConcurrency,omitempty"`
+	// Jitter is the fraction by which the period of each resource is randomly varied so that resources with the
+	// same period don't all run at once e.g. 0.1 varies the period by up to 10%. Defaults to 0.1.
+	Jitter float64 `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+	// MaxBackoff is the longest to wait before retrying a resource that keeps failing e.g. "1h".
+	// The wait doubles with each consecutive failure. Defaults to 1h.
+	MaxBackoff string `json:"maxBackoff,omitempty" yaml:"maxBackoff,omitempty"`
+	// MaxRunsPerMinute limits how many resources start reconciling per minute so that resources don't all clone
+	// repositories and query registries at once. Defaults to no limit.
+	MaxRunsPerMinute int `json:"maxRunsPerMinute,omitempty" yaml:"maxRunsPerMinute,omitempty"`
+	// Spread delays the first reconcile of each resource by a random fraction of its period so that resources
+	// applied at the same time are spread across the period. By default each resource is reconciled as soon as it
+	// is applied.
+	Spread bool `json:"spread,omitempty" yaml:"spread,omitempty"`
+}
+
+// FunctionsConfig is the allowlist of the containerized and exec KRM functions hydros may run. External functions
+// run arbitrary code with the credentials of hydros so the allowlist is owned by the operator. ManifestSyncs and
+// HydrosConfigs in source repositories can only select functions from it; entries they list that aren't allowed
+// here are ignored.
+//
+// Entries are patterns matched with path.Match e.g. "gcr.io/kpt-fn/*" allows all images in gcr.io/kpt-fn.
+type FunctionsConfig struct {
+	// Images are patterns of the container images that are allowed to run. Images are matched including their
+	// tag or digest.
+	Images []string `json:"images,omitempty" yaml:"images,omitempty"`
+	// Execs are patterns of the absolute paths of the executables that are allowed to run.
+	Execs []string `json:"execs,omitempty" yaml:"execs,omitempty"`
+	// Network allows container functions to request network access.
+	Network bool `json:"network,omitempty" yaml:"network,omitempty"`
+	// Dir is the directory relative exec paths are resolved against. If it isn't set, functions with relative
+	// exec paths are rejected.
+	Dir string `json:"dir,omitempty" yaml:"dir,omitempty"`
+}
+
+// IsImageAllowed returns true if functions using the container image are allowed to run. A nil FunctionsConfig
+// doesn't allow any images.
+func (f *FunctionsConfig) IsImageAllowed(image string) bool {
+	if f == nil {
+		return false
+	}
+	return matchesAny(f.Images, image)
+}
+
+// ResolveExec returns the absolute path of the executable p of an exec function. Relative paths are resolved
+// against Dir and must stay inside it. It returns an error if the path can't be resolved and an empty string if
+// the executable isn't allowed to run.
+func (f *FunctionsConfig) ResolveExec(p string) (string, error) {
+	if f == nil {
+		return "", nil
+	}
+	resolved := filepath.Clean(p)
+	if !filepath.IsAbs(p) {
+		if f.Dir == "" {
+			return "", errors.Errorf("Exec function %v has a relative path; relative paths are only allowed if functions.dir is set in the hydros config", p)
+		}
+		dir := filepath.Clean(f.Dir)
+		resolved = filepath.Join(dir, p)
+		if rel, err := filepath.Rel(dir, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", errors.Errorf("Exec function %v resolves to %v which is outside functions.dir %v", p, resolved, dir)
+		}
+	}
+	if !matchesAny(f.Execs, resolved) {
+		return "", nil
+	}
+	return resolved, nil
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if match, err := path.Match(p, value); err == nil && match {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Config) GetLogLevel() string {
 	if c.Logging.Level == "" {
 		return "info"
@@ -87,14 +226,38 @@ func (c *Config) GetWorkDir() string {
 	return c.WorkDir
 }
 
-// GetConfigDir returns the configuration directory
+// GetConfigDir returns the configuration directory. If no configuration file was used it returns the default
+// configuration directory ~/.hydros.
 func (c *Config) GetConfigDir() string {
+	if viper.ConfigFileUsed() == "" {
+		return binHome()
+	}
 	return filepath.Dir(viper.ConfigFileUsed())
 }
 
 // IsValid validates the configuration and returns any errors.
 func (c *Config) IsValid() []string {
 	problems := make([]string, 0, 1)
+	if c.HTTP != nil {
+		if c.HTTP.Timeout != "" {
+			if _, err := time.ParseDuration(c.HTTP.Timeout); err != nil {
+				problems = append(problems, fmt.Sprintf("http.timeout %v isn't a valid duration; %v", c.HTTP.Timeout, err))
+			}
+		}
+		if c.HTTP.Proxy != "" {
+			if _, err := url.Parse(c.HTTP.Proxy); err != nil {
+				problems = append(problems, fmt.Sprintf("http.proxy %v isn't a valid URL; %v", c.HTTP.Proxy, err))
+			}
+		}
+	}
+	for i, m := range c.RegistryMirrors {
+		if m.Registry == "" {
+			problems = append(problems, fmt.Sprintf("registryMirrors[%d].registry must be specified", i))
+		}
+		if m.Mirror == "" {
+			problems = append(problems, fmt.Sprintf("registryMirrors[%d].mirror must be specified", i))
+		}
+	}
 	return problems
 }
 
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// SetConditions records the conditions in status.conditions of the resource. Controllers use it to report the
+// conditions of the resources they reconcile to the caller e.g. the RepoController.
+func SetConditions(n *yaml.RNode, conditions []v1alpha1.Condition) error {
+	value := &yaml.Node{}
+	if err := value.Encode(conditions); err != nil {
+		return errors.Wrapf(err, "Failed to encode conditions")
+	}
+	status, err := n.Pipe(yaml.LookupCreate(yaml.MappingNode, "status"))
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get the status of %v/%v", n.GetKind(), n.GetName())
+	}
+	return errors.Wrapf(status.PipeE(yaml.SetField("conditions", yaml.NewRNode(value))), "Failed to set the conditions of %v/%v", n.GetKind(), n.GetName())
+}
+
+// GetConditions returns the conditions in status.conditions of the resource.
+func GetConditions(n *yaml.RNode) ([]v1alpha1.Condition, error) {
+	value, err := n.Pipe(yaml.Lookup("status", "conditions"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get the conditions of %v/%v", n.GetKind(), n.GetName())
+	}
+	if value == nil {
+		return nil, nil
+	}
+	conditions := []v1alpha1.Condition{}
+	if err := value.YNode().Decode(&conditions); err != nil {
+		return nil, errors.Wrapf(err, "Failed to decode the conditions of %v/%v", n.GetKind(), n.GetName())
+	}
+	return conditions, nil
+}
@@ -97,10 +97,14 @@ type ImageResolver struct {
 // TODO(jeremy): We need to standardize how not found/doesn't exist errors are returned. We need to support multiple
 // registries and resolvers. Right now it will return a notfound Status wrapped in an error
 // you can check it using status.Code(err) == codes.NotFound
-func (i *ImageResolver) ResolveImageToSha(ref util.DockerImageRef, strategy v1alpha1.Strategy) (util.DockerImageRef, error) {
+func (i *ImageResolver) ResolveImageToSha(ctx context.Context, ref util.DockerImageRef, strategy v1alpha1.Strategy) (util.DockerImageRef, error) {
 	// SourceCommitStrategy is a special case of MutableTagStrategy because the tag is the commit
-	if strategy != v1alpha1.MutableTagStrategy && strategy != v1alpha1.SourceCommitStrategy {
-		return util.DockerImageRef{}, fmt.Errorf("Only MutableTagStrategy and SourceCommitStrategy are currently implemented for artifact registry")
+	// Similarly, for NewestMatchingTagStrategy, SemverStrategy and PromotedTagStrategy the tag has already been set
+	// to the chosen tag.
+	switch strategy {
+	case v1alpha1.MutableTagStrategy, v1alpha1.SourceCommitStrategy, v1alpha1.NewestMatchingTagStrategy, v1alpha1.SemverStrategy, v1alpha1.PromotedTagStrategy:
+	default:
+		return util.DockerImageRef{}, fmt.Errorf("Only MutableTagStrategy, SourceCommitStrategy, NewestMatchingTagStrategy, SemverStrategy and PromotedTagStrategy are currently implemented for artifact registry")
 	}
 
 	image, err := FromImageRef(ref)
@@ -114,7 +118,7 @@ func (i *ImageResolver) ResolveImageToSha(ref util.DockerImageRef, strategy v1al
 
 	log := zapr.NewLogger(zap.L())
 	log.Info("Getting tag", "name", req.Name)
-	resp, err := i.client.GetTag(context.Background(), req)
+	resp, err := i.client.GetTag(ctx, req)
 	if err != nil {
 		return ref, err
 	}
@@ -1,6 +1,7 @@
 package gcp
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"testing"
@@ -87,7 +88,7 @@ func Test_ResolveImageToSHA(t *testing.T) {
 	}
 	expectedSha := "sha256:f2709b8a04f7ee03c7a1b5ce014e480b568661d7383dfbd9578ffca531c9184a"
 	strategy := v1alpha1.MutableTagStrategy
-	sha, err := r.ResolveImageToSha(ref, strategy)
+	sha, err := r.ResolveImageToSha(context.Background(), ref, strategy)
 
 	if err != nil {
 		t.Fatalf("Error resolving image; %+v", err)
@@ -0,0 +1,113 @@
+package gcp
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultLogPollPeriod is how often BuildLogTailer.Follow checks for new output.
+	DefaultLogPollPeriod = 5 * time.Second
+	// finalPollTimeout is how long Follow waits to read the end of the log after it is stopped.
+	finalPollTimeout = 30 * time.Second
+)
+
+// BuildLogTailer copies the log of a Cloud Build build into a logger as GCB writes it so the output of the build
+// e.g. docker build is visible inline. GCB writes the log of build <id> to log-<id>.txt in the build's logs bucket.
+type BuildLogTailer struct {
+	log logr.Logger
+	// read returns the contents of the log starting at offset. It returns nil if there is nothing new.
+	read func(ctx context.Context, offset int64) ([]byte, error)
+
+	offset int64
+	// partial is the last line read if it wasn't terminated by a newline yet.
+	partial string
+}
+
+// NewBuildLogTailer creates a tailer for the log of the build in logsBucket e.g.
+// gs://1234.cloudbuild-logs.googleusercontent.com. Lines are logged using log.
+func NewBuildLogTailer(client *storage.Client, logsBucket string, buildId string, log logr.Logger) (*BuildLogTailer, error) {
+	if !strings.HasPrefix(logsBucket, "gs://") {
+		return nil, errors.Errorf("Build %v logs bucket %q isn't a GCS bucket; logs can only be followed when they are written to GCS", buildId, logsBucket)
+	}
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(logsBucket, "gs://"), "/")
+	obj := client.Bucket(bucket).Object(path.Join(prefix, "log-"+buildId+".txt"))
+
+	read := func(ctx context.Context, offset int64) ([]byte, error) {
+		attrs, err := obj.Attrs(ctx)
+		if err == storage.ErrObjectNotExist {
+			// The log isn't created until the build starts.
+			return nil, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to get attributes of the log of build %v", buildId)
+		}
+		if attrs.Size <= offset {
+			return nil, nil
+		}
+		r, err := obj.NewRangeReader(ctx, offset, -1)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read the log of build %v", buildId)
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+
+	return &BuildLogTailer{
+		log:  log.WithValues("buildId", buildId),
+		read: read,
+	}, nil
+}
+
+// Poll logs the lines written since the last poll. An incomplete final line is held until it is completed or Flush
+// is called.
+func (t *BuildLogTailer) Poll(ctx context.Context) error {
+	data, err := t.read(ctx, t.offset)
+	if err != nil {
+		return err
+	}
+	t.offset += int64(len(data))
+	lines := strings.Split(t.partial+string(data), "\n")
+	t.partial = lines[len(lines)-1]
+	for _, l := range lines[:len(lines)-1] {
+		t.log.Info(strings.TrimSuffix(l, "\r"))
+	}
+	return nil
+}
+
+// Flush logs the incomplete final line if there is one.
+func (t *BuildLogTailer) Flush() {
+	if t.partial != "" {
+		t.log.Info(t.partial)
+		t.partial = ""
+	}
+}
+
+// Follow polls the log every period until ctx is done. It then logs the rest of the log so it should be stopped
+// once the build is done.
+func (t *BuildLogTailer) Follow(ctx context.Context, period time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			// N.B. We can't use ctx because it is already done.
+			finalCtx, cancel := context.WithTimeout(context.Background(), finalPollTimeout)
+			defer cancel()
+			if err := t.Poll(finalCtx); err != nil {
+				t.log.Error(err, "Failed to read the end of the build log")
+			}
+			t.Flush()
+			return
+		case <-time.After(period):
+			if err := t.Poll(ctx); err != nil && ctx.Err() == nil {
+				t.log.Error(err, "Failed to read the build log")
+			}
+		}
+	}
+}
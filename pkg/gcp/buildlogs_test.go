@@ -0,0 +1,50 @@
+package gcp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_BuildLogTailer(t *testing.T) {
+	// chunks are the data returned by successive reads of the log.
+	chunks := []string{
+		"Step #0: Pulling image\nStep #0: Buil",
+		"",
+		"ding\r\nStep #1: Pushing",
+	}
+	lines := []string{}
+	offsets := []int64{}
+	tailer := &BuildLogTailer{
+		log: funcr.New(func(prefix, args string) {
+			lines = append(lines, args)
+		}, funcr.Options{}),
+		read: func(ctx context.Context, offset int64) ([]byte, error) {
+			offsets = append(offsets, offset)
+			c := chunks[0]
+			chunks = chunks[1:]
+			return []byte(c), nil
+		},
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := tailer.Poll(context.Background()); err != nil {
+			t.Fatalf("Poll failed; %v", err)
+		}
+	}
+	tailer.Flush()
+
+	expected := []string{
+		`"level"=0 "msg"="Step #0: Pulling image"`,
+		`"level"=0 "msg"="Step #0: Building"`,
+		`"level"=0 "msg"="Step #1: Pushing"`,
+	}
+	if d := cmp.Diff(expected, lines); d != "" {
+		t.Errorf("Unexpected lines; diff:\n%v", d)
+	}
+	if d := cmp.Diff([]int64{0, 36, 36}, offsets); d != "" {
+		t.Errorf("Unexpected offsets; diff:\n%v", d)
+	}
+}
@@ -3,6 +3,8 @@ package gcp
 import (
 	"context"
 	"encoding/base64"
+	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -11,12 +13,46 @@ import (
 	"cloud.google.com/go/longrunning/autogen/longrunningpb"
 	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
+	"github.com/jlewi/hydros/pkg/poll"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
 const (
 	kanikoBuilder = "gcr.io/kaniko-project/executor:latest"
+	dockerBuilder = "gcr.io/cloud-builders/docker"
+
+	// kanikoStepID is the id of the step that runs kaniko. The step is identified by its id rather than its image
+	// so the image can be overridden.
+	kanikoStepID = "kaniko"
+
+	// buildxBuilderName is the name of the buildx builder instance created for multi-arch builds.
+	buildxBuilderName = "hydros"
+	// buildxStepID is the id of the step that runs docker buildx build.
+	buildxStepID = "buildx"
+	// dockerStepID is the id of the step that runs docker build.
+	dockerStepID = "docker"
+
+	// PullCacheStepID is the id of the step that pulls the image used as the layer cache.
+	PullCacheStepID = "pull-cache"
+	// pushStepPrefix is the prefix of the ids of the steps which push the images built with docker build.
+	pushStepPrefix = "push-"
+
+	// TestStepID is the id of the step that runs the tests.
+	TestStepID = "test"
+
+	syftImage   = "anchore/syft:latest"
+	cosignImage = "gcr.io/projectsigstore/cosign:latest"
+
+	// SignStepID is the id of the step that signs the image.
+	SignStepID = "sign"
+	// SBOMStepID is the id of the step that attaches the SBOM attestation.
+	SBOMStepID = "attest-sbom"
+	// ProvenanceStepID is the id of the step that attaches the provenance attestation.
+	ProvenanceStepID = "attest-provenance"
+
+	sbomFile       = "/workspace/sbom.spdx.json"
+	provenanceFile = "/workspace/provenance.json"
 )
 
 // BuildImage builds a docker image using GCB
@@ -55,6 +91,7 @@ func DefaultBuild() *cbpb.Build {
 		Steps: []*cbpb.BuildStep{
 			{
 				Name: kanikoBuilder,
+				Id:   kanikoStepID,
 				Args: []string{
 					"--cache=true",
 					// Set the date as a build arg
@@ -75,17 +112,140 @@ func DefaultBuild() *cbpb.Build {
 	return build
 }
 
-// AddImages adds images to the build
-func AddImages(build *cbpb.Build, images []string) error {
-	if build.Steps == nil {
-		return errors.New("Build.Steps is nil")
+// BuildxBuild constructs a build which uses docker buildx to build a multi-arch image for the specified
+// platforms e.g. linux/amd64, linux/arm64. The images for all the platforms are pushed along with a manifest list.
+func BuildxBuild(platforms []string) *cbpb.Build {
+	now := time.Now()
+	nowStr := now.Format(time.RFC3339)
+	build := &cbpb.Build{
+		Steps: []*cbpb.BuildStep{
+			{
+				// Register QEMU so we can build for platforms other than the one the build runs on.
+				Name: dockerBuilder,
+				Args: []string{"run", "--privileged", "--rm", "tonistiigi/binfmt", "--install", "all"},
+			},
+			{
+				// The default docker driver can't build multi-platform images so we need to create a builder
+				// using the docker-container driver.
+				Name: dockerBuilder,
+				Args: []string{"buildx", "create", "--name=" + buildxBuilderName, "--driver=docker-container"},
+			},
+			{
+				Name: dockerBuilder,
+				Id:   buildxStepID,
+				Args: []string{
+					"buildx",
+					"build",
+					"--builder=" + buildxBuilderName,
+					"--platform=" + strings.Join(platforms, ","),
+					"--push",
+					// Set the date as a build arg
+					// This is so that it can be passed to the builder and used to set the date in the image
+					// of the build
+					"--build-arg=DATE=" + nowStr,
+					// The build context is the workspace.
+					".",
+				},
+			},
+		},
+		Options: &cbpb.BuildOptions{
+			MachineType: cbpb.BuildOptions_UNSPECIFIED,
+			Logging:     cbpb.BuildOptions_CLOUD_LOGGING_ONLY,
+		},
 	}
 
-	if build.Steps[0].Name != kanikoBuilder {
-		return errors.Errorf("Build.Steps[0].Name %s doesn't match expected %s", build.Steps[0].Name, kanikoBuilder)
+	return build
+}
+
+// DockerBuild constructs a build which uses docker build to build an image for the platform of the build. Unlike
+// kaniko, docker build can use a previously built image as a layer cache; see AddCacheFrom. The images are pushed
+// by separate steps after the build so later steps e.g. signing can refer to them.
+func DockerBuild() *cbpb.Build {
+	now := time.Now()
+	nowStr := now.Format(time.RFC3339)
+	build := &cbpb.Build{
+		Steps: []*cbpb.BuildStep{
+			{
+				Name: dockerBuilder,
+				Id:   dockerStepID,
+				Args: []string{
+					"build",
+					// Set the date as a build arg
+					// This is so that it can be passed to the builder and used to set the date in the image
+					// of the build
+					"--build-arg=DATE=" + nowStr,
+					// The build context is the workspace.
+					".",
+				},
+			},
+		},
+		Options: &cbpb.BuildOptions{
+			MachineType: cbpb.BuildOptions_UNSPECIFIED,
+			Logging:     cbpb.BuildOptions_CLOUD_LOGGING_ONLY,
+		},
+	}
+
+	return build
+}
+
+// AddCacheFrom uses the previously built image as a layer cache so layers that haven't changed aren't rebuilt.
+// For docker build a step which pulls the image is added before the build; the step doesn't fail if the image
+// doesn't exist yet e.g. on the first build. Docker buildx reads the cache directly from the registry. In both
+// cases the cache metadata is pushed inline with the images so they can be used as the cache of the next build.
+// Kaniko doesn't support using an image as a cache.
+func AddCacheFrom(build *cbpb.Build, image string) error {
+	step, err := builderStep(build)
+	if err != nil {
+		return err
+	}
+	switch step.Id {
+	case dockerStepID:
+		pull := &cbpb.BuildStep{
+			Name:       dockerBuilder,
+			Id:         PullCacheStepID,
+			Entrypoint: "bash",
+			Args:       []string{"-c", fmt.Sprintf("docker pull %v || echo 'Failed to pull %v; building without a cache'", image, image)},
+		}
+		steps := make([]*cbpb.BuildStep, 0, len(build.Steps)+1)
+		for _, s := range build.Steps {
+			if s == step {
+				steps = append(steps, pull)
+			}
+			steps = append(steps, s)
+		}
+		build.Steps = steps
+		return addBuilderArgs(step, []string{"--cache-from=" + image, "--build-arg=BUILDKIT_INLINE_CACHE=1"})
+	case buildxStepID:
+		return addBuilderArgs(step, []string{"--cache-from=type=registry,ref=" + image, "--cache-to=type=inline"})
+	default:
+		return errors.New("Kaniko doesn't support using an image as a cache; use DockerBuild or BuildxBuild")
+	}
+}
+
+// SetDockerfile sets the path of the Dockerfile to use
+func SetDockerfile(build *cbpb.Build, dockerfile string) error {
+	step, err := builderStep(build)
+	if err != nil {
+		return err
+	}
+	flag := "--dockerfile="
+	if step.Id == buildxStepID || step.Id == dockerStepID {
+		flag = "--file="
+	}
+	return addBuilderArgs(step, []string{flag + dockerfile})
+}
+
+// AddImages adds images to the build. For docker build a step pushing each image is added after the build.
+func AddImages(build *cbpb.Build, images []string) error {
+	step, err := builderStep(build)
+	if err != nil {
+		return err
 	}
 
 	destFlag := "--destination="
+	if step.Id == buildxStepID || step.Id == dockerStepID {
+		destFlag = "--tag="
+	}
 
 	args := make([]string, 0, len(images))
 
@@ -93,24 +253,131 @@ func AddImages(build *cbpb.Build, images []string) error {
 		args = append(args, destFlag+i)
 	}
 
-	return AddKanikoArgs(build, args)
+	if err := addBuilderArgs(step, args); err != nil {
+		return err
+	}
+
+	if step.Id != dockerStepID {
+		return nil
+	}
+	steps := make([]*cbpb.BuildStep, 0, len(build.Steps)+len(images))
+	for _, s := range build.Steps {
+		steps = append(steps, s)
+		if s != step {
+			continue
+		}
+		for n, i := range images {
+			steps = append(steps, &cbpb.BuildStep{
+				Name: dockerBuilder,
+				Id:   fmt.Sprintf("%s%d", pushStepPrefix, n),
+				Args: []string{"push", i},
+			})
+		}
+	}
+	build.Steps = steps
 	return nil
 }
 
 // AddKanikoArgs adds a build arg to the build
 // null-op if its already added
 func AddKanikoArgs(build *cbpb.Build, buildArgs []string) error {
-	if build.Steps == nil {
-		return errors.New("Build.Steps is nil")
+	step, err := kanikoStep(build)
+	if err != nil {
+		return err
 	}
 
-	if build.Steps[0].Name != kanikoBuilder {
-		return errors.Errorf("Build.Steps[0].Name %s doesn't match expected %s", build.Steps[0].Name, kanikoBuilder)
+	return addBuilderArgs(step, buildArgs)
+}
+
+// AddBuilderArgs adds args to the step that builds the image; either kaniko, docker build or docker buildx.
+// null-op if its already added
+func AddBuilderArgs(build *cbpb.Build, args []string) error {
+	step, err := builderStep(build)
+	if err != nil {
+		return err
 	}
+	return addBuilderArgs(step, args)
+}
 
+// AddBuildArgs passes args to the docker build as build args. They are added in sorted order so the build is
+// deterministic.
+func AddBuildArgs(build *cbpb.Build, args map[string]string) error {
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	flags := make([]string, 0, len(keys))
+	for _, k := range keys {
+		flags = append(flags, "--build-arg="+k+"="+args[k])
+	}
+	return AddBuilderArgs(build, flags)
+}
+
+// SetTarget sets the stage of a multi-stage Dockerfile to build.
+func SetTarget(build *cbpb.Build, target string) error {
+	return AddBuilderArgs(build, []string{"--target=" + target})
+}
+
+// SetBuilderImage sets the image of the step that builds the image e.g. to use a pinned version of kaniko.
+func SetBuilderImage(build *cbpb.Build, image string) error {
+	step, err := builderStep(build)
+	if err != nil {
+		return err
+	}
+	step.Name = image
+	return nil
+}
+
+// AddSecretEnv sets the environment variable env to the Secret Manager secret version e.g.
+// projects/<project>/secrets/<secret>/versions/latest in the step that builds the image and the test step if
+// the build has one. The test step should be added before calling AddSecretEnv.
+func AddSecretEnv(build *cbpb.Build, env string, version string) error {
+	step, err := builderStep(build)
+	if err != nil {
+		return err
+	}
+	if build.AvailableSecrets == nil {
+		build.AvailableSecrets = &cbpb.Secrets{}
+	}
+	build.AvailableSecrets.SecretManager = append(build.AvailableSecrets.SecretManager, &cbpb.SecretManagerSecret{
+		VersionName: version,
+		Env:         env,
+	})
+	step.SecretEnv = append(step.SecretEnv, env)
+	for _, s := range build.Steps {
+		if s.Id == TestStepID {
+			s.SecretEnv = append(s.SecretEnv, env)
+		}
+	}
+	return nil
+}
+
+// SetWorkerPool runs the build in the private worker pool e.g.
+// projects/<project>/locations/<location>/workerPools/<pool>.
+func SetWorkerPool(build *cbpb.Build, pool string) {
+	if build.Options == nil {
+		build.Options = &cbpb.BuildOptions{}
+	}
+	build.Options.Pool = &cbpb.BuildOptions_PoolOption{Name: pool}
+}
+
+// ServiceAccountName returns the resource name GCB expects for the service account the build runs as. account
+// can be the email of the account or a resource name of the form projects/<project>/serviceAccounts/<account>.
+func ServiceAccountName(project string, account string) string {
+	if strings.HasPrefix(account, "projects/") {
+		return account
+	}
+	return "projects/" + project + "/serviceAccounts/" + account
+}
+
+// addBuilderArgs adds args to the step that builds the image
+// null-op if its already added
+func addBuilderArgs(step *cbpb.BuildStep, buildArgs []string) error {
 	existing := make(map[string]bool)
 
-	for _, arg := range build.Steps[0].Args {
+	for _, arg := range step.Args {
 		existing[arg] = true
 	}
 
@@ -119,11 +386,156 @@ func AddKanikoArgs(build *cbpb.Build, buildArgs []string) error {
 			continue
 		}
 
-		build.Steps[0].Args = append(build.Steps[0].Args, a)
+		step.Args = append(step.Args, a)
+	}
+	return nil
+}
+
+// AddTestStep adds a step to run the tests in the specified image before the image is built.
+// The step runs in the same workspace as the builder so it has access to the build context. Steps run sequentially
+// so if the tests fail the build fails and the image is never pushed.
+func AddTestStep(build *cbpb.Build, image string, command []string) error {
+	if _, err := builderStep(build); err != nil {
+		return err
+	}
+	if len(command) == 0 {
+		return errors.New("Test command must be specified")
+	}
+
+	step := &cbpb.BuildStep{
+		Name:       image,
+		Id:         TestStepID,
+		Entrypoint: command[0],
+		Args:       command[1:],
+	}
+
+	build.Steps = append([]*cbpb.BuildStep{step}, build.Steps...)
+	return nil
+}
+
+// AddSignStep adds a step to sign the image with cosign after it is built and pushed. image should be one of the
+// images pushed by the build; cosign signs the digest the tag points to. If keyRef is empty the image is signed
+// using keyless signing with the identity of the build's service account.
+func AddSignStep(build *cbpb.Build, image string, keyRef string) error {
+	if _, err := builderStep(build); err != nil {
+		return err
+	}
+
+	args := []string{"sign", "--yes"}
+	if keyRef != "" {
+		args = append(args, "--key="+keyRef)
+	}
+	args = append(args, image)
+
+	build.Steps = append(build.Steps, &cbpb.BuildStep{
+		Name: cosignImage,
+		Id:   SignStepID,
+		Args: args,
+	})
+	return nil
+}
+
+// AddSBOMSteps adds steps to generate an SPDX SBOM for the image with syft and attach it to the image as a
+// cosign attestation signed with key. The steps are added after the image is built and pushed so image should be
+// one of the images pushed by the build.
+func AddSBOMSteps(build *cbpb.Build, image string, key string) error {
+	if _, err := builderStep(build); err != nil {
+		return err
+	}
+	if key == "" {
+		return errors.New("Key must be specified to sign the SBOM attestation")
+	}
+
+	build.Steps = append(build.Steps,
+		&cbpb.BuildStep{
+			Name: syftImage,
+			Args: []string{image, "--output=spdx-json=" + sbomFile},
+		},
+		attestStep(SBOMStepID, image, key, "spdxjson", sbomFile),
+	)
+	return nil
+}
+
+// AddProvenanceSteps adds steps to attach predicate to the image as a SLSA provenance attestation signed with key.
+// predicate should be the JSON serialized SLSA provenance predicate.
+func AddProvenanceSteps(build *cbpb.Build, image string, key string, predicate []byte) error {
+	if _, err := builderStep(build); err != nil {
+		return err
+	}
+	if key == "" {
+		return errors.New("Key must be specified to sign the provenance attestation")
 	}
+
+	build.Steps = append(build.Steps,
+		&cbpb.BuildStep{
+			// Write the predicate to the workspace so cosign can read it. The predicate is passed in an environment
+			// variable to avoid having to quote it. GCB treats $ as the start of a substitution so it needs to
+			// be escaped.
+			Name:       "bash",
+			Entrypoint: "bash",
+			Args:       []string{"-c", `printf '%s' "$$PROVENANCE" > ` + provenanceFile},
+			Env:        []string{"PROVENANCE=" + strings.ReplaceAll(string(predicate), "$", "$$")},
+		},
+		attestStep(ProvenanceStepID, image, key, "slsaprovenance", provenanceFile),
+	)
 	return nil
 }
 
+// attestStep returns a step that uses cosign to attach the predicate in predicateFile to the image.
+func attestStep(id string, image string, key string, predicateType string, predicateFile string) *cbpb.BuildStep {
+	return &cbpb.BuildStep{
+		Name: cosignImage,
+		Id:   id,
+		Args: []string{
+			"attest",
+			"--yes",
+			"--key=" + key,
+			"--type=" + predicateType,
+			"--predicate=" + predicateFile,
+			image,
+		},
+	}
+}
+
+// GetStepStatus returns the status of the step with the given id. It returns Build_STATUS_UNKNOWN if there is no
+// step with that id.
+func GetStepStatus(build *cbpb.Build, id string) cbpb.Build_Status {
+	for _, s := range build.GetSteps() {
+		if s.GetId() == id {
+			return s.GetStatus()
+		}
+	}
+	return cbpb.Build_STATUS_UNKNOWN
+}
+
+// kanikoStep returns the step that builds the image with kaniko.
+func kanikoStep(build *cbpb.Build) (*cbpb.BuildStep, error) {
+	if build.Steps == nil {
+		return nil, errors.New("Build.Steps is nil")
+	}
+
+	for _, s := range build.Steps {
+		if s.Id == kanikoStepID {
+			return s, nil
+		}
+	}
+	return nil, errors.Errorf("Build doesn't have a step using %s", kanikoBuilder)
+}
+
+// builderStep returns the step that builds the image; either kaniko, docker build or docker buildx.
+func builderStep(build *cbpb.Build) (*cbpb.BuildStep, error) {
+	if build.Steps == nil {
+		return nil, errors.New("Build.Steps is nil")
+	}
+
+	for _, s := range build.Steps {
+		if s.Id == kanikoStepID || s.Id == buildxStepID || s.Id == dockerStepID {
+			return s, nil
+		}
+	}
+	return nil, errors.Errorf("Build doesn't have a step using %s, docker build or docker buildx", kanikoBuilder)
+}
+
 // AddBuildTags passes various values as build flags to the build
 func AddBuildTags(build *cbpb.Build, sourceCommit string, version string) error {
 	args := []string{
@@ -135,7 +547,11 @@ func AddBuildTags(build *cbpb.Build, sourceCommit string, version string) error
 		"--label=COMMIT=" + version,
 	}
 
-	return AddKanikoArgs(build, args)
+	step, err := builderStep(build)
+	if err != nil {
+		return err
+	}
+	return addBuilderArgs(step, args)
 }
 
 // OPNameToBuildID converts an operation name to a build id
@@ -154,24 +570,16 @@ func OPNameToBuildID(name string) (string, error) {
 
 // WaitForBuild waits for a build to complete. Caller should set the deadline on the context.
 // On timeout error is nil and the last operation is returned but Done won't be true.
+// If the context is cancelled WaitForBuild returns immediately with the context's error.
 func WaitForBuild(ctx context.Context, client *cb.Client, project string, buildId string) (*cbpb.Build, error) {
-	// TODO(jeremy): We should get the logger from the context?
-	deadline, ok := ctx.Deadline()
-	if !ok {
-		// Set a default deadline of 10 minutes
-		deadline = time.Now().Add(10 * time.Minute)
-	}
-
 	log, err := logr.FromContext(ctx)
 	if err != nil {
 		log = zapr.NewLogger(zap.L())
 	}
 
-	pause := 20 * time.Second
-
 	var last *cbpb.Build
 	logged := false
-	for time.Now().Before(deadline) {
+	condition := func(ctx context.Context) (bool, error) {
 		req := cbpb.GetBuildRequest{
 			ProjectId: project,
 			Id:        buildId,
@@ -179,34 +587,49 @@ func WaitForBuild(ctx context.Context, client *cb.Client, project string, buildI
 
 		// N.B. We can't just do opClient.WaitForOp because I think that does a server side wait and will timeout
 		// when the http/grpc timeout is reahed.
-		last, err := client.GetBuild(ctx, &req)
-
+		b, err := client.GetBuild(ctx, &req)
 		if err != nil {
+			if ctx.Err() == context.Canceled {
+				return false, ctx.Err()
+			}
 			// TODO(jeremy): We should decide if this is a permanent or retryable error
 			log.Error(err, "Failed to get build", "buildId", buildId)
-
-		} else {
-			switch last.GetStatus() {
-			case cbpb.Build_STATUS_UNKNOWN:
-			case cbpb.Build_PENDING:
-			case cbpb.Build_QUEUED:
-			case cbpb.Build_WORKING:
-			default:
-				return last, nil
-			}
+			return false, nil
 		}
 
-		if !logged && err == nil {
+		last = b
+		switch last.GetStatus() {
+		case cbpb.Build_STATUS_UNKNOWN:
+		case cbpb.Build_PENDING:
+		case cbpb.Build_QUEUED:
+		case cbpb.Build_WORKING:
+		default:
+			return true, nil
+		}
+		if !logged {
 			log.Info("Waiting for build", "buildId", buildId, "logsUrl", last.LogUrl)
 			logged = true
 		}
-		if time.Now().Add(pause).After(deadline) {
-			return last, err
-		}
-		time.Sleep(pause)
-		continue
-
+		return false, nil
 	}
 
+	// N.B. Until waits up to 10 minutes if the context doesn't have a deadline.
+	err = poll.Until(ctx, condition, poll.WithInterval(20*time.Second))
+	if err != nil && !poll.IsTimeout(err) {
+		return last, errors.Wrapf(err, "Stopped waiting for build %v", buildId)
+	}
 	return last, nil
 }
+
+// CancelBuild cancels the build. This is used to stop builds that hydros is no longer waiting on so they don't keep
+// running and consuming resources.
+func CancelBuild(ctx context.Context, client *cb.Client, project string, buildId string) error {
+	req := &cbpb.CancelBuildRequest{
+		ProjectId: project,
+		Id:        buildId,
+	}
+	if _, err := client.CancelBuild(ctx, req); err != nil {
+		return errors.Wrapf(err, "Failed to cancel build %v in project %v", buildId, project)
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package gcp
+
+import (
+	"testing"
+
+	cbpb "cloud.google.com/go/cloudbuild/apiv1/v2/cloudbuildpb"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_AddTestStep(t *testing.T) {
+	build := DefaultBuild()
+	if err := AddTestStep(build, "golang:1.21", []string{"go", "test", "./..."}); err != nil {
+		t.Fatalf("Failed to add test step; %v", err)
+	}
+
+	if len(build.Steps) != 2 {
+		t.Fatalf("Expected 2 steps; got %d", len(build.Steps))
+	}
+
+	test := build.Steps[0]
+	if test.Id != TestStepID {
+		t.Errorf("Expected the first step to be the test step; got id %v", test.Id)
+	}
+	if test.Name != "golang:1.21" || test.Entrypoint != "go" {
+		t.Errorf("Test step has wrong image or entrypoint; got %v %v", test.Name, test.Entrypoint)
+	}
+	if d := cmp.Diff([]string{"test", "./..."}, test.Args); d != "" {
+		t.Errorf("Unexpected args:\n%s", d)
+	}
+
+	// Kaniko args should still be added to the kaniko step even though it is no longer the first step.
+	if err := AddImages(build, []string{"us-west1-docker.pkg.dev/acme/images/hercules:latest"}); err != nil {
+		t.Fatalf("Failed to add images; %v", err)
+	}
+	kaniko := build.Steps[1]
+	if kaniko.Args[len(kaniko.Args)-1] != "--destination=us-west1-docker.pkg.dev/acme/images/hercules:latest" {
+		t.Errorf("Destination wasn't added to the kaniko step; got %v", kaniko.Args)
+	}
+	if len(test.Args) != 2 {
+		t.Errorf("Test step args were modified; got %v", test.Args)
+	}
+
+	if err := AddTestStep(build, "golang:1.21", nil); err == nil {
+		t.Errorf("Expected an error when the test command is empty")
+	}
+}
+
+func Test_GetStepStatus(t *testing.T) {
+	build := &cbpb.Build{
+		Steps: []*cbpb.BuildStep{
+			{Id: TestStepID, Status: cbpb.Build_FAILURE},
+			{Name: kanikoBuilder, Status: cbpb.Build_CANCELLED},
+		},
+	}
+
+	if s := GetStepStatus(build, TestStepID); s != cbpb.Build_FAILURE {
+		t.Errorf("Expected FAILURE; got %v", s)
+	}
+	if s := GetStepStatus(build, "missing"); s != cbpb.Build_STATUS_UNKNOWN {
+		t.Errorf("Expected STATUS_UNKNOWN; got %v", s)
+	}
+}
+
+func Test_BuildxBuild(t *testing.T) {
+	build := BuildxBuild([]string{"linux/amd64", "linux/arm64"})
+
+	if err := AddImages(build, []string{"us-west1-docker.pkg.dev/acme/images/hercules:latest"}); err != nil {
+		t.Fatalf("Failed to add images; %v", err)
+	}
+	if err := SetDockerfile(build, "Dockerfile.prod"); err != nil {
+		t.Fatalf("Failed to set the Dockerfile; %v", err)
+	}
+	if err := AddBuildTags(build, "1234abcd", "v20240101T000000"); err != nil {
+		t.Fatalf("Failed to add build tags; %v", err)
+	}
+	if err := AddTestStep(build, "golang:1.21", []string{"go", "test", "./..."}); err != nil {
+		t.Fatalf("Failed to add test step; %v", err)
+	}
+
+	if build.Steps[0].Id != TestStepID {
+		t.Errorf("Expected the first step to be the test step; got id %v", build.Steps[0].Id)
+	}
+
+	step, err := builderStep(build)
+	if err != nil {
+		t.Fatalf("Failed to get the builder step; %v", err)
+	}
+
+	args := map[string]bool{}
+	for _, a := range step.Args {
+		args[a] = true
+	}
+
+	for _, expected := range []string{
+		"--platform=linux/amd64,linux/arm64",
+		"--push",
+		"--tag=us-west1-docker.pkg.dev/acme/images/hercules:latest",
+		"--file=Dockerfile.prod",
+		"--build-arg=COMMIT=1234abcd",
+		".",
+	} {
+		if !args[expected] {
+			t.Errorf("Buildx step is missing arg %v; got %v", expected, step.Args)
+		}
+	}
+
+	if err := AddKanikoArgs(build, []string{"--cache=true"}); err == nil {
+		t.Errorf("Expected an error adding kaniko args to a buildx build")
+	}
+}
+
+func Test_DockerBuildWithCache(t *testing.T) {
+	build := DockerBuild()
+
+	images := []string{
+		"us-west1-docker.pkg.dev/acme/images/hercules:1234abcd",
+		"us-west1-docker.pkg.dev/acme/images/hercules:latest",
+	}
+	if err := AddImages(build, images); err != nil {
+		t.Fatalf("Failed to add images; %v", err)
+	}
+	if err := SetDockerfile(build, "Dockerfile.prod"); err != nil {
+		t.Fatalf("Failed to set the Dockerfile; %v", err)
+	}
+	if err := AddCacheFrom(build, "us-west1-docker.pkg.dev/acme/images/hercules:latest"); err != nil {
+		t.Fatalf("Failed to add the cache; %v", err)
+	}
+	if err := AddSignStep(build, images[0], "gcpkms://key"); err != nil {
+		t.Fatalf("Failed to add sign step; %v", err)
+	}
+
+	ids := []string{}
+	for _, s := range build.Steps {
+		ids = append(ids, s.Id)
+	}
+	// The cache is pulled before the build and the images are pushed before they are signed.
+	expectedIds := []string{PullCacheStepID, dockerStepID, "push-0", "push-1", SignStepID}
+	if d := cmp.Diff(expectedIds, ids); d != "" {
+		t.Errorf("Unexpected steps; diff:\n%v", d)
+	}
+
+	expectedArgs := []string{
+		"build",
+		build.Steps[1].Args[1],
+		".",
+		"--tag=us-west1-docker.pkg.dev/acme/images/hercules:1234abcd",
+		"--tag=us-west1-docker.pkg.dev/acme/images/hercules:latest",
+		"--file=Dockerfile.prod",
+		"--cache-from=us-west1-docker.pkg.dev/acme/images/hercules:latest",
+		"--build-arg=BUILDKIT_INLINE_CACHE=1",
+	}
+	if d := cmp.Diff(expectedArgs, build.Steps[1].Args); d != "" {
+		t.Errorf("Unexpected docker args; diff:\n%v", d)
+	}
+	if d := cmp.Diff([]string{"push", images[1]}, build.Steps[3].Args); d != "" {
+		t.Errorf("Unexpected push args; diff:\n%v", d)
+	}
+
+	buildx := BuildxBuild([]string{"linux/amd64", "linux/arm64"})
+	if err := AddCacheFrom(buildx, "us-west1-docker.pkg.dev/acme/images/hercules:cache"); err != nil {
+		t.Fatalf("Failed to add the cache; %v", err)
+	}
+	step, err := builderStep(buildx)
+	if err != nil {
+		t.Fatalf("Failed to get the builder step; %v", err)
+	}
+	if d := cmp.Diff([]string{"--cache-from=type=registry,ref=us-west1-docker.pkg.dev/acme/images/hercules:cache", "--cache-to=type=inline"}, step.Args[len(step.Args)-2:]); d != "" {
+		t.Errorf("Unexpected buildx args; diff:\n%v", d)
+	}
+
+	if err := AddCacheFrom(DefaultBuild(), "us-west1-docker.pkg.dev/acme/images/hercules:latest"); err == nil {
+		t.Errorf("Expected an error adding a cache to a kaniko build")
+	}
+}
+
+func Test_AddAttestationSteps(t *testing.T) {
+	build := DefaultBuild()
+	image := "us-west1-docker.pkg.dev/acme/images/hercules:1234abcd"
+	key := "gcpkms://projects/acme/locations/global/keyRings/hydros/cryptoKeys/cosign"
+
+	if err := AddSBOMSteps(build, image, key); err != nil {
+		t.Fatalf("Failed to add SBOM steps; %v", err)
+	}
+	if err := AddProvenanceSteps(build, image, key, []byte(`{"buildType":"$x"}`)); err != nil {
+		t.Fatalf("Failed to add provenance steps; %v", err)
+	}
+
+	if len(build.Steps) != 5 {
+		t.Fatalf("Expected 5 steps; got %d", len(build.Steps))
+	}
+	if build.Steps[0].Name != kanikoBuilder {
+		t.Errorf("Expected the attestation steps to be added after the builder; got first step %v", build.Steps[0].Name)
+	}
+
+	expected := map[string][]string{
+		SBOMStepID:       {"attest", "--yes", "--key=" + key, "--type=spdxjson", "--predicate=" + sbomFile, image},
+		ProvenanceStepID: {"attest", "--yes", "--key=" + key, "--type=slsaprovenance", "--predicate=" + provenanceFile, image},
+	}
+	for _, s := range build.Steps {
+		want, ok := expected[s.Id]
+		if !ok {
+			continue
+		}
+		delete(expected, s.Id)
+		if d := cmp.Diff(want, s.Args); d != "" {
+			t.Errorf("Unexpected args for step %v:\n%s", s.Id, d)
+		}
+	}
+	if len(expected) != 0 {
+		t.Errorf("Build is missing steps %v", expected)
+	}
+
+	if env := build.Steps[3].Env; len(env) != 1 || env[0] != `PROVENANCE={"buildType":"$$x"}` {
+		t.Errorf("Provenance wasn't escaped for GCB; got %v", env)
+	}
+
+	if err := AddSBOMSteps(build, image, ""); err == nil {
+		t.Errorf("Expected an error when the key is empty")
+	}
+}
+
+func Test_AddSignStep(t *testing.T) {
+	image := "us-west1-docker.pkg.dev/acme/images/hercules:1234abcd"
+	key := "gcpkms://projects/acme/locations/global/keyRings/hydros/cryptoKeys/cosign"
+
+	type testCase struct {
+		name     string
+		keyRef   string
+		expected []string
+	}
+
+	testCases := []testCase{
+		{
+			name:     "kms",
+			keyRef:   key,
+			expected: []string{"sign", "--yes", "--key=" + key, image},
+		},
+		{
+			name:     "keyless",
+			keyRef:   "",
+			expected: []string{"sign", "--yes", image},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			build := DefaultBuild()
+			if err := AddSignStep(build, image, c.keyRef); err != nil {
+				t.Fatalf("Failed to add sign step; %v", err)
+			}
+			step := build.Steps[len(build.Steps)-1]
+			if step.Id != SignStepID {
+				t.Fatalf("Expected the sign step to be added last; got id %v", step.Id)
+			}
+			if d := cmp.Diff(c.expected, step.Args); d != "" {
+				t.Errorf("Unexpected args:\n%s", d)
+			}
+		})
+	}
+}
+
+func Test_AddBuildArgsAndTarget(t *testing.T) {
+	for name, build := range map[string]*cbpb.Build{
+		"kaniko": DefaultBuild(),
+		"buildx": BuildxBuild([]string{"linux/amd64"}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := AddBuildArgs(build, map[string]string{"ENV": "prod", "API_URL": "https://api.acme.com"}); err != nil {
+				t.Fatalf("Failed to add build args; %v", err)
+			}
+			if err := SetTarget(build, "prod"); err != nil {
+				t.Fatalf("Failed to set target; %v", err)
+			}
+			step, err := builderStep(build)
+			if err != nil {
+				t.Fatalf("Failed to get the builder step; %v", err)
+			}
+			expected := []string{"--build-arg=API_URL=https://api.acme.com", "--build-arg=ENV=prod", "--target=prod"}
+			if d := cmp.Diff(expected, step.Args[len(step.Args)-3:]); d != "" {
+				t.Errorf("Unexpected args; diff:\n%v", d)
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"cloud.google.com/go/longrunning/autogen/longrunningpb"
 	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
+	"github.com/jlewi/hydros/pkg/poll"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
@@ -18,46 +19,38 @@ var (
 
 // WaitForOp waits for an operation to complete. Caller should set the deadline on the context.
 // On timeout error is nil and the last operation is returned but Done won't be true.
+// If the context is cancelled WaitForOp returns immediately with the context's error.
 func WaitForOp(ctx context.Context, client *longrunning.OperationsClient, op *longrunningpb.Operation) (*longrunningpb.Operation, error) {
-	// TODO(jeremy): We should get the logger from the context?
-	deadline, ok := ctx.Deadline()
-	if !ok {
-		// Set a default deadline of 10 minutes
-		deadline = time.Now().Add(10 * time.Minute)
-	}
-
 	log, err := logr.FromContext(ctx)
 	if err != nil {
 		log = zapr.NewLogger(zap.L())
 	}
 
-	pause := 5 * time.Second
-
 	var last *longrunningpb.Operation
-	for time.Now().Before(deadline) {
+	condition := func(ctx context.Context) (bool, error) {
 		req := longrunningpb.GetOperationRequest{
 			Name: op.GetName(),
 		}
 
 		// N.B. We can't just do opClient.WaitForOp because I think that does a server side wait and will timeout
 		// when the http/grpc timeout is reahed.
-		last, err := client.GetOperation(ctx, &req)
-
+		current, err := client.GetOperation(ctx, &req)
 		if err != nil {
+			if ctx.Err() == context.Canceled {
+				return false, ctx.Err()
+			}
 			// TODO(jeremy): We should decide if this is a permanent or retryable error
 			log.Error(err, "Failed to get operation", "name", op.GetName())
-
-		} else if last.GetDone() {
-			return last, nil
+			return false, nil
 		}
-
-		if time.Now().Add(pause).After(deadline) {
-			return last, err
-		}
-		time.Sleep(pause)
-		continue
-
+		last = current
+		return last.GetDone(), nil
 	}
 
+	// N.B. Until waits up to 10 minutes if the context doesn't have a deadline.
+	err = poll.Until(ctx, condition, poll.WithInterval(5*time.Second))
+	if err != nil && !poll.IsTimeout(err) {
+		return last, errors.Wrapf(err, "Stopped waiting for operation %v", op.GetName())
+	}
 	return last, nil
 }
@@ -0,0 +1,44 @@
+package ghapp
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// CancelPath is the path of the endpoint to cancel the run in progress of a reconciler e.g. a sync stuck waiting
+// for an image build. The name of the reconciler is passed in the name query parameter.
+const CancelPath = "/api/cancel"
+
+// handleCancel cancels the run in progress of the reconciler in the request.
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeStatus(w, "Runs must be cancelled using POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(s.cancelToken)) != 1 {
+		s.writeStatus(w, "Request to cancel the run isn't authorized", http.StatusUnauthorized)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		s.writeStatus(w, "The name of the reconciler is required", http.StatusBadRequest)
+		return
+	}
+
+	cancelled, err := s.handler.Manager.Cancel(name)
+	if err != nil {
+		s.writeStatus(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !cancelled {
+		s.writeStatus(w, fmt.Sprintf("Reconciler %v isn't running", name), http.StatusOK)
+		return
+	}
+	s.log.Info("Cancelled run", "name", name)
+	s.writeStatus(w, fmt.Sprintf("Cancelled the run of %v", name), http.StatusOK)
+}
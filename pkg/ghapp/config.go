@@ -1,6 +1,7 @@
 package ghapp
 
 import (
+	"github.com/jlewi/hydros/pkg/keychain"
 	"github.com/jlewi/monogo/files"
 	"github.com/palantir/go-githubapp/githubapp"
 	"github.com/pkg/errors"
@@ -42,13 +43,18 @@ type App struct {
 // that configuration because not all of those options make sense. For example, we allow URIs to be used
 // for the secrets. So this is a helper function to convert our values into githubapp.Config which
 // can be passed to those libraries.
+// webhookSecret can be empty if the config won't be used to receive webhooks e.g. when replaying recorded events.
 func BuildConfig(appId int64, webhookSecret string, privateKeySecret string) (*githubapp.Config, error) {
-	hmacSecret, err := files.Read(webhookSecret)
-	if err != nil {
-		return nil, errors.Wrapf(err, "Error reading webhook secret %s", webhookSecret)
+	var hmacSecret []byte
+	if webhookSecret != "" {
+		var err error
+		hmacSecret, err = files.Read(webhookSecret)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error reading webhook secret %s", webhookSecret)
+		}
 	}
 
-	privateKey, err := files.Read(privateKeySecret)
+	privateKey, err := keychain.Read(privateKeySecret)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Error reading private key %s", privateKeySecret)
 	}
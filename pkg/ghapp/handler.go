@@ -7,11 +7,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
 	"github.com/google/go-github/v52/github"
 	"github.com/jlewi/hydros/api/v1alpha1"
+	hconfig "github.com/jlewi/hydros/pkg/config"
 	hGithub "github.com/jlewi/hydros/pkg/github"
 	"github.com/jlewi/hydros/pkg/github/ghrepo"
 	"github.com/jlewi/hydros/pkg/gitops"
@@ -29,6 +33,10 @@ const (
 	HydrosConfigPath = "hydros.yaml"
 	// SharedRepository is the name of the repository containing the shared hydros configuration for all repositories
 	SharedRepository = ".github"
+
+	// mergeQueueBranchPrefix is the prefix of the temporary branches GitHub creates to test the PRs in a merge
+	// queue. The branches are named gh-readonly-queue/<base branch>/pr-<number>-<sha>.
+	mergeQueueBranchPrefix = "gh-readonly-queue/"
 )
 
 // TODO(jeremy): Per https://github.com/jlewi/hydros/issues/5#issuecomment-2050452031
@@ -38,16 +46,34 @@ const (
 // for that.
 
 // HydrosHandler is a handler for certain GitHub events. It currently handles PushEvents by sending them to
-// Renderer which knows how to do in place modification using KRMs.
-// TODO(jeremy): Also handle syncer.
+// Renderer which knows how to do in place modification using KRMs and to any Syncers whose source is the
+// branch that was pushed. MergeGroupEvents and CheckSuiteEvents for merge queues resume the Syncers whose PRs
+// were waiting in the merge queue.
 type HydrosHandler struct {
 	githubapp.ClientCreator
 	Manager *gitops.Manager
+	// Functions are the external KRM functions allowed by the hydros config. InPlaceConfigs can only select
+	// functions from it.
+	Functions *hconfig.FunctionsConfig
 	// TODO(jeremy): ClientCreator and TransportManager are somewhat redundant.
 	transports *hGithub.TransportManager
 
 	workDir string
 	fetcher *ConfigFetcher
+
+	mu sync.RWMutex
+	// syncers maps the source branches of Syncers to the names of the Syncers.
+	syncers map[string][]string
+	// destSyncers maps the destination branches of Syncers to the names of the Syncers.
+	destSyncers map[string][]string
+	// imageWatchers are the Syncers to run when images are pushed.
+	imageWatchers []imageWatcher
+}
+
+// imageWatcher is a reconciler that should be run when images it uses are pushed.
+type imageWatcher interface {
+	Name() string
+	WatchesImage(image util.DockerImageRef) bool
 }
 
 // NewHandler starts a new HydrosHandler for GitHub.
@@ -55,7 +81,8 @@ type HydrosHandler struct {
 // transports: Manage GitHub transports
 // workDir: The directory to use for storing temporary files and checking out repositories
 // numWorkers: The number of workers to use for processing events
-func NewHandler(cc githubapp.ClientCreator, transports *hGithub.TransportManager, workDir string, numWorkers int) (*HydrosHandler, error) {
+// opts: Options for the manager running the reconcilers
+func NewHandler(cc githubapp.ClientCreator, transports *hGithub.TransportManager, workDir string, numWorkers int, opts ...gitops.ManagerOption) (*HydrosHandler, error) {
 	log := zapr.NewLogger(zap.L())
 
 	fetcher := &ConfigFetcher{Loader: appconfig.NewLoader(
@@ -74,7 +101,7 @@ func NewHandler(cc githubapp.ClientCreator, transports *hGithub.TransportManager
 		workDir = tDir
 	}
 
-	manager, err := gitops.NewManager([]gitops.Reconciler{})
+	manager, err := gitops.NewManager([]gitops.Reconciler{}, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -89,18 +116,164 @@ func NewHandler(cc githubapp.ClientCreator, transports *hGithub.TransportManager
 		fetcher:       fetcher,
 		workDir:       workDir,
 		Manager:       manager,
+		syncers:       map[string][]string{},
+		destSyncers:   map[string][]string{},
 	}
 
 	return handler, nil
 }
 
+// sourceKey returns the key used to match pushes to Syncers. GitHub names are case insensitive.
+func sourceKey(org string, repo string, branch string) string {
+	return strings.ToLower(org+"/"+repo) + "/" + branch
+}
+
+// AddSyncer adds the syncer to the Manager. The syncer is run whenever its source branch is pushed
+// as well as periodically.
+func (h *HydrosHandler) AddSyncer(s *gitops.Syncer) error {
+	if err := h.Manager.AddReconciler(s); err != nil {
+		return err
+	}
+	source := s.SourceRepo()
+	key := sourceKey(source.Org, source.Repo, source.Branch)
+
+	h.mu.Lock()
+	h.syncers[key] = append(h.syncers[key], s.Name())
+	h.imageWatchers = append(h.imageWatchers, s)
+	added := map[string]bool{}
+	for _, d := range s.DestRepos() {
+		dKey := sourceKey(d.Org, d.Repo, d.Branch)
+		if added[dKey] {
+			continue
+		}
+		added[dKey] = true
+		h.destSyncers[dKey] = append(h.destSyncers[dKey], s.Name())
+	}
+	h.mu.Unlock()
+
+	// Run the syncer now to start the periodic resyncs.
+	return h.Manager.Enqueue(s.Name(), nil)
+}
+
+// HandleImagePushed enqueues a sync for every syncer that uses the registry of the image. This lets image only
+// changes be synced without waiting for the next periodic sync.
+func (h *HydrosHandler) HandleImagePushed(image util.DockerImageRef) {
+	log := zapr.NewLogger(zap.L()).WithValues("image", image.ToURL())
+	h.mu.RLock()
+	watchers := h.imageWatchers
+	h.mu.RUnlock()
+
+	for _, w := range watchers {
+		if !w.WatchesImage(image) {
+			continue
+		}
+		if err := h.Manager.Enqueue(w.Name(), gitops.ImagePushedEvent{Image: image}); err != nil {
+			log.Error(err, "Failed to enqueue sync", "name", w.Name())
+		}
+	}
+}
+
+// enqueueSyncers enqueues a sync for every syncer whose source is the branch that was pushed.
+func (h *HydrosHandler) enqueueSyncers(repoName ghrepo.Interface, branch string, commit string) {
+	h.mu.RLock()
+	names := h.syncers[sourceKey(repoName.RepoOwner(), repoName.RepoName(), branch)]
+	h.mu.RUnlock()
+
+	for _, name := range names {
+		if err := h.Manager.Enqueue(name, gitops.SyncEvent{Commit: commit}); err != nil {
+			zapr.NewLogger(zap.L()).Error(err, "Failed to enqueue sync", "name", name)
+		}
+	}
+}
+
+// enqueueMergeQueueSyncers enqueues a sync for every syncer with a destination whose merge queue finished.
+func (h *HydrosHandler) enqueueMergeQueueSyncers(repoName ghrepo.Interface, branch string) {
+	h.mu.RLock()
+	names := h.destSyncers[sourceKey(repoName.RepoOwner(), repoName.RepoName(), branch)]
+	h.mu.RUnlock()
+
+	for _, name := range names {
+		if err := h.Manager.Enqueue(name, gitops.MergeQueueEvent{Repo: ghrepo.FullName(repoName), Branch: branch}); err != nil {
+			zapr.NewLogger(zap.L()).Error(err, "Failed to enqueue sync", "name", name)
+		}
+	}
+}
+
+// mergeQueueBaseBranch returns the branch a merge queue merges into given the name of the temporary branch of
+// a merge group. It returns false if the branch isn't a merge queue branch.
+func mergeQueueBaseBranch(branch string) (string, bool) {
+	if !strings.HasPrefix(branch, mergeQueueBranchPrefix) {
+		return "", false
+	}
+	rest := strings.TrimPrefix(branch, mergeQueueBranchPrefix)
+	i := strings.LastIndex(rest, "/pr-")
+	if i <= 0 {
+		return "", false
+	}
+	return rest[:i], true
+}
+
+// handleMergeGroup resumes the syncers waiting on a merge queue when one of its merge groups is destroyed i.e.
+// the PRs in the group were merged or removed from the queue.
+func (h *HydrosHandler) handleMergeGroup(log logr.Logger, payload []byte) error {
+	event := &github.MergeGroupEvent{}
+	if err := json.Unmarshal(payload, event); err != nil {
+		log.Error(err, "Failed to decode a MergeGroupEvent")
+		return err
+	}
+	if event.GetAction() != "destroyed" {
+		log.V(util.Debug).Info("Ignoring merge_group event", "action", event.GetAction())
+		return nil
+	}
+	repoName, err := ghrepo.FromFullName(event.GetRepo().GetFullName())
+	if err != nil {
+		return err
+	}
+	branch := strings.TrimPrefix(event.GetMergeGroup().GetBaseRef(), "refs/heads/")
+	log.Info("Got merge_group event", "repo", ghrepo.FullName(repoName), "branch", branch, "action", event.GetAction())
+	h.enqueueMergeQueueSyncers(repoName, branch)
+	return nil
+}
+
+// handleCheckSuite resumes the syncers waiting on a merge queue when the checks of one of its merge groups
+// complete. This lets syncs resume as soon as the checks fail rather than when the merge group is destroyed.
+func (h *HydrosHandler) handleCheckSuite(log logr.Logger, payload []byte) error {
+	event := &github.CheckSuiteEvent{}
+	if err := json.Unmarshal(payload, event); err != nil {
+		log.Error(err, "Failed to decode a CheckSuiteEvent")
+		return err
+	}
+	if event.GetAction() != "completed" {
+		return nil
+	}
+	branch, ok := mergeQueueBaseBranch(event.GetCheckSuite().GetHeadBranch())
+	if !ok {
+		return nil
+	}
+	repoName, err := ghrepo.FromFullName(event.GetRepo().GetFullName())
+	if err != nil {
+		return err
+	}
+	log.Info("Got check_suite event for merge queue", "repo", ghrepo.FullName(repoName), "branch", branch, "conclusion", event.GetCheckSuite().GetConclusion())
+	h.enqueueMergeQueueSyncers(repoName, branch)
+	return nil
+}
+
 func (h *HydrosHandler) Handles() []string {
-	return []string{"push"}
+	return []string{"push", "merge_group", "check_suite"}
 }
 
 func (h *HydrosHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
 	log := zapr.NewLogger(zap.L()).WithValues("eventType", eventType, "deliverID", deliveryID)
 	log.V(util.Debug).Info("Got github webhook")
+
+	switch eventType {
+	case "merge_group":
+		return h.handleMergeGroup(log, payload)
+	case "check_suite":
+		return h.handleCheckSuite(log, payload)
+	}
+
 	r := bytes.NewBuffer(payload)
 	d := json.NewDecoder(r)
 
@@ -131,6 +304,8 @@ func (h *HydrosHandler) Handle(ctx context.Context, eventType, deliveryID string
 	refsPrefix := "refs/heads/"
 	branch := event.GetRef()[len(refsPrefix):]
 
+	h.enqueueSyncers(repoName, branch, event.GetAfter())
+
 	config := h.fetcher.ConfigForRepositoryBranch(context.Background(), client, repoName.RepoOwner(), repoName.RepoName(), branch)
 
 	if config.LoadError != nil {
@@ -207,7 +382,7 @@ func (h *HydrosHandler) Handle(ctx context.Context, eventType, deliveryID string
 		// Make sure workdir is unique for each reconciler.
 		workDir := filepath.Join(h.workDir, rName)
 
-		r, err := gitops.NewRenderer(repoName.RepoOwner(), repoName.RepoName(), workDir, h.transports)
+		r, err := gitops.NewRenderer(repoName.RepoOwner(), repoName.RepoName(), workDir, h.transports, gitops.RenderWithFunctions(h.Functions))
 		if err != nil {
 			return err
 		}
@@ -221,17 +396,15 @@ func (h *HydrosHandler) Handle(ctx context.Context, eventType, deliveryID string
 	}
 
 	// Enqueue a sync event.
-	h.Manager.Enqueue(rName, gitops.RenderEvent{
+	if err := h.Manager.Enqueue(rName, gitops.RenderEvent{
 		// https://docs.github.com/en/webhooks-and-events/webhooks/webhook-events-and-payloads#push
 		// "After" is the commit after the push.
 		Commit: event.GetAfter(),
 		// HydrosConfig could potentially be different for different commits
 		// So we pass it along with the event
 		BranchConfig: inPlaceConfig,
-	})
-
-	if err != nil {
-		log.Error(err, "Failed to create check")
+	}); err != nil {
+		log.Error(err, "Failed to enqueue render event", "name", rName)
 		return err
 	}
 
@@ -16,6 +16,7 @@ import (
 	"github.com/gregjones/httpcache"
 	hGithub "github.com/jlewi/hydros/pkg/github"
 	"github.com/jlewi/hydros/pkg/github/ghrepo"
+	"github.com/jlewi/hydros/pkg/gitops"
 	"github.com/jlewi/hydros/pkg/hydros"
 	"github.com/jlewi/hydros/pkg/util"
 	"github.com/palantir/go-githubapp/githubapp"
@@ -125,3 +126,176 @@ func latestCommit(transports *hGithub.TransportManager, repo ghrepo.Interface, b
 
 	return *b.Commit.SHA, nil
 }
+
+// recordingReconciler records the events it is run with.
+type recordingReconciler struct {
+	events chan any
+}
+
+func (r *recordingReconciler) Name() string {
+	return "syncer-test"
+}
+
+func (r *recordingReconciler) Run(event any) error {
+	r.events <- event
+	return nil
+}
+
+func Test_enqueueSyncers(t *testing.T) {
+	r := &recordingReconciler{events: make(chan any, 10)}
+	manager, err := gitops.NewManager([]gitops.Reconciler{r})
+	if err != nil {
+		t.Fatalf("Failed to create manager; %v", err)
+	}
+	if err := manager.Start(1, time.Hour); err != nil {
+		t.Fatalf("Failed to start manager; %v", err)
+	}
+	defer manager.Shutdown()
+
+	h := &HydrosHandler{
+		Manager: manager,
+		syncers: map[string][]string{
+			sourceKey("Acme", "src", "main"): {r.Name()},
+		},
+	}
+
+	// Pushes to other branches shouldn't trigger a sync.
+	h.enqueueSyncers(ghrepo.New("acme", "src"), "dev", "1234")
+	h.enqueueSyncers(ghrepo.New("acme", "src"), "main", "abcd")
+
+	select {
+	case event := <-r.events:
+		expected := gitops.SyncEvent{Commit: "abcd"}
+		if event != expected {
+			t.Errorf("Got event %v; want %v", event, expected)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Syncer wasn't run")
+	}
+}
+
+func Test_mergeQueueBaseBranch(t *testing.T) {
+	testCases := map[string]struct {
+		branch   string
+		expected string
+		ok       bool
+	}{
+		"main": {
+			branch:   "gh-readonly-queue/main/pr-12-f0e1d2c3b4a5f0e1d2c3b4a5f0e1d2c3b4a5f0e1",
+			expected: "main",
+			ok:       true,
+		},
+		"nested": {
+			branch:   "gh-readonly-queue/release/v1/pr-3-f0e1d2c3b4a5f0e1d2c3b4a5f0e1d2c3b4a5f0e1",
+			expected: "release/v1",
+			ok:       true,
+		},
+		"not-a-queue": {
+			branch: "hydros/main",
+		},
+		"no-pr": {
+			branch: "gh-readonly-queue/main",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			actual, ok := mergeQueueBaseBranch(tc.branch)
+			if actual != tc.expected || ok != tc.ok {
+				t.Errorf("Got (%v, %v); want (%v, %v)", actual, ok, tc.expected, tc.ok)
+			}
+		})
+	}
+}
+
+func Test_HandleMergeQueueEvents(t *testing.T) {
+	r := &recordingReconciler{events: make(chan any, 10)}
+	manager, err := gitops.NewManager([]gitops.Reconciler{r})
+	if err != nil {
+		t.Fatalf("Failed to create manager; %v", err)
+	}
+	if err := manager.Start(1, time.Hour); err != nil {
+		t.Fatalf("Failed to start manager; %v", err)
+	}
+	defer manager.Shutdown()
+
+	h := &HydrosHandler{
+		Manager: manager,
+		destSyncers: map[string][]string{
+			sourceKey("Acme", "manifests", "main"): {r.Name()},
+		},
+	}
+
+	repo := &github.Repository{FullName: proto.String("acme/manifests")}
+	events := []struct {
+		eventType string
+		event     any
+	}{
+		{
+			// Merge groups being created shouldn't trigger a sync.
+			eventType: "merge_group",
+			event: &github.MergeGroupEvent{
+				Action:     proto.String("checks_requested"),
+				MergeGroup: &github.MergeGroup{BaseRef: proto.String("refs/heads/main")},
+				Repo:       repo,
+			},
+		},
+		{
+			// Check suites on branches that aren't merge queues shouldn't trigger a sync.
+			eventType: "check_suite",
+			event: &github.CheckSuiteEvent{
+				Action:     proto.String("completed"),
+				CheckSuite: &github.CheckSuite{HeadBranch: proto.String("hydros/main")},
+				Repo:       repo,
+			},
+		},
+		{
+			eventType: "check_suite",
+			event: &github.CheckSuiteEvent{
+				Action:     proto.String("completed"),
+				CheckSuite: &github.CheckSuite{HeadBranch: proto.String("gh-readonly-queue/main/pr-12-abcd")},
+				Repo:       repo,
+			},
+		},
+	}
+	for _, e := range events {
+		payload, err := json.Marshal(e.event)
+		if err != nil {
+			t.Fatalf("Failed to marshal event; %v", err)
+		}
+		if err := h.Handle(context.Background(), e.eventType, "1234", payload); err != nil {
+			t.Fatalf("Failed to handle %v event; %v", e.eventType, err)
+		}
+	}
+
+	select {
+	case event := <-r.events:
+		expected := gitops.MergeQueueEvent{Repo: "acme/manifests", Branch: "main"}
+		if event != expected {
+			t.Errorf("Got event %v; want %v", event, expected)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Syncer wasn't run")
+	}
+
+	payload, err := json.Marshal(&github.MergeGroupEvent{
+		Action:     proto.String("destroyed"),
+		MergeGroup: &github.MergeGroup{BaseRef: proto.String("refs/heads/main")},
+		Repo:       repo,
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal event; %v", err)
+	}
+	if err := h.Handle(context.Background(), "merge_group", "1234", payload); err != nil {
+		t.Fatalf("Failed to handle merge_group event; %v", err)
+	}
+
+	select {
+	case event := <-r.events:
+		expected := gitops.MergeQueueEvent{Repo: "acme/manifests", Branch: "main"}
+		if event != expected {
+			t.Errorf("Got event %v; want %v", event, expected)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Syncer wasn't run")
+	}
+}
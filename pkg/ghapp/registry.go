@@ -0,0 +1,157 @@
+package ghapp
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+)
+
+const (
+	// RegistryEventsPath is the path of the endpoint that receives notifications about images pushed to
+	// registries.
+	RegistryEventsPath = "/registry/events"
+
+	// maxRegistryEventSize is the largest notification that will be read.
+	maxRegistryEventSize = 1 << 20
+)
+
+// pubSubPush is the body of a Pub/Sub push subscription request.
+// https://cloud.google.com/pubsub/docs/push#receive_push
+type pubSubPush struct {
+	Message struct {
+		Data string `json:"data"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// gcrNotification is the notification published by Artifact Registry and Container Registry to the gcr topic.
+// https://cloud.google.com/artifact-registry/docs/configure-notifications
+type gcrNotification struct {
+	Action string `json:"action"`
+	Digest string `json:"digest"`
+	Tag    string `json:"tag"`
+}
+
+// ecrEvent is the EventBridge event sent by ECR when an image is pushed.
+// https://docs.aws.amazon.com/AmazonECR/latest/userguide/ecr-eventbridge.html
+type ecrEvent struct {
+	Source     string `json:"source"`
+	DetailType string `json:"detail-type"`
+	Account    string `json:"account"`
+	Region     string `json:"region"`
+	Detail     struct {
+		Result         string `json:"result"`
+		RepositoryName string `json:"repository-name"`
+		ImageDigest    string `json:"image-digest"`
+		ActionType     string `json:"action-type"`
+		ImageTag       string `json:"image-tag"`
+	} `json:"detail"`
+}
+
+// parseRegistryEvent parses a notification that an image was pushed. It supports Pub/Sub push requests for
+// Artifact Registry notifications and EventBridge events from ECR. It returns nil if the notification isn't
+// about an image being pushed e.g. an image was deleted.
+func parseRegistryEvent(body []byte) (*util.DockerImageRef, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, errors.Wrapf(err, "Failed to decode registry event")
+	}
+
+	if _, ok := fields["message"]; ok {
+		push := &pubSubPush{}
+		if err := json.Unmarshal(body, push); err != nil {
+			return nil, errors.Wrapf(err, "Failed to decode Pub/Sub push request")
+		}
+		data, err := base64.StdEncoding.DecodeString(push.Message.Data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to decode data of Pub/Sub message from subscription %v", push.Subscription)
+		}
+		n := &gcrNotification{}
+		if err := json.Unmarshal(data, n); err != nil {
+			return nil, errors.Wrapf(err, "Failed to decode registry notification from subscription %v", push.Subscription)
+		}
+		if n.Action != "INSERT" {
+			return nil, nil
+		}
+		image := n.Tag
+		if image == "" {
+			image = n.Digest
+		}
+		return util.ParseImageURL(image)
+	}
+
+	if _, ok := fields["detail-type"]; ok {
+		e := &ecrEvent{}
+		if err := json.Unmarshal(body, e); err != nil {
+			return nil, errors.Wrapf(err, "Failed to decode EventBridge event")
+		}
+		if e.Source != "aws.ecr" || e.DetailType != "ECR Image Action" {
+			return nil, errors.Errorf("Unsupported EventBridge event; source %v detail-type %v", e.Source, e.DetailType)
+		}
+		if e.Detail.ActionType != "PUSH" || e.Detail.Result != "SUCCESS" {
+			return nil, nil
+		}
+		return &util.DockerImageRef{
+			Registry: fmt.Sprintf("%v.dkr.ecr.%v.amazonaws.com", e.Account, e.Region),
+			Repo:     e.Detail.RepositoryName,
+			Tag:      e.Detail.ImageTag,
+			Sha:      e.Detail.ImageDigest,
+		}, nil
+	}
+
+	return nil, errors.New("Unsupported registry event; expected a Pub/Sub push request or an EventBridge event")
+}
+
+// handleRegistryEvent handles notifications that images were pushed.
+func (s *Server) handleRegistryEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeStatus(w, "Registry events must be sent using POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.isAuthorizedRegistryEvent(r) {
+		s.writeStatus(w, "Registry event isn't authorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRegistryEventSize))
+	if err != nil {
+		s.writeStatus(w, fmt.Sprintf("Failed to read body; %v", err), http.StatusBadRequest)
+		return
+	}
+
+	image, err := parseRegistryEvent(body)
+	if err != nil {
+		// N.B. Pub/Sub keeps retrying requests that fail so we only return an error for requests that
+		// we couldn't parse.
+		s.writeStatus(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if image == nil {
+		s.writeStatus(w, "Ignoring registry event; it isn't an image push", http.StatusOK)
+		return
+	}
+
+	s.log.Info("Image pushed", "image", image.ToURL())
+	s.handler.HandleImagePushed(*image)
+	s.writeStatus(w, fmt.Sprintf("Handled push of %v", image.ToURL()), http.StatusOK)
+}
+
+// isAuthorizedRegistryEvent returns true if the request includes the registry events token. The token can be
+// passed in the token query parameter (e.g. in the endpoint of a Pub/Sub push subscription) or as a bearer
+// token (e.g. using an EventBridge connection).
+func (s *Server) isAuthorizedRegistryEvent(r *http.Request) bool {
+	token := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.registryEventsToken)) == 1
+}
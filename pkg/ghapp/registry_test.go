@@ -0,0 +1,121 @@
+package ghapp
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/pkg/gitops"
+	"github.com/jlewi/hydros/pkg/util"
+)
+
+func Test_parseRegistryEvent(t *testing.T) {
+	pubSub := func(data string) string {
+		return `{"message": {"data": "` + base64.StdEncoding.EncodeToString([]byte(data)) + `"}, "subscription": "projects/acme/subscriptions/hydros"}`
+	}
+
+	type testCase struct {
+		name      string
+		body      string
+		expected  *util.DockerImageRef
+		expectErr bool
+	}
+
+	cases := []testCase{
+		{
+			name: "artifact-registry",
+			body: pubSub(`{"action": "INSERT", "digest": "us-west1-docker.pkg.dev/acme/images/hercules@sha256:1234", "tag": "us-west1-docker.pkg.dev/acme/images/hercules:latest"}`),
+			expected: &util.DockerImageRef{
+				Registry: "us-west1-docker.pkg.dev",
+				Repo:     "acme/images/hercules",
+				Tag:      "latest",
+			},
+		},
+		{
+			name:     "artifact-registry-delete",
+			body:     pubSub(`{"action": "DELETE", "tag": "us-west1-docker.pkg.dev/acme/images/hercules:latest"}`),
+			expected: nil,
+		},
+		{
+			name: "ecr",
+			body: `{"source": "aws.ecr", "detail-type": "ECR Image Action", "account": "123456789012", "region": "us-west-2", "detail": {"result": "SUCCESS", "repository-name": "hercules", "image-digest": "sha256:1234", "action-type": "PUSH", "image-tag": "latest"}}`,
+			expected: &util.DockerImageRef{
+				Registry: "123456789012.dkr.ecr.us-west-2.amazonaws.com",
+				Repo:     "hercules",
+				Tag:      "latest",
+				Sha:      "sha256:1234",
+			},
+		},
+		{
+			name:     "ecr-delete",
+			body:     `{"source": "aws.ecr", "detail-type": "ECR Image Action", "detail": {"result": "SUCCESS", "action-type": "DELETE"}}`,
+			expected: nil,
+		},
+		{
+			name:      "unsupported",
+			body:      `{"kind": "Other"}`,
+			expectErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := parseRegistryEvent([]byte(c.body))
+			if c.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRegistryEvent failed; %v", err)
+			}
+			if d := cmp.Diff(c.expected, actual); d != "" {
+				t.Errorf("Unexpected image; diff:\n%v", d)
+			}
+		})
+	}
+}
+
+// registryWatcher is a reconciler that watches a single registry.
+type registryWatcher struct {
+	recordingReconciler
+	registry string
+}
+
+func (w *registryWatcher) WatchesImage(image util.DockerImageRef) bool {
+	return image.Registry == w.registry
+}
+
+func Test_HandleImagePushed(t *testing.T) {
+	w := &registryWatcher{recordingReconciler: recordingReconciler{events: make(chan any, 10)}, registry: "ghcr.io"}
+	manager, err := gitops.NewManager([]gitops.Reconciler{w})
+	if err != nil {
+		t.Fatalf("Failed to create manager; %v", err)
+	}
+	if err := manager.Start(1, time.Hour); err != nil {
+		t.Fatalf("Failed to start manager; %v", err)
+	}
+	defer manager.Shutdown()
+
+	h := &HydrosHandler{
+		Manager:       manager,
+		imageWatchers: []imageWatcher{w},
+	}
+
+	// Pushes to other registries shouldn't trigger a sync.
+	h.HandleImagePushed(util.DockerImageRef{Registry: "docker.io", Repo: "library/nginx", Tag: "latest"})
+	pushed := util.DockerImageRef{Registry: "ghcr.io", Repo: "acme/hercules", Tag: "latest"}
+	h.HandleImagePushed(pushed)
+
+	select {
+	case event := <-w.events:
+		expected := gitops.ImagePushedEvent{Image: pushed}
+		if d := cmp.Diff(expected, event); d != "" {
+			t.Errorf("Unexpected event; diff:\n%v", d)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Syncer wasn't run")
+	}
+}
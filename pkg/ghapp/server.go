@@ -11,6 +11,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/jlewi/hydros/pkg/util"
 	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/pkg/errors"
 
 	// TODO(jeremy): We should move relevant code in jlewi/p22h to jlewi/monogo
 	"net/http"
@@ -25,6 +26,9 @@ import (
 
 const (
 	healthPath = "/healthz"
+	readyPath  = "/readyz"
+	// LeaderPath is the path of the endpoint reporting whether this replica is the leader.
+	LeaderPath = "/leaderz"
 	UserAgent  = "hydros/0.0.1"
 )
 
@@ -44,10 +48,55 @@ type Server struct {
 	gitWebhook http.Handler
 
 	baseHREF string
+
+	// registryEventsToken is the token registries must send with notifications about images being pushed.
+	// If it is empty notifications aren't handled.
+	registryEventsToken string
+
+	// cancelToken is the token requests to cancel runs must include. If it is empty runs can't be cancelled.
+	cancelToken string
+
+	// isLeader returns true if this replica is the leader. If it is nil the replica is always the leader.
+	isLeader func() bool
+}
+
+// ServerOption is an option for the server.
+type ServerOption func(s *Server) error
+
+// WithRegistryEvents enables the endpoint for notifications about images pushed to registries. Notifications
+// must include the token.
+func WithRegistryEvents(token string) ServerOption {
+	return func(s *Server) error {
+		if token == "" {
+			return errors.New("The token for registry events can't be empty")
+		}
+		s.registryEventsToken = token
+		return nil
+	}
+}
+
+// WithCancelToken enables the endpoint to cancel the runs of reconcilers. Requests must include the token.
+func WithCancelToken(token string) ServerOption {
+	return func(s *Server) error {
+		if token == "" {
+			return errors.New("The token to cancel runs can't be empty")
+		}
+		s.cancelToken = token
+		return nil
+	}
+}
+
+// WithLeaderCheck reports whether this replica is the leader at LeaderPath. Readiness doesn't depend on
+// leadership; replicas which aren't the leader still accept webhooks and enqueue the events for the leader.
+func WithLeaderCheck(isLeader func() bool) ServerOption {
+	return func(s *Server) error {
+		s.isLeader = isLeader
+		return nil
+	}
 }
 
 // NewServer creates a new server that relies on IAP as an authentication proxy.
-func NewServer(baseHREF string, port int, config githubapp.Config, handler *HydrosHandler) (*Server, error) {
+func NewServer(baseHREF string, port int, config githubapp.Config, handler *HydrosHandler, opts ...ServerOption) (*Server, error) {
 	// Strip trailing slash from baseHREF so we can just it to the url path
 	if strings.HasSuffix(baseHREF, "/") {
 		baseHREF = baseHREF[:len(baseHREF)-1]
@@ -60,6 +109,12 @@ func NewServer(baseHREF string, port int, config githubapp.Config, handler *Hydr
 		baseHREF: baseHREF,
 	}
 
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := s.setupHandler(); err != nil {
 		return nil, err
 	}
@@ -104,9 +159,33 @@ func (s *Server) addRoutes() error {
 	log.Info("Registering health check", "path", hPath)
 	router.HandleFunc(hPath, s.healthCheck)
 
+	rPath := s.baseHREF + readyPath
+	log.Info("Registering readiness check", "path", rPath)
+	router.HandleFunc(rPath, s.readyCheck)
+
+	lPath := s.baseHREF + LeaderPath
+	log.Info("Registering leader check", "path", lPath)
+	router.HandleFunc(lPath, s.leaderCheck)
+
 	githubWebhookPath := s.baseHREF + githubapp.DefaultWebhookRoute
 	log.Info("Adding routes for GitHub webhooks", "path", githubWebhookPath)
 	router.Handle(githubWebhookPath, s.gitWebhook)
+
+	statusPath := s.baseHREF + StatusPath
+	log.Info("Adding route for the status of the reconcilers", "path", statusPath)
+	router.HandleFunc(statusPath, s.handleStatus)
+
+	if s.registryEventsToken != "" {
+		registryPath := s.baseHREF + RegistryEventsPath
+		log.Info("Adding route for registry events", "path", registryPath)
+		router.HandleFunc(registryPath, s.handleRegistryEvent)
+	}
+
+	if s.cancelToken != "" {
+		cancelPath := s.baseHREF + CancelPath
+		log.Info("Adding route to cancel runs", "path", cancelPath)
+		router.HandleFunc(cancelPath, s.handleCancel)
+	}
 	router.NotFoundHandler = http.HandlerFunc(s.notFoundHandler)
 
 	return nil
@@ -115,9 +194,8 @@ func (s *Server) addRoutes() error {
 // trapInterrupt waits for a shutdown signal and shutsdown the server
 func (s *Server) trapInterrupt() {
 	sigs := make(chan os.Signal, 10)
-	// SIGSTOP and SIGTERM can't be caught; however SIGINT works as expected when using ctl-z
-	// to interrupt the process
-	signal.Notify(sigs, syscall.SIGINT)
+	// N.B. Kubernetes sends SIGTERM when stopping pods; SIGINT is sent when using ctl-c to interrupt the process.
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
 		msg := <-sigs
@@ -154,6 +232,21 @@ func (s *Server) healthCheck(w http.ResponseWriter, r *http.Request) {
 	s.writeStatus(w, "Hydros server is running", http.StatusOK)
 }
 
+// readyCheck reports whether the server is ready to handle webhooks. It doesn't depend on leadership so every
+// replica is ready e.g. during rolling updates.
+func (s *Server) readyCheck(w http.ResponseWriter, r *http.Request) {
+	s.writeStatus(w, "Hydros server is ready", http.StatusOK)
+}
+
+// leaderCheck reports whether this replica is the leader i.e. whether it is running reconcilers.
+func (s *Server) leaderCheck(w http.ResponseWriter, r *http.Request) {
+	if s.isLeader != nil && !s.isLeader() {
+		s.writeStatus(w, "Hydros server isn't the leader", http.StatusServiceUnavailable)
+		return
+	}
+	s.writeStatus(w, "Hydros server is the leader", http.StatusOK)
+}
+
 func (s *Server) notFoundHandler(w http.ResponseWriter, r *http.Request) {
 	s.writeStatus(w, fmt.Sprintf("Hydros server doesn't handle the path; url: %v", r.URL), http.StatusNotFound)
 }
@@ -0,0 +1,98 @@
+package ghapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jlewi/hydros/pkg/gitops"
+	"github.com/palantir/go-githubapp/githubapp"
+)
+
+func Test_ReadinessIndependentOfLeadership(t *testing.T) {
+	isLeader := false
+	config := githubapp.Config{}
+	config.App.WebhookSecret = "secret"
+	s, err := NewServer("/hydros/", 8080, config, &HydrosHandler{}, WithLeaderCheck(func() bool { return isLeader }))
+	if err != nil {
+		t.Fatalf("Failed to create server; %+v", err)
+	}
+
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	if code := get("/hydros" + readyPath); code != http.StatusOK {
+		t.Errorf("Expected a replica which isn't the leader to be ready; got %v", code)
+	}
+	if code := get("/hydros" + LeaderPath); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected %v for a replica which isn't the leader; got %v", http.StatusServiceUnavailable, code)
+	}
+
+	isLeader = true
+	if code := get("/hydros" + readyPath); code != http.StatusOK {
+		t.Errorf("Expected the leader to be ready; got %v", code)
+	}
+	if code := get("/hydros" + LeaderPath); code != http.StatusOK {
+		t.Errorf("Expected %v for the leader; got %v", http.StatusOK, code)
+	}
+}
+
+// cancelableReconciler is a reconciler whose runs can be cancelled.
+type cancelableReconciler struct {
+	recordingReconciler
+	running bool
+}
+
+func (r *cancelableReconciler) Name() string {
+	return "cancelable"
+}
+
+func (r *cancelableReconciler) Cancel() bool {
+	return r.running
+}
+
+func Test_HandleCancel(t *testing.T) {
+	r := &cancelableReconciler{running: true}
+	manager, err := gitops.NewManager([]gitops.Reconciler{r, &recordingReconciler{}})
+	if err != nil {
+		t.Fatalf("Failed to create manager; %v", err)
+	}
+	config := githubapp.Config{}
+	config.App.WebhookSecret = "secret"
+	s, err := NewServer("/hydros/", 8080, config, &HydrosHandler{Manager: manager}, WithCancelToken("token"))
+	if err != nil {
+		t.Fatalf("Failed to create server; %+v", err)
+	}
+
+	type testCase struct {
+		name     string
+		method   string
+		token    string
+		query    string
+		expected int
+	}
+
+	cases := []testCase{
+		{name: "cancelled", method: http.MethodPost, token: "token", query: "name=cancelable", expected: http.StatusOK},
+		{name: "get", method: http.MethodGet, token: "token", query: "name=cancelable", expected: http.StatusMethodNotAllowed},
+		{name: "unauthorized", method: http.MethodPost, token: "wrong", query: "name=cancelable", expected: http.StatusUnauthorized},
+		{name: "no-name", method: http.MethodPost, token: "token", expected: http.StatusBadRequest},
+		{name: "unknown-reconciler", method: http.MethodPost, token: "token", query: "name=unknown", expected: http.StatusNotFound},
+		{name: "not-cancelable", method: http.MethodPost, token: "token", query: "name=syncer-test", expected: http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, "/hydros"+CancelPath+"?"+c.query, nil)
+			req.Header.Set("Authorization", "Bearer "+c.token)
+			w := httptest.NewRecorder()
+			s.router.ServeHTTP(w, req)
+			if w.Code != c.expected {
+				t.Errorf("Expected status %v; got %v: %v", c.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+}
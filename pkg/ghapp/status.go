@@ -0,0 +1,58 @@
+package ghapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/gitops"
+)
+
+// StatusPath is the path of the endpoint reporting the status of the reconcilers.
+const StatusPath = "/api/status"
+
+// ReconcilerStatus is the status of a reconciler returned by the status endpoint.
+type ReconcilerStatus struct {
+	// Name of the reconciler.
+	Name string `json:"name"`
+	// LastRunTime is when the latest run started.
+	LastRunTime time.Time `json:"lastRunTime"`
+	// Duration is how long the latest run took.
+	Duration string `json:"duration"`
+	// Error is the error of the latest run. It is empty if the run succeeded.
+	Error string `json:"error,omitempty"`
+	// Conditions are the conditions of the resource reconciled by the reconciler.
+	Conditions []v1alpha1.Condition `json:"conditions,omitempty"`
+}
+
+// buildReconcilerStatuses converts the results of the latest runs of the reconcilers into their statuses.
+func buildReconcilerStatuses(results []gitops.ReconcileResult) []ReconcilerStatus {
+	statuses := make([]ReconcilerStatus, 0, len(results))
+	for _, r := range results {
+		s := ReconcilerStatus{
+			Name:        r.Name,
+			LastRunTime: r.StartTime.UTC(),
+			Duration:    r.Duration.Round(time.Millisecond).String(),
+			Conditions:  r.Conditions,
+		}
+		if r.Err != nil {
+			s.Error = r.Err.Error()
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+// handleStatus returns the status of the reconcilers that have run as JSON.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeStatus(w, "The status must be fetched using GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(buildReconcilerStatuses(s.handler.Manager.Results())); err != nil {
+		s.log.Error(err, "Failed to write the status of the reconcilers")
+	}
+}
@@ -0,0 +1,91 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v52/github"
+	"github.com/pkg/errors"
+)
+
+// CheckApproval checks whether the PR has at least approvals approving reviews and all of labels.
+// If it doesn't, the returned string describes what is missing.
+func (h *RepoHelper) CheckApproval(prNumber int, approvals int, labels []string) (bool, string, error) {
+	client := github.NewClient(&http.Client{Transport: h.transport})
+	ctx := context.Background()
+	owner := h.baseRepo.RepoOwner()
+	repo := h.baseRepo.RepoName()
+
+	reviews := []*github.PullRequestReview{}
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := client.PullRequests.ListReviews(ctx, owner, repo, prNumber, opts)
+		if err != nil {
+			return false, "", errors.Wrapf(err, "Failed to list reviews of PR %v/%v#%v", owner, repo, prNumber)
+		}
+		reviews = append(reviews, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	prLabels := []string{}
+	if len(labels) > 0 {
+		current, _, err := client.Issues.ListLabelsByIssue(ctx, owner, repo, prNumber, &github.ListOptions{PerPage: 100})
+		if err != nil {
+			return false, "", errors.Wrapf(err, "Failed to list labels of PR %v/%v#%v", owner, repo, prNumber)
+		}
+		for _, l := range current {
+			prLabels = append(prLabels, l.GetName())
+		}
+	}
+
+	approved, reason := checkApproval(reviews, prLabels, approvals, labels)
+	return approved, reason, nil
+}
+
+// checkApproval checks whether a PR with the reviews and labels has the required approvals and labels.
+// Only the latest review from each reviewer counts and comments don't change a reviewer's decision; this matches
+// how GitHub decides whether a PR is approved.
+func checkApproval(reviews []*github.PullRequestReview, prLabels []string, approvals int, labels []string) (bool, string) {
+	latest := map[string]string{}
+	for _, r := range reviews {
+		switch state := r.GetState(); state {
+		case "APPROVED", "CHANGES_REQUESTED", "DISMISSED":
+			latest[r.GetUser().GetLogin()] = state
+		}
+	}
+
+	numApprovals := 0
+	for _, state := range latest {
+		if state == "APPROVED" {
+			numApprovals++
+		}
+	}
+
+	has := map[string]bool{}
+	for _, l := range prLabels {
+		has[l] = true
+	}
+	missing := []string{}
+	for _, l := range labels {
+		if !has[l] {
+			missing = append(missing, l)
+		}
+	}
+
+	reasons := []string{}
+	if numApprovals < approvals {
+		reasons = append(reasons, fmt.Sprintf("has %v of %v required approvals", numApprovals, approvals))
+	}
+	if len(missing) > 0 {
+		reasons = append(reasons, fmt.Sprintf("is missing labels %v", strings.Join(missing, ", ")))
+	}
+	if len(reasons) > 0 {
+		return false, "PR " + strings.Join(reasons, " and ")
+	}
+	return true, ""
+}
@@ -0,0 +1,70 @@
+package github
+
+import (
+	"testing"
+
+	"github.com/google/go-github/v52/github"
+)
+
+func Test_checkApproval(t *testing.T) {
+	review := func(login string, state string) *github.PullRequestReview {
+		return &github.PullRequestReview{
+			User:  &github.User{Login: github.String(login)},
+			State: github.String(state),
+		}
+	}
+
+	type testCase struct {
+		name           string
+		reviews        []*github.PullRequestReview
+		prLabels       []string
+		approvals      int
+		labels         []string
+		expected       bool
+		expectedReason string
+	}
+
+	cases := []testCase{
+		{
+			name:      "approved",
+			reviews:   []*github.PullRequestReview{review("alice", "APPROVED"), review("alice", "COMMENTED")},
+			approvals: 1,
+			expected:  true,
+		},
+		{
+			name:           "changes-requested-after-approval",
+			reviews:        []*github.PullRequestReview{review("alice", "APPROVED"), review("alice", "CHANGES_REQUESTED"), review("bob", "APPROVED")},
+			approvals:      2,
+			expected:       false,
+			expectedReason: "PR has 1 of 2 required approvals",
+		},
+		{
+			name:           "missing-labels",
+			reviews:        []*github.PullRequestReview{review("alice", "APPROVED")},
+			prLabels:       []string{"lgtm"},
+			approvals:      1,
+			labels:         []string{"lgtm", "approved"},
+			expected:       false,
+			expectedReason: "PR is missing labels approved",
+		},
+		{
+			name:           "nothing",
+			approvals:      1,
+			labels:         []string{"approved"},
+			expected:       false,
+			expectedReason: "PR has 0 of 1 required approvals and is missing labels approved",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, reason := checkApproval(c.reviews, c.prLabels, c.approvals, c.labels)
+			if actual != c.expected {
+				t.Errorf("Got %v; want %v", actual, c.expected)
+			}
+			if reason != c.expectedReason {
+				t.Errorf("Got reason %q; want %q", reason, c.expectedReason)
+			}
+		})
+	}
+}
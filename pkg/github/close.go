@@ -0,0 +1,41 @@
+package github
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/go-github/v52/github"
+	"github.com/pkg/errors"
+)
+
+// ClosePR closes the PR without merging it. If comment isn't empty it is added to the PR first to explain why
+// the PR was closed.
+func (h *RepoHelper) ClosePR(prNumber int, comment string) error {
+	client := github.NewClient(&http.Client{Transport: h.transport})
+	ctx := context.Background()
+	owner := h.baseRepo.RepoOwner()
+	repo := h.baseRepo.RepoName()
+
+	if comment != "" {
+		if err := h.CommentOnPR(prNumber, comment); err != nil {
+			return err
+		}
+	}
+
+	if _, _, err := client.PullRequests.Edit(ctx, owner, repo, prNumber, &github.PullRequest{State: github.String("closed")}); err != nil {
+		return errors.Wrapf(err, "Failed to close PR %v/%v#%v", owner, repo, prNumber)
+	}
+	h.log.Info("Closed PR", "number", prNumber)
+	return nil
+}
+
+// CommentOnPR adds the comment to the PR.
+func (h *RepoHelper) CommentOnPR(prNumber int, comment string) error {
+	client := github.NewClient(&http.Client{Transport: h.transport})
+	owner := h.baseRepo.RepoOwner()
+	repo := h.baseRepo.RepoName()
+	if _, _, err := client.Issues.CreateComment(context.Background(), owner, repo, prNumber, &github.IssueComment{Body: github.String(comment)}); err != nil {
+		return errors.Wrapf(err, "Failed to comment on PR %v/%v#%v", owner, repo, prNumber)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package github
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/google/go-github/v52/github"
+	"github.com/pkg/errors"
+)
+
+// codeOwnersLocations are the locations GitHub looks for the CODEOWNERS file in, in order of precedence.
+// https://docs.github.com/en/repositories/managing-your-repositorys-settings-and-features/customizing-your-repository/about-code-owners#codeowners-file-location
+var codeOwnersLocations = []string{
+	".github/CODEOWNERS",
+	"CODEOWNERS",
+	"docs/CODEOWNERS",
+}
+
+// CodeOwners are the rules in a CODEOWNERS file.
+type CodeOwners struct {
+	rules []codeOwnersRule
+}
+
+type codeOwnersRule struct {
+	pattern gitignore.Pattern
+	owners  []string
+}
+
+// ParseCodeOwners parses a CODEOWNERS file.
+func ParseCodeOwners(r io.Reader) (*CodeOwners, error) {
+	c := &CodeOwners{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		c.rules = append(c.rules, codeOwnersRule{
+			pattern: gitignore.ParsePattern(fields[0], nil),
+			owners:  fields[1:],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "Failed to read CODEOWNERS")
+	}
+	return c, nil
+}
+
+// ReadCodeOwners reads the CODEOWNERS file of the repository checked out in repoDir. It returns nil if the
+// repository doesn't have a CODEOWNERS file.
+func ReadCodeOwners(repoDir string) (*CodeOwners, error) {
+	for _, l := range codeOwnersLocations {
+		f, err := os.Open(filepath.Join(repoDir, l))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "Failed to open %v", l)
+		}
+		defer f.Close()
+		return ParseCodeOwners(f)
+	}
+	return nil, nil
+}
+
+// Owners returns the owners of the file at path; path is relative to the root of the repository.
+// As in GitHub, the last matching rule determines the owners. A nil CodeOwners has no owners.
+func (c *CodeOwners) Owners(path string) []string {
+	if c == nil {
+		return nil
+	}
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	for i := len(c.rules) - 1; i >= 0; i-- {
+		r := c.rules[i]
+		// N.B. A rule matching a directory applies to all the files below it.
+		for j := len(parts); j > 0; j-- {
+			if r.pattern.Match(parts[:j], j < len(parts)) != gitignore.NoMatch {
+				return r.owners
+			}
+		}
+	}
+	return nil
+}
+
+// OwnersOfDir returns the owners of all the files below dir in the repository checked out in repoDir; dir is
+// relative to the root of the repository. Owners are deduplicated and sorted.
+func (c *CodeOwners) OwnersOfDir(repoDir string, dir string) ([]string, error) {
+	if c == nil {
+		return []string{}, nil
+	}
+	owners := map[string]bool{}
+	root := filepath.Join(repoDir, dir)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(repoDir, path)
+		if err != nil {
+			return err
+		}
+		for _, o := range c.Owners(rel) {
+			owners[o] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to walk %v", root)
+	}
+
+	result := make([]string, 0, len(owners))
+	for o := range owners {
+		result = append(result, o)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// RequestReviewers requests reviews of the PR from the owners. Owners are in the format used in CODEOWNERS files;
+// i.e. @user or @org/team. Owners specified by email are skipped because reviews can't be requested by email.
+func (h *RepoHelper) RequestReviewers(prNumber int, owners []string) error {
+	request := reviewersRequest(owners)
+	if len(request.Reviewers) == 0 && len(request.TeamReviewers) == 0 {
+		return nil
+	}
+
+	client := github.NewClient(&http.Client{Transport: h.transport})
+	owner := h.baseRepo.RepoOwner()
+	repo := h.baseRepo.RepoName()
+	if _, _, err := client.PullRequests.RequestReviewers(context.Background(), owner, repo, prNumber, request); err != nil {
+		return errors.Wrapf(err, "Failed to request reviewers %v for PR %v/%v#%v", owners, owner, repo, prNumber)
+	}
+	h.log.Info("Requested reviewers", "number", prNumber, "reviewers", request.Reviewers, "teams", request.TeamReviewers)
+	return nil
+}
+
+// reviewersRequest converts owners in CODEOWNERS format to a request for reviewers.
+func reviewersRequest(owners []string) github.ReviewersRequest {
+	request := github.ReviewersRequest{
+		Reviewers:     []string{},
+		TeamReviewers: []string{},
+	}
+	for _, o := range owners {
+		if !strings.HasPrefix(o, "@") {
+			continue
+		}
+		o = strings.TrimPrefix(o, "@")
+		if i := strings.Index(o, "/"); i >= 0 {
+			// Teams are requested using their slug without the org.
+			request.TeamReviewers = append(request.TeamReviewers, o[i+1:])
+			continue
+		}
+		request.Reviewers = append(request.Reviewers, o)
+	}
+	return request
+}
@@ -0,0 +1,106 @@
+package github
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-github/v52/github"
+)
+
+const testCodeOwners = `# Default owners
+*       @acme/platform
+
+# Environments
+/manifests/prod/    @acme/sre alice@acme.com
+manifests/dev/**    @bob
+*.md                @acme/docs
+`
+
+func Test_CodeOwners(t *testing.T) {
+	c, err := ParseCodeOwners(strings.NewReader(testCodeOwners))
+	if err != nil {
+		t.Fatalf("Failed to parse CODEOWNERS; %v", err)
+	}
+
+	type testCase struct {
+		path     string
+		expected []string
+	}
+
+	cases := []testCase{
+		{path: "main.go", expected: []string{"@acme/platform"}},
+		{path: "manifests/prod/deployment.yaml", expected: []string{"@acme/sre", "alice@acme.com"}},
+		{path: "manifests/prod/app/service.yaml", expected: []string{"@acme/sre", "alice@acme.com"}},
+		{path: "manifests/dev/app/service.yaml", expected: []string{"@bob"}},
+		{path: "manifests/prod/README.md", expected: []string{"@acme/docs"}},
+		{path: "other/manifests/prod/deployment.yaml", expected: []string{"@acme/platform"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.path, func(t *testing.T) {
+			if d := cmp.Diff(tc.expected, c.Owners(tc.path)); d != "" {
+				t.Errorf("Unexpected owners; diff:\n%v", d)
+			}
+		})
+	}
+}
+
+func Test_OwnersOfDir(t *testing.T) {
+	dir, err := os.MkdirTemp("", "testCodeOwners")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		".github/CODEOWNERS":             testCodeOwners,
+		"manifests/prod/deployment.yaml": "",
+		"manifests/prod/README.md":       "",
+		"manifests/dev/deployment.yaml":  "",
+	}
+	for name, contents := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("Failed to create dir; %v", err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatalf("Failed to write %v; %v", name, err)
+		}
+	}
+
+	c, err := ReadCodeOwners(dir)
+	if err != nil {
+		t.Fatalf("Failed to read CODEOWNERS; %v", err)
+	}
+
+	actual, err := c.OwnersOfDir(dir, "manifests/prod")
+	if err != nil {
+		t.Fatalf("OwnersOfDir failed; %v", err)
+	}
+	expected := []string{"@acme/docs", "@acme/sre", "alice@acme.com"}
+	if d := cmp.Diff(expected, actual); d != "" {
+		t.Errorf("Unexpected owners; diff:\n%v", d)
+	}
+
+	none, err := ReadCodeOwners(filepath.Join(dir, "manifests"))
+	if err != nil {
+		t.Fatalf("Failed to read CODEOWNERS; %v", err)
+	}
+	if none != nil {
+		t.Errorf("Expected nil CodeOwners for a repo without a CODEOWNERS file")
+	}
+}
+
+func Test_reviewersRequest(t *testing.T) {
+	actual := reviewersRequest([]string{"@acme/sre", "alice@acme.com", "@bob"})
+	expected := github.ReviewersRequest{
+		Reviewers:     []string{"bob"},
+		TeamReviewers: []string{"sre"},
+	}
+	if d := cmp.Diff(expected, actual); d != "" {
+		t.Errorf("Unexpected request; diff:\n%v", d)
+	}
+}
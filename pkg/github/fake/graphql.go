@@ -0,0 +1,307 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphQLError struct {
+	Type    string `json:"type,omitempty"`
+	Message string `json:"message"`
+}
+
+// handleGraphQL handles GraphQL requests. The fake doesn't parse GraphQL; it recognizes the queries and mutations
+// hydros issues by the fields they select and always returns the same set of fields for an object. Clients ignore
+// fields they didn't ask for.
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	req := &graphQLRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Failed to decode GraphQL request; %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var data interface{}
+	var gErr *graphQLError
+	q := req.Query
+	switch {
+	case strings.Contains(q, "createPullRequest("):
+		data, gErr = s.createPullRequest(req.Variables)
+	case strings.Contains(q, "updatePullRequest("):
+		// Labels aren't stored so there is nothing to update.
+		data = map[string]interface{}{"updatePullRequest": map[string]interface{}{"clientMutationId": ""}}
+	case strings.Contains(q, "enablePullRequestAutoMerge("):
+		// Checks always pass in the fake so auto merge merges the PR right away unless the repository has a
+		// merge queue.
+		data, gErr = s.mergePullRequest(req.Variables, "enablePullRequestAutoMerge")
+	case strings.Contains(q, "mergePullRequest("):
+		data, gErr = s.mergePullRequest(req.Variables, "mergePullRequest")
+	case strings.Contains(q, "pullRequest(number:"):
+		data, gErr = s.pullRequestByNumber(req.Variables)
+	case strings.Contains(q, "pullRequests("):
+		data, gErr = s.pullRequestsForBranch(req.Variables)
+	case strings.Contains(q, "labels("):
+		data = map[string]interface{}{
+			"repository": map[string]interface{}{
+				"labels": map[string]interface{}{
+					"nodes":    []interface{}{},
+					"pageInfo": map[string]interface{}{"hasNextPage": false, "endCursor": ""},
+				},
+			},
+		}
+	case strings.Contains(q, "repository("):
+		data, gErr = s.repository(req.Variables)
+	default:
+		gErr = &graphQLError{Message: "The fake doesn't support the query:\n" + q}
+	}
+
+	if gErr != nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"errors": []*graphQLError{gErr}})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+// repository handles the RepositoryInfo query.
+func (s *Server) repository(vars map[string]interface{}) (interface{}, *graphQLError) {
+	org := stringVar(vars, "owner")
+	repo := stringVar(vars, "name")
+	if !s.repoExists(org, repo) {
+		return nil, notFound(org, repo)
+	}
+	branch, err := runGit(s.repoDir(org, repo), "symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return nil, &graphQLError{Message: err.Error()}
+	}
+	return map[string]interface{}{
+		"repository": map[string]interface{}{
+			"id":                 repoID(org, repo),
+			"name":               repo,
+			"owner":              map[string]interface{}{"login": org},
+			"viewerPermission":   "ADMIN",
+			"defaultBranchRef":   map[string]interface{}{"name": branch},
+			"mergeCommitAllowed": true,
+			"rebaseMergeAllowed": true,
+			"squashMergeAllowed": true,
+		},
+	}, nil
+}
+
+// createPullRequest handles the createPullRequest mutation.
+func (s *Server) createPullRequest(vars map[string]interface{}) (interface{}, *graphQLError) {
+	input, _ := vars["input"].(map[string]interface{})
+	org, repo, ok := parseRepoID(stringVar(input, "repositoryId"))
+	if !ok || !s.repoExists(org, repo) {
+		return nil, &graphQLError{Type: "NOT_FOUND", Message: "Could not resolve to a Repository"}
+	}
+
+	base := stringVar(input, "baseRefName")
+	head := stringVar(input, "headRefName")
+	for _, p := range s.prs {
+		if p.Org == org && p.Repo == repo && p.HeadRefName == head && p.BaseRefName == base && p.State == "OPEN" {
+			return nil, &graphQLError{Message: fmt.Sprintf("A pull request already exists for %v:%v.", org, head)}
+		}
+	}
+
+	if _, err := runGit(s.repoDir(org, repo), "rev-parse", "--verify", "refs/heads/"+head); err != nil {
+		return nil, &graphQLError{Message: fmt.Sprintf("Head sha can't be blank, Base sha can't be blank, No commits between %v and %v", base, head)}
+	}
+
+	pr := &PullRequest{
+		Number:      len(s.prs) + 1,
+		Org:         org,
+		Repo:        repo,
+		Title:       stringVar(input, "title"),
+		Body:        stringVar(input, "body"),
+		BaseRefName: base,
+		HeadRefName: head,
+		State:       "OPEN",
+	}
+	pr.ID = fmt.Sprintf("PR_%v", pr.Number)
+	s.prs = append(s.prs, pr)
+	s.log.Info("Created PR", "url", pr.URL(), "base", base, "head", head)
+
+	return map[string]interface{}{
+		"createPullRequest": map[string]interface{}{
+			"pullRequest": map[string]interface{}{
+				"id":  pr.ID,
+				"url": pr.URL(),
+			},
+		},
+	}, nil
+}
+
+// pullRequestsForBranch handles the query to find open PRs for a branch.
+func (s *Server) pullRequestsForBranch(vars map[string]interface{}) (interface{}, *graphQLError) {
+	org := stringVar(vars, "owner")
+	repo := stringVar(vars, "Repo")
+	head := stringVar(vars, "headRefName")
+
+	nodes := []interface{}{}
+	for _, p := range s.prs {
+		if p.Org == org && p.Repo == repo && p.HeadRefName == head && p.State == "OPEN" {
+			nodes = append(nodes, s.prJSON(p))
+		}
+	}
+	return map[string]interface{}{
+		"repository": map[string]interface{}{
+			"pullRequests": map[string]interface{}{"nodes": nodes},
+		},
+	}, nil
+}
+
+// pullRequestByNumber handles the PullRequestByNumber query.
+func (s *Server) pullRequestByNumber(vars map[string]interface{}) (interface{}, *graphQLError) {
+	org := stringVar(vars, "owner")
+	repo := stringVar(vars, "repo")
+	number, _ := vars["pr_number"].(float64)
+
+	for _, p := range s.prs {
+		if p.Org == org && p.Repo == repo && p.Number == int(number) {
+			return map[string]interface{}{
+				"repository": map[string]interface{}{"pullRequest": s.prJSON(p)},
+			}, nil
+		}
+	}
+	return nil, &graphQLError{Type: "NOT_FOUND", Message: fmt.Sprintf("Could not resolve to a PullRequest with the number of %v.", number)}
+}
+
+// mergePullRequest handles the mergePullRequest and enablePullRequestAutoMerge mutations. The PR is merged using
+// the requested merge method; see merge.
+func (s *Server) mergePullRequest(vars map[string]interface{}, field string) (interface{}, *graphQLError) {
+	input, _ := vars["input"].(map[string]interface{})
+	id := stringVar(input, "pullRequestId")
+
+	var pr *PullRequest
+	for _, p := range s.prs {
+		if p.ID == id {
+			pr = p
+		}
+	}
+	if pr == nil {
+		return nil, &graphQLError{Type: "NOT_FOUND", Message: fmt.Sprintf("Could not resolve to a node with the global id of '%v'", id)}
+	}
+	if pr.State != "OPEN" {
+		return nil, &graphQLError{Message: fmt.Sprintf("Pull request %v is %v", pr.URL(), pr.State)}
+	}
+	if status := s.mergeStateStatus(pr); status != "CLEAN" {
+		return nil, &graphQLError{Message: fmt.Sprintf("Pull request %v can't be merged; mergeStateStatus %v", pr.URL(), status)}
+	}
+
+	pr.MergeMethod = stringVar(input, "mergeMethod")
+	result := map[string]interface{}{
+		field: map[string]interface{}{"clientMutationId": ""},
+	}
+	if field == "enablePullRequestAutoMerge" && s.mergeQueues[pr.Org+"/"+pr.Repo] {
+		pr.InMergeQueue = true
+		s.log.Info("Added PR to merge queue", "url", pr.URL())
+		return result, nil
+	}
+	if gErr := s.merge(pr); gErr != nil {
+		return nil, gErr
+	}
+	return result, nil
+}
+
+// merge merges the head branch of the PR into its base branch. By default the head branch is squashed onto the base
+// branch. Since PRs are only merged once their head branch contains the base branch, REBASE fast forwards the base
+// branch to the head branch so the commits of the PR are kept and MERGE creates a merge commit.
+func (s *Server) merge(pr *PullRequest) *graphQLError {
+	dir := s.repoDir(pr.Org, pr.Repo)
+	base, err := runGit(dir, "rev-parse", "refs/heads/"+pr.BaseRefName)
+	if err != nil {
+		return &graphQLError{Message: err.Error()}
+	}
+	// Set the identity explicitly so merging doesn't depend on the git config of the machine running the fake.
+	commitTree := []string{"-c", "user.name=hydros", "-c", "user.email=hydros@github.com", "commit-tree", "refs/heads/" + pr.HeadRefName + "^{tree}", "-p", base}
+	var merged string
+	switch pr.MergeMethod {
+	case "REBASE":
+		merged, err = runGit(dir, "rev-parse", "refs/heads/"+pr.HeadRefName)
+	case "MERGE":
+		merged, err = runGit(dir, append(commitTree, "-p", "refs/heads/"+pr.HeadRefName, "-m", fmt.Sprintf("Merge pull request #%v", pr.Number))...)
+	default:
+		merged, err = runGit(dir, append(commitTree, "-m", fmt.Sprintf("%v (#%v)", pr.Title, pr.Number))...)
+	}
+	if err != nil {
+		return &graphQLError{Message: err.Error()}
+	}
+	if _, err := runGit(dir, "update-ref", "refs/heads/"+pr.BaseRefName, merged, base); err != nil {
+		return &graphQLError{Message: err.Error()}
+	}
+	pr.State = "MERGED"
+	pr.InMergeQueue = false
+	s.log.Info("Merged PR", "url", pr.URL(), "commit", merged)
+	return nil
+}
+
+// mergeStateStatus returns the mergeStateStatus of the PR. The PR is CLEAN if the head branch contains the base
+// branch and BEHIND otherwise. Merged and closed PRs have the same status as their state.
+func (s *Server) mergeStateStatus(pr *PullRequest) string {
+	if pr.State != "OPEN" {
+		return pr.State
+	}
+	dir := s.repoDir(pr.Org, pr.Repo)
+	if _, err := runGit(dir, "merge-base", "--is-ancestor", "refs/heads/"+pr.BaseRefName, "refs/heads/"+pr.HeadRefName); err != nil {
+		return "BEHIND"
+	}
+	return "CLEAN"
+}
+
+// prJSON returns the GraphQL representation of the PR.
+func (s *Server) prJSON(p *PullRequest) map[string]interface{} {
+	headOid, _ := runGit(s.repoDir(p.Org, p.Repo), "rev-parse", "refs/heads/"+p.HeadRefName)
+	return map[string]interface{}{
+		"id":                  p.ID,
+		"number":              p.Number,
+		"title":               p.Title,
+		"body":                p.Body,
+		"state":               p.State,
+		"closed":              p.State != "OPEN",
+		"url":                 p.URL(),
+		"baseRefName":         p.BaseRefName,
+		"headRefName":         p.HeadRefName,
+		"headRefOid":          headOid,
+		"mergeable":           "MERGEABLE",
+		"mergeStateStatus":    s.mergeStateStatus(p),
+		"isInMergeQueue":      p.InMergeQueue,
+		"isMergeQueueEnabled": s.mergeQueues[p.Org+"/"+p.Repo],
+		"isCrossRepository":   false,
+		"author":              map[string]interface{}{"login": "hydros"},
+		"headRepositoryOwner": map[string]interface{}{"login": p.Org},
+		"commits":             map[string]interface{}{"totalCount": 1},
+	}
+}
+
+func repoID(org string, repo string) string {
+	return "R_" + org + "/" + repo
+}
+
+func parseRepoID(id string) (string, string, bool) {
+	pieces := strings.Split(strings.TrimPrefix(id, "R_"), "/")
+	if len(pieces) != 2 {
+		return "", "", false
+	}
+	return pieces[0], pieces[1], true
+}
+
+func notFound(org string, repo string) *graphQLError {
+	return &graphQLError{
+		Type:    "NOT_FOUND",
+		Message: fmt.Sprintf("Could not resolve to a Repository with the name '%v/%v'.", org, repo),
+	}
+}
+
+func stringVar(vars map[string]interface{}, name string) string {
+	v, _ := vars[name].(string)
+	return v
+}
@@ -0,0 +1,271 @@
+// Package fake implements an in-process fake of the subset of the GitHub API used by hydros. It is intended for
+// local development; it lets the sync loop run end to end against local bare repositories without a real GitHub
+// App or real repositories.
+//
+// The fake implements
+//   - the REST endpoints used by GitHub App authentication to look up installations and mint access tokens
+//   - the GraphQL queries and mutations used to find, create and merge pull requests
+//   - the git smart HTTP protocol (via git http-backend) so repositories can be cloned, fetched and pushed
+//
+// Repositories are bare repositories stored in ReposDir as <org>/<repo>.git.
+package fake
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cgi"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// Token is the access token issued for every installation.
+	Token = "fake-token"
+	// AppID is the id of the fake GitHub App.
+	AppID = int64(1)
+	// installationID is the id of the one installation of the fake GitHub App. The app is installed in all repos.
+	installationID = int64(1)
+)
+
+// PullRequest is a pull request in the fake.
+type PullRequest struct {
+	ID          string
+	Number      int
+	Org         string
+	Repo        string
+	Title       string
+	Body        string
+	BaseRefName string
+	HeadRefName string
+	// State is OPEN, MERGED or CLOSED
+	State string
+	// InMergeQueue is true if auto merge was enabled for the PR and its repository has a merge queue.
+	InMergeQueue bool
+	// MergeMethod is the merge method requested when the PR was merged or added to the merge queue e.g. SQUASH.
+	MergeMethod string
+}
+
+// URL returns the URL of the PR.
+func (p *PullRequest) URL() string {
+	return fmt.Sprintf("https://github.com/%v/%v/pull/%v", p.Org, p.Repo, p.Number)
+}
+
+// Server is a fake GitHub server.
+type Server struct {
+	ReposDir string
+
+	log        logr.Logger
+	privateKey []byte
+
+	mu  sync.Mutex
+	prs []*PullRequest
+	// mergeQueues are the repositories, as "<org>/<repo>", with a merge queue.
+	mergeQueues map[string]bool
+}
+
+// NewServer creates a new fake backed by the bare repositories in reposDir.
+func NewServer(reposDir string) (*Server, error) {
+	if reposDir == "" {
+		return nil, errors.New("reposDir is required")
+	}
+
+	// Generate a key for the fake GitHub App. The fake doesn't verify the JWTs signed with it but
+	// ghinstallation requires a valid key to sign them.
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to generate private key")
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+
+	return &Server{
+		ReposDir:    reposDir,
+		log:         zapr.NewLogger(zap.L()),
+		privateKey:  privateKey,
+		prs:         []*PullRequest{},
+		mergeQueues: map[string]bool{},
+	}, nil
+}
+
+// PrivateKey returns the PEM encoded private key for the fake GitHub App.
+func (s *Server) PrivateKey() []byte {
+	return s.privateKey
+}
+
+// CreateRepo creates an empty bare repository for org/repo whose default branch is branch.
+func (s *Server) CreateRepo(org string, repo string, branch string) error {
+	dir := s.repoDir(org, repo)
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return errors.Wrapf(err, "Failed to create directory for repo %v/%v", org, repo)
+	}
+	_, err := runGit(filepath.Dir(dir), "init", "--bare", "--initial-branch="+branch, dir)
+	return err
+}
+
+// EnableMergeQueue enables a merge queue for org/repo. Enabling auto merge for a PR adds it to the queue and
+// the PR isn't merged until FinishMergeQueue is called.
+func (s *Server) EnableMergeQueue(org string, repo string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mergeQueues[org+"/"+repo] = true
+}
+
+// FinishMergeQueue merges the PRs in the merge queue of org/repo.
+func (s *Server) FinishMergeQueue(org string, repo string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.prs {
+		if p.Org != org || p.Repo != repo || !p.InMergeQueue {
+			continue
+		}
+		if gErr := s.merge(p); gErr != nil {
+			return errors.New(gErr.Message)
+		}
+	}
+	return nil
+}
+
+// PullRequests returns a copy of all the PRs.
+func (s *Server) PullRequests() []PullRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prs := make([]PullRequest, 0, len(s.prs))
+	for _, p := range s.prs {
+		prs = append(prs, *p)
+	}
+	return prs
+}
+
+// ServeHTTP implements http.Handler. It serves both the API (api.github.com) and git (github.com) endpoints.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log := s.log.WithValues("method", r.Method, "path", r.URL.Path)
+	log.V(1).Info("Handling request")
+	switch {
+	case r.URL.Path == "/graphql" || r.URL.Path == "/api/graphql":
+		s.handleGraphQL(w, r)
+	case strings.HasPrefix(r.URL.Path, "/repos/") && strings.HasSuffix(r.URL.Path, "/installation"):
+		s.handleInstallation(w, r)
+	case strings.HasPrefix(r.URL.Path, "/app/installations/") && strings.HasSuffix(r.URL.Path, "/access_tokens"):
+		s.handleAccessToken(w, r)
+	case strings.Contains(r.URL.Path, ".git/"):
+		s.handleGit(w, r)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("The fake doesn't implement %v %v", r.Method, r.URL.Path))
+	}
+}
+
+// Serve serves the fake on the listener. This is needed for clients, e.g. the git CLI, that can't use an in-process
+// transport.
+func (s *Server) Serve(listener net.Listener) error {
+	return http.Serve(listener, s)
+}
+
+// GitConfigEnv returns environment variables that configure the git CLI to use the fake served at baseURL rather
+// than github.com. baseURL should be of the form http://127.0.0.1:<port>/.
+func GitConfigEnv(baseURL string) []string {
+	key := "url." + baseURL + ".insteadOf"
+	return []string{
+		"GIT_CONFIG_COUNT=2",
+		"GIT_CONFIG_KEY_0=" + key,
+		fmt.Sprintf("GIT_CONFIG_VALUE_0=https://x-access-token:%v@github.com/", Token),
+		"GIT_CONFIG_KEY_1=" + key,
+		"GIT_CONFIG_VALUE_1=https://github.com/",
+	}
+}
+
+func (s *Server) repoDir(org string, repo string) string {
+	return filepath.Join(s.ReposDir, org, repo+".git")
+}
+
+func (s *Server) repoExists(org string, repo string) bool {
+	_, err := os.Stat(s.repoDir(org, repo))
+	return err == nil
+}
+
+// handleInstallation handles GET /repos/{owner}/{repo}/installation
+func (s *Server) handleInstallation(w http.ResponseWriter, r *http.Request) {
+	pieces := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pieces) != 4 {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	if !s.repoExists(pieces[1], pieces[2]) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Repository %v/%v doesn't exist in %v", pieces[1], pieces[2], s.ReposDir))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": installationID})
+}
+
+// handleAccessToken handles POST /app/installations/{id}/access_tokens
+func (s *Server) handleAccessToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "access tokens must be created with POST")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"token":      Token,
+		"expires_at": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	})
+}
+
+// handleGit serves the git smart HTTP protocol using git http-backend.
+func (s *Server) handleGit(w http.ResponseWriter, r *http.Request) {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "git isn't installed")
+		return
+	}
+	h := &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + s.ReposDir,
+			"GIT_HTTP_EXPORT_ALL=1",
+			// Allow pushes. By default http-backend only allows pushes by authenticated users.
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.receivepack",
+			"GIT_CONFIG_VALUE_0=true",
+		},
+	}
+	h.ServeHTTP(w, r)
+}
+
+// runGit runs git in dir and returns the trimmed output.
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", errors.Wrapf(err, "git %v failed; output:\n%s", strings.Join(args, " "), out)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		zapr.NewLogger(zap.L()).Error(err, "Failed to write response")
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]interface{}{"message": message})
+}
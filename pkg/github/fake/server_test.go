@@ -0,0 +1,156 @@
+package fake
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/jlewi/hydros/pkg/github"
+	"github.com/jlewi/hydros/pkg/github/ghrepo"
+	"github.com/jlewi/hydros/pkg/util"
+)
+
+func Test_SyncLoop(t *testing.T) {
+	util.SetupLogger("info", true)
+	s, h := newSyncBranch(t)
+
+	existing, err := h.PullRequestForBranch()
+	if err != nil {
+		t.Fatalf("PullRequestForBranch failed; %v", err)
+	}
+	if existing != nil {
+		t.Fatalf("Expected no PR; got %+v", existing)
+	}
+
+	pr, err := h.CreatePr("Hydrate manifests\nSync the manifests", nil)
+	if err != nil {
+		t.Fatalf("Failed to create PR; %v", err)
+	}
+	if pr.Number != 1 {
+		t.Errorf("Expected PR number 1; got %v", pr.Number)
+	}
+
+	existing, err = h.PullRequestForBranch()
+	if err != nil {
+		t.Fatalf("PullRequestForBranch failed; %v", err)
+	}
+	if existing == nil || existing.Number != pr.Number {
+		t.Fatalf("Expected PullRequestForBranch to return PR %v; got %+v", pr.Number, existing)
+	}
+
+	state, err := h.MergeAndWait(pr.Number, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to merge PR; %v", err)
+	}
+	if state != github.MergedState {
+		t.Errorf("Expected PR to be merged; got %v", state)
+	}
+
+	// The change should now be on main.
+	if _, err := runGit(s.repoDir("acme", "manifests"), "cat-file", "-e", "refs/heads/main:deployment.yaml"); err != nil {
+		t.Errorf("Merged changes aren't on main; %v", err)
+	}
+
+	if prs := s.PullRequests(); len(prs) != 1 || prs[0].State != "MERGED" {
+		t.Errorf("Expected one merged PR; got %+v", prs)
+	} else if prs[0].MergeMethod != "SQUASH" {
+		t.Errorf("Expected the PR to be squash merged by default; got %v", prs[0].MergeMethod)
+	}
+}
+
+func Test_MergeQueue(t *testing.T) {
+	util.SetupLogger("info", true)
+	s, h := newSyncBranch(t)
+	s.EnableMergeQueue("acme", "manifests")
+
+	pr, err := h.CreatePr("Hydrate manifests\nSync the manifests", nil)
+	if err != nil {
+		t.Fatalf("Failed to create PR; %v", err)
+	}
+
+	// MergeOrEnqueue shouldn't wait for the merge queue.
+	for i := 0; i < 2; i++ {
+		state, err := h.MergeOrEnqueue(pr.Number, time.Minute)
+		if err != nil {
+			t.Fatalf("MergeOrEnqueue failed; %v", err)
+		}
+		if state != github.EnqueuedState {
+			t.Fatalf("Expected PR to be enqueued; got %v", state)
+		}
+	}
+
+	if err := s.FinishMergeQueue("acme", "manifests"); err != nil {
+		t.Fatalf("FinishMergeQueue failed; %v", err)
+	}
+	state, err := h.MergeOrEnqueue(pr.Number, time.Minute)
+	if err != nil {
+		t.Fatalf("MergeOrEnqueue failed; %v", err)
+	}
+	if state != github.MergedState {
+		t.Errorf("Expected PR to be merged; got %v", state)
+	}
+}
+
+// newSyncBranch creates the repository acme/manifests in a new fake and pushes the branch hydros/sync with a
+// change on top of main. It returns a RepoHelper for the branch.
+func newSyncBranch(t *testing.T) (*Server, *github.RepoHelper) {
+	s, err := NewServer(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create fake; %v", err)
+	}
+	if err := s.CreateRepo("acme", "manifests", "main"); err != nil {
+		t.Fatalf("Failed to create repo; %v", err)
+	}
+
+	// Push a commit to main and a branch with a change on top of it over git smart HTTP.
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	wDir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "--initial-branch=main"},
+		{"config", "user.email", "hydros@acme.com"},
+		{"config", "user.name", "hydros"},
+		{"remote", "add", "origin", srv.URL + "/acme/manifests.git"},
+		{"commit", "--allow-empty", "-m", "initial commit"},
+		{"push", "origin", "main"},
+		{"checkout", "-b", "hydros/sync"},
+	} {
+		if _, err := runGit(wDir, args...); err != nil {
+			t.Fatalf("Failed to set up repo; %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(wDir, "deployment.yaml"), []byte("kind: Deployment\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write file; %v", err)
+	}
+	for _, args := range [][]string{
+		{"add", "."},
+		{"commit", "-m", "hydrate manifests"},
+		{"push", "origin", "hydros/sync"},
+	} {
+		if _, err := runGit(wDir, args...); err != nil {
+			t.Fatalf("Failed to push branch; %v", err)
+		}
+	}
+
+	tr, err := ghinstallation.New(&Transport{Server: s}, AppID, installationID, s.PrivateKey())
+	if err != nil {
+		t.Fatalf("Failed to create transport; %v", err)
+	}
+
+	h, err := github.NewGithubRepoHelper(&github.RepoHelperArgs{
+		BaseRepo:   ghrepo.New("acme", "manifests"),
+		GhTr:       tr,
+		FullDir:    t.TempDir(),
+		BranchName: "hydros/sync",
+		BaseBranch: "main",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create repo helper; %v", err)
+	}
+
+	return s, h
+}
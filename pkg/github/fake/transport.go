@@ -0,0 +1,46 @@
+package fake
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/pkg/errors"
+)
+
+// hosts are the hosts whose requests are served by the fake.
+var hosts = map[string]bool{
+	"github.com":     true,
+	"api.github.com": true,
+}
+
+// Transport is an http.RoundTripper that serves requests to GitHub using the fake and forwards all other
+// requests to Next. It can be installed as http.DefaultTransport so all of the GitHub clients in hydros talk
+// to the fake without any other configuration.
+type Transport struct {
+	Server *Server
+	// Next is the transport for requests that don't go to GitHub. It is required when the Transport is installed
+	// as http.DefaultTransport.
+	Next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !hosts[req.URL.Hostname()] {
+		if t.Next == nil {
+			return nil, errors.Errorf("The fake doesn't serve %v and no transport is configured for other hosts", req.URL.Host)
+		}
+		return t.Next.RoundTrip(req)
+	}
+
+	// Handlers expect the body of server requests to be non nil.
+	if req.Body == nil {
+		req = req.Clone(req.Context())
+		req.Body = http.NoBody
+	}
+
+	rec := httptest.NewRecorder()
+	t.Server.ServeHTTP(rec, req)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
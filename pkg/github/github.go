@@ -8,7 +8,7 @@ import (
 
 	"github.com/go-logr/zapr"
 	"github.com/jlewi/hydros/pkg/config"
-	"github.com/jlewi/monogo/files"
+	"github.com/jlewi/hydros/pkg/keychain"
 	"go.uber.org/zap"
 
 	ghinstallation "github.com/bradleyfalzon/ghinstallation/v2"
@@ -89,7 +89,7 @@ type TransportManager struct {
 
 // NewTransportManagerFromConfig creates a new transport manager from the specified configuration.
 func NewTransportManagerFromConfig(cfg config.Config) (*TransportManager, error) {
-	privateKey, err := files.Read(cfg.GitHub.PrivateKey)
+	privateKey, err := keychain.Read(cfg.GitHub.PrivateKey)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Could not create GitHub transport manager; failed to read key: %s", cfg.GitHub.PrivateKey)
 	}
@@ -0,0 +1,104 @@
+package github
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/go-github/v52/github"
+	"github.com/pkg/errors"
+)
+
+// Lifecycle labels are added to the PRs created by hydros so humans scanning the list of PRs can see which PRs
+// need attention. A PR has at most one lifecycle label at a time.
+const (
+	// PendingMergeLabel indicates the PR is waiting to be merged e.g. it is in the merge queue or waiting on checks.
+	PendingMergeLabel = "hydros/pending-merge"
+	// BlockedLabel indicates the PR can't be merged e.g. because checks failed or it needs approval.
+	// Hydros won't update the branch until the PR is merged or closed.
+	BlockedLabel = "hydros/blocked"
+	// SupersededLabel indicates the PR is blocked and there are newer changes it doesn't include.
+	// It should be closed (or merged) so hydros can create a PR with the latest changes.
+	SupersededLabel = "hydros/superseded"
+	// AwaitingApprovalLabel indicates the PR won't be merged until it is approved by a human.
+	AwaitingApprovalLabel = "hydros/awaiting-approval"
+)
+
+// LifecycleLabels are all the lifecycle labels.
+var LifecycleLabels = []string{PendingMergeLabel, BlockedLabel, SupersededLabel, AwaitingApprovalLabel}
+
+// LifecycleLabelForState returns the lifecycle label for a PR in the given state. It returns the empty string
+// for PRs that are merged or closed since they no longer need a label.
+func LifecycleLabelForState(state PRMergeState) string {
+	switch state {
+	case MergedState, ClosedState:
+		return ""
+	case BlockedState:
+		return BlockedLabel
+	default:
+		return PendingMergeLabel
+	}
+}
+
+// SetLifecycleLabel sets the lifecycle label of the PR and removes any other lifecycle labels.
+// If label is the empty string all lifecycle labels are removed.
+func (h *RepoHelper) SetLifecycleLabel(prNumber int, label string) error {
+	log := h.log.WithValues("number", prNumber, "label", label)
+	client := github.NewClient(&http.Client{Transport: h.transport})
+	ctx := context.Background()
+	owner := h.baseRepo.RepoOwner()
+	repo := h.baseRepo.RepoName()
+
+	labels, _, err := client.Issues.ListLabelsByIssue(ctx, owner, repo, prNumber, &github.ListOptions{PerPage: 100})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to list labels of PR %v/%v#%v", owner, repo, prNumber)
+	}
+
+	current := make([]string, 0, len(labels))
+	for _, l := range labels {
+		current = append(current, l.GetName())
+	}
+
+	add, remove := lifecycleLabelChanges(current, label)
+	for _, l := range remove {
+		if _, err := client.Issues.RemoveLabelForIssue(ctx, owner, repo, prNumber, l); err != nil {
+			return errors.Wrapf(err, "Failed to remove label %v from PR %v/%v#%v", l, owner, repo, prNumber)
+		}
+	}
+
+	if len(add) > 0 {
+		// N.B. GitHub creates the label if it doesn't exist in the repository.
+		if _, _, err := client.Issues.AddLabelsToIssue(ctx, owner, repo, prNumber, add); err != nil {
+			return errors.Wrapf(err, "Failed to add labels %v to PR %v/%v#%v", add, owner, repo, prNumber)
+		}
+	}
+
+	if len(add) > 0 || len(remove) > 0 {
+		log.Info("Updated PR lifecycle label", "added", add, "removed", remove)
+	}
+	return nil
+}
+
+// lifecycleLabelChanges returns the labels to add and remove so that label is the only lifecycle label of a
+// PR with the current labels. Labels that aren't lifecycle labels are left alone.
+func lifecycleLabelChanges(current []string, label string) ([]string, []string) {
+	add := []string{}
+	remove := []string{}
+
+	hasLabel := false
+	for _, c := range current {
+		if c == label {
+			hasLabel = true
+			continue
+		}
+		for _, l := range LifecycleLabels {
+			if c == l {
+				remove = append(remove, c)
+			}
+		}
+	}
+
+	if label != "" && !hasLabel {
+		add = append(add, label)
+	}
+	return add, remove
+}
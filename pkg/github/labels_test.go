@@ -0,0 +1,75 @@
+package github
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_lifecycleLabelChanges(t *testing.T) {
+	type testCase struct {
+		name           string
+		current        []string
+		label          string
+		expectedAdd    []string
+		expectedRemove []string
+	}
+
+	cases := []testCase{
+		{
+			name:           "add",
+			current:        []string{"bug"},
+			label:          BlockedLabel,
+			expectedAdd:    []string{BlockedLabel},
+			expectedRemove: []string{},
+		},
+		{
+			name:           "replace",
+			current:        []string{"bug", PendingMergeLabel},
+			label:          SupersededLabel,
+			expectedAdd:    []string{SupersededLabel},
+			expectedRemove: []string{PendingMergeLabel},
+		},
+		{
+			name:           "unchanged",
+			current:        []string{PendingMergeLabel},
+			label:          PendingMergeLabel,
+			expectedAdd:    []string{},
+			expectedRemove: []string{},
+		},
+		{
+			name:           "remove-all",
+			current:        []string{BlockedLabel, "bug", SupersededLabel},
+			label:          "",
+			expectedAdd:    []string{},
+			expectedRemove: []string{BlockedLabel, SupersededLabel},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			add, remove := lifecycleLabelChanges(c.current, c.label)
+			if d := cmp.Diff(c.expectedAdd, add); d != "" {
+				t.Errorf("Unexpected labels to add; diff:\n%v", d)
+			}
+			if d := cmp.Diff(c.expectedRemove, remove); d != "" {
+				t.Errorf("Unexpected labels to remove; diff:\n%v", d)
+			}
+		})
+	}
+}
+
+func Test_LifecycleLabelForState(t *testing.T) {
+	expected := map[PRMergeState]string{
+		MergedState:   "",
+		ClosedState:   "",
+		BlockedState:  BlockedLabel,
+		EnqueuedState: PendingMergeLabel,
+		UnknownState:  PendingMergeLabel,
+	}
+	for state, label := range expected {
+		if actual := LifecycleLabelForState(state); actual != label {
+			t.Errorf("LifecycleLabelForState(%v): got %q; want %q", state, actual, label)
+		}
+	}
+}
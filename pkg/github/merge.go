@@ -50,6 +50,8 @@ type MergeOptions struct {
 	// The number for the PR
 	PRNumber int
 	Repo     ghrepo.Interface
+	// Method is the method used to merge the PR. Defaults to squash.
+	Method githubv4.PullRequestMergeMethod
 }
 
 // ErrAlreadyInMergeQueue indicates that the pull request is already in a merge queue
@@ -62,11 +64,12 @@ var ErrAlreadyInMergeQueue = errors.New("already in merge queue")
 //
 //	as possible
 //
-// ii) It uses squash method to do the merge to preserve linear history.
+// ii) It uses squash method to do the merge to preserve linear history unless another method is requested.
 type prMerger struct {
 	pr         *api.PullRequest
 	HttpClient *http.Client
 	Repo       ghrepo.Interface
+	method     githubv4.PullRequestMergeMethod
 	log        logr.Logger
 }
 
@@ -108,9 +111,12 @@ func (m *prMerger) merge() (PRMergeState, error) {
 	payload := mergePayload{
 		repo:          m.Repo,
 		pullRequestID: m.pr.ID,
-		// N.B. We are oppionated and use squash merge to give linear history.
+		// N.B. We are oppionated and default to squash merge to give linear history.
 		method: githubv4.PullRequestMergeMethodSquash,
 	}
+	if m.method != "" {
+		payload.method = m.method
+	}
 
 	// We need to set payload.auto which controls whether an
 	// https://docs.github.com/en/graphql/reference/mutations#enablepullrequestautomerge
@@ -163,10 +169,9 @@ func (adt *addAcceptHeaderTransport) RoundTrip(req *http.Request) (*http.Respons
 func newPRMerger(client *http.Client, repo ghrepo.Interface, number int) (*prMerger, error) {
 	client.Transport = &addAcceptHeaderTransport{T: client.Transport}
 
-	// N.B github/cli/cli was also fetching the fields "isInMergeQueue", "isMergeQueueEnabled" but when I tried
-	// I was getting an error those fields don't exist. I think that might be a preview feature and access to those
-	// fields might be restricted.
-	fields := []string{"id", "number", "state", "title", "lastCommit", "mergeStateStatus", "headRepositoryOwner", "headRefName", "baseRefName", "headRefOid"}
+	// N.B. "isInMergeQueue" and "isMergeQueueEnabled" used to be preview fields; they are needed to add PRs to
+	// merge queues instead of merging them directly.
+	fields := []string{"id", "number", "state", "title", "lastCommit", "mergeStateStatus", "headRepositoryOwner", "headRefName", "baseRefName", "headRefOid", "isInMergeQueue", "isMergeQueueEnabled"}
 	pr, err := fetchPR(client, repo, number, fields)
 	if err != nil {
 		return nil, err
@@ -181,16 +186,22 @@ func newPRMerger(client *http.Client, repo ghrepo.Interface, number int) (*prMer
 	}, nil
 }
 
-// MergePR merges a PR.
+// MergePR merges a PR using squash merge.
 // client - http client to use to talk to github
 // repo - the repo that owns the PR
 // number - the PR number to merge
 func MergePR(client *http.Client, repo ghrepo.Interface, number int) (PRMergeState, error) {
-	m, err := newPRMerger(client, repo, number)
+	return MergePRWithOptions(MergeOptions{HttpClient: client, Repo: repo, PRNumber: number})
+}
+
+// MergePRWithOptions merges the PR in opts using the merge method in opts.
+func MergePRWithOptions(opts MergeOptions) (PRMergeState, error) {
+	m, err := newPRMerger(opts.HttpClient, opts.Repo, opts.PRNumber)
 
 	if err != nil {
 		return UnknownState, err
 	}
+	m.method = opts.Method
 	return m.merge()
 }
 
@@ -244,8 +255,7 @@ func init() {
 }
 
 // mergePullRequest is a helper function to actually merge the payload.
-// N.B. This function supports all the different merge methods because the code was inherited from GitHub's cli
-// so why not? But the higher APIs that call it don't support that.
+// N.B. This function supports all the different merge methods because the code was inherited from GitHub's cli.
 //
 // This will either issue an https://docs.github.com/en/graphql/reference/mutations#enablepullrequestautomerge
 // or a https://docs.github.com/en/graphql/reference/mutations#mergepullrequest depending on the value of auto.
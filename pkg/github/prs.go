@@ -1,6 +1,7 @@
 package github
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -22,6 +23,7 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
 	"github.com/jlewi/hydros/pkg/github/ghrepo"
+	"github.com/jlewi/hydros/pkg/poll"
 	"github.com/pkg/errors"
 	"github.com/shurcooL/githubv4"
 	"go.uber.org/zap"
@@ -50,11 +52,14 @@ type RepoHelper struct {
 	client     *api.Client
 	baseRepo   ghrepo.Interface
 	fullDir    string
+	cloneDir   string
 	name       string
 	email      string
 	remote     string
 	BranchName string
 	BaseBranch string
+
+	mergeMethod githubv4.PullRequestMergeMethod
 }
 
 // RepoHelperArgs is the arguments used to instantiate the object.
@@ -64,6 +69,10 @@ type RepoHelperArgs struct {
 	// GhTr is the GitHub transport used to authenticate as a GitHub App. If nil a transport will not be used.
 	GhTr    *ghinstallation.Transport
 	FullDir string
+	// CloneDir is an optional directory for a clone of the repository that is shared by multiple RepoHelpers.
+	// If set, FullDir is created as a git worktree of that clone. This allows multiple branches of the same
+	// repository to be prepared concurrently without cloning the repository for each branch.
+	CloneDir string
 	// Name is the name attached to commits.
 	Name string
 	// Email is the email attached to commits
@@ -78,6 +87,9 @@ type RepoHelperArgs struct {
 	// BaseBranch is the name of the branch to use as the base.
 	// This is all the branch to which the PR will be merged
 	BaseBranch string
+
+	// MergeMethod is the method used to merge PRs. Defaults to squash.
+	MergeMethod githubv4.PullRequestMergeMethod
 }
 
 // NewGithubRepoHelper creates a helper for a specific repository.
@@ -132,10 +144,13 @@ func NewGithubRepoHelper(args *RepoHelperArgs) (*RepoHelper, error) {
 		baseRepo:   args.BaseRepo,
 		log:        zapr.NewLogger(zap.L()),
 		fullDir:    args.FullDir,
+		cloneDir:   args.CloneDir,
 		email:      args.Email,
 		remote:     args.Remote,
 		BranchName: args.BranchName,
 		BaseBranch: args.BaseBranch,
+
+		mergeMethod: args.MergeMethod,
 	}
 
 	return h, nil
@@ -352,7 +367,8 @@ func (h *RepoHelper) PullRequestForBranch() (*PullRequest, error) {
 
 // PrepareBranch prepares a branch. This will do the following
 // 1. Clone the repository if it hasn't already been cloned
-// 2. Create a branch if one doesn't already exist.
+// 2. If CloneDir is set, add a worktree of the clone at FullDir if one doesn't already exist
+// 3. Create a branch if one doesn't already exist.
 //
 // If dropChanges is true if the working tree is dirty the changes will be ignored.
 //
@@ -386,64 +402,91 @@ func (h *RepoHelper) PrepareBranch(dropChanges bool) error {
 
 	log.Info("URL and Auth configured", "url", url, "appAuth", appAuth)
 
-	// Clone the repository if it hasn't already been cloned.
-	err := func() error {
-		if _, err := os.Stat(h.fullDir); err == nil {
-			log.Info("Directory exists; repository will not be cloned", "directory", h.fullDir)
-			return nil
+	// repoDir is the clone that fetches and config changes are applied to.
+	repoDir := h.fullDir
+	if h.cloneDir != "" {
+		repoDir = h.cloneDir
+		log = log.WithValues("cloneDir", h.cloneDir)
+	}
+
+	if err := func() error {
+		unlock := lockClone(repoDir)
+		defer unlock()
+
+		// Clone the repository if it hasn't already been cloned.
+		err := func() error {
+			if _, err := os.Stat(repoDir); err == nil {
+				log.Info("Directory exists; repository will not be cloned", "directory", repoDir)
+				return nil
+			}
+
+			opts := &git.CloneOptions{
+				URL:      url,
+				Auth:     appAuth,
+				Progress: os.Stdout,
+			}
+
+			_, err := git.PlainClone(repoDir, false, opts)
+			return err
+		}()
+
+		if err != nil {
+			return err
 		}
 
-		opts := &git.CloneOptions{
-			URL:      url,
-			Auth:     appAuth,
-			Progress: os.Stdout,
+		// Open the repository
+		r, err := openRepo(repoDir)
+		if err != nil {
+			return err
 		}
 
-		_, err := git.PlainClone(h.fullDir, false, opts)
-		return err
-	}()
+		// Do a fetch to make sure the remote is up to date.
+		log.Info("Fetching remote", "remote", h.remote)
+		if err := r.Fetch(&git.FetchOptions{
+			RemoteName: h.remote,
+			Auth:       appAuth,
+			// TODO(jeremy): Do we need to specify refspec?
+			// RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("refs/heads/*:refs/remotes/%v/*", h.remote))},
+		}); err != nil {
+			// Fetch returns an error if its already up to date and we want to ignore that.
+			if err.Error() != "already up-to-date" {
+				return err
+			}
+		}
 
-	if err != nil {
-		return err
-	}
+		// config reads .git/config
+		// We can use this to determine how the repository is setup to figure out what we need to do
+		cfg, err := r.Config()
+		if err != nil {
+			return err
+		}
 
-	// Open the repository
-	r, err := git.PlainOpenWithOptions(h.fullDir, &git.PlainOpenOptions{})
-	if err != nil {
-		return errors.Wrapf(err, "Could not open respoistory at %v; ensure the directory contains a git repo", h.fullDir)
-	}
+		// Set email and name of the author
+		// This is equivalent to git config user.email
+		// TODO(jeremy): I'm not sure we need to do this. I believe the name and email get specified explicitly in
+		// the options to push and don't get inherited from the config automatically.
+		log.Info("Updating email and name for commits")
+		cfg.User.Email = h.email
+		cfg.User.Name = h.name
 
-	// Do a fetch to make sure the remote is up to date.
-	log.Info("Fetching remote", "remote", h.remote)
-	if err := r.Fetch(&git.FetchOptions{
-		RemoteName: h.remote,
-		Auth:       appAuth,
-		// TODO(jeremy): Do we need to specify refspec?
-		// RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("refs/heads/*:refs/remotes/%v/*", h.remote))},
-	}); err != nil {
-		// Fetch returns an error if its already up to date and we want to ignore that.
-		if err.Error() != "already up-to-date" {
+		// Need to update the config for the changes to take effect
+		if err := r.Storer.SetConfig(cfg); err != nil {
 			return err
 		}
-	}
 
-	// config reads .git/config
-	// We can use this to determine how the repository is setup to figure out what we need to do
-	cfg, err := r.Config()
-	if err != nil {
+		if h.cloneDir != "" {
+			log.Info("Adding worktree", "worktree", h.fullDir)
+			if err := addWorktree(h.cloneDir, h.fullDir); err != nil {
+				return err
+			}
+		}
+		return nil
+	}(); err != nil {
 		return err
 	}
 
-	// Set email and name of the author
-	// This is equivalent to git config user.email
-	// TODO(jeremy): I'm not sure we need to do this. I believe the name and email get specified explicitly in
-	// the options to push and don't get inherited from the config automatically.
-	log.Info("Updating email and name for commits")
-	cfg.User.Email = h.email
-	cfg.User.Name = h.name
-
-	// Need to update the config for the changes to take effect
-	if err := r.Storer.SetConfig(cfg); err != nil {
+	r, err := openRepo(h.fullDir)
+	if err != nil {
 		return err
 	}
 
@@ -513,7 +556,7 @@ func (h *RepoHelper) HasChanges() (bool, error) {
 	log = log.WithValues("org", h.baseRepo.RepoOwner(), "repo", h.baseRepo.RepoName(), "dir", h.fullDir)
 
 	// Open the repository
-	r, err := git.PlainOpenWithOptions(h.fullDir, &git.PlainOpenOptions{})
+	r, err := openRepo(h.fullDir)
 	if err != nil {
 		return false, err
 	}
@@ -534,7 +577,7 @@ func (h *RepoHelper) HasChanges() (bool, error) {
 
 // Head returns the reference of the head commit of the branch.
 func (h *RepoHelper) Head() (*plumbing.Reference, error) {
-	gitRepo, err := git.PlainOpenWithOptions(h.fullDir, &git.PlainOpenOptions{})
+	gitRepo, err := openRepo(h.fullDir)
 	if err != nil {
 		return nil, err
 	}
@@ -551,7 +594,7 @@ func (h *RepoHelper) CommitAndPush(message string, force bool) error {
 	log = log.WithValues("org", h.baseRepo.RepoOwner(), "repo", h.baseRepo.RepoName(), "dir", h.fullDir)
 
 	// Open the repository
-	r, err := git.PlainOpenWithOptions(h.fullDir, &git.PlainOpenOptions{})
+	r, err := openRepo(h.fullDir)
 	if err != nil {
 		return err
 	}
@@ -645,16 +688,27 @@ func (h *RepoHelper) Dir() string {
 func (h *RepoHelper) MergePR(prNumber int) (PRMergeState, error) {
 	client := &http.Client{Transport: h.transport}
 
-	return MergePR(client, h.baseRepo, prNumber)
+	return MergePRWithOptions(MergeOptions{HttpClient: client, Repo: h.baseRepo, PRNumber: prNumber, Method: h.mergeMethod})
 }
 
 // MergeAndWait merges the PR and waits for it to be merged.
+// If it times out the last observed state of the PR is returned along with an error.
 func (h *RepoHelper) MergeAndWait(prNumber int, timeout time.Duration) (PRMergeState, error) {
-	done := false
+	return h.mergeAndWait(prNumber, timeout, false)
+}
+
+// MergeOrEnqueue is like MergeAndWait except it returns EnqueuedState as soon as the PR is in a merge queue rather
+// than waiting for the merge queue to merge it. Callers are expected to check the PR again when the merge queue
+// finishes e.g. in response to a merge_group webhook.
+func (h *RepoHelper) MergeOrEnqueue(prNumber int, timeout time.Duration) (PRMergeState, error) {
+	return h.mergeAndWait(prNumber, timeout, true)
+}
+
+func (h *RepoHelper) mergeAndWait(prNumber int, timeout time.Duration, returnIfEnqueued bool) (PRMergeState, error) {
+	lastState := UnknownState
 	log := h.log.WithValues("number", prNumber)
-	wait := 10 * time.Second
-	for endTime := time.Now().Add(timeout); endTime.After(time.Now()) && !done; {
-		state := func() PRMergeState {
+	condition := func(ctx context.Context) (bool, error) {
+		lastState = func() PRMergeState {
 			pr, err := h.FetchPR(prNumber)
 			if err != nil {
 				log.Error(err, "Failed to fetch PR; unable to confirm if its been merged")
@@ -678,32 +732,29 @@ func (h *RepoHelper) MergeAndWait(prNumber int, timeout time.Duration) (PRMergeS
 			return state
 		}()
 
-		switch state {
-		case ClosedState:
-			fallthrough
-		case MergedState:
-			return state, nil
+		switch lastState {
+		case ClosedState, MergedState:
+			return true, nil
 		case EnqueuedState:
-			fallthrough
-		case UnknownState:
-			fallthrough
-		case BlockedState:
-			fallthrough
+			return returnIfEnqueued, nil
 		default:
-			if endTime.After(time.Now().Add(wait)) {
-				time.Sleep(wait)
-			}
+			return false, nil
 		}
 	}
 
-	return UnknownState, errors.Errorf("Timed out waiting for PR to merge")
+	// Back off since PRs waiting on checks or in a merge queue can take a long time to merge.
+	err := poll.Until(context.Background(), condition, poll.WithTimeout(timeout), poll.WithInterval(10*time.Second), poll.WithBackoff(2, time.Minute))
+	if poll.IsTimeout(err) {
+		return lastState, errors.Errorf("Timed out waiting for PR to merge")
+	}
+	return lastState, err
 }
 
 func (h *RepoHelper) FetchPR(prNumber int) (*api.PullRequest, error) {
 	// We need to set the appropriate header in oder to get merge queue status.
 	transport := &addAcceptHeaderTransport{T: h.transport}
 	client := &http.Client{Transport: transport}
-	fields := []string{"id", "number", "state", "title", "lastCommit", "mergeStateStatus", "headRepositoryOwner", "headRefName", "baseRefName", "headRefOid"}
+	fields := []string{"id", "number", "state", "title", "lastCommit", "mergeStateStatus", "headRepositoryOwner", "headRefName", "baseRefName", "headRefOid", "isInMergeQueue", "isMergeQueueEnabled"}
 	return fetchPR(client, h.baseRepo, prNumber, fields)
 }
 
@@ -0,0 +1,78 @@
+package github
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+)
+
+// cloneLocks serializes operations that modify the state shared by all the worktrees of a clone; e.g. fetching
+// or adding worktrees. Operations on a single worktree (e.g. checking out a branch or committing) don't need
+// the lock so multiple branches of the same repository can be prepared concurrently.
+var cloneLocks = struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}{
+	locks: map[string]*sync.Mutex{},
+}
+
+// lockClone locks the clone in dir and returns a function to unlock it.
+func lockClone(dir string) func() {
+	dir = filepath.Clean(dir)
+	cloneLocks.mu.Lock()
+	l, ok := cloneLocks.locks[dir]
+	if !ok {
+		l = &sync.Mutex{}
+		cloneLocks.locks[dir] = l
+	}
+	cloneLocks.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// openRepo opens the repository in dir. dir can be a clone or a worktree of a clone.
+func openRepo(dir string) (*git.Repository, error) {
+	r, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{
+		// N.B. EnableDotGitCommonDir is needed to resolve refs and objects stored in the clone when dir is a
+		// worktree. It is a no-op for regular clones.
+		EnableDotGitCommonDir: true,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not open repository at %v; ensure the directory contains a git repo", dir)
+	}
+	return r, nil
+}
+
+// addWorktree adds a worktree of the clone in cloneDir at worktreeDir. The worktree is created with a detached
+// HEAD so that it doesn't lock any branch; callers are expected to check out the branch they need.
+// It is a no-op if worktreeDir already exists. Callers must hold the lock for cloneDir.
+func addWorktree(cloneDir string, worktreeDir string) error {
+	if _, err := os.Stat(worktreeDir); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(worktreeDir), util.FilePermUserGroup); err != nil {
+		return errors.Wrapf(err, "Failed to create directory for worktree %v", worktreeDir)
+	}
+
+	// Prune worktrees whose directories were deleted so they don't block recreating them.
+	commands := [][]string{
+		{"git", "worktree", "prune"},
+		{"git", "worktree", "add", "--detach", worktreeDir},
+	}
+	for _, c := range commands {
+		cmd := exec.Command(c[0], c[1:]...)
+		cmd.Dir = cloneDir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return errors.Wrapf(err, "Failed to run %v; output:\n%v", strings.Join(c, " "), string(out))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package github
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func Test_addWorktree(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skipf("git isn't installed")
+	}
+
+	dir, err := os.MkdirTemp("", "testWorktree")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cloneDir := filepath.Join(dir, "clone")
+	r, err := git.PlainInit(cloneDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repo; %v", err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatalf("Failed to get worktree; %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(cloneDir, "README.md"), []byte("hello"), 0o644); err != nil {
+		t.Fatalf("Failed to write file; %v", err)
+	}
+	if _, err := w.Add("README.md"); err != nil {
+		t.Fatalf("Failed to add file; %v", err)
+	}
+	sig := &object.Signature{Name: "test", Email: "test@acme.com", When: time.Now()}
+	base, err := w.Commit("initial commit", &git.CommitOptions{Author: sig})
+	if err != nil {
+		t.Fatalf("Failed to commit; %v", err)
+	}
+
+	// Prepare multiple branches concurrently; each in its own worktree.
+	branches := []string{"pr-a", "pr-b", "pr-c"}
+	var wg sync.WaitGroup
+	for _, b := range branches {
+		wg.Add(1)
+		go func(branch string) {
+			defer wg.Done()
+			if err := commitOnBranch(cloneDir, filepath.Join(dir, "worktrees", branch), branch, base, sig); err != nil {
+				t.Errorf("Failed to commit on branch %v; %v", branch, err)
+			}
+		}(b)
+	}
+	wg.Wait()
+
+	// The branches should be visible in the shared clone.
+	for _, b := range branches {
+		ref, err := r.Reference(plumbing.NewBranchReferenceName(b), true)
+		if err != nil {
+			t.Errorf("Branch %v wasn't found in the clone; %v", b, err)
+			continue
+		}
+		c, err := r.CommitObject(ref.Hash())
+		if err != nil {
+			t.Errorf("Failed to get commit for branch %v; %v", b, err)
+			continue
+		}
+		if c.Message != "update "+b {
+			t.Errorf("Branch %v has commit %q; want %q", b, c.Message, "update "+b)
+		}
+	}
+
+	// Adding an existing worktree should be a no-op.
+	unlock := lockClone(cloneDir)
+	defer unlock()
+	if err := addWorktree(cloneDir, filepath.Join(dir, "worktrees", branches[0])); err != nil {
+		t.Errorf("addWorktree failed for an existing worktree; %v", err)
+	}
+}
+
+// commitOnBranch adds a worktree, checks out branch and commits a change to it.
+func commitOnBranch(cloneDir string, worktreeDir string, branch string, base plumbing.Hash, sig *object.Signature) error {
+	if err := func() error {
+		unlock := lockClone(cloneDir)
+		defer unlock()
+		return addWorktree(cloneDir, worktreeDir)
+	}(); err != nil {
+		return err
+	}
+
+	r, err := openRepo(worktreeDir)
+	if err != nil {
+		return err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	if err := w.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch), Create: true, Hash: base}); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(worktreeDir, branch+".txt"), []byte(branch), 0o644); err != nil {
+		return err
+	}
+	if err := w.AddWithOptions(&git.AddOptions{All: true}); err != nil {
+		return err
+	}
+	if _, err := w.Commit(fmt.Sprintf("update %v", branch), &git.CommitOptions{Author: sig}); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package gitops
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/apply"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// applyToCluster applies the hydrated manifests below dir to the cluster of the ManifestSync with server-side
+// apply. The lastsync file isn't applied. It is a no-op if the ManifestSync doesn't apply the manifests.
+func applyToCluster(ctx context.Context, m *v1alpha1.ManifestSync, dir string) error {
+	if m.Spec.Apply == nil || m.Spec.Apply.Cluster == nil {
+		return nil
+	}
+	log := logr.FromContextOrDiscard(ctx)
+
+	nodes, err := (&kio.LocalPackageReader{PackagePath: dir}).Read()
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read the hydrated manifests in %v", dir)
+	}
+	resources := make([]*yaml.RNode, 0, len(nodes))
+	for _, n := range nodes {
+		path, _, err := kioutil.GetFileAnnotations(n)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to get the path of %v/%v", n.GetKind(), n.GetName())
+		}
+		if path == lastSyncFile {
+			continue
+		}
+		resources = append(resources, n)
+	}
+
+	applier, err := apply.NewApplierForCluster(m.Spec.Apply.Cluster, m.Metadata.Name)
+	if err != nil {
+		return err
+	}
+	result, err := applier.Apply(ctx, resources)
+	if err != nil {
+		log.Error(err, "Failed to apply the hydrated manifests", "dir", dir)
+		return err
+	}
+	log.Info("Applied the hydrated manifests", "applied", len(result.Applied), "pruned", result.Pruned)
+	return nil
+}
+
+// applyDestination applies the manifests in the dest path of the destination to the cluster if the head of the dest
+// branch changed since they were last applied. The apply is keyed on the dest commit rather than on merging a PR so
+// manifests merged after the run that created the PR e.g. by auto-merge or a merge queue are applied by the next run.
+func (s *syncRun) applyDestination(ctx context.Context, d *destination) error {
+	m := s.manifest
+	if m.Spec.Apply == nil || m.Spec.Apply.Cluster == nil || m.Spec.Apply.ApplyOnly() {
+		return nil
+	}
+	log := s.log.WithValues("destination", d.Name)
+	destDir := s.repoKeyToDir(d.destKey)
+
+	// N.B. Fetch the dest branch since a PR may have been merged after the repos were cloned.
+	if err := s.execHelper.RunCommands([][]string{
+		{"git", "fetch", "origin", d.DestRepo.Branch},
+		{"git", "checkout", "origin/" + d.DestRepo.Branch},
+	}, func(cmd *exec.Cmd) {
+		cmd.Dir = destDir
+	}); err != nil {
+		return err
+	}
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = destDir
+	output, err := s.execHelper.RunQuietly(cmd)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get the head of the dest branch; output: %v", output)
+	}
+	head := strings.TrimSpace(output)
+	if head == d.appliedCommit {
+		log.V(util.Debug).Info("Manifests in the dest branch were already applied", "destCommit", head)
+		return nil
+	}
+
+	dir := filepath.Join(destDir, d.DestPath)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		log.Info("Dest path doesn't exist yet; nothing to apply", "destCommit", head, "destPath", d.DestPath)
+		return nil
+	}
+	if err := s.applyManifests(logr.NewContext(ctx, log), m, dir); err != nil {
+		return err
+	}
+	log.Info("Applied the manifests in the dest branch", "destCommit", head)
+	d.appliedCommit = head
+	return nil
+}
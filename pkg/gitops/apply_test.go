@@ -0,0 +1,116 @@
+package gitops
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/zapr"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/util"
+	"go.uber.org/zap"
+)
+
+func Test_applyDestination(t *testing.T) {
+	dir := t.TempDir()
+	for _, v := range []string{"GIT_AUTHOR_NAME", "GIT_AUTHOR_EMAIL", "GIT_COMMITTER_NAME", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(v, "test@acme.com")
+	}
+
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed; %v; output:\n%v", args, err, string(out))
+		}
+		return strings.TrimSpace(string(out))
+	}
+	// merge stands in for a PR being merged into the dest branch outside of the sync e.g. by auto-merge.
+	merge := func(contents string) string {
+		upstream := filepath.Join(dir, "upstream")
+		p := filepath.Join(upstream, "hydrated", "deployment.yaml")
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("Failed to create dir; %v", err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatalf("Failed to write %v; %v", p, err)
+		}
+		git(upstream, "add", ".")
+		git(upstream, "commit", "-q", "-m", "Update hydrated manifests")
+		git(upstream, "push", "-q", "origin", "HEAD:main")
+		return git(upstream, "rev-parse", "HEAD")
+	}
+
+	origin := filepath.Join(dir, "origin.git")
+	git(dir, "init", "-q", "--bare", origin)
+	git(dir, "clone", "-q", origin, "upstream")
+	first := merge("replicas: 1")
+
+	workDir := filepath.Join(dir, "work")
+	if err := os.MkdirAll(workDir, 0o755); err != nil {
+		t.Fatalf("Failed to create dir; %v", err)
+	}
+	git(workDir, "clone", "-q", origin, destKey)
+
+	applied := []string{}
+	log := zapr.NewLogger(zap.L())
+	s := &syncRun{
+		Syncer: &Syncer{
+			workDir: workDir,
+			applyManifests: func(ctx context.Context, m *v1alpha1.ManifestSync, dir string) error {
+				b, err := os.ReadFile(filepath.Join(dir, "deployment.yaml"))
+				if err != nil {
+					return err
+				}
+				applied = append(applied, string(b))
+				return nil
+			},
+		},
+		log: log,
+		manifest: &v1alpha1.ManifestSync{
+			Spec: v1alpha1.ManifestSyncSpec{
+				Apply: &v1alpha1.ApplyConfig{Cluster: &v1alpha1.ClusterApply{}},
+			},
+		},
+		execHelper: &util.ExecHelper{Log: log},
+	}
+	d := &destination{
+		Destination: v1alpha1.Destination{
+			DestRepo: v1alpha1.GitHubRepo{Branch: "main"},
+			DestPath: "hydrated",
+		},
+		destKey: destKey,
+	}
+
+	if err := s.applyDestination(context.Background(), d); err != nil {
+		t.Fatalf("applyDestination failed; %+v", err)
+	}
+	if d.appliedCommit != first {
+		t.Errorf("Expected applied commit %v; got %v", first, d.appliedCommit)
+	}
+
+	// Nothing is applied if the dest branch didn't change.
+	if err := s.applyDestination(context.Background(), d); err != nil {
+		t.Fatalf("applyDestination failed; %+v", err)
+	}
+	if len(applied) != 1 {
+		t.Fatalf("Expected the manifests to be applied once; got %v", applied)
+	}
+
+	// The PR is merged after the run that created it returned so the next run applies it.
+	second := merge("replicas: 3")
+	if err := s.applyDestination(context.Background(), d); err != nil {
+		t.Fatalf("applyDestination failed; %+v", err)
+	}
+	if d.appliedCommit != second {
+		t.Errorf("Expected applied commit %v; got %v", second, d.appliedCommit)
+	}
+	expected := []string{"replicas: 1", "replicas: 3"}
+	if strings.Join(applied, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected applied manifests %v; got %v", expected, applied)
+	}
+}
@@ -0,0 +1,160 @@
+package gitops
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// argoApplication is an ArgoCD Application. Only the fields set by hydros are included.
+// See: https://argo-cd.readthedocs.io/en/stable/operator-manual/declarative-setup/#applications
+type argoApplication struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   argoMetadata      `yaml:"metadata"`
+	Spec       argoApplicationSp `yaml:"spec"`
+}
+
+type argoMetadata struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+}
+
+type argoApplicationSp struct {
+	Project     string          `yaml:"project"`
+	Source      argoSource      `yaml:"source"`
+	Destination argoDestination `yaml:"destination"`
+	SyncPolicy  argoSyncPolicy  `yaml:"syncPolicy"`
+}
+
+type argoSource struct {
+	RepoURL        string        `yaml:"repoURL"`
+	TargetRevision string        `yaml:"targetRevision"`
+	Path           string        `yaml:"path"`
+	Directory      argoDirectory `yaml:"directory"`
+}
+
+type argoDirectory struct {
+	Recurse bool   `yaml:"recurse"`
+	Exclude string `yaml:"exclude,omitempty"`
+}
+
+type argoDestination struct {
+	Server    string `yaml:"server"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+type argoSyncPolicy struct {
+	Automated argoAutomated `yaml:"automated"`
+}
+
+type argoAutomated struct {
+	Prune    bool `yaml:"prune"`
+	SelfHeal bool `yaml:"selfHeal"`
+}
+
+// buildApplication returns the ArgoCD Application deploying the hydrated manifests of the destination of the
+// ManifestSync named sync.
+func buildApplication(sync string, config *v1alpha1.ArgoCDApplication, d v1alpha1.Destination) *argoApplication {
+	orDefault := func(v string, defaultValue string) string {
+		if v == "" {
+			return defaultValue
+		}
+		return v
+	}
+	destPath := path.Clean(v1alpha1.RootRelative(d.DestPath))
+	return &argoApplication{
+		APIVersion: "argoproj.io/v1alpha1",
+		Kind:       "Application",
+		Metadata: argoMetadata{
+			Name:      config.ApplicationName(sync, d),
+			Namespace: orDefault(config.Namespace, v1alpha1.DefaultArgoCDNamespace),
+		},
+		Spec: argoApplicationSp{
+			Project: orDefault(config.Project, v1alpha1.DefaultArgoCDProject),
+			Source: argoSource{
+				RepoURL:        fmt.Sprintf("https://github.com/%v/%v.git", d.DestRepo.Org, d.DestRepo.Repo),
+				TargetRevision: d.DestRepo.Branch,
+				Path:           destPath,
+				Directory: argoDirectory{
+					Recurse: true,
+					// The lastsync file records the status of the sync; it isn't a resource to deploy.
+					Exclude: lastSyncFile,
+				},
+			},
+			Destination: argoDestination{
+				Server:    orDefault(config.Server, v1alpha1.DefaultArgoCDServer),
+				Namespace: config.DestinationNamespace,
+			},
+			SyncPolicy: argoSyncPolicy{
+				Automated: argoAutomated{
+					Prune:    config.Prune,
+					SelfHeal: config.SelfHeal,
+				},
+			},
+		},
+	}
+}
+
+// writeApplication writes the ArgoCD Application of the destination to the checkout of the dest repo in repoDir.
+// It returns the path of the file relative to repoDir or an empty string if the ManifestSync doesn't generate an
+// Application.
+func writeApplication(repoDir string, m *v1alpha1.ManifestSync, d v1alpha1.Destination) (string, error) {
+	if m.Spec.ArgoCD == nil {
+		return "", nil
+	}
+	relPath := m.Spec.ArgoCD.ApplicationPath(m.Metadata.Name, d)
+	p := filepath.Join(repoDir, filepath.FromSlash(relPath))
+	if err := os.MkdirAll(filepath.Dir(p), util.FilePermUserGroup); err != nil {
+		return "", errors.Wrapf(err, "Failed to create directory for %v", p)
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to create %v", p)
+	}
+	defer f.Close()
+	e := yaml.NewEncoder(f)
+	e.SetIndent(2)
+	if err := e.Encode(buildApplication(m.Metadata.Name, m.Spec.ArgoCD, d)); err != nil {
+		return "", errors.Wrapf(err, "Failed to write ArgoCD Application %v", p)
+	}
+	if err := e.Close(); err != nil {
+		return "", errors.Wrapf(err, "Failed to write ArgoCD Application %v", p)
+	}
+	return relPath, nil
+}
+
+// commitApplication commits the ArgoCD Application at appPath in forkDir if it changed.
+func (s *syncRun) commitApplication(forkDir string, appPath string) error {
+	if appPath == "" {
+		return nil
+	}
+	cmd := exec.Command("git", "status", "--porcelain", "--", appPath)
+	cmd.Dir = forkDir
+	out, err := cmd.Output()
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get the status of %v", appPath)
+	}
+	if strings.TrimSpace(string(out)) == "" {
+		return nil
+	}
+	for _, args := range [][]string{
+		{"add", "--", appPath},
+		{"commit", "-m", fmt.Sprintf("Update ArgoCD Application %v", appPath)},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = forkDir
+		if err := s.execHelper.Run(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package gitops
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/api/v1alpha1"
+)
+
+func Test_writeApplication(t *testing.T) {
+	dir, err := os.MkdirTemp("", "testWriteApplication")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := &v1alpha1.ManifestSync{
+		Metadata: v1alpha1.Metadata{Name: "hydros"},
+		Spec: v1alpha1.ManifestSyncSpec{
+			ArgoCD: &v1alpha1.ArgoCDApplication{
+				DestinationNamespace: "hydros",
+				SelfHeal:             true,
+			},
+		},
+	}
+	d := v1alpha1.Destination{
+		DestRepo: v1alpha1.GitHubRepo{Org: "jlewi", Repo: "hydrated", Branch: "main"},
+		DestPath: "/hydros/",
+	}
+
+	relPath, err := writeApplication(dir, m, d)
+	if err != nil {
+		t.Fatalf("writeApplication failed; %+v", err)
+	}
+	if relPath != "argocd/hydros.yaml" {
+		t.Errorf("Unexpected path; got %v", relPath)
+	}
+
+	actual, err := os.ReadFile(filepath.Join(dir, relPath))
+	if err != nil {
+		t.Fatalf("Failed to read the application; %v", err)
+	}
+	expected := `apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: hydros
+  namespace: argocd
+spec:
+  project: default
+  source:
+    repoURL: https://github.com/jlewi/hydrated.git
+    targetRevision: main
+    path: hydros
+    directory:
+      recurse: true
+      exclude: .lastsync.yaml
+  destination:
+    server: https://kubernetes.default.svc
+    namespace: hydros
+  syncPolicy:
+    automated:
+      prune: false
+      selfHeal: true
+`
+	if d := cmp.Diff(expected, string(actual)); d != "" {
+		t.Errorf("Unexpected application; diff:\n%v", d)
+	}
+
+	m.Spec.ArgoCD = nil
+	if relPath, err := writeApplication(dir, m, d); err != nil || relPath != "" {
+		t.Errorf("Expected no application to be written; got %v, %v", relPath, err)
+	}
+}
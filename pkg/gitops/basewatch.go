@@ -0,0 +1,286 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	ghAPI "github.com/google/go-github/v52/github"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/images"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// BaseImageStateKind is the kind of the document recording what a BaseImageWatch saw when it was last
+	// reconciled.
+	BaseImageStateKind = "BaseImageState"
+)
+
+// BaseImageState records the base images a BaseImageWatch saw when it was last reconciled so changes can be
+// detected.
+type BaseImageState struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	// UpdateTime is when the state was last written.
+	UpdateTime time.Time `yaml:"updateTime"`
+	// Digests maps each base image to the digest it resolved to.
+	Digests map[string]string `yaml:"digests,omitempty"`
+	// Advisories are the advisories the images were rebuilt for. Each is the ID of the advisory and the affected
+	// base image separated by a space.
+	Advisories []string `yaml:"advisories,omitempty"`
+}
+
+// advisoryKey returns the key recording that the images built from image were rebuilt for the advisory.
+func advisoryKey(a v1alpha1.Advisory, image string) string {
+	return a.ID + " " + image
+}
+
+// checkBaseImage returns the reasons the images built from the base image need to be rebuilt and the keys of
+// the advisories affecting it that haven't been acted on. A base image that wasn't seen before isn't a reason to
+// rebuild; its digest is only recorded.
+func checkBaseImage(state *BaseImageState, image string, digest string, advisories []v1alpha1.Advisory) ([]string, []string) {
+	reasons := []string{}
+	keys := []string{}
+	if last, ok := state.Digests[image]; ok && last != digest {
+		reasons = append(reasons, fmt.Sprintf("%v changed from %v to %v", image, last, digest))
+	}
+
+	handled := map[string]bool{}
+	for _, k := range state.Advisories {
+		handled[k] = true
+	}
+	for _, a := range advisories {
+		key := advisoryKey(a, image)
+		if handled[key] || !a.Matches(image, digest) {
+			continue
+		}
+		reasons = append(reasons, fmt.Sprintf("%v is affected by %v", image, a.ID))
+		keys = append(keys, key)
+	}
+	return reasons, keys
+}
+
+// updateBaseImageState returns the state to record after a reconcile. The digests and advisories of base images
+// in failed are left unchanged so the images built from them are rebuilt again on the next reconcile.
+func updateBaseImageState(previous *BaseImageState, digests map[string]string, advisories map[string]string, failed map[string]bool, now time.Time) *BaseImageState {
+	state := &BaseImageState{
+		APIVersion: v1alpha1.Group + "/" + v1alpha1.Version,
+		Kind:       BaseImageStateKind,
+		UpdateTime: now,
+		Digests:    map[string]string{},
+		Advisories: []string{},
+	}
+	for image, digest := range digests {
+		if last, ok := previous.Digests[image]; ok && failed[image] {
+			digest = last
+		}
+		state.Digests[image] = digest
+	}
+
+	state.Advisories = append(state.Advisories, previous.Advisories...)
+	for key, image := range advisories {
+		if failed[image] {
+			continue
+		}
+		state.Advisories = append(state.Advisories, key)
+	}
+	sort.Strings(state.Advisories)
+	return state
+}
+
+// ReadBaseImageState reads the state written to uri. It returns an empty state if uri doesn't exist.
+func ReadBaseImageState(ctx context.Context, uri string) (*BaseImageState, error) {
+	b, err := readStatusObject(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	state := &BaseImageState{}
+	if len(b) == 0 {
+		return state, nil
+	}
+	if err := yaml.Unmarshal(b, state); err != nil {
+		return nil, errors.Wrapf(err, "Failed to decode state %v", uri)
+	}
+	return state, nil
+}
+
+// WriteBaseImageState writes the state to uri.
+func WriteBaseImageState(ctx context.Context, uri string, state *BaseImageState) error {
+	b, err := yaml.Marshal(state)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to serialize state")
+	}
+	return writeStatusObject(ctx, uri, b)
+}
+
+// rebuiltDockerfile records why the images built from a Dockerfile were rebuilt.
+type rebuiltDockerfile struct {
+	path    string
+	reasons []string
+	images  []string
+}
+
+// applyBaseImageWatch checks the base images of the Dockerfiles of a BaseImageWatch and rebuilds the images built
+// from Dockerfiles whose base images changed or are affected by an advisory.
+func (c *RepoController) applyBaseImageWatch(ctx context.Context, r *resource) error {
+	log := util.LogFromContext(ctx)
+
+	watch := &v1alpha1.BaseImageWatch{}
+	if err := r.node.YNode().Decode(watch); err != nil {
+		return errors.Wrapf(err, "Error decoding BaseImageWatch")
+	}
+	if errs, ok := watch.IsValid(); !ok {
+		return errors.New(errs)
+	}
+	log = log.WithValues(v1alpha1.LogValues(watch.Spec.Owners, watch.Spec.Notify)...)
+
+	previous, err := ReadBaseImageState(ctx, watch.Spec.StateURI)
+	if err != nil {
+		return err
+	}
+
+	headRef, err := c.gitRepo.Head()
+	if err != nil {
+		return errors.Wrapf(err, "Error getting head ref")
+	}
+
+	baseDir := filepath.Dir(r.path)
+	resolvePath := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(baseDir, p)
+	}
+
+	failures := &util.ListOfErrors{}
+	digests := map[string]string{}
+	advisories := map[string]string{}
+	failed := map[string]bool{}
+	rebuilt := []rebuiltDockerfile{}
+
+	for _, d := range watch.Spec.Dockerfiles {
+		contents, err := os.ReadFile(resolvePath(d.Path))
+		if err != nil {
+			failures.AddCause(errors.Wrapf(err, "Failed to read Dockerfile %v", d.Path))
+			continue
+		}
+
+		baseImages := images.BaseImages(string(contents))
+		reasons := []string{}
+		for _, image := range baseImages {
+			digest, ok := digests[image]
+			if !ok {
+				digest, err = images.ResolveDigest(image)
+				if err != nil {
+					failures.AddCause(err)
+					failed[image] = true
+					continue
+				}
+				digests[image] = digest
+			}
+			imageReasons, keys := checkBaseImage(previous, image, digest, watch.Spec.Advisories)
+			reasons = append(reasons, imageReasons...)
+			for _, k := range keys {
+				advisories[k] = image
+			}
+		}
+
+		if len(reasons) == 0 {
+			continue
+		}
+
+		log.Info("Rebuilding images for base image changes", "dockerfile", d.Path, "reasons", reasons)
+		result := rebuiltDockerfile{path: d.Path, reasons: reasons}
+		for _, p := range d.Images {
+			uris, err := c.rebuildImages(ctx, resolvePath(p), headRef.Hash().String())
+			result.images = append(result.images, uris...)
+			if err != nil {
+				failures.AddCause(errors.Wrapf(err, "Failed to rebuild the images in %v", p))
+				for _, image := range baseImages {
+					failed[image] = true
+				}
+			}
+		}
+		rebuilt = append(rebuilt, result)
+	}
+
+	state := updateBaseImageState(previous, digests, advisories, failed, time.Now())
+	if err := WriteBaseImageState(ctx, watch.Spec.StateURI, state); err != nil {
+		failures.AddCause(err)
+	}
+
+	if watch.Spec.Issues && len(rebuilt) > 0 {
+		if err := c.createBaseImageIssue(ctx, watch, rebuilt); err != nil {
+			log.Error(err, "Failed to open an issue for the rebuilt images")
+		}
+	}
+
+	if len(failures.Causes) > 0 {
+		failures.Final = errors.Errorf("BaseImageWatch %v failed to rebuild one or more images", watch.Metadata.Name)
+		return failures
+	}
+	return nil
+}
+
+// rebuildImages rebuilds the Image resources in the YAML file at path. It returns the URIs of the images that were
+// rebuilt.
+func (c *RepoController) rebuildImages(ctx context.Context, path string, commit string) ([]string, error) {
+	nodes, err := util.ReadYaml(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read %v", path)
+	}
+	uris := []string{}
+	for _, n := range nodes {
+		if n.GetKind() != v1alpha1.ImageGVK.Kind {
+			continue
+		}
+		image := &v1alpha1.Image{}
+		if err := n.YNode().Decode(image); err != nil {
+			return uris, errors.Wrapf(err, "Error decoding image")
+		}
+		image.Status.SourceCommit = commit
+		if err := c.imageController.Rebuild(ctx, image); err != nil {
+			return uris, errors.Wrapf(err, "Failed to rebuild image %v", image.Metadata.Name)
+		}
+		uris = append(uris, image.Status.URI)
+	}
+	return uris, nil
+}
+
+// createBaseImageIssue opens an issue in the repository listing the images that were rebuilt and why.
+func (c *RepoController) createBaseImageIssue(ctx context.Context, watch *v1alpha1.BaseImageWatch, rebuilt []rebuiltDockerfile) error {
+	client, repo, err := c.repoClient()
+	if err != nil {
+		return err
+	}
+	title, body := buildBaseImageIssue(watch.Metadata.Name, rebuilt)
+	_, _, err = client.Issues.Create(ctx, repo.RepoOwner(), repo.RepoName(), &ghAPI.IssueRequest{
+		Title: ghAPI.String(title),
+		Body:  ghAPI.String(body),
+	})
+	return errors.Wrapf(err, "Failed to create issue %v", title)
+}
+
+// buildBaseImageIssue returns the title and body of the issue listing the images that were rebuilt and why.
+func buildBaseImageIssue(name string, rebuilt []rebuiltDockerfile) (string, string) {
+	title := fmt.Sprintf("[Auto] Rebuilt images for base image changes detected by BaseImageWatch %v", name)
+	lines := []string{"Hydros rebuilt the images built from Dockerfiles whose base images changed or are affected by an advisory."}
+	for _, d := range rebuilt {
+		lines = append(lines, "", fmt.Sprintf("## %v", d.path), "", "Reasons:")
+		for _, r := range d.reasons {
+			lines = append(lines, "* "+r)
+		}
+		lines = append(lines, "", "Images:")
+		for _, i := range d.images {
+			lines = append(lines, "* "+i)
+		}
+	}
+	return title, strings.Join(lines, "\n")
+}
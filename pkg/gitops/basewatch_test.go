@@ -0,0 +1,120 @@
+package gitops
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/api/v1alpha1"
+)
+
+func Test_checkBaseImage(t *testing.T) {
+	state := &BaseImageState{
+		Digests: map[string]string{
+			"debian:bookworm": "sha256:old",
+			"golang:1.21":     "sha256:same",
+		},
+		Advisories: []string{"CVE-2024-0001 golang:1.21"},
+	}
+	advisories := []v1alpha1.Advisory{
+		{ID: "CVE-2024-0001", Images: []string{"golang:"}},
+		{ID: "CVE-2024-0002", Images: []string{"golang:1.21"}, Digests: []string{"sha256:same"}},
+		{ID: "CVE-2024-0003", Images: []string{"debian:"}, Digests: []string{"sha256:old"}},
+	}
+
+	type testCase struct {
+		name            string
+		image           string
+		digest          string
+		expectedReasons []string
+		expectedKeys    []string
+	}
+
+	cases := []testCase{
+		{
+			name:            "digest-changed",
+			image:           "debian:bookworm",
+			digest:          "sha256:new",
+			expectedReasons: []string{"debian:bookworm changed from sha256:old to sha256:new"},
+			expectedKeys:    []string{},
+		},
+		{
+			name:            "advisory",
+			image:           "golang:1.21",
+			digest:          "sha256:same",
+			expectedReasons: []string{"golang:1.21 is affected by CVE-2024-0002"},
+			expectedKeys:    []string{"CVE-2024-0002 golang:1.21"},
+		},
+		{
+			name:            "new-image",
+			image:           "alpine:3.19",
+			digest:          "sha256:1234",
+			expectedReasons: []string{},
+			expectedKeys:    []string{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			reasons, keys := checkBaseImage(state, c.image, c.digest, advisories)
+			if d := cmp.Diff(c.expectedReasons, reasons); d != "" {
+				t.Errorf("Unexpected reasons; diff:\n%v", d)
+			}
+			if d := cmp.Diff(c.expectedKeys, keys); d != "" {
+				t.Errorf("Unexpected keys; diff:\n%v", d)
+			}
+		})
+	}
+}
+
+func Test_BaseImageState(t *testing.T) {
+	now := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	previous := &BaseImageState{
+		Digests: map[string]string{
+			"debian:bookworm": "sha256:old",
+			"golang:1.21":     "sha256:old",
+		},
+		Advisories: []string{"CVE-2024-0001 golang:1.21"},
+	}
+	digests := map[string]string{
+		"debian:bookworm": "sha256:new",
+		"golang:1.21":     "sha256:new",
+		"alpine:3.19":     "sha256:1234",
+	}
+	advisories := map[string]string{
+		"CVE-2024-0002 debian:bookworm": "debian:bookworm",
+		"CVE-2024-0002 golang:1.21":     "golang:1.21",
+	}
+	// The rebuild of the images built from golang:1.21 failed so it should be retried.
+	failed := map[string]bool{"golang:1.21": true}
+
+	actual := updateBaseImageState(previous, digests, advisories, failed, now)
+	expected := &BaseImageState{
+		APIVersion: "hydros.dev/v1alpha1",
+		Kind:       BaseImageStateKind,
+		UpdateTime: now,
+		Digests: map[string]string{
+			"debian:bookworm": "sha256:new",
+			"golang:1.21":     "sha256:old",
+			"alpine:3.19":     "sha256:1234",
+		},
+		Advisories: []string{"CVE-2024-0001 golang:1.21", "CVE-2024-0002 debian:bookworm"},
+	}
+	if d := cmp.Diff(expected, actual); d != "" {
+		t.Fatalf("Unexpected state; diff:\n%v", d)
+	}
+
+	uri := filepath.Join(t.TempDir(), "state.yaml")
+	if err := WriteBaseImageState(context.Background(), uri, actual); err != nil {
+		t.Fatalf("Failed to write state; %v", err)
+	}
+	read, err := ReadBaseImageState(context.Background(), uri)
+	if err != nil {
+		t.Fatalf("Failed to read state; %v", err)
+	}
+	if d := cmp.Diff(expected, read); d != "" {
+		t.Errorf("Unexpected state read; diff:\n%v", d)
+	}
+}
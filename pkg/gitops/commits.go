@@ -0,0 +1,93 @@
+package gitops
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/pkg/errors"
+	"github.com/shurcooL/githubv4"
+)
+
+// mergeMethod returns the method used to merge the PRs of the ManifestSync. When each overlay is committed
+// separately the PRs are rebased so the commits aren't squashed into one; otherwise they are squashed.
+func mergeMethod(m *v1alpha1.ManifestSync) githubv4.PullRequestMergeMethod {
+	if m.Spec.CommitPerOverlay {
+		return githubv4.PullRequestMergeMethodRebase
+	}
+	return githubv4.PullRequestMergeMethodSquash
+}
+
+// commitPerOverlay commits the hydrated manifests in forkDir with one commit per overlay. Files directly in
+// destPath (e.g. the sync file) are committed last so the last commit marks the sync as complete.
+func (s *syncRun) commitPerOverlay(forkDir string, destPath string, sourceCommit string) error {
+	git := func(args ...string) *exec.Cmd {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = forkDir
+		return cmd
+	}
+
+	// Stage everything to find the files that changed; then unstage them so they can be committed by overlay.
+	if err := s.execHelper.Run(git("add", "-A", "--", destPath)); err != nil {
+		return err
+	}
+	out, err := git("diff", "--cached", "--name-only", "--no-renames", "--", destPath).Output()
+	if err != nil {
+		return errors.Wrapf(err, "Failed to list the changed files in %v", destPath)
+	}
+	if err := s.execHelper.Run(git("reset", "-q")); err != nil {
+		return err
+	}
+
+	files := strings.Split(strings.TrimSpace(string(out)), "\n")
+	for _, overlay := range overlayCommitOrder(groupByOverlay(destPath, files)) {
+		message := fmt.Sprintf("Update hydrated manifests for %v to %v", overlay.name, sourceCommit)
+		if overlay.name == "." {
+			message = fmt.Sprintf("Update hydrated manifests to %v", sourceCommit)
+		}
+		if err := s.execHelper.Run(git(append([]string{"add", "-A", "--"}, overlay.files...)...)); err != nil {
+			return err
+		}
+		if err := s.execHelper.Run(git("commit", "-m", message)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// overlayFiles are the changed files of an overlay.
+type overlayFiles struct {
+	name  string
+	files []string
+}
+
+// groupByOverlay groups files by the overlay containing them; files are relative to the root of the repo.
+func groupByOverlay(destPath string, files []string) map[string][]string {
+	result := map[string][]string{}
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+		overlay := overlayOf(destPath, f)
+		result[overlay] = append(result[overlay], f)
+	}
+	return result
+}
+
+// overlayCommitOrder returns the overlays in the order they should be committed; overlays are sorted by name
+// except the root of destPath which is committed last.
+func overlayCommitOrder(groups map[string][]string) []overlayFiles {
+	result := make([]overlayFiles, 0, len(groups))
+	for name, files := range groups {
+		result = append(result, overlayFiles{name: name, files: files})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].name == "." || result[j].name == "." {
+			return result[j].name == "." && result[i].name != "."
+		}
+		return result[i].name < result[j].name
+	})
+	return result
+}
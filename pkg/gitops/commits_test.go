@@ -0,0 +1,97 @@
+package gitops
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/zapr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/pkg/util"
+	"go.uber.org/zap"
+)
+
+func Test_commitPerOverlay(t *testing.T) {
+	dir, err := os.MkdirTemp("", "testCommitPerOverlay")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, v := range []string{"GIT_AUTHOR_NAME", "GIT_AUTHOR_EMAIL", "GIT_COMMITTER_NAME", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(v, "test@acme.com")
+	}
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed; %v; output:\n%v", args, err, string(out))
+		}
+		return string(out)
+	}
+	write := func(files map[string]string) {
+		for name, contents := range files {
+			p := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+				t.Fatalf("Failed to create dir; %v", err)
+			}
+			if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+				t.Fatalf("Failed to write %v; %v", name, err)
+			}
+		}
+	}
+
+	git("init", "-q")
+	write(map[string]string{
+		"hydrated/dev/deployment.yaml":  "v1",
+		"hydrated/prod/deployment.yaml": "v1",
+		"hydrated/hydros.sync.yaml":     "v1",
+	})
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+
+	write(map[string]string{
+		"hydrated/dev/deployment.yaml":        "v2",
+		"hydrated/dev/service.yaml":           "v2",
+		"hydrated/dev/charts/values.yaml":     "v2",
+		"hydrated/hydros.sync.yaml":           "v2",
+		"hydrated/staging/deployment.yaml.bk": "v2",
+	})
+	if err := os.Remove(filepath.Join(dir, "hydrated/prod/deployment.yaml")); err != nil {
+		t.Fatalf("Failed to remove file; %v", err)
+	}
+
+	s := &syncRun{execHelper: &util.ExecHelper{Log: zapr.NewLogger(zap.L())}}
+	if err := s.commitPerOverlay(dir, "hydrated", "abcd"); err != nil {
+		t.Fatalf("commitPerOverlay failed; %v", err)
+	}
+
+	log := strings.TrimSpace(git("log", "--format=%s", "--name-only", "--reverse", "HEAD~5..HEAD"))
+	expected := `Update hydrated manifests for dev to abcd
+
+hydrated/dev/deployment.yaml
+hydrated/dev/service.yaml
+Update hydrated manifests for dev/charts to abcd
+
+hydrated/dev/charts/values.yaml
+Update hydrated manifests for prod to abcd
+
+hydrated/prod/deployment.yaml
+Update hydrated manifests for staging to abcd
+
+hydrated/staging/deployment.yaml.bk
+Update hydrated manifests to abcd
+
+hydrated/hydros.sync.yaml`
+	if d := cmp.Diff(expected, log); d != "" {
+		t.Errorf("Unexpected commits; diff:\n%v", d)
+	}
+
+	if status := git("status", "--porcelain"); status != "" {
+		t.Errorf("Expected all changes to be committed; got:\n%v", status)
+	}
+}
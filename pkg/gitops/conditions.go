@@ -0,0 +1,113 @@
+package gitops
+
+import (
+	"fmt"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+)
+
+// Reasons of the conditions of ManifestSyncs.
+const (
+	awaitingApprovalReason    = "AwaitingApproval"
+	inMergeQueueReason        = "InMergeQueue"
+	prBlockedReason           = "PRBlocked"
+	pausedReason              = "Paused"
+	validationFailedReason    = "ValidationFailed"
+	policyViolationsReason    = "PolicyViolations"
+	fileGuardViolationsReason = "FileGuardViolations"
+	versionSkewReason         = "VersionSkew"
+	upToDateReason            = "UpToDate"
+	notBlockedReason          = "NotBlocked"
+)
+
+// runCondition is the reason and message of a condition set during a run.
+type runCondition struct {
+	reason  string
+	message string
+}
+
+// block records that a destination can't be synced without intervention. Only the first reason is kept.
+func (s *syncRun) block(reason string, message string) {
+	if s.blocked == nil {
+		s.blocked = &runCondition{reason: reason, message: message}
+	}
+	s.waitFor(reason, message)
+}
+
+// waitFor records that a destination isn't synced yet e.g. because its PR is in a merge queue. Only the first
+// reason is kept.
+func (s *syncRun) waitFor(reason string, message string) {
+	if s.notSynced == nil {
+		s.notSynced = &runCondition{reason: reason, message: message}
+	}
+}
+
+// conditions returns the conditions of the ManifestSync after the run. previous are the conditions after the
+// previous run; the LastTransitionTime of conditions whose status didn't change is kept.
+func (s *syncRun) conditions(previous []v1alpha1.Condition, runErr error) []v1alpha1.Condition {
+	conditions := append([]v1alpha1.Condition{}, previous...)
+
+	ready := v1alpha1.NewCondition(v1alpha1.ReadyCondition, v1alpha1.ConditionTrue, v1alpha1.ReconcileSucceededReason, "")
+	if runErr != nil {
+		ready = v1alpha1.NewCondition(v1alpha1.ReadyCondition, v1alpha1.ConditionFalse, v1alpha1.ReconcileFailedReason, runErr.Error())
+	}
+	conditions = v1alpha1.SetCondition(conditions, ready)
+
+	synced := v1alpha1.NewCondition(v1alpha1.SyncedCondition, v1alpha1.ConditionTrue, upToDateReason, "")
+	if s.sourceCommit != "" {
+		synced.Message = fmt.Sprintf("Hydrated from %v", s.sourceCommit)
+	}
+	switch {
+	case s.notSynced != nil:
+		synced = v1alpha1.NewCondition(v1alpha1.SyncedCondition, v1alpha1.ConditionFalse, s.notSynced.reason, s.notSynced.message)
+	case runErr != nil:
+		synced = v1alpha1.NewCondition(v1alpha1.SyncedCondition, v1alpha1.ConditionUnknown, v1alpha1.ReconcileFailedReason, runErr.Error())
+	}
+	conditions = v1alpha1.SetCondition(conditions, synced)
+
+	if emergency, ok := s.emergencyCondition(previous); ok {
+		conditions = v1alpha1.SetCondition(conditions, emergency)
+	}
+
+	blocked := v1alpha1.NewCondition(v1alpha1.BlockedCondition, v1alpha1.ConditionFalse, notBlockedReason, "")
+	if s.blocked != nil {
+		blocked = v1alpha1.NewCondition(v1alpha1.BlockedCondition, v1alpha1.ConditionTrue, s.blocked.reason, s.blocked.message)
+	}
+	return v1alpha1.SetCondition(conditions, blocked)
+}
+
+// Conditions returns the conditions of the ManifestSync after the latest run. When spec.environments is used
+// they summarize the conditions of the environments.
+func (s *Syncer) Conditions() []v1alpha1.Condition {
+	if len(s.environments) > 0 {
+		return s.environmentConditions()
+	}
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	return append([]v1alpha1.Condition{}, s.conditions...)
+}
+
+// environmentConditions summarizes the conditions of the environments. A condition is only true if it is true
+// for every environment except Blocked and Emergency which are true if they are true for any of the environments.
+func (s *Syncer) environmentConditions() []v1alpha1.Condition {
+	var result []v1alpha1.Condition
+	for i, env := range s.environments {
+		name := s.manifest.Spec.Environments[i].Name
+		for _, c := range env.Conditions() {
+			existing := v1alpha1.GetCondition(result, c.Type)
+			if existing == nil {
+				result = append(result, c)
+				continue
+			}
+			worse := c.Status != v1alpha1.ConditionTrue && existing.Status == v1alpha1.ConditionTrue
+			if c.Type == v1alpha1.BlockedCondition || c.Type == v1alpha1.EmergencyCondition {
+				worse = c.Status == v1alpha1.ConditionTrue && existing.Status != v1alpha1.ConditionTrue
+			}
+			if worse {
+				c.Message = fmt.Sprintf("Environment %v: %v", name, c.Message)
+				*existing = c
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,105 @@
+package gitops
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/pkg/errors"
+)
+
+func Test_syncRunConditions(t *testing.T) {
+	type testCase struct {
+		name    string
+		run     func(s *syncRun)
+		err     error
+		ready   v1alpha1.ConditionStatus
+		synced  v1alpha1.ConditionStatus
+		blocked v1alpha1.ConditionStatus
+		reason  string
+	}
+
+	testCases := []testCase{
+		{
+			name:    "synced",
+			run:     func(s *syncRun) {},
+			ready:   v1alpha1.ConditionTrue,
+			synced:  v1alpha1.ConditionTrue,
+			blocked: v1alpha1.ConditionFalse,
+			reason:  upToDateReason,
+		},
+		{
+			name: "awaiting-approval",
+			run: func(s *syncRun) {
+				s.block(awaitingApprovalReason, "PR is waiting for approval")
+				s.block(pausedReason, "ignored")
+			},
+			ready:   v1alpha1.ConditionTrue,
+			synced:  v1alpha1.ConditionFalse,
+			blocked: v1alpha1.ConditionTrue,
+			reason:  awaitingApprovalReason,
+		},
+		{
+			name:    "merge-queue",
+			run:     func(s *syncRun) { s.waitFor(inMergeQueueReason, "PR is in the merge queue") },
+			ready:   v1alpha1.ConditionTrue,
+			synced:  v1alpha1.ConditionFalse,
+			blocked: v1alpha1.ConditionFalse,
+			reason:  inMergeQueueReason,
+		},
+		{
+			name:    "policy-violations",
+			run:     func(s *syncRun) { s.block(policyViolationsReason, "violates 2 policies") },
+			err:     errors.New("violates 2 policies"),
+			ready:   v1alpha1.ConditionFalse,
+			synced:  v1alpha1.ConditionFalse,
+			blocked: v1alpha1.ConditionTrue,
+			reason:  policyViolationsReason,
+		},
+		{
+			name:    "failed",
+			run:     func(s *syncRun) {},
+			err:     errors.New("failed to clone"),
+			ready:   v1alpha1.ConditionFalse,
+			synced:  v1alpha1.ConditionUnknown,
+			blocked: v1alpha1.ConditionFalse,
+			reason:  v1alpha1.ReconcileFailedReason,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &syncRun{sourceCommit: "1234"}
+			c.run(s)
+			conditions := s.conditions(nil, c.err)
+			for conditionType, expected := range map[v1alpha1.ConditionType]v1alpha1.ConditionStatus{
+				v1alpha1.ReadyCondition:   c.ready,
+				v1alpha1.SyncedCondition:  c.synced,
+				v1alpha1.BlockedCondition: c.blocked,
+			} {
+				actual := v1alpha1.GetCondition(conditions, conditionType)
+				if actual == nil {
+					t.Fatalf("Missing condition %v", conditionType)
+				}
+				if actual.Status != expected {
+					t.Errorf("Condition %v: got status %v; want %v", conditionType, actual.Status, expected)
+				}
+			}
+			if synced := v1alpha1.GetCondition(conditions, v1alpha1.SyncedCondition); synced.Reason != c.reason {
+				t.Errorf("Got Synced reason %v; want %v", synced.Reason, c.reason)
+			}
+		})
+	}
+}
+
+func Test_syncRunConditionsKeepTransitionTime(t *testing.T) {
+	first := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	previous := []v1alpha1.Condition{{Type: v1alpha1.BlockedCondition, Status: v1alpha1.ConditionTrue, Reason: awaitingApprovalReason, LastTransitionTime: first}}
+
+	s := &syncRun{}
+	s.block(awaitingApprovalReason, "PR is still waiting for approval")
+	conditions := s.conditions(previous, nil)
+	if blocked := v1alpha1.GetCondition(conditions, v1alpha1.BlockedCondition); !blocked.LastTransitionTime.Equal(first) {
+		t.Errorf("Expected the sync to have been blocked since %v; got %v", first, blocked.LastTransitionTime)
+	}
+}
@@ -0,0 +1,169 @@
+package gitops
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/pkg/errors"
+)
+
+// key returns the kind/name of the resource used to refer to it in dependsOn.
+func (r *resource) key() string {
+	return v1alpha1.ResourceRef{Kind: r.node.GetKind(), Name: r.node.GetName()}.String()
+}
+
+// dependsOn returns the resources r depends on.
+func (r *resource) dependsOn() ([]v1alpha1.ResourceRef, error) {
+	metaNode := r.node.Field("metadata")
+	if metaNode == nil {
+		return nil, nil
+	}
+	meta := &v1alpha1.Metadata{}
+	if err := metaNode.Value.YNode().Decode(meta); err != nil {
+		return nil, errors.Wrapf(err, "Failed to decode metadata of %v", r.key())
+	}
+	return meta.DependsOn, nil
+}
+
+// dependencyGraph is the graph of dependencies between the resources of a RepoConfig.
+type dependencyGraph struct {
+	resources []*resource
+	deps      map[*resource][]*resource
+	// invalid are resources that can't be applied because of a problem with their dependencies e.g. a missing
+	// dependency or a cycle.
+	invalid map[*resource]error
+}
+
+// newDependencyGraph builds the graph of dependencies between resources. Problems with the dependencies of a
+// resource e.g. a cycle only prevent that resource, and the resources depending on it, from being applied.
+func newDependencyGraph(resources []*resource) *dependencyGraph {
+	g := &dependencyGraph{
+		resources: resources,
+		deps:      map[*resource][]*resource{},
+		invalid:   map[*resource]error{},
+	}
+
+	// N.B. Names are only unique within a file so a reference can match multiple resources; the resource depends
+	// on all of them.
+	byKey := map[string][]*resource{}
+	for _, r := range resources {
+		byKey[r.key()] = append(byKey[r.key()], r)
+	}
+
+	for _, r := range resources {
+		refs, err := r.dependsOn()
+		if err != nil {
+			g.invalid[r] = err
+			continue
+		}
+		for _, ref := range refs {
+			targets, ok := byKey[ref.String()]
+			if !ok {
+				g.invalid[r] = errors.Errorf("%v depends on %v which isn't one of the resources of the RepoConfig", r.key(), ref)
+				break
+			}
+			g.deps[r] = append(g.deps[r], targets...)
+		}
+	}
+
+	g.findCycles()
+	return g
+}
+
+// findCycles marks every resource in a dependency cycle as invalid.
+func (g *dependencyGraph) findCycles() {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[*resource]int{}
+	stack := []*resource{}
+
+	var visit func(r *resource)
+	visit = func(r *resource) {
+		state[r] = visiting
+		stack = append(stack, r)
+		for _, d := range g.deps[r] {
+			switch state[d] {
+			case unvisited:
+				visit(d)
+			case visiting:
+				// The resources on the stack from d onwards form a cycle.
+				start := len(stack) - 1
+				for stack[start] != d {
+					start--
+				}
+				cycle := stack[start:]
+				names := make([]string, 0, len(cycle)+1)
+				for _, c := range cycle {
+					names = append(names, c.key())
+				}
+				names = append(names, d.key())
+				err := errors.Errorf("dependency cycle %v", strings.Join(names, " -> "))
+				for _, c := range cycle {
+					if _, ok := g.invalid[c]; !ok {
+						g.invalid[c] = err
+					}
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[r] = visited
+	}
+
+	for _, r := range g.resources {
+		if state[r] == unvisited {
+			visit(r)
+		}
+	}
+}
+
+// apply applies the resources using applyFn. Resources are applied in parallel except that a resource isn't
+// applied until all of its dependencies have been applied successfully. If a dependency fails the resource isn't
+// applied. It returns the error for each resource that wasn't applied successfully.
+func (g *dependencyGraph) apply(ctx context.Context, applyFn func(ctx context.Context, r *resource) error) map[*resource]error {
+	var mu sync.Mutex
+	results := map[*resource]error{}
+	done := map[*resource]chan struct{}{}
+	for _, r := range g.resources {
+		done[r] = make(chan struct{})
+	}
+
+	var wg sync.WaitGroup
+	for _, r := range g.resources {
+		wg.Add(1)
+		go func(r *resource) {
+			defer wg.Done()
+			defer close(done[r])
+
+			err := func() error {
+				// N.B. Check for invalid dependencies before waiting on them since resources in a cycle would wait
+				// on each other forever.
+				if err, ok := g.invalid[r]; ok {
+					return err
+				}
+				for _, d := range g.deps[r] {
+					<-done[d]
+					mu.Lock()
+					depErr := results[d]
+					mu.Unlock()
+					if depErr != nil {
+						return errors.Errorf("%v wasn't applied because its dependency %v failed", r.key(), d.key())
+					}
+				}
+				return applyFn(ctx, r)
+			}()
+
+			if err != nil {
+				mu.Lock()
+				results[r] = err
+				mu.Unlock()
+			}
+		}(r)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,149 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func newTestResource(t *testing.T, kind string, name string, dependsOn ...string) *resource {
+	t.Helper()
+	lines := []string{
+		"apiVersion: hydros.dev/v1alpha1",
+		"kind: " + kind,
+		"metadata:",
+		"  name: " + name,
+	}
+	if len(dependsOn) > 0 {
+		lines = append(lines, "  dependsOn:")
+		for _, d := range dependsOn {
+			pieces := strings.Split(d, "/")
+			lines = append(lines, "  - kind: "+pieces[0], "    name: "+pieces[1])
+		}
+	}
+	node, err := yaml.Parse(strings.Join(lines, "\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse resource; %v", err)
+	}
+	return &resource{node: node, path: name + ".yaml", rPath: name + ".yaml"}
+}
+
+func Test_dependencyGraph(t *testing.T) {
+	type testCase struct {
+		name      string
+		resources [][]string
+		// fail is the resource whose apply fails.
+		fail string
+		// expectedOrder are pairs of resources where the first must be applied before the second.
+		expectedOrder [][2]string
+		// expectedErrors are the resources that aren't applied successfully and a substring of their error.
+		expectedErrors map[string]string
+	}
+
+	cases := []testCase{
+		{
+			name: "image-before-sync",
+			resources: [][]string{
+				{"ManifestSync", "app", "Image/app", "Image/sidecar"},
+				{"Image", "app"},
+				{"Image", "sidecar"},
+				{"GitHubReleaser", "releaser"},
+			},
+			expectedOrder:  [][2]string{{"Image/app", "ManifestSync/app"}, {"Image/sidecar", "ManifestSync/app"}},
+			expectedErrors: map[string]string{},
+		},
+		{
+			name: "failed-dependency",
+			resources: [][]string{
+				{"ManifestSync", "app", "Image/app"},
+				{"Image", "app"},
+				{"Image", "other"},
+			},
+			fail: "Image/app",
+			expectedErrors: map[string]string{
+				"Image/app":        "build failed",
+				"ManifestSync/app": "its dependency Image/app failed",
+			},
+		},
+		{
+			name: "missing-dependency",
+			resources: [][]string{
+				{"ManifestSync", "app", "Image/missing"},
+				{"Image", "app"},
+			},
+			expectedErrors: map[string]string{
+				"ManifestSync/app": "depends on Image/missing which isn't one of the resources",
+			},
+		},
+		{
+			name: "cycle",
+			resources: [][]string{
+				{"ManifestSync", "a", "Image/b"},
+				{"Image", "b", "ManifestSync/a"},
+				{"ManifestSync", "c", "Image/b"},
+				{"Image", "d"},
+			},
+			expectedErrors: map[string]string{
+				"ManifestSync/a": "dependency cycle",
+				"Image/b":        "dependency cycle",
+				"ManifestSync/c": "its dependency Image/b failed",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resources := []*resource{}
+			for _, r := range c.resources {
+				resources = append(resources, newTestResource(t, r[0], r[1], r[2:]...))
+			}
+
+			var mu sync.Mutex
+			applied := map[string]int{}
+			g := newDependencyGraph(resources)
+			failed := g.apply(context.Background(), func(ctx context.Context, r *resource) error {
+				mu.Lock()
+				defer mu.Unlock()
+				applied[r.key()] = len(applied)
+				if r.key() == c.fail {
+					return fmt.Errorf("build failed")
+				}
+				return nil
+			})
+
+			actualErrors := map[string]string{}
+			for r, err := range failed {
+				actualErrors[r.key()] = err.Error()
+			}
+			if len(actualErrors) != len(c.expectedErrors) {
+				t.Errorf("Unexpected failures; diff:\n%v", cmp.Diff(c.expectedErrors, actualErrors))
+			}
+			for k, expected := range c.expectedErrors {
+				if !strings.Contains(actualErrors[k], expected) {
+					t.Errorf("Error for %v doesn't contain %q; got %q", k, expected, actualErrors[k])
+				}
+			}
+
+			for _, o := range c.expectedOrder {
+				first, ok := applied[o[0]]
+				if !ok {
+					t.Errorf("%v wasn't applied", o[0])
+					continue
+				}
+				second, ok := applied[o[1]]
+				if !ok {
+					t.Errorf("%v wasn't applied", o[1])
+					continue
+				}
+				if first > second {
+					t.Errorf("%v was applied before its dependency %v", o[1], o[0])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package gitops
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// overlayStat summarizes the changes to the hydrated manifests of a single overlay.
+type overlayStat struct {
+	// Overlay is the directory, relative to DestPath, containing the hydrated manifests of the overlay.
+	Overlay string
+	Added   int
+	Changed int
+	Removed int
+	// Images are the images added by the change.
+	Images []string
+}
+
+// diffstat computes the changes between the dest branch and the hydrated manifests committed in forkDir grouped
+// by overlay.
+func diffstat(forkDir string, destBranch string, destPath string) ([]*overlayStat, error) {
+	base := "origin/" + destBranch
+	run := func(args ...string) (string, error) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = forkDir
+		out, err := cmd.Output()
+		if err != nil {
+			return "", errors.Wrapf(err, "Failed to run git %v", strings.Join(args, " "))
+		}
+		return string(out), nil
+	}
+
+	nameStatus, err := run("diff", "--name-status", "--no-renames", base, "HEAD", "--", destPath)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := run("diff", "-U0", "--no-renames", base, "HEAD", "--", destPath)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := map[string]*overlayStat{}
+	parseNameStatus(nameStatus, destPath, stats)
+	parseImageChanges(patch, destPath, stats)
+
+	result := make([]*overlayStat, 0, len(stats))
+	for _, s := range stats {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Overlay < result[j].Overlay
+	})
+	return result, nil
+}
+
+// overlayOf returns the overlay containing the file; i.e. its directory relative to destPath. file is relative
+// to the root of the repo.
+func overlayOf(destPath string, file string) string {
+	rel := strings.TrimPrefix(file, path.Clean(destPath)+"/")
+	return path.Dir(rel)
+}
+
+// overlayFor returns the stats of the overlay containing the file; file is relative to the root of the repo.
+func overlayFor(stats map[string]*overlayStat, destPath string, file string) *overlayStat {
+	overlay := overlayOf(destPath, file)
+	s, ok := stats[overlay]
+	if !ok {
+		s = &overlayStat{Overlay: overlay, Images: []string{}}
+		stats[overlay] = s
+	}
+	return s
+}
+
+// parseNameStatus parses the output of git diff --name-status.
+func parseNameStatus(out string, destPath string, stats map[string]*overlayStat) {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 || fields[0] == "" {
+			continue
+		}
+		s := overlayFor(stats, destPath, fields[1])
+		switch fields[0][0] {
+		case 'A':
+			s.Added++
+		case 'D':
+			s.Removed++
+		default:
+			s.Changed++
+		}
+	}
+}
+
+// parseImageChanges finds the images added in a patch generated by git diff.
+func parseImageChanges(patch string, destPath string, stats map[string]*overlayStat) {
+	var current *overlayStat
+	seen := map[*overlayStat]map[string]bool{}
+	for _, line := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(line, "+++ ") {
+			current = nil
+			if file := strings.TrimPrefix(line, "+++ "); strings.HasPrefix(file, "b/") {
+				current = overlayFor(stats, destPath, strings.TrimPrefix(file, "b/"))
+			}
+			continue
+		}
+		if current == nil || !strings.HasPrefix(line, "+") {
+			continue
+		}
+		content := strings.TrimSpace(strings.TrimPrefix(line, "+"))
+		content = strings.TrimPrefix(content, "- ")
+		if !strings.HasPrefix(content, "image:") {
+			continue
+		}
+		image := strings.Trim(strings.TrimSpace(strings.TrimPrefix(content, "image:")), `"'`)
+		if image == "" {
+			continue
+		}
+		if seen[current] == nil {
+			seen[current] = map[string]bool{}
+		}
+		if seen[current][image] {
+			continue
+		}
+		seen[current][image] = true
+		current.Images = append(current.Images, image)
+	}
+}
+
+// buildDiffstat renders the stats as a collapsible markdown section. It returns the empty string if there are
+// no changes.
+func buildDiffstat(stats []*overlayStat) string {
+	if len(stats) == 0 {
+		return ""
+	}
+	numFiles := 0
+	for _, s := range stats {
+		numFiles += s.Added + s.Changed + s.Removed
+	}
+
+	lines := []string{
+		"<details>",
+		fmt.Sprintf("<summary>Changes by overlay: %v files in %v overlays</summary>", numFiles, len(stats)),
+		"",
+		"| Overlay | Added | Changed | Removed | Images Changed |",
+		"| --- | --- | --- | --- | --- |",
+	}
+	for _, s := range stats {
+		images := "None"
+		if len(s.Images) > 0 {
+			images = strings.Join(s.Images, "<br>")
+		}
+		lines = append(lines, fmt.Sprintf("| %v | %v | %v | %v | %v |", s.Overlay, s.Added, s.Changed, s.Removed, images))
+	}
+	lines = append(lines, "", "</details>")
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,69 @@
+package gitops
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_Diffstat(t *testing.T) {
+	nameStatus := `A	hydrated/dev/deployment.yaml
+M	hydrated/dev/service.yaml
+D	hydrated/prod/configmap.yaml
+M	hydrated/prod/deployment.yaml
+M	hydrated/hydros.last.sync.yaml
+`
+	patch := `diff --git a/hydrated/dev/deployment.yaml b/hydrated/dev/deployment.yaml
+new file mode 100644
+--- /dev/null
++++ b/hydrated/dev/deployment.yaml
+@@ -0,0 +1,3 @@
++      - image: gcr.io/acme/app:v2
++        name: app
++      - image: "gcr.io/acme/sidecar:v1"
+diff --git a/hydrated/prod/configmap.yaml b/hydrated/prod/configmap.yaml
+deleted file mode 100644
+--- a/hydrated/prod/configmap.yaml
++++ /dev/null
+@@ -1 +0,0 @@
+-  image: gcr.io/acme/old:v1
+diff --git a/hydrated/prod/deployment.yaml b/hydrated/prod/deployment.yaml
+--- a/hydrated/prod/deployment.yaml
++++ b/hydrated/prod/deployment.yaml
+@@ -10 +10 @@
+-        image: gcr.io/acme/app:v1
++        image: gcr.io/acme/app:v2
+`
+
+	stats := map[string]*overlayStat{}
+	parseNameStatus(nameStatus, "hydrated/", stats)
+	parseImageChanges(patch, "hydrated/", stats)
+
+	expected := map[string]*overlayStat{
+		".":    {Overlay: ".", Changed: 1, Images: []string{}},
+		"dev":  {Overlay: "dev", Added: 1, Changed: 1, Images: []string{"gcr.io/acme/app:v2", "gcr.io/acme/sidecar:v1"}},
+		"prod": {Overlay: "prod", Changed: 1, Removed: 1, Images: []string{"gcr.io/acme/app:v2"}},
+	}
+	if d := cmp.Diff(expected, stats); d != "" {
+		t.Fatalf("Unexpected stats; diff:\n%v", d)
+	}
+
+	actual := buildDiffstat([]*overlayStat{stats["."], stats["dev"], stats["prod"]})
+	expectedMarkdown := `<details>
+<summary>Changes by overlay: 5 files in 3 overlays</summary>
+
+| Overlay | Added | Changed | Removed | Images Changed |
+| --- | --- | --- | --- | --- |
+| . | 0 | 1 | 0 | None |
+| dev | 1 | 1 | 0 | gcr.io/acme/app:v2<br>gcr.io/acme/sidecar:v1 |
+| prod | 0 | 1 | 1 | gcr.io/acme/app:v2 |
+
+</details>`
+	if d := cmp.Diff(expectedMarkdown, actual); d != "" {
+		t.Errorf("Unexpected markdown; diff:\n%v", d)
+	}
+
+	if buildDiffstat(nil) != "" {
+		t.Errorf("Expected no summary when there are no changes")
+	}
+}
@@ -0,0 +1,100 @@
+package gitops
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/pkg/errors"
+)
+
+// Reasons of the Emergency condition.
+const (
+	pauseBypassedReason = "PauseBypassed"
+	emergencySyncReason = "EmergencySync"
+	notEmergencyReason  = "NotEmergency"
+)
+
+// emergencySync describes an emergency sync.
+type emergencySync struct {
+	// reason is why the sync is an emergency.
+	reason string
+	// expires is when the emergency ends. It is zero when the reason is given when running the syncer e.g. with
+	// a CLI flag.
+	expires time.Time
+}
+
+// newEmergencySync returns the emergency sync of m or nil if the sync isn't an emergency. override is the reason
+// given when running the syncer e.g. with a CLI flag; it takes precedence over the annotations of m. It is an
+// error for m to be annotated as an emergency without a reason or an expiry. The annotations are ignored once
+// the emergency has expired.
+func newEmergencySync(m v1alpha1.ManifestSync, override string, now time.Time) (*emergencySync, error) {
+	if reason := strings.TrimSpace(override); reason != "" {
+		return &emergencySync{reason: reason}, nil
+	}
+	if !m.IsEmergency() {
+		return nil, nil
+	}
+	reason := strings.TrimSpace(m.Metadata.Annotations[v1alpha1.EmergencyReasonAnnotation])
+	if reason == "" {
+		return nil, errors.Errorf("ManifestSync %v is annotated with %v but doesn't give a reason; set the annotation %v to the reason for the emergency", m.Metadata.Name, v1alpha1.EmergencyAnnotation, v1alpha1.EmergencyReasonAnnotation)
+	}
+	expires, err := m.EmergencyExpires()
+	if err != nil {
+		return nil, errors.Wrapf(err, "ManifestSync %v is annotated with %v but doesn't give a valid expiry", m.Metadata.Name, v1alpha1.EmergencyAnnotation)
+	}
+	if !now.Before(expires) {
+		return nil, nil
+	}
+	return &emergencySync{reason: reason, expires: expires}, nil
+}
+
+// describe returns a description of the emergency used in notes and conditions.
+func (e *emergencySync) describe() string {
+	if e.expires.IsZero() {
+		return fmt.Sprintf("Reason: %v", e.reason)
+	}
+	return fmt.Sprintf("Reason: %v; expires: %v", e.reason, e.expires.UTC().Format(time.RFC3339))
+}
+
+// describePause returns a description of the pause bypassed by an emergency sync.
+func describePause(d *destination, status *v1alpha1.ManifestSyncStatus) string {
+	msg := fmt.Sprintf("destination %v was paused until %v", d.Name, status.PausedUntil)
+	if status.PausedBy != "" {
+		msg += fmt.Sprintf(" by %v", status.PausedBy)
+	}
+	return msg
+}
+
+// buildEmergencyNote returns the note added to the PR description of an emergency sync. bypassed describes the
+// pause of the destination that was bypassed; it is empty if the destination wasn't paused.
+func buildEmergencyNote(e *emergencySync, bypassed string) string {
+	if bypassed == "" {
+		return fmt.Sprintf("**Emergency sync**: no pauses were bypassed.\n%v", e.describe())
+	}
+	return fmt.Sprintf("**Emergency sync**: the pause was bypassed; %v.\n%v", bypassed, e.describe())
+}
+
+// emergencyCondition returns the Emergency condition of the run. previous are the conditions after the previous
+// run. ok is false if the condition shouldn't be set i.e. the run isn't an emergency and no previous run was.
+func (s *syncRun) emergencyCondition(previous []v1alpha1.Condition) (v1alpha1.Condition, bool) {
+	if s.emergency == nil {
+		if v1alpha1.GetCondition(previous, v1alpha1.EmergencyCondition) == nil {
+			return v1alpha1.Condition{}, false
+		}
+		return v1alpha1.NewCondition(v1alpha1.EmergencyCondition, v1alpha1.ConditionFalse, notEmergencyReason, ""), true
+	}
+
+	bypassed := []string{}
+	for _, d := range s.destinations {
+		if p, ok := s.bypassedPauses[d]; ok {
+			bypassed = append(bypassed, p)
+		}
+	}
+	if len(bypassed) == 0 {
+		return v1alpha1.NewCondition(v1alpha1.EmergencyCondition, v1alpha1.ConditionTrue, emergencySyncReason, s.emergency.describe()), true
+	}
+	msg := fmt.Sprintf("Pauses were bypassed; %v. %v", strings.Join(bypassed, "; "), s.emergency.describe())
+	return v1alpha1.NewCondition(v1alpha1.EmergencyCondition, v1alpha1.ConditionTrue, pauseBypassedReason, msg), true
+}
@@ -0,0 +1,107 @@
+package gitops
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_newEmergencySync(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	type testCase struct {
+		name        string
+		annotations map[string]string
+		override    string
+		expected    *emergencySync
+		wantErr     bool
+	}
+
+	cases := []testCase{
+		{name: "none", expected: nil},
+		{name: "annotation", annotations: map[string]string{v1alpha1.EmergencyAnnotation: "true", v1alpha1.EmergencyReasonAnnotation: " CVE-2023-1234 ", v1alpha1.EmergencyExpiresAnnotation: "2023-06-01T17:00:00Z"}, expected: &emergencySync{reason: "CVE-2023-1234", expires: time.Date(2023, 6, 1, 17, 0, 0, 0, time.UTC)}},
+		{name: "not-emergency", annotations: map[string]string{v1alpha1.EmergencyAnnotation: "false", v1alpha1.EmergencyReasonAnnotation: "CVE-2023-1234"}, expected: nil},
+		{name: "expired", annotations: map[string]string{v1alpha1.EmergencyAnnotation: "true", v1alpha1.EmergencyReasonAnnotation: "CVE-2023-1234", v1alpha1.EmergencyExpiresAnnotation: "2023-06-01T11:00:00Z"}, expected: nil},
+		{name: "override", annotations: map[string]string{v1alpha1.EmergencyAnnotation: "true", v1alpha1.EmergencyReasonAnnotation: "CVE-2023-1234", v1alpha1.EmergencyExpiresAnnotation: "2023-06-01T17:00:00Z"}, override: "CVE-2023-5678", expected: &emergencySync{reason: "CVE-2023-5678"}},
+		{name: "flag-only", override: "CVE-2023-5678", expected: &emergencySync{reason: "CVE-2023-5678"}},
+		{name: "missing-reason", annotations: map[string]string{v1alpha1.EmergencyAnnotation: "true", v1alpha1.EmergencyExpiresAnnotation: "2023-06-01T17:00:00Z"}, wantErr: true},
+		{name: "missing-expires", annotations: map[string]string{v1alpha1.EmergencyAnnotation: "true", v1alpha1.EmergencyReasonAnnotation: "CVE-2023-1234"}, wantErr: true},
+		{name: "invalid-expires", annotations: map[string]string{v1alpha1.EmergencyAnnotation: "true", v1alpha1.EmergencyReasonAnnotation: "CVE-2023-1234", v1alpha1.EmergencyExpiresAnnotation: "tomorrow"}, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := v1alpha1.ManifestSync{Metadata: v1alpha1.Metadata{Name: "test", Annotations: c.annotations}}
+			actual, err := newEmergencySync(m, c.override, now)
+			if c.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newEmergencySync failed; %v", err)
+			}
+			if (actual == nil) != (c.expected == nil) || (actual != nil && *actual != *c.expected) {
+				t.Errorf("Got %+v; want %+v", actual, c.expected)
+			}
+		})
+	}
+}
+
+func Test_emergencyCondition(t *testing.T) {
+	prod := &destination{Destination: v1alpha1.Destination{Name: "prod"}}
+	dev := &destination{Destination: v1alpha1.Destination{Name: "dev"}}
+	emergency := &emergencySync{reason: "CVE-2023-1234", expires: time.Date(2023, 6, 1, 17, 0, 0, 0, time.UTC)}
+	pausedUntil := metav1.NewTime(time.Date(2023, 6, 2, 0, 0, 0, 0, time.UTC))
+
+	s := &syncRun{
+		Syncer:    &Syncer{destinations: []*destination{dev, prod}},
+		emergency: emergency,
+		bypassedPauses: map[*destination]string{
+			prod: describePause(prod, &v1alpha1.ManifestSyncStatus{PausedUntil: &pausedUntil, PausedBy: "jane@acme.com"}),
+		},
+	}
+	conditions := s.conditions(nil, nil)
+	c := v1alpha1.GetCondition(conditions, v1alpha1.EmergencyCondition)
+	if c == nil {
+		t.Fatalf("Expected an Emergency condition; got %v", conditions)
+	}
+	if c.Status != v1alpha1.ConditionTrue || c.Reason != pauseBypassedReason {
+		t.Errorf("Expected the Emergency condition to be true with reason %v; got %+v", pauseBypassedReason, c)
+	}
+	for _, want := range []string{"destination prod was paused until", "by jane@acme.com", "Reason: CVE-2023-1234", "expires: 2023-06-01T17:00:00Z"} {
+		if !strings.Contains(c.Message, want) {
+			t.Errorf("Expected the message to contain %q; got %v", want, c.Message)
+		}
+	}
+
+	// The condition is cleared by the next run which isn't an emergency.
+	next := &syncRun{Syncer: s.Syncer}
+	c = v1alpha1.GetCondition(next.conditions(conditions, nil), v1alpha1.EmergencyCondition)
+	if c == nil || c.Status != v1alpha1.ConditionFalse || c.Reason != notEmergencyReason {
+		t.Errorf("Expected the Emergency condition to be false with reason %v; got %+v", notEmergencyReason, c)
+	}
+
+	// Resources that never had an emergency don't get the condition.
+	if c := v1alpha1.GetCondition(next.conditions(nil, nil), v1alpha1.EmergencyCondition); c != nil {
+		t.Errorf("Expected no Emergency condition; got %+v", c)
+	}
+}
+
+func Test_buildEmergencyNote(t *testing.T) {
+	e := &emergencySync{reason: "CVE-2023-1234", expires: time.Date(2023, 6, 1, 17, 0, 0, 0, time.UTC)}
+	note := buildEmergencyNote(e, "destination prod was paused until 2023-06-02 by jane@acme.com")
+	expected := "**Emergency sync**: the pause was bypassed; destination prod was paused until 2023-06-02 by jane@acme.com.\nReason: CVE-2023-1234; expires: 2023-06-01T17:00:00Z"
+	if note != expected {
+		t.Errorf("Got:\n%v\nwant:\n%v", note, expected)
+	}
+
+	note = buildEmergencyNote(&emergencySync{reason: "CVE-2023-5678"}, "")
+	expected = "**Emergency sync**: no pauses were bypassed.\nReason: CVE-2023-5678"
+	if note != expected {
+		t.Errorf("Got:\n%v\nwant:\n%v", note, expected)
+	}
+}
@@ -0,0 +1,481 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"github.com/jlewi/monogo/gcp/gcs"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	// defaultQueuePollInterval is how often Get checks for items that are ready.
+	defaultQueuePollInterval = 5 * time.Second
+	// defaultQueueLease is how long a replica can process an item before other replicas assume it died and
+	// process the item themselves.
+	defaultQueueLease = time.Hour
+	// maxQueueConflicts is how many times an update is retried when the object was modified concurrently.
+	maxQueueConflicts = 10
+)
+
+// queueStore stores the objects of a GCSQueue. Updates are conditioned on the generation of the object so
+// replicas can't overwrite each other's changes.
+type queueStore interface {
+	// create creates the object. It returns false if the object already exists.
+	create(ctx context.Context, name string, data []byte) (bool, error)
+	// read returns the contents and generation of the object. It returns errQueueObjectNotFound if it doesn't exist.
+	read(ctx context.Context, name string) ([]byte, int64, error)
+	// update replaces the object. It returns false if the generation of the object doesn't match generation.
+	update(ctx context.Context, name string, data []byte, generation int64) (bool, error)
+	// delete deletes the object. It returns false if the generation of the object doesn't match generation.
+	delete(ctx context.Context, name string, generation int64) (bool, error)
+	// list returns the names of the objects.
+	list(ctx context.Context) ([]string, error)
+}
+
+var errQueueObjectNotFound = errors.New("queue object not found")
+
+// queueRecord is the object stored for each item in a GCSQueue.
+type queueRecord struct {
+	Item encodedItem `json:"item"`
+	// NotBefore is when the item can be processed.
+	NotBefore time.Time `json:"notBefore"`
+	// Owner and LeaseExpiry are set while a replica is processing the item.
+	Owner       string    `json:"owner,omitempty"`
+	LeaseExpiry time.Time `json:"leaseExpiry,omitempty"`
+	// Dirty is true if the item was added while it was being processed so it should be processed again.
+	Dirty bool `json:"dirty,omitempty"`
+}
+
+// leased returns true if a replica is processing the item.
+func (r *queueRecord) leased(now time.Time) bool {
+	return r.Owner != "" && now.Before(r.LeaseExpiry)
+}
+
+// GCSQueue is a Queue stored in GCS. It lets multiple replicas share the work and items survive restarts.
+// Each item is stored as an object whose name is derived from the item so duplicates are merged. A replica
+// processing an item holds a lease on it; if the replica dies other replicas process the item once the lease
+// expires. Items are only processed by replicas that have a reconciler with the item's name; see setFilter.
+type GCSQueue struct {
+	store        queueStore
+	identity     string
+	pollInterval time.Duration
+	lease        time.Duration
+	now          func() time.Time
+	log          logr.Logger
+	// accept returns true if the item with the name can be processed by this replica. If it is nil every item
+	// is accepted.
+	accept func(name string) bool
+
+	// names maps the items being processed by this replica to their objects.
+	mu    sync.Mutex
+	names map[string]string
+
+	ctx      context.Context
+	shutdown context.CancelFunc
+}
+
+var _ Queue = &GCSQueue{}
+
+// GCSQueueOption is an option for the GCSQueue.
+type GCSQueueOption func(q *GCSQueue)
+
+// WithQueuePollInterval sets how often Get checks for items that are ready.
+func WithQueuePollInterval(d time.Duration) GCSQueueOption {
+	return func(q *GCSQueue) {
+		q.pollInterval = d
+	}
+}
+
+// WithQueueLease sets how long a replica can process an item before other replicas process it.
+func WithQueueLease(d time.Duration) GCSQueueOption {
+	return func(q *GCSQueue) {
+		q.lease = d
+	}
+}
+
+// NewGCSQueue creates a queue stored in the objects under uri e.g. gs://my-bucket/hydros/queue.
+// identity identifies this replica e.g. its hostname.
+func NewGCSQueue(client *storage.Client, uri string, identity string, opts ...GCSQueueOption) (*GCSQueue, error) {
+	if client == nil {
+		return nil, errors.New("client is required")
+	}
+	p, err := gcs.Parse(uri)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid GCS queue %v", uri)
+	}
+	prefix := strings.TrimSuffix(p.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	store := &gcsQueueStore{bucket: client.Bucket(p.Bucket), prefix: prefix}
+	return newGCSQueue(store, identity, opts...)
+}
+
+func newGCSQueue(store queueStore, identity string, opts ...GCSQueueOption) (*GCSQueue, error) {
+	if identity == "" {
+		return nil, errors.New("identity is required")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &GCSQueue{
+		store:        store,
+		identity:     identity,
+		pollInterval: defaultQueuePollInterval,
+		lease:        defaultQueueLease,
+		now:          time.Now,
+		log:          zapr.NewLogger(zap.L()),
+		names:        map[string]string{},
+		ctx:          ctx,
+		shutdown:     cancel,
+	}
+	for _, o := range opts {
+		o(q)
+	}
+	return q, nil
+}
+
+// setFilter sets the function that decides which items this replica processes. Items it doesn't accept are left
+// in the queue for other replicas e.g. replicas that have loaded the RepoConfig defining their reconciler.
+func (q *GCSQueue) setFilter(accept func(name string) bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.accept = accept
+}
+
+// objectName returns the name of the object for the item. Identical items map to the same object.
+func objectName(e *encodedItem) (string, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to serialize item %v", e.Name)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]) + ".json", nil
+}
+
+func (q *GCSQueue) Add(item Item) {
+	q.AddAfter(item, 0)
+}
+
+// AddAfter adds the item so it can be processed once duration has passed. If the item is already queued it is
+// processed at the earlier of the two times. Errors are logged since Queue doesn't return them.
+func (q *GCSQueue) AddAfter(item Item, duration time.Duration) {
+	if err := q.addAfter(item, duration); err != nil {
+		q.log.Error(err, "Failed to add item to the queue", "name", item.Name)
+	}
+}
+
+func (q *GCSQueue) addAfter(item Item, duration time.Duration) error {
+	e, err := encodeItem(item)
+	if err != nil {
+		return err
+	}
+	name, err := objectName(e)
+	if err != nil {
+		return err
+	}
+	notBefore := q.now().Add(duration)
+
+	for i := 0; i < maxQueueConflicts; i++ {
+		b, err := json.Marshal(&queueRecord{Item: *e, NotBefore: notBefore})
+		if err != nil {
+			return errors.Wrapf(err, "Failed to serialize item %v", item.Name)
+		}
+		created, err := q.store.create(q.ctx, name, b)
+		if err != nil {
+			return err
+		}
+		if created {
+			return nil
+		}
+
+		record, generation, err := q.read(name)
+		if err == errQueueObjectNotFound {
+			// The item was processed in the meantime.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if record.leased(q.now()) {
+			if record.Dirty && !record.NotBefore.After(notBefore) {
+				return nil
+			}
+			// Process the item again once it is done.
+			record.Dirty = true
+			record.NotBefore = notBefore
+		} else {
+			if !record.NotBefore.After(notBefore) {
+				return nil
+			}
+			record.NotBefore = notBefore
+		}
+		updated, err := q.write(name, record, generation)
+		if err != nil {
+			return err
+		}
+		if updated {
+			return nil
+		}
+	}
+	return errors.Errorf("Failed to add item %v; it was modified concurrently %v times", item.Name, maxQueueConflicts)
+}
+
+// Get blocks until it leases an item that is ready to be processed or the queue is shut down.
+func (q *GCSQueue) Get() (Item, bool) {
+	for {
+		item, ok, err := q.claim()
+		if err != nil {
+			q.log.Error(err, "Failed to get an item from the queue")
+		}
+		if ok {
+			return item, false
+		}
+		select {
+		case <-q.ctx.Done():
+			return Item{}, true
+		case <-time.After(q.pollInterval):
+		}
+	}
+}
+
+// claim leases the first item that is ready to be processed and accepted by this replica. It returns false if
+// there isn't one.
+func (q *GCSQueue) claim() (Item, bool, error) {
+	names, err := q.store.list(q.ctx)
+	if err != nil {
+		return Item{}, false, err
+	}
+	q.mu.Lock()
+	accept := q.accept
+	q.mu.Unlock()
+	for _, name := range names {
+		record, generation, err := q.read(name)
+		if err == errQueueObjectNotFound {
+			continue
+		}
+		if err != nil {
+			q.log.Error(err, "Failed to read queue item", "object", name)
+			continue
+		}
+		now := q.now()
+		if record.leased(now) || now.Before(record.NotBefore) {
+			continue
+		}
+		item, err := record.Item.decode()
+		if err != nil {
+			q.log.Error(err, "Skipping queue item that can't be decoded", "object", name)
+			continue
+		}
+		if accept != nil && !accept(item.Name) {
+			continue
+		}
+
+		record.Owner = q.identity
+		record.LeaseExpiry = now.Add(q.lease)
+		record.Dirty = false
+		claimed, err := q.write(name, record, generation)
+		if err != nil {
+			q.log.Error(err, "Failed to lease queue item", "object", name)
+			continue
+		}
+		if !claimed {
+			// Another replica got it first.
+			continue
+		}
+		q.mu.Lock()
+		q.names[itemKey(item)] = name
+		q.mu.Unlock()
+		return item, true, nil
+	}
+	return Item{}, false, nil
+}
+
+// Done deletes the item unless it was added again while it was being processed in which case its lease is
+// released so it is processed again.
+func (q *GCSQueue) Done(item Item) {
+	q.mu.Lock()
+	name, ok := q.names[itemKey(item)]
+	delete(q.names, itemKey(item))
+	q.mu.Unlock()
+	if !ok {
+		return
+	}
+	if err := q.done(name); err != nil {
+		q.log.Error(err, "Failed to mark queue item as done; it will be processed again once its lease expires", "name", item.Name)
+	}
+}
+
+func (q *GCSQueue) done(name string) error {
+	// N.B. Use a background context so items are still marked done when the queue is shutting down.
+	ctx := context.Background()
+	for i := 0; i < maxQueueConflicts; i++ {
+		record, generation, err := q.read(name)
+		if err == errQueueObjectNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if record.Owner != q.identity {
+			// The lease expired and another replica took over the item.
+			return nil
+		}
+
+		var ok bool
+		if record.Dirty {
+			record.Owner = ""
+			record.LeaseExpiry = time.Time{}
+			record.Dirty = false
+			b, err := json.Marshal(record)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to serialize queue item %v", name)
+			}
+			ok, err = q.store.update(ctx, name, b, generation)
+			if err != nil {
+				return err
+			}
+		} else {
+			ok, err = q.store.delete(ctx, name, generation)
+			if err != nil {
+				return err
+			}
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.Errorf("Failed to mark queue item %v as done; it was modified concurrently %v times", name, maxQueueConflicts)
+}
+
+// ShutDown causes Get to return. Unlike the in memory queue items aren't drained since they are persisted.
+func (q *GCSQueue) ShutDown() {
+	q.shutdown()
+}
+
+func (q *GCSQueue) read(name string) (*queueRecord, int64, error) {
+	b, generation, err := q.store.read(q.ctx, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	record := &queueRecord{}
+	if err := json.Unmarshal(b, record); err != nil {
+		return nil, 0, errors.Wrapf(err, "Failed to decode queue item %v", name)
+	}
+	return record, generation, nil
+}
+
+func (q *GCSQueue) write(name string, record *queueRecord, generation int64) (bool, error) {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return false, errors.Wrapf(err, "Failed to serialize queue item %v", name)
+	}
+	return q.store.update(q.ctx, name, b, generation)
+}
+
+// itemKey identifies an item being processed.
+func itemKey(item Item) string {
+	e, err := encodeItem(item)
+	if err != nil {
+		return item.Name
+	}
+	name, err := objectName(e)
+	if err != nil {
+		return item.Name
+	}
+	return name
+}
+
+// gcsQueueStore stores the queue in the objects under prefix in bucket.
+type gcsQueueStore struct {
+	bucket *storage.BucketHandle
+	prefix string
+}
+
+var _ queueStore = &gcsQueueStore{}
+
+func (s *gcsQueueStore) create(ctx context.Context, name string, data []byte) (bool, error) {
+	return s.write(ctx, s.bucket.Object(s.prefix+name).If(storage.Conditions{DoesNotExist: true}), name, data)
+}
+
+func (s *gcsQueueStore) update(ctx context.Context, name string, data []byte, generation int64) (bool, error) {
+	return s.write(ctx, s.bucket.Object(s.prefix+name).If(storage.Conditions{GenerationMatch: generation}), name, data)
+}
+
+func (s *gcsQueueStore) write(ctx context.Context, object *storage.ObjectHandle, name string, data []byte) (bool, error) {
+	w := object.NewWriter(ctx)
+	w.ContentType = "application/json"
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		w.Close()
+		return false, errors.Wrapf(err, "Failed to write queue item %v", name)
+	}
+	if err := w.Close(); err != nil {
+		if isPreconditionFailed(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "Failed to write queue item %v", name)
+	}
+	return true, nil
+}
+
+func (s *gcsQueueStore) read(ctx context.Context, name string) ([]byte, int64, error) {
+	r, err := s.bucket.Object(s.prefix + name).NewReader(ctx)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return nil, 0, errQueueObjectNotFound
+		}
+		return nil, 0, errors.Wrapf(err, "Failed to read queue item %v", name)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "Failed to read queue item %v", name)
+	}
+	return b, r.Attrs.Generation, nil
+}
+
+func (s *gcsQueueStore) delete(ctx context.Context, name string, generation int64) (bool, error) {
+	err := s.bucket.Object(s.prefix + name).If(storage.Conditions{GenerationMatch: generation}).Delete(ctx)
+	if err == nil {
+		return true, nil
+	}
+	if err == storage.ErrObjectNotExist || isPreconditionFailed(err) {
+		return false, nil
+	}
+	return false, errors.Wrapf(err, "Failed to delete queue item %v", name)
+}
+
+func (s *gcsQueueStore) list(ctx context.Context) ([]string, error) {
+	names := []string{}
+	it := s.bucket.Objects(ctx, &storage.Query{Prefix: s.prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return names, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to list queue items")
+		}
+		names = append(names, strings.TrimPrefix(attrs.Name, s.prefix))
+	}
+}
+
+// isPreconditionFailed returns true if the error is because the conditions on the object weren't met.
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed
+}
@@ -0,0 +1,250 @@
+package gitops
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/util"
+)
+
+// fakeQueueStore is an in memory queueStore with the same generation semantics as GCS.
+type fakeQueueStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	gens    map[string]int64
+	next    int64
+}
+
+func newFakeQueueStore() *fakeQueueStore {
+	return &fakeQueueStore{objects: map[string][]byte{}, gens: map[string]int64{}}
+}
+
+func (s *fakeQueueStore) put(name string, data []byte) {
+	s.next++
+	s.objects[name] = data
+	s.gens[name] = s.next
+}
+
+func (s *fakeQueueStore) create(ctx context.Context, name string, data []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[name]; ok {
+		return false, nil
+	}
+	s.put(name, data)
+	return true, nil
+}
+
+func (s *fakeQueueStore) read(ctx context.Context, name string) ([]byte, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.objects[name]
+	if !ok {
+		return nil, 0, errQueueObjectNotFound
+	}
+	return b, s.gens[name], nil
+}
+
+func (s *fakeQueueStore) update(ctx context.Context, name string, data []byte, generation int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gens[name] != generation {
+		return false, nil
+	}
+	s.put(name, data)
+	return true, nil
+}
+
+func (s *fakeQueueStore) delete(ctx context.Context, name string, generation int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[name]; !ok || s.gens[name] != generation {
+		return false, nil
+	}
+	delete(s.objects, name)
+	delete(s.gens, name)
+	return true, nil
+}
+
+func (s *fakeQueueStore) list(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := []string{}
+	for n := range s.objects {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func Test_encodeItem(t *testing.T) {
+	items := []Item{
+		{Name: "resync"},
+		{Name: "sync", Event: SyncEvent{Commit: "1234"}},
+		{Name: "image", Event: ImagePushedEvent{Image: util.DockerImageRef{Registry: "gcr.io", Repo: "acme/web", Tag: "latest"}}},
+		{Name: "render", Event: RenderEvent{Commit: "1234", BranchConfig: &v1alpha1.InPlaceConfig{BaseBranch: "main", PRBranch: "hydros/main"}}},
+		{Name: "merge-queue", Event: MergeQueueEvent{Repo: "acme/manifests", Branch: "main"}},
+	}
+	for _, item := range items {
+		e, err := encodeItem(item)
+		if err != nil {
+			t.Fatalf("encodeItem failed; %v", err)
+		}
+		actual, err := e.decode()
+		if err != nil {
+			t.Fatalf("decode failed; %v", err)
+		}
+		if d := cmp.Diff(item, actual); d != "" {
+			t.Errorf("Item didn't round trip; diff:\n%v", d)
+		}
+	}
+
+	if _, err := encodeItem(Item{Name: "bad", Event: "not an event"}); err == nil {
+		t.Errorf("Expected an error for an event that can't be queued")
+	}
+}
+
+func Test_GCSQueue(t *testing.T) {
+	store := newFakeQueueStore()
+	now := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	newQueue := func(identity string) *GCSQueue {
+		q, err := newGCSQueue(store, identity, WithQueuePollInterval(time.Millisecond), WithQueueLease(time.Minute))
+		if err != nil {
+			t.Fatalf("Failed to create queue; %v", err)
+		}
+		q.now = func() time.Time { return now }
+		return q
+	}
+	first := newQueue("first")
+	second := newQueue("second")
+
+	// Duplicate items are merged.
+	event := Item{Name: "sync", Event: SyncEvent{Commit: "1234"}}
+	first.Add(event)
+	second.Add(event)
+	second.AddAfter(Item{Name: "resync"}, time.Hour)
+	if names, _ := store.list(context.Background()); len(names) != 2 {
+		t.Fatalf("Expected 2 items in the queue; got %v", names)
+	}
+
+	item, shutdown := first.Get()
+	if shutdown {
+		t.Fatalf("Get returned shutdown")
+	}
+	if d := cmp.Diff(event, item); d != "" {
+		t.Fatalf("Unexpected item; diff:\n%v", d)
+	}
+
+	// The item is leased by the first replica and the resync isn't ready so the second replica can't get anything.
+	if _, ok, err := second.claim(); err != nil || ok {
+		t.Fatalf("Expected no items to be ready; ok %v err %v", ok, err)
+	}
+
+	// Adding the item while it is being processed means it is processed again once it is done.
+	second.Add(event)
+	first.Done(item)
+	item, shutdown = second.Get()
+	if shutdown || item.Name != "sync" {
+		t.Fatalf("Expected the item to be processed again; got %v shutdown %v", item, shutdown)
+	}
+
+	// If the replica processing the item dies the item is processed once its lease expires.
+	now = now.Add(2 * time.Minute)
+	item, shutdown = first.Get()
+	if shutdown || item.Name != "sync" {
+		t.Fatalf("Expected the item to be processed after the lease expired; got %v shutdown %v", item, shutdown)
+	}
+	first.Done(item)
+	// The second replica lost the lease so marking the item done is a no-op.
+	second.Done(item)
+	if names, _ := store.list(context.Background()); len(names) != 1 {
+		t.Fatalf("Expected only the resync to be left in the queue; got %v", names)
+	}
+
+	now = now.Add(time.Hour)
+	item, _ = second.Get()
+	if item.Name != "resync" || item.Event != nil {
+		t.Fatalf("Expected the resync; got %v", item)
+	}
+	second.Done(item)
+
+	// Get returns once the queue is shut down.
+	done := make(chan bool)
+	go func() {
+		_, shutdown := first.Get()
+		done <- shutdown
+	}()
+	first.ShutDown()
+	if !<-done {
+		t.Errorf("Get didn't return shutdown")
+	}
+}
+
+// channelReconciler sends the events it is run with to a channel.
+type channelReconciler struct {
+	name   string
+	events chan any
+}
+
+func (r *channelReconciler) Name() string {
+	return r.name
+}
+
+func (r *channelReconciler) Run(event any) error {
+	r.events <- event
+	return nil
+}
+
+func Test_GCSQueueSkipsUnknownReconcilers(t *testing.T) {
+	store := newFakeQueueStore()
+	newQueue := func(identity string) *GCSQueue {
+		q, err := newGCSQueue(store, identity, WithQueuePollInterval(time.Millisecond), WithQueueLease(time.Minute))
+		if err != nil {
+			t.Fatalf("Failed to create queue; %v", err)
+		}
+		return q
+	}
+
+	// The first replica doesn't have the reconciler e.g. because it hasn't loaded the RepoConfig defining it.
+	without, err := NewManager([]Reconciler{}, WithQueue(newQueue("without")))
+	if err != nil {
+		t.Fatalf("Failed to create manager; %v", err)
+	}
+	if err := without.Start(1, time.Hour); err != nil {
+		t.Fatalf("Failed to start manager; %v", err)
+	}
+	defer without.Shutdown()
+
+	event := Item{Name: "sync", Event: SyncEvent{Commit: "1234"}}
+	without.q.Add(event)
+
+	// Give the replica without the reconciler plenty of chances to claim the item.
+	time.Sleep(50 * time.Millisecond)
+	if names, _ := store.list(context.Background()); len(names) != 1 {
+		t.Fatalf("Expected the item to be left in the queue; got %v", names)
+	}
+
+	r := &channelReconciler{name: "sync", events: make(chan any, 10)}
+	with, err := NewManager([]Reconciler{r}, WithQueue(newQueue("with")))
+	if err != nil {
+		t.Fatalf("Failed to create manager; %v", err)
+	}
+	if err := with.Start(1, time.Hour); err != nil {
+		t.Fatalf("Failed to start manager; %v", err)
+	}
+	defer with.Shutdown()
+
+	select {
+	case actual := <-r.events:
+		if d := cmp.Diff(event.Event, actual); d != "" {
+			t.Errorf("Unexpected event; diff:\n%v", d)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for the replica with the reconciler to process the item")
+	}
+}
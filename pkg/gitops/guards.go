@@ -0,0 +1,88 @@
+package gitops
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/pkg/errors"
+)
+
+// binarySniffLen is the number of bytes inspected to decide whether a file is binary. This matches the heuristic
+// git uses; a file is binary if it contains a NUL byte in its first 8000 bytes.
+const binarySniffLen = 8000
+
+// checkFileGuards returns a description of each file below dir that violates the guards. Paths in the
+// descriptions are relative to dir.
+func checkFileGuards(dir string, guards *v1alpha1.FileGuards) ([]string, error) {
+	maxSize, err := guards.MaxFileSizeBytes()
+	if err != nil {
+		return nil, err
+	}
+	allowBinary := guards != nil && guards.AllowBinary
+
+	violations := []string{}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if info.Size() > maxSize {
+			violations = append(violations, fmt.Sprintf("%v is %v bytes; the limit is %v bytes", rel, info.Size(), maxSize))
+		}
+		if allowBinary {
+			return nil
+		}
+		binary, err := isBinary(path)
+		if err != nil {
+			return err
+		}
+		if binary {
+			violations = append(violations, fmt.Sprintf("%v is a binary file", rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to check the files in %v", dir)
+	}
+	return violations, nil
+}
+
+// isBinary returns true if the file at path looks like a binary file.
+func isBinary(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, binarySniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	return bytes.IndexByte(buf[:n], 0) >= 0, nil
+}
+
+// buildFileGuardWarnings renders the violations as a markdown list for the PR description. It returns the empty
+// string if there are no violations.
+func buildFileGuardWarnings(violations []string) string {
+	if len(violations) == 0 {
+		return ""
+	}
+	lines := []string{"Warning: the hydrated manifests include binaries or large files; this is often a symptom of a misconfigured helm chart:"}
+	for _, v := range violations {
+		lines = append(lines, "* "+v)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,68 @@
+package gitops
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/api/v1alpha1"
+)
+
+func Test_checkFileGuards(t *testing.T) {
+	dir, err := os.MkdirTemp("", "testFileGuards")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string][]byte{
+		"dev/deployment.yaml":  []byte("apiVersion: apps/v1\nkind: Deployment\n"),
+		"dev/charts/app.tgz":   {0x1f, 0x8b, 0x08, 0x00, 0x00},
+		"prod/configmap.yaml":  []byte(strings.Repeat("a", 2048)),
+		"prod/deployment.yaml": []byte("apiVersion: apps/v1\nkind: Deployment\n"),
+	}
+	for name, contents := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("Failed to create dir; %v", err)
+		}
+		if err := os.WriteFile(p, contents, 0o644); err != nil {
+			t.Fatalf("Failed to write %v; %v", name, err)
+		}
+	}
+
+	type testCase struct {
+		name     string
+		guards   *v1alpha1.FileGuards
+		expected []string
+	}
+
+	cases := []testCase{
+		{
+			name:     "defaults",
+			guards:   nil,
+			expected: []string{"dev/charts/app.tgz is a binary file"},
+		},
+		{
+			name:   "small-limit",
+			guards: &v1alpha1.FileGuards{MaxFileSize: "1Ki", AllowBinary: true},
+			expected: []string{
+				"prod/configmap.yaml is 2048 bytes; the limit is 1024 bytes",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := checkFileGuards(dir, c.guards)
+			if err != nil {
+				t.Fatalf("checkFileGuards failed; %v", err)
+			}
+			if d := cmp.Diff(c.expected, actual); d != "" {
+				t.Errorf("Unexpected violations; diff:\n%v", d)
+			}
+		})
+	}
+}
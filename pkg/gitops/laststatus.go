@@ -0,0 +1,120 @@
+package gitops
+
+import (
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// parseLastStatus parses the status in the sync file written by a previous sync.
+//
+// The sync file may have been written by an older or newer version of hydros so parsing is tolerant. Only the
+// status is parsed so changes to the spec don't matter. If the status can't be decoded as a whole, each field
+// is decoded on its own and the fields that can't be decoded are skipped; list fields are decoded item by item.
+// Fields hydros doesn't know about are ignored. The returned errors describe the problems; the status
+// contains everything that could be decoded.
+func parseLastStatus(r io.Reader) (*v1alpha1.ManifestSyncStatus, []error) {
+	status := &v1alpha1.ManifestSyncStatus{
+		PinnedImages: []v1alpha1.PinnedImage{},
+	}
+
+	doc := &yaml.Node{}
+	if err := yaml.NewDecoder(r).Decode(doc); err != nil {
+		if err == io.EOF {
+			return status, []error{errors.New("sync file is empty")}
+		}
+		return status, []error{errors.Wrapf(err, "Failed to parse sync file")}
+	}
+
+	root := doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return status, []error{errors.Errorf("sync file isn't a YAML object")}
+	}
+
+	statusNode := mappingValue(root, "status")
+	if statusNode == nil {
+		return status, []error{errors.New("sync file doesn't have a status")}
+	}
+
+	if err := statusNode.Decode(status); err == nil {
+		if status.PinnedImages == nil {
+			status.PinnedImages = []v1alpha1.PinnedImage{}
+		}
+		return status, nil
+	}
+
+	// Fall back to decoding each field separately.
+	status = &v1alpha1.ManifestSyncStatus{
+		PinnedImages: []v1alpha1.PinnedImage{},
+	}
+	problems := []error{}
+	fields := yamlFields(reflect.ValueOf(status).Elem())
+	if statusNode.Kind != yaml.MappingNode {
+		return status, []error{errors.New("status in sync file isn't a YAML object")}
+	}
+	for i := 0; i+1 < len(statusNode.Content); i += 2 {
+		name := statusNode.Content[i].Value
+		value := statusNode.Content[i+1]
+		field, ok := fields[name]
+		if !ok {
+			continue
+		}
+		problems = append(problems, decodeField(name, value, field)...)
+	}
+	return status, problems
+}
+
+// decodeField decodes the node into the field. Slices are decoded item by item so one bad item doesn't lose the
+// others.
+func decodeField(name string, n *yaml.Node, field reflect.Value) []error {
+	if err := n.Decode(field.Addr().Interface()); err == nil {
+		return nil
+	} else if field.Kind() != reflect.Slice || n.Kind != yaml.SequenceNode {
+		field.Set(reflect.Zero(field.Type()))
+		return []error{errors.Wrapf(err, "Failed to decode status.%v", name)}
+	}
+
+	problems := []error{}
+	items := reflect.MakeSlice(field.Type(), 0, len(n.Content))
+	for i, item := range n.Content {
+		v := reflect.New(field.Type().Elem())
+		if err := item.Decode(v.Interface()); err != nil {
+			problems = append(problems, errors.Wrapf(err, "Failed to decode status.%v[%v]", name, i))
+			continue
+		}
+		items = reflect.Append(items, v.Elem())
+	}
+	field.Set(items)
+	return problems
+}
+
+// yamlFields returns the fields of the struct v keyed by their YAML names.
+func yamlFields(v reflect.Value) map[string]reflect.Value {
+	fields := map[string]reflect.Value{}
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = v.Field(i)
+	}
+	return fields
+}
+
+// mappingValue returns the value of key in the mapping node n or nil if it doesn't exist.
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
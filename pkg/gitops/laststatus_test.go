@@ -0,0 +1,90 @@
+package gitops
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/api/v1alpha1"
+)
+
+func Test_parseLastStatus(t *testing.T) {
+	type testCase struct {
+		name        string
+		input       string
+		expected    *v1alpha1.ManifestSyncStatus
+		numProblems int
+	}
+
+	cases := []testCase{
+		{
+			name: "valid",
+			input: `apiVersion: hydros.dev/v1alpha1
+kind: ManifestSync
+status:
+  pausedBy: jane@acme.com
+  sourceCommit: abcd
+  pinnedImages:
+    - image: gcr.io/acme/app:latest
+      newImage: gcr.io/acme/app@sha256:1234
+`,
+			expected: &v1alpha1.ManifestSyncStatus{
+				PausedBy:     "jane@acme.com",
+				SourceCommit: "abcd",
+				PinnedImages: []v1alpha1.PinnedImage{{Image: "gcr.io/acme/app:latest", NewImage: "gcr.io/acme/app@sha256:1234"}},
+			},
+		},
+		{
+			// A newer version changed the type of a spec field and added a status field.
+			name: "newer-schema",
+			input: `apiVersion: hydros.dev/v1alpha2
+kind: ManifestSync
+spec:
+  sourceRepo: https://github.com/acme/app.git
+status:
+  sourceCommit: abcd
+  syncedAt: "2024-01-30T20:46:27-08:00"
+`,
+			expected: &v1alpha1.ManifestSyncStatus{
+				SourceCommit: "abcd",
+				PinnedImages: []v1alpha1.PinnedImage{},
+			},
+		},
+		{
+			name: "bad-fields",
+			input: `status:
+  sourceCommit: abcd
+  pausedUntil: not-a-time
+  pinnedImages:
+    - image: gcr.io/acme/app:latest
+      newImage: gcr.io/acme/app@sha256:1234
+    - image: [not, a, string]
+`,
+			expected: &v1alpha1.ManifestSyncStatus{
+				SourceCommit: "abcd",
+				PinnedImages: []v1alpha1.PinnedImage{{Image: "gcr.io/acme/app:latest", NewImage: "gcr.io/acme/app@sha256:1234"}},
+			},
+			numProblems: 2,
+		},
+		{
+			name:  "no-status",
+			input: "kind: ManifestSync\n",
+			expected: &v1alpha1.ManifestSyncStatus{
+				PinnedImages: []v1alpha1.PinnedImage{},
+			},
+			numProblems: 1,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, problems := parseLastStatus(strings.NewReader(c.input))
+			if d := cmp.Diff(c.expected, actual); d != "" {
+				t.Errorf("Unexpected status; diff:\n%v", d)
+			}
+			if len(problems) != c.numProblems {
+				t.Errorf("Got %v problems; want %v; problems: %v", len(problems), c.numProblems, problems)
+			}
+		})
+	}
+}
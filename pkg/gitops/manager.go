@@ -2,13 +2,16 @@ package gitops
 
 import (
 	"fmt"
+	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/go-logr/zapr"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/util"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
-	"k8s.io/client-go/util/workqueue"
 )
 
 // Reconciler defines a common interface for reconcilers so that Manager can be used to manage different
@@ -25,6 +28,26 @@ type Reconciler interface {
 	Run(event any) error
 }
 
+// ConditionReporter is implemented by reconcilers that report the conditions of the resource they reconcile
+// e.g. Syncer.
+type ConditionReporter interface {
+	// Conditions returns the conditions of the resource after the latest run.
+	Conditions() []v1alpha1.Condition
+}
+
+// Canceler is implemented by reconcilers whose runs can be cancelled e.g. Syncer.
+type Canceler interface {
+	// Cancel cancels the run in progress, if any. It returns true if a run was cancelled.
+	Cancel() bool
+}
+
+// SyncPeriodProvider is implemented by reconcilers whose resource overrides how often it is resynced e.g. Syncer
+// using spec.syncPeriod.
+type SyncPeriodProvider interface {
+	// GetSyncPeriod returns how often to resync the resource. defaultPeriod is the period of the Manager.
+	GetSyncPeriod(defaultPeriod time.Duration) time.Duration
+}
+
 // Manager manages multiple reconcilers.
 // Its job is to ensure that
 //  1. A given reconciler is never running more than once concurrently
@@ -37,17 +60,93 @@ type Manager struct {
 	// Mapping from the a key to the corresponding syncer
 	syncers map[string]Reconciler
 
-	q workqueue.DelayingInterface
+	q Queue
 	// Wait group is used to detect when all workers have shutdown.
 	wg sync.WaitGroup
 	mu sync.RWMutex
+
+	recorders []ResultRecorder
+	// results are the results of the latest run of each reconciler.
+	results map[string]ReconcileResult
+
+	// isLeader returns true if this replica is the leader. If it is nil the replica is always the leader.
+	isLeader func() bool
+
+	// randFloat returns a random number in [0, 1). It can be overridden in tests.
+	randFloat func() float64
+}
+
+// notLeaderRequeuePeriod is how long to wait before checking again whether an event can be processed when
+// this replica isn't the leader.
+const notLeaderRequeuePeriod = 15 * time.Second
+
+// ReconcileResult is the outcome of a single run of a reconciler.
+type ReconcileResult struct {
+	// Name of the reconciler
+	Name string
+	// Event is the event that triggered the run; it is nil for periodic resyncs.
+	Event any
+	// StartTime is when the run started.
+	StartTime time.Time
+	// Duration is how long the run took.
+	Duration time.Duration
+	// Err is the error returned by the reconciler if the run failed.
+	Err error
+	// Conditions are the conditions of the resource after the run if the reconciler is a ConditionReporter.
+	Conditions []v1alpha1.Condition
+}
+
+// ResultRecorder records the results of reconciles e.g. to expose them to operators.
+type ResultRecorder interface {
+	Record(result ReconcileResult)
+}
+
+// ManagerOption is an option for the Manager.
+type ManagerOption func(m *Manager) error
+
+// WithResultRecorder adds a recorder that is invoked with the result of every reconcile.
+func WithResultRecorder(r ResultRecorder) ManagerOption {
+	return func(m *Manager) error {
+		m.recorders = append(m.recorders, r)
+		return nil
+	}
+}
+
+// WithLeaderCheck configures the manager to only run reconcilers while isLeader returns true. This allows multiple
+// replicas to run with only the leader reconciling. Events received while this replica isn't the leader are
+// requeued so they are processed if it becomes the leader.
+func WithLeaderCheck(isLeader func() bool) ManagerOption {
+	return func(m *Manager) error {
+		m.isLeader = isLeader
+		return nil
+	}
+}
+
+// WithQueue configures the manager to use q for reconcile events instead of an in memory queue e.g. a GCSQueue so
+// multiple replicas can share the events and events survive restarts.
+func WithQueue(q Queue) ManagerOption {
+	return func(m *Manager) error {
+		if q == nil {
+			return errors.New("queue is required")
+		}
+		m.q = q
+		return nil
+	}
 }
 
 // NewManager starts a new sync manager.
-func NewManager(syncers []Reconciler) (*Manager, error) {
+func NewManager(syncers []Reconciler, opts ...ManagerOption) (*Manager, error) {
 	m := &Manager{
-		syncers: make(map[string]Reconciler),
-		q:       workqueue.NewDelayingQueue(),
+		syncers:   make(map[string]Reconciler),
+		results:   make(map[string]ReconcileResult),
+		q:         NewMemoryQueue(),
+		randFloat: rand.Float64,
+	}
+
+	for _, o := range opts {
+		if err := o(m); err != nil {
+			return nil, err
+		}
 	}
 
 	for _, s := range syncers {
@@ -58,6 +157,10 @@ func NewManager(syncers []Reconciler) (*Manager, error) {
 
 		m.syncers[name] = s
 	}
+
+	if q, ok := m.q.(filteredQueue); ok {
+		q.setFilter(m.HasReconciler)
+	}
 	return m, nil
 }
 
@@ -107,12 +210,8 @@ func (m *Manager) Start(numWorkers int, reSyncPeriod time.Duration) error {
 
 	m.wg.Add(numWorkers)
 
-	for name := range m.syncers {
-		// Enqueue an item for each config.
-		log.Info("Enqueing config", "name", name)
-		m.q.Add(name)
-	}
-
+	// N.B. Start doesn't enqueue the reconcilers. They are run when an event is enqueued for them e.g. when
+	// they are added to the HydrosHandler; after that each run enqueues a resync.
 	return nil
 }
 
@@ -124,8 +223,12 @@ type Item struct {
 	Event any
 }
 
-// Enqueue adds a sync event for the reconciler with the specified name
+// Enqueue adds a sync event for the reconciler with the specified name. An error is returned if there is no
+// reconciler with the name.
 func (m *Manager) Enqueue(name string, payload any) error {
+	if !m.HasReconciler(name) {
+		return errors.Errorf("There is no reconciler named %v", name)
+	}
 	log := zapr.NewLogger(zap.L())
 	log.Info("Enqueing reconcile event", "reconciler", name, "payload", payload)
 	m.q.Add(Item{
@@ -147,19 +250,13 @@ func (m *Manager) runWorker(wid int, reSyncPeriod time.Duration) {
 	log := zapr.NewLogger(zap.L()).WithValues("windex", wid)
 	for {
 		shutdown := func() bool {
-			item, shutdown := m.q.Get()
+			latest, shutdown := m.q.Get()
 			if shutdown {
 				log.Info("worker shutting down")
 				return shutdown
 			}
 			// We need to mark the item as done. Until the item is marked as done further processing is blocked.
-			defer m.q.Done(item)
-			if _, ok := item.(Item); !ok {
-				// This is unexpected mark it as done and keep going
-				log.Info("Got work queue item which is not an Item; %v", item)
-				return shutdown
-			}
-			latest := item.(Item)
+			defer m.q.Done(latest)
 			s, ok := func() (Reconciler, bool) {
 				m.mu.RLock()
 				defer m.mu.RUnlock()
@@ -172,13 +269,39 @@ func (m *Manager) runWorker(wid int, reSyncPeriod time.Duration) {
 				return shutdown
 			}
 
-			if err := s.Run(latest.Event); err != nil {
+			if m.isLeader != nil && !m.isLeader() {
+				log.V(util.Debug).Info("Not the leader; requeuing event", "name", latest.Name)
+				m.q.AddAfter(latest, notLeaderRequeuePeriod)
+				return shutdown
+			}
+
+			start := time.Now()
+			err := s.Run(latest.Event)
+			result := ReconcileResult{
+				Name:      latest.Name,
+				Event:     latest.Event,
+				StartTime: start,
+				Duration:  time.Since(start),
+				Err:       err,
+			}
+			if r, ok := s.(ConditionReporter); ok {
+				result.Conditions = r.Conditions()
+			}
+			m.record(result)
+			if m.isLeader != nil && !m.isLeader() {
+				// Leadership was lost during the run so the run was probably cancelled. Requeue the event so it
+				// is processed by the leader.
+				log.Info("Lost leadership during the run; requeuing event", "name", latest.Name, "err", err)
+				m.q.AddAfter(latest, notLeaderRequeuePeriod)
+				return shutdown
+			}
+			if err != nil {
 				log.Error(err, "Failed to sync", "name", latest.Name)
 				return shutdown
 			}
 
 			// Leaving the event empty indicates it is a resync event
-			m.q.AddAfter(Item{Name: latest.Name}, reSyncPeriod)
+			m.q.AddAfter(Item{Name: latest.Name}, m.resyncDelay(s, reSyncPeriod))
 			return shutdown
 		}()
 
@@ -188,3 +311,78 @@ func (m *Manager) runWorker(wid int, reSyncPeriod time.Duration) {
 		}
 	}
 }
+
+// Cancel cancels the run in progress of the reconciler with the specified name. It returns true if a run was
+// cancelled. An error is returned if there is no reconciler with the name or its runs can't be cancelled.
+func (m *Manager) Cancel(name string) (bool, error) {
+	m.mu.RLock()
+	r, ok := m.syncers[name]
+	m.mu.RUnlock()
+	if !ok {
+		return false, errors.Errorf("There is no reconciler named %v", name)
+	}
+	c, ok := r.(Canceler)
+	if !ok {
+		return false, errors.Errorf("Runs of reconciler %v can't be cancelled", name)
+	}
+	return c.Cancel(), nil
+}
+
+// CancelAll cancels the runs in progress of all the reconcilers whose runs can be cancelled e.g. because this
+// replica stopped being the leader. It returns the names of the reconcilers whose runs were cancelled.
+func (m *Manager) CancelAll() []string {
+	m.mu.RLock()
+	cancelers := map[string]Canceler{}
+	for name, r := range m.syncers {
+		if c, ok := r.(Canceler); ok {
+			cancelers[name] = c
+		}
+	}
+	m.mu.RUnlock()
+
+	cancelled := []string{}
+	for name, c := range cancelers {
+		if c.Cancel() {
+			cancelled = append(cancelled, name)
+		}
+	}
+	sort.Strings(cancelled)
+	return cancelled
+}
+
+// resyncDelay returns how long to wait before resyncing the reconciler. The period of the reconciler's resource,
+// if it has one, is used instead of reSyncPeriod. The delay is randomly varied by up to defaultJitter so that
+// reconcilers that ran at the same time don't keep resyncing at the same time.
+func (m *Manager) resyncDelay(r Reconciler, reSyncPeriod time.Duration) time.Duration {
+	period := reSyncPeriod
+	if p, ok := r.(SyncPeriodProvider); ok {
+		period = p.GetSyncPeriod(reSyncPeriod)
+	}
+	offset := (2*m.randFloat() - 1) * defaultJitter * float64(period)
+	return period + time.Duration(offset)
+}
+
+// Results returns the result of the latest run of each reconciler sorted by name. Reconcilers that haven't run
+// yet are omitted.
+func (m *Manager) Results() []ReconcileResult {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	results := make([]ReconcileResult, 0, len(m.results))
+	for _, r := range m.results {
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	return results
+}
+
+// record stores the result and passes it to all the recorders.
+func (m *Manager) record(result ReconcileResult) {
+	m.mu.Lock()
+	m.results[result.Name] = result
+	m.mu.Unlock()
+	for _, r := range m.recorders {
+		r.Record(result)
+	}
+}
@@ -0,0 +1,137 @@
+package gitops
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+)
+
+type fakeReconciler struct {
+	name string
+}
+
+func (r *fakeReconciler) Name() string {
+	return r.name
+}
+
+func (r *fakeReconciler) Run(event any) error {
+	return nil
+}
+
+func Test_ManagerResyncDelay(t *testing.T) {
+	m, err := NewManager([]Reconciler{})
+	if err != nil {
+		t.Fatalf("Failed to create manager; %v", err)
+	}
+
+	withPeriod := &Syncer{
+		manifest: &v1alpha1.ManifestSync{
+			Spec: v1alpha1.ManifestSyncSpec{SyncPeriod: "1h"},
+		},
+	}
+	withoutPeriod := &Syncer{
+		manifest: &v1alpha1.ManifestSync{},
+	}
+
+	type testCase struct {
+		name       string
+		reconciler Reconciler
+		random     float64
+		expected   time.Duration
+	}
+
+	cases := []testCase{
+		{name: "no-jitter", reconciler: &fakeReconciler{name: "fake"}, random: 0.5, expected: 10 * time.Minute},
+		{name: "min-jitter", reconciler: &fakeReconciler{name: "fake"}, random: 0, expected: 9 * time.Minute},
+		{name: "sync-period", reconciler: withPeriod, random: 0.5, expected: time.Hour},
+		{name: "sync-period-jitter", reconciler: withPeriod, random: 0.75, expected: 63 * time.Minute},
+		{name: "sync-period-unset", reconciler: withoutPeriod, random: 0.5, expected: 10 * time.Minute},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m.randFloat = func() float64 { return c.random }
+			if actual := m.resyncDelay(c.reconciler, 10*time.Minute); actual != c.expected {
+				t.Errorf("resyncDelay: got %v; want %v", actual, c.expected)
+			}
+		})
+	}
+}
+
+// blockingReconciler blocks until its run is cancelled.
+type blockingReconciler struct {
+	started   chan bool
+	cancelled chan bool
+}
+
+func (r *blockingReconciler) Name() string {
+	return "blocking"
+}
+
+func (r *blockingReconciler) Run(event any) error {
+	r.started <- true
+	<-r.cancelled
+	return context.Canceled
+}
+
+func (r *blockingReconciler) Cancel() bool {
+	close(r.cancelled)
+	return true
+}
+
+// addAfterQueue records the items added with AddAfter.
+type addAfterQueue struct {
+	Queue
+	mu    sync.Mutex
+	added []Item
+}
+
+func (q *addAfterQueue) AddAfter(item Item, duration time.Duration) {
+	q.mu.Lock()
+	q.added = append(q.added, item)
+	q.mu.Unlock()
+	q.Queue.AddAfter(item, duration)
+}
+
+func Test_ManagerLosesLeadershipDuringRun(t *testing.T) {
+	leader := &atomic.Bool{}
+	leader.Store(true)
+	q := &addAfterQueue{Queue: NewMemoryQueue()}
+	r := &blockingReconciler{started: make(chan bool, 1), cancelled: make(chan bool)}
+	m, err := NewManager([]Reconciler{r}, WithQueue(q), WithLeaderCheck(leader.Load))
+	if err != nil {
+		t.Fatalf("Failed to create manager; %v", err)
+	}
+	if err := m.Start(1, time.Hour); err != nil {
+		t.Fatalf("Failed to start manager; %v", err)
+	}
+
+	event := SyncEvent{Commit: "1234"}
+	if err := m.Enqueue(r.Name(), event); err != nil {
+		t.Fatalf("Failed to enqueue event; %v", err)
+	}
+	select {
+	case <-r.started:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for the run to start")
+	}
+
+	// Losing leadership cancels the run in progress.
+	leader.Store(false)
+	if cancelled := m.CancelAll(); len(cancelled) != 1 || cancelled[0] != r.Name() {
+		t.Fatalf("Expected the run of %v to be cancelled; got %v", r.Name(), cancelled)
+	}
+	m.Shutdown()
+
+	// The event is requeued for the leader rather than resynced by this replica.
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	expected := []Item{{Name: r.Name(), Event: event}}
+	if len(q.added) != 1 || q.added[0] != expected[0] {
+		t.Errorf("Expected the items %v to be requeued; got %v", expected, q.added)
+	}
+}
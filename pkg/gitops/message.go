@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"strings"
 
+	ghAPI "github.com/google/go-github/v52/github"
 	"github.com/jlewi/hydros/api/v1alpha1"
 	"github.com/jlewi/hydros/pkg/util"
+	"google.golang.org/protobuf/proto"
 )
 
 // buildPrMessage generates the message for the PR.
@@ -17,15 +19,107 @@ func buildPrMessage(manifest *v1alpha1.ManifestSync, changedImages []util.Docker
 		fmt.Sprintf("Source: [%v](%v)", sourceKey, manifest.Status.SourceURL),
 		fmt.Sprintf("Source Branch: %v", manifest.Spec.SourceRepo.Branch),
 	}
+	if manifest.Spec.Hydration == v1alpha1.PinOnlyHydrationMode {
+		lines[0] = fmt.Sprintf("[Auto] Pin images in %v hydrated from %v; %v images changed", manifest.Spec.DestRepo.Branch, sourceKey, len(changedImages))
+		lines[1] = "Update the images pinned in the hydrated manifests; hydration was skipped because spec.hydration is " + string(v1alpha1.PinOnlyHydrationMode)
+	}
 
 	if len(changedImages) == 0 {
 		lines = append(lines, "Changed ImageList: None")
 	} else {
+		// Versions chosen using the SemverStrategy are recorded in the status.
+		versions := map[string]string{}
+		for _, p := range manifest.Status.PinnedImages {
+			if p.Version != "" {
+				versions[p.NewImage] = p.Version
+			}
+		}
 		lines = append(lines, "Changed ImageList:")
 		for _, i := range changedImages {
+			if v, ok := versions[i.ToURL()]; ok {
+				lines = append(lines, fmt.Sprintf("* %v (version %v)", i.ToURL(), v))
+				continue
+			}
 			lines = append(lines, fmt.Sprintf("* %v", i.ToURL()))
 		}
 	}
 
 	return strings.Join(lines, "\n")
 }
+
+// buildSyncCheckRun generates the check run reporting the result of a sync on the source commit.
+// prURLs are the PRs created by the sync. The check run links to the first of them.
+// awaitingApproval indicates the PRs won't be merged until they are approved.
+func buildSyncCheckRun(manifest *v1alpha1.ManifestSync, commit string, prURLs []string, awaitingApproval bool, syncErr error) ghAPI.CreateCheckRunOptions {
+	conclusion := "success"
+	title := fmt.Sprintf("Hydrated %v", manifest.Metadata.Name)
+	lines := []string{}
+	if awaitingApproval {
+		conclusion = "neutral"
+		title = fmt.Sprintf("Hydrated %v; waiting for the PRs to be approved", manifest.Metadata.Name)
+	}
+	if syncErr != nil {
+		conclusion = "failure"
+		title = fmt.Sprintf("Failed to hydrate %v", manifest.Metadata.Name)
+		lines = append(lines, fmt.Sprintf("Sync failed; error: %v", syncErr))
+	}
+
+	if len(prURLs) == 0 {
+		lines = append(lines, "Pull Requests: None")
+	} else {
+		lines = append(lines, "Pull Requests:")
+		for _, u := range prURLs {
+			lines = append(lines, fmt.Sprintf("* %v", u))
+		}
+	}
+
+	opts := ghAPI.CreateCheckRunOptions{
+		Name:       SyncCheckName + "/" + manifest.Metadata.Name,
+		HeadSHA:    commit,
+		Status:     proto.String("completed"),
+		Conclusion: proto.String(conclusion),
+		Output: &ghAPI.CheckRunOutput{
+			Title:   proto.String(title),
+			Summary: proto.String(title),
+			Text:    proto.String(strings.Join(lines, "\n")),
+		},
+	}
+	if len(prURLs) > 0 {
+		opts.DetailsURL = proto.String(prURLs[0])
+	}
+	return opts
+}
+
+// buildTestCheckRun generates the check run reporting the result of the tests run when building the image from
+// commit. buildErr is the error, if any, of the build.
+func buildTestCheckRun(image *v1alpha1.Image, commit string, buildErr error) ghAPI.CreateCheckRunOptions {
+	conclusion := "success"
+	title := fmt.Sprintf("Tests of %v passed", image.Metadata.Name)
+	if image.Status.TestStatus != "SUCCESS" {
+		conclusion = "failure"
+		title = fmt.Sprintf("Tests of %v failed", image.Metadata.Name)
+	}
+	lines := []string{fmt.Sprintf("Test status: %v", image.Status.TestStatus)}
+	if buildErr != nil {
+		lines = append(lines, fmt.Sprintf("Build error: %v", buildErr))
+	}
+	if image.Status.BuildLogsURL != "" {
+		lines = append(lines, fmt.Sprintf("Build logs: %v", image.Status.BuildLogsURL))
+	}
+
+	opts := ghAPI.CreateCheckRunOptions{
+		Name:       TestCheckName + "/" + image.Metadata.Name,
+		HeadSHA:    commit,
+		Status:     proto.String("completed"),
+		Conclusion: proto.String(conclusion),
+		Output: &ghAPI.CheckRunOutput{
+			Title:   proto.String(title),
+			Summary: proto.String(title),
+			Text:    proto.String(strings.Join(lines, "\n")),
+		},
+	}
+	if image.Status.BuildLogsURL != "" {
+		opts.DetailsURL = proto.String(image.Status.BuildLogsURL)
+	}
+	return opts
+}
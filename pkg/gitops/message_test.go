@@ -1,10 +1,14 @@
 package gitops
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+	ghAPI "github.com/google/go-github/v52/github"
 	"github.com/jlewi/hydros/api/v1alpha1"
 	"github.com/jlewi/hydros/pkg/util"
+	"google.golang.org/protobuf/proto"
 )
 
 func Test_BuildPrMessage(t *testing.T) {
@@ -49,6 +53,60 @@ Update hydrated manifests to [PrimerAI/some-git-repo@bf51fd1](https://github.com
 Source: [PrimerAI/some-git-repo@bf51fd1](https://github.com/PrimerAI/some-git-repo/tree/bf51fd1)
 Source Branch: master
 Changed ImageList:
+* 12345/some-repo/some-image:latest@9876`,
+		},
+		{
+			manifest: &v1alpha1.ManifestSync{
+				Spec: testManifest.Spec,
+				Status: v1alpha1.ManifestSyncStatus{
+					SourceURL:    "https://github.com/PrimerAI/some-git-repo/tree/bf51fd1",
+					SourceCommit: "bf51fd1",
+					PinnedImages: []v1alpha1.PinnedImage{
+						{
+							Image:    "docker.io/library/nginx:1.25",
+							NewImage: "docker.io/library/nginx:1.25.3@sha256:1234",
+							Version:  "1.25.3",
+						},
+					},
+				},
+			},
+			changedImages: []util.DockerImageRef{
+				{
+					Registry: "docker.io",
+					Repo:     "library/nginx",
+					Tag:      "1.25.3",
+					Sha:      "sha256:1234",
+				},
+			},
+			expected: `[Auto] Hydrate env/dev with PrimerAI/some-git-repo@bf51fd1; 1 images changed
+Update hydrated manifests to [PrimerAI/some-git-repo@bf51fd1](https://github.com/PrimerAI/some-git-repo/tree/bf51fd1)
+Source: [PrimerAI/some-git-repo@bf51fd1](https://github.com/PrimerAI/some-git-repo/tree/bf51fd1)
+Source Branch: master
+Changed ImageList:
+* docker.io/library/nginx:1.25.3@sha256:1234 (version 1.25.3)`,
+		},
+		{
+			manifest: &v1alpha1.ManifestSync{
+				Spec: func() v1alpha1.ManifestSyncSpec {
+					spec := testManifest.Spec
+					spec.Hydration = v1alpha1.PinOnlyHydrationMode
+					return spec
+				}(),
+				Status: testManifest.Status,
+			},
+			changedImages: []util.DockerImageRef{
+				{
+					Registry: "12345",
+					Repo:     "some-repo/some-image",
+					Tag:      "latest",
+					Sha:      "9876",
+				},
+			},
+			expected: `[Auto] Pin images in env/dev hydrated from PrimerAI/some-git-repo@bf51fd1; 1 images changed
+Update the images pinned in the hydrated manifests; hydration was skipped because spec.hydration is pinOnly
+Source: [PrimerAI/some-git-repo@bf51fd1](https://github.com/PrimerAI/some-git-repo/tree/bf51fd1)
+Source Branch: master
+Changed ImageList:
 * 12345/some-repo/some-image:latest@9876`,
 		},
 		{
@@ -70,3 +128,132 @@ Changed ImageList: None`,
 		}
 	}
 }
+
+func Test_BuildSyncCheckRun(t *testing.T) {
+	type testCase struct {
+		name     string
+		prURLs   []string
+		awaiting bool
+		err      error
+		expected ghAPI.CreateCheckRunOptions
+	}
+
+	manifest := &v1alpha1.ManifestSync{
+		Metadata: v1alpha1.Metadata{Name: "dev"},
+	}
+
+	testCases := []testCase{
+		{
+			name:   "success",
+			prURLs: []string{"https://github.com/acme/hydrated/pull/1"},
+			expected: ghAPI.CreateCheckRunOptions{
+				Name:       "hydros-sync/dev",
+				HeadSHA:    "bf51fd1",
+				DetailsURL: proto.String("https://github.com/acme/hydrated/pull/1"),
+				Status:     proto.String("completed"),
+				Conclusion: proto.String("success"),
+				Output: &ghAPI.CheckRunOutput{
+					Title:   proto.String("Hydrated dev"),
+					Summary: proto.String("Hydrated dev"),
+					Text:    proto.String("Pull Requests:\n* https://github.com/acme/hydrated/pull/1"),
+				},
+			},
+		},
+		{
+			name:     "awaiting-approval",
+			prURLs:   []string{"https://github.com/acme/hydrated/pull/1"},
+			awaiting: true,
+			expected: ghAPI.CreateCheckRunOptions{
+				Name:       "hydros-sync/dev",
+				HeadSHA:    "bf51fd1",
+				DetailsURL: proto.String("https://github.com/acme/hydrated/pull/1"),
+				Status:     proto.String("completed"),
+				Conclusion: proto.String("neutral"),
+				Output: &ghAPI.CheckRunOutput{
+					Title:   proto.String("Hydrated dev; waiting for the PRs to be approved"),
+					Summary: proto.String("Hydrated dev; waiting for the PRs to be approved"),
+					Text:    proto.String("Pull Requests:\n* https://github.com/acme/hydrated/pull/1"),
+				},
+			},
+		},
+		{
+			name: "failure",
+			err:  fmt.Errorf("Not all images could be resolved"),
+			expected: ghAPI.CreateCheckRunOptions{
+				Name:       "hydros-sync/dev",
+				HeadSHA:    "bf51fd1",
+				Status:     proto.String("completed"),
+				Conclusion: proto.String("failure"),
+				Output: &ghAPI.CheckRunOutput{
+					Title:   proto.String("Failed to hydrate dev"),
+					Summary: proto.String("Failed to hydrate dev"),
+					Text:    proto.String("Sync failed; error: Not all images could be resolved\nPull Requests: None"),
+				},
+			},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			actual := buildSyncCheckRun(manifest, "bf51fd1", c.prURLs, c.awaiting, c.err)
+			if d := cmp.Diff(c.expected, actual); d != "" {
+				t.Errorf("Unexpected check run; diff:\n%v", d)
+			}
+		})
+	}
+}
+
+func Test_BuildTestCheckRun(t *testing.T) {
+	type testCase struct {
+		name     string
+		status   v1alpha1.ImageStatus
+		err      error
+		expected ghAPI.CreateCheckRunOptions
+	}
+
+	testCases := []testCase{
+		{
+			name:   "success",
+			status: v1alpha1.ImageStatus{TestStatus: "SUCCESS", BuildLogsURL: "https://console.cloud.google.com/cloud-build/builds/1234"},
+			expected: ghAPI.CreateCheckRunOptions{
+				Name:       "hydros-test/app",
+				HeadSHA:    "bf51fd1",
+				DetailsURL: proto.String("https://console.cloud.google.com/cloud-build/builds/1234"),
+				Status:     proto.String("completed"),
+				Conclusion: proto.String("success"),
+				Output: &ghAPI.CheckRunOutput{
+					Title:   proto.String("Tests of app passed"),
+					Summary: proto.String("Tests of app passed"),
+					Text:    proto.String("Test status: SUCCESS\nBuild logs: https://console.cloud.google.com/cloud-build/builds/1234"),
+				},
+			},
+		},
+		{
+			name:   "failure",
+			status: v1alpha1.ImageStatus{TestStatus: "FAILURE", BuildLogsURL: "https://console.cloud.google.com/cloud-build/builds/1234"},
+			err:    fmt.Errorf("Tests failed with status FAILURE"),
+			expected: ghAPI.CreateCheckRunOptions{
+				Name:       "hydros-test/app",
+				HeadSHA:    "bf51fd1",
+				DetailsURL: proto.String("https://console.cloud.google.com/cloud-build/builds/1234"),
+				Status:     proto.String("completed"),
+				Conclusion: proto.String("failure"),
+				Output: &ghAPI.CheckRunOutput{
+					Title:   proto.String("Tests of app failed"),
+					Summary: proto.String("Tests of app failed"),
+					Text:    proto.String("Test status: FAILURE\nBuild error: Tests failed with status FAILURE\nBuild logs: https://console.cloud.google.com/cloud-build/builds/1234"),
+				},
+			},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			image := &v1alpha1.Image{Metadata: v1alpha1.Metadata{Name: "app"}, Status: c.status}
+			actual := buildTestCheckRun(image, "bf51fd1", c.err)
+			if d := cmp.Diff(c.expected, actual); d != "" {
+				t.Errorf("Unexpected check run; diff:\n%v", d)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package gitops
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	ghAPI "github.com/google/go-github/v52/github"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/github"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+)
+
+// PauseStatus reports whether syncs to a destination of a ManifestSync are paused.
+type PauseStatus struct {
+	// Name is the name of the ManifestSync; for environments it is the name of the environment's ManifestSync.
+	Name        string
+	Destination string
+	DestRepo    v1alpha1.GitHubRepo
+	DestPath    string
+	// Synced is false if the destination doesn't have a sync file yet.
+	Synced      bool
+	PausedUntil *time.Time
+	PausedBy    string
+	// Err is the error reading the sync file if any.
+	Err error
+}
+
+// IsPaused returns true if syncs to the destination are paused at time now.
+func (p PauseStatus) IsPaused(now time.Time) bool {
+	return p.PausedUntil != nil && p.PausedUntil.After(now)
+}
+
+// GetPauseStatus reads the sync files of the destinations of the ManifestSync and reports which are paused.
+// The sync files are read using the GitHub API so the destination repositories don't need to be cloned.
+// Errors reading a destination's sync file are reported in the PauseStatus of the destination.
+func GetPauseStatus(ctx context.Context, m *v1alpha1.ManifestSync, transports *github.TransportManager) []PauseStatus {
+	syncs := []*v1alpha1.ManifestSync{m}
+	if len(m.Spec.Environments) > 0 {
+		syncs = make([]*v1alpha1.ManifestSync, 0, len(m.Spec.Environments))
+		for _, e := range m.Spec.Environments {
+			syncs = append(syncs, m.ForEnvironment(e))
+		}
+	}
+
+	statuses := []PauseStatus{}
+	for _, s := range syncs {
+		for _, d := range s.Destinations() {
+			p := PauseStatus{
+				Name:        s.Metadata.Name,
+				Destination: d.Name,
+				DestRepo:    d.DestRepo,
+				DestPath:    d.DestPath,
+			}
+			status, err := readLastStatus(ctx, transports, d.DestRepo, path.Join(d.DestPath, lastSyncFile))
+			p.Err = err
+			if status != nil {
+				p.Synced = true
+				p.PausedBy = status.PausedBy
+				if status.PausedUntil != nil {
+					t := status.PausedUntil.Time
+					p.PausedUntil = &t
+				}
+			}
+			statuses = append(statuses, p)
+		}
+	}
+	return statuses
+}
+
+// readLastStatus reads the status in the sync file at filePath in repo using the GitHub API. It returns nil if
+// the file doesn't exist.
+func readLastStatus(ctx context.Context, transports *github.TransportManager, repo v1alpha1.GitHubRepo, filePath string) (*v1alpha1.ManifestSyncStatus, error) {
+	tr, err := transports.Get(repo.Org, repo.Repo)
+	if err != nil {
+		return nil, err
+	}
+	client := ghAPI.NewClient(&http.Client{Transport: tr})
+
+	f, _, resp, err := client.Repositories.GetContents(ctx, repo.Org, repo.Repo, filePath, &ghAPI.RepositoryContentGetOptions{Ref: repo.Branch})
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "Failed to get %v from %v/%v@%v", filePath, repo.Org, repo.Repo, repo.Branch)
+	}
+	if f == nil {
+		return nil, errors.Errorf("%v in %v/%v@%v isn't a file", filePath, repo.Org, repo.Repo, repo.Branch)
+	}
+	contents, err := f.GetContent()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to decode the contents of %v in %v/%v@%v", filePath, repo.Org, repo.Repo, repo.Branch)
+	}
+
+	status, problems := parseLastStatus(strings.NewReader(contents))
+	if len(problems) == 0 {
+		return status, nil
+	}
+	// Return the status along with the problems since the pause may still have been parsed.
+	parseErr := &util.ListOfErrors{
+		Final: errors.Errorf("Failed to parse %v in %v/%v@%v", filePath, repo.Org, repo.Repo, repo.Branch),
+	}
+	for _, p := range problems {
+		parseErr.AddCause(p)
+	}
+	return status, parseErr
+}
@@ -0,0 +1,212 @@
+package gitops
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// repinImages returns the images to replace in the dest path when syncing in pin only mode. It maps the URLs
+// of the images pinned by the last sync to the images they are now pinned to. Images whose pin didn't change
+// aren't included.
+func repinImages(lastPinned []v1alpha1.PinnedImage, pinnedImages map[util.DockerImageRef]util.DockerImageRef, log logr.Logger) map[string]util.DockerImageRef {
+	replacements := map[string]util.DockerImageRef{}
+	for _, p := range lastPinned {
+		source, err := util.ParseImageURL(p.Image)
+		if err != nil {
+			log.Error(err, "Could not parse image", "image", p.Image)
+			continue
+		}
+		old, err := util.ParseImageURL(p.NewImage)
+		if err != nil {
+			log.Error(err, "Could not parse image", "image", p.NewImage)
+			continue
+		}
+		resolved, ok := pinnedImages[*source]
+		if !ok || resolved.ToURL() == old.ToURL() {
+			continue
+		}
+		replacements[old.ToURL()] = resolved
+	}
+	return replacements
+}
+
+// updatePinnedStatus returns the pinned images to record after syncing in pin only mode. Only the images that
+// were pinned by the last sync are updated in the dest path so images that weren't pinned before aren't recorded.
+func updatePinnedStatus(lastPinned []v1alpha1.PinnedImage, pinnedImages map[util.DockerImageRef]util.DockerImageRef, versions map[util.DockerImageRef]string) []v1alpha1.PinnedImage {
+	results := make([]v1alpha1.PinnedImage, 0, len(lastPinned))
+	for _, p := range lastPinned {
+		if source, err := util.ParseImageURL(p.Image); err == nil {
+			if resolved, ok := pinnedImages[*source]; ok {
+				p.NewImage = resolved.ToURL()
+				p.Version = versions[*source]
+			}
+		}
+		results = append(results, p)
+	}
+	return results
+}
+
+// pinImagesInDest replaces the images in the YAML files in dir whose URLs are keys of replacements. Both the
+// images of resources and the images of kustomizations are replaced. It returns the paths, relative to dir, of
+// the files that were changed.
+func pinImagesInDest(dir string, replacements map[string]util.DockerImageRef, log logr.Logger) ([]string, error) {
+	changed := []string{}
+	if len(replacements) == 0 {
+		return changed, nil
+	}
+
+	replace := func(image string) (string, error) {
+		r, err := util.ParseImageURL(image)
+		if err != nil {
+			return image, nil
+		}
+		resolved, ok := replacements[r.ToURL()]
+		if !ok {
+			return image, nil
+		}
+		return resolved.ToURL(), nil
+	}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		ext := strings.ToLower(filepath.Ext(info.Name()))
+		if (ext != ".yaml" && ext != ".yml") || info.Name() == lastSyncFile {
+			return nil
+		}
+
+		nodes, err := util.ReadYaml(path)
+		if err != nil {
+			log.V(util.Debug).Info("Skipping file that couldn't be read as YAML", "path", path, "err", err)
+			return nil
+		}
+
+		fileChanged := false
+		for _, n := range nodes {
+			original, err := n.String()
+			if err != nil {
+				return errors.Wrapf(err, "Failed to serialize resource in %v", path)
+			}
+			if n.GetKind() == "Kustomization" || info.Name() == kustomizationFile {
+				err = pinKustomizationImages(n, replacements)
+			} else {
+				err = visitImages(n, replace)
+			}
+			if err != nil {
+				return errors.Wrapf(err, "Failed to pin images in %v", path)
+			}
+			updated, err := n.String()
+			if err != nil {
+				return errors.Wrapf(err, "Failed to serialize resource in %v", path)
+			}
+			fileChanged = fileChanged || original != updated
+		}
+
+		if !fileChanged {
+			return nil
+		}
+
+		var b bytes.Buffer
+		w := kio.ByteWriter{Writer: &b}
+		if err := w.Write(nodes); err != nil {
+			return errors.Wrapf(err, "Failed to serialize %v", path)
+		}
+		if err := os.WriteFile(path, b.Bytes(), info.Mode()); err != nil {
+			return errors.Wrapf(err, "Failed to write %v", path)
+		}
+
+		rPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return errors.Wrapf(err, "Could not compute relative path of %v", path)
+		}
+		changed = append(changed, rPath)
+		return nil
+	})
+	sort.Strings(changed)
+	return changed, err
+}
+
+// pinKustomizationImages replaces the images of the kustomization whose URLs are keys of replacements.
+// The image a kustomization sets is newName (or name if newName isn't set) with newTag and digest. The fields
+// are set the same way as util.SetKustomizeImage sets them.
+func pinKustomizationImages(k *yaml.RNode, replacements map[string]util.DockerImageRef) error {
+	images, err := k.Pipe(yaml.Lookup("images"))
+	if err != nil || images == nil {
+		return err
+	}
+	elements, err := images.Elements()
+	if err != nil {
+		return err
+	}
+	for _, e := range elements {
+		name, _ := e.GetString("newName")
+		if name == "" {
+			name, _ = e.GetString("name")
+		}
+		url := name
+		if tag, _ := e.GetString("newTag"); tag != "" {
+			url = url + ":" + tag
+		}
+		if digest, _ := e.GetString("digest"); digest != "" {
+			url = url + "@" + digest
+		}
+		r, err := util.ParseImageURL(url)
+		if err != nil {
+			continue
+		}
+		resolved, ok := replacements[r.ToURL()]
+		if !ok {
+			continue
+		}
+
+		newName := resolved.Registry + "/" + resolved.Repo
+		newTag := ""
+		if resolved.Tag != "" {
+			if resolved.Sha != "" {
+				// If digest and tag are set then tag should be part of the name
+				newName = newName + ":" + resolved.Tag
+			} else {
+				newTag = resolved.Tag
+			}
+		}
+
+		fields := []struct {
+			name  string
+			value string
+		}{
+			{name: "newName", value: newName},
+			{name: "newTag", value: newTag},
+			{name: "digest", value: resolved.Sha},
+		}
+		for _, f := range fields {
+			if f.value == "" {
+				if err := e.PipeE(yaml.Clear(f.name)); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := e.PipeE(yaml.SetField(f.name, yaml.NewStringRNode(f.value))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package gitops
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/zapr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/util"
+	"go.uber.org/zap"
+)
+
+func Test_PinImagesInDest(t *testing.T) {
+	log := zapr.NewLogger(zap.L())
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"web/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: gcr.io/project/web:latest@sha256:old
+      - name: sidecar
+        image: gcr.io/project/sidecar:v1
+`,
+		"web/service.yaml": `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+`,
+		"worker/kustomization.yaml": `resources:
+- deployment.yaml
+images:
+- name: gcr.io/project/worker
+  newName: gcr.io/project/worker:latest
+  digest: sha256:old
+`,
+		lastSyncFile: `status:
+  pinnedImages:
+  - image: gcr.io/project/web:latest
+    newImage: gcr.io/project/web:latest@sha256:old
+`,
+	}
+	for name, contents := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), util.FilePermUserGroup); err != nil {
+			t.Fatalf("Failed to create directory; %v", err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatalf("Failed to write %v; %v", p, err)
+		}
+	}
+
+	lastPinned := []v1alpha1.PinnedImage{
+		{Image: "gcr.io/project/web:latest", NewImage: "gcr.io/project/web:latest@sha256:old"},
+		{Image: "gcr.io/project/worker:latest", NewImage: "gcr.io/project/worker:latest@sha256:old"},
+		{Image: "gcr.io/project/unchanged:latest", NewImage: "gcr.io/project/unchanged:latest@sha256:same"},
+	}
+	pinned := map[util.DockerImageRef]util.DockerImageRef{
+		{Registry: "gcr.io", Repo: "project/web", Tag: "latest"}:       {Registry: "gcr.io", Repo: "project/web", Tag: "latest", Sha: "sha256:new"},
+		{Registry: "gcr.io", Repo: "project/worker", Tag: "latest"}:    {Registry: "gcr.io", Repo: "project/worker", Tag: "latest", Sha: "sha256:new"},
+		{Registry: "gcr.io", Repo: "project/unchanged", Tag: "latest"}: {Registry: "gcr.io", Repo: "project/unchanged", Tag: "latest", Sha: "sha256:same"},
+		// An image that wasn't pinned by the last sync isn't in the dest path.
+		{Registry: "gcr.io", Repo: "project/new", Tag: "latest"}: {Registry: "gcr.io", Repo: "project/new", Tag: "latest", Sha: "sha256:new"},
+	}
+
+	replacements := repinImages(lastPinned, pinned, log)
+	if len(replacements) != 2 {
+		t.Errorf("Expected 2 images to be repinned; got %v", replacements)
+	}
+
+	changed, err := pinImagesInDest(dir, replacements, log)
+	if err != nil {
+		t.Fatalf("pinImagesInDest failed; %v", err)
+	}
+	if d := cmp.Diff([]string{"web/deployment.yaml", "worker/kustomization.yaml"}, changed); d != "" {
+		t.Errorf("Unexpected changed files; diff:\n%v", d)
+	}
+
+	expected := map[string]string{
+		"web/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: gcr.io/project/web:latest@sha256:new
+      - name: sidecar
+        image: gcr.io/project/sidecar:v1
+`,
+		"worker/kustomization.yaml": `resources:
+- deployment.yaml
+images:
+- name: gcr.io/project/worker
+  newName: gcr.io/project/worker:latest
+  digest: sha256:new
+`,
+		lastSyncFile: files[lastSyncFile],
+	}
+	for name, want := range expected {
+		actual, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Failed to read %v; %v", name, err)
+		}
+		if d := cmp.Diff(want, string(actual)); d != "" {
+			t.Errorf("Unexpected contents of %v; diff:\n%v", name, d)
+		}
+	}
+
+	status := updatePinnedStatus(lastPinned, pinned, map[util.DockerImageRef]string{})
+	expectedStatus := []v1alpha1.PinnedImage{
+		{Image: "gcr.io/project/web:latest", NewImage: "gcr.io/project/web:latest@sha256:new"},
+		{Image: "gcr.io/project/worker:latest", NewImage: "gcr.io/project/worker:latest@sha256:new"},
+		{Image: "gcr.io/project/unchanged:latest", NewImage: "gcr.io/project/unchanged:latest@sha256:same"},
+	}
+	if d := cmp.Diff(expectedStatus, status); d != "" {
+		t.Errorf("Unexpected status; diff:\n%v", d)
+	}
+}
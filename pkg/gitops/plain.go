@@ -0,0 +1,212 @@
+package gitops
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	kustomize2 "github.com/jlewi/hydros/pkg/kustomize"
+	imagefns "github.com/jlewi/hydros/pkg/kustomize/fns/images"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/kustomize/api/filters/fsslice"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// plainManifest is a file of plain YAML manifests that is hydrated without kustomize.
+type plainManifest struct {
+	// Path is the path of the file relative to the source root.
+	Path  string
+	Nodes []*yaml.RNode
+}
+
+// findPlainManifests finds the resources in the YAML files under root. It is used when the source is plain YAML
+// rather than kustomize packages. Kustomizations, function configs and hydros resources are skipped. If selector
+// isn't nil only resources whose labels match it are returned.
+// excludes is a list of directories relative to repoRoot to skip.
+func findPlainManifests(root string, repoRoot string, excludes []string, selector *meta.LabelSelector, log logr.Logger) ([]*plainManifest, error) {
+	results := []*plainManifest{}
+
+	var labelSelector labels.Selector
+	if selector != nil {
+		var err error
+		labelSelector, err = meta.LabelSelectorAsSelector(selector)
+		if err != nil {
+			return results, errors.Wrapf(err, "Failed to convert selector")
+		}
+	}
+
+	excludesSet := map[string]bool{}
+
+	for _, e := range excludes {
+		excludesSet[e] = true
+	}
+
+	err := filepath.Walk(root,
+		func(path string, info os.FileInfo, err error) error {
+			if info == nil {
+				// N.B. I think this happens if path is the empty string.
+				return fmt.Errorf("No info returned for path %v", info)
+			}
+			// skip directories
+			if info.IsDir() {
+				rPath, err := filepath.Rel(repoRoot, path)
+				if err != nil {
+					log.Error(err, "Could not compute relative path", "basePath", root, "path", path)
+				}
+
+				if _, ok := excludesSet[rPath]; ok || info.Name() == ".git" {
+					log.V(util.Debug).Info("Excluding directory", "dir", path)
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			// Skip non YAML files
+			ext := strings.ToLower(filepath.Ext(info.Name()))
+
+			if ext != ".yaml" && ext != ".yml" {
+				return nil
+			}
+
+			if info.Name() == kustomizationFile {
+				return nil
+			}
+
+			nodes, err := util.ReadYaml(path)
+			if err != nil {
+				// Not all YAML files are valid resources e.g. templates so keep going.
+				log.V(util.Debug).Info("Skipping file that couldn't be read as YAML", "path", path, "err", err)
+				return nil
+			}
+
+			rPath, err := filepath.Rel(root, path)
+			if err != nil {
+				return errors.Wrapf(err, "Could not compute relative path of %v", path)
+			}
+
+			m := &plainManifest{Path: rPath, Nodes: []*yaml.RNode{}}
+			for _, n := range nodes {
+				if kustomize2.IsFunction(n) || strings.HasPrefix(n.GetApiVersion(), v1alpha1.Group+"/") {
+					continue
+				}
+				if labelSelector != nil && !labelSelector.Matches(labels.Set(n.GetLabels())) {
+					continue
+				}
+				m.Nodes = append(m.Nodes, n)
+			}
+
+			if len(m.Nodes) == 0 {
+				log.V(util.Debug).Info("File has no resources to hydrate", "path", path)
+				return nil
+			}
+			results = append(results, m)
+			return nil
+		})
+
+	return results, err
+}
+
+// findImagesInManifests returns the images in the manifests that are eligible to be pinned.
+func (s *syncRun) findImagesInManifests(manifests []*plainManifest) (map[util.DockerImageRef][]imageAndFile, error) {
+	results := map[util.DockerImageRef][]imageAndFile{}
+	for _, m := range manifests {
+		for _, n := range m.Nodes {
+			err := visitImages(n, func(image string) (string, error) {
+				r, err := util.ParseImageURL(image)
+				if err != nil {
+					s.log.V(util.Debug).Info("Skipping image that couldn't be parsed", "image", image, "path", m.Path, "err", err)
+					return image, nil
+				}
+				if !s.matchesRegistries(r.Registry) {
+					return image, nil
+				}
+				if _, ok := results[*r]; !ok {
+					results[*r] = []imageAndFile{}
+				}
+				return image, nil
+			})
+			if err != nil {
+				return results, errors.Wrapf(err, "Failed to find images in %v", m.Path)
+			}
+		}
+	}
+	return results, nil
+}
+
+// pinImagesInManifests replaces the images in the manifests with the pinned images.
+func pinImagesInManifests(manifests []*plainManifest, pinnedImages map[util.DockerImageRef]util.DockerImageRef) error {
+	for _, m := range manifests {
+		for _, n := range m.Nodes {
+			err := visitImages(n, func(image string) (string, error) {
+				r, err := util.ParseImageURL(image)
+				if err != nil {
+					return image, nil
+				}
+				resolved, ok := pinnedImages[*r]
+				if !ok {
+					return image, nil
+				}
+				return resolved.ToURL(), nil
+			})
+			if err != nil {
+				return errors.Wrapf(err, "Failed to pin images in %v", m.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// visitImages calls fn on every image in the resource. The image is replaced with the value returned by fn.
+// The image fields are the same ones used by the ImagePrefix function.
+func visitImages(node *yaml.RNode, fn func(image string) (string, error)) error {
+	return node.PipeE(fsslice.Filter{
+		FsSlice: imagefns.DefaultFsSlice,
+		SetValue: func(rn *yaml.RNode) error {
+			if err := yaml.ErrorIfInvalid(rn, yaml.ScalarNode); err != nil {
+				return err
+			}
+			image := rn.YNode().Value
+			newImage, err := fn(image)
+			if err != nil {
+				return err
+			}
+			if newImage == image {
+				return nil
+			}
+			return rn.PipeE(yaml.FieldSetter{StringValue: newImage})
+		},
+	})
+}
+
+// writePlainManifests writes the manifests to the corresponding paths in dir.
+func writePlainManifests(dir string, manifests []*plainManifest) error {
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].Path < manifests[j].Path
+	})
+	for _, m := range manifests {
+		var b bytes.Buffer
+		w := kio.ByteWriter{Writer: &b}
+		if err := w.Write(m.Nodes); err != nil {
+			return errors.Wrapf(err, "Failed to serialize %v", m.Path)
+		}
+
+		p := filepath.Join(dir, m.Path)
+		if err := os.MkdirAll(filepath.Dir(p), util.FilePermUserGroup); err != nil {
+			return errors.Wrapf(err, "Failed to create directory: %v", filepath.Dir(p))
+		}
+		if err := os.WriteFile(p, b.Bytes(), 0o644); err != nil {
+			return errors.Wrapf(err, "Failed to write %v", p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package gitops
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/zapr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/util"
+	"go.uber.org/zap"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const plainDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    env: dev
+spec:
+  template:
+    spec:
+      containers:
+      - name: nginx
+        image: docker.io/library/nginx:latest
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  labels:
+    env: prod
+`
+
+const plainFunction = `apiVersion: v1alpha1
+kind: PodEnvs
+metadata:
+  name: pod-envs
+spec:
+  remove:
+    - DD_AGENT_HOST
+`
+
+func Test_PlainManifests(t *testing.T) {
+	repoRoot := t.TempDir()
+	root := filepath.Join(repoRoot, "manifests")
+	files := map[string]string{
+		"web/deployment.yaml":     plainDeployment,
+		"web/envs.yaml":           plainFunction,
+		"web/kustomization.yaml":  "resources:\n- deployment.yaml\n",
+		"templates/template.yaml": plainDeployment,
+	}
+	for name, contents := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), util.FilePermUserGroup); err != nil {
+			t.Fatalf("Failed to create directory; %v", err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatalf("Failed to write %v; %v", p, err)
+		}
+	}
+
+	log := zapr.NewLogger(zap.L())
+	selector := &meta.LabelSelector{MatchLabels: map[string]string{"env": "dev"}}
+	manifests, err := findPlainManifests(root, repoRoot, []string{"manifests/templates"}, selector, log)
+	if err != nil {
+		t.Fatalf("Failed to find manifests; %v", err)
+	}
+
+	if len(manifests) != 1 {
+		t.Fatalf("Expected 1 file; got %d", len(manifests))
+	}
+	if manifests[0].Path != "web/deployment.yaml" {
+		t.Errorf("Got path %v; want web/deployment.yaml", manifests[0].Path)
+	}
+	if len(manifests[0].Nodes) != 1 || manifests[0].Nodes[0].GetKind() != "Deployment" {
+		t.Fatalf("Expected only the Deployment to match the selector; got %v", manifests[0].Nodes)
+	}
+
+	s := &syncRun{
+		Syncer:   &Syncer{},
+		log:      log,
+		manifest: &v1alpha1.ManifestSync{},
+	}
+	images, err := s.findImagesInManifests(manifests)
+	if err != nil {
+		t.Fatalf("Failed to find images; %v", err)
+	}
+	source := util.DockerImageRef{Registry: "docker.io", Repo: "library/nginx", Tag: "latest"}
+	if _, ok := images[source]; !ok || len(images) != 1 {
+		t.Fatalf("Expected image %v; got %v", source, images)
+	}
+
+	resolved := source
+	resolved.Sha = "sha256:1234"
+	if err := pinImagesInManifests(manifests, map[util.DockerImageRef]util.DockerImageRef{source: resolved}); err != nil {
+		t.Fatalf("Failed to pin images; %v", err)
+	}
+
+	outDir := t.TempDir()
+	if err := writePlainManifests(outDir, manifests); err != nil {
+		t.Fatalf("Failed to write manifests; %v", err)
+	}
+
+	actual, err := os.ReadFile(filepath.Join(outDir, "web", "deployment.yaml"))
+	if err != nil {
+		t.Fatalf("Failed to read hydrated manifest; %v", err)
+	}
+
+	expected := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    env: dev
+spec:
+  template:
+    spec:
+      containers:
+      - name: nginx
+        image: docker.io/library/nginx:latest@sha256:1234
+`
+	if d := cmp.Diff(expected, string(actual)); d != "" {
+		t.Errorf("Unexpected hydrated manifest; diff:\n%v", d)
+	}
+}
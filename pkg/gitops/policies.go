@@ -0,0 +1,46 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/kustomize/fns/validation"
+	"github.com/jlewi/hydros/pkg/policy"
+)
+
+// checkPolicies returns the violations of the policies by the manifests below dir. The policies are in the
+// directory of the check relative to sourceRoot. It returns no violations if check is nil.
+func checkPolicies(ctx context.Context, sourceRoot string, dir string, check *v1alpha1.PolicyCheck) ([]validation.Violation, error) {
+	if check == nil {
+		return nil, nil
+	}
+	checker, err := policy.NewChecker(ctx, filepath.Join(sourceRoot, check.Path))
+	if err != nil {
+		return nil, err
+	}
+	return checker.CheckDir(ctx, dir)
+}
+
+// buildPolicyViolations returns a markdown table of the violations to include in the PR description. fails is
+// whether the violations block the PR.
+func buildPolicyViolations(violations []validation.Violation, fails bool) string {
+	if len(violations) == 0 {
+		return ""
+	}
+	header := fmt.Sprintf("Warning: the hydrated manifests violate %d policies:", len(violations))
+	if fails {
+		header = fmt.Sprintf("The hydrated manifests violate %d policies; the PR won't be merged until they are fixed:", len(violations))
+	}
+	lines := []string{
+		header,
+		"| Resource | File | Message |",
+		"| --- | --- | --- |",
+	}
+	for _, v := range violations {
+		lines = append(lines, fmt.Sprintf("| %v | %v | %v |", v.Resource, v.Path, strings.ReplaceAll(v.Message, "|", "\\|")))
+	}
+	return strings.Join(lines, "\n")
+}
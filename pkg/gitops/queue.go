@@ -0,0 +1,132 @@
+package gitops
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/go-logr/zapr"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Queue is the queue of reconcile events processed by the Manager's workers. Implementations have the semantics
+// of workqueue.DelayingInterface; an item that is already queued isn't queued again and an item isn't handed to a
+// worker while another worker is processing it. If it is added while it is being processed it is processed again
+// once it is done.
+type Queue interface {
+	// Add adds the item to the queue.
+	Add(item Item)
+	// AddAfter adds the item to the queue once duration has passed.
+	AddAfter(item Item, duration time.Duration)
+	// Get blocks until there is an item to process. It returns true if the queue has been shut down.
+	Get() (Item, bool)
+	// Done marks the item as processed.
+	Done(item Item)
+	// ShutDown causes Get to return once the queue is empty.
+	ShutDown()
+}
+
+// filteredQueue is implemented by queues shared by replicas which may not have the same reconcilers. The Manager
+// sets the filter so the queue only hands its workers items for reconcilers it has; other items are left in the
+// queue for the replicas that have them.
+type filteredQueue interface {
+	setFilter(accept func(name string) bool)
+}
+
+// memoryQueue is a Queue that is kept in memory. It is the default.
+type memoryQueue struct {
+	q workqueue.DelayingInterface
+}
+
+var _ Queue = &memoryQueue{}
+
+// NewMemoryQueue creates a Queue kept in memory. Items are lost when the process exits.
+func NewMemoryQueue() Queue {
+	return &memoryQueue{q: workqueue.NewDelayingQueue()}
+}
+
+func (m *memoryQueue) Add(item Item) {
+	m.q.Add(item)
+}
+
+func (m *memoryQueue) AddAfter(item Item, duration time.Duration) {
+	m.q.AddAfter(item, duration)
+}
+
+func (m *memoryQueue) Get() (Item, bool) {
+	for {
+		item, shutdown := m.q.Get()
+		if shutdown {
+			return Item{}, true
+		}
+		if i, ok := item.(Item); ok {
+			return i, false
+		}
+		// This is unexpected mark it as done and keep going
+		log := zapr.NewLogger(zap.L())
+		log.Info("Got work queue item which is not an Item", "item", item)
+		m.q.Done(item)
+	}
+}
+
+func (m *memoryQueue) Done(item Item) {
+	m.q.Done(item)
+}
+
+func (m *memoryQueue) ShutDown() {
+	m.q.ShutDown()
+}
+
+// eventTypes are the types of events that can be stored in queues that serialize items e.g. GCSQueue.
+var eventTypes = map[string]reflect.Type{
+	"SyncEvent":        reflect.TypeOf(SyncEvent{}),
+	"ImagePushedEvent": reflect.TypeOf(ImagePushedEvent{}),
+	"RenderEvent":      reflect.TypeOf(RenderEvent{}),
+	"MergeQueueEvent":  reflect.TypeOf(MergeQueueEvent{}),
+}
+
+// encodedItem is the serialized form of an Item.
+type encodedItem struct {
+	Name      string          `json:"name"`
+	EventType string          `json:"eventType,omitempty"`
+	Event     json.RawMessage `json:"event,omitempty"`
+}
+
+// encodeItem serializes the item. The event must be nil or one of eventTypes.
+func encodeItem(item Item) (*encodedItem, error) {
+	e := &encodedItem{Name: item.Name}
+	if item.Event == nil {
+		return e, nil
+	}
+	t := reflect.TypeOf(item.Event)
+	if eventTypes[t.Name()] != t {
+		return nil, errors.Errorf("Events of type %v can't be queued", t)
+	}
+	b, err := json.Marshal(item.Event)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to serialize event for %v", item.Name)
+	}
+	e.EventType = t.Name()
+	e.Event = b
+	return e, nil
+}
+
+// decode returns the Item that was serialized.
+func (e *encodedItem) decode() (Item, error) {
+	item := Item{Name: e.Name}
+	if e.EventType == "" {
+		return item, nil
+	}
+	t, ok := eventTypes[e.EventType]
+	if !ok {
+		return item, errors.Errorf("Unknown event type %v", e.EventType)
+	}
+	event := reflect.New(t)
+	if err := json.Unmarshal(e.Event, event.Interface()); err != nil {
+		return item, errors.Wrapf(err, "Failed to decode %v for %v", e.EventType, e.Name)
+	}
+	item.Event = event.Elem().Interface()
+	return item, nil
+}
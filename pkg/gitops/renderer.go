@@ -15,6 +15,7 @@ import (
 	"github.com/go-logr/zapr"
 	ghAPI "github.com/google/go-github/v52/github"
 	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/config"
 	"github.com/jlewi/hydros/pkg/github"
 	"github.com/jlewi/hydros/pkg/github/ghrepo"
 	hkustomize "github.com/jlewi/hydros/pkg/kustomize"
@@ -33,7 +34,8 @@ const (
 // It is intended to run a bunch of KRM functions in place and then check the modifications back into the repository.
 //
 // There is currently one renderer per repository. A single renderer can handle multiple branches but not
-// concurrently.
+// concurrently. Each PR branch is prepared in its own worktree of a single clone of the repository so branches
+// don't share a working tree and the repository only needs to be cloned once.
 //
 // TODO(jeremy): I don't think the semantics for specifying the KRM functions to apply is quite right.
 // Right now we apply all KRM functions found at sourcePath. These functions get applied to all YAML below the
@@ -47,9 +49,24 @@ type Renderer struct {
 	transports *github.TransportManager
 
 	client *ghAPI.Client
+
+	// functions are the external KRM functions the operator allows.
+	functions *config.FunctionsConfig
+}
+
+// RendererOption is an option for the Renderer.
+type RendererOption func(r *Renderer) error
+
+// RenderWithFunctions creates an option to allow the external KRM functions in cfg to run. Functions are only run
+// if they are also selected by the externalFunctions of the InPlaceConfig.
+func RenderWithFunctions(cfg *config.FunctionsConfig) RendererOption {
+	return func(r *Renderer) error {
+		r.functions = cfg
+		return nil
+	}
 }
 
-func NewRenderer(org string, name string, workDir string, transports *github.TransportManager) (*Renderer, error) {
+func NewRenderer(org string, name string, workDir string, transports *github.TransportManager, opts ...RendererOption) (*Renderer, error) {
 	ghTr, err := transports.Get(org, name)
 	if err != nil {
 		return nil, err
@@ -65,6 +82,11 @@ func NewRenderer(org string, name string, workDir string, transports *github.Tra
 		client:     client,
 	}
 
+	for _, o := range opts {
+		if err := o(r); err != nil {
+			return nil, err
+		}
+	}
 	return r, nil
 }
 func (r *Renderer) init() error {
@@ -159,7 +181,8 @@ func (r *Renderer) Run(anyEvent any) error {
 	args := &github.RepoHelperArgs{
 		BaseRepo:   ghrepo.New(r.org, r.repo),
 		GhTr:       tr,
-		FullDir:    r.cloneDir(),
+		FullDir:    r.worktreeDir(event.BranchConfig.PRBranch),
+		CloneDir:   r.cloneDir(),
 		Name:       "hydros",
 		Email:      "hydros@yourdomain.com",
 		Remote:     "origin",
@@ -172,6 +195,7 @@ func (r *Renderer) Run(anyEvent any) error {
 		return err
 	}
 
+	report := &hkustomize.Report{}
 	runErr := func() error {
 		if _, err := os.Stat(r.workDir); os.IsNotExist(err) {
 			log.V(util.Debug).Info("Creating work directory.", "directory", r.workDir)
@@ -208,6 +232,7 @@ func (r *Renderer) Run(anyEvent any) error {
 			}
 			log.Info("PR Already Exists; attempting to merge it.", "pr", existingPR.URL)
 			state, err := repoHelper.MergeAndWait(existingPR.Number, 3*time.Minute)
+			r.setLifecycleLabel(repoHelper, existingPR.Number, state)
 			if err != nil {
 				log.Error(err, "Failed to Merge existing PR unable to continue with sync", "number", existingPR.Number, "pr", existingPR.URL)
 				return err
@@ -236,7 +261,7 @@ func (r *Renderer) Run(anyEvent any) error {
 			}
 		}
 
-		syncNeeded, err := r.syncNeeded()
+		syncNeeded, err := r.syncNeeded(repoHelper.Dir())
 		if err != nil {
 			return err
 		}
@@ -251,7 +276,7 @@ func (r *Renderer) Run(anyEvent any) error {
 			paths = []string{""}
 		}
 		for _, path := range paths {
-			if err := r.applyKRMFns(path); err != nil {
+			if err := r.applyKRMFns(repoHelper.Dir(), path, event.BranchConfig.FunctionKinds, event.BranchConfig.ExternalFunctions, report); err != nil {
 				return err
 			}
 		}
@@ -264,7 +289,7 @@ func (r *Renderer) Run(anyEvent any) error {
 		if !hasChanges {
 			// We should update he checkRun message to report this.
 			log.Info("No changes to sync")
-			return nil
+			return report.ValidationErr()
 		}
 
 		message := "Hydros AI generating configurations"
@@ -275,11 +300,21 @@ func (r *Renderer) Run(anyEvent any) error {
 		if err := repoHelper.CommitAndPush(message, true); err != nil {
 			return err
 		}
-		pr, err := repoHelper.CreatePr(message, []string{})
+		prMessage := message
+		if table := report.Markdown(); table != "" {
+			prMessage += "\n\n" + table
+		}
+		pr, err := repoHelper.CreatePr(prMessage, []string{})
 		if err != nil {
 			return err
 		}
 
+		// N.B. The PR is still created so the violations can be reviewed on it but it isn't merged.
+		if err := report.ValidationErr(); err != nil {
+			log.Error(err, "Rendered configurations failed validation; the PR won't be merged", "pr", pr.URL, "number", pr.Number)
+			return err
+		}
+
 		if !event.BranchConfig.AutoMerge {
 			return nil
 		}
@@ -289,6 +324,7 @@ func (r *Renderer) Run(anyEvent any) error {
 		// The syncer can return and the PR will be merged either 1) when syncer is rerun or 2) by auto merge if enabled
 		// The desired behavior is potentially different in the takeover and non takeover setting.
 		state, err := repoHelper.MergeAndWait(pr.Number, 1*time.Minute)
+		r.setLifecycleLabel(repoHelper, pr.Number, state)
 		if err != nil {
 			log.Error(err, "Failed to merge pr", "number", pr.Number, "url", pr.URL)
 			return err
@@ -314,6 +350,9 @@ func (r *Renderer) Run(anyEvent any) error {
 		conclusion = "failure"
 		text = fmt.Sprintf("Failed to run Hydros AI; error %v", runErr)
 	}
+	if table := report.Markdown(); table != "" {
+		text += "\n\n" + table
+	}
 
 	uCheck, _, err := r.client.Checks.UpdateCheckRun(context.Background(), r.org, r.repo, *check.ID, ghAPI.UpdateCheckRunOptions{
 		Name:       RendererCheckName,
@@ -334,19 +373,42 @@ func (r *Renderer) Run(anyEvent any) error {
 	return runErr
 }
 
+// setLifecycleLabel sets the lifecycle label of the PR to reflect its state. Failing to set the label is logged
+// but doesn't fail the render.
+func (r *Renderer) setLifecycleLabel(repoHelper *github.RepoHelper, number int, state github.PRMergeState) {
+	label := github.LifecycleLabelForState(state)
+	if err := repoHelper.SetLifecycleLabel(number, label); err != nil {
+		log := zapr.NewLogger(zap.L()).WithValues("renderer", r.Name())
+		log.Error(err, "Failed to set PR lifecycle label", "number", number, "label", label)
+	}
+}
+
 func (r *Renderer) cloneDir() string {
 	return filepath.Join(r.workDir, "source")
 }
 
-// applyKRMFns applies the KRM functions to the source repo.
-func (r *Renderer) applyKRMFns(sourcePath string) error {
+// worktreeDir returns the directory of the worktree used to prepare the PR branch.
+func (r *Renderer) worktreeDir(branch string) string {
+	return filepath.Join(r.workDir, "worktrees", strings.ReplaceAll(branch, "/", "_"))
+}
+
+// applyKRMFns applies the KRM functions to the checkout of the source repo in repoDir.
+// kinds restricts the kinds of functions that are applied and external selects the external functions to run from
+// those allowed by the hydros config.
+// The results of applying the functions are added to report.
+func (r *Renderer) applyKRMFns(repoDir string, sourcePath string, kinds *v1alpha1.FunctionKinds, external *v1alpha1.ExternalFunctions, report *hkustomize.Report) error {
 	log := zapr.NewLogger(zap.L())
 
 	d := hkustomize.Dispatcher{
-		Log: log,
+		Log:      log,
+		Timeout:  hkustomize.DefaultFunctionTimeout,
+		Kinds:    kinds,
+		External: external,
+		Allowed:  r.functions,
+		Report:   report,
 	}
 
-	sourceDir := filepath.Join(r.cloneDir(), sourcePath)
+	sourceDir := filepath.Join(repoDir, sourcePath)
 	// get all functions based on the source directory
 	funcs, err := d.GetAllFuncs([]string{sourceDir})
 	if err != nil {
@@ -377,13 +439,15 @@ func (r *Renderer) applyKRMFns(sourcePath string) error {
 }
 
 // syncNeeded checks if a sync is needed. Since we are checking changes into the source repository we need to
-// avoid recursively triggering a sync; i.e. if the last change was made by hydros AI don't run
-func (r *Renderer) syncNeeded() (bool, error) {
+// avoid recursively triggering a sync; i.e. if the last change was made by hydros AI don't run.
+// repoDir is the checkout of the branch.
+func (r *Renderer) syncNeeded(repoDir string) (bool, error) {
 	log := zapr.NewLogger(zap.L())
 	// Open the repository
-	gitRepo, err := git.PlainOpenWithOptions(r.cloneDir(), &git.PlainOpenOptions{})
+	// N.B. EnableDotGitCommonDir is needed because repoDir is a worktree.
+	gitRepo, err := git.PlainOpenWithOptions(repoDir, &git.PlainOpenOptions{EnableDotGitCommonDir: true})
 	if err != nil {
-		return false, errors.Wrapf(err, "Could not open respoistory at %v; ensure the directory contains a git repo", r.cloneDir())
+		return false, errors.Wrapf(err, "Could not open respoistory at %v; ensure the directory contains a git repo", repoDir)
 	}
 
 	// Get the current commit
@@ -1,10 +1,11 @@
 package gitops
 
 import (
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/jlewi/hydros/pkg/controllers"
@@ -14,8 +15,10 @@ import (
 	"github.com/bmatcuk/doublestar/v4"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-logr/logr"
+	ghAPI "github.com/google/go-github/v52/github"
 	"github.com/jlewi/hydros/api/v1alpha1"
 	"github.com/jlewi/hydros/pkg/github"
+	"github.com/jlewi/hydros/pkg/github/ghrepo"
 	"github.com/jlewi/hydros/pkg/images"
 	"github.com/jlewi/hydros/pkg/util"
 	"github.com/pkg/errors"
@@ -35,13 +38,19 @@ type RepoController struct {
 	config          *v1alpha1.RepoConfig
 	cloner          *github.ReposCloner
 	imageController *images.Controller
+	imageOptions    []images.ControllerOption
+	syncerOptions   []SyncerOption
 	gitRepo         *git.Repository
 	manager         *github.TransportManager
 	registry        *controllers.Registry
 	selectors       []labels.Selector
+	// restrict is an additional selector resources must match e.g. to reconcile a single team's resources.
+	restrict labels.Selector
 }
 
-func NewRepoController(appConfig config.Config, registry *controllers.Registry, config *v1alpha1.RepoConfig) (*RepoController, error) {
+// NewRepoController creates a controller for the repository in config. syncerOptions are used to create the Syncers
+// of the ManifestSyncs found in the repository.
+func NewRepoController(appConfig config.Config, registry *controllers.Registry, config *v1alpha1.RepoConfig, syncerOptions ...SyncerOption) (*RepoController, error) {
 	if config == nil {
 		return nil, errors.New("config must be non nil")
 	}
@@ -59,7 +68,8 @@ func NewRepoController(appConfig config.Config, registry *controllers.Registry,
 		return nil, err
 	}
 
-	imageController, err := images.NewController()
+	imageOptions := []images.ControllerOption{images.WithRegistryMirrors(appConfig.RegistryMirrors)}
+	imageController, err := images.NewController(imageOptions...)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to create image controller")
 	}
@@ -88,12 +98,20 @@ func NewRepoController(appConfig config.Config, registry *controllers.Registry,
 		config:          config,
 		cloner:          cloner,
 		imageController: imageController,
+		imageOptions:    imageOptions,
+		syncerOptions:   append([]SyncerOption{SyncWithFunctions(appConfig.Functions)}, syncerOptions...),
 		manager:         manager,
 		selectors:       selectors,
 		registry:        registry,
 	}, nil
 }
 
+// Restrict restricts the resources that are reconciled to those that match both one of the selectors of the
+// RepoConfig and selector.
+func (c *RepoController) Restrict(selector labels.Selector) {
+	c.restrict = selector
+}
+
 func (c *RepoController) Reconcile(ctx context.Context) error {
 	log := util.LogFromContext(ctx)
 	log = log.WithValues("repoConfig", c.config.Metadata.Name)
@@ -127,20 +145,35 @@ func (c *RepoController) Reconcile(ctx context.Context) error {
 		return err
 	}
 
-	// Apply all the resources in parallel
-	// https://github.com/jlewi/hydros/issues/60 is tracking properly ordering dependencies.
-	var wg sync.WaitGroup
+	// Apply the resources in parallel except that resources wait for the resources they depend on.
+	graph := newDependencyGraph(resources)
+	failed := graph.apply(ctx, c.applyResource)
+
+	commit := ""
+	if headRef, err := c.gitRepo.Head(); err != nil {
+		log.Error(err, "Error getting head ref")
+	} else {
+		commit = headRef.Hash().String()
+	}
+	now := time.Now()
+	results := make([]ResourceStatus, 0, len(resources))
 	for _, r := range resources {
-		wg.Add(1)
-		go func(rNode *resource) {
-			if err := c.applyResource(ctx, rNode); err != nil {
-				log.Error(err, "Error applying resource", "path", rNode.path, "name", rNode.node.GetName())
-			}
-			wg.Done()
-		}(r)
+		result := ResourceStatus{
+			Kind:              r.node.GetKind(),
+			Name:              r.node.GetName(),
+			Path:              r.rPath,
+			Commit:            commit,
+			LastReconcileTime: now,
+		}
+		err, ok := failed[r]
+		if ok {
+			log.Error(err, "Error applying resource", "path", r.path, "kind", r.node.GetKind(), "name", r.node.GetName())
+			result.LastError = err.Error()
+		}
+		result.Conditions = resourceConditions(ctx, r.node, err)
+		results = append(results, result)
 	}
-
-	wg.Wait()
+	c.reportStatus(ctx, results)
 	return nil
 }
 
@@ -231,6 +264,10 @@ func (c *RepoController) findResources(ctx context.Context) ([]*resource, error)
 				log.V(util.Debug).Info("Skipping resource because it doesn't match any selectors", "kind", s.Kind, "name", node.GetName(), "path", fullpath, "labels", labelsMap)
 				continue
 			}
+			if c.restrict != nil && !c.restrict.Matches(labelsMap) {
+				log.V(util.Debug).Info("Skipping resource because it doesn't match the restricting selector", "kind", s.Kind, "name", node.GetName(), "path", fullpath, "labels", labelsMap, "selector", c.restrict.String())
+				continue
+			}
 
 			// Ensure the resource has a name that is unique at least within the file.
 			if seen[node.GetName()] {
@@ -262,6 +299,9 @@ func (c *RepoController) applyResource(ctx context.Context, r *resource) error {
 	case v1alpha1.ManifestSyncGVK.Kind:
 		// TODO(jeremy): We should move this into the registry?
 		return c.applyManifest(ctx, r)
+	case v1alpha1.BaseImageWatchGVK.Kind:
+		// BaseImageWatch needs the checkout of the repository to read the Dockerfiles.
+		return c.applyBaseImageWatch(ctx, r)
 	default:
 		return c.registry.ReconcileNode(ctx, r.node)
 	}
@@ -282,7 +322,42 @@ func (c *RepoController) applyImage(ctx context.Context, r *resource) error {
 
 	image.Status.SourceCommit += headRef.Hash().String()
 
-	return c.imageController.Reconcile(ctx, image)
+	err = c.imageController.Reconcile(ctx, image)
+	recordConditions(ctx, r, image.Status.Conditions)
+	// N.B. The test status is only set when the reconcile built the image and ran the tests.
+	if image.Status.TestStatus != "" {
+		c.reportTestStatus(ctx, image, headRef.Hash().String(), err)
+	}
+	return err
+}
+
+// reportTestStatus reports the result of the tests run when building the image as a check run on the commit.
+// Failing to report the status doesn't fail the reconcile so errors are only logged.
+func (c *RepoController) reportTestStatus(ctx context.Context, image *v1alpha1.Image, commit string, buildErr error) {
+	log := util.LogFromContext(ctx).WithValues("commit", commit)
+	u, err := url.Parse(c.config.Spec.Repo)
+	if err != nil {
+		log.Error(err, "Failed to parse repo URL; unable to report test status", "repo", c.config.Spec.Repo)
+		return
+	}
+	repo, err := ghrepo.FromURL(u)
+	if err != nil {
+		log.Error(err, "Failed to get the repository from the URL; unable to report test status", "repo", c.config.Spec.Repo)
+		return
+	}
+	tr, err := c.manager.Get(repo.RepoOwner(), repo.RepoName())
+	if err != nil {
+		log.Error(err, "Failed to get transport; unable to report test status", "org", repo.RepoOwner(), "repo", repo.RepoName())
+		return
+	}
+	client := ghAPI.NewClient(&http.Client{Transport: tr})
+	opts := buildTestCheckRun(image, commit, buildErr)
+	check, _, err := client.Checks.CreateCheckRun(ctx, repo.RepoOwner(), repo.RepoName(), opts)
+	if err != nil {
+		log.Error(err, "Failed to create check run reporting the test status")
+		return
+	}
+	log.Info("Reported test status", "check", check.GetHTMLURL(), "conclusion", check.GetConclusion())
 }
 
 func (c *RepoController) applyManifest(ctx context.Context, r *resource) error {
@@ -307,7 +382,7 @@ func (c *RepoController) applyManifest(ctx context.Context, r *resource) error {
 			return errors.Wrapf(err, "Error parsing pause duration %v", pause)
 		}
 
-		if err := SetTakeOverAnnotations(manifest, pauseDur); err != nil {
+		if err := SetTakeOverAnnotations(manifest, pauseDur, v1alpha1.RepoGVK.Kind+"/"+c.config.Metadata.Name); err != nil {
 			return errors.Wrapf(err, "Failed to set takeover annotations")
 		}
 		log.Info("Pausing automatic syncs; doing a takeover")
@@ -319,13 +394,23 @@ func (c *RepoController) applyManifest(ctx context.Context, r *resource) error {
 	dirname := strings.Replace(r.rPath, "/", "_", -1) + "_" + r.node.GetName()
 	workDir := filepath.Join(c.workDir, dirname)
 
-	syncer, err := NewSyncer(manifest, c.manager, SyncWithWorkDir(workDir), SyncWithLogger(log))
+	opts := append([]SyncerOption{SyncWithWorkDir(workDir), SyncWithLogger(log), SyncWithImageOptions(c.imageOptions...)}, c.syncerOptions...)
+	syncer, err := NewSyncer(manifest, c.manager, opts...)
 	if err != nil {
 		log.Error(err, "Failed to create syncer")
 		return err
 	}
 
-	return syncer.RunOnce(false)
+	err = syncer.RunOnce(false)
+	recordConditions(ctx, r, syncer.Conditions())
+	return err
+}
+
+// recordConditions records the conditions of the resource in its node so they are included in its status.
+func recordConditions(ctx context.Context, r *resource, conditions []v1alpha1.Condition) {
+	if err := controllers.SetConditions(r.node, conditions); err != nil {
+		util.LogFromContext(ctx).Error(err, "Failed to record the conditions of the resource", "kind", r.node.GetKind())
+	}
 }
 
 type resource struct {
@@ -0,0 +1,344 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	ghAPI "github.com/google/go-github/v52/github"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/controllers"
+	"github.com/jlewi/hydros/pkg/github/ghrepo"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/jlewi/monogo/gcp/gcs"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// RepoStatusKind is the kind of the document recording the status of the resources of a RepoConfig.
+	RepoStatusKind = "RepoStatus"
+
+	// commitStatusPrefix is the prefix of the context of the commit statuses reporting the result of reconciling
+	// each resource.
+	commitStatusPrefix = "hydros"
+	// maxCommitStatusDescription is the longest description GitHub accepts for a commit status.
+	maxCommitStatusDescription = 140
+
+	// resourcesFailedReason is the reason of the Ready condition of a RepoConfig when resources failed.
+	resourcesFailedReason = "ResourcesFailed"
+)
+
+// RepoStatus records the result of the latest reconcile of each resource of a RepoConfig.
+type RepoStatus struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	// RepoConfig is the name of the RepoConfig.
+	RepoConfig string `yaml:"repoConfig"`
+	// Repo is the repository the resources were read from.
+	Repo string `yaml:"repo"`
+	// UpdateTime is when the status was last written.
+	UpdateTime time.Time `yaml:"updateTime"`
+	// Conditions are the conditions of the RepoConfig. Ready is false if any of the resources failed.
+	Conditions []v1alpha1.Condition `yaml:"conditions,omitempty"`
+	// Resources is the status of each resource sorted by kind and name.
+	Resources []ResourceStatus `yaml:"resources,omitempty"`
+}
+
+// ResourceStatus is the result of the latest reconcile of a resource.
+type ResourceStatus struct {
+	Kind string `yaml:"kind"`
+	Name string `yaml:"name"`
+	// Path is the path of the file containing the resource relative to the root of the repository.
+	Path string `yaml:"path"`
+	// Commit is the commit of the repository that was last reconciled i.e. the version of the resource.
+	Commit string `yaml:"commit,omitempty"`
+	// LastReconcileTime is when the resource was last reconciled.
+	LastReconcileTime time.Time `yaml:"lastReconcileTime"`
+	// LastSuccessTime is when the resource was last reconciled successfully.
+	LastSuccessTime *time.Time `yaml:"lastSuccessTime,omitempty"`
+	// LastError is the error of the latest reconcile. It is empty if the latest reconcile succeeded.
+	LastError string `yaml:"lastError,omitempty"`
+	// Conditions are the conditions of the resource reported by its controller.
+	Conditions []v1alpha1.Condition `yaml:"conditions,omitempty"`
+}
+
+// key returns the kind/name of the resource.
+func (s ResourceStatus) key() string {
+	return v1alpha1.ResourceRef{Kind: s.Kind, Name: s.Name}.String()
+}
+
+// ReadRepoStatus reads the status written to uri. It returns an empty status if uri doesn't exist.
+func ReadRepoStatus(ctx context.Context, uri string) (*RepoStatus, error) {
+	b, err := readStatusObject(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	status := &RepoStatus{}
+	if len(b) == 0 {
+		return status, nil
+	}
+	if err := yaml.Unmarshal(b, status); err != nil {
+		return nil, errors.Wrapf(err, "Failed to decode status %v", uri)
+	}
+	return status, nil
+}
+
+// WriteRepoStatus writes the status to uri.
+func WriteRepoStatus(ctx context.Context, uri string, status *RepoStatus) error {
+	b, err := yaml.Marshal(status)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to serialize status")
+	}
+	return writeStatusObject(ctx, uri, b)
+}
+
+// updateRepoStatus merges the results of a reconcile into the previous status. Resources that weren't
+// reconciled e.g. because they were deleted or no longer match the selectors are dropped.
+func updateRepoStatus(previous *RepoStatus, results []ResourceStatus, now time.Time) *RepoStatus {
+	last := map[string]ResourceStatus{}
+	for _, r := range previous.Resources {
+		last[r.key()] = r
+	}
+	status := &RepoStatus{
+		APIVersion: v1alpha1.Group + "/" + v1alpha1.Version,
+		Kind:       RepoStatusKind,
+		RepoConfig: previous.RepoConfig,
+		Repo:       previous.Repo,
+		UpdateTime: now,
+		Resources:  make([]ResourceStatus, 0, len(results)),
+	}
+	for _, r := range results {
+		if r.LastError == "" {
+			t := r.LastReconcileTime
+			r.LastSuccessTime = &t
+		} else if l, ok := last[r.key()]; ok {
+			r.LastSuccessTime = l.LastSuccessTime
+		}
+		r.Conditions = v1alpha1.MergeConditions(last[r.key()].Conditions, r.Conditions)
+		status.Resources = append(status.Resources, r)
+	}
+	sort.Slice(status.Resources, func(i, j int) bool {
+		return status.Resources[i].key() < status.Resources[j].key()
+	})
+	status.Conditions = v1alpha1.MergeConditions(previous.Conditions, repoConditions(results, now))
+	return status
+}
+
+// resourceConditions returns the conditions of the resource after it was applied. err is the error, if any, of
+// applying it. The conditions are those the controller recorded in the status of the resource; Ready is derived
+// from err if the controller didn't report it e.g. because the resource couldn't be decoded.
+func resourceConditions(ctx context.Context, n *kyaml.RNode, err error) []v1alpha1.Condition {
+	conditions, cErr := controllers.GetConditions(n)
+	if cErr != nil {
+		util.LogFromContext(ctx).Error(cErr, "Failed to read the conditions of the resource", "kind", n.GetKind(), "name", n.GetName())
+	}
+	ready := v1alpha1.GetCondition(conditions, v1alpha1.ReadyCondition)
+	switch {
+	case err != nil && (ready == nil || ready.Status == v1alpha1.ConditionTrue):
+		conditions = v1alpha1.SetCondition(conditions, v1alpha1.NewCondition(v1alpha1.ReadyCondition, v1alpha1.ConditionFalse, v1alpha1.ReconcileFailedReason, err.Error()))
+	case err == nil && ready == nil:
+		conditions = v1alpha1.SetCondition(conditions, v1alpha1.NewCondition(v1alpha1.ReadyCondition, v1alpha1.ConditionTrue, v1alpha1.ReconcileSucceededReason, ""))
+	}
+	return conditions
+}
+
+// repoConditions returns the conditions of the RepoConfig given the results of reconciling its resources at now.
+func repoConditions(results []ResourceStatus, now time.Time) []v1alpha1.Condition {
+	failed := 0
+	commit := ""
+	for _, r := range results {
+		if r.LastError != "" {
+			failed++
+		}
+		if r.Commit != "" {
+			commit = r.Commit
+		}
+	}
+	ready := v1alpha1.Condition{
+		Type:               v1alpha1.ReadyCondition,
+		Status:             v1alpha1.ConditionTrue,
+		Reason:             v1alpha1.ReconcileSucceededReason,
+		Message:            fmt.Sprintf("%d resources reconciled", len(results)),
+		LastTransitionTime: now,
+	}
+	if failed > 0 {
+		ready.Status = v1alpha1.ConditionFalse
+		ready.Reason = resourcesFailedReason
+		ready.Message = fmt.Sprintf("%d of %d resources failed", failed, len(results))
+	}
+	synced := v1alpha1.Condition{
+		Type:               v1alpha1.SyncedCondition,
+		Status:             v1alpha1.ConditionUnknown,
+		Reason:             "CommitUnknown",
+		LastTransitionTime: now,
+	}
+	if commit != "" {
+		synced.Status = v1alpha1.ConditionTrue
+		synced.Reason = "Reconciled"
+		synced.Message = fmt.Sprintf("Reconciled commit %v", commit)
+	}
+	return []v1alpha1.Condition{ready, synced}
+}
+
+// reportStatus reports the results of reconciling the resources as configured by the RepoConfig. Failing to report
+// the status doesn't fail the reconcile so errors are only logged.
+func (c *RepoController) reportStatus(ctx context.Context, results []ResourceStatus) {
+	cfg := c.config.Spec.Status
+	if cfg == nil {
+		return
+	}
+	log := util.LogFromContext(ctx)
+	if cfg.URI != "" {
+		if err := c.writeStatus(ctx, cfg.URI, results); err != nil {
+			log.Error(err, "Failed to write the status of the resources", "uri", cfg.URI)
+		}
+	}
+	if cfg.CommitStatus {
+		if err := c.createCommitStatuses(ctx, results); err != nil {
+			log.Error(err, "Failed to report the status of the resources as commit statuses")
+		}
+	}
+}
+
+func (c *RepoController) writeStatus(ctx context.Context, uri string, results []ResourceStatus) error {
+	previous, err := ReadRepoStatus(ctx, uri)
+	if err != nil {
+		return err
+	}
+	previous.RepoConfig = c.config.Metadata.Name
+	previous.Repo = c.config.Spec.Repo
+	return WriteRepoStatus(ctx, uri, updateRepoStatus(previous, results, time.Now()))
+}
+
+// createCommitStatuses reports the result of each resource as a commit status on the commit that was reconciled.
+func (c *RepoController) createCommitStatuses(ctx context.Context, results []ResourceStatus) error {
+	client, repo, err := c.repoClient()
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Commit == "" {
+			continue
+		}
+		status := buildCommitStatus(r)
+		if _, _, err := client.Repositories.CreateStatus(ctx, repo.RepoOwner(), repo.RepoName(), r.Commit, status); err != nil {
+			return errors.Wrapf(err, "Failed to create commit status %v", status.GetContext())
+		}
+	}
+	return nil
+}
+
+// repoClient returns a GitHub client for the repository of the RepoConfig.
+func (c *RepoController) repoClient() (*ghAPI.Client, ghrepo.Interface, error) {
+	u, err := url.Parse(c.config.Spec.Repo)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Could not parse URI %v", c.config.Spec.Repo)
+	}
+	repo, err := ghrepo.FromURL(u)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Could not parse URI %v", c.config.Spec.Repo)
+	}
+	tr, err := c.manager.Get(repo.RepoOwner(), repo.RepoName())
+	if err != nil {
+		return nil, nil, err
+	}
+	return ghAPI.NewClient(&http.Client{Transport: tr}), repo, nil
+}
+
+// buildCommitStatus returns the commit status reporting the result of reconciling the resource.
+func buildCommitStatus(r ResourceStatus) *ghAPI.RepoStatus {
+	state := "success"
+	description := fmt.Sprintf("%v reconciled successfully", r.key())
+	if r.LastError != "" {
+		state = "failure"
+		description = r.LastError
+	}
+	if len(description) > maxCommitStatusDescription {
+		description = description[:maxCommitStatusDescription-3] + "..."
+	}
+	return &ghAPI.RepoStatus{
+		State:       ghAPI.String(state),
+		Context:     ghAPI.String(commitStatusPrefix + "/" + r.key()),
+		Description: ghAPI.String(description),
+	}
+}
+
+// readStatusObject returns the contents of the local file or GCS object at uri or nil if it doesn't exist.
+func readStatusObject(ctx context.Context, uri string) ([]byte, error) {
+	if !strings.HasPrefix(uri, "gs://") {
+		b, err := os.ReadFile(strings.TrimPrefix(uri, "file://"))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read status %v", uri)
+		}
+		return b, nil
+	}
+
+	var b []byte
+	err := withStatusObject(ctx, uri, func(object *storage.ObjectHandle) error {
+		r, err := object.NewReader(ctx)
+		if err == storage.ErrObjectNotExist {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		b, err = io.ReadAll(r)
+		return err
+	})
+	return b, errors.Wrapf(err, "Failed to read status %v", uri)
+}
+
+// writeStatusObject writes data to the local file or GCS object at uri.
+func writeStatusObject(ctx context.Context, uri string, data []byte) error {
+	if !strings.HasPrefix(uri, "gs://") {
+		p := strings.TrimPrefix(uri, "file://")
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return errors.Wrapf(err, "Failed to create directory for status %v", uri)
+		}
+		// Write to a temporary file and rename it so readers never see a partially written status.
+		tmp := p + ".tmp"
+		if err := os.WriteFile(tmp, data, 0o644); err != nil {
+			return errors.Wrapf(err, "Failed to write status %v", uri)
+		}
+		return errors.Wrapf(os.Rename(tmp, p), "Failed to write status %v", uri)
+	}
+
+	err := withStatusObject(ctx, uri, func(object *storage.ObjectHandle) error {
+		w := object.NewWriter(ctx)
+		w.ContentType = "application/yaml"
+		if _, err := w.Write(data); err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
+	})
+	return errors.Wrapf(err, "Failed to write status %v", uri)
+}
+
+// withStatusObject calls fn with the GCS object at uri.
+func withStatusObject(ctx context.Context, uri string, fn func(object *storage.ObjectHandle) error) error {
+	p, err := gcs.Parse(uri)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid status URI %v", uri)
+	}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create GCS client")
+	}
+	defer client.Close()
+	return fn(client.Bucket(p.Bucket).Object(p.Path))
+}
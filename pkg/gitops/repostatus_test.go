@@ -0,0 +1,138 @@
+package gitops
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/controllers"
+	"github.com/pkg/errors"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func Test_updateRepoStatus(t *testing.T) {
+	first := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+
+	previous := &RepoStatus{
+		RepoConfig: "hydros",
+		Repo:       "https://github.com/jlewi/hydros.git",
+		Conditions: []v1alpha1.Condition{
+			{Type: v1alpha1.SyncedCondition, Status: v1alpha1.ConditionTrue, Reason: "Reconciled", Message: "Reconciled commit 1234", LastTransitionTime: first},
+		},
+		Resources: []ResourceStatus{
+			{Kind: "Image", Name: "app", Commit: "1234", LastReconcileTime: first, LastSuccessTime: &first, Conditions: []v1alpha1.Condition{
+				{Type: v1alpha1.ReadyCondition, Status: v1alpha1.ConditionTrue, LastTransitionTime: first},
+			}},
+			{Kind: "ManifestSync", Name: "app", Commit: "1234", LastReconcileTime: first, LastSuccessTime: &first},
+			{Kind: "ManifestSync", Name: "deleted", Commit: "1234", LastReconcileTime: first, LastSuccessTime: &first},
+		},
+	}
+
+	results := []ResourceStatus{
+		{Kind: "ManifestSync", Name: "app", Commit: "5678", LastReconcileTime: second, LastError: "sync failed"},
+		{Kind: "Image", Name: "app", Commit: "5678", LastReconcileTime: second, Conditions: []v1alpha1.Condition{
+			{Type: v1alpha1.ReadyCondition, Status: v1alpha1.ConditionTrue, LastTransitionTime: second},
+		}},
+		{Kind: "Image", Name: "new", Commit: "5678", LastReconcileTime: second, LastError: "build failed"},
+	}
+
+	actual := updateRepoStatus(previous, results, second)
+
+	expected := &RepoStatus{
+		APIVersion: "hydros.dev/v1alpha1",
+		Kind:       RepoStatusKind,
+		RepoConfig: "hydros",
+		Repo:       "https://github.com/jlewi/hydros.git",
+		UpdateTime: second,
+		Conditions: []v1alpha1.Condition{
+			{Type: v1alpha1.ReadyCondition, Status: v1alpha1.ConditionFalse, Reason: resourcesFailedReason, Message: "2 of 3 resources failed", LastTransitionTime: second},
+			{Type: v1alpha1.SyncedCondition, Status: v1alpha1.ConditionTrue, Reason: "Reconciled", Message: "Reconciled commit 5678", LastTransitionTime: first},
+		},
+		Resources: []ResourceStatus{
+			{Kind: "Image", Name: "app", Commit: "5678", LastReconcileTime: second, LastSuccessTime: &second, Conditions: []v1alpha1.Condition{
+				{Type: v1alpha1.ReadyCondition, Status: v1alpha1.ConditionTrue, LastTransitionTime: first},
+			}},
+			{Kind: "Image", Name: "new", Commit: "5678", LastReconcileTime: second, LastError: "build failed"},
+			{Kind: "ManifestSync", Name: "app", Commit: "5678", LastReconcileTime: second, LastSuccessTime: &first, LastError: "sync failed"},
+		},
+	}
+
+	if d := cmp.Diff(expected, actual); d != "" {
+		t.Errorf("Unexpected status; diff:\n%v", d)
+	}
+}
+
+func Test_RepoStatusReadWrite(t *testing.T) {
+	uri := "file://" + filepath.Join(t.TempDir(), "status", "repo.yaml")
+	ctx := context.Background()
+
+	status, err := ReadRepoStatus(ctx, uri)
+	if err != nil {
+		t.Fatalf("ReadRepoStatus failed; %v", err)
+	}
+	if len(status.Resources) != 0 {
+		t.Fatalf("Expected an empty status when the file doesn't exist; got %+v", status)
+	}
+
+	now := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	expected := updateRepoStatus(&RepoStatus{RepoConfig: "hydros"}, []ResourceStatus{
+		{Kind: "Image", Name: "app", Path: "images/app.yaml", Commit: "1234", LastReconcileTime: now},
+	}, now)
+	if err := WriteRepoStatus(ctx, uri, expected); err != nil {
+		t.Fatalf("WriteRepoStatus failed; %v", err)
+	}
+
+	actual, err := ReadRepoStatus(ctx, uri)
+	if err != nil {
+		t.Fatalf("ReadRepoStatus failed; %v", err)
+	}
+	if d := cmp.Diff(expected, actual); d != "" {
+		t.Errorf("Status didn't round trip; diff:\n%v", d)
+	}
+}
+
+func Test_resourceConditions(t *testing.T) {
+	ctx := context.Background()
+	n, err := kyaml.Parse("apiVersion: hydros.dev/v1alpha1\nkind: ReplicatedImage\nmetadata:\n  name: app\n")
+	if err != nil {
+		t.Fatalf("Failed to parse resource; %v", err)
+	}
+
+	// Ready is derived from the error when the controller didn't report any conditions.
+	conditions := resourceConditions(ctx, n, errors.New("failed to decode"))
+	if ready := v1alpha1.GetCondition(conditions, v1alpha1.ReadyCondition); ready == nil || ready.Status != v1alpha1.ConditionFalse || ready.Message != "failed to decode" {
+		t.Errorf("Expected Ready to be False; got %+v", ready)
+	}
+
+	reported := []v1alpha1.Condition{
+		v1alpha1.NewCondition(v1alpha1.ReadyCondition, v1alpha1.ConditionFalse, "ReplicationFailed", "copy failed"),
+		v1alpha1.NewCondition(v1alpha1.SyncedCondition, v1alpha1.ConditionFalse, "ReplicationFailed", "copy failed"),
+	}
+	if err := controllers.SetConditions(n, reported); err != nil {
+		t.Fatalf("SetConditions failed; %v", err)
+	}
+	conditions = resourceConditions(ctx, n, errors.New("copy failed"))
+	if d := cmp.Diff(reported, conditions); d != "" {
+		t.Errorf("Expected the conditions reported by the controller; diff:\n%v", d)
+	}
+}
+
+func Test_buildCommitStatus(t *testing.T) {
+	status := buildCommitStatus(ResourceStatus{Kind: "Image", Name: "app"})
+	if status.GetState() != "success" || status.GetContext() != "hydros/Image/app" {
+		t.Errorf("Unexpected status %v", status)
+	}
+
+	status = buildCommitStatus(ResourceStatus{Kind: "Image", Name: "app", LastError: strings.Repeat("x", 200)})
+	if status.GetState() != "failure" {
+		t.Errorf("Expected state failure; got %v", status.GetState())
+	}
+	if len(status.GetDescription()) != maxCommitStatusDescription {
+		t.Errorf("Expected the description to be truncated to %v characters; got %v", maxCommitStatusDescription, len(status.GetDescription()))
+	}
+}
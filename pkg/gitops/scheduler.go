@@ -0,0 +1,211 @@
+package gitops
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/zapr"
+	"github.com/jlewi/hydros/pkg/config"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultMaxConcurrency = 4
+	defaultJitter         = 0.1
+	defaultMaxBackoff     = time.Hour
+)
+
+// Job is a unit of work run by the Scheduler.
+type Job struct {
+	// Name of the job e.g. the name of the ManifestSync.
+	Name string
+	// Keys identify the resources the job modifies e.g. the fork branches a Syncer pushes to.
+	// Jobs that share a key are never run concurrently.
+	Keys []string
+	Run  func() error
+}
+
+// Scheduler runs jobs periodically. It limits how many jobs run concurrently and how often jobs start, ensures
+// jobs that modify the same resources don't run at the same time, optionally spreads the jobs across their
+// periods, adds jitter to the periods and backs off jobs that keep failing.
+type Scheduler struct {
+	// sem limits the number of jobs running concurrently.
+	sem        chan bool
+	jitter     float64
+	maxBackoff time.Duration
+	// limiter limits how often jobs start. It is nil if the rate isn't limited.
+	limiter *rate.Limiter
+	// spread is true if the first run of each job is delayed by a random fraction of its period.
+	spread bool
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+
+	// randFloat returns a random number in [0, 1). It can be overridden in tests.
+	randFloat func() float64
+}
+
+// NewScheduler creates a new scheduler. c can be nil to use the defaults.
+func NewScheduler(c *config.SchedulerConfig) (*Scheduler, error) {
+	s := &Scheduler{
+		sem:        make(chan bool, defaultMaxConcurrency),
+		jitter:     defaultJitter,
+		maxBackoff: defaultMaxBackoff,
+		locks:      map[string]*sync.Mutex{},
+		randFloat:  rand.Float64,
+	}
+
+	if c == nil {
+		return s, nil
+	}
+
+	if c.MaxConcurrency < 0 {
+		return nil, errors.Errorf("Scheduler maxConcurrency %v is invalid; it must be positive", c.MaxConcurrency)
+	}
+	if c.MaxConcurrency > 0 {
+		s.sem = make(chan bool, c.MaxConcurrency)
+	}
+
+	if c.Jitter < 0 || c.Jitter >= 1 {
+		return nil, errors.Errorf("Scheduler jitter %v is invalid; it must be in [0, 1)", c.Jitter)
+	}
+	if c.Jitter > 0 {
+		s.jitter = c.Jitter
+	}
+
+	if c.MaxBackoff != "" {
+		d, err := time.ParseDuration(c.MaxBackoff)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Scheduler maxBackoff %v isn't a valid duration", c.MaxBackoff)
+		}
+		s.maxBackoff = d
+	}
+
+	if c.MaxRunsPerMinute < 0 {
+		return nil, errors.Errorf("Scheduler maxRunsPerMinute %v is invalid; it must be positive", c.MaxRunsPerMinute)
+	}
+	if c.MaxRunsPerMinute > 0 {
+		s.limiter = rate.NewLimiter(rate.Limit(float64(c.MaxRunsPerMinute)/60), 1)
+	}
+	s.spread = c.Spread
+	return s, nil
+}
+
+// RunOnce runs the job once. It blocks until the job can be run without exceeding the maximum concurrency and
+// without any other job holding one of its keys.
+func (s *Scheduler) RunOnce(j Job) error {
+	if s.limiter != nil {
+		if err := s.limiter.Wait(context.Background()); err != nil {
+			return errors.Wrapf(err, "Failed to wait to run job %v", j.Name)
+		}
+	}
+	s.sem <- true
+	defer func() { <-s.sem }()
+
+	unlock := s.lock(j.Keys)
+	defer unlock()
+
+	return j.Run()
+}
+
+// lock acquires the locks for the keys and returns a function to release them.
+func (s *Scheduler) lock(keys []string) func() {
+	// N.B. Locks are acquired in sorted order so that jobs with overlapping keys can't deadlock.
+	sorted := make([]string, 0, len(keys))
+	seen := map[string]bool{}
+	for _, k := range keys {
+		if !seen[k] {
+			seen[k] = true
+			sorted = append(sorted, k)
+		}
+	}
+	sort.Strings(sorted)
+
+	locks := make([]*sync.Mutex, 0, len(sorted))
+	s.mu.Lock()
+	for _, k := range sorted {
+		if _, ok := s.locks[k]; !ok {
+			s.locks[k] = &sync.Mutex{}
+		}
+		locks = append(locks, s.locks[k])
+	}
+	s.mu.Unlock()
+
+	for _, l := range locks {
+		l.Lock()
+	}
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].Unlock()
+		}
+	}
+}
+
+// Start runs the job periodically until ctx is cancelled. The job is run immediately unless the scheduler is
+// configured to spread jobs in which case the first run is delayed by a random fraction of the period so that
+// jobs started at the same time don't all run at once.
+func (s *Scheduler) Start(ctx context.Context, j Job, period time.Duration) {
+	log := zapr.NewLogger(zap.L()).WithValues("job", j.Name)
+	go func() {
+		if delay := s.initialDelay(period); delay > 0 {
+			log.V(util.Debug).Info("Delaying first run", "duration", delay)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+
+		failures := 0
+		for {
+			if err := s.RunOnce(j); err != nil {
+				failures++
+				log.Error(err, "Job failed", "consecutiveFailures", failures)
+			} else {
+				failures = 0
+			}
+
+			delay := s.nextDelay(period, failures)
+			log.V(util.Debug).Info("sleep", "duration", delay)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+	}()
+}
+
+// initialDelay returns how long to wait before running a job for the first time.
+func (s *Scheduler) initialDelay(period time.Duration) time.Duration {
+	if !s.spread {
+		return 0
+	}
+	return time.Duration(s.randFloat() * float64(period))
+}
+
+// nextDelay returns how long to wait before running a job again. The period is doubled for each consecutive
+// failure up to maxBackoff. The delay is then randomly varied by up to jitter.
+func (s *Scheduler) nextDelay(period time.Duration, failures int) time.Duration {
+	delay := period
+	for i := 0; i < failures; i++ {
+		delay *= 2
+		if delay >= s.maxBackoff {
+			delay = s.maxBackoff
+			break
+		}
+	}
+	// Never run a job more often than its period even if maxBackoff is shorter.
+	if delay < period {
+		delay = period
+	}
+
+	offset := (2*s.randFloat() - 1) * s.jitter * float64(delay)
+	return delay + time.Duration(offset)
+}
@@ -0,0 +1,160 @@
+package gitops
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jlewi/hydros/pkg/config"
+)
+
+func Test_SchedulerRunOnce(t *testing.T) {
+	type testCase struct {
+		name           string
+		maxConcurrency int
+		keys           func(i int) []string
+		expectedMax    int32
+	}
+
+	cases := []testCase{
+		{
+			name:           "max-concurrency",
+			maxConcurrency: 2,
+			keys: func(i int) []string {
+				return []string{string(rune('a' + i))}
+			},
+			expectedMax: 2,
+		},
+		{
+			name:           "shared-key",
+			maxConcurrency: 4,
+			keys: func(i int) []string {
+				// N.B. Keys are listed in different orders to check jobs don't deadlock.
+				if i%2 == 0 {
+					return []string{"org/repo/branch", "other"}
+				}
+				return []string{"other", "org/repo/branch", "org/repo/branch"}
+			},
+			expectedMax: 1,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, err := NewScheduler(&config.SchedulerConfig{MaxConcurrency: c.maxConcurrency})
+			if err != nil {
+				t.Fatalf("Failed to create scheduler; %v", err)
+			}
+
+			var running, maxRunning int32
+			var wg sync.WaitGroup
+			for i := 0; i < 6; i++ {
+				wg.Add(1)
+				j := Job{
+					Keys: c.keys(i),
+					Run: func() error {
+						n := atomic.AddInt32(&running, 1)
+						for {
+							m := atomic.LoadInt32(&maxRunning)
+							if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+								break
+							}
+						}
+						time.Sleep(20 * time.Millisecond)
+						atomic.AddInt32(&running, -1)
+						return nil
+					},
+				}
+				go func() {
+					defer wg.Done()
+					if err := s.RunOnce(j); err != nil {
+						t.Errorf("RunOnce failed; %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if maxRunning != c.expectedMax {
+				t.Errorf("Max concurrent jobs: got %v; want %v", maxRunning, c.expectedMax)
+			}
+		})
+	}
+}
+
+func Test_SchedulerNextDelay(t *testing.T) {
+	s, err := NewScheduler(&config.SchedulerConfig{MaxBackoff: "1h"})
+	if err != nil {
+		t.Fatalf("Failed to create scheduler; %v", err)
+	}
+	// Use the midpoint so there is no jitter.
+	s.randFloat = func() float64 { return 0.5 }
+
+	type testCase struct {
+		period   time.Duration
+		failures int
+		expected time.Duration
+	}
+
+	cases := []testCase{
+		{period: time.Minute, failures: 0, expected: time.Minute},
+		{period: time.Minute, failures: 3, expected: 8 * time.Minute},
+		{period: time.Minute, failures: 20, expected: time.Hour},
+		{period: 2 * time.Hour, failures: 2, expected: 2 * time.Hour},
+	}
+
+	for _, c := range cases {
+		if actual := s.nextDelay(c.period, c.failures); actual != c.expected {
+			t.Errorf("nextDelay(%v, %v): got %v; want %v", c.period, c.failures, actual, c.expected)
+		}
+	}
+
+	s.randFloat = func() float64 { return 0 }
+	if actual := s.nextDelay(time.Minute, 0); actual != 54*time.Second {
+		t.Errorf("nextDelay with jitter: got %v; want %v", actual, 54*time.Second)
+	}
+}
+
+func Test_SchedulerInitialDelay(t *testing.T) {
+	s, err := NewScheduler(nil)
+	if err != nil {
+		t.Fatalf("Failed to create scheduler; %v", err)
+	}
+	s.randFloat = func() float64 { return 0.25 }
+	// By default jobs run immediately e.g. so hydros apply --period applies resources as soon as it starts.
+	if actual := s.initialDelay(time.Hour); actual != 0 {
+		t.Errorf("initialDelay: got %v; want 0", actual)
+	}
+
+	s, err = NewScheduler(&config.SchedulerConfig{Spread: true})
+	if err != nil {
+		t.Fatalf("Failed to create scheduler; %v", err)
+	}
+	s.randFloat = func() float64 { return 0.25 }
+	if actual := s.initialDelay(time.Hour); actual != 15*time.Minute {
+		t.Errorf("initialDelay with spread: got %v; want %v", actual, 15*time.Minute)
+	}
+}
+
+func Test_SchedulerRateLimit(t *testing.T) {
+	// 600 runs per minute is one run every 100ms.
+	s, err := NewScheduler(&config.SchedulerConfig{MaxRunsPerMinute: 600})
+	if err != nil {
+		t.Fatalf("Failed to create scheduler; %v", err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := s.RunOnce(Job{Run: func() error { return nil }}); err != nil {
+			t.Fatalf("RunOnce failed; %v", err)
+		}
+	}
+	// The first run isn't delayed so 3 runs take at least 200ms.
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond {
+		t.Errorf("Runs weren't rate limited; 3 runs took %v", elapsed)
+	}
+
+	if _, err := NewScheduler(&config.SchedulerConfig{MaxRunsPerMinute: -1}); err == nil {
+		t.Errorf("Expected an error for a negative maxRunsPerMinute")
+	}
+}
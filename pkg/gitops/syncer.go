@@ -3,14 +3,17 @@ package gitops
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/jlewi/hydros/pkg/azure"
 	"github.com/jlewi/hydros/pkg/gcp"
 
 	"github.com/go-git/go-git/v5"
@@ -18,8 +21,7 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"github.com/jlewi/hydros/pkg/gitutil"
 
-	"github.com/jlewi/hydros/pkg/ecrutil"
-	"github.com/jlewi/hydros/pkg/skaffold"
+	"github.com/jlewi/hydros/pkg/images"
 
 	kustomize2 "github.com/jlewi/hydros/pkg/kustomize"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -28,12 +30,14 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecr"
 	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
+	ghAPI "github.com/google/go-github/v52/github"
 	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/callbacks"
+	hconfig "github.com/jlewi/hydros/pkg/config"
 	"github.com/jlewi/hydros/pkg/github"
 	"github.com/jlewi/hydros/pkg/github/ghrepo"
 	"github.com/jlewi/hydros/pkg/util"
@@ -53,17 +57,71 @@ type Syncer struct {
 	sess       *session.Session
 	transports *github.TransportManager
 
-	repoHelper *github.RepoHelper
+	// allowExistingWorkDir allows workDir to be a directory that wasn't created by hydros.
+	allowExistingWorkDir bool
 
-	execHelper *util.ExecHelper
+	// hydrosVersion is the version of hydros doing the hydration. It is recorded in the lastsync file.
+	hydrosVersion string
+
+	// mergeQueueEvents is true if the syncer is run with a MergeQueueEvent when the merge queue of a destination
+	// finishes. PRs in a merge queue are then left in the queue instead of waiting for them to be merged.
+	mergeQueueEvents bool
+
+	// emergencyReason if set makes every run an emergency sync which bypasses pauses.
+	emergencyReason string
+
+	// destinations are the destinations the manifests are hydrated to.
+	destinations []*destination
+	// environments are the syncers of each of the environments when spec.environments is used.
+	environments []*Syncer
+
+	// mu serializes runs. All runs share the checkouts in workDir so overlapping callers
+	// (e.g. a webhook and the periodic sync) have to take turns. The caches below are only accessed while
+	// holding mu.
+	mu sync.Mutex
+
+	// imageController is used to build Image resources found in the source repository.
+	// It is created the first time it is needed using imageOptions.
+	imageController *images.Controller
+	imageOptions    []images.ControllerOption
 
 	// imageStrategies is a cache of how images should be resolved
-	imageStrategies map[util.DockerImageRef]v1alpha1.Strategy
+	imageStrategies map[util.DockerImageRef]v1alpha1.ImageTagToPin
 
 	selector *meta.LabelSelector
 
 	// Cache the Google image Resolver
 	gcpImageResovler *gcp.ImageResolver
+	// Cache the Azure Container Registry image resolver
+	acrImageResolver *azure.ImageResolver
+	// Cache the resolver for all other OCI registries
+	ociImageResolver *images.Resolver
+	// imageResolver if set resolves all images instead of the resolvers above.
+	imageResolver ImageResolver
+
+	// applyManifests applies the manifests in a directory to the cluster of the ManifestSync.
+	applyManifests func(ctx context.Context, m *v1alpha1.ManifestSync, dir string) error
+
+	// functions are the external KRM functions the operator allows. Functions are only run if they are also
+	// selected by spec.externalFunctions.
+	functions *hconfig.FunctionsConfig
+
+	// statusMu guards conditions and cancelRun. It is separate from mu so they can be accessed while a run is in
+	// progress.
+	statusMu sync.Mutex
+	// conditions are the conditions of the ManifestSync after the latest run.
+	conditions []v1alpha1.Condition
+	// cancelRun cancels the context of the run in progress. It is nil if no run is in progress.
+	cancelRun context.CancelFunc
+
+	// callbackSender sends the results of runs to the callbacks of the ManifestSync. It is created the first time
+	// it is needed.
+	callbackSender *callbacks.Sender
+}
+
+// ImageResolver resolves images to their shas.
+type ImageResolver interface {
+	ResolveImageToSha(ctx context.Context, r util.DockerImageRef, strategy v1alpha1.Strategy) (util.DockerImageRef, error)
 }
 
 const (
@@ -72,6 +130,14 @@ const (
 	sourceKey         = "source"
 	forkKey           = "fork"
 	kustomizationFile = "kustomization.yaml"
+
+	// SyncCheckName is the prefix of the name of the check run reporting the result of a sync on the source
+	// commit. The check run is named SyncCheckName/<ManifestSync name> since several ManifestSyncs can share
+	// a source repository.
+	SyncCheckName = "hydros-sync"
+	// TestCheckName is the prefix of the name of the check run reporting the result of the tests run when
+	// building an Image. The check run is named TestCheckName/<Image name>.
+	TestCheckName = "hydros-test"
 )
 
 // NewSyncer creates a new syncer.
@@ -93,6 +159,8 @@ func NewSyncer(m *v1alpha1.ManifestSync, manager *github.TransportManager, opts
 		workDir:    "",
 		manifest:   m,
 		transports: manager,
+
+		applyManifests: applyToCluster,
 	}
 
 	for _, o := range opts {
@@ -101,6 +169,10 @@ func NewSyncer(m *v1alpha1.ManifestSync, manager *github.TransportManager, opts
 		}
 	}
 	s.log.Info("Creating NewSyncer", "manifest", m)
+	if len(m.Spec.Environments) > 0 {
+		return s, s.newEnvironments(opts...)
+	}
+
 	if s.workDir == "" {
 		newDir, err := os.MkdirTemp("", "manifestSync")
 		if err != nil {
@@ -112,10 +184,7 @@ func NewSyncer(m *v1alpha1.ManifestSync, manager *github.TransportManager, opts
 	s.workDir = filepath.Join(s.workDir, m.Metadata.Name)
 	s.log.Info("workdir is set.", "workDir", s.workDir)
 	s.log = s.log.WithValues("ManifestSync.Name", s.manifest.Metadata.Name)
-
-	s.execHelper = &util.ExecHelper{
-		Log: s.log,
-	}
+	s.log = s.log.WithValues(v1alpha1.LogValues(s.manifest.Spec.Owners, s.manifest.Spec.Notify)...)
 
 	if s.manifest.Spec.Selector != nil {
 		selector, err := s.manifest.Spec.Selector.ToK8s()
@@ -134,8 +203,75 @@ func NewSyncer(m *v1alpha1.ManifestSync, manager *github.TransportManager, opts
 		}
 	}
 
-	// Create a repo helper for the destRepo
-	dRepo := s.manifest.Spec.DestRepo
+	for _, d := range s.manifest.Destinations() {
+		dest, err := s.newDestination(d)
+		if err != nil {
+			return nil, err
+		}
+		s.destinations = append(s.destinations, dest)
+	}
+
+	s.log.Info("Successfully created Syncer")
+	return s, nil
+}
+
+// newEnvironments creates a Syncer for each of the environments of the manifest.
+func (s *Syncer) newEnvironments(opts ...SyncerOption) error {
+	s.log = s.log.WithValues("ManifestSync.Name", s.manifest.Metadata.Name)
+	for _, e := range s.manifest.Spec.Environments {
+		env, err := NewSyncer(s.manifest.ForEnvironment(e), s.transports, opts...)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to create syncer for environment %v", e.Name)
+		}
+		s.environments = append(s.environments, env)
+	}
+	s.log.Info("Successfully created Syncer", "numEnvironments", len(s.environments))
+	return nil
+}
+
+// runEnvironments runs the syncer of each environment once. A failure to sync one environment doesn't prevent
+// the others from being synced.
+func (s *Syncer) runEnvironments(force bool) error {
+	finalErr := &util.ListOfErrors{}
+	for i, env := range s.environments {
+		if err := env.RunOnce(force); err != nil {
+			s.log.Error(err, "Failed to sync environment", "environment", s.manifest.Spec.Environments[i].Name)
+			finalErr.AddCause(err)
+		}
+	}
+	if len(finalErr.Causes) == 0 {
+		return nil
+	}
+	finalErr.Final = errors.Errorf("Failed to sync %d of %d environments", len(finalErr.Causes), len(s.environments))
+	return finalErr
+}
+
+// destination is a destination the manifests are hydrated to.
+type destination struct {
+	v1alpha1.Destination
+
+	// destKey and forkKey are the keys of the checkouts of the DestRepo and ForkRepo.
+	destKey string
+	forkKey string
+
+	repoHelper *github.RepoHelper
+
+	// appliedCommit is the commit of the dest branch whose manifests were last applied to the cluster.
+	appliedCommit string
+}
+
+// destinationKeys returns the keys used to identify the checkouts of the dest and fork repos of the destination.
+func destinationKeys(d v1alpha1.Destination) (string, string) {
+	if d.Name == "" {
+		return destKey, forkKey
+	}
+	return destKey + "-" + d.Name, forkKey + "-" + d.Name
+}
+
+// newDestination creates the destination and a repo helper for its destRepo.
+func (s *Syncer) newDestination(d v1alpha1.Destination) (*destination, error) {
+	dKey, fKey := destinationKeys(d)
+	dRepo := d.DestRepo
 	tr, err := s.transports.Get(dRepo.Org, dRepo.Repo)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Failed to get transport for repo %v/%v; Is the GitHub ghapp installed in that repo?", dRepo.Org, dRepo.Repo)
@@ -144,12 +280,14 @@ func NewSyncer(m *v1alpha1.ManifestSync, manager *github.TransportManager, opts
 	args := &github.RepoHelperArgs{
 		BaseRepo:   ghrepo.New(dRepo.Org, dRepo.Repo),
 		GhTr:       tr,
-		FullDir:    filepath.Join(s.workDir, destKey),
+		FullDir:    s.repoKeyToDir(dKey),
 		Name:       "hydros",
 		Email:      "hydros@yourdomain.com",
 		Remote:     "origin",
-		BranchName: s.manifest.Spec.ForkRepo.Branch,
+		BranchName: d.ForkRepo.Branch,
 		BaseBranch: dRepo.Branch,
+
+		MergeMethod: mergeMethod(s.manifest),
 	}
 
 	repoHelper, err := github.NewGithubRepoHelper(args)
@@ -157,17 +295,34 @@ func NewSyncer(m *v1alpha1.ManifestSync, manager *github.TransportManager, opts
 		return nil, err
 	}
 
-	s.repoHelper = repoHelper
-	s.log.Info("Successfully created Syncer")
-	return s, nil
+	return &destination{
+		Destination: d,
+		destKey:     dKey,
+		forkKey:     fKey,
+		repoHelper:  repoHelper,
+	}, nil
 }
 
 func getRepos(m v1alpha1.ManifestSync) map[string]v1alpha1.GitHubRepo {
-	return map[string]v1alpha1.GitHubRepo{
+	repos := map[string]v1alpha1.GitHubRepo{
 		sourceKey: m.Spec.SourceRepo,
-		destKey:   m.Spec.DestRepo,
-		forkKey:   m.Spec.ForkRepo,
 	}
+	for _, d := range m.Destinations() {
+		dKey, fKey := destinationKeys(d)
+		repos[dKey] = d.DestRepo
+		repos[fKey] = d.ForkRepo
+	}
+	return repos
+}
+
+// isForkKey returns true if the key identifies the checkout of a fork repo.
+func isForkKey(name string) bool {
+	return name == forkKey || strings.HasPrefix(name, forkKey+"-")
+}
+
+// isDestKey returns true if the key identifies the checkout of a dest repo.
+func isDestKey(name string) bool {
+	return name == destKey || strings.HasPrefix(name, destKey+"-")
 }
 
 // SyncerOption is an option for instantiating the syncer.
@@ -189,6 +344,55 @@ func SyncWithWorkDir(wDir string) SyncerOption {
 	}
 }
 
+// SyncWithAllowExistingWorkDir creates an option to allow the working directory to be an existing directory that
+// wasn't created by hydros. By default hydros refuses to use such directories since it discards local changes
+// in its working directory.
+func SyncWithAllowExistingWorkDir() SyncerOption {
+	return func(s *Syncer) error {
+		s.allowExistingWorkDir = true
+		return nil
+	}
+}
+
+// SyncWithHydrosVersion creates an option to record version as the version of hydros that hydrated the manifests.
+// It is compared to the version recorded by the last sync to detect version skew.
+func SyncWithHydrosVersion(version string) SyncerOption {
+	return func(s *Syncer) error {
+		s.hydrosVersion = version
+		return nil
+	}
+}
+
+// SyncWithMergeQueueEvents creates an option to not wait for PRs that are added to a merge queue to be merged.
+// The caller is responsible for running the syncer with a MergeQueueEvent when the merge queue finishes
+// e.g. in response to merge_group webhooks.
+func SyncWithMergeQueueEvents() SyncerOption {
+	return func(s *Syncer) error {
+		s.mergeQueueEvents = true
+		return nil
+	}
+}
+
+// SyncWithEmergency creates an option to run emergency syncs e.g. to roll out an urgent security fix. Emergency
+// syncs bypass pauses. The reason is required; it is logged and recorded in the PRs.
+func SyncWithEmergency(reason string) SyncerOption {
+	return func(s *Syncer) error {
+		if strings.TrimSpace(reason) == "" {
+			return errors.New("Emergency syncs require a reason")
+		}
+		s.emergencyReason = reason
+		return nil
+	}
+}
+
+// SyncWithImageOptions creates an option to configure the controller used to build images.
+func SyncWithImageOptions(opts ...images.ControllerOption) SyncerOption {
+	return func(s *Syncer) error {
+		s.imageOptions = opts
+		return nil
+	}
+}
+
 // SyncWithAwsSession creates an option to use the supplied session.
 func SyncWithAwsSession(sess *session.Session) SyncerOption {
 	return func(s *Syncer) error {
@@ -197,10 +401,38 @@ func SyncWithAwsSession(sess *session.Session) SyncerOption {
 	}
 }
 
+// SyncWithImageResolver creates an option to resolve images to their shas using r rather than the resolver for
+// the image's registry; e.g. to stub out the registries in tests. Tags are still listed using the OCI distribution
+// API for the newestMatchingTag and semver strategies.
+func SyncWithImageResolver(r ImageResolver) SyncerOption {
+	return func(s *Syncer) error {
+		s.imageResolver = r
+		return nil
+	}
+}
+
+// SyncWithFunctions creates an option to allow the external KRM functions in cfg to run. Without it container
+// and exec functions are never run regardless of spec.externalFunctions.
+func SyncWithFunctions(cfg *hconfig.FunctionsConfig) SyncerOption {
+	return func(s *Syncer) error {
+		s.functions = cfg
+		return nil
+	}
+}
+
 // getPinStrategy returns the strategy to resolve the image.
 func (s *Syncer) getPinStrategy(source util.DockerImageRef) v1alpha1.Strategy {
+	imageToPin, ok := s.getImageTagToPin(source)
+	if !ok {
+		return v1alpha1.UnknownStrategy
+	}
+	return imageToPin.Strategy
+}
+
+// getImageTagToPin returns the ImageTagToPin matching the image if any.
+func (s *Syncer) getImageTagToPin(source util.DockerImageRef) (v1alpha1.ImageTagToPin, bool) {
 	if s.imageStrategies == nil {
-		s.imageStrategies = map[util.DockerImageRef]v1alpha1.Strategy{}
+		s.imageStrategies = map[util.DockerImageRef]v1alpha1.ImageTagToPin{}
 	}
 
 	if _, ok := s.imageStrategies[source]; !ok {
@@ -218,7 +450,7 @@ func (s *Syncer) getPinStrategy(source util.DockerImageRef) v1alpha1.Strategy {
 			}
 
 			if imageToPin.ImageRepoMatch == nil {
-				s.imageStrategies[source] = imageToPin.Strategy
+				s.imageStrategies[source] = imageToPin
 				break
 			}
 
@@ -231,48 +463,130 @@ func (s *Syncer) getPinStrategy(source util.DockerImageRef) v1alpha1.Strategy {
 			}
 
 			if repoMatches && imageToPin.ImageRepoMatch.Type == v1alpha1.IncludeRepo {
-				s.imageStrategies[source] = imageToPin.Strategy
+				s.imageStrategies[source] = imageToPin
 			}
 
 			if !repoMatches && imageToPin.ImageRepoMatch.Type == v1alpha1.ExcludeRepo {
-				s.imageStrategies[source] = imageToPin.Strategy
+				s.imageStrategies[source] = imageToPin
 			}
 		}
 	}
 
-	strategy, ok := s.imageStrategies[source]
+	imageToPin, ok := s.imageStrategies[source]
+	return imageToPin, ok
+}
+
+// syncRun holds the state scoped to a single run of the Syncer. Methods that are part of a run are defined on
+// syncRun so they use the run's logger and update the run's copy of the manifest rather than mutating the
+// Syncer. The embedded Syncer provides the configuration shared by all runs.
+type syncRun struct {
+	*Syncer
 
-	if ok {
-		return strategy
-	}
+	log        logr.Logger
+	manifest   *v1alpha1.ManifestSync
+	execHelper *util.ExecHelper
 
-	return v1alpha1.UnknownStrategy
+	// sourceCommit is the commit of the source being synced. It is empty until the source is cloned.
+	sourceCommit string
+	// needsSync is true if any destination needed to be synced.
+	needsSync bool
+	// prURLs are the URLs of the PRs created during the run.
+	prURLs []string
+	// awaitingApproval is true if PRs created during the run won't be merged until they are approved.
+	awaitingApproval bool
+	// release is true if the run ends a takeover; pauses are ignored.
+	release bool
+	// emergency describes an emergency sync; pauses are bypassed. It is nil if the run isn't an emergency.
+	emergency *emergencySync
+	// bypassedPauses describes the pauses of the destinations bypassed by an emergency sync.
+	bypassedPauses map[*destination]string
+	// blocked is why a destination can't be synced without intervention. It is nil if no destination is blocked.
+	blocked *runCondition
+	// notSynced is why a destination isn't synced yet. It is nil if every destination is synced.
+	notSynced *runCondition
+}
+
+// newRun creates the state for a single run of the syncer.
+func (s *Syncer) newRun() *syncRun {
+	// Generate a unique run id for each run so that its easy to group log entries about a single run.
+	log := s.log.WithValues("run", uuid.New().String()[0:5])
+
+	// Copy the manifest so that setting the status during the run doesn't modify the Syncer's copy.
+	// N.B. This is a shallow copy; runs only assign to the fields of the status and never modify the spec.
+	manifest := *s.manifest
+	return &syncRun{
+		Syncer:   s,
+		log:      log,
+		manifest: &manifest,
+		execHelper: &util.ExecHelper{
+			Log: log,
+		},
+	}
 }
 
 // RunOnce runs the syncer once. If force is true a sync is run even if none is needed.
+// It is safe to call RunOnce concurrently; overlapping runs are serialized.
 func (s *Syncer) RunOnce(force bool) error {
-	// We need to reset the logger after RunOnce runs. Otherwise we will end up accumulating fields
-	// like "run".
-	oldLogger := s.log
-	defer func() {
-		s.log = oldLogger
-	}()
-
-	// Generate a unique run id for each run so that its easy to group log entries about a single run.
-	s.log = s.log.WithValues("run", uuid.New().String()[0:5])
-	ctx := context.Background()
-	ctx = logr.NewContext(ctx, s.log)
-	s.execHelper.Log = s.log
-	log := s.log
-	if _, err := os.Stat(s.workDir); os.IsNotExist(err) {
-		log.V(util.Debug).Info("Creating work directory.", "directory", s.workDir)
+	if len(s.environments) > 0 {
+		return s.runEnvironments(force)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start := time.Now()
+	r := s.newRun()
+	ctx, done := s.runContext()
+	defer done()
+	err := r.run(ctx, force)
+	r.reportStatus(err)
+	s.statusMu.Lock()
+	s.conditions = r.conditions(s.conditions, err)
+	conditions := append([]v1alpha1.Condition{}, s.conditions...)
+	s.statusMu.Unlock()
+	r.sendCallbacks(start, conditions, err)
+	return err
+}
 
-		err = os.MkdirAll(s.workDir, util.FilePermUserGroup)
+// runContext returns the context of a run which is cancelled by Cancel. done must be called when the run finishes.
+func (s *Syncer) runContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.statusMu.Lock()
+	s.cancelRun = cancel
+	s.statusMu.Unlock()
+	return ctx, func() {
+		s.statusMu.Lock()
+		s.cancelRun = nil
+		s.statusMu.Unlock()
+		cancel()
+	}
+}
 
-		if err != nil {
-			return errors.Wrapf(err, "Failed to create dir: %v", s.workDir)
+// Cancel cancels the run in progress, if any, e.g. to abort a run stuck waiting for an image build. It returns
+// true if a run was cancelled.
+func (s *Syncer) Cancel() bool {
+	cancelled := false
+	for _, env := range s.environments {
+		if env.Cancel() {
+			cancelled = true
 		}
 	}
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	if s.cancelRun != nil {
+		s.log.Info("Cancelling sync in progress")
+		s.cancelRun()
+		cancelled = true
+	}
+	return cancelled
+}
+
+// run runs a single sync. Cancelling ctx aborts resolving images and waiting for image builds.
+func (s *syncRun) run(ctx context.Context, force bool) error {
+	log := s.log
+	ctx = logr.NewContext(ctx, log)
+	if err := s.prepareWorkDir(); err != nil {
+		log.Error(err, "Work directory can't be used")
+		return err
+	}
 
 	// Set the pauseStatus if needed
 	// This is necessary to ensure that the pausedUntil gets persisted when we update the manifest in git
@@ -281,79 +595,137 @@ func (s *Syncer) RunOnce(force bool) error {
 		log.Error(err, "Failed to set pause status")
 	}
 
-	// Check if there is a PR already pending from the branch and if there is don't do a sync.
-
-	// If the fork is in a different repo then the head reference is OWNER:BRANCH
-	// If we are creating the PR from a different branch in the same repo as where we are creating
-	// the PR then we just use BRANCH as the ref
-	headBranchRef := s.manifest.Spec.ForkRepo.Branch
-
-	if s.manifest.Spec.ForkRepo.Org != s.manifest.Spec.DestRepo.Org {
-		headBranchRef = s.manifest.Spec.ForkRepo.Org + ":" + headBranchRef
-	}
-	existingPR, err := s.repoHelper.PullRequestForBranch()
+	emergency, err := newEmergencySync(*s.manifest, s.emergencyReason, time.Now())
 	if err != nil {
-		log.Error(err, "Failed to check if there is an existing PR", "headBranchRef", headBranchRef)
+		log.Error(err, "Emergency sync is missing a reason or expiry")
 		return err
 	}
+	s.emergency = emergency
+	if emergency != nil {
+		log.Info("Running emergency sync; pauses will be bypassed", "emergencyReason", emergency.reason, "emergencyExpires", emergency.expires)
+	} else if s.manifest.IsEmergency() {
+		log.Info("Emergency has expired; ignoring the emergency annotation", "emergencyExpires", s.manifest.Metadata.Annotations[v1alpha1.EmergencyExpiresAnnotation])
+	}
 
-	if existingPR != nil {
-		log.Info("PR Already Exists; attempting to merge it.", "pr", existingPR.URL)
-		state, err := s.repoHelper.MergeAndWait(existingPR.Number, 3*time.Minute)
+	// Check if there is a PR already pending for each destination and if there is try to merge it.
+	// Destinations with a PR that can't be merged are skipped but the other destinations are still synced.
+	finalErr := &util.ListOfErrors{}
+	targets := []*destination{}
+	for _, d := range s.destinations {
+		proceed, err := s.mergeExistingPR(d)
 		if err != nil {
-			log.Error(err, "Failed to Merge existing PR unable to continue with sync", "number", existingPR.Number, "pr", existingPR.URL)
-			return err
+			finalErr.AddCause(err)
+			continue
 		}
-
-		if state != github.ClosedState && state != github.MergedState {
-			log.Info("PR hasn't been merged; unable to continue with the sync", "number", existingPR.Number, "pr", existingPR.URL, "state", state)
-			return errors.Errorf("Existing PR %v is blocking sync", existingPR.URL)
+		if !proceed {
+			continue
 		}
+		targets = append(targets, d)
+	}
+
+	if len(targets) == 0 {
+		return destinationErrors(finalErr, len(s.destinations))
 	}
 
 	if err := s.cloneRepos(); err != nil {
 		return err
 	}
 
+	// Apply the manifests merged into the dest branches since they were last applied.
+	for _, d := range targets {
+		if err := s.applyDestination(ctx, d); err != nil {
+			log.Error(err, "Failed to apply the manifests in the dest branch", "destination", d.Name)
+			finalErr.AddCause(err)
+		}
+	}
+
 	sourceRepoRoot := filepath.Join(s.workDir, sourceKey)
 	sourceRoot := filepath.Join(sourceRepoRoot, s.manifest.Spec.SourcePath)
 
 	sourceCommit := s.getSourceCommit()
+	s.sourceCommit = sourceCommit
 
-	if err := s.buildImages(sourceRoot, sourceCommit); err != nil {
+	sourceTag, err := s.manifest.Spec.SourceCommitTag.Tag(sourceCommit)
+	if err != nil {
 		return err
 	}
 
-	lastStatus := s.lastStatusFromManifest(filepath.Join(s.workDir, destKey, s.manifest.Spec.DestPath, lastSyncFile))
-
-	// We need to take into account the current manifest and the lastStatus to deci
-	if isPaused(ctx, *s.manifest, *lastStatus, time.Now()) {
-		log.Info("Sync paused", "pausedUntil", lastStatus.PausedUntil)
-		return nil
+	if err := s.buildImages(ctx, sourceRepoRoot, sourceRoot, sourceCommit); err != nil {
+		return err
 	}
 
-	if lastStatus.PausedUntil != nil {
-		log.Info("Sync pause has expired", "pausedUntil", lastStatus.PausedUntil)
+	// Check whether any of the destinations are paused.
+	active := []*destination{}
+	lastStatuses := map[*destination]*v1alpha1.ManifestSyncStatus{}
+	released := map[*destination]bool{}
+	for _, d := range targets {
+		log := log.WithValues("destination", d.Name)
+		lastStatus := s.lastStatusFromManifest(filepath.Join(s.repoKeyToDir(d.destKey), d.DestPath, lastSyncFile))
+
+		// We need to take into account the current manifest and the lastStatus to deci
+		if !s.release && isPaused(ctx, *s.manifest, *lastStatus, time.Now()) {
+			if s.emergency == nil {
+				log.Info("Sync paused", "pausedUntil", lastStatus.PausedUntil)
+				s.block(pausedReason, fmt.Sprintf("Sync of destination %v is paused until %v", d.Name, lastStatus.PausedUntil))
+				continue
+			}
+			// N.B. Bypassing a pause doesn't end it; see syncDestination.
+			log.Info("Emergency sync; bypassing pause", "pausedUntil", lastStatus.PausedUntil, "pausedBy", lastStatus.PausedBy, "emergencyReason", s.emergency.reason)
+			if s.bypassedPauses == nil {
+				s.bypassedPauses = map[*destination]string{}
+			}
+			s.bypassedPauses[d] = describePause(d, lastStatus)
+		} else if lastStatus.PausedUntil != nil && !isTakeOver(*s.manifest) {
+			// The takeover has ended so force a sync to replace the manifests hydrated during the takeover even
+			// if the source and images haven't changed.
+			log.Info("Sync pause has expired; releasing takeover", "pausedUntil", lastStatus.PausedUntil)
+			released[d] = true
+		}
+		lastStatuses[d] = lastStatus
+		active = append(active, d)
 	}
 
-	// Walk the source repository and find all kustomization files.
-	kustomizeFiles, err := findKustomizationFiles(sourceRoot, sourceRepoRoot, s.manifest.Spec.ExcludeDirs, log)
-	if err != nil {
-		log.Error(err, "Failed to find kustomization files", "sourceRoot", sourceRoot)
-		return err
+	if len(active) == 0 {
+		return destinationErrors(finalErr, len(s.destinations))
 	}
 
-	allImages, filesToHydrate, err := s.findImagesToPin(kustomizeFiles)
-	if err != nil {
-		return err
+	var allImages map[util.DockerImageRef][]imageAndFile
+	var filesToHydrate []string
+	var plainManifests []*plainManifest
+	if s.manifest.Spec.SourceFormat == v1alpha1.YAMLSourceFormat {
+		plainManifests, err = findPlainManifests(sourceRoot, sourceRepoRoot, s.manifest.Spec.ExcludeDirs, s.selector, log)
+		if err != nil {
+			log.Error(err, "Failed to find manifests", "sourceRoot", sourceRoot)
+			return err
+		}
+
+		allImages, err = s.findImagesInManifests(plainManifests)
+		if err != nil {
+			return err
+		}
+	} else {
+		// Walk the source repository and find all kustomization files.
+		kustomizeFiles, err := findKustomizationFiles(sourceRoot, sourceRepoRoot, s.manifest.Spec.ExcludeDirs, log)
+		if err != nil {
+			log.Error(err, "Failed to find kustomization files", "sourceRoot", sourceRoot)
+			return err
+		}
+
+		allImages, filesToHydrate, err = s.findImagesToPin(kustomizeFiles)
+		if err != nil {
+			return err
+		}
 	}
 
 	imagesToPin := map[util.DockerImageRef]v1alpha1.Strategy{}
 
 	// find the images to pin to.
 	pinnedImages := map[util.DockerImageRef]util.DockerImageRef{}
+	// versions are the versions chosen for images pinned using the SemverStrategy.
+	versions := map[util.DockerImageRef]string{}
 
 	unResolved := []util.DockerImageRef{}
+	unsigned := []string{}
 
 	for source := range allImages {
 		// N.B. We make a copy of the tagged image because we will potentially modify its tag before
@@ -371,13 +743,52 @@ func (s *Syncer) RunOnce(force bool) error {
 		// If the image is built from source then we want to change the tag of the image
 		// to be the source commit
 		if strategy == v1alpha1.SourceCommitStrategy {
-			log.V(util.Debug).Info("image built from source", "image", source, "oldTag", source.Tag, "newTag", sourceCommit)
-			taggedImage.Tag = sourceCommit
+			log.V(util.Debug).Info("image built from source", "image", source, "oldTag", source.Tag, "newTag", sourceTag)
+			taggedImage.Tag = sourceTag
+		}
+
+		// If we are tracking the newest tag matching a pattern or a version constraint then we need to find that tag.
+		if strategy == v1alpha1.NewestMatchingTagStrategy {
+			imageToPin, _ := s.getImageTagToPin(source)
+			newest, err := s.newestMatchingTag(ctx, taggedImage, imageToPin.TagPattern)
+			if err != nil {
+				unResolved = append(unResolved, source)
+				log.Error(err, "Failed to find newest matching tag.", "image", taggedImage, "tagPattern", imageToPin.TagPattern)
+				continue
+			}
+			log.V(util.Debug).Info("Found newest matching tag", "image", source, "oldTag", source.Tag, "newTag", newest)
+			taggedImage.Tag = newest
+		}
+
+		if strategy == v1alpha1.SemverStrategy {
+			imageToPin, _ := s.getImageTagToPin(source)
+			version, err := s.highestVersion(ctx, taggedImage, imageToPin.Constraint)
+			if err != nil {
+				unResolved = append(unResolved, source)
+				log.Error(err, "Failed to find a version satisfying the constraint.", "image", taggedImage, "constraint", imageToPin.Constraint)
+				continue
+			}
+			log.Info("Found highest version satisfying constraint", "image", source, "constraint", imageToPin.Constraint, "version", version)
+			taggedImage.Tag = version
+			versions[source] = version
+		}
+
+		// If the image is pinned to the tag applied when it was promoted then resolve that tag instead.
+		if strategy == v1alpha1.PromotedTagStrategy {
+			imageToPin, _ := s.getImageTagToPin(source)
+			log.V(util.Debug).Info("Pinning promoted image", "image", source, "oldTag", source.Tag, "newTag", imageToPin.PromotionTag)
+			taggedImage.Tag = imageToPin.PromotionTag
 		}
 
 		// All strategies require calling resolveImageToSha to resolve the image
 		// to a particular sha.
-		resolved, err := s.resolveImageToSha(taggedImage, strategy)
+		resolved, err := s.resolveImageToSha(ctx, taggedImage, strategy)
+		var unsignedErr *unsignedImageError
+		if errors.As(err, &unsignedErr) {
+			unsigned = append(unsigned, unsignedErr.image)
+			log.Info("Refusing to pin unsigned image", "image", taggedImage, "resolved", unsignedErr.image)
+			continue
+		}
 		if err != nil {
 			// We want to accumulate a list of all unresolved images because its helpful to print a list of them
 			// all in the logs.
@@ -393,19 +804,10 @@ func (s *Syncer) RunOnce(force bool) error {
 		return fmt.Errorf("Not all images could be resolved; unresolved images: %v", unResolved)
 	}
 
-	// Check if the pinned images have changed.
-	changedImages := s.didImagesChange(lastStatus.PinnedImages, pinnedImages)
-
-	if sourceCommit == lastStatus.SourceCommit && len(changedImages) == 0 {
-		if !force {
-			log.Info("Sync not needed; manifests and images up to date", "sourceCommit", sourceCommit)
-			return nil
-		}
-		log.Info("Sync not needed but force is true", "sourceCommit", sourceCommit)
+	if len(unsigned) > 0 {
+		return fmt.Errorf("ImagePolicy requires signed images but the following images aren't signed by any of the trusted identities:\n%v", strings.Join(unsigned, "\n"))
 	}
 
-	log.Info("Hydrated manifests need sync", "sourceCommit", sourceCommit, "lastSync", lastStatus.SourceCommit, "changedImages", changedImages)
-
 	// Set the images in the kustomization files.
 	for source, resolved := range pinnedImages {
 		// Loop over all the files containing this image
@@ -444,10 +846,174 @@ func (s *Syncer) RunOnce(force bool) error {
 		}
 	}
 
+	if s.manifest.Spec.SourceFormat == v1alpha1.YAMLSourceFormat {
+		if err := pinImagesInManifests(plainManifests, pinnedImages); err != nil {
+			return err
+		}
+	}
+
+	// The source is the same for all destinations.
+	src := &hydrationSource{
+		commit:         sourceCommit,
+		root:           sourceRoot,
+		repoRoot:       sourceRepoRoot,
+		pinnedImages:   pinnedImages,
+		versions:       versions,
+		filesToHydrate: filesToHydrate,
+		plainManifests: plainManifests,
+	}
+	for _, d := range active {
+		err := s.syncDestination(ctx, d, lastStatuses[d], force || released[d], src)
+		if err != nil {
+			s.log.Error(err, "Failed to sync destination", "destination", d.Name)
+			finalErr.AddCause(err)
+		}
+	}
+	return destinationErrors(finalErr, len(s.destinations))
+}
+
+// reportStatus reports the result of the run as a check run on the source commit. Nothing is reported if the
+// run didn't get as far as checking out the source or if the run succeeded without needing to sync.
+func (s *syncRun) reportStatus(runErr error) {
+	if s.sourceCommit == "" || (runErr == nil && !s.needsSync) {
+		return
+	}
+	log := s.log.WithValues("sourceCommit", s.sourceCommit)
+	repo := s.manifest.Spec.SourceRepo
+	tr, err := s.transports.Get(repo.Org, repo.Repo)
+	if err != nil {
+		log.Error(err, "Failed to get transport; unable to report sync status", "org", repo.Org, "repo", repo.Repo)
+		return
+	}
+	client := ghAPI.NewClient(&http.Client{Transport: tr})
+	opts := buildSyncCheckRun(s.manifest, s.sourceCommit, s.prURLs, s.awaitingApproval, runErr)
+	check, _, err := client.Checks.CreateCheckRun(context.Background(), repo.Org, repo.Repo, opts)
+	if err != nil {
+		log.Error(err, "Failed to create check run reporting the sync status")
+		return
+	}
+	log.Info("Reported sync status", "check", check.GetHTMLURL(), "conclusion", check.GetConclusion())
+}
+
+// sendCallbacks sends the result of the run to the callbacks of the ManifestSync. Results are only sent for runs
+// that hydrated the manifests or failed so callbacks aren't notified every time the syncer checks for changes.
+// Failing to notify the callbacks doesn't fail the sync so errors are only logged.
+func (s *syncRun) sendCallbacks(start time.Time, conditions []v1alpha1.Condition, runErr error) {
+	if len(s.manifest.Spec.Callbacks) == 0 || (runErr == nil && !s.needsSync) {
+		return
+	}
+	if s.callbackSender == nil {
+		sender, err := callbacks.NewSender()
+		if err != nil {
+			s.log.Error(err, "Failed to create the sender for callbacks")
+			return
+		}
+		s.callbackSender = sender
+	}
+	result := callbacks.NewResult(v1alpha1.ManifestSyncGVK.Kind, s.manifest.Metadata, start, runErr)
+	result.SourceCommit = s.sourceCommit
+	result.PullRequests = s.prURLs
+	result.Conditions = conditions
+	// N.B. Send logs the callbacks that failed.
+	_ = s.callbackSender.Send(logr.NewContext(context.Background(), s.log), s.manifest.Spec.Callbacks, result)
+}
+
+// destinationErrors returns an error if syncing any of the destinations failed.
+func destinationErrors(errs *util.ListOfErrors, numDestinations int) error {
+	if len(errs.Causes) == 0 {
+		return nil
+	}
+	if numDestinations == 1 {
+		return errs.Causes[0]
+	}
+	errs.Final = errors.Errorf("Failed to sync %d of %d destinations", len(errs.Causes), numDestinations)
+	return errs
+}
+
+// hydrationSource is the source, with images pinned, that is hydrated into each destination.
+type hydrationSource struct {
+	commit string
+	root   string
+	// repoRoot is the root of the checkout of the source repo.
+	repoRoot string
+	// pinnedImages maps the images in the source to the images they are pinned to.
+	pinnedImages map[util.DockerImageRef]util.DockerImageRef
+	// versions are the versions chosen for images pinned using the SemverStrategy.
+	versions       map[util.DockerImageRef]string
+	filesToHydrate []string
+	plainManifests []*plainManifest
+}
+
+// syncDestination hydrates the manifests into the destination and creates a PR to merge them.
+func (s *syncRun) syncDestination(ctx context.Context, d *destination, lastStatus *v1alpha1.ManifestSyncStatus, force bool, src *hydrationSource) error {
+	log := s.log.WithValues("destination", d.Name)
+	sourceCommit := src.commit
+	sourceRoot := src.root
+	pinnedImages := src.pinnedImages
+	filesToHydrate := src.filesToHydrate
+
+	// Each destination gets its own copy of the manifest so its status is recorded independently.
+	// The spec is narrowed to the destination so the lastsync file describes what was synced to it.
+	manifest := *s.manifest
+	manifest.Spec.ForkRepo = d.ForkRepo
+	manifest.Spec.DestRepo = d.DestRepo
+	manifest.Spec.DestPath = d.DestPath
+	manifest.Spec.DestRepos = nil
+	manifest.Status.Destination = d.Name
+	m := &manifest
+
+	// An emergency sync doesn't end a pause e.g. a takeover. The pause is carried over so syncs are paused again
+	// once the emergency is rolled out.
+	if s.emergency != nil && m.Status.PausedUntil == nil && lastStatus.PausedUntil != nil && lastStatus.PausedUntil.After(time.Now()) {
+		m.Status.PausedUntil = lastStatus.PausedUntil
+		m.Status.PausedBy = lastStatus.PausedBy
+	}
+
+	// In pin only mode the source isn't hydrated; only the images pinned in the dest path are updated.
+	pinOnly := m.Spec.Hydration == v1alpha1.PinOnlyHydrationMode
+
+	// Check if the pinned images have changed.
+	changedImages := s.didImagesChange(lastStatus.PinnedImages, pinnedImages)
+
+	if (pinOnly || sourceCommit == lastStatus.SourceCommit) && len(changedImages) == 0 {
+		if !force {
+			log.Info("Sync not needed; manifests and images up to date", "sourceCommit", sourceCommit)
+			return nil
+		}
+		log.Info("Sync not needed but force is true", "sourceCommit", sourceCommit)
+	}
+
+	s.needsSync = true
+	log.Info("Hydrated manifests need sync", "sourceCommit", sourceCommit, "lastSync", lastStatus.SourceCommit, "changedImages", changedImages)
+
+	// N.B. Check the version skew before modifying the fork so a failure leaves it untouched.
+	skewed := !pinOnly && versionSkewed(lastStatus.HydrosVersion, s.hydrosVersion)
+	if skewed {
+		if m.Spec.VersionSkew == v1alpha1.FailGuardAction && !force {
+			err := fmt.Errorf("Manifests were last hydrated by hydros %v but this is hydros %v; force the sync to hydrate them with this version", lastStatus.HydrosVersion, s.hydrosVersion)
+			log.Error(err, "Version skew", "lastVersion", lastStatus.HydrosVersion, "version", s.hydrosVersion)
+			s.block(versionSkewReason, err.Error())
+			return err
+		}
+		log.Info("Manifests were last hydrated by a different version of hydros; changes may be due to the version", "lastVersion", lastStatus.HydrosVersion, "version", s.hydrosVersion)
+	}
+
+	// N.B. Compute all the target paths before modifying the fork so collisions fail the sync before anything
+	// is deleted.
+	var targets []hydrateTarget
+	if !pinOnly {
+		var err error
+		targets, err = computeHydrateTargets(s.manifest.Spec.DestLayout, sourceRoot, filesToHydrate)
+		if err != nil {
+			log.Error(err, "Failed to compute the target paths of the kustomizations")
+			return err
+		}
+	}
+
 	// Create a local branch from the fork repo
-	forkDir := filepath.Join(s.workDir, forkKey)
+	forkDir := s.repoKeyToDir(d.forkKey)
 	// N.B We check out the branch of the destination repo.
-	cmd := exec.Command("git", "checkout", "-B", s.manifest.Spec.ForkRepo.Branch, "origin/"+s.manifest.Spec.DestRepo.Branch)
+	cmd := exec.Command("git", "checkout", "-B", d.ForkRepo.Branch, "origin/"+d.DestRepo.Branch)
 	cmd.Dir = forkDir
 
 	if err := s.execHelper.Run(cmd); err != nil {
@@ -455,8 +1021,244 @@ func (s *Syncer) RunOnce(force bool) error {
 		return err
 	}
 
+	baseHydratePath := filepath.Join(forkDir, d.DestPath)
+	report := &kustomize2.Report{}
+	commitMessage := fmt.Sprintf("Update hydrated manifests to %v", sourceCommit)
+	if pinOnly {
+		replacements := repinImages(lastStatus.PinnedImages, pinnedImages, log)
+		changedFiles, err := pinImagesInDest(baseHydratePath, replacements, log)
+		if err != nil {
+			log.Error(err, "Failed to update the images pinned in the dest path")
+			return err
+		}
+		if len(changedFiles) == 0 {
+			log.Info("Sync not needed; none of the images pinned in the dest path changed", "changedImages", changedImages)
+			return nil
+		}
+		log.Info("Updated pinned images", "files", changedFiles)
+
+		// The manifests weren't hydrated so the status still records the source they were hydrated from.
+		m.Status.SourceCommit = lastStatus.SourceCommit
+		m.Status.SourceURL = lastStatus.SourceURL
+		m.Status.HydrosVersion = lastStatus.HydrosVersion
+		m.Status.PinnedImages = updatePinnedStatus(lastStatus.PinnedImages, pinnedImages, src.versions)
+		commitMessage = "Update images pinned in hydrated manifests"
+	} else {
+		if err := s.hydrateDestination(d, baseHydratePath, targets, src, report); err != nil {
+			return err
+		}
+
+		// Write the updated manifest to the dest
+		m.Status.SourceCommit = sourceCommit
+		m.Status.PinnedImages = []v1alpha1.PinnedImage{}
+		sourceRepo := m.Spec.SourceRepo
+		sourceURL := fmt.Sprintf("https://github.com/%v/%v/tree/%v", sourceRepo.Org, sourceRepo.Repo, sourceCommit)
+		m.Status.SourceURL = sourceURL
+		m.Status.HydrosVersion = s.hydrosVersion
+		for old, new := range pinnedImages {
+			m.Status.PinnedImages = append(m.Status.PinnedImages, v1alpha1.PinnedImage{
+				Image:    old.ToURL(),
+				NewImage: new.ToURL(),
+				Version:  src.versions[old],
+			})
+		}
+	}
+
+	// N.B. The policies are checked before the lastsync file is written so it isn't one of their inputs.
+	policyViolations, err := checkPolicies(logr.NewContext(ctx, log), src.repoRoot, baseHydratePath, m.Spec.Policies)
+	if err != nil {
+		log.Error(err, "Failed to check the hydrated manifests against the policies")
+		return err
+	}
+	if len(policyViolations) > 0 {
+		log.Info("Hydrated manifests violate the policies", "violations", len(policyViolations))
+	}
+
+	newSyncFile := filepath.Join(baseHydratePath, lastSyncFile)
+	w, err := os.Create(newSyncFile)
+	if err != nil {
+		log.Error(err, "Failed to write manifest", "path", newSyncFile)
+		return err
+	}
+	e := yaml.NewEncoder(w)
+	e.SetIndent(2)
+	if err := e.Encode(m); err != nil {
+		log.Error(err, "Failed to update manifest", "path", newSyncFile)
+		return err
+	}
+
+	// Check the hydrated files before committing them so binaries and large files don't end up in the dest repo.
+	violations, err := checkFileGuards(baseHydratePath, m.Spec.FileGuards)
+	if err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		if m.Spec.FileGuards.Fails() {
+			err := fmt.Errorf("Hydrated manifests include binaries or large files: %v", strings.Join(violations, "; "))
+			log.Error(err, "Hydrated files violate the file guards", "violations", violations)
+			s.block(fileGuardViolationsReason, err.Error())
+			return err
+		}
+		log.Info("Hydrated files violate the file guards; committing them anyway", "violations", violations)
+	}
+
+	if m.Spec.Apply.ApplyOnly() {
+		// N.B. The manifests aren't committed so the violations can only be reported by failing the sync.
+		if err := report.ValidationErr(); err != nil {
+			log.Error(err, "Hydrated manifests failed validation; they won't be applied")
+			s.block(validationFailedReason, err.Error())
+			return err
+		}
+		if len(policyViolations) > 0 && m.Spec.Policies.Fails() {
+			err := fmt.Errorf("Hydrated manifests violate %d policies", len(policyViolations))
+			log.Error(err, "Hydrated manifests violate the policies; they won't be applied", "violations", policyViolations)
+			s.block(policyViolationsReason, err.Error())
+			return err
+		}
+		if err := s.applyManifests(logr.NewContext(ctx, log), m, baseHydratePath); err != nil {
+			return err
+		}
+		log.Info("Sync succeeded; the hydrated manifests were applied without committing them")
+		return nil
+	}
+
+	appPath, err := writeApplication(forkDir, m, d.Destination)
+	if err != nil {
+		log.Error(err, "Failed to write the ArgoCD Application")
+		return err
+	}
+
+	// Commit and push the changes.
+	commands := [][]string{
+		{"git", "add", "."},
+		{"git", "commit", "-m", commitMessage},
+		{"git", "push", "-f", "-u", "origin", "HEAD"},
+	}
+	if m.Spec.CommitPerOverlay {
+		// N.B. The Application isn't in destPath so it isn't committed with the overlays.
+		if err := s.commitApplication(forkDir, appPath); err != nil {
+			log.Error(err, "Failed to commit the ArgoCD Application")
+			return err
+		}
+		if err := s.commitPerOverlay(forkDir, d.DestPath, m.Status.SourceCommit); err != nil {
+			log.Error(err, "Failed to commit the hydrated manifests of each overlay")
+			return err
+		}
+		commands = commands[2:]
+	}
+	for _, c := range commands {
+		cmd := exec.Command(c[0],
+			c[1:]...,
+		)
+		cmd.Dir = forkDir
+		if err := s.execHelper.Run(cmd); err != nil {
+			return err
+		}
+
+	}
+
+	// Create the PR.
+	prMessage := buildPrMessage(m, changedImages)
+	if table := report.Markdown(); table != "" {
+		prMessage += "\n\n" + table
+	}
+	if warnings := buildFileGuardWarnings(violations); warnings != "" {
+		prMessage += "\n\n" + warnings
+	}
+	if table := buildPolicyViolations(policyViolations, m.Spec.Policies.Fails()); table != "" {
+		prMessage += "\n\n" + table
+	}
+	if skewed {
+		prMessage += "\n\n" + buildVersionSkewWarning(lastStatus.HydrosVersion, s.hydrosVersion)
+	}
+	if s.emergency != nil {
+		prMessage += "\n\n" + buildEmergencyNote(s.emergency, s.bypassedPauses[d])
+	}
+
+	// N.B. The summary only makes the PR easier to review so failing to compute it doesn't fail the sync.
+	if stats, err := diffstat(forkDir, d.DestRepo.Branch, d.DestPath); err != nil {
+		log.Error(err, "Failed to compute the changes by overlay")
+	} else if summary := buildDiffstat(stats); summary != "" {
+		prMessage += "\n\n" + summary
+	}
+
+	pr, err := d.repoHelper.CreatePr(prMessage, m.Spec.PrLabels)
+	if err != nil {
+		log.Error(err, "Failed to create pr")
+		return err
+	}
+	s.prURLs = append(s.prURLs, pr.URL)
+	s.requestCodeOwners(d, forkDir, pr.Number)
+	// N.B. The PR may have been created by an earlier run in which case its description doesn't record the
+	// bypass so it is recorded in a comment.
+	if bypassed, ok := s.bypassedPauses[d]; ok && s.emergency != nil {
+		if err := d.repoHelper.CommentOnPR(pr.Number, buildEmergencyNote(s.emergency, bypassed)); err != nil {
+			log.Error(err, "Failed to record the emergency sync on the PR", "pr", pr.URL, "number", pr.Number)
+		}
+	}
+
+	// N.B. The PR is still created when the validating functions find violations so the report of the
+	// violations is posted on it but it isn't merged.
+	if err := report.ValidationErr(); err != nil {
+		log.Error(err, "Hydrated manifests failed validation; the PR won't be merged", "pr", pr.URL, "number", pr.Number)
+		s.block(validationFailedReason, err.Error())
+		return err
+	}
+	if len(policyViolations) > 0 && m.Spec.Policies.Fails() {
+		err := fmt.Errorf("Hydrated manifests violate %d policies", len(policyViolations))
+		log.Error(err, "Hydrated manifests violate the policies; the PR won't be merged", "pr", pr.URL, "number", pr.Number)
+		s.block(policyViolationsReason, err.Error())
+		return err
+	}
+
+	if m.Spec.Merge.RequiresApproval() {
+		log.Info("PR created; it will be merged by a later sync once it is approved", "pr", pr.URL, "number", pr.Number)
+		s.setLifecycleLabel(d, pr.Number, github.AwaitingApprovalLabel)
+		s.awaitingApproval = true
+		s.block(awaitingApprovalReason, fmt.Sprintf("PR %v is waiting for approval", pr.URL))
+		return nil
+	}
+
+	// EnableAutoMerge or merge the PR automatically. If you don't want the PR to be automerged you should
+	// set up appropriate branch protections e.g. require approvers.
+	// Wait up to 1 minute to try to merge the PR
+	// TODO(jeremy): This is mostly for dev takeover in the event we can't rely on automerge e.g. because
+	// we have a private repository for which we can't enable automerge. In this case we want to wait and
+	// retry the merge until its merged or we timeout. We may want to refactor this code so we can invoke the
+	// polling only in the case of being called with the takeover command.
+	// If the PR can't be merged does it make sense to report an error?  in the case of long running tests
+	// The syncer can return and the PR will be merged either 1) when syncer is rerun or 2) by auto merge if enabled
+	// The desired behavior is potentially different in the takeover and non takeover setting.
+	state, err := s.mergePR(d, pr.Number, 1*time.Minute)
+	s.setLifecycleLabel(d, pr.Number, github.LifecycleLabelForState(state))
+	if err != nil {
+		log.Error(err, "Failed to merge pr", "number", pr.Number, "url", pr.URL)
+		return err
+	}
+	if state == github.EnqueuedState && s.mergeQueueEvents {
+		log.Info("PR is in the merge queue; the sync will resume when the merge queue finishes", "pr", pr.URL, "number", pr.Number)
+		s.waitFor(inMergeQueueReason, fmt.Sprintf("PR %v is in the merge queue", pr.URL))
+		return nil
+	}
+	if state != github.MergedState && state != github.ClosedState {
+		return fmt.Errorf("Failed to merge pr; state: %v", state)
+	}
+
+	if state == github.MergedState {
+		if err := s.applyDestination(ctx, d); err != nil {
+			return err
+		}
+	}
+
+	log.Info("Sync succeeded")
+	return nil
+}
+
+// hydrateDestination replaces the manifests in baseHydratePath, the dest path in the fork, with the hydrated
+// source and applies the kustomize functions to them.
+func (s *syncRun) hydrateDestination(d *destination, baseHydratePath string, targets []hydrateTarget, src *hydrationSource, report *kustomize2.Report) error {
+	log := s.log.WithValues("destination", d.Name)
 	// Delete the target directory
-	baseHydratePath := filepath.Join(forkDir, s.manifest.Spec.DestPath)
 	if _, err := os.Stat(baseHydratePath); err == nil || os.IsExist(err) {
 		log.V(util.Debug).Info("Deleting dest path", "destPath", baseHydratePath)
 		if err := os.RemoveAll(baseHydratePath); err != nil {
@@ -469,118 +1271,193 @@ func (s *Syncer) RunOnce(force bool) error {
 		return errors.Wrapf(err, "Failed to create directory: %v", baseHydratePath)
 	}
 
+	// With the flatten layout each kustomization is hydrated into its own directory and then moved into the dest
+	// path so that kustomizations producing the same file are detected.
+	var flattened flattenedFiles
+	if s.manifest.Spec.DestLayout.GetType() == v1alpha1.FlattenDestLayout {
+		flattened = flattenedFiles{}
+	}
+
 	// Hydrate overlay dirs
-	log.Info("Hydrating kustomizations", "kustomizations", filesToHydrate)
-	for _, k := range filesToHydrate {
-		targetPath, err := kustomize2.GenerateTargetPath(sourceRoot, k)
-		if err != nil {
-			log.Error(err, "Failed to generate target path", "kustomization", k)
+	log.Info("Hydrating kustomizations", "kustomizations", src.filesToHydrate)
+	for _, t := range targets {
+		k := t.kustomization
+		hydratePath := filepath.Join(baseHydratePath, t.dir)
+		outputPath := hydratePath
+		if flattened != nil {
+			dir, err := os.MkdirTemp(filepath.Dir(baseHydratePath), ".hydros-flatten-")
+			if err != nil {
+				return errors.Wrapf(err, "Failed to create directory to hydrate %v", k)
+			}
+			defer os.RemoveAll(dir)
+			outputPath = dir
+		}
+
+		log.V(util.Debug).Info("Create kustomize output dir", "dir", hydratePath)
+		if err := os.MkdirAll(hydratePath, util.FilePermUserGroup); err != nil {
+			return errors.Wrapf(err, "Failed to create directory: %v", hydratePath)
+		}
+
+		overlayDir := path.Dir(k)
+		cmd := exec.Command("kustomize", "build", "--enable-helm", "--load-restrictor=LoadRestrictionsNone", "-o", outputPath, overlayDir)
+
+		if err := s.execHelper.Run(cmd); err != nil {
+			log.Error(err, "Failed to hydrate kustomization", "overlayDir", overlayDir, "output", outputPath)
+			return err
+		}
+		if flattened != nil {
+			if err := flattened.add(k, outputPath, hydratePath); err != nil {
+				return err
+			}
+		}
+		log.Info("Successfully hydrated package", "kustomization", k)
+	}
+
+	if s.manifest.Spec.SourceFormat == v1alpha1.YAMLSourceFormat {
+		log.Info("Copying manifests", "numFiles", len(src.plainManifests))
+		if err := writePlainManifests(baseHydratePath, src.plainManifests); err != nil {
 			return err
 		}
+	}
 
-		hydratePath := filepath.Join(baseHydratePath, targetPath.Dir)
+	if err := s.applyKustomizeFns(baseHydratePath, src.root, src.filesToHydrate, report); err != nil {
+		log.Error(err, "applyKustomizeFns failed")
+		return err
+	}
+	return nil
+}
+
+// mergeExistingPR checks if there is a PR already pending from the fork branch of the destination. If there is
+// it tries to merge it and returns an error if it can't be merged because it would block the sync.
+// It returns false if the destination shouldn't be synced because the PR is waiting to be approved; this isn't
+// an error.
+func (s *syncRun) mergeExistingPR(d *destination) (bool, error) {
+	log := s.log.WithValues("destination", d.Name)
+	// If the fork is in a different repo then the head reference is OWNER:BRANCH
+	// If we are creating the PR from a different branch in the same repo as where we are creating
+	// the PR then we just use BRANCH as the ref
+	headBranchRef := d.ForkRepo.Branch
+
+	if d.ForkRepo.Org != d.DestRepo.Org {
+		headBranchRef = d.ForkRepo.Org + ":" + headBranchRef
+	}
+	existingPR, err := d.repoHelper.PullRequestForBranch()
+	if err != nil {
+		log.Error(err, "Failed to check if there is an existing PR", "headBranchRef", headBranchRef)
+		return false, err
+	}
+
+	if existingPR == nil {
+		return true, nil
+	}
 
-		if _, err := os.Stat(hydratePath); os.IsExist(err) {
-			newErr := fmt.Errorf("Hydrated path already exists; %v; kustomization:%v", hydratePath, k)
-			log.Error(newErr, "Hydrated directory already exists; This indicates two kustomizations are trying to hydrate the same package", "hydratePath", hydratePath, "kustomization", k)
-			return newErr
+	if merge := s.manifest.Spec.Merge; merge.RequiresApproval() {
+		approved, reason, err := d.repoHelper.CheckApproval(existingPR.Number, merge.RequiredApprovals(), merge.Labels)
+		if err != nil {
+			log.Error(err, "Failed to check if the existing PR is approved", "pr", existingPR.URL)
+			return false, err
 		}
-
-		log.V(util.Debug).Info("Create kustomize output dir", "dir", hydratePath)
-		if err := os.MkdirAll(hydratePath, util.FilePermUserGroup); err != nil {
-			return errors.Wrapf(err, "Failed to create directory: %v", hydratePath)
+		if !approved {
+			log.Info("PR is waiting for approval; skipping sync until it is approved and merged", "pr", existingPR.URL, "reason", reason)
+			s.setLifecycleLabel(d, existingPR.Number, github.AwaitingApprovalLabel)
+			s.block(awaitingApprovalReason, fmt.Sprintf("PR %v is waiting for approval: %v", existingPR.URL, reason))
+			return false, nil
 		}
+		log.Info("PR has been approved", "pr", existingPR.URL)
+	}
 
-		overlayDir := path.Dir(k)
-		cmd := exec.Command("kustomize", "build", "--enable-helm", "--load-restrictor=LoadRestrictionsNone", "-o", hydratePath, overlayDir)
+	log.Info("PR Already Exists; attempting to merge it.", "pr", existingPR.URL)
+	state, err := s.mergePR(d, existingPR.Number, 3*time.Minute)
+	label := github.LifecycleLabelForState(state)
+	if label == github.BlockedLabel && s.isSuperseded(existingPR) {
+		label = github.SupersededLabel
+	}
+	s.setLifecycleLabel(d, existingPR.Number, label)
 
-		if err := s.execHelper.Run(cmd); err != nil {
-			log.Error(err, "Failed to hydrate kustomization", "overlayDir", overlayDir, "output", hydratePath)
-			return err
-		}
-		log.Info("Successfully hydrated package", "kustomization", k)
+	if err != nil {
+		log.Error(err, "Failed to Merge existing PR unable to continue with sync", "number", existingPR.Number, "pr", existingPR.URL)
+		return false, err
 	}
 
-	// Write the updated manifest to the dest
-	s.manifest.Status.SourceCommit = sourceCommit
-	s.manifest.Status.PinnedImages = []v1alpha1.PinnedImage{}
-	sourceRepo := s.manifest.Spec.SourceRepo
-	sourceURL := fmt.Sprintf("https://github.com/%v/%v/tree/%v", sourceRepo.Org, sourceRepo.Repo, sourceCommit)
-	s.manifest.Status.SourceURL = sourceURL
-	for old, new := range pinnedImages {
-		s.manifest.Status.PinnedImages = append(s.manifest.Status.PinnedImages, v1alpha1.PinnedImage{
-			Image:    old.ToURL(),
-			NewImage: new.ToURL(),
-		})
+	if state == github.EnqueuedState && s.mergeQueueEvents {
+		log.Info("PR is in the merge queue; skipping sync until the merge queue finishes", "pr", existingPR.URL)
+		s.waitFor(inMergeQueueReason, fmt.Sprintf("PR %v is in the merge queue", existingPR.URL))
+		return false, nil
 	}
 
-	err = s.applyKustomizeFns(baseHydratePath, sourceRoot, filesToHydrate)
+	if state != github.ClosedState && state != github.MergedState {
+		log.Info("PR hasn't been merged; unable to continue with the sync", "number", existingPR.Number, "pr", existingPR.URL, "state", state)
+		s.block(prBlockedReason, fmt.Sprintf("PR %v can't be merged; state: %v", existingPR.URL, state))
+		return false, errors.Errorf("Existing PR %v is blocking sync", existingPR.URL)
+	}
+	return true, nil
+}
 
-	if err != nil {
-		log.Error(err, "applyKustomizeFns failed")
-		return err
+// mergePR merges the PR waiting up to timeout for it to be merged. If the syncer is notified when merge queues
+// finish it doesn't wait for PRs in a merge queue.
+func (s *syncRun) mergePR(d *destination, number int, timeout time.Duration) (github.PRMergeState, error) {
+	if s.mergeQueueEvents {
+		return d.repoHelper.MergeOrEnqueue(number, timeout)
 	}
+	return d.repoHelper.MergeAndWait(number, timeout)
+}
 
-	newSyncFile := filepath.Join(baseHydratePath, lastSyncFile)
-	w, err := os.Create(newSyncFile)
+// requestCodeOwners requests reviews of the PR from the owners of DestPath in the CODEOWNERS file of the dest repo
+// so the owners of the environment are always looped in. Failing to request reviewers is logged but doesn't fail
+// the sync.
+func (s *syncRun) requestCodeOwners(d *destination, forkDir string, number int) {
+	log := s.log.WithValues("destination", d.Name, "number", number)
+	owners, err := func() ([]string, error) {
+		c, err := github.ReadCodeOwners(forkDir)
+		if err != nil {
+			return nil, err
+		}
+		return c.OwnersOfDir(forkDir, d.DestPath)
+	}()
 	if err != nil {
-		log.Error(err, "Failed to write manifest", "path", newSyncFile)
-		return err
+		log.Error(err, "Failed to determine the code owners of the destPath", "destPath", d.DestPath)
+		return
 	}
-	e := yaml.NewEncoder(w)
-	e.SetIndent(2)
-	if err := e.Encode(s.manifest); err != nil {
-		log.Error(err, "Failed to update manifest", "path", newSyncFile)
-		return err
+	if len(owners) == 0 {
+		return
 	}
-
-	// Commit and push the changes.
-	commands := [][]string{
-		{"git", "add", "."},
-		{"git", "commit", "-m", fmt.Sprintf("Update hydrated manifests to %v", sourceCommit)},
-		{"git", "push", "-f", "-u", "origin", "HEAD"},
+	if err := d.repoHelper.RequestReviewers(number, owners); err != nil {
+		log.Error(err, "Failed to request reviews from code owners", "owners", owners)
 	}
-	for _, c := range commands {
-		cmd := exec.Command(c[0],
-			c[1:]...,
-		)
-		cmd.Dir = forkDir
-		if err := s.execHelper.Run(cmd); err != nil {
-			return err
-		}
+}
 
+// setLifecycleLabel sets the lifecycle label of a PR for the destination. Failing to set the label is logged but
+// doesn't fail the sync.
+func (s *syncRun) setLifecycleLabel(d *destination, number int, label string) {
+	if err := d.repoHelper.SetLifecycleLabel(number, label); err != nil {
+		s.log.Error(err, "Failed to set PR lifecycle label", "destination", d.Name, "number", number, "label", label)
 	}
+}
 
-	// Create the PR.
-	prMessage := buildPrMessage(s.manifest, changedImages)
+// isSuperseded returns true if the source branch has commits that aren't included in the PR.
+// The PR title includes the source commit it was hydrated from; see buildPrMessage.
+func (s *syncRun) isSuperseded(pr *github.PullRequest) bool {
+	latest := s.latestSourceCommit()
+	return latest != "" && !strings.Contains(pr.Title, "@"+latest)
+}
 
-	pr, err := s.repoHelper.CreatePr(prMessage, s.manifest.Spec.PrLabels)
+// latestSourceCommit returns the head commit of the source branch on GitHub. It returns the empty string if the
+// commit can't be determined.
+func (s *syncRun) latestSourceCommit() string {
+	repo := s.manifest.Spec.SourceRepo
+	log := s.log.WithValues("org", repo.Org, "repo", repo.Repo, "branch", repo.Branch)
+	tr, err := s.transports.Get(repo.Org, repo.Repo)
 	if err != nil {
-		log.Error(err, "Failed to create pr")
-		return err
+		log.Error(err, "Failed to get transport; unable to determine the latest source commit")
+		return ""
 	}
-
-	// EnableAutoMerge or merge the PR automatically. If you don't want the PR to be automerged you should
-	// set up appropriate branch protections e.g. require approvers.
-	// Wait up to 1 minute to try to merge the PR
-	// TODO(jeremy): This is mostly for dev takeover in the event we can't rely on automerge e.g. because
-	// we have a private repository for which we can't enable automerge. In this case we want to wait and
-	// retry the merge until its merged or we timeout. We may want to refactor this code so we can invoke the
-	// polling only in the case of being called with the takeover command.
-	// If the PR can't be merged does it make sense to report an error?  in the case of long running tests
-	// The syncer can return and the PR will be merged either 1) when syncer is rerun or 2) by auto merge if enabled
-	// The desired behavior is potentially different in the takeover and non takeover setting.
-	state, err := s.repoHelper.MergeAndWait(pr.Number, 1*time.Minute)
+	client := ghAPI.NewClient(&http.Client{Transport: tr})
+	branch, _, err := client.Repositories.GetBranch(context.Background(), repo.Org, repo.Repo, repo.Branch, false)
 	if err != nil {
-		log.Error(err, "Failed to merge pr", "number", pr.Number, "url", pr.URL)
-		return err
-	}
-	if state != github.MergedState && state != github.ClosedState {
-		return fmt.Errorf("Failed to merge pr; state: %v", state)
+		log.Error(err, "Failed to get branch; unable to determine the latest source commit")
+		return ""
 	}
-
-	log.Info("Sync succeeded")
-	return nil
+	return branch.GetCommit().GetSHA()
 }
 
 // PushLocal commits any changes in wDir and then pushes those changes to the branch of the sourceRepo
@@ -698,7 +1575,7 @@ func (s *Syncer) repoKeyToDir(name string) string {
 }
 
 // cloneRepos clones all the repos
-func (s *Syncer) cloneRepos() error {
+func (s *syncRun) cloneRepos() error {
 	log := s.log
 	// Clone the repos if its not already cloned.
 	for name, repoSpec := range getRepos(*s.manifest) {
@@ -749,10 +1626,11 @@ func (s *Syncer) cloneRepos() error {
 			{"git", "config", "user.email", "hydros@notvalid.primer.ai"},
 			{"git", "remote", "set-url", "origin", url},
 			{"git", "fetch", "origin"},
-			// if we don't force code.abbrev to be 7 digits then we might get a variable
+			// if we don't force code.abbrev to a fixed number of digits then we might get a variable
 			// number. We need the short hash to be consistent with the docker image
 			// tag otherwise we will fail to resolve images.
-			{"git", "config", "--local", "--add", "core.abbrev", "7"},
+			// N.B. use --replace-all rather than --add so that changing the length doesn't leave multiple values.
+			{"git", "config", "--local", "--replace-all", "core.abbrev", strconv.Itoa(s.manifest.Spec.SourceCommitTag.GetAbbrevLength())},
 		}
 
 		if err := s.execHelper.RunCommands(commands, func(cmd *exec.Cmd) {
@@ -770,11 +1648,11 @@ func (s *Syncer) cloneRepos() error {
 		cmd.Dir = fullDir
 		// N.B. use RunQuietly because we don't want to spam the logs when everything is working correctly.
 		if data, err := s.execHelper.RunQuietly(cmd); err != nil {
-			if name == forkKey {
+			if isForkKey(name) {
 				// The checkout will fail if the origin branch doesn't already exist. This is fine.
 				// It means the manifests are out of sync and we will create the branch below.
 				log.V(util.Debug).Info("Ignoring failed checkout of forked branch; assuming it doesn't exist")
-			} else if name == destKey {
+			} else if isDestKey(name) {
 				log.Error(err, "git checkout failed; the branch to merge the PR into doesn't exist. This usually means this is a new branch and you need to create it manually", "command", cmd.String(), "output", data)
 				return err
 			} else {
@@ -825,6 +1703,92 @@ func (s *Syncer) didImagesChange(lastSync []v1alpha1.PinnedImage, current map[ut
 	return changed
 }
 
+var _ Reconciler = &Syncer{}
+
+// SyncerName returns the name of the reconciler for the ManifestSync with the given name.
+func SyncerName(name string) string {
+	return "syncer-" + name
+}
+
+// Name returns the name of the Syncer. It implements the Reconciler interface so Syncers can be run by
+// the Manager.
+func (s *Syncer) Name() string {
+	return SyncerName(s.manifest.Metadata.Name)
+}
+
+// GetSyncPeriod returns how often the ManifestSync should be resynced i.e. spec.syncPeriod if it is set.
+func (s *Syncer) GetSyncPeriod(defaultPeriod time.Duration) time.Duration {
+	return s.manifest.GetSyncPeriod(defaultPeriod)
+}
+
+// SourceRepo returns the repository the manifests are synced from.
+func (s *Syncer) SourceRepo() v1alpha1.GitHubRepo {
+	return s.manifest.Spec.SourceRepo
+}
+
+// ForkBranches returns the fork branches the syncer pushes to. Each branch is identified as
+// "<org>/<repo>/<branch>".
+func (s *Syncer) ForkBranches() []string {
+	branches := []string{}
+	for _, d := range s.manifest.Destinations() {
+		branches = append(branches, fmt.Sprintf("%v/%v/%v", d.ForkRepo.Org, d.ForkRepo.Repo, d.ForkRepo.Branch))
+	}
+	for _, e := range s.environments {
+		branches = append(branches, e.ForkBranches()...)
+	}
+	return branches
+}
+
+// DestRepos returns the repositories and branches the syncer opens PRs against.
+func (s *Syncer) DestRepos() []v1alpha1.GitHubRepo {
+	repos := []v1alpha1.GitHubRepo{}
+	for _, d := range s.manifest.Destinations() {
+		repos = append(repos, d.DestRepo)
+	}
+	for _, e := range s.environments {
+		repos = append(repos, e.DestRepos()...)
+	}
+	return repos
+}
+
+// WatchesImage returns true if a push of the image could change the images pinned by the syncer.
+func (s *Syncer) WatchesImage(image util.DockerImageRef) bool {
+	return matchesRegistries(s.manifest.Spec.ImageRegistries, image.Registry)
+}
+
+// SyncEvent is the event for a sync triggered by a push to the source repository.
+type SyncEvent struct {
+	// Commit is the commit that was pushed.
+	Commit string
+}
+
+// ImagePushedEvent is the event for a sync triggered by a push of an image to a registry.
+type ImagePushedEvent struct {
+	Image util.DockerImageRef
+}
+
+// MergeQueueEvent is the event for a sync resumed because the merge queue of one of its destinations finished.
+type MergeQueueEvent struct {
+	// Repo is the destination repository as "<org>/<repo>".
+	Repo string
+	// Branch is the branch the merge queue merges into.
+	Branch string
+}
+
+// Run runs the syncer once. event is a SyncEvent, an ImagePushedEvent, a MergeQueueEvent or nil for periodic
+// resyncs.
+func (s *Syncer) Run(anyEvent any) error {
+	switch event := anyEvent.(type) {
+	case SyncEvent:
+		s.log.Info("Sync triggered by push to source", "commit", event.Commit)
+	case ImagePushedEvent:
+		s.log.Info("Sync triggered by image push", "image", event.Image.ToURL())
+	case MergeQueueEvent:
+		s.log.Info("Sync resumed because the merge queue finished", "repo", event.Repo, "branch", event.Branch)
+	}
+	return s.RunOnce(false)
+}
+
 // RunPeriodically runs periodically with the specified period.
 func (s *Syncer) RunPeriodically(period time.Duration) {
 	for {
@@ -838,7 +1802,7 @@ func (s *Syncer) RunPeriodically(period time.Duration) {
 }
 
 // lastStatusFromManifest reads the commit of the source from a YAML file containing a ManifestSync object
-func (s *Syncer) lastStatusFromManifest(syncFile string) *v1alpha1.ManifestSyncStatus {
+func (s *syncRun) lastStatusFromManifest(syncFile string) *v1alpha1.ManifestSyncStatus {
 	lastStatus := &v1alpha1.ManifestSyncStatus{
 		PinnedImages: []v1alpha1.PinnedImage{},
 	}
@@ -852,19 +1816,18 @@ func (s *Syncer) lastStatusFromManifest(syncFile string) *v1alpha1.ManifestSyncS
 	r, err := os.Open(syncFile)
 	if err != nil {
 		// Just force a sync
-		log.Error(err, "Could not read sync file", "syncFile")
+		log.Error(err, "Could not read sync file; a full sync will be run", "syncFile", syncFile)
 		return lastStatus
 	}
+	defer r.Close()
 
-	d := yaml.NewDecoder(r)
-
-	lastSync := &v1alpha1.ManifestSync{}
-	if err := d.Decode(lastSync); err != nil {
-		log.Error(err, "Could not decode ManifestSync")
-		return lastStatus
+	status, problems := parseLastStatus(r)
+	for _, p := range problems {
+		// N.B. Problems mean parts of the last status are missing which can trigger a sync that isn't needed so
+		// they are logged as errors to make them visible.
+		log.Error(p, "Problem parsing the status in the sync file; fields that couldn't be parsed are ignored", "syncFile", syncFile)
 	}
-
-	return &lastSync.Status
+	return status
 }
 
 // setPausedUntil checks for the annotation PauseAnnotation and sets the status to paused until the specified time
@@ -884,10 +1847,11 @@ func setPausedUntil(s *v1alpha1.ManifestSync) error {
 		return errors.Wrapf(err, "Failed to unmarshal the value of annotations %v; value %v", v1alpha1.PauseAnnotation, timeJson)
 	}
 	s.Status.PausedUntil = t
+	s.Status.PausedBy = s.Metadata.Annotations[v1alpha1.TakeoverByAnnotation]
 	return nil
 }
 
-func (s *Syncer) getSourceCommit() string {
+func (s *syncRun) getSourceCommit() string {
 	log := s.log
 	// Get the latest commit on the source repo
 	cmd := exec.Command("git", "rev-parse", "origin/"+s.manifest.Spec.SourceRepo.Branch)
@@ -910,7 +1874,7 @@ type imageAndFile struct {
 	Kustomization string
 }
 
-func (s *Syncer) resetBranch(repoDir string) error {
+func (s *syncRun) resetBranch(repoDir string) error {
 	// Stash any changes
 	cmd := exec.Command("git", "stash", "save", "--keep-index", "--include-untracked")
 	cmd.Dir = repoDir
@@ -939,11 +1903,74 @@ func (s *Syncer) resetBranch(repoDir string) error {
 // resolveImageToSha resolves the provided DockerImageRef to an image and gets the sha.
 // If the image isn't found err will be an AwsError with code ecr.ErrCodeImageNotFoundException.
 // See http://docs.aws.amazon.com/AmazonS3/latest/API/ErrorResponses.html for example of how to process it.
-func (s *Syncer) resolveImageToSha(r util.DockerImageRef, strategy v1alpha1.Strategy) (util.DockerImageRef, error) {
+// If the ImagePolicy requires signatures and the resolved image isn't signed, err will be an *unsignedImageError.
+// Cancelling ctx aborts the resolution.
+func (s *syncRun) resolveImageToSha(ctx context.Context, r util.DockerImageRef, strategy v1alpha1.Strategy) (util.DockerImageRef, error) {
+	if err := ctx.Err(); err != nil {
+		return r, errors.Wrapf(err, "Resolving image %v was aborted", r.ToURL())
+	}
+	resolved, err := s.lookupImageSha(ctx, r, strategy)
+	if err != nil {
+		return resolved, err
+	}
+	if err := s.verifySignature(ctx, resolved); err != nil {
+		return resolved, err
+	}
+	return resolved, nil
+}
+
+// unsignedImageError indicates an image isn't signed by any of the identities trusted by the ImagePolicy.
+type unsignedImageError struct {
+	image string
+}
+
+func (e *unsignedImageError) Error() string {
+	return fmt.Sprintf("image %v isn't signed by any of the trusted identities", e.image)
+}
+
+// verifySignature verifies the digest of the image is signed by one of the identities in the ImagePolicy.
+// It is a no-op if the policy doesn't require signatures. Signatures are verified using the cosign CLI.
+func (s *syncRun) verifySignature(ctx context.Context, image util.DockerImageRef) error {
+	policy := s.manifest.Spec.ImagePolicy
+	if policy == nil || !policy.RequireSignature {
+		return nil
+	}
+	log := s.log
+	ref := image.Registry + "/" + image.Repo + "@" + image.Sha
+	for _, id := range policy.Identities {
+		cmd := exec.CommandContext(ctx, "cosign", cosignVerifyArgs(ref, id)...)
+		out, err := s.execHelper.RunQuietly(cmd)
+		if err == nil {
+			log.V(util.Debug).Info("Verified image signature", "image", ref, "identity", id)
+			return nil
+		}
+		log.V(util.Debug).Info("Image signature couldn't be verified with identity", "image", ref, "identity", id, "output", out)
+	}
+	return &unsignedImageError{image: ref}
+}
+
+// cosignVerifyArgs returns the arguments for cosign to verify image is signed by the identity.
+func cosignVerifyArgs(image string, id v1alpha1.SignatureIdentity) []string {
+	args := []string{"verify"}
+	if id.KeyRef != "" {
+		args = append(args, "--key="+id.KeyRef)
+	} else {
+		args = append(args, "--certificate-identity="+id.Subject, "--certificate-oidc-issuer="+id.Issuer)
+	}
+	return append(args, image)
+}
+
+// lookupImageSha looks up the sha of the image in its registry.
+func (s *syncRun) lookupImageSha(ctx context.Context, r util.DockerImageRef, strategy v1alpha1.Strategy) (util.DockerImageRef, error) {
 	log := s.log
+	if s.imageResolver != nil {
+		return s.imageResolver.ResolveImageToSha(ctx, r, strategy)
+	}
+
 	if gcp.IsArtifactRegistry(r.Registry) {
 		if s.gcpImageResovler == nil {
 			log.Info("Creating GCP image resolver")
+			// N.B. The resolver is reused by later runs so it isn't created with the run's context.
 			resolver, err := gcp.NewImageResolver(context.Background())
 			if err != nil {
 				return r, err
@@ -951,10 +1978,31 @@ func (s *Syncer) resolveImageToSha(r util.DockerImageRef, strategy v1alpha1.Stra
 			s.gcpImageResovler = resolver
 		}
 
-		return s.gcpImageResovler.ResolveImageToSha(r, strategy)
+		return s.gcpImageResovler.ResolveImageToSha(ctx, r, strategy)
+	}
+
+	if azure.IsACR(r.Registry) {
+		if s.acrImageResolver == nil {
+			log.Info("Creating ACR image resolver")
+			resolver, err := azure.NewImageResolver()
+			if err != nil {
+				return r, err
+			}
+			s.acrImageResolver = resolver
+		}
+
+		return s.acrImageResolver.ResolveImageToSha(ctx, r, strategy)
+	}
+
+	if r.GetAwsRegistryID() == "" {
+		// Fall back to the OCI distribution API for registries without a bespoke resolver.
+		resolver, err := s.getOCIImageResolver()
+		if err != nil {
+			return r, err
+		}
+		return resolver.ResolveImageToSha(ctx, r, strategy)
 	}
 
-	// Assume its ECR otherwise.
 	svc := ecr.New(s.sess)
 
 	resolved := r
@@ -969,7 +2017,7 @@ func (s *Syncer) resolveImageToSha(r util.DockerImageRef, strategy v1alpha1.Stra
 		RepositoryName: aws.String(r.Repo),
 	}
 
-	result, err := svc.DescribeImages(input)
+	result, err := svc.DescribeImagesWithContext(ctx, input)
 	if err != nil {
 		return resolved, err
 	}
@@ -1007,19 +2055,43 @@ func (s *Syncer) resolveImageToSha(r util.DockerImageRef, strategy v1alpha1.Stra
 	return resolved, nil
 }
 
+// newestMatchingTag returns the newest tag of the image matching pattern. Tags are listed using the OCI
+// distribution API which all the registries we support implement.
+func (s *syncRun) newestMatchingTag(ctx context.Context, r util.DockerImageRef, pattern string) (string, error) {
+	resolver, err := s.getOCIImageResolver()
+	if err != nil {
+		return "", err
+	}
+	return resolver.NewestMatchingTag(ctx, r, pattern)
+}
+
+// highestVersion returns the tag of the image with the highest semantic version satisfying constraint.
+func (s *syncRun) highestVersion(ctx context.Context, r util.DockerImageRef, constraint string) (string, error) {
+	resolver, err := s.getOCIImageResolver()
+	if err != nil {
+		return "", err
+	}
+	return resolver.HighestVersion(ctx, r, constraint)
+}
+
+// getOCIImageResolver returns the cached resolver for the OCI distribution API; creating it if necessary.
+func (s *syncRun) getOCIImageResolver() (*images.Resolver, error) {
+	if s.ociImageResolver == nil {
+		s.log.Info("Creating OCI image resolver")
+		resolver, err := images.NewResolver()
+		if err != nil {
+			return nil, err
+		}
+		s.ociImageResolver = resolver
+	}
+	return s.ociImageResolver, nil
+}
+
 // findImagesToPin searches the kustomize files to find all images that might need to be pinned.
 // Result is a mapping from docker images. Also returns a list of kustomization files that match the annotations
 // and should be hydrated.
-func (s *Syncer) findImagesToPin(kustomizeFiles []string) (map[util.DockerImageRef][]imageAndFile, []string, error) {
+func (s *syncRun) findImagesToPin(kustomizeFiles []string) (map[util.DockerImageRef][]imageAndFile, []string, error) {
 	log := s.log
-	// Define some sets to look up the images to replace.
-	registrySet := map[string]bool{}
-
-	matchAllRegistries := s.manifest.Spec.ImageRegistries == nil
-	for _, i := range s.manifest.Spec.ImageRegistries {
-		registrySet[i] = true
-	}
-
 	results := map[util.DockerImageRef][]imageAndFile{}
 
 	filesToHydrate := []string{}
@@ -1072,7 +2144,7 @@ func (s *Syncer) findImagesToPin(kustomizeFiles []string) (map[util.DockerImageR
 				log.Error(err, "Failed to parse image url", "image", url)
 			}
 
-			if _, ok := registrySet[r.Registry]; !ok && !matchAllRegistries {
+			if !s.matchesRegistries(r.Registry) {
 				continue
 			}
 
@@ -1088,7 +2160,27 @@ func (s *Syncer) findImagesToPin(kustomizeFiles []string) (map[util.DockerImageR
 	return results, filesToHydrate, nil
 }
 
-func (s *Syncer) applyKustomizeFns(hydratedPath string, sourceRoot string, filesToHydrate []string) error {
+// matchesRegistries returns true if images in the registry are eligible to be pinned.
+func (s *syncRun) matchesRegistries(registry string) bool {
+	return matchesRegistries(s.manifest.Spec.ImageRegistries, registry)
+}
+
+// matchesRegistries returns true if registry is one of registries. A nil list matches all registries.
+func matchesRegistries(registries []string, registry string) bool {
+	if registries == nil {
+		return true
+	}
+	for _, r := range registries {
+		if r == registry {
+			return true
+		}
+	}
+	return false
+}
+
+// applyKustomizeFns applies the functions to the hydrated manifests. The results of applying the functions are
+// added to report.
+func (s *syncRun) applyKustomizeFns(hydratedPath string, sourceRoot string, filesToHydrate []string, report *kustomize2.Report) error {
 	log := s.log
 	functionPaths := []string{}
 	for _, f := range s.manifest.Spec.Functions {
@@ -1099,7 +2191,13 @@ func (s *Syncer) applyKustomizeFns(hydratedPath string, sourceRoot string, files
 	}
 
 	d := kustomize2.Dispatcher{
-		Log: log,
+		Log:      log,
+		Timeout:  kustomize2.DefaultFunctionTimeout,
+		Kinds:    s.manifest.Spec.FunctionKinds,
+		External: s.manifest.Spec.ExternalFunctions,
+		Allowed:  s.functions,
+		Report:   report,
+		Layout:   s.manifest.Spec.DestLayout,
 	}
 
 	// get all functions based on sourcedir
@@ -1138,135 +2236,182 @@ func (s *Syncer) applyKustomizeFns(hydratedPath string, sourceRoot string, files
 	return d.ApplyFilteredFuncs(funcs.Nodes)
 }
 
-// TODO(jeremy): Having buildImages as a method on Syncer no longer makes sense.
-// We have the image resource which should be used to build images. We aren't using skaffold to build images
-// so we might just want to delete this code.
-func (s *Syncer) buildImages(sourcePath string, sourceCommit string) error {
+// buildImages builds the images defined by Image resources under sourcePath if they don't already exist.
+// This runs before images are pinned so that the images for sourceCommit exist when we try to resolve them.
+func (s *syncRun) buildImages(ctx context.Context, sourceRepoRoot string, sourcePath string, sourceCommit string) error {
 	// Give each run of buildImages a unique id so its easy to group all the messages about image building
 	// for a particular run.
-	log := s.log.WithValues("skaffoldId", uuid.New().String()[0:5])
+	log := s.log.WithValues("buildImagesId", uuid.New().String()[0:5])
+	ctx = logr.NewContext(ctx, log)
 
 	if s.manifest.Spec.ImageBuilder == nil || !s.manifest.Spec.ImageBuilder.Enabled {
 		log.Info("image builder not enabled")
 		return nil
 	}
 
-	// Find all the skaffold files
-	configs, err := skaffold.LoadSkaffoldConfigs(log, sourcePath, nil, s.manifest.Spec.ExcludeDirs)
+	toBuild, err := findImageResources(sourcePath, sourceRepoRoot, s.manifest.Spec.ExcludeDirs, log)
 	if err != nil {
-		log.Error(err, "Failed to load skaffold configs", "sourcePath", sourcePath)
+		log.Error(err, "Failed to find Image resources", "sourcePath", sourcePath)
 		return err
 	}
 
-	skaffoldErrs := &util.ListOfErrors{}
-	errsMu := sync.Mutex{}
-
-	// Explicitly tag the image with source so even if the tagging strategy is different we still have the
-	// tag expected by hydros.
-	tags := []string{sourceCommit, "latest"}
+	if len(toBuild) == 0 {
+		// Images used to be built from skaffold configs. Fail rather than silently stop building the images of
+		// ManifestSyncs that haven't been migrated.
+		configs, err := findSkaffoldConfigs(sourcePath, sourceRepoRoot, s.manifest.Spec.ExcludeDirs, log)
+		if err != nil {
+			return err
+		}
+		if len(configs) > 0 {
+			err := errors.Errorf("spec.imageBuilder is enabled but there are no Image resources under %v; images are no longer built from skaffold configs %v; replace them with Image resources as described in docs/hydrating_manifests.md", s.manifest.Spec.SourcePath, configs)
+			log.Error(err, "Skaffold configs need to be migrated to Image resources", "sourcePath", sourcePath)
+			return err
+		}
+		log.Info("No Image resources found", "sourcePath", sourcePath)
+		return nil
+	}
 
-	var wg sync.WaitGroup
-	// Determine which images don't exist
-	for _, c := range configs {
-		log := log.WithValues("skaffoldFile", c.Path)
-		err := skaffold.ChangeRegistry(c.Config, s.manifest.Spec.ImageBuilder.Registry)
+	if s.imageController == nil {
+		c, err := images.NewController(s.imageOptions...)
 		if err != nil {
-			return errors.Wrapf(err, "Failed to change registry in file: %v", c.Path)
+			return errors.Wrapf(err, "Failed to create image controller")
 		}
+		s.imageController = c
+	}
 
-		missingImages := false
-		for _, a := range c.Config.Build.Artifacts {
-			image, err := util.ParseImageURL(a.ImageName)
-			if err != nil {
-				log.Error(err, "Failed to parse image.", "image", a.ImageName)
-				return errors.Wrapf(err, "Failed to parse image: %v", a.ImageName)
-			}
+	// Use the source repository that was already cloned rather than cloning it again.
+	gitRepo, err := git.PlainOpen(sourceRepoRoot)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to open git repository %v", sourceRepoRoot)
+	}
+	if err := s.imageController.SetLocalRepos([]images.GitRepoRef{{Repo: gitRepo}}); err != nil {
+		return err
+	}
 
-			// Ensure the repo exists
-			if err := ecrutil.EnsureRepoExists(s.sess, image.GetAwsRegistryID(), image.Repo); err != nil {
-				return errors.Wrapf(err, "Failed to ensure the repo exists; registry: %v; repo: %v", image.Registry, image.Repo)
-			}
+	buildErrs := &util.ListOfErrors{}
+	errsMu := sync.Mutex{}
 
-			// Ensure cache repo exists
-			cacheRepo := image.Repo + "/cache"
-			if err := ecrutil.EnsureRepoExists(s.sess, image.GetAwsRegistryID(), cacheRepo); err != nil {
-				return errors.Wrapf(err, "Failed to ensure the repo exists; registry: %v; repo: %v", image.Registry, cacheRepo)
+	var wg sync.WaitGroup
+	for _, image := range toBuild {
+		image.Status.SourceCommit = sourceCommit
+		wg.Add(1)
+		go func(image *v1alpha1.Image) {
+			defer wg.Done()
+			if err := s.imageController.Reconcile(ctx, image); err != nil {
+				log.Error(err, "Failed to build image", "name", image.Metadata.Name, "image", image.Spec.Image)
+				errsMu.Lock()
+				defer errsMu.Unlock()
+				buildErrs.AddCause(err)
 			}
-			// Check if the image exists.
-			image.Tag = sourceCommit
+		}(image)
+	}
+
+	wg.Wait()
+
+	if len(buildErrs.Causes) > 0 {
+		buildErrs.Final = errors.Errorf("Failed to build images")
+		return buildErrs
+	}
+	return nil
+}
 
-			// TODO(jeremy): Should we return the resolved image so that hydros doesn't have to resolve them
-			// a second time?
-			resolved, err := s.resolveImageToSha(*image, v1alpha1.MutableTagStrategy)
+// findImageResources finds all the Image resources in YAML files under root.
+// excludes is a list of directories relative to repoRoot to skip.
+// findSkaffoldConfigs returns the paths, relative to repoRoot, of the skaffold configs under root. Directories in
+// excludes are skipped.
+func findSkaffoldConfigs(root string, repoRoot string, excludes []string, log logr.Logger) ([]string, error) {
+	excludesSet := map[string]bool{}
+	for _, e := range excludes {
+		excludesSet[e] = true
+	}
 
+	results := []string{}
+	err := filepath.Walk(root,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rPath, err := filepath.Rel(repoRoot, path)
 			if err != nil {
-				// code returned by the service in code. The error code can be used
-				// to switch on context specific functionality. In this case a context
-				// specific error message is printed to the user based on the bucket
-				// and key existing.
-				//
-				// For information on other S3 API error codes see:
-				// http://docs.aws.amazon.com/AmazonS3/latest/API/ErrorResponses.html
-				if aerr, ok := err.(awserr.Error); ok {
-					switch aerr.Code() {
-					case ecr.ErrCodeImageNotFoundException:
-						missingImages = true
-					default:
-						return errors.Wrapf(err, "Failed to resolve image to sha; image: %v", image.ToURL())
-					}
+				return errors.Wrapf(err, "Could not compute relative path of %v", path)
+			}
+			if info.IsDir() {
+				if excludesSet[rPath] || info.Name() == ".git" {
+					log.V(util.Debug).Info("Excluding directory", "dir", path)
+					return filepath.SkipDir
 				}
-			} else {
-				log.V(util.Debug).Info("Resolved image", "image", image.ToURL(), "resolved", resolved)
+				return nil
 			}
-		}
+			if info.Name() == "skaffold.yaml" || info.Name() == "skaffold.yml" {
+				results = append(results, rPath)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to search %v for skaffold configs", root)
+	}
+	return results, nil
+}
 
-		if missingImages {
-			// Since the skaffold config could have been modified to change the registry we need to write it back out
-			f, err := os.CreateTemp("", "skaffold.*.yaml")
+func findImageResources(root string, repoRoot string, excludes []string, log logr.Logger) ([]*v1alpha1.Image, error) {
+	results := []*v1alpha1.Image{}
 
-			newFile := f.Name()
+	excludesSet := map[string]bool{}
 
-			if err != nil {
-				return errors.Wrapf(err, "Could not create temporary file to write updated skaffold config for %v", c.Path)
+	for _, e := range excludes {
+		excludesSet[e] = true
+	}
+
+	err := filepath.Walk(root,
+		func(path string, info os.FileInfo, err error) error {
+			if info == nil {
+				// N.B. I think this happens if path is the empty string.
+				return fmt.Errorf("No info returned for path %v", info)
 			}
+			// skip directories
+			if info.IsDir() {
+				rPath, err := filepath.Rel(repoRoot, path)
+				if err != nil {
+					log.Error(err, "Could not compute relative path", "basePath", root, "path", path)
+				}
 
-			b, err := yaml.Marshal(c.Config)
-			if err != nil {
-				return errors.Wrapf(err, "Could not marshal skaffold config")
+				if _, ok := excludesSet[rPath]; ok || info.Name() == ".git" {
+					log.V(util.Debug).Info("Excluding directory", "dir", path)
+					return filepath.SkipDir
+				}
+
+				return nil
 			}
 
-			log.V(util.Debug).Info("Writing updated skaffold file", "config", string(b), "path", newFile)
-			if _, err := f.Write(b); err != nil {
-				return errors.Wrapf(err, "Failed to write skaffold config to file: %v", f.Name())
+			// Skip non YAML files
+			ext := strings.ToLower(filepath.Ext(info.Name()))
+
+			if ext != ".yaml" && ext != ".yml" {
+				return nil
 			}
 
-			if err := f.Close(); err != nil {
-				return errors.Wrapf(err, "Failed close file: %v", f.Name())
+			nodes, err := util.ReadYaml(path)
+			if err != nil {
+				// Not all YAML files are valid resources e.g. templates so keep going.
+				log.V(util.Debug).Info("Skipping file that couldn't be read as YAML", "path", path, "err", err)
+				return nil
 			}
 
-			// Since at least one image is missing run skaffold build to build the image.
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				// Build directory should be location of the original skaffold file
-				buildDir := path.Dir(c.Path)
-				err := skaffold.RunBuild(newFile, buildDir, tags, s.sess, log)
-				if err != nil {
-					errsMu.Lock()
-					defer errsMu.Unlock()
-					skaffoldErrs.AddCause(err)
+			for _, n := range nodes {
+				if n.GetApiVersion() != v1alpha1.ImageGVK.GroupVersion().String() || n.GetKind() != v1alpha1.ImageGVK.Kind {
+					continue
 				}
-			}()
-		}
-	}
-
-	wg.Wait()
+				image := &v1alpha1.Image{}
+				if err := n.YNode().Decode(image); err != nil {
+					return errors.Wrapf(err, "Failed to decode Image %v in %v", n.GetName(), path)
+				}
+				log.Info("Found Image resource", "name", image.Metadata.Name, "path", path)
+				results = append(results, image)
+			}
+			return nil
+		})
 
-	if len(skaffoldErrs.Causes) > 0 {
-		skaffoldErrs.Final = errors.Errorf("Failed to build images using skaffold")
-		return skaffoldErrs
-	}
-	return nil
+	return results, err
 }
 
 func matches(k *kustomize.Kustomization, selector *meta.LabelSelector) (bool, error) {
@@ -2,7 +2,11 @@ package gitops
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -381,3 +385,243 @@ func Test_IsPaused(t *testing.T) {
 		})
 	}
 }
+
+func Test_findImageResources(t *testing.T) {
+	root := filepath.Join("test_data", "images")
+	log := zapr.NewLogger(zap.L())
+
+	// The templates directory should be excluded.
+	actual, err := findImageResources(root, "test_data", []string{filepath.Join("images", "templates")}, log)
+	if err != nil {
+		t.Fatalf("findImageResources failed; %v", err)
+	}
+
+	names := []string{}
+	for _, i := range actual {
+		names = append(names, i.Metadata.Name)
+	}
+
+	if d := cmp.Diff([]string{"app"}, names); d != "" {
+		t.Errorf("Unexpected images (-want +got):\n%s", d)
+	}
+
+	if len(actual) == 1 && actual[0].Spec.Builder.GCB.Project != "acme" {
+		t.Errorf("Image wasn't decoded; got %+v", actual[0])
+	}
+}
+
+func Test_buildImagesUnmigratedSkaffold(t *testing.T) {
+	repoRoot := t.TempDir()
+	for _, p := range []string{"manifests/app/skaffold.yaml", "manifests/templates/skaffold.yaml"} {
+		p = filepath.Join(repoRoot, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("Failed to create dir; %v", err)
+		}
+		if err := os.WriteFile(p, []byte("apiVersion: skaffold/v2beta13\nkind: Config\n"), 0o644); err != nil {
+			t.Fatalf("Failed to write %v; %v", p, err)
+		}
+	}
+
+	log := zapr.NewLogger(zap.L())
+	configs, err := findSkaffoldConfigs(filepath.Join(repoRoot, "manifests"), repoRoot, []string{filepath.Join("manifests", "templates")}, log)
+	if err != nil {
+		t.Fatalf("findSkaffoldConfigs failed; %v", err)
+	}
+	if d := cmp.Diff([]string{filepath.Join("manifests", "app", "skaffold.yaml")}, configs); d != "" {
+		t.Errorf("Unexpected skaffold configs (-want +got):\n%s", d)
+	}
+
+	s := &syncRun{
+		Syncer: &Syncer{},
+		log:    log,
+		manifest: &v1alpha1.ManifestSync{
+			Spec: v1alpha1.ManifestSyncSpec{
+				SourcePath:   "manifests",
+				ImageBuilder: &v1alpha1.ImageBuilder{Enabled: true},
+			},
+		},
+	}
+	err = s.buildImages(context.Background(), repoRoot, filepath.Join(repoRoot, "manifests"), "1234")
+	if err == nil || !strings.Contains(err.Error(), "no longer built from skaffold configs") {
+		t.Errorf("Expected an error because the skaffold configs weren't migrated; got %v", err)
+	}
+}
+
+func Test_newRunIsolatesState(t *testing.T) {
+	m := &v1alpha1.ManifestSync{
+		Metadata: v1alpha1.Metadata{
+			Name: "test",
+		},
+	}
+	s := &Syncer{
+		log:      zapr.NewLogger(zap.L()),
+		manifest: m,
+	}
+
+	first := s.newRun()
+	second := s.newRun()
+
+	first.manifest.Status.SourceCommit = "1234"
+	first.manifest.Status.PinnedImages = []v1alpha1.PinnedImage{{Image: "some-repo/app:latest"}}
+
+	if m.Status.SourceCommit != "" || len(m.Status.PinnedImages) != 0 {
+		t.Errorf("Updating the status of a run modified the Syncer's manifest; got %+v", m.Status)
+	}
+	if second.manifest.Status.SourceCommit != "" {
+		t.Errorf("Updating the status of a run modified another run; got %+v", second.manifest.Status)
+	}
+	if first.execHelper == second.execHelper {
+		t.Errorf("Runs should not share an ExecHelper")
+	}
+}
+
+// blockingResolver is an ImageResolver which blocks until the context is cancelled.
+type blockingResolver struct {
+	started chan bool
+}
+
+func (r *blockingResolver) ResolveImageToSha(ctx context.Context, image util.DockerImageRef, strategy v1alpha1.Strategy) (util.DockerImageRef, error) {
+	r.started <- true
+	<-ctx.Done()
+	return image, ctx.Err()
+}
+
+func Test_resolveImageToShaCancelled(t *testing.T) {
+	resolver := &blockingResolver{started: make(chan bool, 1)}
+	s := &Syncer{
+		log:           zapr.NewLogger(zap.L()),
+		manifest:      &v1alpha1.ManifestSync{},
+		imageResolver: resolver,
+	}
+	r := s.newRun()
+	image := util.DockerImageRef{Registry: "ghcr.io", Repo: "acme/hercules", Tag: "latest"}
+
+	if s.Cancel() {
+		t.Errorf("Cancel returned true but no run is in progress")
+	}
+
+	ctx, done := s.runContext()
+	defer done()
+	errs := make(chan error, 1)
+	go func() {
+		_, err := r.resolveImageToSha(ctx, image, v1alpha1.MutableTagStrategy)
+		errs <- err
+	}()
+
+	<-resolver.started
+	if !s.Cancel() {
+		t.Errorf("Cancel returned false but a run is in progress")
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the resolution to be cancelled; got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Cancelling the run didn't abort resolving the image")
+	}
+
+	// Images aren't resolved once the run is cancelled.
+	if _, err := r.resolveImageToSha(ctx, image, v1alpha1.MutableTagStrategy); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected resolving an image after the run is cancelled to fail; got %v", err)
+	}
+	select {
+	case <-resolver.started:
+		t.Errorf("The resolver was called after the run was cancelled")
+	default:
+	}
+}
+
+func Test_cosignVerifyArgs(t *testing.T) {
+	image := "us-west1-docker.pkg.dev/acme/images/hercules@sha256:abcd"
+	type testCase struct {
+		name     string
+		id       v1alpha1.SignatureIdentity
+		expected []string
+	}
+
+	testCases := []testCase{
+		{
+			name:     "key",
+			id:       v1alpha1.SignatureIdentity{KeyRef: "gcpkms://projects/acme/locations/global/keyRings/cosign/cryptoKeys/images"},
+			expected: []string{"verify", "--key=gcpkms://projects/acme/locations/global/keyRings/cosign/cryptoKeys/images", image},
+		},
+		{
+			name:     "keyless",
+			id:       v1alpha1.SignatureIdentity{Issuer: "https://accounts.google.com", Subject: "builder@acme.iam.gserviceaccount.com"},
+			expected: []string{"verify", "--certificate-identity=builder@acme.iam.gserviceaccount.com", "--certificate-oidc-issuer=https://accounts.google.com", image},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			if d := cmp.Diff(c.expected, cosignVerifyArgs(image, c.id)); d != "" {
+				t.Errorf("Unexpected args; diff:\n%v", d)
+			}
+		})
+	}
+}
+
+func Test_getRepos(t *testing.T) {
+	source := v1alpha1.GitHubRepo{Org: "acme", Repo: "src", Branch: "main"}
+	hydrated := v1alpha1.GitHubRepo{Org: "acme", Repo: "hydrated", Branch: "main"}
+	fork := func(branch string) v1alpha1.GitHubRepo {
+		return v1alpha1.GitHubRepo{Org: "acme", Repo: "hydrated", Branch: branch}
+	}
+
+	type testCase struct {
+		name     string
+		spec     v1alpha1.ManifestSyncSpec
+		expected map[string]v1alpha1.GitHubRepo
+	}
+
+	testCases := []testCase{
+		{
+			name: "single",
+			spec: v1alpha1.ManifestSyncSpec{
+				SourceRepo: source,
+				ForkRepo:   fork("hydros/prod"),
+				DestRepo:   hydrated,
+			},
+			expected: map[string]v1alpha1.GitHubRepo{
+				"source": source,
+				"dest":   hydrated,
+				"fork":   fork("hydros/prod"),
+			},
+		},
+		{
+			name: "destRepos",
+			spec: v1alpha1.ManifestSyncSpec{
+				SourceRepo: source,
+				DestRepos: []v1alpha1.Destination{
+					{Name: "us-west-2", ForkRepo: fork("hydros/us-west-2"), DestRepo: hydrated},
+					{Name: "eu-west-1", ForkRepo: fork("hydros/eu-west-1"), DestRepo: hydrated},
+				},
+			},
+			expected: map[string]v1alpha1.GitHubRepo{
+				"source":         source,
+				"dest-us-west-2": hydrated,
+				"fork-us-west-2": fork("hydros/us-west-2"),
+				"dest-eu-west-1": hydrated,
+				"fork-eu-west-1": fork("hydros/eu-west-1"),
+			},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			actual := getRepos(v1alpha1.ManifestSync{Spec: c.spec})
+			if d := cmp.Diff(c.expected, actual); d != "" {
+				t.Errorf("Unexpected repos; diff:\n%v", d)
+			}
+			for key := range actual {
+				if key == sourceKey {
+					continue
+				}
+				if isForkKey(key) == isDestKey(key) {
+					t.Errorf("Key %v should be exactly one of a fork or dest key", key)
+				}
+			}
+		})
+	}
+}
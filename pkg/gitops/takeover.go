@@ -58,8 +58,9 @@ func rewriteRepos(ctx context.Context, m *v1alpha1.ManifestSync, mappings []v1al
 	return nil
 }
 
-// SetTakeOverAnnotations sets the takeover annotations on the manifest.
-func SetTakeOverAnnotations(m *v1alpha1.ManifestSync, pause time.Duration) error {
+// SetTakeOverAnnotations sets the takeover annotations on the manifest. by is who is doing the takeover; it is
+// recorded in the status of the sync so operators can tell who paused it.
+func SetTakeOverAnnotations(m *v1alpha1.ManifestSync, pause time.Duration, by string) error {
 	tEnd := time.Now().Add(pause)
 
 	k8sTime := metav1.NewTime(tEnd)
@@ -72,6 +73,65 @@ func SetTakeOverAnnotations(m *v1alpha1.ManifestSync, pause time.Duration) error
 		v1alpha1.TakeoverAnnotation: "true",
 		v1alpha1.PauseAnnotation:    string(v),
 	}
+	if by != "" {
+		m.Metadata.Annotations[v1alpha1.TakeoverByAnnotation] = by
+	}
 
 	return nil
 }
+
+// Release ends a takeover before its pause expires. Open PRs from the fork branches are closed since they
+// contain manifests hydrated during the takeover. The manifests are then hydrated from the source branch of
+// the Syncer's manifest ignoring any pause; the new sync file doesn't set PausedUntil so regular syncs resume.
+func (s *Syncer) Release() error {
+	if len(s.environments) > 0 {
+		finalErr := &util.ListOfErrors{}
+		for i, env := range s.environments {
+			if err := env.Release(); err != nil {
+				s.log.Error(err, "Failed to release takeover of environment", "environment", s.manifest.Spec.Environments[i].Name)
+				finalErr.AddCause(err)
+			}
+		}
+		if len(finalErr.Causes) == 0 {
+			return nil
+		}
+		finalErr.Final = errors.Errorf("Failed to release takeover of %d of %d environments", len(finalErr.Causes), len(s.environments))
+		return finalErr
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.newRun()
+	r.release = true
+
+	// N.B. The run's manifest is a shallow copy so copy the annotations before removing the takeover annotations.
+	annotations := map[string]string{}
+	for k, v := range r.manifest.Metadata.Annotations {
+		if k == v1alpha1.TakeoverAnnotation || k == v1alpha1.PauseAnnotation {
+			continue
+		}
+		annotations[k] = v
+	}
+	r.manifest.Metadata.Annotations = annotations
+	r.manifest.Status.PausedUntil = nil
+
+	for _, d := range s.destinations {
+		pr, err := d.repoHelper.PullRequestForBranch()
+		if err != nil {
+			return errors.Wrapf(err, "Failed to check if destination %v has an open PR", d.Name)
+		}
+		if pr == nil {
+			continue
+		}
+		r.log.Info("Closing PR opened during the takeover", "destination", d.Name, "pr", pr.URL)
+		if err := d.repoHelper.ClosePR(pr.Number, "Closed by hydros because the takeover was released."); err != nil {
+			return err
+		}
+	}
+
+	ctx, done := s.runContext()
+	defer done()
+	err := r.run(ctx, true)
+	r.reportStatus(err)
+	return err
+}
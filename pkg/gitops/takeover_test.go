@@ -3,6 +3,7 @@ package gitops
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/jlewi/hydros/api/v1alpha1"
@@ -55,3 +56,36 @@ func Test_rewriteRepos(t *testing.T) {
 		})
 	}
 }
+
+func Test_isTakeOver(t *testing.T) {
+	type testCase struct {
+		name        string
+		annotations map[string]string
+		expected    bool
+	}
+
+	cases := []testCase{
+		{name: "no-annotations", expected: false},
+		{name: "true", annotations: map[string]string{v1alpha1.TakeoverAnnotation: "true"}, expected: true},
+		{name: "any-value", annotations: map[string]string{v1alpha1.TakeoverAnnotation: "jeremy"}, expected: true},
+		{name: "false", annotations: map[string]string{v1alpha1.TakeoverAnnotation: " False "}, expected: false},
+		{name: "other-annotation", annotations: map[string]string{v1alpha1.PauseAnnotation: "\"2023-01-01T00:00:00Z\""}, expected: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := v1alpha1.ManifestSync{Metadata: v1alpha1.Metadata{Annotations: c.annotations}}
+			if actual := isTakeOver(m); actual != c.expected {
+				t.Errorf("isTakeOver: got %v; want %v", actual, c.expected)
+			}
+		})
+	}
+
+	m := &v1alpha1.ManifestSync{}
+	if err := SetTakeOverAnnotations(m, time.Hour, "jeremy"); err != nil {
+		t.Fatalf("SetTakeOverAnnotations failed; %v", err)
+	}
+	if !isTakeOver(*m) {
+		t.Errorf("Expected the manifest to be a takeover after SetTakeOverAnnotations; got annotations %v", m.Metadata.Annotations)
+	}
+}
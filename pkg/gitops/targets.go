@@ -0,0 +1,91 @@
+package gitops
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	kustomize2 "github.com/jlewi/hydros/pkg/kustomize"
+	"github.com/pkg/errors"
+)
+
+// hydrateTarget is a kustomization and the directory, relative to the dest path, it is hydrated into.
+type hydrateTarget struct {
+	kustomization string
+	dir           string
+}
+
+// computeHydrateTargets computes the directory each kustomization is hydrated into. It is called before
+// anything in the dest path is deleted so that misconfigurations fail the sync without modifying the fork.
+// Two kustomizations hydrated into the same directory would overwrite each other so every collision is
+// reported in the error. The flatten layout hydrates every kustomization into the dest path by design so
+// it isn't checked for collisions here; the files are only known once the kustomizations are built so
+// flattenedFiles checks that no two kustomizations produce the same file.
+func computeHydrateTargets(layout *v1alpha1.DestLayout, sourceRoot string, kustomizations []string) ([]hydrateTarget, error) {
+	targets := make([]hydrateTarget, 0, len(kustomizations))
+	byDir := map[string][]string{}
+	for _, k := range kustomizations {
+		dir, err := kustomize2.HydratedDir(layout, sourceRoot, k)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to generate target path for kustomization %v", k)
+		}
+		targets = append(targets, hydrateTarget{kustomization: k, dir: dir})
+		byDir[dir] = append(byDir[dir], k)
+	}
+
+	if layout.GetType() == v1alpha1.FlattenDestLayout {
+		return targets, nil
+	}
+
+	collisions := []string{}
+	for dir, sources := range byDir {
+		if len(sources) < 2 {
+			continue
+		}
+		if dir == "" {
+			dir = "."
+		}
+		collisions = append(collisions, fmt.Sprintf("%v is the target of %v", dir, strings.Join(sources, " and ")))
+	}
+	if len(collisions) == 0 {
+		return targets, nil
+	}
+	sort.Strings(collisions)
+	return nil, errors.Errorf("Multiple kustomizations would be hydrated into the same directory; select only one of them (e.g. one overlay) or change the destLayout:\n%v", strings.Join(collisions, "\n"))
+}
+
+// flattenedFiles maps the files hydrated into the dest path using the flatten layout to the kustomization they
+// were hydrated from. It is used to detect kustomizations producing the same file because with the flatten layout
+// the later kustomization would silently overwrite the resource of the earlier one.
+type flattenedFiles map[string]string
+
+// add moves the files kustomization was hydrated into in dir into destDir. An error naming both kustomizations is
+// returned for every file that was already hydrated from another kustomization; nothing is moved in that case.
+func (f flattenedFiles) add(kustomization string, dir string, destDir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read the hydrated manifests of %v", kustomization)
+	}
+
+	collisions := []string{}
+	for _, e := range entries {
+		if other, ok := f[e.Name()]; ok {
+			collisions = append(collisions, fmt.Sprintf("%v is the output of %v and %v", e.Name(), other, kustomization))
+		}
+	}
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		return errors.Errorf("Multiple kustomizations would be hydrated into the same file using the flatten layout; select only one of them (e.g. one overlay) or change the destLayout:\n%v", strings.Join(collisions, "\n"))
+	}
+
+	for _, e := range entries {
+		if err := os.Rename(filepath.Join(dir, e.Name()), filepath.Join(destDir, e.Name())); err != nil {
+			return errors.Wrapf(err, "Failed to move %v into %v", e.Name(), destDir)
+		}
+		f[e.Name()] = kustomization
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package gitops
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/api/v1alpha1"
+)
+
+func Test_computeHydrateTargets(t *testing.T) {
+	sourceRoot := "/tmp/manifests"
+	kustomizations := []string{
+		"/tmp/manifests/backend/dev/kustomization.yaml",
+		"/tmp/manifests/backend/prod/kustomization.yaml",
+		"/tmp/manifests/frontend/dev/kustomization.yaml",
+		"/tmp/manifests/frontend/prod/kustomization.yaml",
+		"/tmp/manifests/worker/dev/kustomization.yaml",
+	}
+
+	_, err := computeHydrateTargets(nil, sourceRoot, kustomizations)
+	if err == nil {
+		t.Fatalf("Expected an error because overlays of the same package collide")
+	}
+	// Every collision should be reported with both source paths.
+	for _, k := range kustomizations[:4] {
+		if !strings.Contains(err.Error(), k) {
+			t.Errorf("Error doesn't mention %v; got:\n%v", k, err)
+		}
+	}
+	if strings.Contains(err.Error(), kustomizations[4]) {
+		t.Errorf("Error mentions %v which doesn't collide; got:\n%v", kustomizations[4], err)
+	}
+
+	targets, err := computeHydrateTargets(&v1alpha1.DestLayout{Type: v1alpha1.KeepOverlayDestLayout}, sourceRoot, kustomizations)
+	if err != nil {
+		t.Fatalf("computeHydrateTargets failed; %v", err)
+	}
+	expected := []hydrateTarget{
+		{kustomization: kustomizations[0], dir: "backend/dev"},
+		{kustomization: kustomizations[1], dir: "backend/prod"},
+		{kustomization: kustomizations[2], dir: "frontend/dev"},
+		{kustomization: kustomizations[3], dir: "frontend/prod"},
+		{kustomization: kustomizations[4], dir: "worker/dev"},
+	}
+	if d := cmp.Diff(expected, targets, cmp.AllowUnexported(hydrateTarget{})); d != "" {
+		t.Errorf("Unexpected targets; diff:\n%v", d)
+	}
+
+	if _, err := computeHydrateTargets(&v1alpha1.DestLayout{Type: v1alpha1.FlattenDestLayout}, sourceRoot, kustomizations); err != nil {
+		t.Errorf("Flatten layout shouldn't report collisions; got %v", err)
+	}
+}
+
+func Test_flattenedFiles(t *testing.T) {
+	dir := t.TempDir()
+	destDir := filepath.Join(dir, "dest")
+	// hydrate stands in for kustomize build writing one file per resource.
+	hydrate := func(name string, files ...string) string {
+		out := filepath.Join(dir, name)
+		if err := os.MkdirAll(out, 0o755); err != nil {
+			t.Fatalf("Failed to create directory; %v", err)
+		}
+		for _, f := range files {
+			if err := os.WriteFile(filepath.Join(out, f), []byte(name+"/"+f), 0o644); err != nil {
+				t.Fatalf("Failed to write file; %v", err)
+			}
+		}
+		return out
+	}
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		t.Fatalf("Failed to create directory; %v", err)
+	}
+
+	backend := "/tmp/manifests/backend/kustomization.yaml"
+	frontend := "/tmp/manifests/frontend/kustomization.yaml"
+	shared := "/tmp/manifests/shared/kustomization.yaml"
+
+	f := flattenedFiles{}
+	if err := f.add(backend, hydrate("backend", "apps_v1_deployment_backend.yaml", "v1_configmap_config.yaml"), destDir); err != nil {
+		t.Fatalf("add failed; %v", err)
+	}
+	if err := f.add(frontend, hydrate("frontend", "apps_v1_deployment_frontend.yaml"), destDir); err != nil {
+		t.Fatalf("add failed; %v", err)
+	}
+
+	err := f.add(shared, hydrate("shared", "v1_configmap_config.yaml", "v1_service_shared.yaml"), destDir)
+	if err == nil {
+		t.Fatalf("Expected an error because backend and shared both produce v1_configmap_config.yaml")
+	}
+	for _, k := range []string{backend, shared, "v1_configmap_config.yaml"} {
+		if !strings.Contains(err.Error(), k) {
+			t.Errorf("Error doesn't mention %v; got:\n%v", k, err)
+		}
+	}
+
+	entries, err := os.ReadDir(destDir)
+	if err != nil {
+		t.Fatalf("Failed to read directory; %v", err)
+	}
+	actual := []string{}
+	for _, e := range entries {
+		actual = append(actual, e.Name())
+	}
+	sort.Strings(actual)
+	expected := []string{"apps_v1_deployment_backend.yaml", "apps_v1_deployment_frontend.yaml", "v1_configmap_config.yaml"}
+	if d := cmp.Diff(expected, actual); d != "" {
+		t.Errorf("Unexpected files in the dest dir; diff:\n%v", d)
+	}
+	b, err := os.ReadFile(filepath.Join(destDir, "v1_configmap_config.yaml"))
+	if err != nil {
+		t.Fatalf("Failed to read file; %v", err)
+	}
+	// The file hydrated from backend wasn't overwritten.
+	if string(b) != "backend/v1_configmap_config.yaml" {
+		t.Errorf("Unexpected contents; got %v", string(b))
+	}
+}
@@ -0,0 +1,29 @@
+package gitops
+
+import (
+	"fmt"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// versionSkewed returns true if the manifests were last hydrated by a different major or minor version of hydros
+// than current. Versions that aren't semantic versions e.g. development builds or manifests hydrated before the
+// version was recorded are never skewed since there's nothing to compare.
+func versionSkewed(last string, current string) bool {
+	l, err := semver.NewVersion(last)
+	if err != nil {
+		return false
+	}
+	c, err := semver.NewVersion(current)
+	if err != nil {
+		return false
+	}
+	return l.Major() != c.Major() || l.Minor() != c.Minor()
+}
+
+// buildVersionSkewWarning returns the note added to the PR description when the manifests were last hydrated by
+// a different version of hydros.
+func buildVersionSkewWarning(last string, current string) string {
+	return fmt.Sprintf("Warning: the manifests were last hydrated by hydros %v and are now hydrated by hydros %v; "+
+		"some of the changes may be due to the different versions of hydros rather than the source.", last, current)
+}
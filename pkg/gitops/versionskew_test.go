@@ -0,0 +1,24 @@
+package gitops
+
+import "testing"
+
+func Test_versionSkewed(t *testing.T) {
+	cases := []struct {
+		last     string
+		current  string
+		expected bool
+	}{
+		{last: "v0.1.3", current: "v0.1.9", expected: false},
+		{last: "v0.1.3", current: "v0.2.0", expected: true},
+		{last: "v1.2.0", current: "v0.2.0", expected: true},
+		// Manifests hydrated before the version was recorded.
+		{last: "", current: "v0.2.0", expected: false},
+		// Development builds.
+		{last: "v0.1.3", current: "dev", expected: false},
+	}
+	for _, c := range cases {
+		if actual := versionSkewed(c.last, c.current); actual != c.expected {
+			t.Errorf("versionSkewed(%q, %q) = %v; want %v", c.last, c.current, actual, c.expected)
+		}
+	}
+}
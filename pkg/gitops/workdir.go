@@ -0,0 +1,97 @@
+package gitops
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+)
+
+// workDirMarker is the file hydros creates in the working directories it manages. Hydros discards local changes
+// in the checkouts in its working directory so it refuses to use directories that it didn't create; otherwise
+// a misconfigured workDir pointing at a developer's checkout could destroy their work.
+const workDirMarker = ".hydros-workdir"
+
+// workDirMarkerContents explains the marker to anyone who finds it.
+const workDirMarkerContents = "This directory is managed by hydros. Local changes to the repositories in it will be discarded.\n"
+
+// prepareWorkDir creates the Syncer's working directory if it doesn't exist and checks hydros can safely use it
+// if it does. Directories hydros can use are marked with workDirMarker. An existing directory without the marker
+// is only used if it is empty, if it only contains checkouts of the repositories of the ManifestSync (i.e. it was
+// created by a version of hydros that didn't mark directories) or if the Syncer allows existing directories.
+func (s *Syncer) prepareWorkDir() error {
+	log := s.log
+	if _, err := os.Stat(s.workDir); os.IsNotExist(err) {
+		log.V(util.Debug).Info("Creating work directory.", "directory", s.workDir)
+		if err := os.MkdirAll(s.workDir, util.FilePermUserGroup); err != nil {
+			return errors.Wrapf(err, "Failed to create dir: %v", s.workDir)
+		}
+		return markWorkDir(s.workDir)
+	} else if err != nil {
+		return errors.Wrapf(err, "Failed to stat work directory %v", s.workDir)
+	}
+
+	if _, err := os.Stat(filepath.Join(s.workDir, workDirMarker)); err == nil {
+		return nil
+	}
+
+	entries, err := os.ReadDir(s.workDir)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read work directory %v", s.workDir)
+	}
+
+	switch {
+	case len(entries) == 0:
+	case s.allowExistingWorkDir:
+		log.Info("Using existing work directory that wasn't created by hydros; local changes to repositories in it will be discarded", "directory", s.workDir)
+	default:
+		if problem := s.unmanagedWorkDirEntry(entries); problem != "" {
+			return errors.Errorf("Refusing to use work directory %v because it wasn't created by hydros and %v. Hydros discards local changes in its work directory so this could destroy local work. Use a different work directory or, if the directory is safe to use, rerun with --allow-existing", s.workDir, problem)
+		}
+		log.Info("Adopting work directory containing checkouts of the ManifestSync's repositories", "directory", s.workDir)
+	}
+	return markWorkDir(s.workDir)
+}
+
+// unmanagedWorkDirEntry returns a description of the first entry in the work directory that isn't a checkout
+// hydros would have created or the empty string if all of them are.
+func (s *Syncer) unmanagedWorkDirEntry(entries []os.DirEntry) string {
+	repos := getRepos(*s.manifest)
+	for _, e := range entries {
+		repo, ok := repos[e.Name()]
+		if !ok || !e.IsDir() {
+			return fmt.Sprintf("it contains %v", e.Name())
+		}
+		r, err := git.PlainOpen(filepath.Join(s.workDir, e.Name()))
+		if err != nil {
+			return fmt.Sprintf("%v isn't a git repository", e.Name())
+		}
+		remote, err := r.Remote("origin")
+		if err != nil {
+			return fmt.Sprintf("%v doesn't have an origin remote", e.Name())
+		}
+		expected := fmt.Sprintf("github.com/%v/%v", repo.Org, repo.Repo)
+		matched := false
+		for _, u := range remote.Config().URLs {
+			if strings.Contains(strings.TrimSuffix(u, ".git"), expected) {
+				matched = true
+			}
+		}
+		if !matched {
+			return fmt.Sprintf("%v isn't a checkout of %v", e.Name(), expected)
+		}
+	}
+	return ""
+}
+
+// markWorkDir marks dir as a working directory managed by hydros.
+func markWorkDir(dir string) error {
+	if err := os.WriteFile(filepath.Join(dir, workDirMarker), []byte(workDirMarkerContents), 0o644); err != nil {
+		return errors.Wrapf(err, "Failed to mark work directory %v", dir)
+	}
+	return nil
+}
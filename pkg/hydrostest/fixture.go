@@ -0,0 +1,283 @@
+// Package hydrostest provides fixtures for end-to-end tests of ManifestSync resources. A Fixture scaffolds the
+// source, dest and fork repositories of a ManifestSync as local bare repositories served by the fake GitHub in
+// pkg/github/fake and runs the Syncer against them with a stub image registry. This lets users test their
+// hydration setups, e.g. their kustomizations, selectors and image pinning, without GitHub or real registries.
+//
+// A Fixture routes all GitHub traffic in the process to the fake by replacing http.DefaultTransport and
+// configuring git with environment variables so tests using a Fixture can't run in parallel.
+package hydrostest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/zapr"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/github"
+	"github.com/jlewi/hydros/pkg/github/fake"
+	"github.com/jlewi/hydros/pkg/gitops"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	kustomize "sigs.k8s.io/kustomize/api/types"
+)
+
+// Fixture is a set of local repositories for a ManifestSync and a Syncer to sync them.
+type Fixture struct {
+	// Manifest is the ManifestSync under test.
+	Manifest *v1alpha1.ManifestSync
+	// Server is the fake GitHub serving the repositories. Use it to inspect the PRs created by the Syncer.
+	Server *fake.Server
+	// Registry resolves the images pinned by the Syncer. Push the images the manifests use before running it.
+	Registry *Registry
+	// WorkDir is the work directory of the Syncer.
+	WorkDir string
+
+	t          testing.TB
+	sourceDir  string
+	transports *github.TransportManager
+	syncer     *gitops.Syncer
+}
+
+// New creates the repositories of the ManifestSync m and seeds them with an initial commit. The repositories
+// are deleted when the test finishes.
+func New(t testing.TB, m *v1alpha1.ManifestSync) *Fixture {
+	t.Helper()
+	if err := m.IsValid(); err != nil {
+		t.Fatalf("Invalid ManifestSync; %v", err)
+	}
+
+	dir := t.TempDir()
+	s, err := fake.NewServer(filepath.Join(dir, "repos"))
+	if err != nil {
+		t.Fatalf("Failed to create fake GitHub; %v", err)
+	}
+
+	// The git CLI can't use an in-process transport so serve the fake and rewrite github.com URLs to it.
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	for _, kv := range fake.GitConfigEnv(srv.URL + "/") {
+		k, v, _ := strings.Cut(kv, "=")
+		t.Setenv(k, v)
+	}
+
+	oldTransport := http.DefaultTransport
+	http.DefaultTransport = &fake.Transport{Server: s, Next: oldTransport}
+	t.Cleanup(func() { http.DefaultTransport = oldTransport })
+
+	transports, err := github.NewTransportManager(fake.AppID, s.PrivateKey(), zapr.NewLogger(zap.L()))
+	if err != nil {
+		t.Fatalf("Failed to create transport manager; %v", err)
+	}
+
+	f := &Fixture{
+		Manifest:   m,
+		Server:     s,
+		Registry:   NewRegistry(),
+		WorkDir:    filepath.Join(dir, "work"),
+		t:          t,
+		sourceDir:  filepath.Join(dir, "source"),
+		transports: transports,
+	}
+	if err := f.seed(filepath.Join(dir, "seed")); err != nil {
+		t.Fatalf("Failed to seed repositories; %v", err)
+	}
+	return f
+}
+
+// seed creates the repositories and pushes an initial commit to the source branch and the dest branches. The dest
+// branch is also pushed to the fork repositories since the fork branch is created from it.
+func (f *Fixture) seed(seedDir string) error {
+	branches := map[v1alpha1.GitHubRepo][]string{}
+	add := func(repo v1alpha1.GitHubRepo, branch string) {
+		key := v1alpha1.GitHubRepo{Org: repo.Org, Repo: repo.Repo}
+		branches[key] = append(branches[key], branch)
+	}
+	add(f.Manifest.Spec.SourceRepo, f.Manifest.Spec.SourceRepo.Branch)
+	for _, d := range f.Manifest.Destinations() {
+		add(d.DestRepo, d.DestRepo.Branch)
+		add(d.ForkRepo, d.DestRepo.Branch)
+	}
+
+	if err := os.MkdirAll(seedDir, 0o755); err != nil {
+		return errors.Wrapf(err, "Failed to create directory %v", seedDir)
+	}
+	if err := runGitCommands(seedDir, [][]string{
+		{"init"},
+		{"config", "user.email", "hydros@acme.com"},
+		{"config", "user.name", "hydros"},
+		{"commit", "--allow-empty", "-m", "initial commit"},
+	}); err != nil {
+		return err
+	}
+
+	for repo, bs := range branches {
+		if err := f.Server.CreateRepo(repo.Org, repo.Repo, bs[0]); err != nil {
+			return err
+		}
+		for _, b := range bs {
+			if _, err := runGit(seedDir, "push", f.repoDir(repo), "HEAD:refs/heads/"+b); err != nil {
+				return err
+			}
+		}
+	}
+
+	source := f.Manifest.Spec.SourceRepo
+	if _, err := runGit(seedDir, "clone", "--branch="+source.Branch, f.repoDir(source), f.sourceDir); err != nil {
+		return err
+	}
+	return runGitCommands(f.sourceDir, [][]string{
+		{"config", "user.email", "hydros@acme.com"},
+		{"config", "user.name", "hydros"},
+	})
+}
+
+// repoDir returns the bare repository backing repo.
+func (f *Fixture) repoDir(repo v1alpha1.GitHubRepo) string {
+	return filepath.Join(f.Server.ReposDir, repo.Org, repo.Repo+".git")
+}
+
+// WriteSourceFile writes contents to the file at path relative to the root of the source repository.
+// The change isn't visible to the Syncer until it is committed with CommitSource.
+func (f *Fixture) WriteSourceFile(path string, contents string) {
+	f.t.Helper()
+	p := filepath.Join(f.sourceDir, path)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		f.t.Fatalf("Failed to create directory %v; %v", filepath.Dir(p), err)
+	}
+	if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+		f.t.Fatalf("Failed to write %v; %v", p, err)
+	}
+}
+
+// WriteKustomization writes k to the kustomization.yaml file in dir relative to the root of the source repository.
+// The change isn't visible to the Syncer until it is committed with CommitSource.
+func (f *Fixture) WriteKustomization(dir string, k *kustomize.Kustomization) {
+	f.t.Helper()
+	b, err := yaml.Marshal(k)
+	if err != nil {
+		f.t.Fatalf("Failed to marshal kustomization; %v", err)
+	}
+	f.WriteSourceFile(filepath.Join(dir, "kustomization.yaml"), string(b))
+}
+
+// CommitSource commits all the changes to the source repository and pushes them to the source branch.
+// It returns the commit.
+func (f *Fixture) CommitSource(message string) string {
+	f.t.Helper()
+	return f.CommitSourceToBranch(f.Manifest.Spec.SourceRepo.Branch, message)
+}
+
+// CommitSourceToBranch commits all the changes to the source repository and pushes them to branch e.g. the branch
+// of a takeover. The changes are committed on top of the last commit on any branch so branch must not have
+// diverged from it.
+// It returns the commit.
+func (f *Fixture) CommitSourceToBranch(branch string, message string) string {
+	f.t.Helper()
+	if err := runGitCommands(f.sourceDir, [][]string{
+		{"add", "--all"},
+		{"commit", "--allow-empty", "-m", message},
+		{"push", "origin", "HEAD:refs/heads/" + branch},
+	}); err != nil {
+		f.t.Fatalf("Failed to commit changes to the source repository; %v", err)
+	}
+	commit, err := runGit(f.sourceDir, "rev-parse", "HEAD")
+	if err != nil {
+		f.t.Fatalf("Failed to get the source commit; %v", err)
+	}
+	return commit
+}
+
+// NewSyncer creates a Syncer for the ManifestSync that syncs the fixture's repositories and resolves images using
+// the Registry. opts are applied after the fixture's options.
+func (f *Fixture) NewSyncer(opts ...gitops.SyncerOption) (*gitops.Syncer, error) {
+	all := []gitops.SyncerOption{
+		gitops.SyncWithWorkDir(f.WorkDir),
+		gitops.SyncWithImageResolver(f.Registry),
+	}
+	return gitops.NewSyncer(f.Manifest, f.transports, append(all, opts...)...)
+}
+
+// NewTakeOverSyncer creates a Syncer which takes over the ManifestSync by hydrating branch of the source repository
+// and pausing regular syncs for pause. A negative pause is already expired.
+func (f *Fixture) NewTakeOverSyncer(branch string, pause time.Duration) (*gitops.Syncer, error) {
+	m := *f.Manifest
+	m.Spec.SourceRepo.Branch = branch
+	if err := gitops.SetTakeOverAnnotations(&m, pause, "hydrostest"); err != nil {
+		return nil, err
+	}
+	// N.B. The takeover uses its own work directory like hydros takeover run locally.
+	workDir, err := os.MkdirTemp(filepath.Dir(f.WorkDir), "takeover")
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create work directory for the takeover")
+	}
+	return gitops.NewSyncer(&m, f.transports, gitops.SyncWithWorkDir(workDir), gitops.SyncWithImageResolver(f.Registry))
+}
+
+// Syncer returns the Syncer used by RunOnce creating it if necessary.
+func (f *Fixture) Syncer() (*gitops.Syncer, error) {
+	if f.syncer == nil {
+		s, err := f.NewSyncer()
+		if err != nil {
+			return nil, err
+		}
+		f.syncer = s
+	}
+	return f.syncer, nil
+}
+
+// RunOnce runs the Syncer once. The Syncer is created the first time RunOnce is called and reused by later calls
+// so it behaves like a long running Syncer.
+func (f *Fixture) RunOnce(force bool) error {
+	s, err := f.Syncer()
+	if err != nil {
+		return err
+	}
+	return s.RunOnce(force)
+}
+
+// ReadDest returns the contents of the file at path relative to the dest path of the destination on the dest
+// branch. Use the empty string for the destination of a ManifestSync without destRepos.
+func (f *Fixture) ReadDest(destination string, path string) (string, error) {
+	for _, d := range f.Manifest.Destinations() {
+		if d.Name != destination {
+			continue
+		}
+		ref := fmt.Sprintf("%v:%v", d.DestRepo.Branch, filepath.ToSlash(filepath.Join(d.DestPath, path)))
+		cmd := exec.Command("git", "show", ref)
+		cmd.Dir = f.repoDir(d.DestRepo)
+		out, err := cmd.Output()
+		if err != nil {
+			return "", errors.Wrapf(err, "Failed to read %v from %v/%v", ref, d.DestRepo.Org, d.DestRepo.Repo)
+		}
+		return string(out), nil
+	}
+	return "", errors.Errorf("ManifestSync %v doesn't have destination %q", f.Manifest.Metadata.Name, destination)
+}
+
+// runGit runs git in dir and returns the trimmed output.
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", errors.Wrapf(err, "git %v failed; output:\n%s", strings.Join(args, " "), out)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func runGitCommands(dir string, commands [][]string) error {
+	for _, args := range commands {
+		if _, err := runGit(dir, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,378 @@
+package hydrostest
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/gitops"
+	"github.com/jlewi/hydros/pkg/util"
+	kustomize "sigs.k8s.io/kustomize/api/types"
+)
+
+const deployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: us-west1-docker.pkg.dev/acme/images/web:latest
+`
+
+func newManifestSync(format v1alpha1.SourceFormat) *v1alpha1.ManifestSync {
+	m := &v1alpha1.ManifestSync{
+		APIVersion: v1alpha1.Group + "/" + v1alpha1.Version,
+		Kind:       "ManifestSync",
+		Metadata: v1alpha1.Metadata{
+			Name: "web",
+		},
+		Spec: v1alpha1.ManifestSyncSpec{
+			SourceRepo: v1alpha1.GitHubRepo{Org: "acme", Repo: "app", Branch: "main"},
+			ForkRepo:   v1alpha1.GitHubRepo{Org: "acme", Repo: "manifests", Branch: "hydros/web"},
+			DestRepo:   v1alpha1.GitHubRepo{Org: "acme", Repo: "manifests", Branch: "main"},
+			SourcePath: "manifests",
+			DestPath:   "web",
+			ImageTagsToPin: []v1alpha1.ImageTagToPin{
+				{Tags: []string{"latest"}, Strategy: v1alpha1.MutableTagStrategy},
+			},
+			SourceFormat: format,
+		},
+	}
+	if format == v1alpha1.KustomizeSourceFormat {
+		m.Spec.MatchAnnotations = map[string]string{"hydros.dev/hydrate": "true"}
+	}
+	return m
+}
+
+func Test_FixturePlainManifests(t *testing.T) {
+	util.SetupLogger("info", true)
+	f := New(t, newManifestSync(v1alpha1.YAMLSourceFormat))
+	if err := f.Registry.Push("us-west1-docker.pkg.dev/acme/images/web:latest", "sha256:1234"); err != nil {
+		t.Fatalf("Failed to push image; %v", err)
+	}
+	f.WriteSourceFile("manifests/web/deployment.yaml", deployment)
+	commit := f.CommitSource("Add web")
+
+	if err := f.RunOnce(false); err != nil {
+		t.Fatalf("RunOnce failed; %+v", err)
+	}
+
+	actual, err := f.ReadDest("", "web/deployment.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read hydrated manifest; %v", err)
+	}
+	if !strings.Contains(actual, "image: us-west1-docker.pkg.dev/acme/images/web:latest@sha256:1234") {
+		t.Errorf("Image wasn't pinned; got:\n%v", actual)
+	}
+
+	status, err := f.ReadDest("", ".lastsync.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read sync file; %v", err)
+	}
+	if !strings.Contains(status, commit) {
+		t.Errorf("Sync file doesn't record source commit %v; got:\n%v", commit, status)
+	}
+
+	prs := f.Server.PullRequests()
+	if len(prs) != 1 || prs[0].State != "MERGED" {
+		t.Fatalf("Expected one merged PR; got %+v", prs)
+	}
+
+	// Nothing changed so a second run shouldn't create a PR.
+	if err := f.RunOnce(false); err != nil {
+		t.Fatalf("RunOnce failed; %+v", err)
+	}
+	if prs := f.Server.PullRequests(); len(prs) != 1 {
+		t.Errorf("Expected no new PRs; got %+v", prs)
+	}
+
+	// Moving the tag should cause the image to be repinned.
+	if err := f.Registry.Push("us-west1-docker.pkg.dev/acme/images/web:latest", "sha256:5678"); err != nil {
+		t.Fatalf("Failed to push image; %v", err)
+	}
+	if err := f.RunOnce(false); err != nil {
+		t.Fatalf("RunOnce failed; %+v", err)
+	}
+	actual, err = f.ReadDest("", "web/deployment.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read hydrated manifest; %v", err)
+	}
+	if !strings.Contains(actual, "@sha256:5678") {
+		t.Errorf("Image wasn't repinned; got:\n%v", actual)
+	}
+}
+
+func Test_FixtureKustomize(t *testing.T) {
+	if _, err := exec.LookPath("kustomize"); err != nil {
+		t.Skip("Skipping test; kustomize isn't installed")
+	}
+	util.SetupLogger("info", true)
+	f := New(t, newManifestSync(v1alpha1.KustomizeSourceFormat))
+	if err := f.Registry.Push("us-west1-docker.pkg.dev/acme/images/web:latest", "sha256:1234"); err != nil {
+		t.Fatalf("Failed to push image; %v", err)
+	}
+	f.WriteSourceFile("manifests/web/base/deployment.yaml", deployment)
+	f.WriteKustomization("manifests/web/base", &kustomize.Kustomization{
+		Resources: []string{"deployment.yaml"},
+	})
+	f.WriteKustomization("manifests/web/dev", &kustomize.Kustomization{
+		CommonAnnotations: map[string]string{"hydros.dev/hydrate": "true"},
+		Resources:         []string{"../base"},
+		Images: []kustomize.Image{
+			{Name: "us-west1-docker.pkg.dev/acme/images/web", NewName: "us-west1-docker.pkg.dev/acme/images/web", NewTag: "latest"},
+		},
+	})
+	f.CommitSource("Add web")
+
+	if err := f.RunOnce(false); err != nil {
+		t.Fatalf("RunOnce failed; %+v", err)
+	}
+
+	actual, err := f.ReadDest("", "web/apps_v1_deployment_web.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read hydrated manifest; %v", err)
+	}
+	if !strings.Contains(actual, "@sha256:1234") {
+		t.Errorf("Image wasn't pinned; got:\n%v", actual)
+	}
+}
+
+func Test_FixtureVersionSkew(t *testing.T) {
+	util.SetupLogger("info", true)
+	m := newManifestSync(v1alpha1.YAMLSourceFormat)
+	m.Spec.VersionSkew = v1alpha1.FailGuardAction
+	f := New(t, m)
+	if err := f.Registry.Push("us-west1-docker.pkg.dev/acme/images/web:latest", "sha256:1234"); err != nil {
+		t.Fatalf("Failed to push image; %v", err)
+	}
+	f.WriteSourceFile("manifests/web/deployment.yaml", deployment)
+	f.CommitSource("Add web")
+
+	old, err := f.NewSyncer(gitops.SyncWithHydrosVersion("v0.1.3"))
+	if err != nil {
+		t.Fatalf("Failed to create syncer; %v", err)
+	}
+	if err := old.RunOnce(false); err != nil {
+		t.Fatalf("RunOnce failed; %+v", err)
+	}
+	status, err := f.ReadDest("", ".lastsync.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read sync file; %v", err)
+	}
+	if !strings.Contains(status, "hydrosVersion: v0.1.3") {
+		t.Errorf("Sync file doesn't record the hydros version; got:\n%v", status)
+	}
+
+	// A newer minor version shouldn't sync unless it is forced.
+	f.WriteSourceFile("manifests/web/deployment.yaml", strings.Replace(deployment, "name: web\n", "name: web2\n", 1))
+	f.CommitSource("Rename web")
+	current, err := f.NewSyncer(gitops.SyncWithHydrosVersion("v0.2.0"))
+	if err != nil {
+		t.Fatalf("Failed to create syncer; %v", err)
+	}
+	if err := current.RunOnce(false); err == nil {
+		t.Fatalf("Expected the sync to fail because of the version skew")
+	}
+	if prs := f.Server.PullRequests(); len(prs) != 1 {
+		t.Fatalf("Expected no new PRs; got %+v", prs)
+	}
+
+	if err := current.RunOnce(true); err != nil {
+		t.Fatalf("RunOnce failed; %+v", err)
+	}
+	prs := f.Server.PullRequests()
+	if len(prs) != 2 {
+		t.Fatalf("Expected a second PR; got %+v", prs)
+	}
+	if !strings.Contains(prs[1].Body, "last hydrated by hydros v0.1.3") {
+		t.Errorf("PR description doesn't note the version skew; got:\n%v", prs[1].Body)
+	}
+	status, err = f.ReadDest("", ".lastsync.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read sync file; %v", err)
+	}
+	if !strings.Contains(status, "hydrosVersion: v0.2.0") {
+		t.Errorf("Sync file doesn't record the new hydros version; got:\n%v", status)
+	}
+}
+
+func Test_FixturePromotedTag(t *testing.T) {
+	util.SetupLogger("info", true)
+	m := newManifestSync(v1alpha1.YAMLSourceFormat)
+	m.Spec.ImageTagsToPin = []v1alpha1.ImageTagToPin{
+		{Tags: []string{"latest"}, Strategy: v1alpha1.PromotedTagStrategy, PromotionTag: "prod"},
+	}
+	f := New(t, m)
+	if err := f.Registry.Push("us-west1-docker.pkg.dev/acme/images/web:latest", "sha256:1234"); err != nil {
+		t.Fatalf("Failed to push image; %v", err)
+	}
+	if err := f.Registry.Push("us-west1-docker.pkg.dev/acme/images/web:prod", "sha256:5678"); err != nil {
+		t.Fatalf("Failed to push image; %v", err)
+	}
+	f.WriteSourceFile("manifests/web/deployment.yaml", deployment)
+	f.CommitSource("Add web")
+
+	if err := f.RunOnce(false); err != nil {
+		t.Fatalf("RunOnce failed; %+v", err)
+	}
+
+	actual, err := f.ReadDest("", "web/deployment.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read hydrated manifest; %v", err)
+	}
+	if !strings.Contains(actual, "image: us-west1-docker.pkg.dev/acme/images/web:prod@sha256:5678") {
+		t.Errorf("Image wasn't pinned to the promoted tag; got:\n%v", actual)
+	}
+}
+
+func Test_FixtureCommitPerOverlay(t *testing.T) {
+	util.SetupLogger("info", true)
+	m := newManifestSync(v1alpha1.YAMLSourceFormat)
+	m.Spec.CommitPerOverlay = true
+	f := New(t, m)
+	if err := f.Registry.Push("us-west1-docker.pkg.dev/acme/images/web:latest", "sha256:1234"); err != nil {
+		t.Fatalf("Failed to push image; %v", err)
+	}
+	f.WriteSourceFile("manifests/web/deployment.yaml", deployment)
+	f.CommitSource("Add web")
+
+	if err := f.RunOnce(false); err != nil {
+		t.Fatalf("RunOnce failed; %+v", err)
+	}
+
+	// Squashing the PR would lose the commit of each overlay so it must be rebased.
+	prs := f.Server.PullRequests()
+	if len(prs) != 1 || prs[0].State != "MERGED" {
+		t.Fatalf("Expected one merged PR; got %+v", prs)
+	}
+	if prs[0].MergeMethod != "REBASE" {
+		t.Errorf("Expected the PR to be merged with REBASE; got %v", prs[0].MergeMethod)
+	}
+}
+
+func Test_FixtureTakeOver(t *testing.T) {
+	util.SetupLogger("info", true)
+	f := New(t, newManifestSync(v1alpha1.YAMLSourceFormat))
+	if err := f.Registry.Push("us-west1-docker.pkg.dev/acme/images/web:latest", "sha256:1234"); err != nil {
+		t.Fatalf("Failed to push image; %v", err)
+	}
+	f.WriteSourceFile("manifests/web/deployment.yaml", deployment)
+	f.CommitSource("Add web")
+	if err := f.RunOnce(false); err != nil {
+		t.Fatalf("RunOnce failed; %+v", err)
+	}
+
+	// Take over the sync with a branch that scales up the deployment.
+	f.WriteSourceFile("manifests/web/deployment.yaml", strings.Replace(deployment, "spec:\n  template:", "spec:\n  replicas: 3\n  template:", 1))
+	f.CommitSourceToBranch("dev", "Scale up web")
+	takeover, err := f.NewTakeOverSyncer("dev", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create takeover syncer; %+v", err)
+	}
+	if err := takeover.RunOnce(true); err != nil {
+		t.Fatalf("Takeover failed; %+v", err)
+	}
+	assertDest := func(expected string, present bool) {
+		t.Helper()
+		actual, err := f.ReadDest("", "web/deployment.yaml")
+		if err != nil {
+			t.Fatalf("Failed to read hydrated manifest; %v", err)
+		}
+		if strings.Contains(actual, expected) != present {
+			t.Errorf("Expected %q in the hydrated manifest to be %v; got:\n%v", expected, present, actual)
+		}
+	}
+	assertDest("replicas: 3", true)
+	status, err := f.ReadDest("", ".lastsync.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read sync file; %v", err)
+	}
+	if !strings.Contains(status, "pausedUntil") {
+		t.Errorf("Sync file doesn't record the pause of the takeover; got:\n%v", status)
+	}
+
+	// Regular syncs are paused during the takeover even though the source and the image changed.
+	f.WriteSourceFile("manifests/web/deployment.yaml", deployment)
+	f.CommitSource("Revert scale up")
+	if err := f.Registry.Push("us-west1-docker.pkg.dev/acme/images/web:latest", "sha256:5678"); err != nil {
+		t.Fatalf("Failed to push image; %v", err)
+	}
+	numPRs := len(f.Server.PullRequests())
+	if err := f.RunOnce(false); err != nil {
+		t.Fatalf("RunOnce failed; %+v", err)
+	}
+	if prs := f.Server.PullRequests(); len(prs) != numPRs {
+		t.Errorf("Expected no PRs while paused; got %+v", prs[numPRs:])
+	}
+	assertDest("replicas: 3", true)
+	s, err := f.Syncer()
+	if err != nil {
+		t.Fatalf("Failed to get syncer; %v", err)
+	}
+	blocked := v1alpha1.GetCondition(s.Conditions(), v1alpha1.BlockedCondition)
+	if blocked == nil || blocked.Status != v1alpha1.ConditionTrue || blocked.Reason != "Paused" {
+		t.Errorf("Expected the sync to be blocked because it is paused; got %+v", blocked)
+	}
+
+	// Releasing the takeover hydrates the source branch ignoring the pause.
+	if err := s.Release(); err != nil {
+		t.Fatalf("Release failed; %+v", err)
+	}
+	assertDest("replicas: 3", false)
+	assertDest("@sha256:5678", true)
+	status, err = f.ReadDest("", ".lastsync.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read sync file; %v", err)
+	}
+	if strings.Contains(status, "pausedUntil") {
+		t.Errorf("Sync file still records the pause after the release; got:\n%v", status)
+	}
+}
+
+func Test_FixtureTakeOverExpired(t *testing.T) {
+	util.SetupLogger("info", true)
+	f := New(t, newManifestSync(v1alpha1.YAMLSourceFormat))
+	if err := f.Registry.Push("us-west1-docker.pkg.dev/acme/images/web:latest", "sha256:1234"); err != nil {
+		t.Fatalf("Failed to push image; %v", err)
+	}
+	f.WriteSourceFile("manifests/web/deployment.yaml", deployment)
+	f.CommitSource("Add web")
+
+	// The takeover hydrates the same commit and images as a regular sync so only the expired pause differs.
+	takeover, err := f.NewTakeOverSyncer("main", -time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create takeover syncer; %+v", err)
+	}
+	if err := takeover.RunOnce(true); err != nil {
+		t.Fatalf("Takeover failed; %+v", err)
+	}
+	numPRs := len(f.Server.PullRequests())
+
+	// Nothing changed but the pause expired so the sync is forced to end the takeover.
+	if err := f.RunOnce(false); err != nil {
+		t.Fatalf("RunOnce failed; %+v", err)
+	}
+	if prs := f.Server.PullRequests(); len(prs) != numPRs+1 {
+		t.Fatalf("Expected the expired takeover to force a sync; got PRs %+v", prs)
+	}
+	status, err := f.ReadDest("", ".lastsync.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read sync file; %v", err)
+	}
+	if strings.Contains(status, "pausedUntil") {
+		t.Errorf("Sync file still records the pause of the takeover; got:\n%v", status)
+	}
+
+	// Once the takeover has ended syncs are only run when something changes.
+	if err := f.RunOnce(false); err != nil {
+		t.Fatalf("RunOnce failed; %+v", err)
+	}
+	if prs := f.Server.PullRequests(); len(prs) != numPRs+1 {
+		t.Errorf("Expected no new PRs; got %+v", prs[numPRs+1:])
+	}
+}
@@ -0,0 +1,54 @@
+package hydrostest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+)
+
+// Registry is a stub image registry. It implements gitops.ImageResolver by resolving images to the shas they
+// were pushed with.
+type Registry struct {
+	mu     sync.Mutex
+	images map[util.DockerImageRef]string
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		images: map[util.DockerImageRef]string{},
+	}
+}
+
+// Push adds the image to the registry. image is the URL of the image including its tag
+// e.g. us-west1-docker.pkg.dev/acme/images/app:latest; sha should be of the form sha256:1234...
+// Pushing a tag that already exists moves the tag to sha.
+func (r *Registry) Push(image string, sha string) error {
+	ref, err := util.ParseImageURL(image)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to parse image %v", image)
+	}
+	ref.Sha = ""
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.images[*ref] = sha
+	return nil
+}
+
+// ResolveImageToSha returns the image with the sha its tag was pushed with.
+func (r *Registry) ResolveImageToSha(ctx context.Context, ref util.DockerImageRef, strategy v1alpha1.Strategy) (util.DockerImageRef, error) {
+	key := ref
+	key.Sha = ""
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sha, ok := r.images[key]
+	if !ok {
+		return ref, errors.Errorf("Image %v doesn't exist in the registry", key.ToURL())
+	}
+	resolved := ref
+	resolved.Sha = sha
+	return resolved, nil
+}
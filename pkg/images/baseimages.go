@@ -0,0 +1,73 @@
+package images
+
+import (
+	"bufio"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
+)
+
+// BaseImages returns the base images of the stages of a Dockerfile in the order they appear. Stages built from
+// earlier stages, scratch and images that depend on build args e.g. FROM golang:${GO_VERSION} aren't included
+// because they don't refer to an image that can be resolved.
+func BaseImages(dockerfile string) []string {
+	images := []string{}
+	seen := map[string]bool{}
+	stages := map[string]bool{}
+
+	scanner := bufio.NewScanner(strings.NewReader(dockerfile))
+	line := ""
+	for scanner.Scan() {
+		// Handle instructions continued over multiple lines.
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasSuffix(text, "\\") {
+			line += strings.TrimSuffix(text, "\\") + " "
+			continue
+		}
+		line += text
+		fields := strings.Fields(line)
+		line = ""
+
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		args := []string{}
+		for _, f := range fields[1:] {
+			// Skip flags e.g. --platform=linux/amd64
+			if strings.HasPrefix(f, "--") {
+				continue
+			}
+			args = append(args, f)
+		}
+		if len(args) == 0 {
+			continue
+		}
+		image := args[0]
+		if len(args) == 3 && strings.EqualFold(args[1], "AS") {
+			stages[strings.ToLower(args[2])] = true
+		}
+
+		if strings.Contains(image, "$") || image == "scratch" || stages[strings.ToLower(image)] || seen[image] {
+			continue
+		}
+		seen[image] = true
+		images = append(images, image)
+	}
+	return images
+}
+
+// ResolveDigest returns the digest of the image e.g. sha256:1234. For multi-arch images it is the digest of the
+// manifest list.
+func ResolveDigest(image string) (string, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to parse image %v", image)
+	}
+	desc, err := remote.Head(ref, remote.WithAuthFromKeychain(keychain))
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to get the digest of image %v", image)
+	}
+	return desc.Digest.String(), nil
+}
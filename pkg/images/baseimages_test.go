@@ -0,0 +1,52 @@
+package images
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_BaseImages(t *testing.T) {
+	type testCase struct {
+		name       string
+		dockerfile string
+		expected   []string
+	}
+
+	cases := []testCase{
+		{
+			name: "multi-stage",
+			dockerfile: `ARG GO_VERSION=1.21
+FROM --platform=$BUILDPLATFORM golang:1.21 AS builder
+RUN go build ./...
+
+FROM builder AS test
+RUN go test ./...
+
+FROM golang:${GO_VERSION} AS tools
+
+from gcr.io/distroless/static:nonroot
+COPY --from=builder /app /app
+`,
+			expected: []string{"golang:1.21", "gcr.io/distroless/static:nonroot"},
+		},
+		{
+			name: "continuation",
+			dockerfile: `FROM \
+  debian:bookworm@sha256:1234
+FROM scratch
+FROM debian:bookworm@sha256:1234
+`,
+			expected: []string{"debian:bookworm@sha256:1234"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual := BaseImages(c.dockerfile)
+			if d := cmp.Diff(c.expected, actual); d != "" {
+				t.Errorf("Unexpected diff:\n%s", d)
+			}
+		})
+	}
+}
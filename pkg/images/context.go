@@ -0,0 +1,159 @@
+package images
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/go-logr/zapr"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// ContextSnippet is a fragment of an Image defining only the sources of a build context. It lets the mapping
+// logic of hydros assemble contexts for other build systems without defining a complete Image. If the snippet
+// only has mappings they are applied to the directory containing the snippet.
+type ContextSnippet struct {
+	// Source are the sources of the context; see ImageSpec.Source.
+	Source []*v1alpha1.ImageSource `yaml:"source,omitempty"`
+	// Mappings are the mappings of a source consisting of the directory containing the snippet.
+	Mappings []*v1alpha1.SourceMapping `yaml:"mappings,omitempty"`
+}
+
+// CreateContextFile creates the build context of an image defined in the file at path and writes it as a gzipped
+// tarball to output; a local path or a URI e.g. gs://bucket/context.tgz. The file can contain Image resources or
+// a ContextSnippet. If the file contains multiple images name selects the image. Local sources without a scheme
+// are resolved relative to the directory containing the file. The returned image reports the files matched by
+// each mapping in its status.
+func CreateContextFile(ctx context.Context, path string, output string, name string, opts ...ControllerOption) (*v1alpha1.Image, error) {
+	log := zapr.NewLogger(zap.L())
+
+	manifestPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get absolute path for %v", path)
+	}
+
+	image, err := readContextImage(manifestPath, name)
+	if err != nil {
+		return nil, err
+	}
+	resolveLocalSources(image.Spec.Source, filepath.Dir(manifestPath))
+
+	// N.B. Don't use NewController because creating the context doesn't require the GCP clients and therefore
+	// credentials.
+	c := &Controller{}
+	// The repository is only used to replace git sources with the local checkout so snippets don't need to be
+	// in a repository.
+	if gitRepo, w, err := openLocalRepo(manifestPath); err != nil {
+		log.V(1).Info("File isn't in a git repository; git sources won't be replaced with a local checkout", "path", manifestPath, "err", err)
+	} else {
+		c.localRepos = []GitRepoRef{{Repo: gitRepo, W: w}}
+		headRef, err := gitRepo.Head()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error getting head ref")
+		}
+		image.Status.SourceCommit = headRef.Hash().String()
+	}
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.BuildContext(ctx, image, output); err != nil {
+		return nil, err
+	}
+	log.Info("Wrote build context", "image", image.Metadata.Name, "tarball", output)
+	return image, nil
+}
+
+// readContextImage reads the image whose context should be created from the file at path.
+func readContextImage(path string, name string) (*v1alpha1.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open file: %v", path)
+	}
+	defer f.Close()
+
+	found := []*v1alpha1.Image{}
+	d := yaml.NewDecoder(f)
+	for {
+		node := &yaml.Node{}
+		if err := d.Decode(node); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, errors.Wrapf(err, "Failed to decode %v", path)
+		}
+
+		meta := struct {
+			Kind string `yaml:"kind"`
+		}{}
+		if err := node.Decode(&meta); err != nil {
+			return nil, errors.Wrapf(err, "Failed to decode %v", path)
+		}
+
+		switch meta.Kind {
+		case v1alpha1.ImageGVK.Kind:
+			image := &v1alpha1.Image{}
+			if err := node.Decode(image); err != nil {
+				return nil, errors.Wrapf(err, "Failed to decode image from %v", path)
+			}
+			found = append(found, image)
+		case "":
+			snippet := &ContextSnippet{}
+			if err := node.Decode(snippet); err != nil {
+				return nil, errors.Wrapf(err, "Failed to decode the context snippet in %v", path)
+			}
+			image := &v1alpha1.Image{Metadata: v1alpha1.Metadata{Name: "context"}}
+			image.Spec.Source = snippet.Source
+			if len(snippet.Mappings) > 0 {
+				image.Spec.Source = append(image.Spec.Source, &v1alpha1.ImageSource{
+					URI:      "file://" + filepath.Dir(path),
+					Mappings: snippet.Mappings,
+				})
+			}
+			if len(image.Spec.Source) == 0 {
+				return nil, errors.Errorf("The context snippet in %v doesn't have any sources or mappings", path)
+			}
+			found = append(found, image)
+		}
+	}
+
+	if name != "" {
+		for _, image := range found {
+			if image.Metadata.Name == name {
+				return image, nil
+			}
+		}
+		return nil, errors.Errorf("%v doesn't have an image named %v", path, name)
+	}
+	switch len(found) {
+	case 0:
+		return nil, errors.Errorf("%v doesn't have any images or context snippets", path)
+	case 1:
+		return found[0], nil
+	default:
+		return nil, errors.Errorf("%v has %v images; use the name to select one", path, len(found))
+	}
+}
+
+// resolveLocalSources replaces the URIs of sources that are local paths without a scheme with absolute file://
+// URIs. Relative paths are resolved relative to baseDir.
+func resolveLocalSources(sources []*v1alpha1.ImageSource, baseDir string) {
+	for _, s := range sources {
+		u, err := url.Parse(s.URI)
+		if err != nil || u.Scheme != "" {
+			continue
+		}
+		p := s.URI
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(baseDir, p)
+		}
+		s.URI = "file://" + p
+	}
+}
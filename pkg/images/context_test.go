@@ -0,0 +1,113 @@
+package images
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/pkg/tarutil"
+)
+
+func Test_CreateContextFile(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"Dockerfile":      "FROM scratch\n",
+		"src/main.go":     "package main\n",
+		"src/README.md":   "readme\n",
+		"assets/logo.png": "png\n",
+		"snippet.yaml": `mappings:
+- src: Dockerfile
+- src: src/*.go
+source:
+- uri: assets
+  mappings:
+  - src: "*.png"
+    dest: static
+`,
+		"images/images.yaml": `apiVersion: hydros.dev/v1alpha1
+kind: Image
+metadata:
+  name: app
+spec:
+  image: us-west1-docker.pkg.dev/acme/images/app
+  source:
+  - uri: ..
+    mappings:
+    - src: Dockerfile
+---
+apiVersion: hydros.dev/v1alpha1
+kind: Image
+metadata:
+  name: other
+spec:
+  image: us-west1-docker.pkg.dev/acme/images/other
+  source:
+  - uri: ../src
+    mappings:
+    - src: README.md
+`,
+	}
+	for name, contents := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("Could not create directory %v", err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatalf("Could not write file %v", err)
+		}
+	}
+
+	type testCase struct {
+		name     string
+		file     string
+		image    string
+		expected []string
+		wantErr  bool
+	}
+
+	cases := []testCase{
+		{
+			name: "snippet",
+			file: "snippet.yaml",
+			// The sources are added before the mappings of the snippet's directory.
+			expected: []string{"static/logo.png", "Dockerfile", "src/main.go"},
+		},
+		{
+			name:     "image",
+			file:     "images/images.yaml",
+			image:    "other",
+			expected: []string{"README.md"},
+		},
+		{
+			name:    "ambiguous",
+			file:    "images/images.yaml",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "context.tgz")
+			_, err := CreateContextFile(context.Background(), filepath.Join(dir, c.file), output, c.image)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateContextFile failed; %+v", err)
+			}
+			entries, err := tarutil.List(output)
+			if err != nil {
+				t.Fatalf("Failed to list build context; %v", err)
+			}
+			if d := cmp.Diff(c.expected, entries); d != "" {
+				t.Errorf("Unexpected build context entries; diff:\n%v", d)
+			}
+		})
+	}
+}
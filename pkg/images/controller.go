@@ -16,10 +16,16 @@ import (
 	cb "cloud.google.com/go/cloudbuild/apiv1"
 	cbpb "cloud.google.com/go/cloudbuild/apiv1/v2/cloudbuildpb"
 	longrunning "cloud.google.com/go/longrunning/autogen"
+	"cloud.google.com/go/longrunning/autogen/longrunningpb"
 	"cloud.google.com/go/storage"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
+	"github.com/google/go-containerregistry/pkg/crane"
 	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/callbacks"
+	"github.com/jlewi/hydros/pkg/config"
+	"github.com/jlewi/hydros/pkg/controllers"
 	"github.com/jlewi/hydros/pkg/gcp"
 	"github.com/jlewi/hydros/pkg/gitutil"
 	"github.com/jlewi/hydros/pkg/tarutil"
@@ -35,6 +41,24 @@ import (
 	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
 )
 
+const (
+	// defaultWaitTimeout is how long to wait for a build if no timeout is specified.
+	defaultWaitTimeout = 1 * time.Hour
+	// cancelTimeout is how long to wait for a request to cancel a build.
+	cancelTimeout = 1 * time.Minute
+
+	// ExportDirPrefix is the prefix of the temporary directories images are exported to.
+	ExportDirPrefix = "hydrosImageReconciler"
+
+	// Reasons of the conditions of images.
+	buildStartedReason   = "BuildStarted"
+	buildSucceededReason = "BuildSucceeded"
+	buildFailedReason    = "BuildFailed"
+	imageExistsReason    = "ImageExists"
+	notBuiltReason       = "NotBuilt"
+	testsFailedReason    = "TestsFailed"
+)
+
 // GitRepoRef is a reference to a git repository.
 // TODO(jeremy): Is this neccessary? Can we retrieve the Repo from the work tree?
 // I don't think we want to always reconstruct the workTree from gitRepos because that could be expensive
@@ -53,9 +77,45 @@ type Controller struct {
 
 	// pointers to one or more repositories that have already been cloned.
 	localRepos []GitRepoRef
+
+	// mirrors to pull docker:// sources through
+	mirrors *registryMirrors
+
+	// followLogs copies the logs of builds into the log while waiting for them.
+	followLogs bool
+
+	// callbacks sends the results of builds to the callbacks of images.
+	callbacks *callbacks.Sender
+}
+
+// ControllerOption is an option for the image controller.
+type ControllerOption func(c *Controller) error
+
+// WithRegistryMirrors creates an option to pull docker:// sources through the specified mirrors.
+func WithRegistryMirrors(mirrors []config.RegistryMirror) ControllerOption {
+	return func(c *Controller) error {
+		if len(mirrors) == 0 {
+			return nil
+		}
+		m, err := newRegistryMirrors(mirrors)
+		if err != nil {
+			return err
+		}
+		c.mirrors = m
+		return nil
+	}
+}
+
+// WithFollowLogs creates an option to copy the logs of builds into the log as they are written so the output of
+// the build is visible inline e.g. in CI.
+func WithFollowLogs() ControllerOption {
+	return func(c *Controller) error {
+		c.followLogs = true
+		return nil
+	}
 }
 
-func NewController() (*Controller, error) {
+func NewController(opts ...ControllerOption) (*Controller, error) {
 	resolver, err := gcp.NewImageResolver(context.Background())
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to create image resolver")
@@ -79,13 +139,26 @@ func NewController() (*Controller, error) {
 		return nil, errors.Wrapf(err, "Failed to create GCS storage client")
 	}
 
-	return &Controller{
+	sender, err := callbacks.NewSender()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create the sender for callbacks")
+	}
+
+	controller := &Controller{
 		resolver:   resolver,
 		opsClient:  c,
 		cbClient:   client,
 		gcsClient:  gcsClient,
 		localRepos: make([]GitRepoRef, 0),
-	}, nil
+		callbacks:  sender,
+	}
+
+	for _, o := range opts {
+		if err := o(controller); err != nil {
+			return nil, err
+		}
+	}
+	return controller, nil
 }
 
 func (c *Controller) ReconcileNode(ctx context.Context, n *kyaml.RNode) error {
@@ -94,14 +167,57 @@ func (c *Controller) ReconcileNode(ctx context.Context, n *kyaml.RNode) error {
 		return errors.Wrapf(err, "Failed to decode Image")
 	}
 
-	return c.Reconcile(ctx, image)
+	err := c.Reconcile(ctx, image)
+	if cErr := controllers.SetConditions(n, image.Status.Conditions); cErr != nil {
+		util.LogFromContext(ctx).Error(cErr, "Failed to record the conditions of the image", "image", image.Metadata.Name)
+	}
+	return err
 }
 
 // Reconcile an image. This will build the image if necessary and resolve the image to a sha.
 // Status is updated with status about the image.
 // basePath is the basePath to resolve paths against
 func (c *Controller) Reconcile(ctx context.Context, image *v1alpha1.Image) error {
-	log := util.LogFromContext(ctx)
+	return c.reconcile(ctx, image, false)
+}
+
+// Rebuild builds the image even if an image tagged with the source commit already exists e.g. to pick up a new
+// version of its base image. The tags of the existing image are moved to the new image.
+func (c *Controller) Rebuild(ctx context.Context, image *v1alpha1.Image) error {
+	return c.reconcile(ctx, image, true)
+}
+
+// reconcile reconciles the image and sets its conditions based on the result.
+func (c *Controller) reconcile(ctx context.Context, image *v1alpha1.Image, force bool) error {
+	start := time.Now()
+	err := c.reconcileImage(ctx, image, force)
+	setImageConditions(image, err)
+	c.sendCallbacks(ctx, image, start, err)
+	return err
+}
+
+// sendCallbacks sends the result of reconciling the image to its callbacks if the image was built or the
+// reconcile failed. Failing to notify the callbacks doesn't fail the reconcile so errors are only logged.
+func (c *Controller) sendCallbacks(ctx context.Context, image *v1alpha1.Image, start time.Time, err error) {
+	if len(image.Spec.Callbacks) == 0 || c.callbacks == nil {
+		return
+	}
+	building := v1alpha1.GetCondition(image.Status.Conditions, v1alpha1.BuildingCondition)
+	built := building != nil && (building.Reason == buildSucceededReason || building.Reason == buildFailedReason)
+	if err == nil && !built {
+		return
+	}
+	result := callbacks.NewResult(v1alpha1.ImageGVK.Kind, image.Metadata, start, err)
+	result.SourceCommit = image.Status.SourceCommit
+	result.Image = image.Status.URI
+	result.Conditions = image.Status.Conditions
+	// N.B. Send logs the callbacks that failed.
+	_ = c.callbacks.Send(ctx, image.Spec.Callbacks, result)
+}
+
+func (c *Controller) reconcileImage(ctx context.Context, image *v1alpha1.Image, force bool) error {
+	log := util.LogFromContext(ctx).WithValues(v1alpha1.LogValues(image.Spec.Owners, image.Spec.Notify)...)
+	ctx = logr.NewContext(ctx, log)
 	log.Info("Reconciling image", "image", image.Metadata.Name)
 
 	if errs, valid := image.IsValid(); !valid {
@@ -129,16 +245,18 @@ func (c *Controller) Reconcile(ctx context.Context, image *v1alpha1.Image) error
 	imageRef.Tag = image.Status.SourceCommit
 
 	// Check if the image already exists
-	resolved, err := c.resolver.ResolveImageToSha(*imageRef, v1alpha1.MutableTagStrategy)
+	resolved, err := c.resolver.ResolveImageToSha(ctx, *imageRef, v1alpha1.MutableTagStrategy)
 
-	if err == nil {
+	if err == nil && !force {
 		log.Info("URI already exists", "image", image.Spec.Image, "sha", resolved.Sha)
 		image.Status.URI = resolved.ToURL()
 		image.Status.SHA = resolved.Sha
 		return nil
 	}
 
-	if status.Code(err) != codes.NotFound {
+	if err == nil {
+		log.Info("URI already exists; rebuilding it", "image", image.Spec.Image, "sha", resolved.Sha)
+	} else if status.Code(err) != codes.NotFound {
 		log.Error(err, "There was an error checking if the image already exists")
 		return err
 	}
@@ -169,24 +287,28 @@ func (c *Controller) Reconcile(ctx context.Context, image *v1alpha1.Image) error
 	// to create the tarball changes it gets picked up.
 	if !exists {
 		log.Info("Creating tarball", "image", image.Spec.Image, "tarball", tarFilePath)
-
-		// N.B. we need export any docker images specified as sources
-		// This will rewrite the image.Spec.ImageSource to point to the tarballs
-		transformed, err := c.exportImages(ctx, image)
-		if err != nil {
+		if err := c.buildContext(ctx, image, tarFilePath); err != nil {
+			// Delete the tarball so a partial tarball isn't reused the next time the image is built.
+			if delErr := c.deleteTarball(ctx, gcsPath); delErr != nil {
+				log.Error(delErr, "Failed to delete tarball", "tarball", tarFilePath)
+			}
 			return err
 		}
-
-		if err := tarutil.Build(transformed, tarFilePath); err != nil {
-			return errors.Wrapf(err, "Failed to create tarball %s", tarFilePath)
-		}
 	} else {
 		log.Info("Tarball exists", "image", image.Spec.Image, "tarball", tarFilePath)
 	}
 
 	log.Info("URI doesn't exist; building", "image", image.Spec.Image, "imageRef", imageRef)
 
+	cacheFrom := image.Spec.Builder.GCB.CacheFrom
 	build := gcp.DefaultBuild()
+	if len(image.Spec.Platforms) > 0 {
+		log.Info("Building multi-arch image", "image", image.Spec.Image, "platforms", image.Spec.Platforms)
+		build = gcp.BuildxBuild(image.Spec.Platforms)
+	} else if cacheFrom != nil {
+		// N.B. kaniko can't use an image as a cache so build with docker instead.
+		build = gcp.DockerBuild()
+	}
 
 	imageBase := image.Spec.Image
 
@@ -197,6 +319,18 @@ func (c *Controller) Reconcile(ctx context.Context, image *v1alpha1.Image) error
 		imageBase + ":latest",
 		imageBase + ":" + version,
 	}
+	if cacheFrom != nil {
+		cacheImage := imageBase + ":" + cacheFrom.CacheTag()
+		pushed := false
+		for _, i := range images {
+			pushed = pushed || i == cacheImage
+		}
+		if !pushed {
+			// Push the image with the cache tag so the next build uses it.
+			images = append(images, cacheImage)
+		}
+		log.Info("Using the previous image as the layer cache", "image", image.Spec.Image, "cacheFrom", cacheImage)
+	}
 
 	// Add some build tags.
 	imageTag := strings.Replace(imageBase, "/", "_", -1)
@@ -205,11 +339,46 @@ func (c *Controller) Reconcile(ctx context.Context, image *v1alpha1.Image) error
 	gcp.AddImages(build, images)
 	gcp.AddBuildTags(build, image.Status.SourceCommit, version)
 
+	if cacheFrom != nil {
+		if err := gcp.AddCacheFrom(build, imageBase+":"+cacheFrom.CacheTag()); err != nil {
+			return errors.Wrapf(err, "Failed to add the layer cache")
+		}
+	}
+
+	if len(image.Spec.Builder.BuildArgs) > 0 {
+		if err := gcp.AddBuildArgs(build, image.Spec.Builder.BuildArgs); err != nil {
+			return errors.Wrapf(err, "Failed to add build args")
+		}
+	}
+	if image.Spec.Builder.Target != "" {
+		if err := gcp.SetTarget(build, image.Spec.Builder.Target); err != nil {
+			return errors.Wrapf(err, "Failed to set the target stage")
+		}
+	}
+
 	dockerFile := "Dockerfile"
 	if image.Spec.Builder.GCB.Dockerfile != "" {
-		dockerFile = image.Spec.Builder.GCB.Dockerfile
+		// N.B. The path is relative to the root of the context even if it has a leading "/"; GCB would
+		// interpret it as a path in the filesystem of the build step.
+		dockerFile = v1alpha1.RootRelative(image.Spec.Builder.GCB.Dockerfile)
+	}
+	gcp.SetDockerfile(build, dockerFile)
+
+	if len(image.Spec.Builder.GCB.TestCommand) > 0 {
+		if err := gcp.AddTestStep(build, image.Spec.Builder.GCB.TestImage, image.Spec.Builder.GCB.TestCommand); err != nil {
+			return errors.Wrapf(err, "Failed to add test step")
+		}
+	}
+
+	if image.Spec.Signing != nil {
+		if err := gcp.AddSignStep(build, imageBase+":"+image.Status.SourceCommit, image.Spec.Signing.KeyRef); err != nil {
+			return errors.Wrapf(err, "Failed to add signing step")
+		}
+	}
+
+	if err := addAttestations(build, image, imageBase+":"+image.Status.SourceCommit, now); err != nil {
+		return err
 	}
-	gcp.AddKanikoArgs(build, []string{"--dockerfile=" + dockerFile})
 
 	build.Source = &cbpb.Source{
 		Source: &cbpb.Source_StorageSource{
@@ -220,25 +389,8 @@ func (c *Controller) Reconcile(ctx context.Context, image *v1alpha1.Image) error
 		},
 	}
 
-	if image.Spec.Builder.GCB.MachineType != "" {
-		val, ok := cbpb.BuildOptions_MachineType_value[image.Spec.Builder.GCB.MachineType]
-		if !ok {
-			allowed := make([]string, 0, len(cbpb.BuildOptions_MachineType_value))
-			for k := range cbpb.BuildOptions_MachineType_value {
-				allowed = append(allowed, k)
-			}
-			return errors.Errorf("Invalid machine type %v; allowed values: %s", image.Spec.Builder.GCB.MachineType, strings.Join(allowed, ", "))
-		}
-		build.Options.MachineType = cbpb.BuildOptions_MachineType(val)
-	}
-
-	if image.Spec.Builder.GCB.Timeout != "" {
-		t, err := time.ParseDuration(image.Spec.Builder.GCB.Timeout)
-		if err != nil {
-			return errors.Wrapf(err, "Invalid timeout %v; value must satisfy time.ParseDuration", image.Spec.Builder.GCB.Timeout)
-		}
-
-		build.Timeout = durationpb.New(t)
+	if err := configureBuild(build, image.Spec.Builder.GCB); err != nil {
+		return err
 	}
 
 	req := &cbpb.CreateBuildRequest{
@@ -246,7 +398,16 @@ func (c *Controller) Reconcile(ctx context.Context, image *v1alpha1.Image) error
 		Build:     build,
 	}
 
-	op, err := c.cbClient.CreateBuild(context.Background(), req)
+	waitTimeout := defaultWaitTimeout
+	if image.Spec.Builder.GCB.WaitTimeout != "" {
+		t, err := time.ParseDuration(image.Spec.Builder.GCB.WaitTimeout)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid waitTimeout %v; value must satisfy time.ParseDuration", image.Spec.Builder.GCB.WaitTimeout)
+		}
+		waitTimeout = t
+	}
+
+	op, err := c.cbClient.CreateBuild(ctx, req)
 	if err != nil {
 		err := errors.Wrapf(err, "Failed to create Google Cloud Build")
 		log.Error(err, "Failed to create Google Cloud Build", "project", project, "build", build)
@@ -261,22 +422,298 @@ func (c *Controller) Reconcile(ctx context.Context, image *v1alpha1.Image) error
 	}
 
 	log.Info("Build started", "id", op.GetName(), "project", project, "buildId", buildId, "operation", op.GetName())
+	image.Status.Conditions = v1alpha1.SetCondition(image.Status.Conditions, v1alpha1.NewCondition(v1alpha1.BuildingCondition, v1alpha1.ConditionTrue, buildStartedReason, fmt.Sprintf("Building %v in build %v", imageRef.ToURL(), buildId)))
 
-	opCtx, _ := context.WithTimeout(ctx, 1*time.Hour)
+	opCtx, cancel := context.WithTimeout(ctx, waitTimeout)
+	defer cancel()
+	stopFollowing := c.followBuildLogs(ctx, op, buildId)
 	finalBuild, err := gcp.WaitForBuild(opCtx, c.cbClient, project, buildId)
+	stopFollowing()
+
+	if err != nil || !isBuildDone(finalBuild) {
+		// We are no longer waiting on the build so cancel it; otherwise a stuck build would keep running.
+		// N.B. We can't use ctx because it could already be cancelled.
+		cancelCtx, stop := context.WithTimeout(context.Background(), cancelTimeout)
+		defer stop()
+		log.Info("Cancelling build", "project", project, "buildId", buildId)
+		if cErr := gcp.CancelBuild(cancelCtx, c.cbClient, project, buildId); cErr != nil {
+			log.Error(cErr, "Failed to cancel build", "project", project, "buildId", buildId)
+		}
+	}
 
 	if err != nil {
 		return errors.Wrapf(err, "Failed to wait for GCB build operation")
 	}
 
+	if !isBuildDone(finalBuild) {
+		return errors.Errorf("Timed out waiting for build %v to complete after %v", buildId, waitTimeout)
+	}
+
+	image.Status.BuildLogsURL = finalBuild.LogUrl
+	if len(image.Spec.Builder.GCB.TestCommand) > 0 {
+		testStatus := gcp.GetStepStatus(finalBuild, gcp.TestStepID)
+		image.Status.TestStatus = testStatus.String()
+		if testStatus != cbpb.Build_SUCCESS {
+			log.Info("Tests failed", "project", project, "buildId", buildId, "testStatus", testStatus, "logsUrl", finalBuild.LogUrl)
+			return errors.Errorf("Tests failed with status %v; logs: %v", testStatus, finalBuild.LogUrl)
+		}
+	}
+
 	if finalBuild.Status != cbpb.Build_SUCCESS {
 		log.Info("Build failed", "project", project, "buildId", buildId, "logsUrl", finalBuild.LogUrl)
 		return errors.Errorf("Build failed with status %v", finalBuild.Status)
 	}
 
+	// Resolve the tag to get the digest of the image that was pushed. For multi-arch images this is the digest
+	// of the manifest list.
+	resolved, err = c.resolver.ResolveImageToSha(ctx, *imageRef, v1alpha1.MutableTagStrategy)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to resolve image %v after building it", imageRef.ToURL())
+	}
+	image.Status.URI = resolved.ToURL()
+	image.Status.SHA = resolved.Sha
+	if image.Spec.Signing != nil {
+		image.Status.Signature = signatureRef(image.Spec.Image, resolved.Sha)
+	}
+	log.Info("Build succeeded", "image", image.Status.URI, "sha", image.Status.SHA)
+	return nil
+}
+
+// setImageConditions sets the conditions of the image after it was reconciled. err is the error, if any, of the
+// reconcile.
+func setImageConditions(image *v1alpha1.Image, err error) {
+	building := v1alpha1.NewCondition(v1alpha1.BuildingCondition, v1alpha1.ConditionFalse, imageExistsReason, "")
+	if err != nil {
+		building.Reason = notBuiltReason
+	}
+	if b := v1alpha1.GetCondition(image.Status.Conditions, v1alpha1.BuildingCondition); b != nil && b.Status == v1alpha1.ConditionTrue {
+		building.Reason = buildSucceededReason
+		if err != nil {
+			building.Reason = buildFailedReason
+		}
+		building.Message = b.Message
+	}
+	image.Status.Conditions = v1alpha1.SetCondition(image.Status.Conditions, building)
+
+	ready := v1alpha1.NewCondition(v1alpha1.ReadyCondition, v1alpha1.ConditionTrue, v1alpha1.ReconcileSucceededReason, image.Status.URI)
+	if err != nil {
+		ready.Status = v1alpha1.ConditionFalse
+		ready.Reason = v1alpha1.ReconcileFailedReason
+		ready.Message = err.Error()
+		if image.Status.TestStatus != "" && image.Status.TestStatus != cbpb.Build_SUCCESS.String() {
+			ready.Reason = testsFailedReason
+		}
+	}
+	image.Status.Conditions = v1alpha1.SetCondition(image.Status.Conditions, ready)
+}
+
+// BuildContext creates the build context of the image as a gzipped tarball at tarFilePath without building the
+// image. tarFilePath can be a local path or a URI e.g. gs://bucket/path.tgz. The files matched by each mapping
+// are reported in the image's status.
+func (c *Controller) BuildContext(ctx context.Context, image *v1alpha1.Image, tarFilePath string) error {
+	if err := c.replaceRemotes(ctx, image); err != nil {
+		return errors.Wrapf(err, "Failed to replace remotes")
+	}
+	return c.buildContext(ctx, image, tarFilePath)
+}
+
+// buildContext creates the tarball for the build context of the image at tarFilePath.
+func (c *Controller) buildContext(ctx context.Context, image *v1alpha1.Image, tarFilePath string) error {
+	log := util.LogFromContext(ctx)
+
+	// N.B. we need export any docker images specified as sources
+	// This will rewrite the image.Spec.ImageSource to point to the tarballs
+	transformed, err := c.exportImages(ctx, image)
+	if err != nil {
+		return err
+	}
+
+	if image.Spec.StrictMappings {
+		for i, source := range transformed {
+			strict := *source
+			strict.Strict = true
+			transformed[i] = &strict
+		}
+	}
+
+	maxSize, err := image.Spec.Builder.MaxContextSizeBytes()
+	if err != nil {
+		return err
+	}
+	stats := &tarutil.Stats{}
+	opts := []tarutil.BuildOption{tarutil.WithStats(stats), tarutil.WithMaxSize(maxSize)}
+	if image.Spec.ReproducibleContext {
+		opts = append(opts, tarutil.WithReproducible())
+	}
+	matches, err := tarutil.BuildWithMatches(transformed, tarFilePath, opts...)
+	image.Status.Context = stats.Status()
+	logContextSize(log, image.Status.Context)
+	// N.B. The transformed sources are in the same order as the sources in the spec. Use the URIs from the
+	// spec since images are exported to temporary files.
+	image.Status.Mappings = []v1alpha1.MappingMatch{}
+	for i, m := range matches {
+		for j, n := range m {
+			image.Status.Mappings = append(image.Status.Mappings, v1alpha1.MappingMatch{
+				Source:  image.Spec.Source[i].URI,
+				Src:     image.Spec.Source[i].Mappings[j].Src,
+				Matches: n,
+			})
+		}
+	}
+	log.Info("Matched files for the build context", "mappings", image.Status.Mappings)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create tarball %s", tarFilePath)
+	}
 	return nil
 }
 
+// logContextSize logs the size of the build context and its largest files.
+func logContextSize(log logr.Logger, status *v1alpha1.ContextStatus) {
+	largest := make([]string, 0, len(status.LargestFiles))
+	for _, f := range status.LargestFiles {
+		largest = append(largest, fmt.Sprintf("%v (%v)", f.Path, tarutil.FormatSize(f.Size)))
+	}
+	log.Info("Build context size", "size", tarutil.FormatSize(status.Size), "numFiles", status.NumFiles, "largestFiles", largest)
+}
+
+// followBuildLogs starts copying the log of the build into the log if the controller follows logs. The returned
+// function stops following the log once the rest of it has been copied.
+func (c *Controller) followBuildLogs(ctx context.Context, op *longrunningpb.Operation, buildId string) func() {
+	if !c.followLogs {
+		return func() {}
+	}
+	log := util.LogFromContext(ctx)
+	meta := &cbpb.BuildOperationMetadata{}
+	if err := op.GetMetadata().UnmarshalTo(meta); err != nil {
+		log.Error(err, "Unable to follow build logs; failed to get build metadata", "buildId", buildId)
+		return func() {}
+	}
+	tailer, err := gcp.NewBuildLogTailer(c.gcsClient, meta.GetBuild().GetLogsBucket(), buildId, log)
+	if err != nil {
+		log.Error(err, "Unable to follow build logs", "buildId", buildId)
+		return func() {}
+	}
+
+	followCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tailer.Follow(followCtx, gcp.DefaultLogPollPeriod)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// signatureRef returns the reference of the cosign signature for the image with the given digest. Cosign stores
+// the signature in the same repository as the image using a tag derived from the digest.
+func signatureRef(image string, digest string) string {
+	return image + ":" + strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+// configureBuild applies the build options in config to build. It should be called after all the steps are added.
+func configureBuild(build *cbpb.Build, config *v1alpha1.GCBConfig) error {
+	if config.MachineType != "" {
+		val, ok := cbpb.BuildOptions_MachineType_value[config.MachineType]
+		if !ok {
+			allowed := make([]string, 0, len(cbpb.BuildOptions_MachineType_value))
+			for k := range cbpb.BuildOptions_MachineType_value {
+				allowed = append(allowed, k)
+			}
+			return errors.Errorf("Invalid machine type %v; allowed values: %s", config.MachineType, strings.Join(allowed, ", "))
+		}
+		build.Options.MachineType = cbpb.BuildOptions_MachineType(val)
+	}
+
+	if config.Timeout != "" {
+		t, err := time.ParseDuration(config.Timeout)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid timeout %v; value must satisfy time.ParseDuration", config.Timeout)
+		}
+
+		build.Timeout = durationpb.New(t)
+	}
+
+	if config.BuilderImage != "" {
+		if err := gcp.SetBuilderImage(build, config.BuilderImage); err != nil {
+			return errors.Wrapf(err, "Failed to set the builder image")
+		}
+	}
+
+	if len(config.BuilderArgs) > 0 {
+		if err := gcp.AddBuilderArgs(build, config.BuilderArgs); err != nil {
+			return errors.Wrapf(err, "Failed to add builder args")
+		}
+	}
+
+	for _, s := range config.SecretEnv {
+		if err := gcp.AddSecretEnv(build, s.Env, s.Version); err != nil {
+			return errors.Wrapf(err, "Failed to add secret env %v", s.Env)
+		}
+	}
+
+	if len(config.Substitutions) > 0 {
+		build.Substitutions = config.Substitutions
+	}
+
+	if config.WorkerPool != "" {
+		gcp.SetWorkerPool(build, config.WorkerPool)
+	}
+
+	if config.ServiceAccount != "" {
+		build.ServiceAccount = gcp.ServiceAccountName(config.Project, config.ServiceAccount)
+	}
+	return nil
+}
+
+// addAttestations adds steps to the build to attach the attestations configured for the image to imageURI.
+func addAttestations(build *cbpb.Build, image *v1alpha1.Image, imageURI string, started time.Time) error {
+	att := image.Spec.Attestations
+	if !att.Enabled() {
+		return nil
+	}
+
+	if att.SBOM {
+		if err := gcp.AddSBOMSteps(build, imageURI, att.Key); err != nil {
+			return errors.Wrapf(err, "Failed to add SBOM attestation")
+		}
+	}
+
+	if att.Provenance {
+		predicate, err := buildProvenance(image, started)
+		if err != nil {
+			return err
+		}
+		if err := gcp.AddProvenanceSteps(build, imageURI, att.Key, predicate); err != nil {
+			return errors.Wrapf(err, "Failed to add provenance attestation")
+		}
+	}
+	return nil
+}
+
+// isBuildDone returns true if the build has reached a terminal state.
+func isBuildDone(b *cbpb.Build) bool {
+	if b == nil {
+		return false
+	}
+	switch b.GetStatus() {
+	case cbpb.Build_STATUS_UNKNOWN, cbpb.Build_PENDING, cbpb.Build_QUEUED, cbpb.Build_WORKING:
+		return false
+	default:
+		return true
+	}
+}
+
+// deleteTarball deletes the tarball at p if it exists.
+func (c *Controller) deleteTarball(ctx context.Context, p gcs.GcsPath) error {
+	err := c.gcsClient.Bucket(p.Bucket).Object(p.Path).Delete(ctx)
+	if err == nil || err == storage.ErrObjectNotExist {
+		return nil
+	}
+	return errors.Wrapf(err, "Failed to delete %v", p.ToURI())
+}
+
 // SetLocalRepos sets the local repositories to use when resolving images
 func (c *Controller) SetLocalRepos(repos []GitRepoRef) error {
 	c.localRepos = repos
@@ -298,7 +735,7 @@ func (c *Controller) exportImages(ctx context.Context, image *v1alpha1.Image) ([
 
 	tarResults := make([]*v1alpha1.ImageSource, 0, len(image.Spec.Source))
 
-	tmpDir, err := os.MkdirTemp("", "hydrosImageReconciler")
+	tmpDir, err := os.MkdirTemp("", ExportDirPrefix)
 	if err != nil {
 		return tarResults, errors.Wrapf(err, "Failed to create temp dir")
 	}
@@ -323,7 +760,12 @@ func (c *Controller) exportImages(ctx context.Context, image *v1alpha1.Image) ([
 			imageRef.Tag = image.Status.SourceCommit
 		}
 
-		imageURI := imageRef.ToURL()
+		// Pull the image through a mirror if one is configured for the registry.
+		pullRef := c.mirrors.Rewrite(*imageRef)
+		if pullRef.Registry != imageRef.Registry {
+			log.Info("Pulling image through mirror", "image", imageRef.ToURL(), "mirror", pullRef.ToURL())
+		}
+		imageURI := pullRef.ToURL()
 
 		// Construct path to where the image will be saved on disk
 		name := imageRef.Registry + "_" + imageRef.Repo + "_" + imageRef.Tag
@@ -340,7 +782,7 @@ func (c *Controller) exportImages(ctx context.Context, image *v1alpha1.Image) ([
 			defer wg.Done()
 			exportErrs[index] = nil
 			log.Info("Exporting image", "image", imageUri, "imagePath", path)
-			if err := ExportImage(imageUri, path); err != nil {
+			if err := ExportImage(imageUri, path, crane.WithAuthFromKeychain(c.mirrors)); err != nil {
 				log.Error(err, "Failed to export image", "image", imageUri, "path", path)
 				exportErrs[index] = err
 			}
@@ -413,8 +855,9 @@ func (c *Controller) replaceRemotes(ctx context.Context, image *v1alpha1.Image)
 }
 
 // ReconcileFile reconciles the images defined in a set of files.
-// It is a helper function primarily used by the CLI
-func ReconcileFile(path string) error {
+// It is a helper function primarily used by the CLI. Cancelling the context aborts any builds in progress.
+// opts are used to configure the controller.
+func ReconcileFile(ctx context.Context, path string, opts ...ControllerOption) error {
 	log := zapr.NewLogger(zap.L())
 
 	manifestPath, err := filepath.Abs(path)
@@ -430,23 +873,9 @@ func ReconcileFile(path string) error {
 		return errors.Wrapf(err, "Failed to open file: %v", manifestPath)
 	}
 
-	gitRoot, err := gitutil.LocateRoot(manifestPath)
-	if err != nil {
-		return errors.Wrapf(err, "Failed to locate git root for %v", path)
-	}
-
-	gitRepo, err := git.PlainOpenWithOptions(gitRoot, &git.PlainOpenOptions{})
+	gitRepo, w, err := openLocalRepo(manifestPath)
 	if err != nil {
-		return errors.Wrapf(err, "Error opening git Repo")
-	}
-
-	w, err := gitRepo.Worktree()
-	if err != nil {
-		return errors.Wrapf(err, "Error getting worktree")
-	}
-
-	if err := gitutil.AddGitignoreToWorktree(w, gitRoot); err != nil {
-		return errors.Wrapf(err, "Failed to add gitignore patterns")
+		return err
 	}
 
 	// Commit any changes. Do this before calling headRef
@@ -466,11 +895,11 @@ func ReconcileFile(path string) error {
 
 	d := yaml.NewDecoder(f)
 
-	c, err := NewController()
-	c.localRepos = append(c.localRepos, GitRepoRef{Repo: gitRepo, W: w})
+	c, err := NewController(opts...)
 	if err != nil {
 		return errors.Wrapf(err, "Error creating controller")
 	}
+	c.localRepos = append(c.localRepos, GitRepoRef{Repo: gitRepo, W: w})
 
 	failures := &helpers.ListOfErrors{}
 
@@ -491,7 +920,6 @@ func ReconcileFile(path string) error {
 			image.Status.SourceCommit += "-dirty"
 		}
 
-		ctx := context.Background()
 		if err := c.Reconcile(ctx, image); err != nil {
 			log.Error(err, "Failed to reconcile image", "image", image)
 			// Keep going
@@ -505,3 +933,112 @@ func ReconcileFile(path string) error {
 	}
 	return nil
 }
+
+// BuildContextFile creates the build contexts of the images defined in the file at path without building them.
+// It is a helper function used by the CLI to debug which files end up in the build context. The context of each
+// image is written to outDir as <name>.tgz. If list is true the names of the entries in the context are also
+// written to <name>.txt. Unlike ReconcileFile, uncommitted changes aren't committed.
+func BuildContextFile(ctx context.Context, path string, outDir string, list bool, opts ...ControllerOption) error {
+	log := zapr.NewLogger(zap.L())
+
+	manifestPath, err := filepath.Abs(path)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get absolute path for %v", path)
+	}
+
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to open file: %v", manifestPath)
+	}
+	defer f.Close()
+
+	gitRepo, w, err := openLocalRepo(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	headRef, err := gitRepo.Head()
+	if err != nil {
+		return errors.Wrapf(err, "Error getting head ref")
+	}
+
+	if err := os.MkdirAll(outDir, util.FilePermUserGroup); err != nil {
+		return errors.Wrapf(err, "Failed to create directory %v", outDir)
+	}
+
+	// N.B. Don't use NewController because creating the contexts doesn't require the GCP clients and therefore
+	// credentials.
+	c := &Controller{
+		localRepos: []GitRepoRef{{Repo: gitRepo, W: w}},
+	}
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return err
+		}
+	}
+
+	names := map[string]bool{}
+	d := yaml.NewDecoder(f)
+	for {
+		image := &v1alpha1.Image{}
+		if err := d.Decode(image); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errors.Wrapf(err, "Failed to decode image from file %v", manifestPath)
+		}
+
+		name := image.Metadata.Name
+		if name == "" {
+			return errors.Errorf("Image in %v doesn't have a name; the name is used to name its build context", manifestPath)
+		}
+		if names[name] {
+			return errors.Errorf("%v has multiple images named %v", manifestPath, name)
+		}
+		names[name] = true
+
+		image.Status.SourceCommit = headRef.Hash().String()
+		tarFilePath := filepath.Join(outDir, name+".tgz")
+		if err := c.BuildContext(ctx, image, tarFilePath); err != nil {
+			return err
+		}
+		log.Info("Wrote build context", "image", name, "tarball", tarFilePath)
+
+		if !list {
+			continue
+		}
+		entries, err := tarutil.List(tarFilePath)
+		if err != nil {
+			return err
+		}
+		listPath := filepath.Join(outDir, name+".txt")
+		if err := os.WriteFile(listPath, []byte(strings.Join(entries, "\n")+"\n"), 0o644); err != nil {
+			return errors.Wrapf(err, "Failed to write %v", listPath)
+		}
+		log.Info("Wrote list of build context entries", "image", name, "file", listPath, "numEntries", len(entries))
+	}
+}
+
+// openLocalRepo opens the git repository containing path. The worktree is configured to ignore the files in the
+// repository's .gitignore files.
+func openLocalRepo(path string) (*git.Repository, *git.Worktree, error) {
+	gitRoot, err := gitutil.LocateRoot(path)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Failed to locate git root for %v", path)
+	}
+
+	gitRepo, err := git.PlainOpenWithOptions(gitRoot, &git.PlainOpenOptions{})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Error opening git Repo")
+	}
+
+	w, err := gitRepo.Worktree()
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Error getting worktree")
+	}
+
+	if err := gitutil.AddGitignoreToWorktree(w, gitRoot); err != nil {
+		return nil, nil, errors.Wrapf(err, "Failed to add gitignore patterns")
+	}
+	return gitRepo, w, nil
+}
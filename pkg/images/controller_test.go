@@ -1,10 +1,18 @@
 package images
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
 
+	cbpb "cloud.google.com/go/cloudbuild/apiv1/v2/cloudbuildpb"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/gcp"
+	"github.com/jlewi/hydros/pkg/tarutil"
 	"github.com/jlewi/hydros/pkg/util"
 )
 
@@ -22,7 +30,128 @@ func Test_Controller(t *testing.T) {
 
 	srcSpec := filepath.Join(cwd, "..", "..", "images.yaml")
 
-	if err := ReconcileFile(srcSpec); err != nil {
+	if err := ReconcileFile(context.Background(), srcSpec); err != nil {
 		t.Fatalf("Error reconciling file %v", err)
 	}
 }
+
+func Test_signatureRef(t *testing.T) {
+	actual := signatureRef("us-west1-docker.pkg.dev/acme/images/hercules", "sha256:abcd")
+	expected := "us-west1-docker.pkg.dev/acme/images/hercules:sha256-abcd.sig"
+	if actual != expected {
+		t.Errorf("Got %v; want %v", actual, expected)
+	}
+}
+
+func Test_BuildContextFile(t *testing.T) {
+	util.SetupLogger("info", true)
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatalf("Could not initialize git repo %v", err)
+	}
+
+	files := map[string]string{
+		"Dockerfile":    "FROM scratch\n",
+		"src/main.go":   "package main\n",
+		"src/README.md": "readme\n",
+		"images.yaml": `apiVersion: hydros.dev/v1alpha1
+kind: Image
+metadata:
+  name: app
+spec:
+  image: us-west1-docker.pkg.dev/acme/images/app
+  source:
+  - uri: file://` + dir + `
+    mappings:
+    - src: Dockerfile
+    - src: src/*.go
+`,
+	}
+	for name, contents := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("Could not create directory %v", err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatalf("Could not write file %v", err)
+		}
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatalf("Could not get worktree %v", err)
+	}
+	if _, err := w.Add("."); err != nil {
+		t.Fatalf("Could not add files %v", err)
+	}
+	if _, err := w.Commit("initial commit", &git.CommitOptions{Author: &object.Signature{Name: "hydros", Email: "hydros@acme.com"}}); err != nil {
+		t.Fatalf("Could not commit %v", err)
+	}
+
+	outDir := filepath.Join(t.TempDir(), "contexts")
+	if err := BuildContextFile(context.Background(), filepath.Join(dir, "images.yaml"), outDir, true); err != nil {
+		t.Fatalf("BuildContextFile failed; %+v", err)
+	}
+
+	entries, err := tarutil.List(filepath.Join(outDir, "app.tgz"))
+	if err != nil {
+		t.Fatalf("Failed to list build context; %v", err)
+	}
+	expected := []string{"Dockerfile", "src/main.go"}
+	if d := cmp.Diff(expected, entries); d != "" {
+		t.Errorf("Unexpected build context entries; diff:\n%v", d)
+	}
+
+	list, err := os.ReadFile(filepath.Join(outDir, "app.txt"))
+	if err != nil {
+		t.Fatalf("Failed to read list of entries; %v", err)
+	}
+	if d := cmp.Diff("Dockerfile\nsrc/main.go\n", string(list)); d != "" {
+		t.Errorf("Unexpected list of entries; diff:\n%v", d)
+	}
+}
+
+func Test_configureBuild(t *testing.T) {
+	build := gcp.DefaultBuild()
+	if err := gcp.AddTestStep(build, "golang:1.21", []string{"go", "test", "./..."}); err != nil {
+		t.Fatalf("Failed to add test step; %v", err)
+	}
+	config := &v1alpha1.GCBConfig{
+		Project:        "acme",
+		Substitutions:  map[string]string{"_REGION": "us-west1"},
+		SecretEnv:      []v1alpha1.SecretEnv{{Env: "NPM_TOKEN", Version: "projects/acme/secrets/npm/versions/latest"}},
+		WorkerPool:     "projects/acme/locations/us-west1/workerPools/builds",
+		ServiceAccount: "builder@acme.iam.gserviceaccount.com",
+		BuilderImage:   "gcr.io/kaniko-project/executor:v1.23.0",
+		BuilderArgs:    []string{"--build-arg=REGION=${_REGION}"},
+	}
+	if err := configureBuild(build, config); err != nil {
+		t.Fatalf("configureBuild failed; %v", err)
+	}
+
+	test, builder := build.Steps[0], build.Steps[1]
+	if builder.Name != config.BuilderImage {
+		t.Errorf("Builder image wasn't overridden; got %v", builder.Name)
+	}
+	if last := builder.Args[len(builder.Args)-1]; last != config.BuilderArgs[0] {
+		t.Errorf("Builder args weren't added; got %v", builder.Args)
+	}
+	for _, s := range []*cbpb.BuildStep{test, builder} {
+		if d := cmp.Diff([]string{"NPM_TOKEN"}, s.SecretEnv); d != "" {
+			t.Errorf("Step %v has unexpected secretEnv; diff:\n%v", s.Id, d)
+		}
+	}
+	if secrets := build.GetAvailableSecrets().GetSecretManager(); len(secrets) != 1 || secrets[0].VersionName != config.SecretEnv[0].Version {
+		t.Errorf("Secret wasn't made available to the build; got %v", secrets)
+	}
+	if d := cmp.Diff(config.Substitutions, build.Substitutions); d != "" {
+		t.Errorf("Unexpected substitutions; diff:\n%v", d)
+	}
+	if build.GetOptions().GetPool().GetName() != config.WorkerPool {
+		t.Errorf("Worker pool wasn't set; got %v", build.GetOptions().GetPool())
+	}
+	if expected := "projects/acme/serviceAccounts/builder@acme.iam.gserviceaccount.com"; build.ServiceAccount != expected {
+		t.Errorf("Got service account %v; want %v", build.ServiceAccount, expected)
+	}
+}
@@ -13,8 +13,10 @@ import (
 // https://github.com/google/go-containerregistry/blob/a0658aa1d0cc7a7f1bcc4a3af9155335b6943f40/cmd/crane/cmd/export.go#L55
 //
 // This is different from image downloader because that appears to download the manifest and individual blobs.
-func ExportImage(src string, tarFilePath string) error {
+// opts are additional options for crane; e.g. to use a different keychain.
+func ExportImage(src string, tarFilePath string, opts ...crane.Option) error {
 	options := []crane.Option{crane.WithAuthFromKeychain(keychain)}
+	options = append(options, opts...)
 	var img v1.Image
 	desc, err := crane.Get(src, options...)
 	if err != nil {
@@ -0,0 +1,187 @@
+package images
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/go-logr/zapr"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/tarutil"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// LintResult is the result of linting an image.
+type LintResult struct {
+	Image *v1alpha1.Image
+	// Errors are the problems that would make the build fail.
+	Errors []string
+	// Warnings are problems that might not make the build fail e.g. mappings that don't match any files.
+	Warnings []string
+	// Mappings are the files matched by the mappings of the local sources.
+	Mappings []v1alpha1.MappingMatch
+	// Files are the files in the build context assembled from the local sources.
+	Files []string
+	// Context is the size of the build context assembled from the local sources.
+	Context *v1alpha1.ContextStatus
+}
+
+// LintFile lints the images defined in the file at path without building them. It validates the spec of each
+// image, previews the mappings of its local sources against the local checkout and checks that the Dockerfile is
+// in the build context. Git sources for the repository containing the file are replaced with the local checkout
+// like they are when the image is built. Remote sources e.g. tarballs in GCS aren't downloaded; their mappings
+// aren't previewed. If name isn't empty only the image with that name is linted.
+func LintFile(ctx context.Context, path string, name string) ([]*LintResult, error) {
+	log := zapr.NewLogger(zap.L())
+
+	manifestPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get absolute path for %v", path)
+	}
+
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open file: %v", manifestPath)
+	}
+	defer f.Close()
+
+	c := &Controller{}
+	if gitRepo, w, err := openLocalRepo(manifestPath); err != nil {
+		log.V(1).Info("File isn't in a git repository; git sources won't be replaced with a local checkout", "path", manifestPath, "err", err)
+	} else {
+		c.localRepos = []GitRepoRef{{Repo: gitRepo, W: w}}
+	}
+
+	results := []*LintResult{}
+	d := yaml.NewDecoder(f)
+	for {
+		node := &yaml.Node{}
+		if err := d.Decode(node); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, errors.Wrapf(err, "Failed to decode %v", manifestPath)
+		}
+		meta := struct {
+			Kind string `yaml:"kind"`
+		}{}
+		if err := node.Decode(&meta); err != nil {
+			return nil, errors.Wrapf(err, "Failed to decode %v", manifestPath)
+		}
+		if meta.Kind != v1alpha1.ImageGVK.Kind {
+			continue
+		}
+		image := &v1alpha1.Image{}
+		if err := node.Decode(image); err != nil {
+			return nil, errors.Wrapf(err, "Failed to decode image from %v", manifestPath)
+		}
+		if name != "" && image.Metadata.Name != name {
+			continue
+		}
+		resolveLocalSources(image.Spec.Source, filepath.Dir(manifestPath))
+		result, err := c.lint(ctx, image)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	if len(results) == 0 {
+		if name != "" {
+			return nil, errors.Errorf("%v doesn't have an image named %v", path, name)
+		}
+		return nil, errors.Errorf("%v doesn't have any images", path)
+	}
+	return results, nil
+}
+
+// lint lints the image. Problems with the image are returned in the result; the error is only set if the image
+// couldn't be linted.
+func (c *Controller) lint(ctx context.Context, image *v1alpha1.Image) (*LintResult, error) {
+	result := &LintResult{Image: image}
+	if msg, ok := image.IsValid(); !ok {
+		result.Errors = append(result.Errors, msg)
+	}
+
+	if err := c.replaceRemotes(ctx, image); err != nil {
+		return nil, errors.Wrapf(err, "Failed to replace remotes")
+	}
+
+	local := []*v1alpha1.ImageSource{}
+	for _, s := range image.Spec.Source {
+		if !isLocalSource(s.URI) {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("Source %v isn't local; its mappings weren't previewed", s.URI))
+			continue
+		}
+		source := *s
+		source.Strict = source.Strict || image.Spec.StrictMappings
+		local = append(local, &source)
+	}
+
+	dir, err := os.MkdirTemp("", "hydrosLint")
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create temporary directory")
+	}
+	defer os.RemoveAll(dir)
+	tarball := filepath.Join(dir, "context.tgz")
+
+	stats := &tarutil.Stats{}
+	matches, err := tarutil.BuildWithMatches(local, tarball, tarutil.WithStats(stats))
+	result.Context = stats.Status()
+	for i, m := range matches {
+		for j, n := range m {
+			result.Mappings = append(result.Mappings, v1alpha1.MappingMatch{
+				Source:  local[i].URI,
+				Src:     local[i].Mappings[j].Src,
+				Matches: n,
+			})
+			if n == 0 {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("Mapping %v of source %v didn't match any files", local[i].Mappings[j].Src, local[i].URI))
+			}
+		}
+	}
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("Failed to assemble the build context; %v", err))
+		return result, nil
+	}
+
+	result.Files, err = tarutil.List(tarball)
+	if err != nil {
+		return nil, err
+	}
+
+	// N.B. The size is checked after the context is assembled rather than with tarutil.WithMaxSize so the size
+	// and largest files of the whole context are reported.
+	if maxSize, err := image.Spec.Builder.MaxContextSizeBytes(); err == nil && maxSize > 0 && stats.Size > maxSize {
+		result.Errors = append(result.Errors, fmt.Sprintf("Build context is %v which exceeds Spec.Builder.MaxContextSize %v", tarutil.FormatSize(stats.Size), image.Spec.Builder.MaxContextSize))
+	}
+
+	dockerfile := "Dockerfile"
+	if image.Spec.Builder != nil && image.Spec.Builder.GCB != nil && image.Spec.Builder.GCB.Dockerfile != "" {
+		dockerfile = v1alpha1.RootRelative(image.Spec.Builder.GCB.Dockerfile)
+	}
+	for _, f := range result.Files {
+		if f == dockerfile {
+			return result, nil
+		}
+	}
+	msg := fmt.Sprintf("Dockerfile %v isn't in the build context", dockerfile)
+	if len(local) < len(image.Spec.Source) {
+		// It might be provided by one of the remote sources.
+		result.Warnings = append(result.Warnings, msg+" assembled from the local sources")
+	} else {
+		result.Errors = append(result.Errors, msg)
+	}
+	return result, nil
+}
+
+// isLocalSource returns true if the source is a local path.
+func isLocalSource(uri string) bool {
+	u, err := url.Parse(uri)
+	return err == nil && u.Scheme == "file"
+}
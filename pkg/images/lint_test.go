@@ -0,0 +1,191 @@
+package images
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/api/v1alpha1"
+)
+
+func Test_LintFile(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"build/Dockerfile": "FROM scratch\n",
+		"src/main.go":      "package main\n",
+		"images.yaml": `apiVersion: hydros.dev/v1alpha1
+kind: Image
+metadata:
+  name: app
+spec:
+  image: us-west1-docker.pkg.dev/acme/images/app
+  builder:
+    gcb:
+      project: acme
+      bucket: builds
+      dockerfile: /build/Dockerfile
+  source:
+  - uri: .
+    mappings:
+    - src: build/Dockerfile
+    - src: src/*.go
+    - src: docs/*.md
+---
+apiVersion: hydros.dev/v1alpha1
+kind: Image
+metadata:
+  name: missing-dockerfile
+spec:
+  image: us-west1-docker.pkg.dev/acme/images/other
+  builder:
+    gcb:
+      project: acme
+      bucket: builds
+  source:
+  - uri: src
+    mappings:
+    - src: "*.go"
+---
+apiVersion: hydros.dev/v1alpha1
+kind: Image
+metadata:
+  name: too-large
+spec:
+  image: us-west1-docker.pkg.dev/acme/images/too-large
+  builder:
+    maxContextSize: "20"
+    gcb:
+      project: acme
+      bucket: builds
+      dockerfile: build/Dockerfile
+  source:
+  - uri: .
+    mappings:
+    - src: build/Dockerfile
+    - src: src/*.go
+---
+apiVersion: hydros.dev/v1alpha1
+kind: Image
+metadata:
+  name: remote
+spec:
+  image: us-west1-docker.pkg.dev/acme/images/remote
+  source:
+  - uri: gs://acme/context.tgz
+    mappings:
+    - src: "**"
+`,
+	}
+	for name, contents := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("Could not create directory %v", err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatalf("Could not write file %v", err)
+		}
+	}
+
+	type testCase struct {
+		name     string
+		image    string
+		expected *LintResult
+	}
+
+	cases := []testCase{
+		{
+			name:  "valid",
+			image: "app",
+			expected: &LintResult{
+				Warnings: []string{"Mapping docs/*.md of source file://" + dir + " didn't match any files"},
+				Mappings: []v1alpha1.MappingMatch{
+					{Source: "file://" + dir, Src: "build/Dockerfile", Matches: 1},
+					{Source: "file://" + dir, Src: "src/*.go", Matches: 1},
+					{Source: "file://" + dir, Src: "docs/*.md", Matches: 0},
+				},
+				Files: []string{"build/Dockerfile", "src/main.go"},
+				Context: &v1alpha1.ContextStatus{
+					Size:     26,
+					NumFiles: 2,
+					LargestFiles: []v1alpha1.ContextFile{
+						{Path: "build/Dockerfile", Size: 13},
+						{Path: "src/main.go", Size: 13},
+					},
+				},
+			},
+		},
+		{
+			name:  "too-large",
+			image: "too-large",
+			expected: &LintResult{
+				Errors: []string{"Build context is 26 B which exceeds Spec.Builder.MaxContextSize 20"},
+				Mappings: []v1alpha1.MappingMatch{
+					{Source: "file://" + dir, Src: "build/Dockerfile", Matches: 1},
+					{Source: "file://" + dir, Src: "src/*.go", Matches: 1},
+				},
+				Files: []string{"build/Dockerfile", "src/main.go"},
+				Context: &v1alpha1.ContextStatus{
+					Size:     26,
+					NumFiles: 2,
+					LargestFiles: []v1alpha1.ContextFile{
+						{Path: "build/Dockerfile", Size: 13},
+						{Path: "src/main.go", Size: 13},
+					},
+				},
+			},
+		},
+		{
+			name:  "missing-dockerfile",
+			image: "missing-dockerfile",
+			expected: &LintResult{
+				Errors: []string{"Dockerfile Dockerfile isn't in the build context"},
+				Mappings: []v1alpha1.MappingMatch{
+					{Source: "file://" + filepath.Join(dir, "src"), Src: "*.go", Matches: 1},
+				},
+				Files: []string{"main.go"},
+				Context: &v1alpha1.ContextStatus{
+					Size:         13,
+					NumFiles:     1,
+					LargestFiles: []v1alpha1.ContextFile{{Path: "main.go", Size: 13}},
+				},
+			},
+		},
+		{
+			name:  "remote",
+			image: "remote",
+			expected: &LintResult{
+				Errors: []string{"Image is invalid. Spec.Builder.GCB must be specified"},
+				Warnings: []string{
+					"Source gs://acme/context.tgz isn't local; its mappings weren't previewed",
+					"Dockerfile Dockerfile isn't in the build context assembled from the local sources",
+				},
+				Files:   []string{},
+				Context: &v1alpha1.ContextStatus{LargestFiles: []v1alpha1.ContextFile{}},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			results, err := LintFile(context.Background(), filepath.Join(dir, "images.yaml"), c.image)
+			if err != nil {
+				t.Fatalf("LintFile failed; %+v", err)
+			}
+			if len(results) != 1 {
+				t.Fatalf("Expected 1 result; got %v", len(results))
+			}
+			actual := results[0]
+			actual.Image = nil
+			if d := cmp.Diff(c.expected, actual); d != "" {
+				t.Errorf("Unexpected result; diff:\n%v", d)
+			}
+		})
+	}
+
+	if _, err := LintFile(context.Background(), filepath.Join(dir, "images.yaml"), "unknown"); err == nil {
+		t.Errorf("Expected an error for an unknown image")
+	}
+}
@@ -0,0 +1,92 @@
+package images
+
+import (
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/jlewi/hydros/pkg/config"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/jlewi/monogo/files"
+	"github.com/pkg/errors"
+)
+
+// registryMirrors rewrites references to images in upstream registries so that they are pulled through
+// the configured mirrors. It also implements authn.Keychain to supply the credentials for the mirrors.
+type registryMirrors struct {
+	mirrors []config.RegistryMirror
+	// auth is the authenticator for each mirror that has credentials configured. It is keyed by the
+	// host of the mirror.
+	auth map[string]authn.Authenticator
+}
+
+func newRegistryMirrors(mirrors []config.RegistryMirror) (*registryMirrors, error) {
+	m := &registryMirrors{
+		mirrors: mirrors,
+		auth:    map[string]authn.Authenticator{},
+	}
+
+	for _, mirror := range mirrors {
+		if mirror.Registry == "" || mirror.Mirror == "" {
+			return nil, errors.Errorf("Registry mirrors must specify registry and mirror; got %+v", mirror)
+		}
+		if mirror.Username == "" {
+			continue
+		}
+		password := ""
+		if mirror.PasswordFile != "" {
+			b, err := files.Read(mirror.PasswordFile)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to read password for registry mirror %v from %v", mirror.Mirror, mirror.PasswordFile)
+			}
+			password = strings.TrimSpace(string(b))
+		}
+		host, _ := splitMirror(mirror.Mirror)
+		m.auth[host] = &authn.Basic{
+			Username: mirror.Username,
+			Password: password,
+		}
+	}
+	return m, nil
+}
+
+// Rewrite returns the reference from which to pull the image. If the image isn't in a mirrored registry the
+// reference is returned unchanged.
+func (m *registryMirrors) Rewrite(ref util.DockerImageRef) util.DockerImageRef {
+	if m == nil {
+		return ref
+	}
+	for _, mirror := range m.mirrors {
+		if ref.Registry != mirror.Registry {
+			continue
+		}
+		host, prefix := splitMirror(mirror.Mirror)
+		ref.Registry = host
+		if prefix != "" {
+			ref.Repo = prefix + "/" + ref.Repo
+		}
+		return ref
+	}
+	return ref
+}
+
+// Resolve implements authn.Keychain. It returns the configured credentials for mirrors and otherwise
+// falls back to the default keychain.
+func (m *registryMirrors) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	if m != nil {
+		if a, ok := m.auth[target.RegistryStr()]; ok {
+			return a, nil
+		}
+	}
+	return keychain.Resolve(target)
+}
+
+// splitMirror splits a mirror into the host and the repository prefix
+// e.g. mirror.corp.com/registry.k8s.io -> mirror.corp.com, registry.k8s.io
+func splitMirror(mirror string) (string, string) {
+	mirror = strings.TrimSuffix(strings.TrimPrefix(mirror, util.DockerScheme+"://"), "/")
+	pieces := strings.SplitN(mirror, "/", 2)
+	if len(pieces) == 1 {
+		return pieces[0], ""
+	}
+	return pieces[0], pieces[1]
+}
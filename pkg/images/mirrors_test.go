@@ -0,0 +1,74 @@
+package images
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/jlewi/hydros/pkg/config"
+	"github.com/jlewi/hydros/pkg/util"
+)
+
+func Test_registryMirrorsRewrite(t *testing.T) {
+	mirrors, err := newRegistryMirrors([]config.RegistryMirror{
+		{
+			Registry: "registry.k8s.io",
+			Mirror:   "mirror.corp.com/registry.k8s.io",
+			Username: "hydros",
+		},
+		{
+			Registry: "ghcr.io",
+			Mirror:   "ghcr-mirror.corp.com",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create mirrors; %v", err)
+	}
+
+	type testCase struct {
+		name     string
+		input    util.DockerImageRef
+		expected util.DockerImageRef
+	}
+
+	cases := []testCase{
+		{
+			name:     "prefix",
+			input:    util.DockerImageRef{Registry: "registry.k8s.io", Repo: "pause", Tag: "3.9"},
+			expected: util.DockerImageRef{Registry: "mirror.corp.com", Repo: "registry.k8s.io/pause", Tag: "3.9"},
+		},
+		{
+			name:     "host-only",
+			input:    util.DockerImageRef{Registry: "ghcr.io", Repo: "jlewi/hydros", Tag: "latest"},
+			expected: util.DockerImageRef{Registry: "ghcr-mirror.corp.com", Repo: "jlewi/hydros", Tag: "latest"},
+		},
+		{
+			name:     "not-mirrored",
+			input:    util.DockerImageRef{Registry: "us-west1-docker.pkg.dev", Repo: "acme/images/hydros", Tag: "latest"},
+			expected: util.DockerImageRef{Registry: "us-west1-docker.pkg.dev", Repo: "acme/images/hydros", Tag: "latest"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual := mirrors.Rewrite(c.input)
+			if d := cmp.Diff(c.expected, actual); d != "" {
+				t.Errorf("Unexpected diff:\n%s", d)
+			}
+		})
+	}
+
+	// Credentials should be used for the mirror.
+	registry, err := name.NewRegistry("mirror.corp.com")
+	if err != nil {
+		t.Fatalf("Failed to parse registry; %v", err)
+	}
+	auth, err := mirrors.Resolve(registry)
+	if err != nil {
+		t.Fatalf("Failed to resolve credentials; %v", err)
+	}
+	if _, ok := auth.(*authn.Basic); !ok {
+		t.Errorf("Expected basic auth for the mirror; got %T", auth)
+	}
+}
@@ -0,0 +1,106 @@
+package images
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// Promoter is a controller for ImagePromotion. It promotes images by tagging and copying an existing digest
+// rather than rebuilding the image.
+type Promoter struct {
+	options []crane.Option
+}
+
+// PromoterOption is an option for the Promoter.
+type PromoterOption func(p *Promoter) error
+
+// WithPromoterKeychain sets the keychain used to authenticate to registries. Defaults to a keychain using the
+// docker config, Google and GitHub credentials.
+func WithPromoterKeychain(k authn.Keychain) PromoterOption {
+	return func(p *Promoter) error {
+		p.options = []crane.Option{crane.WithAuthFromKeychain(k)}
+		return nil
+	}
+}
+
+// NewPromoter creates a new Promoter.
+func NewPromoter(opts ...PromoterOption) (*Promoter, error) {
+	p := &Promoter{
+		options: []crane.Option{crane.WithAuthFromKeychain(keychain)},
+	}
+	for _, o := range opts {
+		if err := o(p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *Promoter) ReconcileNode(ctx context.Context, n *yaml.RNode) error {
+	promotion := &v1alpha1.ImagePromotion{}
+	if err := n.YNode().Decode(promotion); err != nil {
+		return errors.Wrapf(err, "Failed to decode ImagePromotion")
+	}
+
+	return p.Reconcile(ctx, promotion)
+}
+
+// Reconcile applies the tags of each environment to the image in the repositories of the environment. The image
+// is copied to repositories that don't have it yet. Tags which already point at the image are left as is.
+func (p *Promoter) Reconcile(ctx context.Context, promotion *v1alpha1.ImagePromotion) error {
+	log := util.LogFromContext(ctx)
+	log = log.WithValues("namespace", promotion.Metadata.Namespace, "name", promotion.Metadata.Name)
+
+	if err := promotion.IsValid(); err != nil {
+		return err
+	}
+
+	src, err := name.NewDigest(promotion.Spec.Image)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to parse image %v", promotion.Spec.Image)
+	}
+
+	options := append([]crane.Option{crane.WithContext(ctx)}, p.options...)
+
+	allErrors := &util.ListOfErrors{
+		Causes: []error{},
+	}
+
+	for _, e := range promotion.Spec.Environments {
+		repos := e.Repositories
+		if len(repos) == 0 {
+			repos = []string{src.Context().String()}
+		}
+		for _, repo := range repos {
+			for _, tag := range e.GetTags() {
+				dest := repo + ":" + tag
+				if current, err := crane.Digest(dest, options...); err == nil && current == src.DigestStr() {
+					log.V(util.Debug).Info("Image already promoted", "environment", e.Name, "image", dest)
+					continue
+				}
+				log.Info("Promoting image", "environment", e.Name, "image", src.String(), "destination", dest)
+				// N.B. Copy only uploads the blobs that are missing so tagging an image in the same repository
+				// doesn't copy anything.
+				if err := crane.Copy(src.String(), dest, options...); err != nil {
+					log.Error(err, "Failed to promote image", "environment", e.Name, "destination", dest)
+					allErrors.AddCause(errors.Wrapf(err, "failed to promote image to %v", dest))
+				}
+			}
+		}
+	}
+
+	if len(allErrors.Causes) == 0 {
+		return nil
+	}
+	allErrors.Final = fmt.Errorf("failed to promote image %v to one or more environments", src.String())
+
+	return allErrors
+}
@@ -0,0 +1,76 @@
+package images
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/jlewi/hydros/api/v1alpha1"
+)
+
+func Test_Promoter(t *testing.T) {
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL; %v", err)
+	}
+
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("Failed to create image; %v", err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Failed to get digest; %v", err)
+	}
+	tag, err := name.NewTag(u.Host + "/builds/web:abcd")
+	if err != nil {
+		t.Fatalf("Failed to parse tag; %v", err)
+	}
+	if err := remote.Write(tag, img); err != nil {
+		t.Fatalf("Failed to push image; %v", err)
+	}
+
+	promotion := &v1alpha1.ImagePromotion{
+		Metadata: v1alpha1.Metadata{Name: "web"},
+		Spec: v1alpha1.ImagePromotionSpec{
+			Image: u.Host + "/builds/web@" + digest.String(),
+			Environments: []v1alpha1.PromotionEnvironment{
+				{Name: "staging"},
+				{Name: "prod", Tags: []string{"prod", "stable"}, Repositories: []string{u.Host + "/prod/web"}},
+			},
+		},
+	}
+
+	p, err := NewPromoter(WithPromoterKeychain(authn.DefaultKeychain))
+	if err != nil {
+		t.Fatalf("Failed to create promoter; %v", err)
+	}
+	// Reconcile twice to check promoting an image that was already promoted is a no-op.
+	for i := 0; i < 2; i++ {
+		if err := p.Reconcile(context.Background(), promotion); err != nil {
+			t.Fatalf("Reconcile failed; %+v", err)
+		}
+	}
+
+	for _, image := range []string{u.Host + "/builds/web:staging", u.Host + "/prod/web:prod", u.Host + "/prod/web:stable"} {
+		actual, err := crane.Digest(image)
+		if err != nil {
+			t.Errorf("Failed to get digest of %v; %v", image, err)
+			continue
+		}
+		if actual != digest.String() {
+			t.Errorf("Image %v has digest %v; want %v", image, actual, digest.String())
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package images
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+)
+
+const (
+	// gcbBuilderID identifies Google Cloud Build as the builder in the provenance.
+	gcbBuilderID = "https://cloudbuild.googleapis.com/GoogleHostedWorker"
+	// imageBuildType identifies the template used to build the image in the provenance.
+	imageBuildType = "https://github.com/jlewi/hydros/Image@v1alpha1"
+)
+
+// provenance is a SLSA v0.2 provenance predicate.
+// See: https://slsa.dev/provenance/v0.2
+type provenance struct {
+	Builder    provenanceBuilder    `json:"builder"`
+	BuildType  string               `json:"buildType"`
+	Invocation provenanceInvocation `json:"invocation"`
+	Metadata   provenanceMetadata   `json:"metadata"`
+	Materials  []provenanceMaterial `json:"materials,omitempty"`
+}
+
+type provenanceBuilder struct {
+	ID string `json:"id"`
+}
+
+type provenanceInvocation struct {
+	Parameters provenanceParameters `json:"parameters"`
+}
+
+type provenanceParameters struct {
+	Image      string   `json:"image"`
+	Dockerfile string   `json:"dockerfile,omitempty"`
+	Platforms  []string `json:"platforms,omitempty"`
+}
+
+type provenanceMetadata struct {
+	BuildStartedOn string `json:"buildStartedOn,omitempty"`
+	Reproducible   bool   `json:"reproducible"`
+}
+
+type provenanceMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// buildProvenance returns the serialized SLSA provenance predicate for building image.
+// Local sources are recorded as materials at the source commit. Docker image sources are recorded by their URI.
+func buildProvenance(image *v1alpha1.Image, started time.Time) ([]byte, error) {
+	p := provenance{
+		Builder: provenanceBuilder{
+			ID: gcbBuilderID,
+		},
+		BuildType: imageBuildType,
+		Invocation: provenanceInvocation{
+			Parameters: provenanceParameters{
+				Image:     image.Spec.Image,
+				Platforms: image.Spec.Platforms,
+			},
+		},
+		Metadata: provenanceMetadata{
+			BuildStartedOn: started.UTC().Format(time.RFC3339),
+		},
+		Materials: make([]provenanceMaterial, 0, len(image.Spec.Source)),
+	}
+
+	if image.Spec.Builder != nil && image.Spec.Builder.GCB != nil {
+		p.Invocation.Parameters.Dockerfile = image.Spec.Builder.GCB.Dockerfile
+	}
+
+	for _, s := range image.Spec.Source {
+		m := provenanceMaterial{
+			URI: s.URI,
+		}
+		if !util.IsDockerURI(s.URI) && image.Status.SourceCommit != "" {
+			m.Digest = map[string]string{"sha1": image.Status.SourceCommit}
+		}
+		p.Materials = append(p.Materials, m)
+	}
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to serialize provenance for image %v", image.Spec.Image)
+	}
+	return b, nil
+}
@@ -0,0 +1,52 @@
+package images
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/api/v1alpha1"
+)
+
+func Test_buildProvenance(t *testing.T) {
+	image := &v1alpha1.Image{
+		Spec: v1alpha1.ImageSpec{
+			Image: "us-west1-docker.pkg.dev/acme/images/hercules",
+			Source: []*v1alpha1.ImageSource{
+				{URI: "file:///src/hercules"},
+				{URI: "docker://golang:1.21"},
+			},
+		},
+		Status: v1alpha1.ImageStatus{
+			SourceCommit: "0123456789abcdef0123456789abcdef01234567",
+		},
+	}
+
+	b, err := buildProvenance(image, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Failed to build provenance; %v", err)
+	}
+
+	actual := &provenance{}
+	if err := json.Unmarshal(b, actual); err != nil {
+		t.Fatalf("Failed to unmarshal provenance; %v", err)
+	}
+
+	expected := &provenance{
+		Builder:   provenanceBuilder{ID: gcbBuilderID},
+		BuildType: imageBuildType,
+		Invocation: provenanceInvocation{
+			Parameters: provenanceParameters{Image: "us-west1-docker.pkg.dev/acme/images/hercules"},
+		},
+		Metadata: provenanceMetadata{BuildStartedOn: "2024-01-02T03:04:05Z"},
+		Materials: []provenanceMaterial{
+			{URI: "file:///src/hercules", Digest: map[string]string{"sha1": "0123456789abcdef0123456789abcdef01234567"}},
+			{URI: "docker://golang:1.21"},
+		},
+	}
+
+	if d := cmp.Diff(expected, actual); d != "" {
+		t.Errorf("Unexpected provenance:\n%s", d)
+	}
+}
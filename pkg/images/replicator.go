@@ -12,6 +12,7 @@ import (
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/controllers"
 	"github.com/jlewi/hydros/pkg/util"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
@@ -43,10 +44,21 @@ func (r *Replicator) ReconcileNode(ctx context.Context, n *yaml.RNode) error {
 		return errors.Wrapf(err, "Failed to decode ReplicatedImage")
 	}
 
-	return r.Reconcile(ctx, image)
+	err := r.Reconcile(ctx, image)
+	if cErr := controllers.SetConditions(n, image.Status.Conditions); cErr != nil {
+		util.LogFromContext(ctx).Error(cErr, "Failed to record the conditions of the ReplicatedImage", "name", image.Metadata.Name)
+	}
+	return err
 }
 
+// Reconcile replicates the image and sets the conditions of the ReplicatedImage based on the result.
 func (r *Replicator) Reconcile(ctx context.Context, replicated *v1alpha1.ReplicatedImage) error {
+	err := r.replicate(ctx, replicated)
+	setReplicatedConditions(replicated, err)
+	return err
+}
+
+func (r *Replicator) replicate(ctx context.Context, replicated *v1alpha1.ReplicatedImage) error {
 	log := util.LogFromContext(ctx)
 	log = log.WithValues("namespace", replicated.Metadata.Namespace, "name", replicated.Metadata.Name)
 
@@ -74,6 +86,7 @@ func (r *Replicator) Reconcile(ctx context.Context, replicated *v1alpha1.Replica
 	// Now that we know the digest we can construct a reference to the image using the digest rather than the tag
 	latestRef := latestTagRef.Tag(latestDesc.Digest.String())
 	log.Info("Latest image", "digest", latestRef.String(), "ref", latestRef.String())
+	replicated.Status.SHA = latestDesc.Digest.String()
 
 	// Get the tags for the source image
 	tags, err := r.getTagsForImage(latestTagRef.Context(), latestDesc.Digest)
@@ -117,6 +130,26 @@ func (r *Replicator) Reconcile(ctx context.Context, replicated *v1alpha1.Replica
 	return allErrors
 }
 
+// setReplicatedConditions sets the conditions of the ReplicatedImage after it was reconciled. err is the error, if
+// any, of the reconcile.
+func setReplicatedConditions(replicated *v1alpha1.ReplicatedImage, err error) {
+	conditions := replicated.Status.Conditions
+	if err == nil {
+		conditions = v1alpha1.SetCondition(conditions, v1alpha1.NewCondition(v1alpha1.SyncedCondition, v1alpha1.ConditionTrue, "Replicated", fmt.Sprintf("Replicated %v to %d destinations", replicated.Status.SHA, len(replicated.Spec.Destinations))))
+		conditions = v1alpha1.SetCondition(conditions, v1alpha1.NewCondition(v1alpha1.ReadyCondition, v1alpha1.ConditionTrue, v1alpha1.ReconcileSucceededReason, ""))
+	} else {
+		synced := v1alpha1.NewCondition(v1alpha1.SyncedCondition, v1alpha1.ConditionUnknown, v1alpha1.ReconcileFailedReason, err.Error())
+		if replicated.Status.SHA != "" {
+			// The source was resolved so copying it to the destinations failed.
+			synced.Status = v1alpha1.ConditionFalse
+			synced.Reason = "ReplicationFailed"
+		}
+		conditions = v1alpha1.SetCondition(conditions, synced)
+		conditions = v1alpha1.SetCondition(conditions, v1alpha1.NewCondition(v1alpha1.ReadyCondition, v1alpha1.ConditionFalse, v1alpha1.ReconcileFailedReason, err.Error()))
+	}
+	replicated.Status.Conditions = conditions
+}
+
 // getTagsForImage returns the tags for the given image digest.
 func (r *Replicator) getTagsForImage(repository name.Repository, digest v1.Hash) ([]string, error) {
 	// List all tags for the repository
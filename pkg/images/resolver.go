@@ -0,0 +1,181 @@
+package images
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+
+	mSemver "github.com/Masterminds/semver/v3"
+	"github.com/go-logr/zapr"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/mod/semver"
+)
+
+// ResolverOption is an option for the Resolver.
+type ResolverOption func(r *Resolver) error
+
+// WithKeychain sets the keychain used to authenticate to registries. Defaults to a keychain using the docker
+// config, Google and GitHub credentials.
+func WithKeychain(k authn.Keychain) ResolverOption {
+	return func(r *Resolver) error {
+		r.keychain = k
+		return nil
+	}
+}
+
+// Resolver resolves images to digests using the OCI distribution API. It works with any registry that supports the
+// API e.g. Docker Hub, GHCR, Quay and Harbor.
+type Resolver struct {
+	keychain authn.Keychain
+}
+
+// NewResolver creates a new resolver.
+func NewResolver(opts ...ResolverOption) (*Resolver, error) {
+	r := &Resolver{
+		keychain: keychain,
+	}
+	for _, o := range opts {
+		if err := o(r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// ResolveImageToSha resolves the image to a sha. Unlike the registry specific resolvers the distribution API
+// has no way to look up the other tags of an image without fetching every tag. So images resolved using the
+// MutableTagStrategy keep their original tag; the sha takes precedence.
+func (r *Resolver) ResolveImageToSha(ctx context.Context, ref util.DockerImageRef, strategy v1alpha1.Strategy) (util.DockerImageRef, error) {
+	tagRef := util.DockerImageRef{Registry: ref.Registry, Repo: ref.Repo, Tag: ref.Tag}
+	tag, err := name.NewTag(tagRef.ToURL())
+	if err != nil {
+		return ref, errors.Wrapf(err, "Failed to parse image %v", tagRef.ToURL())
+	}
+
+	log := zapr.NewLogger(zap.L())
+	log.Info("Getting descriptor", "image", tag.String(), "strategy", strategy)
+	desc, err := remote.Head(tag, remote.WithAuthFromKeychain(r.keychain), remote.WithContext(ctx))
+	if err != nil {
+		return ref, errors.Wrapf(err, "Failed to get descriptor for image %v", tag.String())
+	}
+
+	resolved := ref
+	resolved.Sha = desc.Digest.String()
+	return resolved, nil
+}
+
+// NewestMatchingTag lists the tags of the image's repository and returns the newest tag matching pattern.
+// Tags are ordered by semantic version; a leading "v" is optional. Tags that aren't semantic versions are
+// considered older than those that are and are ordered lexically.
+func (r *Resolver) NewestMatchingTag(ctx context.Context, ref util.DockerImageRef, pattern string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to compile tag pattern %v", pattern)
+	}
+
+	tags, err := r.listTags(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+
+	matched := []string{}
+	for _, t := range tags {
+		if re.MatchString(t) {
+			matched = append(matched, t)
+		}
+	}
+
+	if len(matched) == 0 {
+		return "", errors.Errorf("No tags in repository %v/%v match %v", ref.Registry, ref.Repo, pattern)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return compareTags(matched[i], matched[j]) < 0
+	})
+	return matched[len(matched)-1], nil
+}
+
+// HighestVersion lists the tags of the image's repository and returns the tag with the highest semantic version
+// satisfying constraint. Tags that aren't semantic versions are ignored.
+func (r *Resolver) HighestVersion(ctx context.Context, ref util.DockerImageRef, constraint string) (string, error) {
+	c, err := mSemver.NewConstraint(constraint)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to parse constraint %v", constraint)
+	}
+
+	tags, err := r.listTags(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+
+	var highest *mSemver.Version
+	highestTag := ""
+	for _, t := range tags {
+		v, err := mSemver.NewVersion(t)
+		if err != nil {
+			continue
+		}
+		if !c.Check(v) {
+			continue
+		}
+		if highest == nil || v.GreaterThan(highest) {
+			highest = v
+			highestTag = t
+		}
+	}
+
+	if highest == nil {
+		return "", errors.Errorf("No tags in repository %v/%v satisfy %v", ref.Registry, ref.Repo, constraint)
+	}
+	return highestTag, nil
+}
+
+// listTags lists the tags in the image's repository.
+func (r *Resolver) listTags(ctx context.Context, ref util.DockerImageRef) ([]string, error) {
+	repoRef := util.DockerImageRef{Registry: ref.Registry, Repo: ref.Repo}
+	repo, err := name.NewRepository(repoRef.ToURL())
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse repository %v", repoRef.ToURL())
+	}
+
+	tags, err := remote.List(repo, remote.WithAuthFromKeychain(r.keychain), remote.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to list tags for repository %v", repo.String())
+	}
+	return tags, nil
+}
+
+// compareTags compares two tags by semantic version. Tags that aren't valid semantic versions sort before those
+// that are.
+func compareTags(a, b string) int {
+	va := toSemver(a)
+	vb := toSemver(b)
+	aValid := semver.IsValid(va)
+	bValid := semver.IsValid(vb)
+	switch {
+	case aValid && bValid:
+		if c := semver.Compare(va, vb); c != 0 {
+			return c
+		}
+	case aValid:
+		return 1
+	case bValid:
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+// toSemver adds the "v" prefix required by the semver package.
+func toSemver(tag string) string {
+	if strings.HasPrefix(tag, "v") {
+		return tag
+	}
+	return "v" + tag
+}
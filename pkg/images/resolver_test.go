@@ -0,0 +1,175 @@
+package images
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/util"
+)
+
+func Test_ResolveImageToSha(t *testing.T) {
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL; %v", err)
+	}
+
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("Failed to create image; %v", err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Failed to get digest; %v", err)
+	}
+	tag, err := name.NewTag(u.Host + "/acme/hercules:latest")
+	if err != nil {
+		t.Fatalf("Failed to parse tag; %v", err)
+	}
+	if err := remote.Write(tag, img); err != nil {
+		t.Fatalf("Failed to push image; %v", err)
+	}
+
+	r, err := NewResolver(WithKeychain(authn.DefaultKeychain))
+	if err != nil {
+		t.Fatalf("Failed to create resolver; %v", err)
+	}
+
+	ref := util.DockerImageRef{Registry: u.Host, Repo: "acme/hercules", Tag: "latest"}
+	resolved, err := r.ResolveImageToSha(context.Background(), ref, v1alpha1.MutableTagStrategy)
+	if err != nil {
+		t.Fatalf("Failed to resolve image; %v", err)
+	}
+	expected := ref
+	expected.Sha = digest.String()
+	if resolved != expected {
+		t.Errorf("Got %+v; want %+v", resolved, expected)
+	}
+
+	if _, err := r.ResolveImageToSha(context.Background(), util.DockerImageRef{Registry: u.Host, Repo: "acme/hercules", Tag: "missing"}, v1alpha1.SourceCommitStrategy); err == nil {
+		t.Errorf("Expected an error resolving a missing tag")
+	}
+}
+
+func Test_NewestMatchingTag(t *testing.T) {
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL; %v", err)
+	}
+
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("Failed to create image; %v", err)
+	}
+	for _, tag := range []string{"1.24.0", "1.25.2", "1.25.10", "1.25.9-alpine", "1.26.0", "latest"} {
+		ref, err := name.NewTag(u.Host + "/library/nginx:" + tag)
+		if err != nil {
+			t.Fatalf("Failed to parse tag; %v", err)
+		}
+		if err := remote.Write(ref, img); err != nil {
+			t.Fatalf("Failed to push image; %v", err)
+		}
+	}
+
+	r, err := NewResolver(WithKeychain(authn.DefaultKeychain))
+	if err != nil {
+		t.Fatalf("Failed to create resolver; %v", err)
+	}
+
+	type testCase struct {
+		pattern  string
+		expected string
+	}
+	testCases := []testCase{
+		{pattern: `^1\.25\.[0-9]+$`, expected: "1.25.10"},
+		{pattern: `^1\.25\.`, expected: "1.25.10"},
+		{pattern: `^[0-9.]+$`, expected: "1.26.0"},
+		{pattern: `.*`, expected: "1.26.0"},
+		{pattern: `^l`, expected: "latest"},
+	}
+
+	ref := util.DockerImageRef{Registry: u.Host, Repo: "library/nginx", Tag: "1.25"}
+	for _, c := range testCases {
+		actual, err := r.NewestMatchingTag(context.Background(), ref, c.pattern)
+		if err != nil {
+			t.Errorf("Pattern %v: failed to get newest matching tag; %v", c.pattern, err)
+			continue
+		}
+		if actual != c.expected {
+			t.Errorf("Pattern %v: got %v; want %v", c.pattern, actual, c.expected)
+		}
+	}
+
+	if _, err := r.NewestMatchingTag(context.Background(), ref, `^2\.`); err == nil {
+		t.Errorf("Expected an error when no tags match")
+	}
+}
+
+func Test_HighestVersion(t *testing.T) {
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse URL; %v", err)
+	}
+
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("Failed to create image; %v", err)
+	}
+	for _, tag := range []string{"v1.1.0", "v1.2.0", "v1.10.1", "v1.11.0-rc.1", "v2.0.0", "latest"} {
+		ref, err := name.NewTag(u.Host + "/acme/hercules:" + tag)
+		if err != nil {
+			t.Fatalf("Failed to parse tag; %v", err)
+		}
+		if err := remote.Write(ref, img); err != nil {
+			t.Fatalf("Failed to push image; %v", err)
+		}
+	}
+
+	r, err := NewResolver(WithKeychain(authn.DefaultKeychain))
+	if err != nil {
+		t.Fatalf("Failed to create resolver; %v", err)
+	}
+
+	type testCase struct {
+		constraint string
+		expected   string
+	}
+	testCases := []testCase{
+		{constraint: ">=1.2.0 <2.0.0", expected: "v1.10.1"},
+		{constraint: "~1.2", expected: "v1.2.0"},
+		{constraint: ">=1.0.0", expected: "v2.0.0"},
+		// Pre-releases are ignored unless the constraint asks for them.
+		{constraint: ">=1.10.0 <2.0.0", expected: "v1.10.1"},
+	}
+
+	ref := util.DockerImageRef{Registry: u.Host, Repo: "acme/hercules", Tag: "stable"}
+	for _, c := range testCases {
+		actual, err := r.HighestVersion(context.Background(), ref, c.constraint)
+		if err != nil {
+			t.Errorf("Constraint %v: failed to get highest version; %v", c.constraint, err)
+			continue
+		}
+		if actual != c.expected {
+			t.Errorf("Constraint %v: got %v; want %v", c.constraint, actual, c.expected)
+		}
+	}
+
+	if _, err := r.HighestVersion(context.Background(), ref, ">=3.0.0"); err == nil {
+		t.Errorf("Expected an error when no tags satisfy the constraint")
+	}
+}
@@ -0,0 +1,306 @@
+// Package janitor garbage collects the artifacts hydros leaves behind e.g. hydration branches, build contexts in GCS
+// and exported images on local disk.
+package janitor
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"github.com/google/go-github/v52/github"
+	hGithub "github.com/jlewi/hydros/pkg/github"
+	"github.com/jlewi/hydros/pkg/github/ghrepo"
+	"github.com/jlewi/monogo/helpers"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+)
+
+// buildContextPattern matches the names of the build contexts the image controller uploads to GCS;
+// <repo>.<commit>.tgz
+var buildContextPattern = regexp.MustCompile(`\.[0-9a-f]{7,40}\.tgz$`)
+
+// ClientFactory creates GitHub clients for a repository.
+type ClientFactory func(org string, repo string) (*github.Client, error)
+
+// TransportClientFactory returns a ClientFactory that creates clients using the transports for the GitHub App.
+func TransportClientFactory(transports *hGithub.TransportManager) ClientFactory {
+	return func(org string, repo string) (*github.Client, error) {
+		tr, err := transports.Get(org, repo)
+		if err != nil {
+			return nil, err
+		}
+		return github.NewClient(&http.Client{Transport: tr}), nil
+	}
+}
+
+// Option is an option for the Janitor.
+type Option func(j *Janitor) error
+
+// WithBranches configures the janitor to delete branches in repo whose names start with prefix.
+// Branches with open PRs are never deleted.
+func WithBranches(repo ghrepo.Interface, prefix string, clients ClientFactory) Option {
+	return func(j *Janitor) error {
+		if prefix == "" {
+			return errors.New("A branch prefix is required; refusing to garbage collect all branches")
+		}
+		j.branches = append(j.branches, branchTarget{repo: repo, prefix: prefix})
+		j.clients = clients
+		return nil
+	}
+}
+
+// WithBuildContexts configures the janitor to delete the build contexts the image controller uploads to bucket.
+func WithBuildContexts(client *storage.Client, bucket string) Option {
+	return func(j *Janitor) error {
+		j.gcsClient = client
+		j.buckets = append(j.buckets, bucket)
+		return nil
+	}
+}
+
+// WithTempDirs configures the janitor to delete directories in dir whose names start with prefix.
+// For example, the directories the image controller exports images to.
+func WithTempDirs(dir string, prefix string) Option {
+	return func(j *Janitor) error {
+		if prefix == "" {
+			return errors.New("A directory prefix is required; refusing to garbage collect all directories")
+		}
+		j.tempDirs = append(j.tempDirs, tempDirTarget{dir: dir, prefix: prefix})
+		return nil
+	}
+}
+
+// WithLeaderCheck configures the janitor to only garbage collect while isLeader returns true so replicas which
+// aren't the leader don't garbage collect the same artifacts.
+func WithLeaderCheck(isLeader func() bool) Option {
+	return func(j *Janitor) error {
+		j.isLeader = isLeader
+		return nil
+	}
+}
+
+type branchTarget struct {
+	repo   ghrepo.Interface
+	prefix string
+}
+
+type tempDirTarget struct {
+	dir    string
+	prefix string
+}
+
+// Janitor deletes artifacts that are older than the retention window.
+type Janitor struct {
+	retention time.Duration
+	log       logr.Logger
+
+	branches []branchTarget
+	clients  ClientFactory
+
+	gcsClient *storage.Client
+	buckets   []string
+
+	tempDirs []tempDirTarget
+
+	// isLeader returns true if this replica is the leader. If it is nil the replica is always the leader.
+	isLeader func() bool
+
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+// New creates a new janitor that deletes artifacts older than retention.
+func New(retention time.Duration, opts ...Option) (*Janitor, error) {
+	if retention <= 0 {
+		return nil, errors.Errorf("retention must be positive; got %v", retention)
+	}
+	j := &Janitor{
+		retention: retention,
+		log:       zapr.NewLogger(zap.L()).WithValues("component", "janitor"),
+		now:       time.Now,
+	}
+	for _, o := range opts {
+		if err := o(j); err != nil {
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// Start runs the janitor every period until the context is cancelled. If a leader check is configured runs are
+// skipped while this replica isn't the leader.
+func (j *Janitor) Start(ctx context.Context, period time.Duration) {
+	j.log.Info("Starting janitor", "period", period, "retention", j.retention)
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			if j.isLeader != nil && !j.isLeader() {
+				j.log.V(1).Info("Not the leader; skipping garbage collection")
+			} else if err := j.RunOnce(ctx); err != nil {
+				j.log.Error(err, "Garbage collection failed")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunOnce deletes all the artifacts that are older than the retention window. It keeps going if deleting some
+// artifacts fails and returns all the errors.
+func (j *Janitor) RunOnce(ctx context.Context) error {
+	cutoff := j.now().Add(-j.retention)
+	finalErr := &helpers.ListOfErrors{}
+
+	for _, b := range j.branches {
+		if err := j.deleteBranches(ctx, b, cutoff); err != nil {
+			finalErr.AddCause(err)
+		}
+	}
+
+	for _, bucket := range j.buckets {
+		if err := j.deleteBuildContexts(ctx, bucket, cutoff); err != nil {
+			finalErr.AddCause(err)
+		}
+	}
+
+	for _, d := range j.tempDirs {
+		if err := j.deleteTempDirs(d, cutoff); err != nil {
+			finalErr.AddCause(err)
+		}
+	}
+
+	if len(finalErr.Causes) > 0 {
+		finalErr.Final = errors.Errorf("Failed to garbage collect %d targets", len(finalErr.Causes))
+		return finalErr
+	}
+	return nil
+}
+
+// deleteBranches deletes the branches matching the target whose head commit is older than cutoff and which don't
+// have an open PR.
+func (j *Janitor) deleteBranches(ctx context.Context, target branchTarget, cutoff time.Time) error {
+	org := target.repo.RepoOwner()
+	repo := target.repo.RepoName()
+	log := j.log.WithValues("repo", ghrepo.FullName(target.repo), "prefix", target.prefix)
+
+	client, err := j.clients(org, repo)
+	if err != nil {
+		return err
+	}
+
+	opts := &github.BranchListOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	toDelete := []string{}
+	for {
+		branches, resp, err := client.Repositories.ListBranches(ctx, org, repo, opts)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to list branches for %v/%v", org, repo)
+		}
+		for _, b := range branches {
+			if !strings.HasPrefix(b.GetName(), target.prefix) || b.GetProtected() {
+				continue
+			}
+			commit, _, err := client.Repositories.GetCommit(ctx, org, repo, b.GetCommit().GetSHA(), nil)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to get commit for branch %v", b.GetName())
+			}
+			if commit.GetCommit().GetCommitter().GetDate().After(cutoff) {
+				continue
+			}
+			toDelete = append(toDelete, b.GetName())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	for _, branch := range toDelete {
+		prs, _, err := client.PullRequests.List(ctx, org, repo, &github.PullRequestListOptions{
+			State: "open",
+			Head:  org + ":" + branch,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "Failed to list PRs for branch %v", branch)
+		}
+		if len(prs) > 0 {
+			log.V(1).Info("Skipping branch with open PR", "branch", branch, "pr", prs[0].GetHTMLURL())
+			continue
+		}
+		log.Info("Deleting branch", "branch", branch)
+		if _, err := client.Git.DeleteRef(ctx, org, repo, "heads/"+branch); err != nil {
+			return errors.Wrapf(err, "Failed to delete branch %v", branch)
+		}
+	}
+	return nil
+}
+
+// deleteBuildContexts deletes the build contexts in the bucket that were last updated before cutoff.
+func (j *Janitor) deleteBuildContexts(ctx context.Context, bucket string, cutoff time.Time) error {
+	if j.gcsClient == nil {
+		return errors.New("GCS client is nil; use WithBuildContexts to configure the janitor")
+	}
+	log := j.log.WithValues("bucket", bucket)
+	b := j.gcsClient.Bucket(bucket)
+	it := b.Objects(ctx, nil)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "Failed to list objects in bucket %v", bucket)
+		}
+		if !IsBuildContext(attrs.Name) || attrs.Updated.After(cutoff) {
+			continue
+		}
+		log.Info("Deleting build context", "object", attrs.Name, "updated", attrs.Updated)
+		if err := b.Object(attrs.Name).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+			return errors.Wrapf(err, "Failed to delete gs://%v/%v", bucket, attrs.Name)
+		}
+	}
+	return nil
+}
+
+// deleteTempDirs deletes the directories matching the target that were last modified before cutoff.
+func (j *Janitor) deleteTempDirs(target tempDirTarget, cutoff time.Time) error {
+	entries, err := os.ReadDir(target.dir)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read directory %v", target.dir)
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), target.prefix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			// The directory was removed while we were listing.
+			continue
+		}
+		if info.ModTime().After(cutoff) {
+			continue
+		}
+		p := filepath.Join(target.dir, e.Name())
+		j.log.Info("Deleting directory", "dir", p, "modTime", info.ModTime())
+		if err := os.RemoveAll(p); err != nil {
+			return errors.Wrapf(err, "Failed to delete directory %v", p)
+		}
+	}
+	return nil
+}
+
+// IsBuildContext returns true if the object name is that of a build context created by the image controller.
+func IsBuildContext(name string) bool {
+	return buildContextPattern.MatchString(name)
+}
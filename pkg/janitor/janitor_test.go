@@ -0,0 +1,201 @@
+package janitor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-github/v52/github"
+	"github.com/jlewi/hydros/pkg/github/ghrepo"
+)
+
+func Test_DeleteBranches(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	commitDates := map[string]time.Time{
+		"old": now.Add(-30 * 24 * time.Hour),
+		"new": now.Add(-time.Hour),
+	}
+
+	var mu sync.Mutex
+	deleted := []string{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/acme/manifests/branches", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"name": "hydros/old", "commit": map[string]string{"sha": "old"}},
+			{"name": "hydros/new", "commit": map[string]string{"sha": "new"}},
+			{"name": "hydros/open-pr", "commit": map[string]string{"sha": "old"}},
+			{"name": "main", "commit": map[string]string{"sha": "old"}},
+		})
+	})
+	mux.HandleFunc("/repos/acme/manifests/commits/", func(w http.ResponseWriter, r *http.Request) {
+		sha := strings.TrimPrefix(r.URL.Path, "/repos/acme/manifests/commits/")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"sha":    sha,
+			"commit": map[string]interface{}{"committer": map[string]interface{}{"date": commitDates[sha]}},
+		})
+	})
+	mux.HandleFunc("/repos/acme/manifests/pulls", func(w http.ResponseWriter, r *http.Request) {
+		prs := []map[string]interface{}{}
+		if r.URL.Query().Get("head") == "acme:hydros/open-pr" {
+			prs = append(prs, map[string]interface{}{"number": 1})
+		}
+		json.NewEncoder(w).Encode(prs)
+	})
+	mux.HandleFunc("/repos/acme/manifests/git/refs/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/repos/acme/manifests/git/refs/"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	clients := func(org string, repo string) (*github.Client, error) {
+		client := github.NewClient(nil)
+		u, err := url.Parse(srv.URL + "/")
+		if err != nil {
+			return nil, err
+		}
+		client.BaseURL = u
+		return client, nil
+	}
+
+	j, err := New(7*24*time.Hour, WithBranches(ghrepo.New("acme", "manifests"), "hydros/", clients))
+	if err != nil {
+		t.Fatalf("Failed to create janitor; %v", err)
+	}
+	j.now = func() time.Time { return now }
+
+	if err := j.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce failed; %+v", err)
+	}
+
+	if d := cmp.Diff([]string{"heads/hydros/old"}, deleted); d != "" {
+		t.Errorf("Unexpected branches deleted; diff:\n%v", d)
+	}
+}
+
+func Test_DeleteTempDirs(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for name, age := range map[string]time.Duration{
+		"hydrosImageReconciler123": 48 * time.Hour,
+		"hydrosImageReconciler456": time.Minute,
+		"other123":                 48 * time.Hour,
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.Mkdir(p, 0o755); err != nil {
+			t.Fatalf("Failed to create directory; %v", err)
+		}
+		modTime := now.Add(-age)
+		if err := os.Chtimes(p, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set modification time; %v", err)
+		}
+	}
+
+	j, err := New(24*time.Hour, WithTempDirs(dir, "hydrosImageReconciler"))
+	if err != nil {
+		t.Fatalf("Failed to create janitor; %v", err)
+	}
+	if err := j.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce failed; %+v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read directory; %v", err)
+	}
+	remaining := []string{}
+	for _, e := range entries {
+		remaining = append(remaining, e.Name())
+	}
+	sort.Strings(remaining)
+	if d := cmp.Diff([]string{"hydrosImageReconciler456", "other123"}, remaining); d != "" {
+		t.Errorf("Unexpected directories remaining; diff:\n%v", d)
+	}
+}
+
+func Test_StartSkipsFollowers(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "hydrosImageReconciler123")
+	if err := os.Mkdir(p, 0o755); err != nil {
+		t.Fatalf("Failed to create directory; %v", err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(p, old, old); err != nil {
+		t.Fatalf("Failed to set modification time; %v", err)
+	}
+
+	checks := make(chan bool, 10)
+	var mu sync.Mutex
+	isLeader := false
+	j, err := New(24*time.Hour, WithTempDirs(dir, "hydrosImageReconciler"), WithLeaderCheck(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		select {
+		case checks <- isLeader:
+		default:
+		}
+		return isLeader
+	}))
+	if err != nil {
+		t.Fatalf("Failed to create janitor; %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	j.Start(ctx, 10*time.Millisecond)
+
+	// Wait for a run after the first one was skipped.
+	<-checks
+	<-checks
+	if _, err := os.Stat(p); err != nil {
+		t.Fatalf("Expected %v not to be garbage collected while not the leader; %v", p, err)
+	}
+
+	mu.Lock()
+	isLeader = true
+	mu.Unlock()
+	for leader := range checks {
+		if leader {
+			break
+		}
+	}
+	// The check runs before garbage collection so wait for the next check.
+	<-checks
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Errorf("Expected %v to be garbage collected once the leader; %v", p, err)
+	}
+}
+
+func Test_IsBuildContext(t *testing.T) {
+	type testCase struct {
+		name     string
+		expected bool
+	}
+	testCases := []testCase{
+		{name: "acme/images/hercules.0123456789abcdef0123456789abcdef01234567.tgz", expected: true},
+		{name: "hercules.d891862.tgz", expected: true},
+		{name: "hercules.tgz", expected: false},
+		{name: "releases/hercules.d891862.tar", expected: false},
+	}
+	for _, c := range testCases {
+		if actual := IsBuildContext(c.name); actual != c.expected {
+			t.Errorf("IsBuildContext(%v) = %v; want %v", c.name, actual, c.expected)
+		}
+	}
+}
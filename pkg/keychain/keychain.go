@@ -0,0 +1,91 @@
+// Package keychain reads and writes secrets in the credential store of the operating system i.e. the macOS
+// keychain, the Windows Credential Manager or the Secret Service (e.g. GNOME Keyring) on Linux. This lets
+// developers running hydros on their laptops keep secrets such as the private key of the GitHub App out of
+// plaintext files.
+package keychain
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/jlewi/monogo/files"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Scheme is the URI scheme for secrets stored in the keychain e.g. keychain://hydros/github-app-key.
+	Scheme = "keychain"
+
+	// base64Prefix marks secrets that were base64 encoded before they were stored because the credential store
+	// can't return arbitrary bytes e.g. the macOS security CLI hex encodes secrets containing newlines.
+	base64Prefix = "hydros-base64:"
+)
+
+// ErrNotFound is returned when the secret isn't in the keychain.
+var ErrNotFound = errors.New("secret not found in the keychain")
+
+// URI returns the URI of the secret stored for account in service.
+func URI(service string, account string) string {
+	return fmt.Sprintf("%v://%v/%v", Scheme, service, account)
+}
+
+// IsKeychainURI returns true if uri refers to a secret in the keychain.
+func IsKeychainURI(uri string) bool {
+	return strings.HasPrefix(strings.ToLower(uri), Scheme+"://")
+}
+
+// Parse returns the service and account of a keychain URI of the form keychain://<service>/<account>.
+func Parse(uri string) (string, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "Failed to parse %v", uri)
+	}
+	if u.Scheme != Scheme {
+		return "", "", errors.Errorf("URI %v doesn't have scheme %v", uri, Scheme)
+	}
+	account := strings.Trim(u.Path, "/")
+	if u.Host == "" || account == "" {
+		return "", "", errors.Errorf("URI %v is invalid; keychain URIs must have the form %v://<service>/<account>", uri, Scheme)
+	}
+	return u.Host, account, nil
+}
+
+// Get returns the secret stored for account in service.
+func Get(service string, account string) ([]byte, error) {
+	secret, err := get(service, account)
+	if err != nil {
+		return nil, err
+	}
+	if s := string(secret); strings.HasPrefix(s, base64Prefix) {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, base64Prefix))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to decode secret %v", URI(service, account))
+		}
+		return decoded, nil
+	}
+	return secret, nil
+}
+
+// Set stores the secret for account in service replacing any existing secret.
+func Set(service string, account string, secret []byte) error {
+	return set(service, account, secret)
+}
+
+// Read reads the secret at uri. Keychain URIs are read from the keychain; all other URIs e.g. local files and
+// gcpSecretManager:///... are read using files.Read.
+func Read(uri string) ([]byte, error) {
+	if !IsKeychainURI(uri) {
+		return files.Read(uri)
+	}
+	service, account, err := Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := Get(service, account)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read %v from the keychain", uri)
+	}
+	return secret, nil
+}
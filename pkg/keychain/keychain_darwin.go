@@ -0,0 +1,37 @@
+package keychain
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// N.B. We use the security CLI rather than linking against the Security framework so hydros doesn't need cgo.
+
+func get(service string, account string) ([]byte, error) {
+	cmd := exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(stderr.String(), "could not be found") {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "security find-generic-password failed; %v", stderr.String())
+	}
+	return bytes.TrimSuffix(out, []byte("\n")), nil
+}
+
+func set(service string, account string, secret []byte) error {
+	// security hex encodes secrets that aren't printable when reading them so secrets are stored base64 encoded.
+	value := base64Prefix + base64.StdEncoding.EncodeToString(secret)
+	// -U updates the secret if it already exists.
+	cmd := exec.Command("security", "add-generic-password", "-U", "-s", service, "-a", account, "-w", value)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "security add-generic-password failed; %v", string(out))
+	}
+	return nil
+}
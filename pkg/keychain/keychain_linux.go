@@ -0,0 +1,35 @@
+package keychain
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// N.B. We use secret-tool (part of libsecret) to talk to the Secret Service e.g. GNOME Keyring or KWallet.
+
+func get(service string, account string) ([]byte, error) {
+	cmd := exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// secret-tool exits with status 1 and no output if the secret doesn't exist.
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 && stderr.Len() == 0 {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "secret-tool lookup failed; %v", stderr.String())
+	}
+	return out, nil
+}
+
+func set(service string, account string, secret []byte) error {
+	cmd := exec.Command("secret-tool", "store", "--label", fmt.Sprintf("%v %v", service, account), "service", service, "account", account)
+	cmd.Stdin = bytes.NewReader(secret)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "secret-tool store failed; %v", string(out))
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !darwin && !linux && !windows
+
+package keychain
+
+import (
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+func get(service string, account string) ([]byte, error) {
+	return nil, errors.Errorf("The keychain isn't supported on %v", runtime.GOOS)
+}
+
+func set(service string, account string, secret []byte) error {
+	return errors.Errorf("The keychain isn't supported on %v", runtime.GOOS)
+}
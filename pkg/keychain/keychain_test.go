@@ -0,0 +1,60 @@
+package keychain
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Parse(t *testing.T) {
+	type testCase struct {
+		name            string
+		uri             string
+		expectedService string
+		expectedAccount string
+		expectErr       bool
+	}
+
+	cases := []testCase{
+		{name: "basic", uri: URI("hydros", "github.privateKey"), expectedService: "hydros", expectedAccount: "github.privateKey"},
+		{name: "nested-account", uri: "keychain://hydros/acme/github-app", expectedService: "hydros", expectedAccount: "acme/github-app"},
+		{name: "missing-account", uri: "keychain://hydros", expectErr: true},
+		{name: "wrong-scheme", uri: "file:///tmp/key.pem", expectErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			service, account, err := Parse(c.uri)
+			if c.expectErr {
+				if err == nil {
+					t.Fatalf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse failed; %v", err)
+			}
+			if service != c.expectedService || account != c.expectedAccount {
+				t.Errorf("Got service %v account %v; want %v %v", service, account, c.expectedService, c.expectedAccount)
+			}
+		})
+	}
+}
+
+func Test_ReadFile(t *testing.T) {
+	// URIs that aren't keychain URIs are read with files.Read.
+	p := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(p, []byte("secret"), 0o600); err != nil {
+		t.Fatalf("Failed to write file; %v", err)
+	}
+	actual, err := Read(p)
+	if err != nil {
+		t.Fatalf("Read failed; %v", err)
+	}
+	if string(actual) != "secret" {
+		t.Errorf("Got %v; want secret", string(actual))
+	}
+	if IsKeychainURI(p) || !IsKeychainURI("Keychain://hydros/key") {
+		t.Errorf("IsKeychainURI returned the wrong result")
+	}
+}
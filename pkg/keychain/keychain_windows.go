@@ -0,0 +1,96 @@
+package keychain
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// Secrets are stored as generic credentials in the Windows Credential Manager with the target name
+// <service>:<account>.
+
+var (
+	advapi32       = syscall.NewLazyDLL("advapi32.dll")
+	procCredReadW  = advapi32.NewProc("CredReadW")
+	procCredWriteW = advapi32.NewProc("CredWriteW")
+	procCredFree   = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+	// errorNotFound is ERROR_NOT_FOUND.
+	errorNotFound = syscall.Errno(1168)
+	// maxCredentialBlobSize is CRED_MAX_CREDENTIAL_BLOB_SIZE.
+	maxCredentialBlobSize = 5 * 512
+)
+
+// credential is CREDENTIALW.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+func get(service string, account string) ([]byte, error) {
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid service %v or account %v", service, account)
+	}
+	var cred *credential
+	r, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		if err == errorNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "CredRead failed")
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	if cred.CredentialBlobSize == 0 {
+		return []byte{}, nil
+	}
+	secret := make([]byte, cred.CredentialBlobSize)
+	copy(secret, unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize))
+	return secret, nil
+}
+
+func set(service string, account string, secret []byte) error {
+	if len(secret) == 0 {
+		return errors.New("Can't store an empty secret")
+	}
+	if len(secret) > maxCredentialBlobSize {
+		return errors.Errorf("Secret is %v bytes; the Windows Credential Manager stores at most %v bytes", len(secret), maxCredentialBlobSize)
+	}
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid service %v or account %v", service, account)
+	}
+	user, err := syscall.UTF16PtrFromString(account)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid account %v", account)
+	}
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(secret)),
+		CredentialBlob:     &secret[0],
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+	r, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0)
+	if r == 0 {
+		return errors.Wrapf(err, "CredWrite failed")
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package kube
+
+import (
+	"github.com/pkg/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// NewLeaseLock creates a lock for leader election stored in the Lease name in namespace. identity identifies this
+// replica e.g. the name of its pod.
+func NewLeaseLock(client kubernetes.Interface, namespace string, name string, identity string) (resourcelock.Interface, error) {
+	if client == nil {
+		return nil, errors.New("client is required")
+	}
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, namespace, name, client.CoreV1(), client.CoordinationV1(), resourcelock.ResourceLockConfig{
+		Identity: identity,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create lease lock %v/%v", namespace, name)
+	}
+	return lock, nil
+}
+
+// NewInClusterLeaseLock creates a lock stored in the Lease name in the namespace of the pod hydros is running in.
+// The pod name is used as the identity; see NewInClusterRecorder for how the namespace and pod name are determined.
+func NewInClusterLeaseLock(name string) (resourcelock.Interface, error) {
+	client, namespace, podName, err := inClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	return NewLeaseLock(client, namespace, name, podName)
+}
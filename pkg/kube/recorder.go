@@ -0,0 +1,243 @@
+// Package kube contains helpers for running hydros in Kubernetes.
+package kube
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-logr/zapr"
+	"github.com/jlewi/hydros/pkg/gitops"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// Component is the source component of the events emitted by hydros.
+	Component = "hydros"
+
+	// ReasonReconcileSucceeded is the reason of events for successful reconciles.
+	ReasonReconcileSucceeded = "ReconcileSucceeded"
+	// ReasonReconcileFailed is the reason of events for failed reconciles.
+	ReasonReconcileFailed = "ReconcileFailed"
+
+	// ReconcilerLabel is the label on status ConfigMaps identifying the reconciler.
+	ReconcilerLabel = "hydros.dev/reconciler"
+	managedByLabel  = "app.kubernetes.io/managed-by"
+
+	namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+	// maxMessageLength is the maximum length of event messages. The API server rejects longer messages.
+	maxMessageLength = 1024
+)
+
+var (
+	invalidNameChars  = regexp.MustCompile(`[^a-z0-9.-]+`)
+	invalidLabelChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+)
+
+// InCluster returns true if hydros is running in a Kubernetes pod.
+func InCluster() bool {
+	return os.Getenv("KUBERNETES_SERVICE_HOST") != "" && os.Getenv("KUBERNETES_SERVICE_PORT") != ""
+}
+
+// RecorderOption is an option for the Recorder.
+type RecorderOption func(r *Recorder) error
+
+// WithStatusConfigMaps configures the recorder to also store the result of the latest reconcile of each reconciler
+// in a ConfigMap named hydros-<reconciler>.
+func WithStatusConfigMaps() RecorderOption {
+	return func(r *Recorder) error {
+		r.configMaps = true
+		return nil
+	}
+}
+
+// Recorder records the results of reconciles as Kubernetes Events on the pod running hydros. This lets operators
+// debug hydros using kubectl describe and kubectl get events.
+type Recorder struct {
+	client     kubernetes.Interface
+	namespace  string
+	podName    string
+	configMaps bool
+}
+
+// NewRecorder creates a recorder that emits events for the pod podName in namespace.
+func NewRecorder(client kubernetes.Interface, namespace string, podName string, opts ...RecorderOption) (*Recorder, error) {
+	if client == nil {
+		return nil, errors.New("client is required")
+	}
+	if namespace == "" {
+		return nil, errors.New("namespace is required")
+	}
+	if podName == "" {
+		return nil, errors.New("podName is required")
+	}
+	r := &Recorder{
+		client:    client,
+		namespace: namespace,
+		podName:   podName,
+	}
+	for _, o := range opts {
+		if err := o(r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// NewInClusterRecorder creates a recorder using the in cluster config. The namespace and pod name are read from
+// the POD_NAMESPACE and POD_NAME environment variables which can be set using the downward API. If they aren't
+// set the namespace of the service account and the hostname are used.
+func NewInClusterRecorder(opts ...RecorderOption) (*Recorder, error) {
+	client, namespace, podName, err := inClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	return NewRecorder(client, namespace, podName, opts...)
+}
+
+// inClusterClient creates a client using the in cluster config and returns it along with the namespace and name
+// of the pod hydros is running in.
+func inClusterClient() (kubernetes.Interface, string, string, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, "", "", errors.Wrapf(err, "Failed to get in cluster config")
+	}
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, "", "", errors.Wrapf(err, "Failed to create Kubernetes client")
+	}
+
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		b, err := os.ReadFile(namespaceFile)
+		if err != nil {
+			return nil, "", "", errors.Wrapf(err, "Failed to determine the namespace; set POD_NAMESPACE")
+		}
+		namespace = strings.TrimSpace(string(b))
+	}
+
+	podName := os.Getenv("POD_NAME")
+	if podName == "" {
+		podName, err = os.Hostname()
+		if err != nil {
+			return nil, "", "", errors.Wrapf(err, "Failed to determine the pod name; set POD_NAME")
+		}
+	}
+	return client, namespace, podName, nil
+}
+
+// Record implements gitops.ResultRecorder. Failures to record results are logged but otherwise ignored because
+// they shouldn't block reconciling.
+func (r *Recorder) Record(result gitops.ReconcileResult) {
+	log := zapr.NewLogger(zap.L()).WithValues("reconciler", result.Name)
+	ctx := context.Background()
+	if err := r.createEvent(ctx, result); err != nil {
+		log.Error(err, "Failed to create event for reconcile")
+	}
+	if !r.configMaps {
+		return
+	}
+	if err := r.updateConfigMap(ctx, result); err != nil {
+		log.Error(err, "Failed to update status ConfigMap for reconcile")
+	}
+}
+
+func (r *Recorder) createEvent(ctx context.Context, result gitops.ReconcileResult) error {
+	eventType := corev1.EventTypeNormal
+	reason := ReasonReconcileSucceeded
+	message := fmt.Sprintf("Reconciler %v succeeded in %v", result.Name, result.Duration.Round(time.Millisecond))
+	if result.Err != nil {
+		eventType = corev1.EventTypeWarning
+		reason = ReasonReconcileFailed
+		message = fmt.Sprintf("Reconciler %v failed after %v: %v", result.Name, result.Duration.Round(time.Millisecond), result.Err)
+	}
+	if len(message) > maxMessageLength {
+		message = message[:maxMessageLength]
+	}
+
+	now := metav1.NewTime(time.Now())
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			// Use the same naming scheme as client-go's event recorder.
+			Name:      fmt.Sprintf("%v.%x", r.podName, now.UnixNano()),
+			Namespace: r.namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Name:       r.podName,
+			Namespace:  r.namespace,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: Component},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	_, err := r.client.CoreV1().Events(r.namespace).Create(ctx, event, metav1.CreateOptions{})
+	return errors.Wrapf(err, "Failed to create event %v", event.Name)
+}
+
+func (r *Recorder) updateConfigMap(ctx context.Context, result gitops.ReconcileResult) error {
+	status := "Succeeded"
+	errMessage := ""
+	if result.Err != nil {
+		status = "Failed"
+		errMessage = result.Err.Error()
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ConfigMapName(result.Name),
+			Namespace: r.namespace,
+			Labels: map[string]string{
+				managedByLabel:  Component,
+				ReconcilerLabel: labelValue(result.Name),
+			},
+		},
+		Data: map[string]string{
+			"reconciler": result.Name,
+			"status":     status,
+			"error":      errMessage,
+			"startTime":  result.StartTime.UTC().Format(time.RFC3339),
+			"duration":   result.Duration.Round(time.Millisecond).String(),
+		},
+	}
+
+	configMaps := r.client.CoreV1().ConfigMaps(r.namespace)
+	_, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+	}
+	return errors.Wrapf(err, "Failed to update ConfigMap %v", cm.Name)
+}
+
+// ConfigMapName returns the name of the status ConfigMap for the reconciler.
+func ConfigMapName(reconciler string) string {
+	name := "hydros-" + strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(reconciler), "-"), "-.")
+	if len(name) > 253 {
+		name = name[:253]
+	}
+	return strings.TrimRight(name, "-.")
+}
+
+// labelValue converts the name of the reconciler into a valid label value.
+func labelValue(reconciler string) string {
+	v := invalidLabelChars.ReplaceAllString(reconciler, "-")
+	if len(v) > 63 {
+		v = v[:63]
+	}
+	return strings.Trim(v, "-_.")
+}
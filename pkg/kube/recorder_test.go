@@ -0,0 +1,59 @@
+package kube
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jlewi/hydros/pkg/gitops"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_Recorder(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	r, err := NewRecorder(client, "hydros", "hydros-0", WithStatusConfigMaps())
+	if err != nil {
+		t.Fatalf("Failed to create recorder; %v", err)
+	}
+
+	name := "renderer/jlewi/hydros-hydrated"
+	r.Record(gitops.ReconcileResult{Name: name, StartTime: time.Now(), Duration: time.Second})
+	r.Record(gitops.ReconcileResult{Name: name, StartTime: time.Now(), Duration: time.Second, Err: errors.New("push failed")})
+
+	events, err := client.CoreV1().Events("hydros").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list events; %v", err)
+	}
+	if len(events.Items) != 2 {
+		t.Fatalf("Expected 2 events; got %v", len(events.Items))
+	}
+	reasons := map[string]string{}
+	for _, e := range events.Items {
+		if e.InvolvedObject.Kind != "Pod" || e.InvolvedObject.Name != "hydros-0" {
+			t.Errorf("Event isn't for the hydros pod; got %+v", e.InvolvedObject)
+		}
+		reasons[e.Reason] = e.Type
+	}
+	if reasons[ReasonReconcileSucceeded] != corev1.EventTypeNormal || reasons[ReasonReconcileFailed] != corev1.EventTypeWarning {
+		t.Errorf("Unexpected events; got %v", reasons)
+	}
+
+	cm, err := client.CoreV1().ConfigMaps("hydros").Get(context.Background(), ConfigMapName(name), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get status ConfigMap; %v", err)
+	}
+	if cm.Data["status"] != "Failed" || cm.Data["error"] != "push failed" {
+		t.Errorf("ConfigMap doesn't have the latest result; got %v", cm.Data)
+	}
+}
+
+func Test_ConfigMapName(t *testing.T) {
+	actual := ConfigMapName("renderer/jlewi/Hydros_Hydrated")
+	expected := "hydros-renderer-jlewi-hydros-hydrated"
+	if actual != expected {
+		t.Errorf("Got %v; want %v", actual, expected)
+	}
+}
@@ -8,10 +8,13 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jlewi/hydros/pkg/kustomize/fns/patches"
 
 	"github.com/go-logr/logr"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/config"
 	"github.com/pkg/errors"
 
 	"sigs.k8s.io/kustomize/kyaml/kio"
@@ -20,11 +23,19 @@ import (
 	"sigs.k8s.io/kustomize/kyaml/yaml"
 
 	"github.com/jlewi/hydros/pkg/kustomize/fns/configmap"
+	"github.com/jlewi/hydros/pkg/kustomize/fns/digests"
 	"github.com/jlewi/hydros/pkg/kustomize/fns/envs"
 	"github.com/jlewi/hydros/pkg/kustomize/fns/fields"
 	"github.com/jlewi/hydros/pkg/kustomize/fns/images"
 	"github.com/jlewi/hydros/pkg/kustomize/fns/labels"
+	"github.com/jlewi/hydros/pkg/kustomize/fns/naming"
+	"github.com/jlewi/hydros/pkg/kustomize/fns/resources"
 	"github.com/jlewi/hydros/pkg/kustomize/fns/s3assets"
+	"github.com/jlewi/hydros/pkg/kustomize/fns/scaling"
+	"github.com/jlewi/hydros/pkg/kustomize/fns/scheduling"
+	"github.com/jlewi/hydros/pkg/kustomize/fns/schemas"
+	"github.com/jlewi/hydros/pkg/kustomize/fns/scripted"
+	"github.com/jlewi/hydros/pkg/kustomize/fns/secretrefs"
 	"github.com/jlewi/hydros/pkg/util"
 )
 
@@ -38,17 +49,42 @@ const (
 // Dispatcher dispatches to the matching API
 type Dispatcher struct {
 	Log logr.Logger
+	// Timeout is how long each function is allowed to run. Zero means there is no timeout.
+	// It can be overridden for individual functions with the TimeoutAnnotation.
+	Timeout time.Duration
+	// Kinds restricts the kinds of functions that are applied. If nil all kinds in the dispatchTable are applied.
+	Kinds *v1alpha1.FunctionKinds
+	// External selects the external functions i.e. functions run as a container or an executable that are applied.
+	// It comes from the resource being hydrated so functions must also be allowed by Allowed. If nil no external
+	// functions are applied.
+	External *v1alpha1.ExternalFunctions
+	// Allowed is the allowlist of external functions from the hydros config. If nil no external functions are
+	// applied.
+	Allowed *config.FunctionsConfig
+	// Report, if not nil, collects the results of applying each function.
+	Report *Report
+	// Layout is the layout of the hydrated kustomizations. It determines the directories that functions are
+	// applied to when leafPaths are passed to SetFuncPaths.
+	Layout *v1alpha1.DestLayout
 }
 
 // dispatchTable maps configFunction Kinds to implementations
 var dispatchTable = map[string]func() kio.Filter{
-	configmap.Kind: configmap.Filter,
-	envs.Kind:      envs.Filter,
-	fields.Kind:    fields.Filter,
-	images.Kind:    images.Filter,
-	labels.Kind:    labels.Filter,
-	s3assets.Kind:  s3assets.Filter,
-	patches.Kind:   patches.Filter,
+	configmap.Kind:  configmap.Filter,
+	digests.Kind:    digests.Filter,
+	envs.Kind:       envs.Filter,
+	fields.Kind:     fields.Filter,
+	images.Kind:     images.Filter,
+	labels.Kind:     labels.Filter,
+	naming.Kind:     naming.Filter,
+	s3assets.Kind:   s3assets.Filter,
+	patches.Kind:    patches.Filter,
+	resources.Kind:  resources.Filter,
+	scaling.Kind:    scaling.Filter,
+	scheduling.Kind: scheduling.Filter,
+	schemas.Kind:    schemas.Filter,
+	scripted.Kind:   scripted.Filter,
+	secretrefs.Kind: secretrefs.Filter,
 }
 
 func isValidFnKind(category string) bool {
@@ -56,6 +92,21 @@ func isValidFnKind(category string) bool {
 	return valid
 }
 
+// IsFunction returns true if the node is the config of a function known to the dispatcher or of an external
+// function.
+func IsFunction(node *yaml.RNode) bool {
+	return isValidFnKind(node.GetKind()) || externalFunctionSpec(node) != nil
+}
+
+// Filters returns the constructors of all the functions known to the dispatcher keyed by kind.
+func Filters() map[string]func() kio.Filter {
+	result := make(map[string]func() kio.Filter, len(dispatchTable))
+	for k, fn := range dispatchTable {
+		result[k] = fn
+	}
+	return result
+}
+
 // RegisterFilter registers a function with the dispatcher
 func RegisterFilter(kind string, fn func() kio.Filter) {
 	dispatchTable[kind] = fn
@@ -154,7 +205,7 @@ func (d *Dispatcher) GetAllFuncs(sourceDir []string) (kio.PackageBuffer, error)
 		findAllFn := kio.FilterFunc(func(operand []*yaml.RNode) ([]*yaml.RNode, error) {
 			for i := range operand {
 				resource := operand[i]
-				if isValidFnKind(resource.GetKind()) {
+				if IsFunction(resource) && d.Kinds.IsAllowed(resource.GetKind()) {
 					allFilteredFns = append(allFilteredFns, resource)
 				}
 			}
@@ -173,13 +224,26 @@ func (d *Dispatcher) GetAllFuncs(sourceDir []string) (kio.PackageBuffer, error)
 	return kio.PackageBuffer{Nodes: allFilteredFns}, nil
 }
 
-// SortFns sorts functions so that functions with the longest paths come first
+// SortFns sorts functions by their phase (generate, mutate and then validate) and then by their run order; see
+// PhaseAnnotation and RunOrderAnnotation. Functions in the same phase with the same run order are sorted so that
+// functions with the longest paths come first.
 // copied from the kustomize library https://github.com/kubernetes-sigs/kustomize/blob/3ebdb3fcef66580417d18f44ac20572469e41fa5/kyaml/runfn/runfn.go#L337
 func (d *Dispatcher) SortFns(buff kio.PackageBuffer) error {
+	orders, err := functionOrders(buff.Nodes)
+	if err != nil {
+		return err
+	}
+
 	var outerErr error
 	// sort the nodes so that we traverse them depth first
 	// functions deeper in the file system tree should be run first
 	sort.Slice(buff.Nodes, func(i, j int) bool {
+		oi := orders[buff.Nodes[i]]
+		oj := orders[buff.Nodes[j]]
+		if oi != oj {
+			return oi.less(oj)
+		}
+
 		mi, _ := buff.Nodes[i].GetMeta()
 		pi := filepath.ToSlash(mi.Annotations[kioutil.PathAnnotation])
 
@@ -264,8 +328,12 @@ func (d *Dispatcher) constructTargetPath(sourceRoot string, pathAnnotation strin
 		return "", err
 	}
 	if leafPaths[targetPath] {
-		// if the path is a leaf, lets remove the overlay and return that path
-		return filepath.Join(hydratedPath, targetPath.Dir), nil
+		// if the path is a leaf, return the directory it was hydrated into; by default this removes the overlay.
+		dir, err := HydratedDir(d.Layout, sourceRoot, filepath.Join(sourceRoot, pathAnnotation))
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(hydratedPath, dir), nil
 	}
 	// the path is not a leaf so we dont remove the dir
 	return filepath.Join(hydratedPath, filepath.Dir(pathAnnotation)), nil
@@ -322,6 +390,12 @@ func (d *Dispatcher) loadFilters(configs []*yaml.RNode) ([]kio.Filter, error) {
 	tempFns := []kio.Filter{}
 	tempCmFns := []kio.Filter{}
 
+	// N.B. Sort a copy so the order of the caller's configs isn't changed.
+	configs = append([]*yaml.RNode{}, configs...)
+	if err := sortByOrder(configs); err != nil {
+		return nil, err
+	}
+
 	for _, n := range configs {
 		m, err := n.GetMeta()
 		if err != nil {
@@ -330,19 +404,51 @@ func (d *Dispatcher) loadFilters(configs []*yaml.RNode) ([]kio.Filter, error) {
 		}
 
 		fn, ok := dispatchTable[m.Kind]
-		if !ok {
+		spec := externalFunctionSpec(n)
+		if !ok && spec == nil {
 			log.Info("Skipping kind; not a fn", "kind", m.Kind, "name", m.Name)
 			continue
 		}
+		if !d.Kinds.IsAllowed(m.Kind) {
+			log.Info("Skipping kind; fn isn't allowed to run", "kind", m.Kind, "name", m.Name)
+			continue
+		}
 		log := log.WithValues("kind", m.Kind, "name", m.Name, "config_path", m.Annotations[kioutil.PathAnnotation])
-		fltr := fn()
 
-		err = n.YNode().Decode(fltr)
+		var fltr kio.Filter
+		if ok {
+			fltr = fn()
+			err = n.YNode().Decode(fltr)
+			if err != nil {
+				log.Error(err, "Failed to decode fn")
+				return nil, err
+			}
+		} else {
+			fltr, err = d.externalFilter(n, spec)
+			if err != nil {
+				log.Error(err, "Failed to create external fn")
+				return nil, err
+			}
+			if fltr == nil {
+				log.Info("Skipping external fn; it isn't in the allowlist", "image", spec.Container.Image, "exec", spec.Exec.Path)
+				continue
+			}
+		}
 
+		timeout, err := d.functionTimeout(m.Annotations)
 		if err != nil {
-			log.Error(err, "Failed to decode fn")
+			log.Error(err, "Failed to get timeout for fn")
 			return nil, err
 		}
+		if timeout > 0 {
+			fltr = &timeoutFilter{filter: fltr, kind: m.Kind, name: m.Name, timeout: timeout}
+		}
+		if d.Report != nil && isValidating(n) {
+			fltr = &validatingFilter{filter: fltr, report: d.Report, kind: m.Kind, name: m.Name}
+		}
+		if d.Report != nil {
+			fltr = &reportingFilter{filter: fltr, report: d.Report, kind: m.Kind, name: m.Name, path: m.Annotations[kioutil.PathAnnotation]}
+		}
 
 		if val, ok := m.Annotations[configmap.ConfigMapAnnotation]; ok {
 			val = strings.TrimSpace(strings.ToLower(val))
@@ -16,6 +16,7 @@ import (
 	"github.com/jlewi/hydros/api/v1alpha1"
 	"github.com/jlewi/hydros/pkg/kustomize/fns/configmap"
 	"github.com/jlewi/hydros/pkg/kustomize/fns/envs"
+	"github.com/jlewi/hydros/pkg/kustomize/fns/labels"
 
 	"sigs.k8s.io/kustomize/kyaml/kio"
 
@@ -278,6 +279,16 @@ func Test_getAllFuncs(t *testing.T) {
 	if expectedFunctions != len(kioBuff.Nodes) {
 		t.Errorf("unexpected diff; expected number of functions %v; actual number of functions %v", expectedFunctions, len(kioBuff.Nodes))
 	}
+
+	// Functions whose kind isn't allowed should be skipped.
+	d.Kinds = &v1alpha1.FunctionKinds{Deny: []string{labels.Kind}}
+	kioBuff, err = d.GetAllFuncs([]string{sourceRepo})
+	if err != nil {
+		t.Fatalf("GetAllFuncs failed; error %v", err)
+	}
+	if len(kioBuff.Nodes) != 0 {
+		t.Errorf("Expected denied functions to be skipped; got %v functions", len(kioBuff.Nodes))
+	}
 }
 
 func Test_SetFuncPaths(t *testing.T) {
@@ -395,3 +406,55 @@ func Test_constructTargetPaths(t *testing.T) {
 		}
 	}
 }
+
+func Test_HydratedDir(t *testing.T) {
+	type testCase struct {
+		name     string
+		layout   *v1alpha1.DestLayout
+		expected string
+	}
+
+	sourceRoot := "/tmp/manifests"
+	kustomization := "/tmp/manifests/backends/somebackend/dev/kustomization.yaml"
+
+	tests := []testCase{
+		{
+			name:     "default",
+			layout:   nil,
+			expected: "backends/somebackend",
+		},
+		{
+			name:     "keep-overlay",
+			layout:   &v1alpha1.DestLayout{Type: v1alpha1.KeepOverlayDestLayout},
+			expected: "backends/somebackend/dev",
+		},
+		{
+			name:     "flatten",
+			layout:   &v1alpha1.DestLayout{Type: v1alpha1.FlattenDestLayout},
+			expected: "",
+		},
+		{
+			name:     "template",
+			layout:   &v1alpha1.DestLayout{Type: v1alpha1.TemplateDestLayout, Template: "{{.Overlay}}/{{.Dir}}"},
+			expected: "dev/backends/somebackend",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := HydratedDir(tc.layout, sourceRoot, kustomization)
+			if err != nil {
+				t.Fatalf("HydratedDir failed; error %v", err)
+			}
+			if actual != tc.expected {
+				t.Errorf("Got %v; want %v", actual, tc.expected)
+			}
+		})
+	}
+
+	// Templates can't generate paths outside the dest path.
+	layout := &v1alpha1.DestLayout{Type: v1alpha1.TemplateDestLayout, Template: "../{{.Path}}"}
+	if _, err := HydratedDir(layout, sourceRoot, kustomization); err == nil {
+		t.Errorf("Expected an error for a template generating a path outside the dest path")
+	}
+}
@@ -1,10 +1,15 @@
 package kustomize
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"text/template"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -49,3 +54,51 @@ type TargetPath struct {
 	Dir         string
 	OverlayName string
 }
+
+// HydratedDir returns the directory, relative to the dest path, that the kustomization should be hydrated into
+// according to layout. A nil layout strips the overlay directory i.e. it returns the Dir of GenerateTargetPath.
+func HydratedDir(layout *v1alpha1.DestLayout, sourceBase string, kustomization string) (string, error) {
+	targetPath, err := GenerateTargetPath(sourceBase, kustomization)
+	if err != nil {
+		return "", err
+	}
+
+	rPath, err := filepath.Rel(sourceBase, filepath.Dir(kustomization))
+	if err != nil {
+		return "", err
+	}
+
+	switch layout.GetType() {
+	case v1alpha1.StripOverlayDestLayout:
+		return targetPath.Dir, nil
+	case v1alpha1.KeepOverlayDestLayout:
+		return rPath, nil
+	case v1alpha1.FlattenDestLayout:
+		return "", nil
+	case v1alpha1.TemplateDestLayout:
+	default:
+		return "", errors.Errorf("Unknown dest layout %v", layout.Type)
+	}
+
+	tmpl, err := template.New("destLayout").Option("missingkey=error").Parse(layout.Template)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to parse dest layout template %v", layout.Template)
+	}
+	var b bytes.Buffer
+	vars := map[string]string{
+		"Path":    rPath,
+		"Dir":     targetPath.Dir,
+		"Overlay": targetPath.OverlayName,
+	}
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", errors.Wrapf(err, "Failed to execute dest layout template %v for kustomization %v", layout.Template, kustomization)
+	}
+	dir := filepath.Clean(b.String())
+	if filepath.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, ".."+string(os.PathSeparator)) {
+		return "", errors.Errorf("Dest layout template %v generated %v for kustomization %v; it must be a path inside the dest path", layout.Template, dir, kustomization)
+	}
+	if dir == "." {
+		return "", nil
+	}
+	return dir, nil
+}
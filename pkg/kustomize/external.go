@@ -0,0 +1,81 @@
+package kustomize
+
+import (
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/fn/runtime/container"
+	"sigs.k8s.io/kustomize/kyaml/fn/runtime/exec"
+	"sigs.k8s.io/kustomize/kyaml/fn/runtime/runtimeutil"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// containerUser is the user containerized functions run as. This matches the default of kustomize and kpt.
+const containerUser = "nobody"
+
+// externalFunctionSpec returns the spec of the external function configured by node or nil if node isn't the
+// config of an external function. External functions are specified with the config.kubernetes.io/function
+// annotation e.g.
+//
+//	metadata:
+//	  annotations:
+//	    config.kubernetes.io/function: |
+//	      container:
+//	        image: gcr.io/kpt-fn/set-labels:v0.1
+//
+// Only container and exec functions are supported; starlark programs should use the ScriptedFn function.
+func externalFunctionSpec(node *yaml.RNode) *runtimeutil.FunctionSpec {
+	spec := runtimeutil.GetFunctionSpec(node)
+	if spec == nil || (spec.Container.Image == "" && spec.Exec.Path == "") {
+		return nil
+	}
+	return spec
+}
+
+// externalFilter returns the filter to run the external function configured by node. It returns nil if the
+// function isn't selected by External or isn't allowed to run by the allowlist in the hydros config.
+func (d *Dispatcher) externalFilter(node *yaml.RNode, spec *runtimeutil.FunctionSpec) (kio.Filter, error) {
+	if spec.Container.Image != "" {
+		if !d.External.IsImageAllowed(spec.Container.Image) || !d.Allowed.IsImageAllowed(spec.Container.Image) {
+			return nil, nil
+		}
+		// N.B. Mounts are disallowed because they would give the function access to the filesystem of hydros.
+		if len(spec.Container.StorageMounts) > 0 {
+			return nil, errors.Errorf("Function %v requests mounts; mounts aren't supported for container functions", spec.Container.Image)
+		}
+		if spec.Container.Network && !(d.External.Network && d.Allowed.Network) {
+			return nil, errors.Errorf("Function %v requests network access; network access isn't allowed", spec.Container.Image)
+		}
+		fltr := container.NewContainer(spec.Container, containerUser)
+		fltr.Exec.FunctionConfig = node
+		// N.B. The dispatcher already scopes functions to the resources in their target directory.
+		fltr.Exec.GlobalScope = true
+		return &fltr, nil
+	}
+
+	if !d.External.IsExecAllowed(spec.Exec.Path) {
+		return nil, nil
+	}
+	// N.B. Relative paths are resolved against the functions directory in the hydros config; never against the
+	// source repository or the working directory of hydros.
+	execPath, err := d.Allowed.ResolveExec(spec.Exec.Path)
+	if err != nil {
+		return nil, err
+	}
+	if execPath == "" {
+		return nil, nil
+	}
+	// The function runs in the directory containing its config.
+	workingDir := node.GetAnnotations()[SourceFunctionPath]
+	if !filepath.IsAbs(workingDir) {
+		workingDir = filepath.Dir(execPath)
+	}
+	fltr := &exec.Filter{
+		Path:       execPath,
+		WorkingDir: workingDir,
+	}
+	fltr.FunctionConfig = node
+	fltr.GlobalScope = true
+	return fltr, nil
+}
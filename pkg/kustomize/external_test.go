@@ -0,0 +1,179 @@
+package kustomize
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/config"
+	"github.com/jlewi/hydros/pkg/util"
+)
+
+const externalDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 3
+`
+
+func Test_ExternalFunctions(t *testing.T) {
+	log := util.SetupLogger("info", true)
+
+	type testCase struct {
+		name     string
+		function string
+		external *v1alpha1.ExternalFunctions
+		allowed  *config.FunctionsConfig
+		expected string
+		wantErr  bool
+	}
+
+	// The script stands in for a function from the kpt catalog; it reads the ResourceList on stdin and writes the
+	// modified ResourceList to stdout.
+	script := filepath.Join(t.TempDir(), "set-replicas.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nsed 's/replicas: 3/replicas: 1/'\n"), 0o755); err != nil {
+		t.Fatalf("Failed to write script; %v", err)
+	}
+
+	scriptDir := filepath.Dir(script)
+	execFn := func(path string) string {
+		return `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: set-replicas
+  annotations:
+    config.kubernetes.io/function: |
+      exec:
+        path: ` + path + `
+`
+	}
+	containerFn := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: set-labels
+  annotations:
+    config.kubernetes.io/function: |
+      container:
+        image: gcr.io/kpt-fn/set-labels:v0.1
+        network: true
+`
+
+	cases := []testCase{
+		{
+			name:     "allowed",
+			function: execFn(script),
+			external: &v1alpha1.ExternalFunctions{Execs: []string{scriptDir + "/*"}},
+			allowed:  &config.FunctionsConfig{Execs: []string{scriptDir + "/*"}},
+			expected: "replicas: 1",
+		},
+		{
+			name:     "not-allowed",
+			function: execFn(script),
+			external: &v1alpha1.ExternalFunctions{Execs: []string{"/usr/local/bin/*"}},
+			allowed:  &config.FunctionsConfig{Execs: []string{scriptDir + "/*"}},
+			expected: "replicas: 3",
+		},
+		{
+			name:     "nil-allowlist",
+			function: execFn(script),
+			allowed:  &config.FunctionsConfig{Execs: []string{scriptDir + "/*"}},
+			expected: "replicas: 3",
+		},
+		{
+			// Entries supplied by the repository that the hydros config doesn't allow are ignored.
+			name:     "repo-allowlist-ignored",
+			function: execFn(script),
+			external: &v1alpha1.ExternalFunctions{Execs: []string{scriptDir + "/*"}},
+			allowed:  &config.FunctionsConfig{Execs: []string{"/usr/local/bin/*"}},
+			expected: "replicas: 3",
+		},
+		{
+			name:     "nil-config",
+			function: execFn(script),
+			external: &v1alpha1.ExternalFunctions{Execs: []string{scriptDir + "/*"}},
+			expected: "replicas: 3",
+		},
+		{
+			name:     "relative-path-without-dir",
+			function: execFn("set-replicas.sh"),
+			external: &v1alpha1.ExternalFunctions{Execs: []string{"*"}},
+			allowed:  &config.FunctionsConfig{Execs: []string{scriptDir + "/*"}},
+			wantErr:  true,
+		},
+		{
+			name:     "relative-path",
+			function: execFn("set-replicas.sh"),
+			external: &v1alpha1.ExternalFunctions{Execs: []string{"*"}},
+			allowed:  &config.FunctionsConfig{Execs: []string{scriptDir + "/*"}, Dir: scriptDir},
+			expected: "replicas: 1",
+		},
+		{
+			name:     "relative-path-outside-dir",
+			function: execFn("../" + filepath.Base(scriptDir) + "/set-replicas.sh"),
+			external: &v1alpha1.ExternalFunctions{Execs: []string{"../*/*"}},
+			allowed:  &config.FunctionsConfig{Execs: []string{"*"}, Dir: filepath.Join(scriptDir, "functions")},
+			wantErr:  true,
+		},
+		{
+			name:     "network",
+			function: containerFn,
+			external: &v1alpha1.ExternalFunctions{Images: []string{"gcr.io/kpt-fn/*"}},
+			allowed:  &config.FunctionsConfig{Images: []string{"gcr.io/kpt-fn/*"}, Network: true},
+			wantErr:  true,
+		},
+		{
+			// Network access allowed by the repository but not the hydros config is ignored.
+			name:     "network-not-allowed-by-config",
+			function: containerFn,
+			external: &v1alpha1.ExternalFunctions{Images: []string{"gcr.io/kpt-fn/*"}, Network: true},
+			allowed:  &config.FunctionsConfig{Images: []string{"gcr.io/kpt-fn/*"}},
+			wantErr:  true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			targetDir := filepath.Join(dir, "target")
+			fnDir := filepath.Join(dir, "functions")
+			for p, contents := range map[string]string{
+				filepath.Join(targetDir, "deployment.yaml"): externalDeployment,
+				filepath.Join(fnDir, "fn.yaml"):             c.function,
+			} {
+				if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+					t.Fatalf("Failed to create directory; %v", err)
+				}
+				if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+					t.Fatalf("Failed to write %v; %v", p, err)
+				}
+			}
+
+			d := Dispatcher{
+				Log:      log,
+				External: c.external,
+				Allowed:  c.allowed,
+			}
+			err := d.RunOnDir(targetDir, []string{fnDir})
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RunOnDir failed; %+v", err)
+			}
+
+			b, err := os.ReadFile(filepath.Join(targetDir, "deployment.yaml"))
+			if err != nil {
+				t.Fatalf("Failed to read deployment; %v", err)
+			}
+			if !strings.Contains(string(b), c.expected) {
+				t.Errorf("Expected deployment to contain %q; got:\n%v", c.expected, string(b))
+			}
+		})
+	}
+}
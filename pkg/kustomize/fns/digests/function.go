@@ -0,0 +1,191 @@
+package digests
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/kustomize/fns/images"
+	"github.com/jlewi/hydros/pkg/kustomize/fns/validation"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/api/filters/fsslice"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/resid"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// Kind is the kind for the kustomize function.
+	Kind = "ImageDigestPolicy"
+
+	// FailAction fails the hydration if any image is referenced by a mutable tag.
+	FailAction = "fail"
+	// PinAction replaces images referenced by mutable tags with the images they are pinned to.
+	PinAction = "pin"
+)
+
+// podFsSlice are the image fields of bare Pods. They aren't in images.DefaultFsSlice because the ImagePrefix
+// function only handles workloads.
+var podFsSlice = []types.FieldSpec{
+	{
+		Gvk:  resid.Gvk{Kind: "Pod"},
+		Path: "spec/containers[]/image",
+	},
+	{
+		Gvk:  resid.Gvk{Kind: "Pod"},
+		Path: "spec/initContainers[]/image",
+	},
+	{
+		Path: "spec/jobTemplate/spec/template/spec/initContainers[]/image",
+	},
+}
+
+var _ kio.Filter = &ImageDigestPolicyFunction{}
+
+// Filter returns a new ImageDigestPolicyFunction
+func Filter() kio.Filter {
+	return &ImageDigestPolicyFunction{}
+}
+
+// ImageDigestPolicyFunction implements the ImageDigestPolicy Function. It checks that every image in the
+// resources is referenced by digest rather than by a mutable tag. Images pinned in kustomizations are already
+// referenced by digest; this catches images embedded in the resources themselves e.g. in raw manifests or helm
+// charts.
+//
+// If the action is fail, the default, hydration fails if any image is referenced by a mutable tag. If the action
+// is pin, images referenced by mutable tags are replaced with the images they are pinned to in Pins; hydration
+// fails if there are images that aren't pinned.
+//
+// Example
+//
+//	apiVersion: hydros.dev/v1alpha1
+//	kind: ImageDigestPolicy
+//	metadata:
+//	  name: digests
+//	spec:
+//	  action: pin
+//	  pins:
+//	    docker.io/library/redis:7: docker.io/library/redis:7@sha256:1234
+//	  ignore:
+//	  - gcr.io/acme/dev/
+type ImageDigestPolicyFunction struct {
+	// Kind is the API name.  Must be ImageDigestPolicy.
+	Kind string `yaml:"kind"`
+
+	// APIVersion is the API version.
+	APIVersion string `yaml:"apiVersion"`
+
+	// Metadata defines instance metadata.
+	Metadata v1alpha1.Metadata `yaml:"metadata"`
+
+	// Spec defines the desired declarative configuration.
+	Spec Spec `yaml:"spec"`
+}
+
+// Spec is the spec for the kustomize function.
+type Spec struct {
+	// Action is what to do with images referenced by mutable tags; fail or pin. Defaults to fail.
+	Action string `yaml:"action,omitempty"`
+
+	// Pins maps images referenced by mutable tags to the images, referenced by digest, to replace them with.
+	// It is only used when the action is pin.
+	Pins map[string]string `yaml:"pins,omitempty"`
+
+	// Ignore is a list of image prefixes e.g. gcr.io/acme/dev/ whose images may be referenced by mutable tags.
+	Ignore []string `yaml:"ignore,omitempty"`
+
+	// FsSlice contains additional FieldSpecs to locate an image field,
+	// e.g. Path: "spec/myContainers[]/image"
+	FsSlice types.FsSlice `json:"fieldSpecs,omitempty" yaml:"fieldSpecs,omitempty"`
+}
+
+func (f *ImageDigestPolicyFunction) init() error {
+	if f.Metadata.Name == "" {
+		return fmt.Errorf("must specify ImageDigestPolicy name")
+	}
+
+	switch f.Spec.Action {
+	case "":
+		f.Spec.Action = FailAction
+	case FailAction, PinAction:
+	default:
+		return errors.Errorf("ImageDigestPolicy %v: action %v is invalid; it must be %v or %v", f.Metadata.Name, f.Spec.Action, FailAction, PinAction)
+	}
+
+	for image, pinned := range f.Spec.Pins {
+		if !hasDigest(pinned) {
+			return errors.Errorf("ImageDigestPolicy %v: image %v is pinned to %v which isn't referenced by digest", f.Metadata.Name, image, pinned)
+		}
+	}
+
+	fsSlice := types.FsSlice{}
+	fsSlice = append(fsSlice, images.DefaultFsSlice...)
+	fsSlice = append(fsSlice, podFsSlice...)
+	f.Spec.FsSlice = append(fsSlice, f.Spec.FsSlice...)
+	return nil
+}
+
+// Filter checks the images of the provided RNodes and pins them if the action is pin.
+func (f ImageDigestPolicyFunction) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	if err := f.init(); err != nil {
+		return nil, err
+	}
+
+	message := "referenced by a mutable tag"
+	if f.Spec.Action == PinAction {
+		message = "referenced by a mutable tag and isn't pinned"
+	}
+	violations := []validation.Violation{}
+	for _, n := range nodes {
+		if n.GetKind() == "CustomResourceDefinition" {
+			continue
+		}
+		resource := n.GetKind() + "/" + n.GetName()
+		if err := n.PipeE(fsslice.Filter{
+			FsSlice: f.Spec.FsSlice,
+			SetValue: func(rn *yaml.RNode) error {
+				if err := yaml.ErrorIfInvalid(rn, yaml.ScalarNode); err != nil {
+					return err
+				}
+				image := rn.YNode().Value
+				if hasDigest(image) || f.isIgnored(image) {
+					return nil
+				}
+				if f.Spec.Action == PinAction {
+					if pinned, ok := f.Spec.Pins[image]; ok {
+						return rn.PipeE(yaml.FieldSetter{StringValue: pinned})
+					}
+				}
+				violations = append(violations, validation.NewViolation(n, "", image+" is "+message))
+				return nil
+			},
+		}); err != nil {
+			return nil, errors.Wrapf(err, "Failed to check the images of %v", resource)
+		}
+	}
+
+	if len(violations) == 0 {
+		return nodes, nil
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].String() < violations[j].String()
+	})
+	return nil, &validation.Error{Function: Kind + "/" + f.Metadata.Name, Violations: violations}
+}
+
+// isIgnored returns true if the image may be referenced by a mutable tag.
+func (f ImageDigestPolicyFunction) isIgnored(image string) bool {
+	for _, prefix := range f.Spec.Ignore {
+		if strings.HasPrefix(image, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDigest returns true if the image is referenced by digest e.g. redis:7@sha256:1234.
+func hasDigest(image string) bool {
+	return strings.Contains(image, "@")
+}
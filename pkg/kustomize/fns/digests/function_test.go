@@ -0,0 +1,184 @@
+package digests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	filtertest "sigs.k8s.io/kustomize/api/testutils/filtertest"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/filters"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const input = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: migrate
+        image: gcr.io/acme/migrate@sha256:1234
+      containers:
+      - name: web
+        image: gcr.io/acme/web:v1@sha256:5678
+      - name: cache
+        image: docker.io/library/redis:7
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: debug
+spec:
+  containers:
+  - name: debug
+    image: gcr.io/acme/dev/debug:latest
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+  - port: 80
+`
+
+func Test_ImageDigestPolicy(t *testing.T) {
+	testCases := map[string]struct {
+		input          string
+		expectedOutput string
+		filter         ImageDigestPolicyFunction
+	}{
+		"pin": {
+			input: input,
+			expectedOutput: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: migrate
+        image: gcr.io/acme/migrate@sha256:1234
+      containers:
+      - name: web
+        image: gcr.io/acme/web:v1@sha256:5678
+      - name: cache
+        image: docker.io/library/redis:7@sha256:abcd
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: debug
+spec:
+  containers:
+  - name: debug
+    image: gcr.io/acme/dev/debug:latest
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+  - port: 80
+`,
+			filter: ImageDigestPolicyFunction{
+				Metadata: v1alpha1.Metadata{Name: "pin"},
+				Spec: Spec{
+					Action: PinAction,
+					Pins:   map[string]string{"docker.io/library/redis:7": "docker.io/library/redis:7@sha256:abcd"},
+					Ignore: []string{"gcr.io/acme/dev/"},
+				},
+			},
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			if !assert.Equal(t,
+				strings.TrimSpace(filtertest.RunFilter(t, tc.expectedOutput, filters.FormatFilter{})),
+				strings.TrimSpace(filtertest.RunFilter(t, tc.input, &pipeline{f: tc.filter}))) {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func Test_ImageDigestPolicyViolations(t *testing.T) {
+	testCases := map[string]struct {
+		filter   ImageDigestPolicyFunction
+		expected []string
+	}{
+		"fail": {
+			filter: ImageDigestPolicyFunction{
+				Metadata: v1alpha1.Metadata{Name: "fail"},
+				Spec: Spec{
+					Pins: map[string]string{"docker.io/library/redis:7": "docker.io/library/redis:7@sha256:abcd"},
+				},
+			},
+			expected: []string{"Deployment/web: docker.io/library/redis:7", "Pod/debug: gcr.io/acme/dev/debug:latest"},
+		},
+		"unpinned": {
+			filter: ImageDigestPolicyFunction{
+				Metadata: v1alpha1.Metadata{Name: "unpinned"},
+				Spec: Spec{
+					Action: PinAction,
+					Ignore: []string{"gcr.io/acme/dev/"},
+				},
+			},
+			expected: []string{"Deployment/web: docker.io/library/redis:7"},
+		},
+		"invalid-pin": {
+			filter: ImageDigestPolicyFunction{
+				Metadata: v1alpha1.Metadata{Name: "invalid"},
+				Spec: Spec{
+					Action: PinAction,
+					Pins:   map[string]string{"docker.io/library/redis:7": "docker.io/library/redis:7.2"},
+				},
+			},
+			expected: []string{"docker.io/library/redis:7.2"},
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			nodes, err := (&kio.ByteReader{Reader: strings.NewReader(input)}).Read()
+			if err != nil {
+				t.Fatalf("Failed to read input; %v", err)
+			}
+			_, err = tc.filter.Filter(nodes)
+			if err == nil {
+				t.Fatalf("Expected an error")
+			}
+			for _, e := range tc.expected {
+				if !strings.Contains(err.Error(), e) {
+					t.Errorf("Expected error to contain %v; got %v", e, err)
+				}
+			}
+		})
+	}
+}
+
+// n.b. we need to run formatfilter on the actual output as the formatfilter causes the keys in
+// dictionaries to be sorted which is necessary otherwise output will be random
+type pipeline struct {
+	f ImageDigestPolicyFunction
+}
+
+func (p pipeline) Filter(inputs []*yaml.RNode) ([]*yaml.RNode, error) {
+	outputs, err := p.f.Filter(inputs)
+	if err != nil {
+		return inputs, err
+	}
+
+	formatter := &filters.FormatFilter{}
+
+	return formatter.Filter(outputs)
+}
@@ -0,0 +1,208 @@
+package naming
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/api/filters/fsslice"
+	"sigs.k8s.io/kustomize/api/filters/namespace"
+	"sigs.k8s.io/kustomize/api/konfig/builtinpluginconsts"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/resid"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// Kind is the kind for the kustomize function.
+	Kind = "Naming"
+)
+
+// skipRename are the kinds whose names aren't prefixed or suffixed. This matches kustomize.
+var skipRename = map[string]bool{
+	"CustomResourceDefinition": true,
+	"APIService":               true,
+	"Namespace":                true,
+}
+
+var _ kio.Filter = &NamingFunction{}
+
+// Filter returns a new NamingFunction
+func Filter() kio.Filter {
+	return &NamingFunction{}
+}
+
+// NamingFunction implements the Naming Function. It sets the namespace of resources and adds a prefix and/or suffix
+// to their names. References to the renamed resources e.g. the Services of an Ingress or the ConfigMaps and Secrets
+// mounted by Pods are updated to the new names. This provides the namespace, namePrefix and nameSuffix fields of a
+// kustomization without having to run kustomize.
+//
+// The fields that set namespaces and reference other resources are the defaults used by kustomize. References are
+// matched by kind and name; the resource being referenced has to be one of the resources the function is applied to.
+//
+// Example
+//
+//	apiVersion: hydros.dev/v1alpha1
+//	kind: Naming
+//	metadata:
+//	  name: staging
+//	spec:
+//	  namespace: staging
+//	  namePrefix: staging-
+type NamingFunction struct {
+	// Kind is the API name.  Must be Naming.
+	Kind string `yaml:"kind"`
+
+	// APIVersion is the API version.
+	APIVersion string `yaml:"apiVersion"`
+
+	// Metadata defines instance metadata.
+	Metadata v1alpha1.Metadata `yaml:"metadata"`
+
+	// Spec defines the desired declarative configuration.
+	Spec Spec `yaml:"spec"`
+}
+
+// Spec is the spec for the kustomize function.
+type Spec struct {
+	// Namespace is the namespace to set on all namespaced resources.
+	Namespace string `yaml:"namespace,omitempty"`
+	// NamePrefix is prepended to the names of the resources.
+	NamePrefix string `yaml:"namePrefix,omitempty"`
+	// NameSuffix is appended to the names of the resources.
+	NameSuffix string `yaml:"nameSuffix,omitempty"`
+}
+
+// nameReference is the fields referring to resources of a kind in kustomize's nameReference configuration.
+type nameReference struct {
+	resid.Gvk  `yaml:",inline"`
+	FieldSpecs types.FsSlice `yaml:"fieldSpecs"`
+}
+
+// fieldSpecs are kustomize's default configurations of the fields to transform.
+type fieldSpecs struct {
+	NameReference []nameReference `yaml:"nameReference"`
+	Namespace     types.FsSlice   `yaml:"namespace"`
+}
+
+var (
+	defaultsOnce sync.Once
+	defaults     fieldSpecs
+	defaultsErr  error
+)
+
+// loadDefaults parses kustomize's default field specs.
+func loadDefaults() (fieldSpecs, error) {
+	defaultsOnce.Do(func() {
+		configs := builtinpluginconsts.GetDefaultFieldSpecsAsMap()
+		for _, key := range []string{"namereference", "namespace"} {
+			if err := yaml.Unmarshal([]byte(configs[key]), &defaults); err != nil {
+				defaultsErr = errors.Wrapf(err, "Failed to parse the default %v field specs", key)
+				return
+			}
+		}
+	})
+	return defaults, defaultsErr
+}
+
+func (f *NamingFunction) init() error {
+	if f.Metadata.Name == "" {
+		return fmt.Errorf("must specify Naming name")
+	}
+	if f.Spec.Namespace == "" && f.Spec.NamePrefix == "" && f.Spec.NameSuffix == "" {
+		return errors.Errorf("Naming %v: at least one of namespace, namePrefix and nameSuffix must be set", f.Metadata.Name)
+	}
+	return nil
+}
+
+// Filter sets the namespaces and names of the provided RNodes.
+func (f NamingFunction) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	if err := f.init(); err != nil {
+		return nil, err
+	}
+	config, err := loadDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Spec.NamePrefix != "" || f.Spec.NameSuffix != "" {
+		// renames maps the kind and the old name of renamed resources to their new names.
+		renames := map[string]map[string]string{}
+		for _, n := range nodes {
+			kind := n.GetKind()
+			if skipRename[kind] {
+				continue
+			}
+			oldName := n.GetName()
+			newName := f.Spec.NamePrefix + oldName + f.Spec.NameSuffix
+			if err := n.SetName(newName); err != nil {
+				return nil, errors.Wrapf(err, "Failed to rename %v %v", kind, oldName)
+			}
+			if renames[kind] == nil {
+				renames[kind] = map[string]string{}
+			}
+			renames[kind][oldName] = newName
+		}
+
+		for _, ref := range config.NameReference {
+			names := renames[ref.Kind]
+			if len(names) == 0 {
+				continue
+			}
+			r := &referenceRenamer{kind: ref.Kind, names: names}
+			if _, err := kio.FilterAll(fsslice.Filter{FsSlice: ref.FieldSpecs, SetValue: r.rename}).Filter(nodes); err != nil {
+				return nil, errors.Wrapf(err, "Failed to update references to %v", ref.Kind)
+			}
+		}
+	}
+
+	if f.Spec.Namespace != "" {
+		nsFilter := namespace.Filter{
+			Namespace: f.Spec.Namespace,
+			FsSlice:   config.Namespace,
+		}
+		if _, err := nsFilter.Filter(nodes); err != nil {
+			return nil, errors.Wrapf(err, "Failed to set namespace")
+		}
+	}
+	return nodes, nil
+}
+
+// referenceRenamer updates references to resources of kind that were renamed.
+type referenceRenamer struct {
+	kind  string
+	names map[string]string
+}
+
+// rename updates the reference in node. node is either the name, a list of names or an object reference with
+// kind and name fields e.g. the subjects of RoleBindings.
+func (r *referenceRenamer) rename(node *yaml.RNode) error {
+	switch node.YNode().Kind {
+	case yaml.ScalarNode:
+		if newName, ok := r.names[node.YNode().Value]; ok {
+			node.YNode().Value = newName
+		}
+	case yaml.SequenceNode:
+		elements, err := node.Elements()
+		if err != nil {
+			return err
+		}
+		for _, e := range elements {
+			if err := r.rename(e); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		if kind, _ := node.GetString("kind"); kind != "" && kind != r.kind {
+			return nil
+		}
+		name := node.Field("name")
+		if name == nil {
+			return nil
+		}
+		return r.rename(name.Value)
+	}
+	return nil
+}
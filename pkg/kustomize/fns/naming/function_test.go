@@ -0,0 +1,250 @@
+package naming
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	filtertest "sigs.k8s.io/kustomize/api/testutils/filtertest"
+	"sigs.k8s.io/kustomize/kyaml/kio/filters"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func Test_Naming(t *testing.T) {
+	testCases := map[string]struct {
+		input          string
+		expectedOutput string
+		filter         NamingFunction
+	}{
+		"prefix-suffix-namespace": {
+			input: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: web
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: default
+spec:
+  template:
+    spec:
+      serviceAccountName: web
+      containers:
+      - name: web
+        env:
+        - name: MODE
+          valueFrom:
+            configMapKeyRef:
+              name: config
+              key: mode
+        - name: PASSWORD
+          valueFrom:
+            secretKeyRef:
+              name: external
+              key: password
+      volumes:
+      - name: config
+        configMap:
+          name: config
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+---
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  rules:
+  - http:
+      paths:
+      - path: /
+        backend:
+          service:
+            name: web
+            port:
+              number: 80
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: reader
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: web
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: reader
+subjects:
+- kind: ServiceAccount
+  name: web
+- kind: User
+  name: web
+`,
+			expectedOutput: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: staging-config-v1
+  namespace: staging
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: staging-web-v1
+  namespace: staging
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: staging-web-v1
+  namespace: staging
+spec:
+  template:
+    spec:
+      serviceAccountName: staging-web-v1
+      containers:
+      - name: web
+        env:
+        - name: MODE
+          valueFrom:
+            configMapKeyRef:
+              name: staging-config-v1
+              key: mode
+        - name: PASSWORD
+          valueFrom:
+            secretKeyRef:
+              name: external
+              key: password
+      volumes:
+      - name: config
+        configMap:
+          name: staging-config-v1
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: staging-web-v1
+  namespace: staging
+---
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: staging-web-v1
+  namespace: staging
+spec:
+  rules:
+  - http:
+      paths:
+      - path: /
+        backend:
+          service:
+            name: staging-web-v1
+            port:
+              number: 80
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: staging-reader-v1
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: staging-web-v1
+  namespace: staging
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: staging-reader-v1
+subjects:
+- kind: ServiceAccount
+  name: staging-web-v1
+- kind: User
+  name: web
+`,
+			filter: NamingFunction{
+				Metadata: v1alpha1.Metadata{Name: "staging"},
+				Spec:     Spec{Namespace: "staging", NamePrefix: "staging-", NameSuffix: "-v1"},
+			},
+		},
+		"namespace-only": {
+			input: `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: dev
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: dev
+`,
+			expectedOutput: `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: prod
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: prod
+`,
+			filter: NamingFunction{
+				Metadata: v1alpha1.Metadata{Name: "prod"},
+				Spec:     Spec{Namespace: "prod"},
+			},
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			if !assert.Equal(t,
+				strings.TrimSpace(filtertest.RunFilter(t, tc.expectedOutput, filters.FormatFilter{})),
+				strings.TrimSpace(filtertest.RunFilter(t, tc.input, &pipeline{f: tc.filter}))) {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func Test_NamingRequiresChanges(t *testing.T) {
+	f := NamingFunction{Metadata: v1alpha1.Metadata{Name: "empty"}}
+	if _, err := f.Filter([]*yaml.RNode{}); err == nil {
+		t.Errorf("Expected an error")
+	}
+}
+
+// n.b. we need to run formatfilter on the actual output as the formatfilter causes the keys in
+// dictionaries to be sorted which is necessary otherwise output will be random
+type pipeline struct {
+	f NamingFunction
+}
+
+func (p pipeline) Filter(inputs []*yaml.RNode) ([]*yaml.RNode, error) {
+	outputs, err := p.f.Filter(inputs)
+	if err != nil {
+		return inputs, err
+	}
+
+	formatter := &filters.FormatFilter{}
+
+	return formatter.Filter(outputs)
+}
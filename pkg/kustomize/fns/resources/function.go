@@ -0,0 +1,249 @@
+package resources
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/kustomize/fns/envs"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/kustomize/api/filters/fsslice"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// Kind is the kind for the kustomize function.
+	Kind = "PodResources"
+
+	requestsField = "requests"
+	limitsField   = "limits"
+)
+
+var _ kio.Filter = &PodResourcesFunction{}
+
+// Filter returns a new PodResourcesFunction
+func Filter() kio.Filter {
+	return &PodResourcesFunction{}
+}
+
+// PodResourcesFunction implements the PodResources Function. It sets the resource requests and limits of the
+// containers of Deployments, StatefulSets, DaemonSets, Jobs and CronJobs. This replaces strategic merge patches
+// which need to repeat the kind and name of every resource and the name of every container.
+//
+// Example
+//
+//	apiVersion: hydros.dev/v1alpha1
+//	kind: PodResources
+//	metadata:
+//	  name: resources
+//	spec:
+//	  containers:
+//	  - name: server
+//	    requests:
+//	      cpu: 500m
+//	      memory: 1Gi
+//	    limits:
+//	      memory: 1Gi
+//	  - nameRegex: ".*-sidecar"
+//	    remove:
+//	    - limits.cpu
+type PodResourcesFunction struct {
+	// Kind is the API name.  Must be PodResources.
+	Kind string `yaml:"kind"`
+
+	// APIVersion is the API version.
+	APIVersion string `yaml:"apiVersion"`
+
+	// Metadata defines instance metadata.
+	Metadata v1alpha1.Metadata `yaml:"metadata"`
+
+	// Spec defines the desired declarative configuration.
+	Spec Spec `yaml:"spec"`
+}
+
+// Spec is the spec for the kustomize function.
+type Spec struct {
+	// Containers are the resources to set. They are applied in order so later entries override earlier entries
+	// that match the same container.
+	Containers []ContainerResources `yaml:"containers"`
+}
+
+// ContainerResources are the resources of the containers matching Name or NameRegex. If neither is set the
+// resources apply to all containers.
+type ContainerResources struct {
+	// Name is the name of the container.
+	Name string `yaml:"name,omitempty"`
+	// NameRegex is a regex that must match the entire name of the container.
+	NameRegex string `yaml:"nameRegex,omitempty"`
+
+	// Requests are the requests to set e.g. cpu: 500m.
+	Requests map[string]string `yaml:"requests,omitempty"`
+	// Limits are the limits to set e.g. memory: 1Gi.
+	Limits map[string]string `yaml:"limits,omitempty"`
+	// Remove are the requests and limits to remove e.g. limits.cpu. Use requests or limits to remove all of
+	// them. Removals are done before setting Requests and Limits.
+	Remove []string `yaml:"remove,omitempty"`
+
+	nameRe *regexp.Regexp
+}
+
+func (f *PodResourcesFunction) init() error {
+	if f.Metadata.Name == "" {
+		return fmt.Errorf("must specify PodResources name")
+	}
+
+	for i := range f.Spec.Containers {
+		c := &f.Spec.Containers[i]
+		if c.Name != "" && c.NameRegex != "" {
+			return errors.Errorf("PodResources %v: containers[%d] can't set both name and nameRegex", f.Metadata.Name, i)
+		}
+		if c.NameRegex != "" {
+			re, err := regexp.Compile("^(?:" + c.NameRegex + ")$")
+			if err != nil {
+				return errors.Wrapf(err, "PodResources %v: containers[%d] has an invalid nameRegex %v", f.Metadata.Name, i, c.NameRegex)
+			}
+			c.nameRe = re
+		}
+		for _, field := range []string{limitsField, requestsField} {
+			for name, q := range c.values(field) {
+				if _, err := resource.ParseQuantity(q); err != nil {
+					return errors.Wrapf(err, "PodResources %v: containers[%d] has an invalid quantity %v for %v.%v", f.Metadata.Name, i, q, field, name)
+				}
+			}
+		}
+		for _, r := range c.Remove {
+			field := strings.SplitN(r, ".", 2)[0]
+			if field != requestsField && field != limitsField {
+				return errors.Errorf("PodResources %v: containers[%d] can't remove %v; it must be requests, limits or one of their resources e.g. limits.cpu", f.Metadata.Name, i, r)
+			}
+		}
+	}
+	return nil
+}
+
+// Filter sets the resources of the containers in the provided RNodes.
+func (f PodResourcesFunction) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	if err := f.init(); err != nil {
+		return nil, err
+	}
+	_, err := kio.FilterAll(yaml.FilterFunc(f.filter)).Filter(nodes)
+	return nodes, err
+}
+
+func (f PodResourcesFunction) filter(node *yaml.RNode) (*yaml.RNode, error) {
+	if err := node.PipeE(fsslice.Filter{
+		FsSlice:  envs.DefaultFsSlice,
+		SetValue: f.setContainersResources,
+	}); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// setContainersResources should receive a sequence rnode of container specs
+func (f PodResourcesFunction) setContainersResources(node *yaml.RNode) error {
+	containers, err := node.Elements()
+	// err only if node isn't sequence rnode
+	if err != nil {
+		return err
+	}
+	_, err = kio.FilterAll(yaml.FilterFunc(f.setResources)).Filter(containers)
+	return err
+}
+
+// matches returns true if the resources apply to the container.
+func (c ContainerResources) matches(name string) bool {
+	switch {
+	case c.Name != "":
+		return c.Name == name
+	case c.nameRe != nil:
+		return c.nameRe.MatchString(name)
+	default:
+		return true
+	}
+}
+
+// values returns the requests or limits.
+func (c ContainerResources) values(field string) map[string]string {
+	if field == requestsField {
+		return c.Requests
+	}
+	return c.Limits
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// setResources sets the resources of a container according to the function configuration.
+func (f PodResourcesFunction) setResources(node *yaml.RNode) (*yaml.RNode, error) {
+	name, err := node.GetString("name")
+	if err != nil {
+		return node, errors.Wrapf(err, "Failed to get the name of the container")
+	}
+	for _, c := range f.Spec.Containers {
+		if !c.matches(name) {
+			continue
+		}
+		for _, r := range c.Remove {
+			if err := removeResource(node, r); err != nil {
+				return node, errors.Wrapf(err, "Failed to remove %v from container %v", r, name)
+			}
+		}
+		// Set the fields in a fixed order so that the output is deterministic.
+		for _, field := range []string{limitsField, requestsField} {
+			values := c.values(field)
+			for _, resourceName := range sortedKeys(values) {
+				if err := node.PipeE(
+					yaml.LookupCreate(yaml.MappingNode, "resources", field),
+					yaml.SetField(resourceName, yaml.NewScalarRNode(values[resourceName])),
+				); err != nil {
+					return node, errors.Wrapf(err, "Failed to set %v.%v of container %v", field, resourceName, name)
+				}
+			}
+		}
+	}
+	return node, nil
+}
+
+// removeResource removes the field, e.g. limits.cpu or limits, from the resources of the container. Empty
+// requests, limits and resources are removed.
+func removeResource(node *yaml.RNode, field string) error {
+	resources, err := node.Pipe(yaml.Lookup("resources"))
+	if err != nil || resources == nil {
+		return err
+	}
+	pieces := strings.SplitN(field, ".", 2)
+	if len(pieces) == 1 {
+		if _, err := resources.Pipe(yaml.Clear(pieces[0])); err != nil {
+			return err
+		}
+	} else {
+		values, err := resources.Pipe(yaml.Lookup(pieces[0]))
+		if err != nil || values == nil {
+			return err
+		}
+		if _, err := values.Pipe(yaml.Clear(pieces[1])); err != nil {
+			return err
+		}
+		if len(values.Content()) == 0 {
+			if _, err := resources.Pipe(yaml.Clear(pieces[0])); err != nil {
+				return err
+			}
+		}
+	}
+	if len(resources.Content()) == 0 {
+		_, err := node.Pipe(yaml.Clear("resources"))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package resources
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	filtertest "sigs.k8s.io/kustomize/api/testutils/filtertest"
+	"sigs.k8s.io/kustomize/kyaml/kio/filters"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func Test_PodResources(t *testing.T) {
+	testCases := map[string]struct {
+		input          string
+		expectedOutput string
+		filter         PodResourcesFunction
+	}{
+		"deployment-by-name": {
+			input: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: server
+spec:
+  template:
+    spec:
+      containers:
+      - name: server
+        image: server
+        resources:
+          requests:
+            cpu: 100m
+      - name: proxy
+        image: proxy
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: server
+spec:
+  ports:
+  - port: 80
+`,
+			expectedOutput: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: server
+spec:
+  template:
+    spec:
+      containers:
+      - name: server
+        image: server
+        resources:
+          requests:
+            cpu: 500m
+            memory: 1Gi
+          limits:
+            memory: 1Gi
+      - name: proxy
+        image: proxy
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: server
+spec:
+  ports:
+  - port: 80
+`,
+			filter: PodResourcesFunction{
+				Metadata: v1alpha1.Metadata{Name: "resources"},
+				Spec: Spec{
+					Containers: []ContainerResources{
+						{
+							Name:     "server",
+							Requests: map[string]string{"cpu": "500m", "memory": "1Gi"},
+							Limits:   map[string]string{"memory": "1Gi"},
+						},
+					},
+				},
+			},
+		},
+		"cronjob-regex-and-remove": {
+			input: `
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          initContainers:
+          - name: init-sidecar
+            resources:
+              limits:
+                cpu: "1"
+          containers:
+          - name: backup
+            resources:
+              limits:
+                cpu: "2"
+                memory: 2Gi
+          - name: log-sidecar
+            resources:
+              limits:
+                cpu: "1"
+`,
+			expectedOutput: `
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          initContainers:
+          - name: init-sidecar
+            resources:
+              requests:
+                cpu: 50m
+          containers:
+          - name: backup
+            resources:
+              limits:
+                memory: 4Gi
+          - name: log-sidecar
+            resources:
+              requests:
+                cpu: 50m
+`,
+			filter: PodResourcesFunction{
+				Metadata: v1alpha1.Metadata{Name: "resources"},
+				Spec: Spec{
+					Containers: []ContainerResources{
+						{
+							// Applies to all containers.
+							Remove: []string{"limits.cpu"},
+						},
+						{
+							Name:   "backup",
+							Limits: map[string]string{"memory": "4Gi"},
+						},
+						{
+							NameRegex: ".*-sidecar",
+							Requests:  map[string]string{"cpu": "50m"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			if !assert.Equal(t,
+				strings.TrimSpace(filtertest.RunFilter(t, tc.expectedOutput, filters.FormatFilter{})),
+				strings.TrimSpace(filtertest.RunFilter(t, tc.input, &pipeline{f: tc.filter}))) {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func Test_PodResourcesErrors(t *testing.T) {
+	testCases := map[string]ContainerResources{
+		"name-and-regex":   {Name: "server", NameRegex: "server.*"},
+		"invalid-regex":    {NameRegex: "("},
+		"invalid-quantity": {Requests: map[string]string{"cpu": "lots"}},
+		"invalid-remove":   {Remove: []string{"cpu"}},
+	}
+
+	for tn, c := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			f := PodResourcesFunction{
+				Metadata: v1alpha1.Metadata{Name: "resources"},
+				Spec:     Spec{Containers: []ContainerResources{c}},
+			}
+			if _, err := f.Filter([]*yaml.RNode{}); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+// n.b. we need to run formatfilter on the actual output as the formatfilter causes the keys in
+// dictionaries to be sorted which is necessary otherwise output will be random
+type pipeline struct {
+	f PodResourcesFunction
+}
+
+func (p pipeline) Filter(inputs []*yaml.RNode) ([]*yaml.RNode, error) {
+	outputs, err := p.f.Filter(inputs)
+	if err != nil {
+		return inputs, err
+	}
+
+	formatter := &filters.FormatFilter{}
+
+	return formatter.Filter(outputs)
+}
@@ -0,0 +1,362 @@
+package scaling
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// Kind is the kind for the kustomize function.
+	Kind = "ScalingGenerator"
+
+	// DefaultAnnotationPrefix is the prefix of the annotations configuring the scaling of a workload.
+	DefaultAnnotationPrefix = "autoscaling.hydros.io/"
+
+	// The annotations, without the prefix, configuring the HorizontalPodAutoscaler.
+	minAnnotation          = "min"
+	maxAnnotation          = "max"
+	targetCPUAnnotation    = "targetCPU"
+	targetMemoryAnnotation = "targetMemory"
+
+	// The annotations, without the prefix, configuring the PodDisruptionBudget.
+	minAvailableAnnotation   = "minAvailable"
+	maxUnavailableAnnotation = "maxUnavailable"
+
+	hpaKind = "HorizontalPodAutoscaler"
+	pdbKind = "PodDisruptionBudget"
+)
+
+// scalableKinds are the kinds of workloads a HorizontalPodAutoscaler can scale.
+var scalableKinds = map[string]bool{
+	"Deployment":  true,
+	"StatefulSet": true,
+}
+
+var _ kio.Filter = &ScalingGeneratorFunction{}
+
+// Filter returns a new ScalingGeneratorFunction
+func Filter() kio.Filter {
+	return &ScalingGeneratorFunction{}
+}
+
+// ScalingGeneratorFunction implements the ScalingGenerator Function. It generates a HorizontalPodAutoscaler and a
+// PodDisruptionBudget for each Deployment and StatefulSet from annotations on the workload so the scaling
+// configuration stays next to the workload.
+//
+//	autoscaling.hydros.io/min: "2"            # minReplicas of the HPA; defaults to 1
+//	autoscaling.hydros.io/max: "10"           # maxReplicas of the HPA; required to generate an HPA
+//	autoscaling.hydros.io/targetCPU: "70"     # target average CPU utilization in percent
+//	autoscaling.hydros.io/targetMemory: "80"  # target average memory utilization in percent
+//	autoscaling.hydros.io/minAvailable: "1"   # minAvailable of the PDB; an integer or a percentage
+//	autoscaling.hydros.io/maxUnavailable: "1" # maxUnavailable of the PDB; an integer or a percentage
+//
+// If the workload has an HPA with at least 2 replicas and neither minAvailable nor maxUnavailable is set, the
+// PDB allows 1 pod to be unavailable. The replicas of a workload with an HPA are removed so applying the hydrated
+// manifests doesn't undo the scaling. The generated resources have the same name and namespace as the workload
+// and are written to the same file. Workloads that already have an HPA or PDB with the same name are skipped.
+//
+// Example
+//
+//	apiVersion: hydros.dev/v1alpha1
+//	kind: ScalingGenerator
+//	metadata:
+//	  name: scaling
+//	spec:
+//	  selector:
+//	    labels:
+//	      environment: prod
+type ScalingGeneratorFunction struct {
+	// Kind is the API name.  Must be ScalingGenerator.
+	Kind string `yaml:"kind"`
+
+	// APIVersion is the API version.
+	APIVersion string `yaml:"apiVersion"`
+
+	// Metadata defines instance metadata.
+	Metadata v1alpha1.Metadata `yaml:"metadata"`
+
+	// Spec defines the desired declarative configuration.
+	Spec Spec `yaml:"spec"`
+}
+
+// Spec is the spec for the kustomize function.
+type Spec struct {
+	// Selector selects the workloads to generate resources for. If it isn't set all workloads are selected.
+	Selector *framework.Selector `yaml:"selector,omitempty"`
+
+	// AnnotationPrefix is the prefix of the annotations. Defaults to autoscaling.hydros.io/.
+	AnnotationPrefix string `yaml:"annotationPrefix,omitempty"`
+}
+
+func (f *ScalingGeneratorFunction) init() error {
+	if f.Metadata.Name == "" {
+		return fmt.Errorf("must specify ScalingGenerator name")
+	}
+	if f.Spec.AnnotationPrefix == "" {
+		f.Spec.AnnotationPrefix = DefaultAnnotationPrefix
+	}
+	return nil
+}
+
+// Filter generates the HorizontalPodAutoscalers and PodDisruptionBudgets of the selected workloads and appends
+// them to the RNodes.
+func (f ScalingGeneratorFunction) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	if err := f.init(); err != nil {
+		return nil, err
+	}
+	target := nodes
+	if f.Spec.Selector != nil {
+		var err error
+		target, err = f.Spec.Selector.Filter(nodes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	existing := map[string]bool{}
+	for _, n := range nodes {
+		existing[key(n.GetKind(), n.GetNamespace(), n.GetName())] = true
+	}
+
+	generated := []*yaml.RNode{}
+	for _, n := range target {
+		if !scalableKinds[n.GetKind()] {
+			continue
+		}
+		resources, err := f.generate(n, existing)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ScalingGenerator %v: %v %v", f.Metadata.Name, n.GetKind(), n.GetName())
+		}
+		generated = append(generated, resources...)
+	}
+	return append(nodes, generated...), nil
+}
+
+// generate returns the resources generated from the annotations of the workload.
+func (f ScalingGeneratorFunction) generate(n *yaml.RNode, existing map[string]bool) ([]*yaml.RNode, error) {
+	annotations := n.GetAnnotations()
+	get := func(name string) (string, bool) {
+		v, ok := annotations[f.Spec.AnnotationPrefix+name]
+		return v, ok
+	}
+
+	results := []*yaml.RNode{}
+	minReplicas := 1
+	hasHPA := false
+	if maxValue, ok := get(maxAnnotation); ok && !existing[key(hpaKind, n.GetNamespace(), n.GetName())] {
+		spec := hpaSpec{
+			ScaleTargetRef: crossVersionObjectReference{
+				APIVersion: n.GetApiVersion(),
+				Kind:       n.GetKind(),
+				Name:       n.GetName(),
+			},
+		}
+		maxReplicas, err := positiveInt(maxAnnotation, maxValue)
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := get(minAnnotation); ok {
+			minReplicas, err = positiveInt(minAnnotation, v)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if minReplicas > maxReplicas {
+			return nil, errors.Errorf("%v %v is greater than %v %v", minAnnotation, minReplicas, maxAnnotation, maxReplicas)
+		}
+		spec.MinReplicas = minReplicas
+		spec.MaxReplicas = maxReplicas
+
+		for _, m := range []struct{ annotation, resource string }{
+			{annotation: targetCPUAnnotation, resource: "cpu"},
+			{annotation: targetMemoryAnnotation, resource: "memory"},
+		} {
+			v, ok := get(m.annotation)
+			if !ok {
+				continue
+			}
+			utilization, err := positiveInt(m.annotation, strings.TrimSuffix(v, "%"))
+			if err != nil {
+				return nil, err
+			}
+			spec.Metrics = append(spec.Metrics, metricSpec{
+				Type: "Resource",
+				Resource: resourceMetricSource{
+					Name:   m.resource,
+					Target: metricTarget{Type: "Utilization", AverageUtilization: utilization},
+				},
+			})
+		}
+
+		hpa, err := toRNode(resource{
+			APIVersion: "autoscaling/v2",
+			Kind:       hpaKind,
+			Metadata:   newObjectMeta(n),
+			Spec:       spec,
+		})
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, hpa)
+		hasHPA = true
+
+		// The HPA owns the replicas of the workload.
+		if err := n.PipeE(yaml.Lookup("spec"), yaml.Clear("replicas")); err != nil {
+			return nil, errors.Wrapf(err, "Failed to remove replicas")
+		}
+	}
+
+	if existing[key(pdbKind, n.GetNamespace(), n.GetName())] {
+		return results, nil
+	}
+	spec := pdbSpec{}
+	minAvailable, hasMin := get(minAvailableAnnotation)
+	maxUnavailable, hasMax := get(maxUnavailableAnnotation)
+	switch {
+	case hasMin && hasMax:
+		return nil, errors.Errorf("only one of %v and %v can be set", minAvailableAnnotation, maxUnavailableAnnotation)
+	case hasMin:
+		spec.MinAvailable = intOrString(minAvailable)
+	case hasMax:
+		spec.MaxUnavailable = intOrString(maxUnavailable)
+	case hasHPA && minReplicas >= 2:
+		spec.MaxUnavailable = intOrString("1")
+	default:
+		return results, nil
+	}
+
+	selector, err := n.Pipe(yaml.Lookup("spec", "selector"))
+	if err != nil {
+		return nil, err
+	}
+	if selector == nil {
+		return nil, errors.Errorf("can't generate a %v; the workload doesn't have a selector", pdbKind)
+	}
+	spec.Selector = *selector.YNode()
+
+	pdb, err := toRNode(resource{
+		APIVersion: "policy/v1",
+		Kind:       pdbKind,
+		Metadata:   newObjectMeta(n),
+		Spec:       spec,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(results, pdb), nil
+}
+
+func key(kind string, namespace string, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// positiveInt parses the value of an annotation.
+func positiveInt(annotation string, value string) (int, error) {
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 1 {
+		return 0, errors.Errorf("%v must be a positive integer; got %v", annotation, value)
+	}
+	return i, nil
+}
+
+// intOrString returns a node for a value that is either an integer or a percentage e.g. 50%.
+func intOrString(value string) *yaml.Node {
+	if _, err := strconv.Atoi(value); err == nil {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: yaml.NodeTagInt, Value: value}
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: yaml.NodeTagString, Value: value}
+}
+
+func toRNode(r resource) (*yaml.RNode, error) {
+	b, err := yaml.Marshal(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to serialize %v", r.Kind)
+	}
+	n, err := yaml.Parse(string(b))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse %v", r.Kind)
+	}
+	return n, nil
+}
+
+type resource struct {
+	APIVersion string      `yaml:"apiVersion"`
+	Kind       string      `yaml:"kind"`
+	Metadata   objectMeta  `yaml:"metadata"`
+	Spec       interface{} `yaml:"spec"`
+}
+
+type objectMeta struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// newObjectMeta copies the name, namespace and labels of the workload. The annotations include the path and
+// index of the workload so the generated resource is written to the same file right after the workload.
+func newObjectMeta(n *yaml.RNode) objectMeta {
+	annotations := map[string]string{}
+	for _, k := range []string{kioutil.PathAnnotation, kioutil.IndexAnnotation} {
+		if v, ok := n.GetAnnotations()[k]; ok {
+			annotations[k] = v
+		}
+	}
+	labels := n.GetLabels()
+	if len(labels) == 0 {
+		labels = nil
+	}
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	return objectMeta{
+		Name:        n.GetName(),
+		Namespace:   n.GetNamespace(),
+		Labels:      labels,
+		Annotations: annotations,
+	}
+}
+
+// The following are the subset of the autoscaling/v2 and policy/v1 types needed to generate the resources.
+
+type hpaSpec struct {
+	ScaleTargetRef crossVersionObjectReference `yaml:"scaleTargetRef"`
+	MinReplicas    int                         `yaml:"minReplicas"`
+	MaxReplicas    int                         `yaml:"maxReplicas"`
+	Metrics        []metricSpec                `yaml:"metrics,omitempty"`
+}
+
+type crossVersionObjectReference struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Name       string `yaml:"name"`
+}
+
+type metricSpec struct {
+	Type     string               `yaml:"type"`
+	Resource resourceMetricSource `yaml:"resource"`
+}
+
+type resourceMetricSource struct {
+	Name   string       `yaml:"name"`
+	Target metricTarget `yaml:"target"`
+}
+
+type metricTarget struct {
+	Type               string `yaml:"type"`
+	AverageUtilization int    `yaml:"averageUtilization"`
+}
+
+type pdbSpec struct {
+	MinAvailable   *yaml.Node `yaml:"minAvailable,omitempty"`
+	MaxUnavailable *yaml.Node `yaml:"maxUnavailable,omitempty"`
+	Selector       yaml.Node  `yaml:"selector"`
+}
@@ -0,0 +1,257 @@
+package scaling
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	filtertest "sigs.k8s.io/kustomize/api/testutils/filtertest"
+	"sigs.k8s.io/kustomize/kyaml/kio/filters"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func Test_ScalingGenerator(t *testing.T) {
+	testCases := map[string]struct {
+		input          string
+		expectedOutput string
+		config         string
+	}{
+		"hpa-and-pdb": {
+			input: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+  labels:
+    app: web
+  annotations:
+    autoscaling.hydros.io/min: "2"
+    autoscaling.hydros.io/max: "10"
+    autoscaling.hydros.io/targetCPU: "70"
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: web
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+  annotations:
+    autoscaling.hydros.io/minAvailable: 50%
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: db
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: static
+spec:
+  replicas: 1
+`,
+			expectedOutput: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+  labels:
+    app: web
+  annotations:
+    autoscaling.hydros.io/min: "2"
+    autoscaling.hydros.io/max: "10"
+    autoscaling.hydros.io/targetCPU: "70"
+spec:
+  selector:
+    matchLabels:
+      app: web
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+  annotations:
+    autoscaling.hydros.io/minAvailable: 50%
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: db
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: static
+spec:
+  replicas: 1
+---
+apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata:
+  name: web
+  namespace: prod
+  labels:
+    app: web
+spec:
+  scaleTargetRef:
+    apiVersion: apps/v1
+    kind: Deployment
+    name: web
+  minReplicas: 2
+  maxReplicas: 10
+  metrics:
+  - type: Resource
+    resource:
+      name: cpu
+      target:
+        type: Utilization
+        averageUtilization: 70
+---
+apiVersion: policy/v1
+kind: PodDisruptionBudget
+metadata:
+  name: web
+  namespace: prod
+  labels:
+    app: web
+spec:
+  maxUnavailable: 1
+  selector:
+    matchLabels:
+      app: web
+---
+apiVersion: policy/v1
+kind: PodDisruptionBudget
+metadata:
+  name: db
+spec:
+  minAvailable: 50%
+  selector:
+    matchLabels:
+      app: db
+`,
+			config: `
+apiVersion: hydros.dev/v1alpha1
+kind: ScalingGenerator
+metadata:
+  name: scaling
+`,
+		},
+		"existing-hpa": {
+			input: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  annotations:
+    scaling/max: "5"
+spec:
+  replicas: 3
+---
+apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata:
+  name: web
+spec:
+  maxReplicas: 3
+`,
+			expectedOutput: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  annotations:
+    scaling/max: "5"
+spec:
+  replicas: 3
+---
+apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata:
+  name: web
+spec:
+  maxReplicas: 3
+`,
+			config: `
+apiVersion: hydros.dev/v1alpha1
+kind: ScalingGenerator
+metadata:
+  name: scaling
+spec:
+  annotationPrefix: scaling/
+`,
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			f := ScalingGeneratorFunction{}
+			if err := yaml.Unmarshal([]byte(tc.config), &f); err != nil {
+				t.Fatalf("Failed to decode config; %v", err)
+			}
+			if !assert.Equal(t,
+				strings.TrimSpace(filtertest.RunFilter(t, tc.expectedOutput, filters.FormatFilter{})),
+				strings.TrimSpace(filtertest.RunFilter(t, tc.input, &pipeline{f: f}))) {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func Test_ScalingGeneratorInvalid(t *testing.T) {
+	testCases := map[string]string{
+		"min-greater-than-max": `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  annotations:
+    autoscaling.hydros.io/min: "5"
+    autoscaling.hydros.io/max: "2"
+`,
+		"no-selector": `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  annotations:
+    autoscaling.hydros.io/maxUnavailable: "1"
+`,
+	}
+
+	for tn, input := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			n, err := yaml.Parse(input)
+			if err != nil {
+				t.Fatalf("Failed to parse input; %v", err)
+			}
+			f := ScalingGeneratorFunction{}
+			f.Metadata.Name = "scaling"
+			if _, err := f.Filter([]*yaml.RNode{n}); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+// n.b. we need to run formatfilter on the actual output as the formatfilter causes the keys in
+// dictionaries to be sorted which is necessary otherwise output will be random
+type pipeline struct {
+	f ScalingGeneratorFunction
+}
+
+func (p pipeline) Filter(inputs []*yaml.RNode) ([]*yaml.RNode, error) {
+	outputs, err := p.f.Filter(inputs)
+	if err != nil {
+		return inputs, err
+	}
+
+	formatter := &filters.FormatFilter{}
+
+	return formatter.Filter(outputs)
+}
@@ -0,0 +1,224 @@
+package scheduling
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/api/filters/fsslice"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// Kind is the kind for the kustomize function.
+	Kind = "PodScheduling"
+)
+
+var _ kio.Filter = &PodSchedulingFunction{}
+
+// podSpecFsSlice is the set of FieldSpecs where we expect to find pod specs.
+var podSpecFsSlice = []types.FieldSpec{
+	{
+		Path: "spec/template/spec",
+	},
+	{
+		Path: "spec/jobTemplate/spec/template/spec",
+	},
+}
+
+// Filter returns a new PodSchedulingFunction
+func Filter() kio.Filter {
+	return &PodSchedulingFunction{}
+}
+
+// PodSchedulingFunction implements the PodScheduling Function. It injects tolerations, a nodeSelector and
+// affinity into the pods of the workloads (Deployments, StatefulSets, DaemonSets, Jobs and CronJobs) matching
+// the selector. This lets the scheduling policy of each cluster be applied during hydration.
+//
+// Example
+//
+//	apiVersion: hydros.dev/v1alpha1
+//	kind: PodScheduling
+//	metadata:
+//	  name: gpu-pool
+//	spec:
+//	  selector:
+//	    labels:
+//	      accelerator: gpu
+//	  nodeSelector:
+//	    cloud.google.com/gke-nodepool: gpu
+//	  tolerations:
+//	  - key: nvidia.com/gpu
+//	    operator: Exists
+//	    effect: NoSchedule
+//	  affinity:
+//	    nodeAffinity:
+//	      requiredDuringSchedulingIgnoredDuringExecution:
+//	        nodeSelectorTerms:
+//	        - matchExpressions:
+//	          - key: cloud.google.com/gke-accelerator
+//	            operator: Exists
+type PodSchedulingFunction struct {
+	// Kind is the API name.  Must be PodScheduling.
+	Kind string `yaml:"kind"`
+
+	// APIVersion is the API version.
+	APIVersion string `yaml:"apiVersion"`
+
+	// Metadata defines instance metadata.
+	Metadata v1alpha1.Metadata `yaml:"metadata"`
+
+	// Spec defines the desired declarative configuration.
+	Spec Spec `yaml:"spec"`
+}
+
+// Spec is the spec for the kustomize function.
+type Spec struct {
+	// Selector selects the workloads to modify. If it isn't set all workloads are modified.
+	Selector *framework.Selector `yaml:"selector,omitempty"`
+
+	// NodeSelector are labels added to the nodeSelector of the pods. They override existing labels with the
+	// same key.
+	NodeSelector map[string]string `yaml:"nodeSelector,omitempty"`
+
+	// Tolerations are added to the tolerations of the pods. They replace existing tolerations with the same key
+	// and effect.
+	Tolerations []Toleration `yaml:"tolerations,omitempty"`
+
+	// Affinity is a corev1.Affinity. Each of nodeAffinity, podAffinity and podAntiAffinity that is set replaces
+	// the corresponding field of the affinity of the pods.
+	// N.B. This isn't a pointer because yaml.v3 only decodes arbitrary YAML into yaml.Node values.
+	Affinity yaml.Node `yaml:"affinity,omitempty"`
+}
+
+// Toleration is a copy of corev1.Toleration with YAML tags; see corev1_envvar_types.go in the envs function
+// for why we don't use the corev1 types directly.
+type Toleration struct {
+	Key               string `yaml:"key,omitempty"`
+	Operator          string `yaml:"operator,omitempty"`
+	Value             string `yaml:"value,omitempty"`
+	Effect            string `yaml:"effect,omitempty"`
+	TolerationSeconds *int64 `yaml:"tolerationSeconds,omitempty"`
+}
+
+func (f *PodSchedulingFunction) init() error {
+	if f.Metadata.Name == "" {
+		return fmt.Errorf("must specify PodScheduling name")
+	}
+	if f.Spec.Affinity.Kind != 0 && f.Spec.Affinity.Kind != yaml.MappingNode {
+		return errors.Errorf("PodScheduling %v: affinity must be a map", f.Metadata.Name)
+	}
+	return nil
+}
+
+// Filter injects the scheduling configuration into the pods of the selected RNodes.
+func (f PodSchedulingFunction) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	if err := f.init(); err != nil {
+		return nil, err
+	}
+	target := nodes
+	if f.Spec.Selector != nil {
+		var err error
+		target, err = f.Spec.Selector.Filter(nodes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err := kio.FilterAll(yaml.FilterFunc(f.filter)).Filter(target)
+	return nodes, err
+}
+
+func (f PodSchedulingFunction) filter(node *yaml.RNode) (*yaml.RNode, error) {
+	if err := node.PipeE(fsslice.Filter{
+		FsSlice:  podSpecFsSlice,
+		SetValue: f.setScheduling,
+	}); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// setScheduling should receive the mapping rnode of a pod spec.
+func (f PodSchedulingFunction) setScheduling(node *yaml.RNode) error {
+	for _, k := range sortedKeys(f.Spec.NodeSelector) {
+		if err := node.PipeE(
+			yaml.LookupCreate(yaml.MappingNode, "nodeSelector"),
+			yaml.SetField(k, yaml.NewStringRNode(f.Spec.NodeSelector[k])),
+		); err != nil {
+			return errors.Wrapf(err, "Failed to set nodeSelector %v", k)
+		}
+	}
+
+	if len(f.Spec.Tolerations) > 0 {
+		if err := f.setTolerations(node); err != nil {
+			return err
+		}
+	}
+
+	if f.Spec.Affinity.Kind == yaml.MappingNode {
+		affinity := yaml.NewRNode(&f.Spec.Affinity)
+		fields, err := affinity.Fields()
+		if err != nil {
+			return errors.Wrapf(err, "Failed to get the fields of affinity")
+		}
+		for _, field := range fields {
+			value := affinity.Field(field).Value.Copy()
+			if err := node.PipeE(
+				yaml.LookupCreate(yaml.MappingNode, "affinity"),
+				yaml.SetField(field, value),
+			); err != nil {
+				return errors.Wrapf(err, "Failed to set affinity %v", field)
+			}
+		}
+	}
+	return nil
+}
+
+// setTolerations appends the tolerations of the function to the tolerations of the pod after removing the
+// existing tolerations they replace.
+func (f PodSchedulingFunction) setTolerations(node *yaml.RNode) error {
+	tolerations, err := node.Pipe(yaml.LookupCreate(yaml.SequenceNode, "tolerations"))
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get tolerations")
+	}
+	replaced := map[string]bool{}
+	for _, t := range f.Spec.Tolerations {
+		replaced[t.Key+"/"+t.Effect] = true
+	}
+
+	elements, err := tolerations.Elements()
+	if err != nil {
+		return errors.Wrapf(err, "tolerations must be a list")
+	}
+	content := []*yaml.Node{}
+	for _, e := range elements {
+		key, _ := e.GetString("key")
+		effect, _ := e.GetString("effect")
+		if replaced[key+"/"+effect] {
+			continue
+		}
+		content = append(content, e.YNode())
+	}
+	for _, t := range f.Spec.Tolerations {
+		n := &yaml.Node{}
+		if err := n.Encode(t); err != nil {
+			return errors.Wrapf(err, "Failed to encode toleration %v", t.Key)
+		}
+		content = append(content, n)
+	}
+	tolerations.YNode().Content = content
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
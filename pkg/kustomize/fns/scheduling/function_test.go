@@ -0,0 +1,250 @@
+package scheduling
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	filtertest "sigs.k8s.io/kustomize/api/testutils/filtertest"
+	"sigs.k8s.io/kustomize/kyaml/kio/filters"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func Test_PodScheduling(t *testing.T) {
+	testCases := map[string]struct {
+		input          string
+		expectedOutput string
+		config         string
+	}{
+		"selector": {
+			input: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: trainer
+  labels:
+    accelerator: gpu
+spec:
+  template:
+    spec:
+      nodeSelector:
+        kubernetes.io/os: linux
+      tolerations:
+      - key: nvidia.com/gpu
+        operator: Equal
+        value: "true"
+        effect: NoSchedule
+      - key: dedicated
+        operator: Exists
+      containers:
+      - name: trainer
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+`,
+			expectedOutput: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: trainer
+  labels:
+    accelerator: gpu
+spec:
+  template:
+    spec:
+      nodeSelector:
+        kubernetes.io/os: linux
+        cloud.google.com/gke-nodepool: gpu
+      tolerations:
+      - key: dedicated
+        operator: Exists
+      - key: nvidia.com/gpu
+        operator: Exists
+        effect: NoSchedule
+      containers:
+      - name: trainer
+      affinity:
+        nodeAffinity:
+          requiredDuringSchedulingIgnoredDuringExecution:
+            nodeSelectorTerms:
+            - matchExpressions:
+              - key: cloud.google.com/gke-accelerator
+                operator: Exists
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+`,
+			config: `
+apiVersion: hydros.dev/v1alpha1
+kind: PodScheduling
+metadata:
+  name: gpu-pool
+spec:
+  selector:
+    labels:
+      accelerator: gpu
+  nodeSelector:
+    cloud.google.com/gke-nodepool: gpu
+  tolerations:
+  - key: nvidia.com/gpu
+    operator: Exists
+    effect: NoSchedule
+  affinity:
+    nodeAffinity:
+      requiredDuringSchedulingIgnoredDuringExecution:
+        nodeSelectorTerms:
+        - matchExpressions:
+          - key: cloud.google.com/gke-accelerator
+            operator: Exists
+`,
+		},
+		"cronjob-affinity": {
+			input: `
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          affinity:
+            nodeAffinity:
+              preferredDuringSchedulingIgnoredDuringExecution:
+              - weight: 1
+                preference:
+                  matchExpressions:
+                  - key: zone
+                    operator: In
+                    values:
+                    - a
+            podAntiAffinity:
+              preferredDuringSchedulingIgnoredDuringExecution: []
+          containers:
+          - name: backup
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: backup
+spec:
+  ports:
+  - port: 80
+`,
+			expectedOutput: `
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          affinity:
+            nodeAffinity:
+              preferredDuringSchedulingIgnoredDuringExecution:
+              - weight: 1
+                preference:
+                  matchExpressions:
+                  - key: pool
+                    operator: In
+                    values:
+                    - batch
+            podAntiAffinity:
+              preferredDuringSchedulingIgnoredDuringExecution: []
+          containers:
+          - name: backup
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: backup
+spec:
+  ports:
+  - port: 80
+`,
+			config: `
+apiVersion: hydros.dev/v1alpha1
+kind: PodScheduling
+metadata:
+  name: batch
+spec:
+  affinity:
+    nodeAffinity:
+      preferredDuringSchedulingIgnoredDuringExecution:
+      - weight: 1
+        preference:
+          matchExpressions:
+          - key: pool
+            operator: In
+            values:
+            - batch
+`,
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			f := PodSchedulingFunction{}
+			if err := yaml.Unmarshal([]byte(tc.config), &f); err != nil {
+				t.Fatalf("Failed to decode config; %v", err)
+			}
+			if !assert.Equal(t,
+				strings.TrimSpace(filtertest.RunFilter(t, tc.expectedOutput, filters.FormatFilter{})),
+				strings.TrimSpace(filtertest.RunFilter(t, tc.input, &pipeline{f: f}))) {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func Test_PodSchedulingInvalidAffinity(t *testing.T) {
+	f := PodSchedulingFunction{}
+	config := `
+kind: PodScheduling
+metadata:
+  name: invalid
+spec:
+  affinity:
+  - nodeAffinity: {}
+`
+	if err := yaml.Unmarshal([]byte(config), &f); err != nil {
+		t.Fatalf("Failed to decode config; %v", err)
+	}
+	if _, err := f.Filter([]*yaml.RNode{}); err == nil {
+		t.Errorf("Expected an error")
+	}
+}
+
+// n.b. we need to run formatfilter on the actual output as the formatfilter causes the keys in
+// dictionaries to be sorted which is necessary otherwise output will be random
+type pipeline struct {
+	f PodSchedulingFunction
+}
+
+func (p pipeline) Filter(inputs []*yaml.RNode) ([]*yaml.RNode, error) {
+	outputs, err := p.f.Filter(inputs)
+	if err != nil {
+		return inputs, err
+	}
+
+	formatter := &filters.FormatFilter{}
+
+	return formatter.Filter(outputs)
+}
@@ -0,0 +1,264 @@
+package schemas
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/kustomize/fns/validation"
+	"github.com/pkg/errors"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/openapi"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// Kind is the kind for the kustomize function.
+	Kind = "SchemaValidator"
+
+	// preserveUnknownFields is the extension set on schemas of objects whose fields aren't known.
+	preserveUnknownFields = "x-kubernetes-preserve-unknown-fields"
+)
+
+// stringOrNumber are the suffixes of the definitions whose values can be either strings or numbers.
+var stringOrNumber = []string{"intstr.IntOrString", "resource.Quantity"}
+
+var _ kio.Filter = &SchemaValidatorFunction{}
+
+// Filter returns a new SchemaValidatorFunction
+func Filter() kio.Filter {
+	return &SchemaValidatorFunction{}
+}
+
+// SchemaValidatorFunction implements the SchemaValidator Function. Like kubeconform it checks the resources
+// against the OpenAPI schemas of the Kubernetes built-in kinds. It catches mistakes such as misspelled fields,
+// values of the wrong type and missing required fields before the resources are applied to the cluster.
+//
+// Resources whose kinds don't have a schema e.g. custom resources are skipped unless rejectUnknownKinds is true.
+// It runs in the validate phase so the violations are reported rather than failing on the first one.
+//
+// Example
+//
+//	apiVersion: hydros.dev/v1alpha1
+//	kind: SchemaValidator
+//	metadata:
+//	  name: kubeconform
+//	spec:
+//	  skipKinds:
+//	  - ConfigMap
+type SchemaValidatorFunction struct {
+	// Kind is the API name.  Must be SchemaValidator.
+	Kind string `yaml:"kind"`
+
+	// APIVersion is the API version.
+	APIVersion string `yaml:"apiVersion"`
+
+	// Metadata defines instance metadata.
+	Metadata v1alpha1.Metadata `yaml:"metadata"`
+
+	// Spec defines the desired declarative configuration.
+	Spec Spec `yaml:"spec"`
+}
+
+// Spec is the spec for the kustomize function.
+type Spec struct {
+	// SkipKinds is a list of kinds which aren't validated.
+	SkipKinds []string `yaml:"skipKinds,omitempty"`
+
+	// RejectUnknownKinds reports resources whose kinds don't have a schema as violations rather than skipping them.
+	RejectUnknownKinds bool `yaml:"rejectUnknownKinds,omitempty"`
+}
+
+func (f *SchemaValidatorFunction) init() error {
+	if f.Metadata.Name == "" {
+		return fmt.Errorf("must specify SchemaValidator name")
+	}
+	return nil
+}
+
+// Filter checks the provided RNodes against their schemas. The nodes are never modified.
+func (f SchemaValidatorFunction) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	if err := f.init(); err != nil {
+		return nil, err
+	}
+
+	skip := map[string]bool{}
+	for _, k := range f.Spec.SkipKinds {
+		skip[k] = true
+	}
+
+	violations := []validation.Violation{}
+	for _, n := range nodes {
+		if skip[n.GetKind()] {
+			continue
+		}
+		meta, err := n.GetMeta()
+		if err != nil {
+			return nil, errors.Wrapf(err, "SchemaValidator %v: failed to get the metadata of a resource", f.Metadata.Name)
+		}
+		rs := openapi.SchemaForResourceType(meta.TypeMeta)
+		if rs == nil {
+			if f.Spec.RejectUnknownKinds {
+				violations = append(violations, validation.NewViolation(n, "", fmt.Sprintf("there is no schema for %v %v", meta.APIVersion, meta.Kind)))
+			}
+			continue
+		}
+		c := &checker{resource: n}
+		if err := c.check(n.YNode(), rs.Schema, ""); err != nil {
+			return nil, errors.Wrapf(err, "SchemaValidator %v: failed to validate %v/%v", f.Metadata.Name, meta.Kind, meta.Name)
+		}
+		violations = append(violations, c.violations...)
+	}
+
+	if len(violations) == 0 {
+		return nodes, nil
+	}
+	return nil, &validation.Error{Function: Kind + "/" + f.Metadata.Name, Violations: violations}
+}
+
+// checker walks a resource and records the fields which don't match the schema.
+type checker struct {
+	resource   *yaml.RNode
+	violations []validation.Violation
+}
+
+func (c *checker) addViolation(field string, format string, args ...interface{}) {
+	c.violations = append(c.violations, validation.NewViolation(c.resource, field, fmt.Sprintf(format, args...)))
+}
+
+// check checks the value of field against schema s.
+func (c *checker) check(node *yaml.Node, s *spec.Schema, field string) error {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.ShortTag() == yaml.NodeTagNull {
+		return nil
+	}
+
+	for s.Ref.String() != "" {
+		ref := s.Ref.String()
+		for _, suffix := range stringOrNumber {
+			if strings.HasSuffix(ref, suffix) {
+				return c.checkScalar(node, field, "a string or number", yaml.NodeTagString, yaml.NodeTagInt, yaml.NodeTagFloat)
+			}
+		}
+		resolved, err := openapi.Resolve(&s.Ref, openapi.Schema())
+		if err != nil {
+			return errors.Wrapf(err, "Failed to resolve the schema %v", ref)
+		}
+		s = resolved
+	}
+
+	switch {
+	case s.Type.Contains("object") || len(s.Properties) > 0:
+		return c.checkObject(node, s, field)
+	case s.Type.Contains("array"):
+		if node.Kind != yaml.SequenceNode {
+			c.addViolation(field, "expected a list; got %v", describe(node))
+			return nil
+		}
+		if s.Items == nil || s.Items.Schema == nil {
+			return nil
+		}
+		for i, item := range node.Content {
+			if err := c.check(item, s.Items.Schema, fmt.Sprintf("%v[%d]", field, i)); err != nil {
+				return err
+			}
+		}
+	case s.Type.Contains("string"):
+		return c.checkScalar(node, field, "a string", yaml.NodeTagString)
+	case s.Type.Contains("integer"):
+		return c.checkScalar(node, field, "an integer", yaml.NodeTagInt)
+	case s.Type.Contains("number"):
+		return c.checkScalar(node, field, "a number", yaml.NodeTagInt, yaml.NodeTagFloat)
+	case s.Type.Contains("boolean"):
+		return c.checkScalar(node, field, "a boolean", yaml.NodeTagBool)
+	}
+	return nil
+}
+
+func (c *checker) checkObject(node *yaml.Node, s *spec.Schema, field string) error {
+	if node.Kind != yaml.MappingNode {
+		c.addViolation(field, "expected an object; got %v", describe(node))
+		return nil
+	}
+
+	preserve, _ := s.Extensions.GetBool(preserveUnknownFields)
+	present := map[string]bool{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i].Value
+		value := node.Content[i+1]
+		present[key] = true
+		child := join(field, key)
+
+		if prop, ok := s.Properties[key]; ok {
+			if err := c.check(value, &prop, child); err != nil {
+				return err
+			}
+			continue
+		}
+		if s.AdditionalProperties != nil {
+			if s.AdditionalProperties.Schema != nil {
+				if err := c.check(value, s.AdditionalProperties.Schema, child); err != nil {
+					return err
+				}
+				continue
+			}
+			if s.AdditionalProperties.Allows {
+				continue
+			}
+		}
+		if len(s.Properties) > 0 && !preserve {
+			c.addViolation(child, "unknown field")
+		}
+	}
+
+	required := append([]string{}, s.Required...)
+	sort.Strings(required)
+	for _, r := range required {
+		if !present[r] {
+			c.addViolation(join(field, r), "missing required field")
+		}
+	}
+	return nil
+}
+
+// checkScalar checks that node is a scalar with one of the tags.
+func (c *checker) checkScalar(node *yaml.Node, field string, expected string, tags ...string) error {
+	if node.Kind == yaml.ScalarNode {
+		for _, t := range tags {
+			if node.ShortTag() == t {
+				return nil
+			}
+		}
+	}
+	c.addViolation(field, "expected %v; got %v", expected, describe(node))
+	return nil
+}
+
+// describe returns a short description of the type of node for violation messages.
+func describe(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "an object"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	switch node.ShortTag() {
+	case yaml.NodeTagString:
+		return fmt.Sprintf("string %q", node.Value)
+	case yaml.NodeTagBool:
+		return fmt.Sprintf("boolean %v", node.Value)
+	}
+	return fmt.Sprintf("number %v", node.Value)
+}
+
+// join returns the path of the field key of the object at path.
+func join(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
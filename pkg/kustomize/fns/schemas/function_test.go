@@ -0,0 +1,152 @@
+package schemas
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/kustomize/fns/validation"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+)
+
+const input = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+  annotations:
+    internal.config.kubernetes.io/path: web.yaml
+spec:
+  replicas: "3"
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: web
+        image: nginx
+        ports:
+        - containerPort: 80
+        resources:
+          limits:
+            cpu: 1
+            memory: 1Gi
+        readinessProbe:
+          httpGet:
+            port: http
+        imagePullPolicy: Always
+        enviroment:
+        - name: FOO
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+  - port: 80
+    targetPort: 8080
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: debug
+data:
+  enabled: true
+---
+apiVersion: acme.dev/v1
+kind: Widget
+metadata:
+  name: widget
+spec:
+  size: 3
+`
+
+func Test_SchemaValidator(t *testing.T) {
+	testCases := map[string]struct {
+		spec     Spec
+		expected []validation.Violation
+	}{
+		"default": {
+			expected: []validation.Violation{
+				{Resource: "Deployment/prod/web", Path: "web.yaml", Field: "spec.replicas", Message: `expected an integer; got string "3"`},
+				{Resource: "Deployment/prod/web", Path: "web.yaml", Field: "spec.template.spec.containers[0].enviroment", Message: "unknown field"},
+				{Resource: "ConfigMap/debug", Field: "data.enabled", Message: "expected a string; got boolean true"},
+			},
+		},
+		"skip-and-reject": {
+			spec: Spec{
+				SkipKinds:          []string{"Deployment", "ConfigMap"},
+				RejectUnknownKinds: true,
+			},
+			expected: []validation.Violation{
+				{Resource: "Widget/widget", Message: "there is no schema for acme.dev/v1 Widget"},
+			},
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			nodes, err := (&kio.ByteReader{Reader: strings.NewReader(input), OmitReaderAnnotations: true}).Read()
+			if err != nil {
+				t.Fatalf("Failed to read input; %v", err)
+			}
+			f := SchemaValidatorFunction{
+				Metadata: v1alpha1.Metadata{Name: "kubeconform"},
+				Spec:     tc.spec,
+			}
+			_, err = f.Filter(nodes)
+			var verr *validation.Error
+			if !errors.As(err, &verr) {
+				t.Fatalf("Expected a validation.Error; got %v", err)
+			}
+			if verr.Function != "SchemaValidator/kubeconform" {
+				t.Errorf("Function is wrong; got %v", verr.Function)
+			}
+			if d := cmp.Diff(tc.expected, verr.Violations); d != "" {
+				t.Errorf("Unexpected violations:\n%v", d)
+			}
+		})
+	}
+}
+
+func Test_SchemaValidatorValid(t *testing.T) {
+	valid := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: web
+  template:
+    spec:
+      containers:
+      - name: web
+        image: nginx
+        resources:
+          limits:
+            cpu: 500m
+`
+	nodes, err := (&kio.ByteReader{Reader: strings.NewReader(valid)}).Read()
+	if err != nil {
+		t.Fatalf("Failed to read input; %v", err)
+	}
+	f := SchemaValidatorFunction{Metadata: v1alpha1.Metadata{Name: "kubeconform"}}
+	out, err := f.Filter(nodes)
+	if err != nil {
+		t.Fatalf("Filter failed; %v", err)
+	}
+	if len(out) != len(nodes) {
+		t.Errorf("Expected %d nodes; got %d", len(nodes), len(out))
+	}
+}
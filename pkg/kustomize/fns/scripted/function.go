@@ -0,0 +1,154 @@
+package scripted
+
+import (
+	"fmt"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/fn/framework"
+	"sigs.k8s.io/kustomize/kyaml/fn/runtime/starlark"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// Kind is the kind for the kustomize function.
+	Kind = "ScriptedFn"
+
+	// StarlarkLanguage is the language of programs written in Starlark; https://github.com/bazelbuild/starlark
+	StarlarkLanguage = "starlark"
+)
+
+var _ kio.Filter = &ScriptedFunction{}
+
+// Filter returns a new ScriptedFunction
+func Filter() kio.Filter {
+	return &ScriptedFunction{}
+}
+
+// ScriptedFunction implements the ScriptedFn Function. It runs a small program embedded in the spec that can read
+// and mutate the resources. This lets teams express one-off transformations without adding a new function kind
+// to hydros.
+//
+// Programs are written in Starlark and follow the conventions of kpt's starlark runtime. The resources are
+// the items of ctx.resource_list and the function config, including params, is ctx.resource_list["functionConfig"].
+// The program mutates the items in place; items can also be added or removed by assigning a new list to
+// ctx.resource_list["items"]. N.B. Top level statements can't be loops so loop over the items in a function.
+// Only starlark is supported; CEL expressions can't mutate resources.
+//
+// Example
+//
+//	apiVersion: hydros.dev/v1alpha1
+//	kind: ScriptedFn
+//	metadata:
+//	  name: team-label
+//	spec:
+//	  params:
+//	    team: payments
+//	  program: |
+//	    def run(items, team):
+//	      for r in items:
+//	        r["metadata"].setdefault("labels", {})["team"] = team
+//
+//	    run(ctx.resource_list["items"], ctx.resource_list["functionConfig"]["spec"]["params"]["team"])
+type ScriptedFunction struct {
+	// Kind is the API name.  Must be ScriptedFn.
+	Kind string `yaml:"kind"`
+
+	// APIVersion is the API version.
+	APIVersion string `yaml:"apiVersion"`
+
+	// Metadata defines instance metadata.
+	Metadata v1alpha1.Metadata `yaml:"metadata"`
+
+	// Spec defines the desired declarative configuration.
+	Spec Spec `yaml:"spec"`
+}
+
+// Spec is the spec for the kustomize function.
+type Spec struct {
+	// Language is the language of the program. Defaults to starlark which is the only supported language.
+	Language string `yaml:"language,omitempty"`
+
+	// Program is the source of the program.
+	Program string `yaml:"program,omitempty"`
+
+	// Params are arbitrary values passed to the program as part of the function config so the same program can
+	// be reused with different values.
+	// N.B. This isn't a pointer because yaml.v3 only decodes arbitrary YAML into yaml.Node values.
+	Params yaml.Node `yaml:"params,omitempty"`
+
+	// Selector selects the resources passed to the program. If it isn't set all resources are passed. Resources
+	// that aren't selected are left unchanged.
+	Selector *framework.Selector `yaml:"selector,omitempty"`
+}
+
+func (f *ScriptedFunction) init() error {
+	if f.Metadata.Name == "" {
+		return fmt.Errorf("must specify ScriptedFn name")
+	}
+	switch f.Spec.Language {
+	case "":
+		f.Spec.Language = StarlarkLanguage
+	case StarlarkLanguage:
+	default:
+		return errors.Errorf("ScriptedFn %v: language %v isn't supported; it must be %v", f.Metadata.Name, f.Spec.Language, StarlarkLanguage)
+	}
+	if f.Spec.Program == "" {
+		return errors.Errorf("ScriptedFn %v: program must be specified", f.Metadata.Name)
+	}
+	return nil
+}
+
+// Filter runs the program on the selected RNodes.
+func (f ScriptedFunction) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	if err := f.init(); err != nil {
+		return nil, err
+	}
+
+	target := nodes
+	unselected := []*yaml.RNode{}
+	if f.Spec.Selector != nil {
+		var err error
+		target, err = f.Spec.Selector.Filter(nodes)
+		if err != nil {
+			return nil, err
+		}
+		selected := map[*yaml.RNode]bool{}
+		for _, n := range target {
+			selected[n] = true
+		}
+		for _, n := range nodes {
+			if !selected[n] {
+				unselected = append(unselected, n)
+			}
+		}
+	}
+
+	// N.B. The selector isn't passed to the program because it can't be serialized once it has been used.
+	fnConfig := f
+	fnConfig.Spec.Selector = nil
+	b, err := yaml.Marshal(fnConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to serialize ScriptedFn %v", f.Metadata.Name)
+	}
+	config, err := yaml.Parse(string(b))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse ScriptedFn %v", f.Metadata.Name)
+	}
+
+	runner := &starlark.Filter{
+		Name:    f.Metadata.Name,
+		Program: f.Spec.Program,
+	}
+	runner.FunctionConfig = config
+	// N.B. The dispatcher already scopes functions to the resources in their directory.
+	runner.GlobalScope = true
+
+	results, err := runner.Filter(target)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ScriptedFn %v failed", f.Metadata.Name)
+	}
+	// N.B. Like kyaml's function runtime the output of the program comes before the resources it wasn't given.
+	return append(results, unselected...), nil
+}
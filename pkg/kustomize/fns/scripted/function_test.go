@@ -0,0 +1,195 @@
+package scripted
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	filtertest "sigs.k8s.io/kustomize/api/testutils/filtertest"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/filters"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const input = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 3
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  labels:
+    app: web
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: debug
+`
+
+func Test_ScriptedFn(t *testing.T) {
+	testCases := map[string]struct {
+		expectedOutput string
+		config         string
+	}{
+		"params": {
+			expectedOutput: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    team: payments
+spec:
+  replicas: 3
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  labels:
+    app: web
+    team: payments
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: debug
+  labels:
+    team: payments
+`,
+			config: `
+apiVersion: hydros.dev/v1alpha1
+kind: ScriptedFn
+metadata:
+  name: team-label
+spec:
+  params:
+    team: payments
+  program: |
+    def run(items, team):
+      for r in items:
+        r["metadata"].setdefault("labels", {})["team"] = team
+
+    run(ctx.resource_list["items"], ctx.resource_list["functionConfig"]["spec"]["params"]["team"])
+`,
+		},
+		"selector": {
+			// The ConfigMap is removed by the program and the Service isn't selected so it is unchanged.
+			expectedOutput: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 1
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  labels:
+    app: web
+`,
+			config: `
+apiVersion: hydros.dev/v1alpha1
+kind: ScriptedFn
+metadata:
+  name: dev
+spec:
+  selector:
+    kinds:
+    - Deployment
+    - ConfigMap
+  program: |
+    def run(items):
+      results = []
+      for r in items:
+        if r["kind"] == "ConfigMap":
+          continue
+        r["spec"]["replicas"] = 1
+        results.append(r)
+      return results
+
+    ctx.resource_list["items"] = run(ctx.resource_list["items"])
+`,
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			f := ScriptedFunction{}
+			if err := yaml.Unmarshal([]byte(tc.config), &f); err != nil {
+				t.Fatalf("Failed to decode config; %v", err)
+			}
+			if !assert.Equal(t,
+				strings.TrimSpace(filtertest.RunFilter(t, tc.expectedOutput, filters.FormatFilter{})),
+				strings.TrimSpace(filtertest.RunFilter(t, input, &pipeline{f: f}))) {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func Test_ScriptedFnInvalid(t *testing.T) {
+	testCases := map[string]string{
+		"language": `
+kind: ScriptedFn
+metadata:
+  name: cel
+spec:
+  language: cel
+  program: object.metadata.name == "web"
+`,
+		"syntax": `
+kind: ScriptedFn
+metadata:
+  name: syntax
+spec:
+  program: |
+    for r in
+`,
+	}
+
+	for tn, config := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			f := ScriptedFunction{}
+			if err := yaml.Unmarshal([]byte(config), &f); err != nil {
+				t.Fatalf("Failed to decode config; %v", err)
+			}
+			if _, err := f.Filter([]*yaml.RNode{}); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+// n.b. we need to run formatfilter on the actual output as the formatfilter causes the keys in
+// dictionaries to be sorted which is necessary otherwise output will be random
+type pipeline struct {
+	f ScriptedFunction
+}
+
+func (p pipeline) Filter(inputs []*yaml.RNode) ([]*yaml.RNode, error) {
+	outputs, err := p.f.Filter(inputs)
+	if err != nil {
+		return inputs, err
+	}
+
+	// kyaml's function runtime sets the path of resources that don't have one.
+	for _, a := range []string{kioutil.PathAnnotation, kioutil.LegacyPathAnnotation} {
+		if _, err := kio.FilterAll(yaml.ClearAnnotation(a)).Filter(outputs); err != nil {
+			return inputs, err
+		}
+	}
+
+	formatter := &filters.FormatFilter{}
+
+	return formatter.Filter(outputs)
+}
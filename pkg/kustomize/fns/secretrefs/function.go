@@ -0,0 +1,489 @@
+package secretrefs
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// Kind is the kind for the kustomize function.
+	Kind = "SecretRefs"
+
+	// ExternalSecretOutput replaces Secrets with ExternalSecrets read by the External Secrets Operator.
+	// https://external-secrets.io
+	ExternalSecretOutput = "ExternalSecret"
+	// SecretProviderClassOutput replaces Secrets with SecretProviderClasses read by the Secrets Store CSI Driver.
+	// https://secrets-store-csi-driver.sigs.k8s.io
+	SecretProviderClassOutput = "SecretProviderClass"
+
+	// GCPProvider is the provider of secrets stored in GCP Secret Manager.
+	GCPProvider = "gcp"
+	// AWSProvider is the provider of secrets stored in AWS Secrets Manager.
+	AWSProvider = "aws"
+
+	gcpScheme = "gcpsecretmanager"
+	awsScheme = "awssecretsmanager"
+)
+
+// defaultSecretStores are the SecretStores ExternalSecrets use if the function doesn't configure one for the
+// provider.
+var defaultSecretStores = map[string]SecretStoreRef{
+	GCPProvider: {Kind: "ClusterSecretStore", Name: "gcp-secret-manager"},
+	AWSProvider: {Kind: "ClusterSecretStore", Name: "aws-secrets-manager"},
+}
+
+var _ kio.Filter = &SecretRefsFn{}
+
+// Filter returns a new SecretRefsFn
+func Filter() kio.Filter {
+	return &SecretRefsFn{}
+}
+
+// SecretRefsFn is a filter that replaces Secrets whose values reference secrets in an external secret store with
+// resources that read the secrets from the store when they are deployed. This way the hydrated manifests never
+// contain the values of the secrets.
+//
+// The values of the Secret (either stringData or base64 encoded data) are URIs of the secrets
+//
+//	gcpSecretManager:///projects/${PROJECT}/secrets/${SECRET}/versions/${VERSION}
+//	awsSecretsManager:///${SECRET}
+//
+// The version of GCP secrets defaults to latest. A fragment e.g. #password selects a property of a JSON secret.
+type SecretRefsFn struct {
+	// Kind is the API name.  Must be SecretRefs.
+	Kind string `yaml:"kind"`
+
+	// APIVersion is the API version.
+	APIVersion string `yaml:"apiVersion"`
+
+	// Metadata defines instance metadata.
+	Metadata v1alpha1.Metadata `yaml:"metadata"`
+
+	// Spec defines the desired declarative configuration.
+	Spec Spec `yaml:"spec"`
+}
+
+// Spec is the spec for the kustomize function.
+type Spec struct {
+	// Output is the kind of resource Secrets are replaced with; ExternalSecret or SecretProviderClass.
+	// Defaults to ExternalSecret.
+	Output string `yaml:"output,omitempty"`
+
+	// SecretStores are the SecretStores ExternalSecrets use for each provider (gcp or aws). The store determines
+	// the project or account the secrets are read from. Defaults to the ClusterSecretStores gcp-secret-manager and
+	// aws-secrets-manager.
+	SecretStores map[string]SecretStoreRef `yaml:"secretStores,omitempty"`
+
+	// RefreshInterval is how often ExternalSecrets are refreshed e.g. 1h.
+	RefreshInterval string `yaml:"refreshInterval,omitempty"`
+
+	// AWSRegion is the region of AWS secrets used by SecretProviderClasses.
+	AWSRegion string `yaml:"awsRegion,omitempty"`
+
+	// RequireRefs fails the function if a Secret contains plaintext values instead of references so that
+	// plaintext secrets can't be hydrated by mistake.
+	RequireRefs bool `yaml:"requireRefs,omitempty"`
+}
+
+// SecretStoreRef refers to a SecretStore or ClusterSecretStore of the External Secrets Operator.
+type SecretStoreRef struct {
+	Kind string `yaml:"kind,omitempty"`
+	Name string `yaml:"name"`
+}
+
+// secretRef is a reference to a secret in an external store.
+type secretRef struct {
+	// Key is the key of the value in the Secret.
+	Key      string
+	Provider string
+	// Project is the GCP project of the secret.
+	Project string
+	Name    string
+	Version string
+	// Property is the property of a JSON secret.
+	Property string
+}
+
+func (f *SecretRefsFn) init() error {
+	if f.Metadata.Name == "" {
+		return fmt.Errorf("must specify name for SecretRefsFn")
+	}
+
+	switch f.Spec.Output {
+	case "":
+		f.Spec.Output = ExternalSecretOutput
+	case ExternalSecretOutput, SecretProviderClassOutput:
+	default:
+		return errors.Errorf("SecretRefsFn %v has invalid output %v; it must be %v or %v", f.Metadata.Name, f.Spec.Output, ExternalSecretOutput, SecretProviderClassOutput)
+	}
+
+	for p := range f.Spec.SecretStores {
+		if _, ok := defaultSecretStores[p]; !ok {
+			return errors.Errorf("SecretRefsFn %v has a secretStore for unknown provider %v; it must be %v or %v", f.Metadata.Name, p, GCPProvider, AWSProvider)
+		}
+	}
+
+	if f.Spec.RefreshInterval != "" {
+		if _, err := time.ParseDuration(f.Spec.RefreshInterval); err != nil {
+			return errors.Wrapf(err, "SecretRefsFn %v has invalid refreshInterval %v", f.Metadata.Name, f.Spec.RefreshInterval)
+		}
+	}
+	return nil
+}
+
+// Filter applies the filter to the nodes.
+func (f SecretRefsFn) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	if err := f.init(); err != nil {
+		return nil, err
+	}
+
+	result := make([]*yaml.RNode, 0, len(nodes))
+	for _, n := range nodes {
+		if n.GetKind() != "Secret" || n.GetApiVersion() != "v1" {
+			result = append(result, n)
+			continue
+		}
+
+		refs, plaintext, err := readRefs(n)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Secret %v has invalid secret references", n.GetName())
+		}
+
+		if len(refs) == 0 {
+			if f.Spec.RequireRefs && len(plaintext) > 0 {
+				return nil, errors.Errorf("Secret %v has plaintext values for keys %v; replace them with references to an external secret store", n.GetName(), strings.Join(plaintext, ", "))
+			}
+			result = append(result, n)
+			continue
+		}
+
+		if len(plaintext) > 0 {
+			return nil, errors.Errorf("Secret %v mixes secret references with plaintext values for keys %v; all values must be references", n.GetName(), strings.Join(plaintext, ", "))
+		}
+
+		var out interface{}
+		switch f.Spec.Output {
+		case SecretProviderClassOutput:
+			out, err = f.secretProviderClass(n, refs)
+		default:
+			out, err = f.externalSecret(n, refs)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to convert Secret %v to %v", n.GetName(), f.Spec.Output)
+		}
+
+		b, err := yaml.Marshal(out)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to serialize %v %v", f.Spec.Output, n.GetName())
+		}
+		newNode, err := yaml.Parse(string(b))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to parse %v %v", f.Spec.Output, n.GetName())
+		}
+		result = append(result, newNode)
+	}
+	return result, nil
+}
+
+// readRefs returns the secret references in the Secret and the keys of the values that aren't references.
+func readRefs(n *yaml.RNode) ([]secretRef, []string, error) {
+	refs := []secretRef{}
+	plaintext := []string{}
+	for _, field := range []string{"stringData", "data"} {
+		m, err := n.Pipe(yaml.Lookup(field))
+		if err != nil {
+			return nil, nil, err
+		}
+		if m == nil {
+			continue
+		}
+		err = m.VisitFields(func(node *yaml.MapNode) error {
+			key := node.Key.YNode().Value
+			value := node.Value.YNode().Value
+			if field == "data" {
+				decoded, err := base64.StdEncoding.DecodeString(value)
+				if err != nil {
+					plaintext = append(plaintext, key)
+					return nil
+				}
+				value = string(decoded)
+			}
+			ref, ok, err := parseRef(strings.TrimSpace(value))
+			if err != nil {
+				return errors.Wrapf(err, "Key %v has an invalid reference", key)
+			}
+			if !ok {
+				plaintext = append(plaintext, key)
+				return nil
+			}
+			ref.Key = key
+			refs = append(refs, *ref)
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return refs, plaintext, nil
+}
+
+// parseRef parses value as a reference to a secret. It returns false if value isn't a reference.
+func parseRef(value string) (*secretRef, bool, error) {
+	lower := strings.ToLower(value)
+	if !strings.HasPrefix(lower, gcpScheme+"://") && !strings.HasPrefix(lower, awsScheme+"://") {
+		return nil, false, nil
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "Failed to parse %v", value)
+	}
+
+	ref := &secretRef{Property: u.Fragment}
+	pieces := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch u.Scheme {
+	case gcpScheme:
+		ref.Provider = GCPProvider
+		if (len(pieces) != 4 && len(pieces) != 6) || pieces[0] != "projects" || pieces[2] != "secrets" || (len(pieces) == 6 && pieces[4] != "versions") {
+			return nil, false, errors.Errorf("GCP secret %v must have the form gcpSecretManager:///projects/${PROJECT}/secrets/${SECRET}/versions/${VERSION}", value)
+		}
+		ref.Project = pieces[1]
+		ref.Name = pieces[3]
+		ref.Version = "latest"
+		if len(pieces) == 6 {
+			ref.Version = pieces[5]
+		}
+	case awsScheme:
+		ref.Provider = AWSProvider
+		// N.B. The names of AWS secrets can contain slashes.
+		ref.Name = strings.Trim(u.Path, "/")
+		if ref.Name == "" {
+			return nil, false, errors.Errorf("AWS secret %v must have the form awsSecretsManager:///${SECRET}", value)
+		}
+	}
+	return ref, true, nil
+}
+
+// provider returns the provider of the references. All the values of a Secret must come from the same provider.
+func provider(refs []secretRef) (string, error) {
+	p := refs[0].Provider
+	for _, r := range refs[1:] {
+		if r.Provider != p {
+			return "", errors.Errorf("values are read from both %v and %v; all the values of a Secret must come from the same provider", p, r.Provider)
+		}
+	}
+	return p, nil
+}
+
+type objectMeta struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// newObjectMeta copies the metadata of the Secret. The annotations include the path of the Secret so the resource
+// replacing it is written to the same file.
+func newObjectMeta(n *yaml.RNode) objectMeta {
+	return objectMeta{
+		Name:        n.GetName(),
+		Namespace:   n.GetNamespace(),
+		Labels:      n.GetLabels(),
+		Annotations: n.GetAnnotations(),
+	}
+}
+
+// secretType returns the type of the Secret if it isn't the default.
+func secretType(n *yaml.RNode) string {
+	t, err := n.Pipe(yaml.Lookup("type"))
+	if err != nil || t == nil || t.YNode().Value == "Opaque" {
+		return ""
+	}
+	return t.YNode().Value
+}
+
+type externalSecret struct {
+	APIVersion string             `yaml:"apiVersion"`
+	Kind       string             `yaml:"kind"`
+	Metadata   objectMeta         `yaml:"metadata"`
+	Spec       externalSecretSpec `yaml:"spec"`
+}
+
+type externalSecretSpec struct {
+	RefreshInterval string               `yaml:"refreshInterval,omitempty"`
+	SecretStoreRef  SecretStoreRef       `yaml:"secretStoreRef"`
+	Target          externalSecretTarget `yaml:"target"`
+	Data            []externalSecretData `yaml:"data"`
+}
+
+type externalSecretTarget struct {
+	Name           string                  `yaml:"name"`
+	CreationPolicy string                  `yaml:"creationPolicy"`
+	Template       *externalSecretTemplate `yaml:"template,omitempty"`
+}
+
+type externalSecretTemplate struct {
+	Type string `yaml:"type"`
+}
+
+type externalSecretData struct {
+	SecretKey string            `yaml:"secretKey"`
+	RemoteRef externalRemoteRef `yaml:"remoteRef"`
+}
+
+type externalRemoteRef struct {
+	Key      string `yaml:"key"`
+	Version  string `yaml:"version,omitempty"`
+	Property string `yaml:"property,omitempty"`
+}
+
+// externalSecret returns the ExternalSecret that creates the Secret from the references.
+func (f SecretRefsFn) externalSecret(n *yaml.RNode, refs []secretRef) (*externalSecret, error) {
+	p, err := provider(refs)
+	if err != nil {
+		return nil, err
+	}
+	store, ok := f.Spec.SecretStores[p]
+	if !ok {
+		store = defaultSecretStores[p]
+	}
+
+	es := &externalSecret{
+		APIVersion: "external-secrets.io/v1beta1",
+		Kind:       "ExternalSecret",
+		Metadata:   newObjectMeta(n),
+		Spec: externalSecretSpec{
+			RefreshInterval: f.Spec.RefreshInterval,
+			SecretStoreRef:  store,
+			Target: externalSecretTarget{
+				Name:           n.GetName(),
+				CreationPolicy: "Owner",
+			},
+			Data: make([]externalSecretData, 0, len(refs)),
+		},
+	}
+	if t := secretType(n); t != "" {
+		es.Spec.Target.Template = &externalSecretTemplate{Type: t}
+	}
+	for _, r := range refs {
+		es.Spec.Data = append(es.Spec.Data, externalSecretData{
+			SecretKey: r.Key,
+			RemoteRef: externalRemoteRef{
+				Key:      r.Name,
+				Version:  r.Version,
+				Property: r.Property,
+			},
+		})
+	}
+	return es, nil
+}
+
+type secretProviderClass struct {
+	APIVersion string                  `yaml:"apiVersion"`
+	Kind       string                  `yaml:"kind"`
+	Metadata   objectMeta              `yaml:"metadata"`
+	Spec       secretProviderClassSpec `yaml:"spec"`
+}
+
+type secretProviderClassSpec struct {
+	Provider      string            `yaml:"provider"`
+	Parameters    map[string]string `yaml:"parameters"`
+	SecretObjects []secretObject    `yaml:"secretObjects"`
+}
+
+type secretObject struct {
+	SecretName string             `yaml:"secretName"`
+	Type       string             `yaml:"type"`
+	Data       []secretObjectData `yaml:"data"`
+}
+
+type secretObjectData struct {
+	ObjectName string `yaml:"objectName"`
+	Key        string `yaml:"key"`
+}
+
+type gcpSecret struct {
+	ResourceName string `yaml:"resourceName"`
+	Path         string `yaml:"path"`
+}
+
+type awsObject struct {
+	ObjectName  string `yaml:"objectName"`
+	ObjectType  string `yaml:"objectType"`
+	ObjectAlias string `yaml:"objectAlias"`
+}
+
+// secretProviderClass returns the SecretProviderClass that mounts the referenced secrets and syncs them to the
+// Secret.
+func (f SecretRefsFn) secretProviderClass(n *yaml.RNode, refs []secretRef) (*secretProviderClass, error) {
+	p, err := provider(refs)
+	if err != nil {
+		return nil, err
+	}
+
+	t := secretType(n)
+	if t == "" {
+		t = "Opaque"
+	}
+	spc := &secretProviderClass{
+		APIVersion: "secrets-store.csi.x-k8s.io/v1",
+		Kind:       "SecretProviderClass",
+		Metadata:   newObjectMeta(n),
+		Spec: secretProviderClassSpec{
+			Provider:   p,
+			Parameters: map[string]string{},
+			SecretObjects: []secretObject{
+				{
+					SecretName: n.GetName(),
+					Type:       t,
+					Data:       make([]secretObjectData, 0, len(refs)),
+				},
+			},
+		},
+	}
+
+	gcpSecrets := []gcpSecret{}
+	awsObjects := []awsObject{}
+	for _, r := range refs {
+		if r.Property != "" {
+			return nil, errors.Errorf("key %v selects property %v; properties are only supported with output %v", r.Key, r.Property, ExternalSecretOutput)
+		}
+		switch p {
+		case GCPProvider:
+			gcpSecrets = append(gcpSecrets, gcpSecret{
+				ResourceName: fmt.Sprintf("projects/%v/secrets/%v/versions/%v", r.Project, r.Name, r.Version),
+				Path:         r.Key,
+			})
+		case AWSProvider:
+			awsObjects = append(awsObjects, awsObject{
+				ObjectName:  r.Name,
+				ObjectType:  "secretsmanager",
+				ObjectAlias: r.Key,
+			})
+		}
+		spc.Spec.SecretObjects[0].Data = append(spc.Spec.SecretObjects[0].Data, secretObjectData{ObjectName: r.Key, Key: r.Key})
+	}
+
+	var objects interface{} = gcpSecrets
+	param := "secrets"
+	if p == AWSProvider {
+		objects = awsObjects
+		param = "objects"
+		if f.Spec.AWSRegion != "" {
+			spc.Spec.Parameters["region"] = f.Spec.AWSRegion
+		}
+	}
+	b, err := yaml.Marshal(objects)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to serialize the %v parameter", param)
+	}
+	spc.Spec.Parameters[param] = string(b)
+	return spc, nil
+}
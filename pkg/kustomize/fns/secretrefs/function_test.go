@@ -0,0 +1,273 @@
+package secretrefs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	filtertest "sigs.k8s.io/kustomize/api/testutils/filtertest"
+	"sigs.k8s.io/kustomize/kyaml/kio/filters"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func Test_SecretRefs(t *testing.T) {
+	testCases := map[string]struct {
+		input          string
+		expectedOutput string
+		filter         SecretRefsFn
+	}{
+		"external-secret-gcp": {
+			input: `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+  namespace: payments
+  labels:
+    app: payments
+type: kubernetes.io/basic-auth
+stringData:
+  username: gcpSecretManager:///projects/acme/secrets/db-user/versions/3
+  password: gcpSecretManager:///projects/acme/secrets/db#password
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  url: gcpSecretManager:///projects/acme/secrets/db
+`,
+			expectedOutput: `
+apiVersion: external-secrets.io/v1beta1
+kind: ExternalSecret
+metadata:
+  name: db
+  namespace: payments
+  labels:
+    app: payments
+spec:
+  refreshInterval: 1h
+  secretStoreRef:
+    kind: ClusterSecretStore
+    name: gcp-secret-manager
+  target:
+    name: db
+    creationPolicy: Owner
+    template:
+      type: kubernetes.io/basic-auth
+  data:
+  - secretKey: username
+    remoteRef:
+      key: db-user
+      version: "3"
+  - secretKey: password
+    remoteRef:
+      key: db
+      version: latest
+      property: password
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  url: gcpSecretManager:///projects/acme/secrets/db
+`,
+			filter: SecretRefsFn{
+				Metadata: v1alpha1.Metadata{Name: "secrets"},
+				Spec:     Spec{RefreshInterval: "1h"},
+			},
+		},
+		"external-secret-aws-data": {
+			// data is base64 encoded awsSecretsManager:///prod/api-key
+			input: `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: api
+data:
+  key: YXdzU2VjcmV0c01hbmFnZXI6Ly8vcHJvZC9hcGkta2V5
+`,
+			expectedOutput: `
+apiVersion: external-secrets.io/v1beta1
+kind: ExternalSecret
+metadata:
+  name: api
+spec:
+  secretStoreRef:
+    kind: SecretStore
+    name: aws
+  target:
+    name: api
+    creationPolicy: Owner
+  data:
+  - secretKey: key
+    remoteRef:
+      key: prod/api-key
+`,
+			filter: SecretRefsFn{
+				Metadata: v1alpha1.Metadata{Name: "secrets"},
+				Spec: Spec{
+					SecretStores: map[string]SecretStoreRef{
+						AWSProvider: {Kind: "SecretStore", Name: "aws"},
+					},
+				},
+			},
+		},
+		"secret-provider-class-gcp": {
+			input: `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+stringData:
+  password: gcpSecretManager:///projects/acme/secrets/db/versions/2
+`,
+			expectedOutput: `
+apiVersion: secrets-store.csi.x-k8s.io/v1
+kind: SecretProviderClass
+metadata:
+  name: db
+spec:
+  provider: gcp
+  parameters:
+    secrets: |
+      - resourceName: projects/acme/secrets/db/versions/2
+        path: password
+  secretObjects:
+  - secretName: db
+    type: Opaque
+    data:
+    - objectName: password
+      key: password
+`,
+			filter: SecretRefsFn{
+				Metadata: v1alpha1.Metadata{Name: "secrets"},
+				Spec:     Spec{Output: SecretProviderClassOutput},
+			},
+		},
+		"plaintext-unchanged": {
+			input: `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+stringData:
+  password: hunter2
+`,
+			expectedOutput: `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+stringData:
+  password: hunter2
+`,
+			filter: SecretRefsFn{
+				Metadata: v1alpha1.Metadata{Name: "secrets"},
+			},
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			if !assert.Equal(t,
+				strings.TrimSpace(filtertest.RunFilter(t, tc.expectedOutput, filters.FormatFilter{})),
+				strings.TrimSpace(filtertest.RunFilter(t, tc.input, &pipeline{f: tc.filter}))) {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func Test_SecretRefsErrors(t *testing.T) {
+	testCases := map[string]struct {
+		input  string
+		filter SecretRefsFn
+	}{
+		"mixed-plaintext": {
+			input: `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+stringData:
+  username: admin
+  password: gcpSecretManager:///projects/acme/secrets/db
+`,
+			filter: SecretRefsFn{Metadata: v1alpha1.Metadata{Name: "secrets"}},
+		},
+		"mixed-providers": {
+			input: `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+stringData:
+  username: awsSecretsManager:///db-user
+  password: gcpSecretManager:///projects/acme/secrets/db
+`,
+			filter: SecretRefsFn{Metadata: v1alpha1.Metadata{Name: "secrets"}},
+		},
+		"require-refs": {
+			input: `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+stringData:
+  password: hunter2
+`,
+			filter: SecretRefsFn{Metadata: v1alpha1.Metadata{Name: "secrets"}, Spec: Spec{RequireRefs: true}},
+		},
+		"invalid-gcp-uri": {
+			input: `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+stringData:
+  password: gcpSecretManager:///secrets/db
+`,
+			filter: SecretRefsFn{Metadata: v1alpha1.Metadata{Name: "secrets"}},
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			nodes, err := kioParse(tc.input)
+			if err != nil {
+				t.Fatalf("Failed to parse input; %v", err)
+			}
+			if _, err := tc.filter.Filter(nodes); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+func kioParse(input string) ([]*yaml.RNode, error) {
+	n, err := yaml.Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	return []*yaml.RNode{n}, nil
+}
+
+// n.b. we need to run formatfilter on the actual output as the formatfilter causes the keys in
+// dictionaries to be sorted which is necessary otherwise output will be random
+type pipeline struct {
+	f SecretRefsFn
+}
+
+func (p pipeline) Filter(inputs []*yaml.RNode) ([]*yaml.RNode, error) {
+	outputs, err := p.f.Filter(inputs)
+	if err != nil {
+		return inputs, err
+	}
+
+	formatter := &filters.FormatFilter{}
+
+	return formatter.Filter(outputs)
+}
@@ -0,0 +1,64 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// Violation is a problem with a resource found by a validating function.
+type Violation struct {
+	// Resource identifies the resource e.g. Deployment/prod/web.
+	Resource string
+	// Path is the path of the file containing the resource.
+	Path string
+	// Field is the path of the field with the problem e.g. spec.replicas. It is empty if the problem is with the
+	// whole resource.
+	Field string
+	// Message describes the problem.
+	Message string
+}
+
+// String returns a one line description of the violation.
+func (v Violation) String() string {
+	s := v.Resource
+	if v.Field != "" {
+		s += " " + v.Field
+	}
+	return s + ": " + v.Message
+}
+
+// NewViolation returns a violation of the resource n.
+func NewViolation(n *yaml.RNode, field string, message string) Violation {
+	resource := n.GetKind()
+	if ns := n.GetNamespace(); ns != "" {
+		resource += "/" + ns
+	}
+	resource += "/" + n.GetName()
+	return Violation{
+		Resource: resource,
+		Path:     n.GetAnnotations()[kioutil.PathAnnotation],
+		Field:    field,
+		Message:  message,
+	}
+}
+
+// Error is returned by validating functions when resources violate their checks. When the Dispatcher has a
+// Report it adds the violations to the report and continues running the remaining functions so all the
+// violations are reported at once rather than only those of the first validator that fails.
+type Error struct {
+	// Function identifies the function that found the violations e.g. SchemaValidator/kubeconform.
+	Function   string
+	Violations []Violation
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	lines := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		lines = append(lines, v.String())
+	}
+	return fmt.Sprintf("%v found %d violations:\n%v", e.Function, len(e.Violations), strings.Join(lines, "\n"))
+}
@@ -0,0 +1,118 @@
+package kustomize
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jlewi/hydros/pkg/kustomize/fns/digests"
+	"github.com/jlewi/hydros/pkg/kustomize/fns/scaling"
+	"github.com/jlewi/hydros/pkg/kustomize/fns/schemas"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// PhaseAnnotation can be set on a function config to override the phase the function runs in.
+	PhaseAnnotation = "hydros.io/phase"
+	// RunOrderAnnotation can be set on a function config to order it relative to the other functions in the same
+	// phase. Functions with lower values run first. The value is an integer; the default is 0.
+	RunOrderAnnotation = "hydros.io/run-order"
+
+	// GeneratePhase is the phase of functions which generate resources e.g. HPAs. It runs first so the generated
+	// resources are modified by the functions in the later phases.
+	GeneratePhase = "generate"
+	// MutatePhase is the phase of functions which modify resources. It is the default phase.
+	MutatePhase = "mutate"
+	// ValidatePhase is the phase of functions which check the final resources. It runs last.
+	ValidatePhase = "validate"
+)
+
+// phases are the phases in the order they run.
+var phases = []string{GeneratePhase, MutatePhase, ValidatePhase}
+
+// defaultPhases are the phases of the function kinds which don't run in the MutatePhase.
+var defaultPhases = map[string]string{
+	scaling.Kind: GeneratePhase,
+	digests.Kind: ValidatePhase,
+	schemas.Kind: ValidatePhase,
+}
+
+// fnOrder is the position of a function determined by its phase and run order.
+type fnOrder struct {
+	phase    int
+	runOrder int
+}
+
+func (o fnOrder) less(other fnOrder) bool {
+	if o.phase != other.phase {
+		return o.phase < other.phase
+	}
+	return o.runOrder < other.runOrder
+}
+
+// functionOrder returns the order of the function configured by node. The phase is the value of the
+// PhaseAnnotation or else the default phase of its kind.
+func functionOrder(node *yaml.RNode) (fnOrder, error) {
+	annotations := node.GetAnnotations()
+
+	phase, ok := annotations[PhaseAnnotation]
+	if !ok {
+		phase = defaultPhases[node.GetKind()]
+	}
+	phase = strings.TrimSpace(strings.ToLower(phase))
+	if phase == "" {
+		phase = MutatePhase
+	}
+
+	o := fnOrder{phase: -1}
+	for i, p := range phases {
+		if p == phase {
+			o.phase = i
+		}
+	}
+	if o.phase < 0 {
+		return o, errors.Errorf("Function %v has invalid value for annotation %v; %v isn't one of %v", node.GetName(), PhaseAnnotation, phase, strings.Join(phases, ", "))
+	}
+
+	if val, ok := annotations[RunOrderAnnotation]; ok {
+		runOrder, err := strconv.Atoi(strings.TrimSpace(val))
+		if err != nil {
+			return o, errors.Wrapf(err, "Function %v has invalid value for annotation %v; %v isn't an integer", node.GetName(), RunOrderAnnotation, val)
+		}
+		o.runOrder = runOrder
+	}
+	return o, nil
+}
+
+// isValidating returns true if the function configured by node runs in the ValidatePhase.
+func isValidating(node *yaml.RNode) bool {
+	o, err := functionOrder(node)
+	return err == nil && phases[o.phase] == ValidatePhase
+}
+
+// functionOrders returns the order of each of the functions.
+func functionOrders(nodes []*yaml.RNode) (map[*yaml.RNode]fnOrder, error) {
+	orders := make(map[*yaml.RNode]fnOrder, len(nodes))
+	for _, n := range nodes {
+		o, err := functionOrder(n)
+		if err != nil {
+			return nil, err
+		}
+		orders[n] = o
+	}
+	return orders, nil
+}
+
+// sortByOrder sorts the functions by their phase and run order. The sort is stable so functions with the same
+// order keep the order they are in e.g. the order they appear in a file.
+func sortByOrder(nodes []*yaml.RNode) error {
+	orders, err := functionOrders(nodes)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return orders[nodes[i]].less(orders[nodes[j]])
+	})
+	return nil
+}
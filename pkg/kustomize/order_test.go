@@ -0,0 +1,100 @@
+package kustomize
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func Test_SortFnsOrder(t *testing.T) {
+	type testCase struct {
+		name     string
+		fns      []string
+		expected []string
+		wantErr  bool
+	}
+
+	// fn returns the config of a function in path whose name identifies it.
+	fn := func(kind string, name string, path string, annotations string) string {
+		return `apiVersion: hydros.dev/v1alpha1
+kind: ` + kind + `
+metadata:
+  name: ` + name + `
+  annotations:
+    ` + kioutil.PathAnnotation + `: ` + path + `
+    ` + kioutil.IndexAnnotation + `: "0"
+` + annotations
+	}
+
+	cases := []testCase{
+		{
+			name: "depth",
+			fns: []string{
+				fn("CommonLabels", "shallow", "fns.yaml", ""),
+				fn("CommonLabels", "deep", "a/b/fns.yaml", ""),
+			},
+			expected: []string{"deep", "shallow"},
+		},
+		{
+			name: "phases",
+			fns: []string{
+				fn("ImageDigestPolicy", "validate", "a/b/digests.yaml", ""),
+				fn("CommonLabels", "mutate", "a/b/labels.yaml", ""),
+				fn("CommonLabels", "generate", "ai.yaml", "    hydros.io/phase: generate\n"),
+				fn("ScalingGenerator", "scaling", "scaling.yaml", ""),
+			},
+			expected: []string{"generate", "scaling", "mutate", "validate"},
+		},
+		{
+			name: "run-order",
+			fns: []string{
+				fn("CommonLabels", "deep", "a/b/fns.yaml", ""),
+				fn("CommonLabels", "last", "fns.yaml", "    hydros.io/run-order: \"10\"\n"),
+				fn("CommonLabels", "first", "last.yaml", "    hydros.io/run-order: \"-1\"\n"),
+			},
+			expected: []string{"first", "deep", "last"},
+		},
+		{
+			name:    "invalid-phase",
+			fns:     []string{fn("CommonLabels", "bad", "fns.yaml", "    hydros.io/phase: cleanup\n")},
+			wantErr: true,
+		},
+		{
+			name:    "invalid-run-order",
+			fns:     []string{fn("CommonLabels", "bad", "fns.yaml", "    hydros.io/run-order: first\n")},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			buff := kio.PackageBuffer{}
+			for _, f := range c.fns {
+				buff.Nodes = append(buff.Nodes, yaml.MustParse(f))
+			}
+
+			d := &Dispatcher{}
+			err := d.SortFns(buff)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SortFns failed; %v", err)
+			}
+
+			actual := []string{}
+			for _, n := range buff.Nodes {
+				actual = append(actual, n.GetName())
+			}
+			if d := cmp.Diff(c.expected, actual); d != "" {
+				t.Errorf("Unexpected order; diff:\n%v", d)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package kustomize
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jlewi/hydros/pkg/kustomize/fns/validation"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// FunctionResult is the result of applying a function.
+type FunctionResult struct {
+	Kind string
+	Name string
+	// Path is the path of the function config.
+	Path string
+	// Files is the number of files the function was applied to.
+	Files int
+	// Modified is the number of resources the function modified or added.
+	Modified int
+	Duration time.Duration
+	Err      error
+}
+
+// Violation is a violation found by a validating function.
+type Violation struct {
+	// Kind and Name identify the function that found the violation.
+	Kind string
+	Name string
+	validation.Violation
+}
+
+// Report collects the results of the functions applied by a Dispatcher so reviewers can tell which functions
+// produced which changes.
+type Report struct {
+	mu      sync.Mutex
+	Results []FunctionResult
+	// Violations are the violations found by the functions in the validate phase.
+	Violations []Violation
+}
+
+func (r *Report) add(result FunctionResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Results = append(r.Results, result)
+}
+
+func (r *Report) addViolations(kind string, name string, violations []validation.Violation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range violations {
+		r.Violations = append(r.Violations, Violation{Kind: kind, Name: name, Violation: v})
+	}
+}
+
+// ValidationErr returns an error if the validating functions found any violations.
+func (r *Report) ValidationErr() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.Violations) == 0 {
+		return nil
+	}
+	lines := make([]string, 0, len(r.Violations))
+	for _, v := range r.Violations {
+		lines = append(lines, fmt.Sprintf("%v/%v: %v", v.Kind, v.Name, v.Violation.String()))
+	}
+	return errors.Errorf("Validating functions found %d violations:\n%v", len(r.Violations), strings.Join(lines, "\n"))
+}
+
+// Markdown returns the results as a markdown table followed by a table of the violations, if any. It returns
+// the empty string if no functions were applied.
+func (r *Report) Markdown() string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.Results) == 0 && len(r.Violations) == 0 {
+		return ""
+	}
+
+	lines := []string{
+		"Functions:",
+		"| Function | Config | Files | Resources Modified | Duration |",
+		"| --- | --- | --- | --- | --- |",
+	}
+	for _, res := range r.Results {
+		modified := fmt.Sprintf("%v", res.Modified)
+		if res.Err != nil {
+			modified = "failed"
+		}
+		lines = append(lines, fmt.Sprintf("| %v/%v | %v | %v | %v | %v |", res.Kind, res.Name, res.Path, res.Files, modified, res.Duration.Round(time.Millisecond)))
+	}
+	if len(r.Violations) > 0 {
+		lines = append(lines,
+			"",
+			fmt.Sprintf("Violations (%d):", len(r.Violations)),
+			"| Function | Resource | File | Field | Message |",
+			"| --- | --- | --- | --- | --- |",
+		)
+		for _, v := range r.Violations {
+			lines = append(lines, fmt.Sprintf("| %v/%v | %v | %v | %v | %v |", v.Kind, v.Name, v.Resource, v.Path, v.Field, strings.ReplaceAll(v.Message, "|", "\\|")))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// reportingFilter wraps a filter and adds the result of applying it to the report.
+type reportingFilter struct {
+	filter kio.Filter
+	report *Report
+	kind   string
+	name   string
+	path   string
+}
+
+// Filter applies the wrapped filter to the nodes.
+func (f *reportingFilter) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	files := map[string]bool{}
+	before := map[*yaml.RNode]string{}
+	for _, n := range nodes {
+		files[n.GetAnnotations()[kioutil.PathAnnotation]] = true
+		before[n] = nodeString(n)
+	}
+
+	start := time.Now()
+	out, err := f.filter.Filter(nodes)
+	result := FunctionResult{
+		Kind:     f.kind,
+		Name:     f.name,
+		Path:     f.path,
+		Files:    len(files),
+		Duration: time.Since(start),
+		Err:      err,
+	}
+
+	if err == nil {
+		for _, n := range out {
+			if s, ok := before[n]; !ok || s != nodeString(n) {
+				result.Modified++
+			}
+		}
+	}
+	f.report.add(result)
+	return out, err
+}
+
+// nodeString returns the YAML of the node. Errors are ignored since the result is only used to detect changes.
+func nodeString(n *yaml.RNode) string {
+	s, _ := n.String()
+	return s
+}
+
+// validatingFilter wraps a function in the ValidatePhase. If the function returns a validation.Error the
+// violations are added to the report and the nodes are passed through unchanged so the remaining validators
+// still run; the caller checks Report.ValidationErr once all the functions have run.
+type validatingFilter struct {
+	filter kio.Filter
+	report *Report
+	kind   string
+	name   string
+}
+
+// Filter applies the wrapped filter to the nodes.
+func (f *validatingFilter) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	out, err := f.filter.Filter(nodes)
+	if err == nil {
+		return out, nil
+	}
+	var verr *validation.Error
+	if !errors.As(err, &verr) {
+		return out, err
+	}
+	f.report.addViolations(f.kind, f.name, verr.Violations)
+	return nodes, nil
+}
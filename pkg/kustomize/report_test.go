@@ -0,0 +1,118 @@
+package kustomize
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jlewi/hydros/pkg/kustomize/fns/validation"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func Test_reportingFilter(t *testing.T) {
+	newNode := func(name string, path string) *yaml.RNode {
+		n, err := yaml.Parse("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: " + name + "\n  annotations:\n    " + kioutil.PathAnnotation + ": " + path + "\n")
+		if err != nil {
+			t.Fatalf("Failed to parse node; %v", err)
+		}
+		return n
+	}
+
+	nodes := []*yaml.RNode{
+		newNode("a", "a.yaml"),
+		newNode("b", "b.yaml"),
+		newNode("c", "b.yaml"),
+	}
+
+	// Modify a single resource.
+	inner := kio.FilterFunc(func(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+		return nodes, nodes[0].PipeE(yaml.SetLabel("env", "dev"))
+	})
+
+	report := &Report{}
+	f := &reportingFilter{filter: inner, report: report, kind: "CommonLabels", name: "env", path: "fns/labels.yaml"}
+	if _, err := f.Filter(nodes); err != nil {
+		t.Fatalf("Filter failed; %v", err)
+	}
+
+	if len(report.Results) != 1 {
+		t.Fatalf("Expected 1 result; got %v", len(report.Results))
+	}
+
+	result := report.Results[0]
+	if result.Files != 2 {
+		t.Errorf("Files: got %v; want 2", result.Files)
+	}
+	if result.Modified != 1 {
+		t.Errorf("Modified: got %v; want 1", result.Modified)
+	}
+
+	report.Results[0].Duration = 12 * time.Millisecond
+	expected := `Functions:
+| Function | Config | Files | Resources Modified | Duration |
+| --- | --- | --- | --- | --- |
+| CommonLabels/env | fns/labels.yaml | 2 | 1 | 12ms |`
+	if actual := report.Markdown(); actual != expected {
+		t.Errorf("Got\n%v\nwant\n%v", actual, expected)
+	}
+
+	var empty *Report
+	if actual := empty.Markdown(); actual != "" {
+		t.Errorf("Expected no report for a nil Report; got %v", actual)
+	}
+}
+
+func Test_validatingFilter(t *testing.T) {
+	n, err := yaml.Parse("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n  annotations:\n    " + kioutil.PathAnnotation + ": a.yaml\n")
+	if err != nil {
+		t.Fatalf("Failed to parse node; %v", err)
+	}
+	nodes := []*yaml.RNode{n}
+
+	inner := kio.FilterFunc(func(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+		return nil, &validation.Error{
+			Function:   "SchemaValidator/kubeconform",
+			Violations: []validation.Violation{validation.NewViolation(nodes[0], "data.enabled", "expected a string; got boolean true")},
+		}
+	})
+
+	report := &Report{}
+	if err := report.ValidationErr(); err != nil {
+		t.Fatalf("Expected no error before any violations; got %v", err)
+	}
+
+	// Validation errors are added to the report and the nodes are passed through.
+	f := &validatingFilter{filter: inner, report: report, kind: "SchemaValidator", name: "kubeconform"}
+	out, err := f.Filter(nodes)
+	if err != nil {
+		t.Fatalf("Filter failed; %v", err)
+	}
+	if len(out) != 1 || out[0] != n {
+		t.Errorf("Expected the nodes to be passed through; got %v", out)
+	}
+	if err := report.ValidationErr(); err == nil {
+		t.Errorf("Expected an error for the violations")
+	}
+
+	expected := `Functions:
+| Function | Config | Files | Resources Modified | Duration |
+| --- | --- | --- | --- | --- |
+
+Violations (1):
+| Function | Resource | File | Field | Message |
+| --- | --- | --- | --- | --- |
+| SchemaValidator/kubeconform | ConfigMap/a | a.yaml | data.enabled | expected a string; got boolean true |`
+	if actual := report.Markdown(); actual != expected {
+		t.Errorf("Got\n%v\nwant\n%v", actual, expected)
+	}
+
+	// Other errors fail the function.
+	failing := &validatingFilter{filter: kio.FilterFunc(func(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+		return nil, errors.New("function crashed")
+	}), report: report, kind: "SchemaValidator", name: "kubeconform"}
+	if _, err := failing.Filter(nodes); err == nil {
+		t.Errorf("Expected the error to be returned")
+	}
+}
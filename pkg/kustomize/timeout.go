@@ -0,0 +1,80 @@
+package kustomize
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// TimeoutAnnotation can be set on a function config to override the timeout of the Dispatcher for that
+	// function. The value is a duration e.g. "30s".
+	TimeoutAnnotation = "hydros.dev/timeout"
+
+	// DefaultFunctionTimeout is the timeout used by hydros when applying functions.
+	DefaultFunctionTimeout = 5 * time.Minute
+)
+
+// ContextFilter is a filter that supports cancellation. Functions which make network calls should implement it
+// so they stop when the function times out.
+type ContextFilter interface {
+	kio.Filter
+	FilterContext(ctx context.Context, nodes []*yaml.RNode) ([]*yaml.RNode, error)
+}
+
+// timeoutFilter wraps a filter and returns an error if it doesn't complete within the timeout.
+type timeoutFilter struct {
+	filter  kio.Filter
+	kind    string
+	name    string
+	timeout time.Duration
+}
+
+type filterResult struct {
+	nodes []*yaml.RNode
+	err   error
+}
+
+// Filter applies the wrapped filter to the nodes.
+func (f *timeoutFilter) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+
+	// N.B. The channel is buffered so the goroutine can exit if we stop waiting for it.
+	// Filters which don't implement ContextFilter can't be stopped and keep running in the background until they
+	// complete. Since the pipeline fails the nodes they modify are never written.
+	done := make(chan filterResult, 1)
+	go func() {
+		var r filterResult
+		if cf, ok := f.filter.(ContextFilter); ok {
+			r.nodes, r.err = cf.FilterContext(ctx, nodes)
+		} else {
+			r.nodes, r.err = f.filter.Filter(nodes)
+		}
+		done <- r
+	}()
+
+	select {
+	case r := <-done:
+		return r.nodes, r.err
+	case <-ctx.Done():
+		return nil, errors.Errorf("Function %v %v timed out after %v", f.kind, f.name, f.timeout)
+	}
+}
+
+// functionTimeout returns the timeout to use for the function with the given annotations.
+// Zero means there is no timeout.
+func (d *Dispatcher) functionTimeout(annotations map[string]string) (time.Duration, error) {
+	val, ok := annotations[TimeoutAnnotation]
+	if !ok {
+		return d.Timeout, nil
+	}
+	timeout, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Invalid value for annotation %v; %v isn't a duration", TimeoutAnnotation, val)
+	}
+	return timeout, nil
+}
@@ -0,0 +1,113 @@
+package kustomize
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// blockingFilter blocks until it is cancelled or unblocked.
+type blockingFilter struct {
+	unblock   chan bool
+	cancelled chan bool
+}
+
+func (f *blockingFilter) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	<-f.unblock
+	return nodes, nil
+}
+
+func (f *blockingFilter) FilterContext(ctx context.Context, nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	<-ctx.Done()
+	f.cancelled <- true
+	return nil, ctx.Err()
+}
+
+// plainFilter hides the FilterContext method of blockingFilter.
+type plainFilter struct {
+	f *blockingFilter
+}
+
+func (f plainFilter) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	return f.f.Filter(nodes)
+}
+
+func Test_timeoutFilter(t *testing.T) {
+	b := &blockingFilter{unblock: make(chan bool), cancelled: make(chan bool, 1)}
+	defer close(b.unblock)
+
+	type testCase struct {
+		name   string
+		filter kio.Filter
+	}
+
+	cases := []testCase{
+		{name: "context", filter: b},
+		{name: "noContext", filter: plainFilter{f: b}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := &timeoutFilter{filter: c.filter, kind: "PodEnvs", name: "slow", timeout: 10 * time.Millisecond}
+			_, err := f.Filter([]*yaml.RNode{})
+			if err == nil {
+				t.Fatalf("Expected the filter to time out")
+			}
+			if !strings.Contains(err.Error(), "PodEnvs slow timed out") {
+				t.Errorf("Error doesn't report the function that timed out; got %v", err)
+			}
+		})
+	}
+
+	select {
+	case <-b.cancelled:
+	case <-time.After(time.Second):
+		t.Errorf("ContextFilter wasn't cancelled")
+	}
+
+	f := &timeoutFilter{filter: kio.FilterFunc(func(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+		return nodes, nil
+	}), kind: "PodEnvs", name: "fast", timeout: time.Minute}
+	if _, err := f.Filter([]*yaml.RNode{}); err != nil {
+		t.Errorf("Expected the filter to succeed; got %v", err)
+	}
+}
+
+func Test_functionTimeout(t *testing.T) {
+	d := Dispatcher{Timeout: time.Minute}
+
+	type testCase struct {
+		name        string
+		annotations map[string]string
+		expected    time.Duration
+		expectErr   bool
+	}
+
+	cases := []testCase{
+		{name: "default", annotations: map[string]string{}, expected: time.Minute},
+		{name: "override", annotations: map[string]string{TimeoutAnnotation: "30s"}, expected: 30 * time.Second},
+		{name: "invalid", annotations: map[string]string{TimeoutAnnotation: "soon"}, expectErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := d.functionTimeout(c.annotations)
+			if c.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("functionTimeout failed; %v", err)
+			}
+			if actual != c.expected {
+				t.Errorf("Got %v; want %v", actual, c.expected)
+			}
+		})
+	}
+}
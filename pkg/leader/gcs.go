@@ -0,0 +1,132 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/jlewi/monogo/gcp/gcs"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// GCSLock is a lock for leader election stored as a JSON object in GCS. It can be used when hydros isn't running
+// in Kubernetes. Updates use the generation of the object as a precondition so only one replica can acquire the
+// lock at a time.
+type GCSLock struct {
+	object   *storage.ObjectHandle
+	uri      string
+	identity string
+
+	mu sync.Mutex
+	// generation is the generation of the object returned by the last call to Get.
+	generation int64
+}
+
+var _ resourcelock.Interface = &GCSLock{}
+
+// NewGCSLock creates a lock stored in the object at uri e.g. gs://my-bucket/hydros/leader.json.
+// identity identifies this replica e.g. its hostname.
+func NewGCSLock(client *storage.Client, uri string, identity string) (*GCSLock, error) {
+	if client == nil {
+		return nil, errors.New("client is required")
+	}
+	if identity == "" {
+		return nil, errors.New("identity is required")
+	}
+	p, err := gcs.Parse(uri)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid GCS lock %v", uri)
+	}
+	if p.Path == "" {
+		return nil, errors.Errorf("Invalid GCS lock %v; it must be an object e.g. gs://bucket/path", uri)
+	}
+	return &GCSLock{
+		object:   client.Bucket(p.Bucket).Object(p.Path),
+		uri:      uri,
+		identity: identity,
+	}, nil
+}
+
+// Get returns the current leader election record. It returns a NotFound error if the object doesn't exist.
+func (l *GCSLock) Get(ctx context.Context) (*resourcelock.LeaderElectionRecord, []byte, error) {
+	r, err := l.object.NewReader(ctx)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return nil, nil, apierrors.NewNotFound(schema.GroupResource{Group: "storage.googleapis.com", Resource: "objects"}, l.uri)
+		}
+		return nil, nil, errors.Wrapf(err, "Failed to read lock %v", l.uri)
+	}
+	defer r.Close()
+
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Failed to read lock %v", l.uri)
+	}
+	record := &resourcelock.LeaderElectionRecord{}
+	if err := json.Unmarshal(raw, record); err != nil {
+		return nil, nil, errors.Wrapf(err, "Failed to decode lock %v", l.uri)
+	}
+
+	l.mu.Lock()
+	l.generation = r.Attrs.Generation
+	l.mu.Unlock()
+	return record, raw, nil
+}
+
+// Create creates the lock. It fails if the object already exists.
+func (l *GCSLock) Create(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	return l.write(ctx, ler, storage.Conditions{DoesNotExist: true})
+}
+
+// Update updates the lock. It fails if the object changed since the last call to Get.
+func (l *GCSLock) Update(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	l.mu.Lock()
+	generation := l.generation
+	l.mu.Unlock()
+	if generation == 0 {
+		return errors.New("GCSLock not initialized; call Get first")
+	}
+	return l.write(ctx, ler, storage.Conditions{GenerationMatch: generation})
+}
+
+func (l *GCSLock) write(ctx context.Context, ler resourcelock.LeaderElectionRecord, conditions storage.Conditions) error {
+	raw, err := json.Marshal(ler)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to encode lock %v", l.uri)
+	}
+
+	w := l.object.If(conditions).NewWriter(ctx)
+	w.ContentType = "application/json"
+	if _, err := w.Write(raw); err != nil {
+		w.Close()
+		return errors.Wrapf(err, "Failed to write lock %v", l.uri)
+	}
+	// N.B. Preconditions are checked when the writer is closed.
+	if err := w.Close(); err != nil {
+		return errors.Wrapf(err, "Failed to write lock %v", l.uri)
+	}
+
+	l.mu.Lock()
+	l.generation = w.Attrs().Generation
+	l.mu.Unlock()
+	return nil
+}
+
+// RecordEvent is a no-op; there is no object to attach events to.
+func (l *GCSLock) RecordEvent(string) {}
+
+// Identity returns the identity of this replica.
+func (l *GCSLock) Identity() string {
+	return l.identity
+}
+
+// Describe returns a description of the lock.
+func (l *GCSLock) Describe() string {
+	return fmt.Sprintf("gcs:%v", l.uri)
+}
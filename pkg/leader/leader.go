@@ -0,0 +1,153 @@
+// Package leader implements leader election so multiple replicas of the hydros server can run for high
+// availability with only the leader running reconcilers.
+package leader
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// DefaultLeaseDuration is how long followers wait before trying to acquire a lease that hasn't been renewed.
+	DefaultLeaseDuration = 15 * time.Second
+	// DefaultRenewDeadline is how long the leader keeps trying to renew the lease before giving up leadership.
+	DefaultRenewDeadline = 10 * time.Second
+	// DefaultRetryPeriod is how long to wait between attempts to acquire or renew the lease.
+	DefaultRetryPeriod = 2 * time.Second
+)
+
+// Option is an option for the Elector.
+type Option func(e *Elector) error
+
+// WithDurations overrides the default lease duration, renew deadline and retry period.
+func WithDurations(leaseDuration time.Duration, renewDeadline time.Duration, retryPeriod time.Duration) Option {
+	return func(e *Elector) error {
+		e.leaseDuration = leaseDuration
+		e.renewDeadline = renewDeadline
+		e.retryPeriod = retryPeriod
+		return nil
+	}
+}
+
+// Elector elects a leader among the replicas that share a lock. The lock can be a Kubernetes Lease
+// (see NewLeaseLock) or an object in GCS (see NewGCSLock).
+type Elector struct {
+	lock          resourcelock.Interface
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+
+	leader atomic.Bool
+	log    logr.Logger
+	// done is closed once the elector stops participating in elections after Start's context is cancelled.
+	done chan struct{}
+
+	mu sync.Mutex
+	// onStoppedLeading are called when this replica stops being the leader.
+	onStoppedLeading []func()
+}
+
+// New creates a new Elector.
+func New(lock resourcelock.Interface, opts ...Option) (*Elector, error) {
+	if lock == nil {
+		return nil, errors.New("lock is required")
+	}
+	e := &Elector{
+		lock:          lock,
+		leaseDuration: DefaultLeaseDuration,
+		renewDeadline: DefaultRenewDeadline,
+		retryPeriod:   DefaultRetryPeriod,
+		log:           zapr.NewLogger(zap.L()).WithValues("lock", lock.Describe(), "identity", lock.Identity()),
+		done:          make(chan struct{}),
+	}
+	for _, o := range opts {
+		if err := o(e); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// IsLeader returns true if this replica currently holds the lock.
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// OnStoppedLeading registers f to be called when this replica stops being the leader e.g. to cancel work in
+// progress that the new leader will redo. f is also called when the lock is released because the context passed
+// to Start is cancelled.
+func (e *Elector) OnStoppedLeading(f func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onStoppedLeading = append(e.onStoppedLeading, f)
+}
+
+// stoppedLeading records that this replica isn't the leader and calls the OnStoppedLeading functions if it was.
+func (e *Elector) stoppedLeading() {
+	// N.B. client-go calls OnStoppedLeading whenever an election ends even if this replica never led.
+	if !e.leader.Swap(false) {
+		return
+	}
+	e.log.Info("Stopped leading")
+	e.mu.Lock()
+	fns := append([]func(){}, e.onStoppedLeading...)
+	e.mu.Unlock()
+	for _, f := range fns {
+		f()
+	}
+}
+
+// Start participates in leader elections in the background until ctx is cancelled. If this replica loses
+// leadership it keeps participating so it can become the leader again. When ctx is cancelled the lock is
+// released if this replica holds it.
+func (e *Elector) Start(ctx context.Context) error {
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            e.lock,
+		LeaseDuration:   e.leaseDuration,
+		RenewDeadline:   e.renewDeadline,
+		RetryPeriod:     e.retryPeriod,
+		ReleaseOnCancel: true,
+		Name:            e.lock.Describe(),
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				e.log.Info("Started leading")
+				e.leader.Store(true)
+			},
+			OnStoppedLeading: e.stoppedLeading,
+			OnNewLeader: func(identity string) {
+				e.log.Info("Leader elected", "leader", identity)
+			},
+		},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create leader elector")
+	}
+
+	go func() {
+		defer close(e.done)
+		for {
+			// Run returns when ctx is cancelled or this replica stops being the leader. If ctx is cancelled
+			// the lock is released before Run returns.
+			le.Run(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Wait blocks until the elector stops after the context passed to Start is cancelled. Once it returns the lock
+// has been released so another replica can become the leader without waiting for the lease to expire.
+func (e *Elector) Wait() {
+	<-e.done
+}
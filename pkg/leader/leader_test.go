@@ -0,0 +1,69 @@
+package leader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+func Test_Elector(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	newElector := func(identity string) *Elector {
+		lock, err := resourcelock.New(resourcelock.LeasesResourceLock, "hydros", "hydros-leader", client.CoreV1(), client.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: identity})
+		if err != nil {
+			t.Fatalf("Failed to create lock; %v", err)
+		}
+		e, err := New(lock, WithDurations(2*time.Second, time.Second, 100*time.Millisecond))
+		if err != nil {
+			t.Fatalf("Failed to create elector; %v", err)
+		}
+		return e
+	}
+
+	waitFor := func(e *Elector, want bool) {
+		for end := time.Now().Add(10 * time.Second); time.Now().Before(end); time.Sleep(50 * time.Millisecond) {
+			if e.IsLeader() == want {
+				return
+			}
+		}
+		t.Fatalf("Timed out waiting for IsLeader to be %v", want)
+	}
+
+	first := newElector("first")
+	stopped := make(chan bool, 10)
+	first.OnStoppedLeading(func() { stopped <- true })
+	firstCtx, firstCancel := context.WithCancel(context.Background())
+	defer firstCancel()
+	if err := first.Start(firstCtx); err != nil {
+		t.Fatalf("Failed to start elector; %v", err)
+	}
+	waitFor(first, true)
+
+	second := newElector("second")
+	secondCtx, secondCancel := context.WithCancel(context.Background())
+	defer secondCancel()
+	if err := second.Start(secondCtx); err != nil {
+		t.Fatalf("Failed to start elector; %v", err)
+	}
+
+	// The second replica shouldn't become the leader while the first holds the lease.
+	time.Sleep(500 * time.Millisecond)
+	if second.IsLeader() {
+		t.Fatalf("Second replica became the leader while the first holds the lease")
+	}
+
+	// Stopping the first replica releases the lease so the second should take over.
+	firstCancel()
+	first.Wait()
+	if first.IsLeader() {
+		t.Fatalf("First replica is still the leader after it stopped")
+	}
+	if len(stopped) != 1 {
+		t.Errorf("Expected the OnStoppedLeading functions to be called once; got %v", len(stopped))
+	}
+	waitFor(second, true)
+}
@@ -0,0 +1,166 @@
+// Package policy checks manifests against Rego policies.
+package policy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jlewi/hydros/pkg/kustomize/fns/validation"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// DenyQuery is the query evaluated for each resource. Like conftest the rules named deny in package main return
+// the messages of the violations of the resource which is the input.
+const DenyQuery = "data.main.deny"
+
+// disallowedBuiltins are the builtins policies can't use. Policies come from the repositories being synced so
+// they can't make network requests e.g. to exfiltrate the manifests or read the environment of the server.
+var disallowedBuiltins = map[string]bool{
+	ast.HTTPSend.Name:        true,
+	ast.NetLookupIPAddr.Name: true,
+	ast.OPARuntime.Name:      true,
+}
+
+// capabilities returns the capabilities of the current version of OPA without the disallowed builtins.
+func capabilities() *ast.Capabilities {
+	c := ast.CapabilitiesForThisVersion()
+	builtins := make([]*ast.Builtin, 0, len(c.Builtins))
+	for _, b := range c.Builtins {
+		if !disallowedBuiltins[b.Name] {
+			builtins = append(builtins, b)
+		}
+	}
+	c.Builtins = builtins
+	// An empty list means no hosts are allowed.
+	c.AllowNet = []string{}
+	return c
+}
+
+// Checker checks resources against a set of policies.
+type Checker struct {
+	query rego.PreparedEvalQuery
+}
+
+// NewChecker creates a checker for the policies in the .rego files in dir and its subdirectories. Policies using
+// builtins which make network requests or read the runtime environment e.g. http.send fail to compile.
+func NewChecker(ctx context.Context, dir string) (*Checker, error) {
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(path) == ".rego" && !strings.HasSuffix(path, "_test.rego") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to find policies in %v", dir)
+	}
+	if len(files) == 0 {
+		return nil, errors.Errorf("%v doesn't have any .rego files", dir)
+	}
+
+	opts := []func(*rego.Rego){rego.Query(DenyQuery), rego.Capabilities(capabilities())}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read policy %v", f)
+		}
+		opts = append(opts, rego.Module(f, string(b)))
+	}
+	query, err := rego.New(opts...).PrepareForEval(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to compile the policies in %v", dir)
+	}
+	return &Checker{query: query}, nil
+}
+
+// Check returns the violations of the resources.
+func (c *Checker) Check(ctx context.Context, nodes []*yaml.RNode) ([]validation.Violation, error) {
+	violations := []validation.Violation{}
+	for _, n := range nodes {
+		input, err := policyInput(n)
+		if err != nil {
+			return nil, err
+		}
+		results, err := c.query.Eval(ctx, rego.EvalInput(input))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to evaluate the policies for %v/%v", n.GetKind(), n.GetName())
+		}
+		messages := []string{}
+		for _, r := range results {
+			for _, e := range r.Expressions {
+				messages = append(messages, denyMessages(e.Value)...)
+			}
+		}
+		sort.Strings(messages)
+		for _, m := range messages {
+			violations = append(violations, validation.NewViolation(n, "", m))
+		}
+	}
+	return violations, nil
+}
+
+// CheckDir returns the violations of the resources in the YAML files in dir. The paths of the violations are
+// relative to dir.
+func (c *Checker) CheckDir(ctx context.Context, dir string) ([]validation.Violation, error) {
+	nodes, err := (&kio.LocalPackageReader{PackagePath: dir}).Read()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read the resources in %v", dir)
+	}
+	return c.Check(ctx, nodes)
+}
+
+// policyInput returns the resource as the input to the policies. The annotations added when the resource was
+// read are removed so the input is the resource as it is in the file.
+func policyInput(n *yaml.RNode) (map[string]interface{}, error) {
+	input := n.Copy()
+	for _, a := range []string{kioutil.PathAnnotation, kioutil.LegacyPathAnnotation, kioutil.IndexAnnotation, kioutil.LegacyIndexAnnotation, kioutil.IdAnnotation, kioutil.LegacyIdAnnotation} {
+		if err := input.PipeE(yaml.ClearAnnotation(a)); err != nil {
+			return nil, errors.Wrapf(err, "Failed to clear annotation %v", a)
+		}
+	}
+	if err := yaml.ClearEmptyAnnotations(input); err != nil {
+		return nil, errors.Wrapf(err, "Failed to clear empty annotations")
+	}
+	m, err := input.Map()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to convert %v/%v to a map", n.GetKind(), n.GetName())
+	}
+	return m, nil
+}
+
+// denyMessages returns the messages in the value of the deny rules. Like conftest the messages can be strings or
+// objects with a msg field.
+func denyMessages(value interface{}) []string {
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	messages := make([]string, 0, len(values))
+	for _, v := range values {
+		switch m := v.(type) {
+		case string:
+			messages = append(messages, m)
+		case map[string]interface{}:
+			if msg, ok := m["msg"].(string); ok {
+				messages = append(messages, msg)
+				continue
+			}
+			messages = append(messages, fmt.Sprintf("%v", m))
+		default:
+			messages = append(messages, fmt.Sprintf("%v", m))
+		}
+	}
+	return messages
+}
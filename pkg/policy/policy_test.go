@@ -0,0 +1,155 @@
+package policy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/pkg/kustomize/fns/validation"
+)
+
+const policies = `package main
+
+deny[msg] {
+	input.kind == "Deployment"
+	c := input.spec.template.spec.containers[_]
+	not c.resources.limits
+	msg := sprintf("container %v doesn't set resource limits", [c.name])
+}
+
+deny[{"msg": msg}] {
+	v := input.spec.template.spec.volumes[_]
+	v.hostPath
+	msg := sprintf("volume %v uses a hostPath", [v.name])
+}
+
+deny[msg] {
+	input.metadata.annotations["config.kubernetes.io/path"]
+	msg := "annotations added by hydros are visible to policies"
+}
+`
+
+const manifests = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: nginx
+      - name: sidecar
+        image: envoy
+        resources:
+          limits:
+            cpu: 1
+      volumes:
+      - name: logs
+        hostPath:
+          path: /var/log
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+`
+
+func Test_Checker(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"policies/deployments.rego":      policies,
+		"policies/deployments_test.rego": "package main\n\ntest_invalid {\n",
+		"hydrated/prod/web.yaml":         manifests,
+	}
+	for name, contents := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("Could not create directory %v", err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatalf("Could not write file %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	c, err := NewChecker(ctx, filepath.Join(dir, "policies"))
+	if err != nil {
+		t.Fatalf("Failed to create checker; %+v", err)
+	}
+	violations, err := c.CheckDir(ctx, filepath.Join(dir, "hydrated"))
+	if err != nil {
+		t.Fatalf("Failed to check manifests; %+v", err)
+	}
+
+	expected := []validation.Violation{
+		{Resource: "Deployment/prod/web", Path: "prod/web.yaml", Message: "container web doesn't set resource limits"},
+		{Resource: "Deployment/prod/web", Path: "prod/web.yaml", Message: "volume logs uses a hostPath"},
+	}
+	if d := cmp.Diff(expected, violations); d != "" {
+		t.Errorf("Unexpected violations; diff:\n%v", d)
+	}
+
+	if _, err := NewChecker(ctx, filepath.Join(dir, "hydrated")); err == nil {
+		t.Errorf("Expected an error for a directory without policies")
+	}
+}
+
+func Test_NewCheckerDisallowedBuiltins(t *testing.T) {
+	type testCase struct {
+		name   string
+		policy string
+	}
+
+	cases := []testCase{
+		{
+			name: "http.send",
+			policy: `package main
+
+deny[msg] {
+	resp := http.send({"method": "get", "url": "https://example.com"})
+	msg := resp.body
+}
+`,
+		},
+		{
+			name: "net.lookup_ip_addr",
+			policy: `package main
+
+deny[msg] {
+	addrs := net.lookup_ip_addr("example.com")
+	msg := sprintf("%v", [addrs])
+}
+`,
+		},
+		{
+			name: "opa.runtime",
+			policy: `package main
+
+deny[msg] {
+	msg := sprintf("%v", [opa.runtime().env])
+}
+`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "policy.rego"), []byte(c.policy), 0o644); err != nil {
+				t.Fatalf("Could not write file %v", err)
+			}
+			_, err := NewChecker(context.Background(), dir)
+			if err == nil {
+				t.Fatalf("Expected policy using %v to fail to compile", c.name)
+			}
+			if !strings.Contains(err.Error(), c.name) {
+				t.Errorf("Expected the error to name %v; got %v", c.name, err)
+			}
+		})
+	}
+}
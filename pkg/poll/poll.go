@@ -0,0 +1,180 @@
+// Package poll provides a helper to wait for a condition by polling it. Polling backs off so that long waits
+// don't keep hitting APIs every few seconds and can be woken up early by events e.g. webhooks; polling is then just
+// a fallback in case the event is never delivered.
+package poll
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultInterval = 5 * time.Second
+	// defaultTimeout is used when neither the context nor the options set a deadline.
+	defaultTimeout = 10 * time.Minute
+)
+
+// ErrTimeout is returned by Until when the deadline is reached before the condition is satisfied.
+var ErrTimeout = errors.New("Timed out waiting for the condition")
+
+// ConditionFunc checks the condition. It returns true if the condition is satisfied. A non nil error stops
+// polling and is returned by Until; conditions should log transient errors and return false, nil to keep polling.
+type ConditionFunc func(ctx context.Context) (bool, error)
+
+// Option is an option for Until.
+type Option func(p *poller)
+
+// WithInterval sets how long to wait before checking the condition again. It defaults to 5 seconds.
+func WithInterval(interval time.Duration) Option {
+	return func(p *poller) {
+		p.interval = interval
+	}
+}
+
+// WithBackoff multiplies the interval by factor after each check up to maxInterval.
+func WithBackoff(factor float64, maxInterval time.Duration) Option {
+	return func(p *poller) {
+		p.factor = factor
+		p.maxInterval = maxInterval
+	}
+}
+
+// WithTimeout sets how long to wait for the condition. If the context has an earlier deadline the context's
+// deadline is used. If neither is set Until waits up to 10 minutes.
+func WithTimeout(timeout time.Duration) Option {
+	return func(p *poller) {
+		p.timeout = timeout
+	}
+}
+
+// WithWakeup checks the condition as soon as a value is received on wakeup rather than waiting for the interval
+// to elapse. Closing wakeup wakes up the poller once.
+func WithWakeup(wakeup <-chan struct{}) Option {
+	return func(p *poller) {
+		p.wakeup = wakeup
+	}
+}
+
+// WithSignal checks the condition every time s is notified rather than waiting for the interval to elapse.
+func WithSignal(s *Signal) Option {
+	return func(p *poller) {
+		p.signal = s
+	}
+}
+
+type poller struct {
+	interval    time.Duration
+	factor      float64
+	maxInterval time.Duration
+	timeout     time.Duration
+	wakeup      <-chan struct{}
+	signal      *Signal
+}
+
+// wakeupChan returns the channel to wait on for wakeups. The channel of a signal must be obtained before checking
+// the condition so notifications sent while checking the condition aren't missed.
+func (p *poller) wakeupChan() <-chan struct{} {
+	if p.signal != nil {
+		return p.signal.C()
+	}
+	return p.wakeup
+}
+
+// Until checks condition until it is satisfied, it returns an error, the deadline is reached or ctx is done.
+// The condition is checked immediately and then after each interval. The condition is always checked one last time
+// at the deadline. When the deadline is reached the error is ErrTimeout; if ctx is done it is ctx.Err().
+func Until(ctx context.Context, condition ConditionFunc, opts ...Option) error {
+	p := &poller{
+		interval: defaultInterval,
+	}
+	for _, o := range opts {
+		o(p)
+	}
+
+	deadline := time.Now().Add(defaultTimeout)
+	if p.timeout > 0 {
+		deadline = time.Now().Add(p.timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (p.timeout <= 0 || d.Before(deadline)) {
+		deadline = d
+	}
+
+	interval := p.interval
+	for {
+		wakeup := p.wakeupChan()
+		done, err := condition(ctx)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return ErrTimeout
+		}
+		wait := interval
+		if wait > remaining {
+			wait = remaining
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if time.Now().Before(deadline) {
+				return ctx.Err()
+			}
+			return ErrTimeout
+		case _, ok := <-wakeup:
+			timer.Stop()
+			if !ok && p.signal == nil {
+				// A closed channel would wake us up immediately from now on.
+				p.wakeup = nil
+			}
+		case <-timer.C:
+		}
+
+		if p.factor > 1 {
+			interval = time.Duration(float64(interval) * p.factor)
+			if p.maxInterval > 0 && interval > p.maxInterval {
+				interval = p.maxInterval
+			}
+		}
+	}
+}
+
+// IsTimeout returns true if err was caused by Until timing out.
+func IsTimeout(err error) bool {
+	return errors.Cause(err) == ErrTimeout
+}
+
+// Signal wakes up the pollers waiting on it. Unlike a channel a Signal can wake up any number of pollers any
+// number of times.
+type Signal struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// C returns a channel that is closed the next time Notify is called.
+func (s *Signal) C() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch == nil {
+		s.ch = make(chan struct{})
+	}
+	return s.ch
+}
+
+// Notify wakes up all the pollers waiting on the channels returned by C.
+func (s *Signal) Notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch != nil {
+		close(s.ch)
+		s.ch = nil
+	}
+}
@@ -0,0 +1,117 @@
+package poll
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func Test_Until(t *testing.T) {
+	calls := 0
+	err := Until(context.Background(), func(ctx context.Context) (bool, error) {
+		calls++
+		return calls == 3, nil
+	}, WithInterval(time.Millisecond))
+	if err != nil {
+		t.Fatalf("Until failed; %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected the condition to be checked 3 times; got %v", calls)
+	}
+}
+
+func Test_UntilError(t *testing.T) {
+	expected := errors.New("permanent error")
+	err := Until(context.Background(), func(ctx context.Context) (bool, error) {
+		return false, expected
+	}, WithInterval(time.Millisecond))
+	if err != expected {
+		t.Errorf("Got error %v; want %v", err, expected)
+	}
+}
+
+func Test_UntilTimeout(t *testing.T) {
+	calls := 0
+	start := time.Now()
+	err := Until(context.Background(), func(ctx context.Context) (bool, error) {
+		calls++
+		return false, nil
+	}, WithInterval(time.Hour), WithTimeout(50*time.Millisecond))
+	if !IsTimeout(err) {
+		t.Fatalf("Expected a timeout; got %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("Until didn't stop at the deadline")
+	}
+	// The condition should be checked when Until starts and one last time at the deadline.
+	if calls != 2 {
+		t.Errorf("Expected the condition to be checked 2 times; got %v", calls)
+	}
+}
+
+func Test_UntilCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	err := Until(ctx, func(ctx context.Context) (bool, error) {
+		cancel()
+		return false, nil
+	}, WithInterval(time.Hour))
+	if err != context.Canceled {
+		t.Errorf("Got error %v; want %v", err, context.Canceled)
+	}
+}
+
+func Test_UntilBackoff(t *testing.T) {
+	times := []time.Time{}
+	err := Until(context.Background(), func(ctx context.Context) (bool, error) {
+		times = append(times, time.Now())
+		return len(times) == 5, nil
+	}, WithInterval(time.Millisecond), WithBackoff(2, 4*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Until failed; %v", err)
+	}
+	// Intervals are 1ms, 2ms, 4ms, 4ms so waiting takes at least 11ms.
+	if d := times[4].Sub(times[0]); d < 11*time.Millisecond {
+		t.Errorf("Expected polling to back off; polled 5 times in %v", d)
+	}
+}
+
+func Test_UntilSignal(t *testing.T) {
+	s := &Signal{}
+	ready := make(chan struct{})
+	calls := 0
+	go func() {
+		<-ready
+		s.Notify()
+	}()
+	err := Until(context.Background(), func(ctx context.Context) (bool, error) {
+		calls++
+		if calls == 1 {
+			// Notifying while the condition is checked shouldn't be missed.
+			close(ready)
+			time.Sleep(10 * time.Millisecond)
+		}
+		return calls == 2, nil
+	}, WithInterval(time.Hour), WithTimeout(time.Minute), WithSignal(s))
+	if err != nil {
+		t.Fatalf("Until failed; %v", err)
+	}
+}
+
+func Test_UntilClosedWakeup(t *testing.T) {
+	wakeup := make(chan struct{})
+	close(wakeup)
+	calls := 0
+	err := Until(context.Background(), func(ctx context.Context) (bool, error) {
+		calls++
+		return false, nil
+	}, WithInterval(time.Hour), WithTimeout(50*time.Millisecond), WithWakeup(wakeup))
+	if !IsTimeout(err) {
+		t.Fatalf("Expected a timeout; got %v", err)
+	}
+	// The closed channel should wake up the poller once and then the deadline is reached.
+	if calls != 3 {
+		t.Errorf("Expected the condition to be checked 3 times; got %v", calls)
+	}
+}
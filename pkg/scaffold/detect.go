@@ -0,0 +1,229 @@
+// Package scaffold generates starter hydros resources for a repository. It inspects the repository to detect
+// the kustomize overlays, Dockerfiles and image registries it uses and generates commented ManifestSync, Image
+// and hydros.yaml files from them so users have something to edit rather than starting from a blank page.
+package scaffold
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/jlewi/hydros/pkg/github/ghrepo"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	kustomize "sigs.k8s.io/kustomize/api/types"
+)
+
+const (
+	kustomizationFile = "kustomization.yaml"
+	dockerfile        = "Dockerfile"
+)
+
+var (
+	// skipDirs are directories that never contain sources we want to scaffold resources for.
+	skipDirs = map[string]bool{
+		".git":         true,
+		"node_modules": true,
+		"vendor":       true,
+	}
+
+	// imageLine matches the image of a container in a YAML manifest.
+	imageLine = regexp.MustCompile(`^\s*(?:-\s+)?image:\s*["']?([^"'\s#]+)`)
+)
+
+// Repo is what Detect found in a repository.
+type Repo struct {
+	// Root is the root of the repository.
+	Root string
+	// Org and Name identify the GitHub repository of the origin remote. They are empty if it isn't on GitHub.
+	Org  string
+	Name string
+	// Branch is the checked out branch.
+	Branch string
+	// Overlays are the directories, relative to Root, of the kustomizations that no other kustomization uses.
+	// These are the kustomizations that would be hydrated.
+	Overlays []string
+	// OverlayLabels are the labels of the overlays keyed by overlay.
+	OverlayLabels map[string]map[string]string
+	// Dockerfiles are the paths, relative to Root, of the Dockerfiles.
+	Dockerfiles []string
+	// Registries are the registries of the images used by the manifests, most used first.
+	Registries []string
+	// Images are the images, without tags, used by the manifests that are in Registries.
+	Images []string
+	// HasManifests is true if the repository has YAML manifests that aren't part of a kustomization.
+	HasManifests bool
+}
+
+// Detect inspects the repository at root.
+func Detect(root string) (*Repo, error) {
+	r := &Repo{
+		Root:          root,
+		OverlayLabels: map[string]map[string]string{},
+	}
+	if err := r.detectGit(); err != nil {
+		return nil, err
+	}
+
+	kustomizations := map[string]*kustomize.Kustomization{}
+	// used are the directories used as resources or components by other kustomizations.
+	used := map[string]bool{}
+	registries := map[string]int{}
+	images := map[string]bool{}
+	addImage := func(image string) {
+		ref, err := util.ParseImageURL(image)
+		if err != nil || !strings.ContainsAny(ref.Registry, ".:") {
+			// Skip images on Docker Hub e.g. nginx or library/nginx since they aren't built by the repository.
+			return
+		}
+		registries[ref.Registry]++
+		images[ref.Registry+"/"+ref.Repo] = true
+	}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != root && (skipDirs[info.Name()] || strings.HasPrefix(info.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to compute path of %v relative to %v", path, root)
+		}
+
+		if info.Name() == dockerfile || strings.HasSuffix(info.Name(), "."+dockerfile) {
+			r.Dockerfiles = append(r.Dockerfiles, rPath)
+			return nil
+		}
+
+		ext := strings.ToLower(filepath.Ext(info.Name()))
+		if ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to read %v", path)
+		}
+
+		if info.Name() != kustomizationFile {
+			r.HasManifests = true
+			for _, l := range strings.Split(string(data), "\n") {
+				if m := imageLine.FindStringSubmatch(l); m != nil {
+					addImage(m[1])
+				}
+			}
+			return nil
+		}
+
+		k := &kustomize.Kustomization{}
+		if err := yaml.Unmarshal(data, k); err != nil {
+			return errors.Wrapf(err, "Failed to parse kustomization %v", path)
+		}
+		dir := filepath.Dir(path)
+		kustomizations[dir] = k
+		for _, res := range append(append([]string{}, k.Resources...), k.Components...) {
+			used[filepath.Join(dir, res)] = true
+		}
+		for _, i := range k.Images {
+			if i.NewName != "" {
+				addImage(i.NewName)
+			} else {
+				addImage(i.Name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to inspect repository %v", root)
+	}
+
+	for dir, k := range kustomizations {
+		if used[dir] {
+			continue
+		}
+		rDir, err := filepath.Rel(root, dir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to compute path of %v relative to %v", dir, root)
+		}
+		r.Overlays = append(r.Overlays, rDir)
+		if k.MetaData != nil && len(k.MetaData.Labels) > 0 {
+			r.OverlayLabels[rDir] = k.MetaData.Labels
+		}
+	}
+	sort.Strings(r.Overlays)
+
+	for reg := range registries {
+		r.Registries = append(r.Registries, reg)
+	}
+	sort.Slice(r.Registries, func(i, j int) bool {
+		a, b := r.Registries[i], r.Registries[j]
+		if registries[a] != registries[b] {
+			return registries[a] > registries[b]
+		}
+		return a < b
+	})
+	for i := range images {
+		r.Images = append(r.Images, i)
+	}
+	sort.Strings(r.Images)
+	return r, nil
+}
+
+// detectGit sets the GitHub repository and branch from the git repository at r.Root.
+func (r *Repo) detectGit() error {
+	repo, err := git.PlainOpen(r.Root)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to open git repository %v", r.Root)
+	}
+
+	// N.B. Read HEAD without resolving it so we get the branch of repositories without commits too.
+	head, err := repo.Storer.Reference(plumbing.HEAD)
+	if err == nil && head.Type() == plumbing.SymbolicReference && head.Target().IsBranch() {
+		r.Branch = head.Target().Short()
+	}
+
+	remote, err := repo.Remote("origin")
+	if err == git.ErrRemoteNotFound {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get origin remote of %v", r.Root)
+	}
+	for _, u := range remote.Config().URLs {
+		if gh := parseGitHubURL(u); gh != nil {
+			r.Org = gh.RepoOwner()
+			r.Name = gh.RepoName()
+			return nil
+		}
+	}
+	return nil
+}
+
+// parseGitHubURL parses the URL of a GitHub remote e.g. https://github.com/jlewi/hydros.git or
+// git@github.com:jlewi/hydros.git. It returns nil if u isn't a GitHub remote.
+func parseGitHubURL(u string) ghrepo.Interface {
+	if user, rest, ok := strings.Cut(u, "@"); ok && !strings.Contains(user, "://") {
+		// Convert the scp like syntax used for ssh remotes to a URL.
+		u = "ssh://" + user + "@" + strings.Replace(rest, ":", "/", 1)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Hostname() != "github.com" {
+		return nil
+	}
+	gh, err := ghrepo.FromURL(parsed)
+	if err != nil {
+		return nil
+	}
+	return gh
+}
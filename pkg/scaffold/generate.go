@@ -0,0 +1,419 @@
+package scaffold
+
+import (
+	"bytes"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ManifestSyncFile, ImagesFile and ConfigFile are the paths, relative to the root of the repository, of the
+	// generated files.
+	ManifestSyncFile = "manifestsync.yaml"
+	ImagesFile       = "images.yaml"
+	ConfigFile       = "hydros.yaml"
+)
+
+// Options are the choices that go into the generated files. Use DefaultOptions to fill them in from a Repo.
+type Options struct {
+	// Name is the name of the ManifestSync.
+	Name string
+	// Org, Repo and Branch are the GitHub repository and branch containing the manifests.
+	Org    string
+	Repo   string
+	Branch string
+	// DestOrg, DestRepo and DestBranch are the GitHub repository and branch to check the hydrated manifests into.
+	DestOrg    string
+	DestRepo   string
+	DestBranch string
+	// DestPath is the directory in DestRepo to write the hydrated manifests to.
+	DestPath string
+	// ImageRepository is the repository the images built from the Dockerfiles are pushed to
+	// e.g. us-west1-docker.pkg.dev/acme/images.
+	ImageRepository string
+	// Project is the GCP project to run the image builds in.
+	Project string
+}
+
+// DefaultOptions returns the options inferred from r.
+func DefaultOptions(r *Repo) Options {
+	o := Options{
+		Name:       r.Name,
+		Org:        r.Org,
+		Repo:       r.Name,
+		Branch:     r.Branch,
+		DestOrg:    r.Org,
+		DestBranch: "main",
+	}
+	if o.Name == "" {
+		o.Name = filepath.Base(r.Root)
+	}
+	if o.Branch == "" {
+		o.Branch = "main"
+	}
+
+	// Push images next to the images the manifests already use.
+	for _, reg := range r.Registries {
+		for _, i := range r.Images {
+			if strings.HasPrefix(i, reg+"/") {
+				o.ImageRepository = path.Dir(i)
+				break
+			}
+		}
+		if o.ImageRepository != "" {
+			break
+		}
+	}
+	o.SetDefaults()
+	return o
+}
+
+// SetDefaults sets the options that are derived from other options if they aren't set. DestRepo and DestPath
+// are derived from Name and Project is derived from ImageRepository.
+func (o *Options) SetDefaults() {
+	if o.DestRepo == "" {
+		o.DestRepo = o.Name + "-hydrated"
+	}
+	if o.DestPath == "" {
+		o.DestPath = o.Name
+	}
+	if o.Project == "" {
+		o.Project = gcpProject(o.ImageRepository)
+	}
+}
+
+// gcpProject returns the GCP project of an Artifact Registry or Container Registry repository. It returns the
+// empty string for other registries.
+func gcpProject(repository string) string {
+	pieces := strings.Split(repository, "/")
+	if len(pieces) < 2 {
+		return ""
+	}
+	if strings.HasSuffix(pieces[0], "-docker.pkg.dev") || pieces[0] == "gcr.io" || strings.HasSuffix(pieces[0], ".gcr.io") {
+		return pieces[1]
+	}
+	return ""
+}
+
+// Generate returns the contents of the files for r keyed by their path relative to the root of the repository.
+// images.yaml is only generated if the repository has Dockerfiles.
+func Generate(r *Repo, o Options) (map[string]string, error) {
+	files := map[string]string{}
+
+	ms, err := render(manifestSyncTemplate, newManifestSyncData(r, o))
+	if err != nil {
+		return nil, err
+	}
+	m := &v1alpha1.ManifestSync{}
+	if err := yaml.Unmarshal([]byte(ms), m); err != nil {
+		return nil, errors.Wrapf(err, "Generated ManifestSync isn't valid YAML:\n%v", ms)
+	}
+	if err := m.IsValid(); err != nil {
+		return nil, errors.Wrapf(err, "Generated ManifestSync isn't valid:\n%v", ms)
+	}
+	files[ManifestSyncFile] = ms
+
+	if len(r.Dockerfiles) > 0 {
+		docs := make([]string, 0, len(r.Dockerfiles))
+		names := map[string]string{}
+		for _, d := range r.Dockerfiles {
+			data := newImageData(r, o, d)
+			if other, ok := names[data.Name]; ok {
+				return nil, errors.Errorf("Dockerfiles %v and %v would both build image %v; move or rename one of them", other, d, data.Name)
+			}
+			names[data.Name] = d
+			doc, err := render(imageTemplate, data)
+			if err != nil {
+				return nil, err
+			}
+			image := &v1alpha1.Image{}
+			if err := yaml.Unmarshal([]byte(doc), image); err != nil {
+				return nil, errors.Wrapf(err, "Generated Image isn't valid YAML:\n%v", doc)
+			}
+			docs = append(docs, doc)
+		}
+		files[ImagesFile] = strings.Join(docs, "---\n")
+	}
+
+	config, err := render(configTemplate, o)
+	if err != nil {
+		return nil, err
+	}
+	c := &v1alpha1.HydrosConfig{}
+	if err := yaml.Unmarshal([]byte(config), c); err != nil {
+		return nil, errors.Wrapf(err, "Generated config isn't valid YAML:\n%v", config)
+	}
+	files[ConfigFile] = config
+	return files, nil
+}
+
+type manifestSyncData struct {
+	Options
+	// SourcePath is the longest directory containing all the overlays.
+	SourcePath string
+	// Plain is true if the manifests aren't kustomize packages.
+	Plain bool
+	// Labels are the labels to select the overlays with.
+	Labels map[string]string
+	// LabelsFrom is the overlay the labels were copied from. It is empty if no overlay has labels.
+	LabelsFrom string
+	Overlays   []string
+	Registries []string
+}
+
+func newManifestSyncData(r *Repo, o Options) manifestSyncData {
+	d := manifestSyncData{
+		Options:    o,
+		Plain:      len(r.Overlays) == 0 && r.HasManifests,
+		Overlays:   r.Overlays,
+		Registries: r.Registries,
+	}
+
+	d.SourcePath = "/" + commonDir(r.Overlays)
+	for _, overlay := range r.Overlays {
+		if labels, ok := r.OverlayLabels[overlay]; ok {
+			d.Labels = labels
+			d.LabelsFrom = overlay
+			break
+		}
+	}
+	if d.Labels == nil && !d.Plain {
+		d.Labels = map[string]string{"app": o.Name}
+	}
+	return d
+}
+
+// commonDir returns the longest directory containing all of dirs. It returns the empty string for the root.
+func commonDir(dirs []string) string {
+	if len(dirs) == 0 {
+		return ""
+	}
+	common := strings.Split(filepath.ToSlash(dirs[0]), "/")
+	for _, d := range dirs[1:] {
+		pieces := strings.Split(filepath.ToSlash(d), "/")
+		n := 0
+		for n < len(common) && n < len(pieces) && common[n] == pieces[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	// An overlay is a directory so don't return it as the common directory when there is only one.
+	if len(dirs) == 1 && len(common) > 0 {
+		common = common[:len(common)-1]
+	}
+	c := strings.Join(common, "/")
+	if c == "." {
+		return ""
+	}
+	return c
+}
+
+type imageData struct {
+	Options
+	Name       string
+	Dockerfile string
+	// Dir is the directory containing the Dockerfile relative to the root of the repository. It is the empty
+	// string for the root.
+	Dir string
+	// BuildDockerfile is the path of the Dockerfile in the build context if it isn't named Dockerfile.
+	BuildDockerfile string
+	Image           string
+}
+
+func newImageData(r *Repo, o Options, dockerfilePath string) imageData {
+	d := imageData{
+		Options:    o,
+		Dockerfile: filepath.ToSlash(dockerfilePath),
+	}
+	d.Dir = path.Dir(d.Dockerfile)
+	if d.Dir == "." {
+		d.Dir = ""
+	}
+	base := path.Base(d.Dockerfile)
+	if base != dockerfile {
+		d.BuildDockerfile = base
+	}
+
+	switch {
+	case strings.HasSuffix(base, "."+dockerfile):
+		d.Name = strings.TrimSuffix(base, "."+dockerfile)
+	case d.Dir != "":
+		d.Name = path.Base(d.Dir)
+	default:
+		d.Name = o.Name
+	}
+
+	// Reuse the image the manifests already use if there is one with the same name.
+	for _, i := range r.Images {
+		if path.Base(i) == d.Name {
+			d.Image = i
+			return d
+		}
+	}
+	repository := o.ImageRepository
+	if repository == "" {
+		repository = "REGISTRY/REPOSITORY"
+	}
+	d.Image = repository + "/" + d.Name
+	return d
+}
+
+func render(tmpl *template.Template, data interface{}) (string, error) {
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", errors.Wrapf(err, "Failed to render %v", tmpl.Name())
+	}
+	return b.String(), nil
+}
+
+// quote returns v as a YAML scalar quoting it if necessary.
+func quote(v string) (string, error) {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to marshal %v", v)
+	}
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
+
+// sortedKeys returns the keys of m in order so the output is deterministic.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var funcs = template.FuncMap{
+	"quote":      quote,
+	"sortedKeys": sortedKeys,
+	"orPlaceholder": func(v string, placeholder string) string {
+		if v == "" {
+			return placeholder
+		}
+		return v
+	},
+}
+
+var manifestSyncTemplate = template.Must(template.New(ManifestSyncFile).Funcs(funcs).Parse(`# ManifestSync {{.Name}} hydrates the manifests in {{.Org}}/{{.Repo}} and opens PRs to check them into
+# {{.DestOrg}}/{{.DestRepo}}. Apply it with: hydros apply manifestsync.yaml
+# See https://github.com/jlewi/hydros/blob/main/docs/hydrating_manifests.md
+apiVersion: hydros.dev/v1alpha1
+kind: ManifestSync
+metadata:
+  name: {{quote .Name}}
+spec:
+  # sourceRepo is the repository and branch containing the manifests to hydrate.
+  sourceRepo:
+    org: {{quote (orPlaceholder .Org "ORG")}}
+    repo: {{quote (orPlaceholder .Repo "REPO")}}
+    branch: {{quote .Branch}}
+  # forkRepo is where hydros pushes the hydrated manifests before opening a PR. It can be the same as destRepo.
+  forkRepo:
+    org: {{quote (orPlaceholder .DestOrg "ORG")}}
+    repo: {{quote .DestRepo}}
+    branch: {{quote (printf "hydros/%v" .Name)}}
+  # destRepo is the repository and branch the PRs are opened against.
+  destRepo:
+    org: {{quote (orPlaceholder .DestOrg "ORG")}}
+    repo: {{quote .DestRepo}}
+    branch: {{quote .DestBranch}}
+  # sourcePath is the directory, relative to the root of sourceRepo, to search for manifests.
+  sourcePath: {{quote .SourcePath}}
+{{- if .Plain}}
+  # The manifests are plain YAML rather than kustomize packages so all of them are hydrated.
+  sourceFormat: yaml
+{{- else}}
+{{- if .LabelsFrom}}
+  # selector selects the kustomizations to hydrate by their metadata.labels. These labels were copied from
+  # {{.LabelsFrom}}.
+{{- else}}
+  # selector selects the kustomizations to hydrate by their metadata.labels. None of the overlays have labels
+  # yet so add these labels to the ones to hydrate.
+{{- end}}
+{{- if .Overlays}}
+  # Overlays found:
+{{- range .Overlays}}
+  #  - {{.}}
+{{- end}}
+{{- end}}
+  selector:
+    matchLabels:
+{{- $labels := .Labels}}
+{{- range sortedKeys $labels}}
+      {{quote .}}: {{quote (index $labels .)}}
+{{- end}}
+{{- end}}
+  # destPath is the directory in destRepo to write the hydrated manifests to. It is recreated on every sync so
+  # don't share it with other ManifestSyncs.
+  destPath: {{quote .DestPath}}
+{{- if .Registries}}
+  # imageRegistries limits pinning to images in the registries the manifests use.
+  imageRegistries:
+{{- range .Registries}}
+    - {{quote .}}
+{{- end}}
+{{- end}}
+  # imageTagsToPin replaces images with these tags with the digest the tag currently points at. Use the
+  # sourceCommit strategy instead to pin images built from the source commit by an Image resource.
+  imageTagsToPin:
+    - tags:
+        - latest
+      strategy: mutableTag
+`))
+
+var imageTemplate = template.Must(template.New(ImagesFile).Funcs(funcs).Parse(`# Image {{.Name}} builds {{.Dockerfile}} using Cloud Build. Build it with: hydros build -f images.yaml
+# See https://github.com/jlewi/hydros/blob/main/docs/image_build.md
+kind: Image
+apiVersion: hydros.dev/v1alpha1
+metadata:
+  name: {{quote .Name}}
+spec:
+  image: {{quote .Image}}
+  source:
+    - uri: {{quote (printf "https://github.com/%v/%v.git" (orPlaceholder .Org "ORG") (orPlaceholder .Repo "REPO"))}}
+      # Only files matched by the mappings are in the build context. Run hydros build --output to check it.
+      mappings:
+{{- if .Dir}}
+        - src: {{quote (printf "%v/**/*" .Dir)}}
+          strip: {{quote .Dir}}
+{{- else}}
+        - src: {{quote .Dockerfile}}
+        # Add the files the Dockerfile copies e.g.
+        # - src: "cmd/**/*.go"
+{{- end}}
+  builder:
+    gcb:
+      project: {{quote (orPlaceholder .Project "PROJECT")}}
+      # bucket is the GCS bucket to store the build contexts and logs in.
+      bucket: {{quote (printf "%v_cloudbuild" (orPlaceholder .Project "PROJECT"))}}
+{{- if .BuildDockerfile}}
+      dockerfile: {{quote .BuildDockerfile}}
+{{- end}}
+`))
+
+var configTemplate = template.Must(template.New(ConfigFile).Funcs(funcs).Parse(`# hydros.yaml configures the hydros GitHub App on this repository.
+# See https://github.com/jlewi/hydros/blob/main/docs/setup.md
+apiVersion: hydros.dev/v1alpha1
+kind: HydrosConfig
+metadata:
+  name: {{quote .Name}}
+spec:
+  # inPlaceConfigs apply the KRM functions in the repository and check the results back into it. Uncomment this
+  # to have hydros open PRs against {{.Branch}} whenever it changes.
+  inPlaceConfigs: []
+  # inPlaceConfigs:
+  #   - baseBranch: {{.Branch}}
+  #     prBranch: hydros/{{.Branch}}
+  #     autoMerge: true
+  #     # paths are the directories to search for KRM functions. Leave it empty to search the whole repository.
+  #     paths: []
+`))
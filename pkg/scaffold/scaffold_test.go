@@ -0,0 +1,158 @@
+package scaffold
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/hydros/pkg/validate"
+)
+
+// newRepo creates a git repository containing files.
+func newRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, contents := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("Failed to create directory; %v", err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatalf("Failed to write %v; %v", p, err)
+		}
+	}
+	for _, args := range [][]string{
+		{"init", "--initial-branch=dev"},
+		{"remote", "add", "origin", "git@github.com:acme/web.git"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = root
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed; %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	return root
+}
+
+func Test_DetectAndGenerate(t *testing.T) {
+	root := newRepo(t, map[string]string{
+		"manifests/base/kustomization.yaml": "resources:\n- deployment.yaml\n",
+		"manifests/base/deployment.yaml":    "kind: Deployment\nspec:\n  template:\n    spec:\n      containers:\n      - name: web\n        image: us-west1-docker.pkg.dev/acme/images/web:latest\n      - name: proxy\n        image: nginx:1.25\n",
+		"manifests/dev/kustomization.yaml":  "metadata:\n  labels:\n    env: dev\nresources:\n- ../base\n",
+		"manifests/prod/kustomization.yaml": "resources:\n- ../base\n",
+		"web/Dockerfile":                    "FROM scratch\n",
+		"worker.Dockerfile":                 "FROM scratch\n",
+		".github/workflows/ci.yaml":         "jobs: {}\n",
+	})
+
+	r, err := Detect(root)
+	if err != nil {
+		t.Fatalf("Detect failed; %+v", err)
+	}
+	expected := &Repo{
+		Root:          root,
+		Org:           "acme",
+		Name:          "web",
+		Branch:        "dev",
+		Overlays:      []string{"manifests/dev", "manifests/prod"},
+		OverlayLabels: map[string]map[string]string{"manifests/dev": {"env": "dev"}},
+		Dockerfiles:   []string{"web/Dockerfile", "worker.Dockerfile"},
+		Registries:    []string{"us-west1-docker.pkg.dev"},
+		Images:        []string{"us-west1-docker.pkg.dev/acme/images/web"},
+		HasManifests:  true,
+	}
+	if d := cmp.Diff(expected, r); d != "" {
+		t.Fatalf("Unexpected repo; diff:\n%v", d)
+	}
+
+	opts := DefaultOptions(r)
+	if opts.ImageRepository != "us-west1-docker.pkg.dev/acme/images" || opts.Project != "acme" {
+		t.Errorf("Unexpected image options; got %+v", opts)
+	}
+
+	files, err := Generate(r, opts)
+	if err != nil {
+		t.Fatalf("Generate failed; %+v", err)
+	}
+
+	out := t.TempDir()
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(out, name), []byte(contents), 0o644); err != nil {
+			t.Fatalf("Failed to write %v; %v", name, err)
+		}
+	}
+	fieldErrs, err := validate.Paths([]string{out})
+	if err != nil {
+		t.Fatalf("Failed to validate generated files; %v", err)
+	}
+	if len(fieldErrs) > 0 {
+		t.Errorf("Generated files are invalid; %v", fieldErrs)
+	}
+
+	for name, wants := range map[string][]string{
+		ManifestSyncFile: {
+			"sourcePath: /manifests",
+			"env: dev",
+			"- us-west1-docker.pkg.dev",
+		},
+		ImagesFile: {
+			"image: us-west1-docker.pkg.dev/acme/images/web",
+			"strip: web",
+			"image: us-west1-docker.pkg.dev/acme/images/worker",
+			"dockerfile: worker.Dockerfile",
+			"bucket: acme_cloudbuild",
+		},
+		ConfigFile: {
+			"kind: HydrosConfig",
+		},
+	} {
+		for _, want := range wants {
+			if !strings.Contains(files[name], want) {
+				t.Errorf("%v doesn't contain %q; got:\n%v", name, want, files[name])
+			}
+		}
+	}
+}
+
+func Test_GeneratePlainManifests(t *testing.T) {
+	root := newRepo(t, map[string]string{
+		"deploy/service.yaml": "kind: Service\n",
+	})
+	r, err := Detect(root)
+	if err != nil {
+		t.Fatalf("Detect failed; %+v", err)
+	}
+	files, err := Generate(r, DefaultOptions(r))
+	if err != nil {
+		t.Fatalf("Generate failed; %+v", err)
+	}
+	if !strings.Contains(files[ManifestSyncFile], "sourceFormat: yaml") {
+		t.Errorf("Expected plain YAML source format; got:\n%v", files[ManifestSyncFile])
+	}
+	if _, ok := files[ImagesFile]; ok {
+		t.Errorf("images.yaml shouldn't be generated without Dockerfiles")
+	}
+}
+
+func Test_commonDir(t *testing.T) {
+	cases := map[string]struct {
+		dirs     []string
+		expected string
+	}{
+		"none":   {dirs: nil, expected: ""},
+		"single": {dirs: []string{"manifests/dev"}, expected: "manifests"},
+		"root":   {dirs: []string{"dev"}, expected: ""},
+		"shared": {dirs: []string{"apps/web/dev", "apps/web/prod", "apps/api/dev"}, expected: "apps"},
+		"nested": {dirs: []string{"apps/web/dev", "apps/web/dev/us"}, expected: "apps/web/dev"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			if actual := commonDir(c.dirs); actual != c.expected {
+				t.Errorf("Got %q; want %q", actual, c.expected)
+			}
+		})
+	}
+}
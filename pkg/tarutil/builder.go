@@ -8,37 +8,91 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/bmatcuk/doublestar/v4"
 	"github.com/go-logr/zapr"
 	"github.com/jlewi/hydros/api/v1alpha1"
-	"github.com/jlewi/monogo/files"
 	"github.com/jlewi/hydros/pkg/util"
+	"github.com/jlewi/monogo/files"
 	mutil "github.com/jlewi/monogo/util"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
+// BuildOption is an option for building a tarball.
+type BuildOption func(o *buildOptions)
+
+type buildOptions struct {
+	reproducible bool
+	maxSize      int64
+	stats        *Stats
+}
+
+// WithReproducible builds the tarball in reproducible mode. In addition to the normalization done for local
+// files, the timestamps and ownership of entries copied from tarballs are cleared, modes are normalized to 0644
+// or 0755 and the entries of all sources are sorted by name. This way identical sources produce byte for byte
+// identical tarballs which can be cached by their hash.
+func WithReproducible() BuildOption {
+	return func(o *buildOptions) {
+		o.reproducible = true
+	}
+}
+
+// WithMaxSize fails building the tarball once the total size of its files, before compression, exceeds maxSize
+// bytes. The error is a *SizeError. The header of the file exceeding the limit isn't written so a large file is
+// caught before it is uploaded.
+func WithMaxSize(maxSize int64) BuildOption {
+	return func(o *buildOptions) {
+		o.maxSize = maxSize
+	}
+}
+
+// WithStats stores the size of the tarball and its largest files in stats. If there is an error the stats of
+// the files added so far are stored.
+func WithStats(stats *Stats) BuildOption {
+	return func(o *buildOptions) {
+		o.stats = stats
+	}
+}
+
 // Build builds an archive from the manifest
 // basePath is the basePath to resolve relative paths against
 // tarball is the path to the tarball to create
 // fileSource is a list of files to include in the tarball
 // tarSource is a list of tarballs and corresponding matches to include
-func Build(tarSources []*v1alpha1.ImageSource, tarFilePath string) error {
+// Sources can be local paths (file://) or prefixes in GCS (gs://) or S3 (s3://).
+func Build(tarSources []*v1alpha1.ImageSource, tarFilePath string, opts ...BuildOption) error {
+	_, err := BuildWithMatches(tarSources, tarFilePath, opts...)
+	return err
+}
+
+// BuildWithMatches builds the archive like Build and returns the number of files matched by each mapping;
+// matches[i][j] is the number of files matched by mapping j of source i. Mappings that don't match any files
+// are logged; they are errors if the source is strict. If there is an error the matches of the sources
+// added so far are returned.
+func BuildWithMatches(tarSources []*v1alpha1.ImageSource, tarFilePath string, opts ...BuildOption) ([][]int, error) {
 	log := zapr.NewLogger(zap.L())
 
+	options := &buildOptions{}
+	for _, o := range opts {
+		o(options)
+	}
+
 	factory := &files.Factory{}
 
 	helper, err := factory.Get(tarFilePath)
 
 	if err != nil {
-		return errors.Wrapf(err, "Error creating helper for %v", tarFilePath)
+		return nil, errors.Wrapf(err, "Error creating helper for %v", tarFilePath)
 	}
 
 	w, err := helper.NewWriter(tarFilePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer mutil.MaybeClose(w)
 
@@ -50,102 +104,227 @@ func Build(tarSources []*v1alpha1.ImageSource, tarFilePath string) error {
 	defer gzWriter.Close()
 
 	// Create a tarutil writer
-	tw := tar.NewWriter(gzWriter)
+	tw := newArchiveWriter(gzWriter)
+	if options.reproducible {
+		tw, err = newReproducibleArchiveWriter(gzWriter)
+		if err != nil {
+			return nil, err
+		}
+	}
 	defer tw.Close()
+	tw.maxSize = options.maxSize
+	if options.stats != nil {
+		defer func() {
+			*options.stats = tw.stats
+		}()
+	}
 
-	// Currently copyTarball doesn't support compressed tarballs
-	tarSuffixes := []string{".tar"}
-
+	matches := make([][]int, 0, len(tarSources))
 	for _, s := range tarSources {
 
-		isTar := false
-		for _, suffix := range tarSuffixes {
-			if strings.HasSuffix(s.URI, suffix) {
-				isTar = true
-				break
-			}
-		}
-
-		if isTar {
+		var numMatches []int
+		if isTarball(s.URI) {
 			log.Info("Adding tarball", "tarball", s.URI, "pattern", s.Mappings)
-			if err := copyTarBall(tw, s); err != nil {
+			numMatches, err = copyTarBall(tw, s)
+			if err != nil {
 				log.Error(err, "Error copying tarball", "tarball", s.URI, "source", s.Mappings)
-				return err
+				return matches, err
+			}
+		} else if isObjectStoreURI(s.URI) {
+			store, err := newObjectStore(s.URI)
+			if err != nil {
+				return matches, err
+			}
+			numMatches, err = copyObjectStorePath(tw, s, store)
+			if err != nil {
+				log.Error(err, "Error copying objects", "source", s)
+				return matches, err
 			}
-			continue
 		} else {
-			if err := copyLocalPath(tw, s); err != nil {
+			numMatches, err = copyLocalPath(tw, s)
+			if err != nil {
 				log.Error(err, "Error copying local path", "source", s)
-				return err
+				return matches, err
 			}
 		}
 
+		for i, n := range numMatches {
+			if n == 0 {
+				log.Info("Warning; mapping didn't match any files; check the src and strip for typos", "source", s.URI, "src", s.Mappings[i].Src)
+			}
+		}
+		matches = append(matches, numMatches)
 	}
-	return nil
+	// N.B. A reproducible writer only writes the entries when it is closed so we need to check the error.
+	if err := tw.Close(); err != nil {
+		return matches, errors.Wrapf(err, "Error writing tarball %v", tarFilePath)
+	}
+	return matches, nil
 }
 
-func copyLocalPath(tw *tar.Writer, s *v1alpha1.ImageSource) error {
+// copyLocalPath copies the local files matching the mappings into the tarball. It returns the number of files
+// matched by each mapping.
+func copyLocalPath(tw *archiveWriter, s *v1alpha1.ImageSource) ([]int, error) {
 	log := zapr.NewLogger(zap.L())
 
 	u, err := url.Parse(s.URI)
 	if err != nil {
-		return errors.Wrapf(err, "Failed to parse URI %v", s.URI)
+		return nil, errors.Wrapf(err, "Failed to parse URI %v", s.URI)
 	}
 
 	if u.Scheme != "file" {
-		return errors.Errorf("Scheme %v is not supported", u.Scheme)
+		return nil, errors.Errorf("Scheme %v is not supported", u.Scheme)
 	}
 
 	basePath := u.Path
-	for _, a := range s.Mappings {
-		log.Info("Adding asset", "asset", a)
-		// TODO(jeremy): Do we need to handle the "file://" prefix?
-		sBase := basePath
-		// We need to adjust the basepath if we have a relative path
-		parent, glob := splitIntoParent(a.Src)
-
-		if parent != "" {
-			sBase = filepath.Clean(filepath.Join(sBase, parent))
+
+	var ignore *dockerIgnore
+	if s.DockerIgnore {
+		ignore, err = readDockerIgnore(basePath)
+		if err != nil {
+			return nil, err
+		}
+		if ignore == nil {
+			log.Info("Source doesn't have a .dockerignore file", "source", s.URI)
 		}
+	}
+
+	// Match the globs for all the mappings in parallel. Globbing a large directory tree can be slow.
+	mappingEntries := make([][]localEntry, len(s.Mappings))
+	mappingErrs := make([]error, len(s.Mappings))
+	var wg sync.WaitGroup
+	for i, a := range s.Mappings {
+		wg.Add(1)
+		go func(index int, a *v1alpha1.SourceMapping) {
+			defer wg.Done()
+			mappingEntries[index], mappingErrs[index] = matchLocalMapping(basePath, a.Normalized(), ignore)
+		}(i, a)
+	}
+	wg.Wait()
 
-		// Match the glob
-		// matchGlob can handle globs with ../. However DirFs returns a filesystem rooted at the directory
-		// so we need to adjust the glob so that all paths occur under the directory used as the root of the DirFs
-		fs := os.DirFS(sBase)
-		matches, err := matchGlob(fs, glob)
+	entries := make([]localEntry, 0, 100)
+	numMatches := make([]int, len(s.Mappings))
+	for i := range s.Mappings {
+		if mappingErrs[i] != nil {
+			return numMatches, mappingErrs[i]
+		}
+		// N.B. The entries are stat'd per mapping so directories matched by a glob don't count as matches.
+		files, err := statEntries(mappingEntries[i])
 		if err != nil {
-			log.Error(err, "Failed to search glob", "glob", a.Src, "basePath", sBase)
-			return err
+			return numMatches, err
 		}
-		log.Info("Matched glob", "glob", a.Src, "numMatches", len(matches), "basePath", sBase)
-		for _, m := range matches {
-			if err := addFileToTarGenerator(tw, sBase, m, a.Strip, a.Dest); err != nil {
-				log.Error(err, "Error adding file to tarball", "file", m, "basePath", sBase, "strip", a.Strip, "dest", a.Dest)
-				return err
+		numMatches[i] = len(files)
+		entries = append(entries, files...)
+	}
+
+	// N.B. Check the matches before writing anything so a strict source doesn't produce a partial tarball.
+	if err := checkMatches(s, numMatches); err != nil {
+		return numMatches, err
+	}
+
+	// Add the files in a stable order so that the tarball is reproducible; the order of glob results isn't
+	// guaranteed. We use a stable sort so if multiple mappings produce the same name the order of the mappings
+	// is preserved.
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+
+	for _, e := range entries {
+		if err := writeLocalEntry(tw, e); err != nil {
+			log.Error(err, "Error adding file to tarball", "file", e.fullPath, "name", e.name)
+			return numMatches, err
+		}
+	}
+	return numMatches, nil
+}
+
+// matchLocalMapping returns the entries for all the files matching the mapping which aren't excluded.
+func matchLocalMapping(basePath string, a *v1alpha1.SourceMapping, ignore *dockerIgnore) ([]localEntry, error) {
+	log := zapr.NewLogger(zap.L())
+	log.Info("Adding asset", "asset", a)
+	// TODO(jeremy): Do we need to handle the "file://" prefix?
+	sBase := basePath
+	// We need to adjust the basepath if we have a relative path
+	parent, glob := splitIntoParent(a.Src)
+
+	if parent != "" {
+		sBase = filepath.Clean(filepath.Join(sBase, parent))
+	}
+
+	// Match the glob
+	// matchGlob can handle globs with ../. However DirFs returns a filesystem rooted at the directory
+	// so we need to adjust the glob so that all paths occur under the directory used as the root of the DirFs
+	fs := os.DirFS(sBase)
+	matches, err := matchGlob(fs, glob)
+	if err != nil {
+		log.Error(err, "Failed to search glob", "glob", a.Src, "basePath", sBase)
+		return nil, err
+	}
+	log.Info("Matched glob", "glob", a.Src, "numMatches", len(matches), "basePath", sBase)
+
+	entries := make([]localEntry, 0, len(matches))
+	for _, m := range matches {
+		excluded, err := isExcluded(a.Exclude, filepath.ToSlash(m))
+		if err != nil {
+			return nil, err
+		}
+		if !excluded && ignore != nil {
+			// The .dockerignore patterns are relative to the root of the source.
+			rPath, err := filepath.Rel(basePath, filepath.Join(sBase, m))
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to compute path of %v relative to %v", m, basePath)
+			}
+			excluded, err = ignore.Ignored(filepath.ToSlash(rPath))
+			if err != nil {
+				return nil, err
 			}
 		}
+		if excluded {
+			log.V(util.Debug).Info("Skipping excluded file", "file", m, "basePath", sBase)
+			continue
+		}
+		e, err := newLocalEntry(sBase, m, a.Strip, a.Dest)
+		if err != nil {
+			log.Error(err, "Error computing name in tarball", "file", m, "basePath", sBase, "strip", a.Strip, "dest", a.Dest)
+			return nil, err
+		}
+		entries = append(entries, e)
 	}
-	return nil
+	return entries, nil
 }
 
 // copyTarball copies assets in the source tarbell matching the glob to the destination tarball
+// The source tarball can be compressed with gzip or zstd.
 // glob is a glob pattern to match against the tarball
 // strip is a path prefix to strip from all paths
 // destPrefix is a path prefix to add to all paths
-func copyTarBall(tw *tar.Writer, s *v1alpha1.ImageSource) error {
+// It returns the number of files matched by each mapping.
+func copyTarBall(tw *archiveWriter, s *v1alpha1.ImageSource) ([]int, error) {
 	log := zapr.NewLogger(zap.L())
 	factory := &files.Factory{}
 	helper, err := factory.Get(s.URI)
 	if err != nil {
-		return errors.Wrapf(err, "Error opening tarball %v", s.URI)
+		return nil, errors.Wrapf(err, "Error opening tarball %v", s.URI)
 	}
 	reader, err := helper.NewReader(s.URI)
 	if err != nil {
-		return errors.Wrapf(err, "Error opening tarball %v", s.URI)
+		return nil, errors.Wrapf(err, "Error opening tarball %v", s.URI)
 	}
 
+	uncompressed, closeDecompressor, err := decompress(reader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error decompressing tarball %v", s.URI)
+	}
+	defer closeDecompressor()
+
 	// Create a tar reader
-	tarReader := tar.NewReader(reader)
+	tarReader := tar.NewReader(uncompressed)
+
+	mappings := make([]*v1alpha1.SourceMapping, len(s.Mappings))
+	for i, m := range s.Mappings {
+		mappings[i] = m.Normalized()
+	}
+	numMatches := make([]int, len(s.Mappings))
 
 	// Iterate over each file in the tarball
 	for {
@@ -153,23 +332,35 @@ func copyTarBall(tw *tar.Writer, s *v1alpha1.ImageSource) error {
 
 		if err == io.EOF {
 			// Reached the end of the tarball
-			return nil
+			return numMatches, checkMatches(s, numMatches)
 		}
 
 		if err != nil {
-			return errors.Wrapf(err, "Error reading tar header:")
+			return numMatches, errors.Wrapf(err, "Error reading tar header:")
 		}
 
 		// Check if any of the patterns match
 		var source *v1alpha1.SourceMapping
-		for _, s := range s.Mappings {
-			isMatch, err := matchGlobToHeader(s.Src, header.Name)
+		for i, m := range mappings {
+			isMatch, err := matchGlobToHeader(m.Src, header.Name)
+			if err != nil {
+				return numMatches, err
+			}
+
+			if !isMatch {
+				continue
+			}
+
+			excluded, err := isExcluded(m.Exclude, header.Name)
 			if err != nil {
-				return err
+				return numMatches, err
 			}
 
-			if isMatch {
-				source = s
+			if !excluded {
+				source = m
+				if header.Typeflag != tar.TypeDir {
+					numMatches[i]++
+				}
 				break
 			}
 		}
@@ -201,7 +392,7 @@ func copyTarBall(tw *tar.Writer, s *v1alpha1.ImageSource) error {
 		newHeader.Name = path
 
 		if err := tw.WriteHeader(newHeader); err != nil {
-			return errors.Wrapf(err, "Error writing tar header: %v", newHeader.Name)
+			return numMatches, errors.Wrapf(err, "Error writing tar header: %v", newHeader.Name)
 		}
 
 		// We create headers for empty files but since the size is 0 we don't copy any bytes.
@@ -211,11 +402,29 @@ func copyTarBall(tw *tar.Writer, s *v1alpha1.ImageSource) error {
 		// Read the file contents
 		_, err = io.CopyN(tw, tarReader, header.Size)
 		if err != nil {
-			return errors.Wrapf(err, "Error reading file contents")
+			return numMatches, errors.Wrapf(err, "Error reading file contents")
 		}
 	}
 }
 
+// checkMatches returns an error listing the mappings that didn't match any files if the source is strict.
+// numMatches is the number of files matched by each mapping of the source.
+func checkMatches(s *v1alpha1.ImageSource, numMatches []int) error {
+	if !s.Strict {
+		return nil
+	}
+	unmatched := []string{}
+	for i, n := range numMatches {
+		if n == 0 {
+			unmatched = append(unmatched, s.Mappings[i].Src)
+		}
+	}
+	if len(unmatched) == 0 {
+		return nil
+	}
+	return errors.Errorf("Mappings %v of source %v didn't match any files", strings.Join(unmatched, ", "), s.URI)
+}
+
 func matchGlobToHeader(glob string, headerName string) (bool, error) {
 	// We need to strip the leading / if any from the glob.
 	// https://github.com/jlewi/hydros/issues/69
@@ -250,43 +459,92 @@ func matchGlob(dirFS fs.FS, glob string) ([]string, error) {
 	return doublestar.Glob(dirFS, glob)
 }
 
-// addFileToTarGenerator adds a file to the tarball
-// fs should be a filesystem rooted at the base directory
-// path should be relative to basePath
-func addFileToTarGenerator(tw *tar.Writer, basePath string, path string, strip string, destPrefix string) error {
-	log := zapr.NewLogger(zap.L())
+// localEntry is a local file to add to the tarball.
+type localEntry struct {
+	// fullPath is the path of the file on disk
+	fullPath string
+	// name is the name of the file in the tarball
+	name string
+	// info is the result of stat'ing the file. It is set by statEntries.
+	info os.FileInfo
+}
 
-	fullPath := filepath.Join(basePath, path)
-	info, err := os.Stat(fullPath)
+// newLocalEntry creates the entry for a file.
+// path should be relative to basePath
+func newLocalEntry(basePath string, path string, strip string, destPrefix string) (localEntry, error) {
+	// Adjust header name if necessary (e.g., relative paths)
+	relPath, err := filepath.Rel(strip, path)
 	if err != nil {
-		return err
+		return localEntry{}, err
 	}
-	if info.IsDir() {
-		log.Info("Skipping directory", "path", fullPath)
-		return nil
+	if destPrefix != "" {
+		relPath = filepath.Join(destPrefix, relPath)
 	}
+	return localEntry{
+		fullPath: filepath.Join(basePath, path),
+		name:     filepath.ToSlash(relPath),
+	}, nil
+}
 
-	// return on non-regular files
-	if !info.Mode().IsRegular() {
-		log.Info("Skipping not regular path", "path", fullPath)
-		return nil
+// statEntries stats the files in parallel. It returns the entries for regular files; directories and other
+// non-regular files are skipped. The order of the entries is preserved.
+func statEntries(entries []localEntry) ([]localEntry, error) {
+	log := zapr.NewLogger(zap.L())
+
+	errs := make([]error, len(entries))
+	indexes := make(chan int, len(entries))
+	for i := range entries {
+		indexes <- i
 	}
+	close(indexes)
 
-	// Create a tarutil header
-	header, err := tar.FileInfoHeader(info, fullPath)
-	if err != nil {
-		return err
+	numWorkers := runtime.NumCPU()
+	if numWorkers > len(entries) {
+		numWorkers = len(entries)
 	}
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				entries[i].info, errs[i] = os.Stat(entries[i].fullPath)
+			}
+		}()
+	}
+	wg.Wait()
 
-	// Adjust header name if necessary (e.g., relative paths)
-	relPath, err := filepath.Rel(strip, path)
+	results := make([]localEntry, 0, len(entries))
+	for i, e := range entries {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if e.info.IsDir() {
+			log.V(util.Debug).Info("Skipping directory", "path", e.fullPath)
+			continue
+		}
+
+		// skip non-regular files
+		if !e.info.Mode().IsRegular() {
+			log.Info("Skipping not regular path", "path", e.fullPath)
+			continue
+		}
+		results = append(results, e)
+	}
+	return results, nil
+}
+
+// writeLocalEntry writes the file to the tarball. The entry must have been stat'd.
+func writeLocalEntry(tw *archiveWriter, e localEntry) error {
+	log := zapr.NewLogger(zap.L())
+
+	// Create a tarutil header
+	header, err := tar.FileInfoHeader(e.info, e.fullPath)
 	if err != nil {
 		return err
 	}
-	if destPrefix != "" {
-		relPath = filepath.Join(destPrefix, relPath)
-	}
-	header.Name = filepath.ToSlash(relPath)
+	header.Name = e.name
+	normalizeHeader(header)
 
 	// Write header to the archive
 	err = tw.WriteHeader(header)
@@ -294,11 +552,10 @@ func addFileToTarGenerator(tw *tar.Writer, basePath string, path string, strip s
 		return err
 	}
 
-	// Only write file contents for regular files
-	log.Info("Writing tarball entry", "header", header.Name, "path", path)
-	file, err := os.Open(fullPath)
+	log.V(util.Debug).Info("Writing tarball entry", "header", header.Name, "path", e.fullPath)
+	file, err := os.Open(e.fullPath)
 	if err != nil {
-		return errors.Wrapf(err, "Failed to openfile %v", fullPath)
+		return errors.Wrapf(err, "Failed to openfile %v", e.fullPath)
 	}
 	defer file.Close()
 
@@ -310,3 +567,22 @@ func addFileToTarGenerator(tw *tar.Writer, basePath string, path string, strip s
 
 	return nil
 }
+
+// addFileToTarGenerator adds a file to the tarball
+// path should be relative to basePath
+func addFileToTarGenerator(tw *archiveWriter, basePath string, path string, strip string, destPrefix string) error {
+	e, err := newLocalEntry(basePath, path, strip, destPrefix)
+	if err != nil {
+		return err
+	}
+	entries, err := statEntries([]localEntry{e})
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := writeLocalEntry(tw, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,18 +2,23 @@ package tarutil
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 
 	"github.com/go-logr/zapr"
 	"github.com/jlewi/hydros/api/v1alpha1"
 	"github.com/jlewi/hydros/pkg/util"
+	"github.com/klauspost/compress/zstd"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
@@ -36,6 +41,8 @@ func Test_Build(t *testing.T) {
 		name     string
 		source   []*v1alpha1.ImageSource
 		expected []string
+		// notExpected are files that shouldn't be in the tarball
+		notExpected []string
 	}
 
 	cases := []testCase{
@@ -80,6 +87,101 @@ func Test_Build(t *testing.T) {
 				"file1.txt",
 			},
 		},
+		{
+			// A leading slash in the strip and exclude patterns is also relative to the root of the source.
+			name: "test-leading-slash-strip",
+			source: []*v1alpha1.ImageSource{
+				{
+					URI: "file://" + filepath.Join(cwd, "test_data", "dockerignore"),
+					Mappings: []*v1alpha1.SourceMapping{
+						{
+							Src:     "/pkg/**/*",
+							Strip:   "/pkg",
+							Exclude: []string{"/pkg/app/testdata"},
+						},
+					},
+				},
+			},
+			expected: []string{
+				"app/app.py",
+			},
+			notExpected: []string{
+				"pkg/app/app.py",
+				"app/testdata/fixture.txt",
+			},
+		},
+		{
+			// A leading slash in the dest is relative to the root of the tarball.
+			name: "test-leading-slash-dest",
+			source: []*v1alpha1.ImageSource{
+				{
+					URI: "file://" + filepath.Join(cwd, "test_data", "dirA"),
+					Mappings: []*v1alpha1.SourceMapping{
+						{
+							Src:  "/*.txt",
+							Dest: "/static",
+						},
+					},
+				},
+			},
+			expected: []string{
+				"static/file1.txt",
+			},
+			notExpected: []string{
+				"/static/file1.txt",
+			},
+		},
+		{
+			name: "test-exclude",
+			source: []*v1alpha1.ImageSource{
+				{
+					URI: "file://" + filepath.Join(cwd, "test_data", "dockerignore"),
+					Mappings: []*v1alpha1.SourceMapping{
+						{
+							Src:     "**/*",
+							Exclude: []string{"**/testdata", "build/out.bin"},
+						},
+					},
+				},
+			},
+			expected: []string{
+				"main.py",
+				"debug.log",
+				"build/keep.bin",
+				"pkg/app/app.py",
+			},
+			notExpected: []string{
+				"build/out.bin",
+				"testdata/fixture.txt",
+				"pkg/app/testdata/fixture.txt",
+			},
+		},
+		{
+			name: "test-dockerignore",
+			source: []*v1alpha1.ImageSource{
+				{
+					URI:          "file://" + filepath.Join(cwd, "test_data", "dockerignore"),
+					DockerIgnore: true,
+					Mappings: []*v1alpha1.SourceMapping{
+						{
+							Src:     "**/*",
+							Exclude: []string{"**/testdata"},
+						},
+					},
+				},
+			},
+			expected: []string{
+				"main.py",
+				"build/keep.bin",
+				"pkg/app/app.py",
+			},
+			notExpected: []string{
+				"debug.log",
+				"build/out.bin",
+				"testdata/fixture.txt",
+				"pkg/app/testdata/fixture.txt",
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -107,6 +209,88 @@ func Test_Build(t *testing.T) {
 			if len(missing) > 0 {
 				t.Errorf("Missing files %v", missing)
 			}
+
+			unexpected := []string{}
+			for _, e := range c.notExpected {
+				if _, ok := manifest[e]; ok {
+					unexpected = append(unexpected, e)
+				}
+			}
+
+			if len(unexpected) > 0 {
+				t.Errorf("Files should have been excluded %v", unexpected)
+			}
+		})
+	}
+}
+
+func Test_BuildStrict(t *testing.T) {
+	tDir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatalf("Error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tDir)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Error getting working directory %v", err)
+	}
+
+	// Create a tarball to use as a source; e.g. an exported image.
+	tarPath := filepath.Join(tDir, "image.tar")
+	f, err := os.Create(tarPath)
+	if err != nil {
+		t.Fatalf("Error creating tarball %v", err)
+	}
+	tw := tar.NewWriter(f)
+	contents := []byte("apiVersion: v1")
+	if err := tw.WriteHeader(&tar.Header{Name: "app/kustomize/kustomization.yaml", Mode: 0o644, Size: int64(len(contents))}); err != nil {
+		t.Fatalf("Error writing header %v", err)
+	}
+	if _, err := tw.Write(contents); err != nil {
+		t.Fatalf("Error writing file %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Error closing tarball %v", err)
+	}
+	f.Close()
+
+	type testCase struct {
+		name      string
+		uri       string
+		src       string
+		expectErr bool
+	}
+
+	cases := []testCase{
+		{name: "local-match", uri: "file://" + filepath.Join(cwd, "test_data", "dirA"), src: "/*.txt"},
+		{name: "local-no-match", uri: "file://" + filepath.Join(cwd, "test_data", "dirA"), src: "/*.yaml", expectErr: true},
+		{name: "tarball-match", uri: "file://" + tarPath, src: "/app/kustomize/**"},
+		{name: "tarball-no-match", uri: "file://" + tarPath, src: "/app/kustomization/**", expectErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			source := []*v1alpha1.ImageSource{
+				{
+					URI:      c.uri,
+					Strict:   true,
+					Mappings: []*v1alpha1.SourceMapping{{Src: c.src}},
+				},
+			}
+			matches, err := BuildWithMatches(source, filepath.Join(tDir, c.name+".tar.gz"))
+			if c.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error because the mapping doesn't match any files")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Build failed; %+v", err)
+			}
+			if d := cmp.Diff([][]int{{1}}, matches); d != "" {
+				t.Errorf("Unexpected matches (-want +got):\n%s", d)
+			}
 		})
 	}
 }
@@ -154,6 +338,292 @@ func readTarball(srcTarball string) (map[string]bool, error) {
 	return manifest, nil
 }
 
+func Test_BuildIsReproducible(t *testing.T) {
+	tDir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatalf("Error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(tDir)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Error getting working directory %v", err)
+	}
+
+	source := []*v1alpha1.ImageSource{
+		{
+			URI: "file://" + filepath.Join(cwd, "test_data", "dockerignore"),
+			Mappings: []*v1alpha1.SourceMapping{
+				{
+					Src: "pkg/**/*",
+				},
+				{
+					Src: "**/*.txt",
+				},
+			},
+		},
+	}
+
+	first := filepath.Join(tDir, "first.tar.gz")
+	if err := Build(source, first); err != nil {
+		t.Fatalf("Error building tarball %+v", err)
+	}
+
+	// Touch one of the files; the modification time shouldn't change the tarball.
+	now := time.Now()
+	if err := os.Chtimes(filepath.Join(cwd, "test_data", "dockerignore", "testdata", "fixture.txt"), now, now); err != nil {
+		t.Fatalf("Failed to change the modification time; %v", err)
+	}
+
+	second := filepath.Join(tDir, "second.tar.gz")
+	if err := Build(source, second); err != nil {
+		t.Fatalf("Error building tarball %+v", err)
+	}
+
+	firstBytes, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatalf("Failed to read %v; %v", first, err)
+	}
+	secondBytes, err := os.ReadFile(second)
+	if err != nil {
+		t.Fatalf("Failed to read %v; %v", second, err)
+	}
+	if !bytes.Equal(firstBytes, secondBytes) {
+		t.Errorf("Building the same sources twice produced different tarballs")
+	}
+
+	// Entries should be sorted by name.
+	f, err := os.Open(first)
+	if err != nil {
+		t.Fatalf("Failed to open %v; %v", first, err)
+	}
+	defer f.Close()
+	gzipReader, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Failed to create gzip reader; %v", err)
+	}
+	names := []string{}
+	tr := tar.NewReader(gzipReader)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read tarball; %v", err)
+		}
+		names = append(names, h.Name)
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("Tarball entries aren't sorted; got %v", names)
+	}
+}
+
+func Test_BuildReproducibleMode(t *testing.T) {
+	tDir := t.TempDir()
+
+	localDir := filepath.Join(tDir, "local")
+	if err := os.MkdirAll(localDir, 0o755); err != nil {
+		t.Fatalf("Failed to create directory; %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(localDir, "b.txt"), []byte("b"), 0o644); err != nil {
+		t.Fatalf("Failed to write file; %v", err)
+	}
+
+	// writeSource writes a tarball with the same contents but different timestamps, ownership and modes.
+	writeSource := func(name string, modTime time.Time, uid int, mode int64) string {
+		p := filepath.Join(tDir, name)
+		f, err := os.Create(p)
+		if err != nil {
+			t.Fatalf("Failed to create %v; %v", p, err)
+		}
+		defer f.Close()
+		tw := tar.NewWriter(f)
+		for _, e := range []struct{ name, contents string }{{"c.txt", "c"}, {"a.txt", "a"}} {
+			h := &tar.Header{Name: e.name, Size: int64(len(e.contents)), Mode: mode, ModTime: modTime, Uid: uid, Uname: "builder"}
+			if err := tw.WriteHeader(h); err != nil {
+				t.Fatalf("Failed to write header; %v", err)
+			}
+			if _, err := tw.Write([]byte(e.contents)); err != nil {
+				t.Fatalf("Failed to write contents; %v", err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatalf("Failed to close tarball; %v", err)
+		}
+		return p
+	}
+
+	build := func(name string, source string) []byte {
+		sources := []*v1alpha1.ImageSource{
+			{
+				URI:      "file://" + localDir,
+				Mappings: []*v1alpha1.SourceMapping{{Src: "*.txt"}},
+			},
+			{
+				URI:      source,
+				Mappings: []*v1alpha1.SourceMapping{{Src: "*.txt"}},
+			},
+		}
+		output := filepath.Join(tDir, name)
+		if err := Build(sources, output, WithReproducible()); err != nil {
+			t.Fatalf("Error building tarball %+v", err)
+		}
+		b, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatalf("Failed to read %v; %v", output, err)
+		}
+		return b
+	}
+
+	first := build("first.tar.gz", writeSource("first.tar", time.Unix(1000, 0), 1000, 0o664))
+	second := build("second.tar.gz", writeSource("second.tar", time.Unix(2000, 0), 2000, 0o644))
+	if !bytes.Equal(first, second) {
+		t.Errorf("Sources with the same contents produced different tarballs")
+	}
+
+	entries, err := List(filepath.Join(tDir, "first.tar.gz"))
+	if err != nil {
+		t.Fatalf("Failed to list tarball; %v", err)
+	}
+	// The entries of all sources are sorted.
+	if d := cmp.Diff([]string{"a.txt", "b.txt", "c.txt"}, entries); d != "" {
+		t.Errorf("Unexpected entries; diff:\n%v", d)
+	}
+}
+
+func Test_BuildCompressedTarballs(t *testing.T) {
+	tDir := t.TempDir()
+
+	// writeTarball writes a tarball with a single file; compress wraps the file e.g. in a gzip writer.
+	writeTarball := func(name string, compress func(io.Writer) (io.WriteCloser, error)) string {
+		p := filepath.Join(tDir, name)
+		f, err := os.Create(p)
+		if err != nil {
+			t.Fatalf("Failed to create %v; %v", p, err)
+		}
+		defer f.Close()
+		w, err := compress(f)
+		if err != nil {
+			t.Fatalf("Failed to create compressor; %v", err)
+		}
+		tw := tar.NewWriter(w)
+		contents := "hello"
+		if err := tw.WriteHeader(&tar.Header{Name: "app/hello.txt", Size: int64(len(contents)), Mode: 0o644}); err != nil {
+			t.Fatalf("Failed to write header; %v", err)
+		}
+		if _, err := tw.Write([]byte(contents)); err != nil {
+			t.Fatalf("Failed to write contents; %v", err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatalf("Failed to close tarball; %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Failed to close compressor; %v", err)
+		}
+		return p
+	}
+
+	gzipCompress := func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	}
+	zstdCompress := func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w)
+	}
+
+	cases := map[string]string{
+		"gzip": writeTarball("source.tar.gz", gzipCompress),
+		"tgz":  writeTarball("source.tgz", gzipCompress),
+		"zstd": writeTarball("source.tar.zst", zstdCompress),
+		// The compression is detected from the contents so it doesn't have to match the suffix.
+		"misnamed": writeTarball("misnamed.tar", gzipCompress),
+	}
+
+	for name, source := range cases {
+		t.Run(name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "context.tar.gz")
+			sources := []*v1alpha1.ImageSource{
+				{
+					URI:      "file://" + source,
+					Mappings: []*v1alpha1.SourceMapping{{Src: "app/*.txt", Strip: "app"}},
+					Strict:   true,
+				},
+			}
+			if err := Build(sources, output); err != nil {
+				t.Fatalf("Error building tarball %+v", err)
+			}
+			entries, err := List(output)
+			if err != nil {
+				t.Fatalf("Failed to list tarball; %v", err)
+			}
+			if d := cmp.Diff([]string{"hello.txt"}, entries); d != "" {
+				t.Errorf("Unexpected entries; diff:\n%v", d)
+			}
+		})
+	}
+}
+
+func Test_BuildMaxSize(t *testing.T) {
+	tDir := t.TempDir()
+	localDir := filepath.Join(tDir, "local")
+	if err := os.MkdirAll(localDir, 0o755); err != nil {
+		t.Fatalf("Failed to create directory; %v", err)
+	}
+	for name, size := range map[string]int{"a.txt": 10, "b.txt": 30, "c.txt": 20} {
+		if err := os.WriteFile(filepath.Join(localDir, name), bytes.Repeat([]byte("x"), size), 0o644); err != nil {
+			t.Fatalf("Failed to write file; %v", err)
+		}
+	}
+	sources := []*v1alpha1.ImageSource{
+		{
+			URI:      "file://" + localDir,
+			Mappings: []*v1alpha1.SourceMapping{{Src: "*.txt"}},
+		},
+	}
+
+	stats := &Stats{}
+	if err := Build(sources, filepath.Join(tDir, "context.tgz"), WithStats(stats), WithMaxSize(60)); err != nil {
+		t.Fatalf("Error building tarball %+v", err)
+	}
+	expected := &Stats{
+		Size:     60,
+		NumFiles: 3,
+		Largest: []v1alpha1.ContextFile{
+			{Path: "b.txt", Size: 30},
+			{Path: "c.txt", Size: 20},
+			{Path: "a.txt", Size: 10},
+		},
+	}
+	if d := cmp.Diff(expected, stats); d != "" {
+		t.Errorf("Unexpected stats; diff:\n%v", d)
+	}
+
+	err := Build(sources, filepath.Join(tDir, "too-large.tgz"), WithMaxSize(35))
+	sizeErr := &SizeError{}
+	if !errors.As(err, &sizeErr) {
+		t.Fatalf("Expected a SizeError; got %v", err)
+	}
+	if sizeErr.Stats.Size != 40 || sizeErr.Stats.NumFiles != 2 {
+		t.Errorf("Expected the error to be reported after adding 40 bytes in 2 files; got %v bytes in %v files", sizeErr.Stats.Size, sizeErr.Stats.NumFiles)
+	}
+}
+
+func Test_FormatSize(t *testing.T) {
+	cases := map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1024:            "1.0 KiB",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+		3 << 30:         "3.0 GiB",
+	}
+	for size, expected := range cases {
+		if actual := FormatSize(size); actual != expected {
+			t.Errorf("FormatSize(%v) = %v; want %v", size, actual, expected)
+		}
+	}
+}
+
 func Test_matchGlob(t *testing.T) {
 	type testCase struct {
 		files    []string
@@ -299,3 +769,165 @@ func Test_matchGlobToHeader(t *testing.T) {
 		})
 	}
 }
+
+// fakeObjectStore is an in memory objectStore used for testing
+type fakeObjectStore struct {
+	objects map[string]string
+}
+
+func (f *fakeObjectStore) List(prefix string) ([]string, error) {
+	uris := make([]string, 0, len(f.objects))
+	for k := range f.objects {
+		if strings.HasPrefix(k, prefix) {
+			uris = append(uris, k)
+		}
+	}
+	sort.Strings(uris)
+	return uris, nil
+}
+
+func (f *fakeObjectStore) NewReader(uri string) (io.Reader, error) {
+	contents, ok := f.objects[uri]
+	if !ok {
+		return nil, errors.Errorf("Object %v not found", uri)
+	}
+	return strings.NewReader(contents), nil
+}
+
+func Test_copyObjectStorePath(t *testing.T) {
+	util.SetupLogger("info", true)
+
+	store := &fakeObjectStore{
+		objects: map[string]string{
+			"gs://bucket/assets/bin/server":        "server",
+			"gs://bucket/assets/bin/client":        "client",
+			"gs://bucket/assets/docs/README.md":    "readme",
+			"gs://bucket/other/bin/server":         "other",
+			"s3://bucket/releases/v1/app.tgz":      "app",
+			"s3://bucket/releases/v1/notes/v1.txt": "notes",
+		},
+	}
+
+	type testCase struct {
+		name     string
+		source   *v1alpha1.ImageSource
+		expected []string
+	}
+
+	cases := []testCase{
+		{
+			name: "gcs",
+			source: &v1alpha1.ImageSource{
+				URI: "gs://bucket/assets",
+				Mappings: []*v1alpha1.SourceMapping{
+					{
+						Src:  "bin/*",
+						Dest: "app",
+					},
+				},
+			},
+			expected: []string{
+				"app/bin/client",
+				"app/bin/server",
+			},
+		},
+		{
+			name: "gcs-strip-and-parent",
+			source: &v1alpha1.ImageSource{
+				URI: "gs://bucket/assets",
+				Mappings: []*v1alpha1.SourceMapping{
+					{
+						Src:   "../other/**/*",
+						Strip: "other/bin",
+					},
+				},
+			},
+			expected: []string{
+				"server",
+			},
+		},
+		{
+			name: "s3",
+			source: &v1alpha1.ImageSource{
+				URI: "s3://bucket/releases/v1",
+				Mappings: []*v1alpha1.SourceMapping{
+					{
+						Src: "/**/*.txt",
+					},
+				},
+			},
+			expected: []string{
+				"notes/v1.txt",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b := &bytes.Buffer{}
+			tw := newArchiveWriter(b)
+			if _, err := copyObjectStorePath(tw, c.source, store); err != nil {
+				t.Fatalf("copyObjectStorePath failed; %+v", err)
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatalf("Failed to close tar writer; %v", err)
+			}
+
+			actual := []string{}
+			tr := tar.NewReader(b)
+			for {
+				h, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Failed to read tarball; %v", err)
+				}
+				actual = append(actual, h.Name)
+			}
+			sort.Strings(actual)
+
+			if d := cmp.Diff(c.expected, actual); d != "" {
+				t.Errorf("Unexpected result (-want +got):\n%s", d)
+			}
+		})
+	}
+}
+
+func Test_dockerIgnore(t *testing.T) {
+	type testCase struct {
+		path    string
+		ignored bool
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Error getting working directory %v", err)
+	}
+
+	ignore, err := readDockerIgnore(filepath.Join(cwd, "test_data", "dockerignore"))
+	if err != nil {
+		t.Fatalf("Error reading .dockerignore %v", err)
+	}
+
+	cases := []testCase{
+		{path: "main.py", ignored: false},
+		{path: "debug.log", ignored: true},
+		{path: "build", ignored: true},
+		{path: "build/out.bin", ignored: true},
+		{path: "build/keep.bin", ignored: false},
+		{path: "pkg/app/app.py", ignored: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			actual, err := ignore.Ignored(c.path)
+			if err != nil {
+				t.Fatalf("Error checking if path is ignored %v", err)
+			}
+			if actual != c.ignored {
+				t.Errorf("Expected ignored %v; got %v", c.ignored, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package tarutil
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+var (
+	// tarSuffixes are the suffixes of the URIs of sources that are tarballs.
+	tarSuffixes = []string{".tar", ".tar.gz", ".tgz", ".tar.zst", ".tzst"}
+
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// isTarball returns true if the URI refers to a tarball that may be compressed.
+func isTarball(uri string) bool {
+	for _, suffix := range tarSuffixes {
+		if strings.HasSuffix(uri, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// decompress returns a reader for the uncompressed contents of r. The compression, gzip or zstd, is detected
+// from the contents rather than the name so tarballs whose names don't match their compression are handled.
+// Readers which aren't compressed are returned unchanged. The returned function closes the decompressor.
+func decompress(r io.Reader) (io.Reader, func() error, error) {
+	br := bufio.NewReader(r)
+	// N.B. Peek returns an error if the reader has fewer bytes than requested; e.g. an empty tarball. In that case
+	// the contents can't be compressed.
+	header, _ := br.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Error creating gzip reader")
+		}
+		return gz, gz.Close, nil
+	case bytes.HasPrefix(header, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Error creating zstd reader")
+		}
+		return zr, func() error {
+			zr.Close()
+			return nil
+		}, nil
+	default:
+		return br, func() error { return nil }, nil
+	}
+}
@@ -0,0 +1,118 @@
+package tarutil
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	dockerIgnoreFile = ".dockerignore"
+)
+
+// isExcluded returns true if the path matches any of the exclude patterns.
+// path should use "/" as the separator and be relative to the same directory as the patterns.
+func isExcluded(excludes []string, path string) (bool, error) {
+	for _, e := range excludes {
+		isMatch, err := matchPathOrParent(e, path)
+		if err != nil {
+			return false, err
+		}
+		if isMatch {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// matchPathOrParent returns true if the pattern matches the path or any of its parent directories.
+// Excluding a directory should exclude everything in it.
+func matchPathOrParent(pattern string, path string) (bool, error) {
+	// Like mappings, a leading "/" is relative to the root.
+	pattern = strings.TrimPrefix(filepath.ToSlash(pattern), "/")
+	path = strings.TrimPrefix(path, "/")
+	isMatch, err := doublestar.Match(pattern, path)
+	if err != nil {
+		return false, errors.Wrapf(err, "Invalid pattern %v", pattern)
+	}
+	if isMatch {
+		return true, nil
+	}
+	return doublestar.Match(strings.TrimSuffix(pattern, "/")+"/**", path)
+}
+
+// ignoreRule is a single line in a .dockerignore file.
+type ignoreRule struct {
+	pattern string
+	// negate is true if the pattern started with "!" which means matching files should be included.
+	negate bool
+}
+
+// dockerIgnore is a parsed .dockerignore file.
+// See https://docs.docker.com/engine/reference/builder/#dockerignore-file
+// We support the commonly used subset of the syntax; i.e. globs including "**" and exceptions using "!".
+// As with docker the last rule matching a path determines whether it is ignored.
+type dockerIgnore struct {
+	rules []ignoreRule
+}
+
+// readDockerIgnore reads the .dockerignore file in dir. It returns nil if there is no .dockerignore file.
+func readDockerIgnore(dir string) (*dockerIgnore, error) {
+	path := filepath.Join(dir, dockerIgnoreFile)
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "Failed to open %v", path)
+	}
+	defer f.Close()
+
+	d := &dockerIgnore{
+		rules: make([]ignoreRule, 0, 10),
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = strings.TrimSpace(line[1:])
+		}
+		rule.pattern = filepath.ToSlash(filepath.Clean(line))
+		if !doublestar.ValidatePattern(strings.TrimPrefix(rule.pattern, "/")) {
+			return nil, errors.Errorf("Invalid pattern %v in %v", line, path)
+		}
+		d.rules = append(d.rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "Failed to read %v", path)
+	}
+	return d, nil
+}
+
+// Ignored returns true if the path should be excluded from the context.
+// path should use "/" as the separator and be relative to the directory containing the .dockerignore file.
+func (d *dockerIgnore) Ignored(path string) (bool, error) {
+	if d == nil {
+		return false, nil
+	}
+	ignored := false
+	for _, r := range d.rules {
+		isMatch, err := matchPathOrParent(r.pattern, path)
+		if err != nil {
+			return false, err
+		}
+		if isMatch {
+			ignored = !r.negate
+		}
+	}
+	return ignored, nil
+}
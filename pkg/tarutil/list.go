@@ -0,0 +1,38 @@
+package tarutil
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// List returns the names of the entries in the gzipped tarball in the order they appear in it.
+func List(tarball string) ([]string, error) {
+	f, err := os.Open(tarball)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error opening tarball %v", tarball)
+	}
+	defer f.Close()
+
+	gzReader, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error creating gzip reader for %v", tarball)
+	}
+	defer gzReader.Close()
+
+	names := []string{}
+	tr := tar.NewReader(gzReader)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return names, errors.Wrapf(err, "Error reading tar header in %v", tarball)
+		}
+		names = append(names, h.Name)
+	}
+}
@@ -0,0 +1,256 @@
+package tarutil
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/PrimerAI/go-micro-utils-public/gmu/s3"
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/go-logr/zapr"
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/jlewi/monogo/files"
+	"github.com/jlewi/monogo/gcp/gcs"
+	mutil "github.com/jlewi/monogo/util"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// S3Scheme is the scheme for objects stored in S3
+	S3Scheme = "s3"
+)
+
+// objectStore is the minimal interface needed to copy objects from a bucket into a tarball.
+type objectStore interface {
+	// List returns the URIs of all objects whose names begin with prefix.
+	// prefix is a URI e.g. gs://bucket/some/dir
+	List(prefix string) ([]string, error)
+	// NewReader returns a reader for the object with the given URI.
+	NewReader(uri string) (io.Reader, error)
+}
+
+// isObjectStoreURI returns true if the URI refers to a bucket in GCS or S3.
+func isObjectStoreURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == files.GCSScheme || u.Scheme == S3Scheme
+}
+
+// newObjectStore returns the objectStore for the scheme of the URI.
+func newObjectStore(uri string) (objectStore, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse URI %v", uri)
+	}
+
+	switch u.Scheme {
+	case files.GCSScheme:
+		factory := &files.Factory{}
+		helper, err := factory.Get(uri)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error creating helper for %v", uri)
+		}
+		gcsHelper, ok := helper.(*gcs.GcsHelper)
+		if !ok {
+			return nil, errors.Errorf("Expected a GcsHelper for %v", uri)
+		}
+		return &gcsStore{helper: gcsHelper}, nil
+	case S3Scheme:
+		client, err := s3.NewClient()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to create S3 client")
+		}
+		return &s3Store{client: client}, nil
+	default:
+		return nil, errors.Errorf("Scheme %v is not supported", u.Scheme)
+	}
+}
+
+// gcsStore is an objectStore backed by GCS.
+type gcsStore struct {
+	helper *gcs.GcsHelper
+}
+
+func (g *gcsStore) List(prefix string) ([]string, error) {
+	return gcs.ListObjectsWithPrefix(g.helper.Ctx, g.helper.Client, prefix)
+}
+
+func (g *gcsStore) NewReader(uri string) (io.Reader, error) {
+	return g.helper.NewReader(uri)
+}
+
+// s3Store is an objectStore backed by S3.
+type s3Store struct {
+	client s3.Client
+}
+
+func (s *s3Store) List(prefix string) ([]string, error) {
+	p, err := s3.FromURI(prefix)
+	if err != nil {
+		return nil, err
+	}
+	p.Key = strings.TrimSuffix(p.Key, "/")
+
+	// N.B. The S3 client lists a single level using "/" as the delimiter so we need to recurse into
+	// the directories.
+	uris := make([]string, 0, 10)
+	toList := []s3.Path{p}
+	for len(toList) > 0 {
+		current := toList[0]
+		toList = toList[1:]
+
+		objects, err := s.client.List(current)
+		if err != nil {
+			return uris, errors.Wrapf(err, "Failed to list objects in %v", current.ToURI())
+		}
+		for _, o := range objects {
+			if strings.HasSuffix(o.Key, "/") {
+				continue
+			}
+			uris = append(uris, o.ToURI())
+		}
+
+		dirs, err := s.client.ListDirectories(current)
+		if err != nil {
+			return uris, errors.Wrapf(err, "Failed to list directories in %v", current.ToURI())
+		}
+		for _, d := range dirs {
+			d.Key = strings.TrimSuffix(d.Key, "/")
+			toList = append(toList, d)
+		}
+	}
+	return uris, nil
+}
+
+func (s *s3Store) NewReader(uri string) (io.Reader, error) {
+	p, err := s3.FromURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp("", "hydrosS3Object")
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create temporary file for %v", uri)
+	}
+	// Remove the file once its been opened; the file handle keeps the contents around until its closed.
+	defer os.Remove(f.Name())
+	if _, err := s.client.DownloadInFile(p, f); err != nil {
+		mutil.MaybeClose(f)
+		return nil, errors.Wrapf(err, "Failed to download %v", uri)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		mutil.MaybeClose(f)
+		return nil, errors.Wrapf(err, "Failed to seek to the start of %v", f.Name())
+	}
+	return f, nil
+}
+
+// copyObjectStorePath copies the objects in a GCS or S3 bucket matching the mappings into the tarball.
+// The URI of the source is treated as a directory; i.e. the globs are matched against the names of
+// the objects relative to the URI. It returns the number of objects matched by each mapping.
+func copyObjectStorePath(tw *archiveWriter, s *v1alpha1.ImageSource, store objectStore) ([]int, error) {
+	log := zapr.NewLogger(zap.L())
+
+	u, err := url.Parse(s.URI)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse URI %v", s.URI)
+	}
+
+	// Objects are downloaded to a temporary directory so we can reuse the logic for adding local files.
+	tmpDir, err := os.MkdirTemp("", "hydrosObjects")
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create temp dir")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	numMatchesByMapping := make([]int, len(s.Mappings))
+
+	for mIndex, m := range s.Mappings {
+		a := m.Normalized()
+		log.Info("Adding asset", "asset", a)
+
+		parent, glob := splitIntoParent(a.Src)
+
+		// Object names are always delimited by "/" so we use path and not filepath.
+		base := *u
+		base.Path = path.Clean(path.Join("/", u.Path, filepath.ToSlash(parent)))
+		prefix := strings.TrimSuffix(base.String(), "/") + "/"
+
+		objects, err := store.List(prefix)
+		if err != nil {
+			log.Error(err, "Failed to list objects", "prefix", prefix)
+			return numMatchesByMapping, errors.Wrapf(err, "Failed to list objects with prefix %v", prefix)
+		}
+		// Sort the objects so the tarball is reproducible regardless of the order in which objects are listed.
+		sort.Strings(objects)
+
+		numMatches := 0
+		for _, o := range objects {
+			name := strings.TrimPrefix(o, prefix)
+			isMatch, err := doublestar.Match(filepath.ToSlash(glob), name)
+			if err != nil {
+				return numMatchesByMapping, errors.Wrapf(err, "Failed to match glob %v", glob)
+			}
+			if !isMatch {
+				log.V(util.Debug).Info("Skipping object because it doesn't match the glob", "object", o, "glob", a.Src)
+				continue
+			}
+			excluded, err := isExcluded(a.Exclude, name)
+			if err != nil {
+				return numMatchesByMapping, err
+			}
+			if excluded {
+				log.V(util.Debug).Info("Skipping excluded object", "object", o, "exclude", a.Exclude)
+				continue
+			}
+			numMatches++
+
+			// Give each mapping its own directory so different mappings can't clobber each other.
+			sBase := filepath.Join(tmpDir, fmt.Sprintf("mapping%d", mIndex))
+			if err := downloadObject(store, o, filepath.Join(sBase, filepath.FromSlash(name))); err != nil {
+				return numMatchesByMapping, err
+			}
+			if err := addFileToTarGenerator(tw, sBase, filepath.FromSlash(name), a.Strip, a.Dest); err != nil {
+				log.Error(err, "Error adding object to tarball", "object", o, "strip", a.Strip, "dest", a.Dest)
+				return numMatchesByMapping, err
+			}
+		}
+		log.Info("Matched glob", "glob", a.Src, "numMatches", numMatches, "prefix", prefix)
+		numMatchesByMapping[mIndex] = numMatches
+	}
+	return numMatchesByMapping, checkMatches(s, numMatchesByMapping)
+}
+
+// downloadObject downloads the object to the local path.
+func downloadObject(store objectStore, uri string, localPath string) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return errors.Wrapf(err, "Failed to create directory for %v", localPath)
+	}
+
+	r, err := store.NewReader(uri)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read object %v", uri)
+	}
+	if closer, ok := r.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	f, err := os.Create(localPath)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create file %v", localPath)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return errors.Wrapf(err, "Failed to download object %v", uri)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package tarutil
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+)
+
+// NumLargestFiles is the number of largest files reported for a tarball.
+const NumLargestFiles = 10
+
+// Stats is the size of the files added to a tarball.
+type Stats struct {
+	// Size is the total size in bytes of the files before they are compressed.
+	Size int64
+	// NumFiles is the number of files.
+	NumFiles int
+	// Largest are the NumLargestFiles largest files sorted by size; largest first.
+	Largest []v1alpha1.ContextFile
+}
+
+// add adds a file to the stats.
+func (s *Stats) add(name string, size int64) {
+	s.Size += size
+	s.NumFiles++
+	if len(s.Largest) == NumLargestFiles && size <= s.Largest[len(s.Largest)-1].Size {
+		return
+	}
+	i := sort.Search(len(s.Largest), func(i int) bool {
+		return s.Largest[i].Size < size
+	})
+	s.Largest = append(s.Largest, v1alpha1.ContextFile{})
+	copy(s.Largest[i+1:], s.Largest[i:])
+	s.Largest[i] = v1alpha1.ContextFile{Path: name, Size: size}
+	if len(s.Largest) > NumLargestFiles {
+		s.Largest = s.Largest[:NumLargestFiles]
+	}
+}
+
+// Status returns the stats as the status of a build context.
+func (s *Stats) Status() *v1alpha1.ContextStatus {
+	largest := make([]v1alpha1.ContextFile, len(s.Largest))
+	copy(largest, s.Largest)
+	return &v1alpha1.ContextStatus{
+		Size:         s.Size,
+		NumFiles:     s.NumFiles,
+		LargestFiles: largest,
+	}
+}
+
+// SizeError is returned when the files added to a tarball exceed its maximum size.
+type SizeError struct {
+	// MaxSize is the maximum size in bytes.
+	MaxSize int64
+	// Stats are the stats of the files added before the maximum was exceeded including the file which exceeded it.
+	Stats Stats
+}
+
+func (e *SizeError) Error() string {
+	lines := []string{
+		fmt.Sprintf("build context exceeds the maximum size of %v; it is at least %v after adding %d files. The largest files are:", FormatSize(e.MaxSize), FormatSize(e.Stats.Size), e.Stats.NumFiles),
+	}
+	for _, f := range e.Stats.Largest {
+		lines = append(lines, fmt.Sprintf("  %v %v", FormatSize(f.Size), f.Path))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FormatSize formats a size in bytes using binary units e.g. 1.5 MiB.
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,223 @@
+package tarutil
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// progressInterval is how often to log progress while building the tarball.
+	progressInterval = 10 * time.Second
+)
+
+// archiveWriter wraps a tar.Writer and periodically logs how many files and bytes have been written.
+// Large contexts can take a long time to build and without progress reporting it looks like hydros is hung.
+//
+// If the writer is reproducible the entries are spooled to a temporary directory and written when the writer is
+// closed. This way the headers of all entries, including entries copied from other tarballs, are normalized and
+// the entries of all sources are sorted by name.
+type archiveWriter struct {
+	tw  *tar.Writer
+	log logr.Logger
+
+	numFiles   int
+	numBytes   int64
+	lastReport time.Time
+
+	// stats are the sizes of the regular files. If maxSize is positive writing the header of a file which takes
+	// the total size above it fails.
+	stats   Stats
+	maxSize int64
+
+	reproducible bool
+	spoolDir     string
+	spooled      []spooledEntry
+	current      *os.File
+	closed       bool
+}
+
+// spooledEntry is an entry whose contents have been written to a temporary file.
+type spooledEntry struct {
+	header *tar.Header
+	path   string
+}
+
+func newArchiveWriter(w io.Writer) *archiveWriter {
+	return &archiveWriter{
+		tw:         tar.NewWriter(w),
+		log:        zapr.NewLogger(zap.L()),
+		lastReport: time.Now(),
+	}
+}
+
+// newReproducibleArchiveWriter returns an archiveWriter whose output only depends on the names, contents and
+// whether the entries are executable.
+func newReproducibleArchiveWriter(w io.Writer) (*archiveWriter, error) {
+	dir, err := os.MkdirTemp("", "hydrosTarball")
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create directory to spool tarball entries")
+	}
+	a := newArchiveWriter(w)
+	a.reproducible = true
+	a.spoolDir = dir
+	return a, nil
+}
+
+// WriteHeader writes the header for the next entry.
+func (a *archiveWriter) WriteHeader(h *tar.Header) error {
+	if h.Typeflag == tar.TypeReg {
+		a.stats.add(h.Name, h.Size)
+		if a.maxSize > 0 && a.stats.Size > a.maxSize {
+			return &SizeError{MaxSize: a.maxSize, Stats: a.stats}
+		}
+	}
+	if a.reproducible {
+		if err := a.closeCurrent(); err != nil {
+			return err
+		}
+		e := spooledEntry{header: reproducibleHeader(h)}
+		if h.Size > 0 {
+			e.path = filepath.Join(a.spoolDir, fmt.Sprintf("entry%d", len(a.spooled)))
+			f, err := os.Create(e.path)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to spool tarball entry %v", h.Name)
+			}
+			a.current = f
+		}
+		a.spooled = append(a.spooled, e)
+	} else if err := a.tw.WriteHeader(h); err != nil {
+		return err
+	}
+	a.numFiles++
+	a.maybeReport()
+	return nil
+}
+
+// Write writes the contents of the current entry.
+func (a *archiveWriter) Write(b []byte) (int, error) {
+	var n int
+	var err error
+	if a.reproducible {
+		if a.current == nil {
+			return 0, errors.New("Can't write contents of an entry without a size")
+		}
+		n, err = a.current.Write(b)
+	} else {
+		n, err = a.tw.Write(b)
+	}
+	a.numBytes += int64(n)
+	a.maybeReport()
+	return n, err
+}
+
+// Close closes the tar writer and reports the totals. If the writer is reproducible this writes the spooled
+// entries sorted by name. Closing the writer more than once is a no-op.
+func (a *archiveWriter) Close() error {
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	if a.reproducible {
+		defer os.RemoveAll(a.spoolDir)
+		if err := a.writeSpooled(); err != nil {
+			return err
+		}
+	}
+	err := a.tw.Close()
+	a.log.Info("Finished writing tarball", "filesAdded", a.numFiles, "bytesWritten", a.numBytes)
+	return err
+}
+
+// closeCurrent closes the spool file of the current entry if there is one.
+func (a *archiveWriter) closeCurrent() error {
+	if a.current == nil {
+		return nil
+	}
+	err := a.current.Close()
+	a.current = nil
+	return err
+}
+
+// writeSpooled writes the spooled entries to the tarball sorted by name. The sort is stable so if multiple
+// entries have the same name they are written in the order they were added.
+func (a *archiveWriter) writeSpooled() error {
+	if err := a.closeCurrent(); err != nil {
+		return err
+	}
+	sort.SliceStable(a.spooled, func(i, j int) bool {
+		return a.spooled[i].header.Name < a.spooled[j].header.Name
+	})
+	for _, e := range a.spooled {
+		if err := a.tw.WriteHeader(e.header); err != nil {
+			return errors.Wrapf(err, "Error writing tar header: %v", e.header.Name)
+		}
+		if e.path == "" {
+			continue
+		}
+		if err := copySpooled(a.tw, e.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copySpooled(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to open spooled entry %v", path)
+	}
+	defer f.Close()
+	if _, err := io.Copy(w, f); err != nil {
+		return errors.Wrapf(err, "Failed to copy spooled entry %v", path)
+	}
+	return nil
+}
+
+func (a *archiveWriter) maybeReport() {
+	if time.Since(a.lastReport) < progressInterval {
+		return
+	}
+	a.lastReport = time.Now()
+	a.log.Info("Writing tarball", "filesAdded", a.numFiles, "bytesWritten", a.numBytes)
+}
+
+// normalizeHeader clears the fields of a header for a local file which depend on when and by whom the file was
+// checked out. This way the tarball, and therefore its hash, only depends on the names, modes and contents of
+// the files.
+func normalizeHeader(h *tar.Header) {
+	h.ModTime = time.Unix(0, 0)
+	h.AccessTime = time.Time{}
+	h.ChangeTime = time.Time{}
+	h.Uid = 0
+	h.Gid = 0
+	h.Uname = ""
+	h.Gname = ""
+}
+
+// reproducibleHeader returns a copy of the header which only depends on the name, size, type and whether the
+// entry is executable. Timestamps and ownership are cleared and the mode is normalized to 0644 or 0755 so the
+// umask of the machine the sources were checked out on doesn't matter.
+func reproducibleHeader(h *tar.Header) *tar.Header {
+	n := &tar.Header{
+		Typeflag: h.Typeflag,
+		Name:     h.Name,
+		Linkname: h.Linkname,
+		Size:     h.Size,
+		Mode:     0o644,
+	}
+	if h.Typeflag == tar.TypeDir || h.Mode&0o111 != 0 {
+		n.Mode = 0o755
+	}
+	normalizeHeader(n)
+	return n
+}
@@ -0,0 +1,284 @@
+// Package upgrade checks the running version of hydros against the latest GitHub release and replaces the binary
+// with the latest release. Skew matters because different versions of hydros can produce different hydrated
+// manifests e.g. the server and a developer's CLI.
+package upgrade
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/google/go-github/v52/github"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Org and Repo are the GitHub repository hydros is released from.
+	Org  = "jlewi"
+	Repo = "hydros"
+
+	// DefaultMaxPatchSkew is how many patch releases the running version can be behind before the skew is
+	// significant. Releases are cut automatically so patch releases are frequent.
+	DefaultMaxPatchSkew = 10
+
+	// checkInterval is how long the latest release is cached for.
+	checkInterval = 24 * time.Hour
+	cacheFile     = "versioncheck.yaml"
+	checksumsFile = "checksums.txt"
+)
+
+// Skew describes how far the running version is behind the latest release.
+type Skew struct {
+	Current string
+	Latest  string
+	// Behind is true if Current is older than Latest.
+	Behind bool
+	// Significant is true if the major or minor versions differ or Current is more than the maximum number of
+	// patch releases behind.
+	Significant bool
+}
+
+// Compare compares the current version to the latest release. It returns an error if either isn't a semantic
+// version e.g. for development builds.
+func Compare(current string, latest string, maxPatchSkew int) (*Skew, error) {
+	c, err := semver.NewVersion(current)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Version %v isn't a release version", current)
+	}
+	l, err := semver.NewVersion(latest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Latest release %v isn't a semantic version", latest)
+	}
+	s := &Skew{
+		Current: current,
+		Latest:  latest,
+		Behind:  c.LessThan(l),
+	}
+	if s.Behind {
+		s.Significant = c.Major() != l.Major() || c.Minor() != l.Minor() || l.Patch()-c.Patch() > uint64(maxPatchSkew)
+	}
+	return s, nil
+}
+
+// Checker looks up the latest release of hydros.
+type Checker struct {
+	client       *github.Client
+	httpClient   *http.Client
+	cacheDir     string
+	maxPatchSkew int
+	now          func() time.Time
+}
+
+// Option configures a Checker.
+type Option func(c *Checker)
+
+// WithClient sets the GitHub client used to look up releases e.g. to use GitHub Enterprise or a fake.
+func WithClient(client *github.Client) Option {
+	return func(c *Checker) {
+		c.client = client
+	}
+}
+
+// WithCacheDir caches the latest release in dir so Check only calls GitHub once a day.
+func WithCacheDir(dir string) Option {
+	return func(c *Checker) {
+		c.cacheDir = dir
+	}
+}
+
+// WithMaxPatchSkew sets how many patch releases the running version can be behind before the skew is significant.
+func WithMaxPatchSkew(n int) Option {
+	return func(c *Checker) {
+		c.maxPatchSkew = n
+	}
+}
+
+// NewChecker creates a Checker. Requests are unauthenticated and use http.DefaultTransport.
+func NewChecker(opts ...Option) *Checker {
+	c := &Checker{
+		httpClient:   &http.Client{},
+		maxPatchSkew: DefaultMaxPatchSkew,
+		now:          time.Now,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	if c.client == nil {
+		c.client = github.NewClient(c.httpClient)
+	}
+	return c
+}
+
+// LatestRelease returns the latest release of hydros.
+func (c *Checker) LatestRelease(ctx context.Context) (*github.RepositoryRelease, error) {
+	release, _, err := c.client.Repositories.GetLatestRelease(ctx, Org, Repo)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get the latest release of %v/%v", Org, Repo)
+	}
+	return release, nil
+}
+
+// versionCache is the latest release as of CheckedAt.
+type versionCache struct {
+	CheckedAt time.Time `yaml:"checkedAt"`
+	Latest    string    `yaml:"latest"`
+}
+
+// Check compares current to the latest release. If the Checker has a cache directory the latest release is only
+// looked up if it wasn't looked up in the last day.
+func (c *Checker) Check(ctx context.Context, current string) (*Skew, error) {
+	// Don't bother looking up the latest release for development builds.
+	if _, err := semver.NewVersion(current); err != nil {
+		return nil, errors.Wrapf(err, "Version %v isn't a release version", current)
+	}
+	latest := c.readCache()
+	if latest == "" {
+		release, err := c.LatestRelease(ctx)
+		if err != nil {
+			return nil, err
+		}
+		latest = release.GetTagName()
+		c.writeCache(latest)
+	}
+	return Compare(current, latest, c.maxPatchSkew)
+}
+
+// readCache returns the cached latest release or the empty string if there isn't one or it is stale.
+func (c *Checker) readCache() string {
+	if c.cacheDir == "" {
+		return ""
+	}
+	b, err := os.ReadFile(filepath.Join(c.cacheDir, cacheFile))
+	if err != nil {
+		return ""
+	}
+	cache := &versionCache{}
+	if err := yaml.Unmarshal(b, cache); err != nil {
+		return ""
+	}
+	if c.now().Sub(cache.CheckedAt) > checkInterval {
+		return ""
+	}
+	return cache.Latest
+}
+
+// writeCache caches the latest release. Failures are ignored since the cache is only an optimization.
+func (c *Checker) writeCache(latest string) {
+	if c.cacheDir == "" {
+		return
+	}
+	b, err := yaml.Marshal(&versionCache{CheckedAt: c.now(), Latest: latest})
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(c.cacheDir, 0o755); err != nil {
+		return
+	}
+	_ = os.WriteFile(filepath.Join(c.cacheDir, cacheFile), b, 0o644)
+}
+
+// AssetName returns the name of the binary in a release for the running OS and architecture. It matches the names
+// goreleaser gives binaries e.g. hydros_0.0.42_linux_amd64.
+func AssetName(tag string) string {
+	return fmt.Sprintf("%v_%v_%v_%v", Repo, strings.TrimPrefix(tag, "v"), runtime.GOOS, runtime.GOARCH)
+}
+
+// Upgrade replaces the binary at exe with the binary from release. The binary is verified against the checksums
+// published with the release before exe is replaced.
+func (c *Checker) Upgrade(ctx context.Context, release *github.RepositoryRelease, exe string) error {
+	name := AssetName(release.GetTagName())
+	var binary, checksums *github.ReleaseAsset
+	for _, a := range release.Assets {
+		switch a.GetName() {
+		case name:
+			binary = a
+		case checksumsFile:
+			checksums = a
+		}
+	}
+	if binary == nil {
+		return errors.Errorf("Release %v doesn't have a binary %v for %v/%v", release.GetTagName(), name, runtime.GOOS, runtime.GOARCH)
+	}
+	if checksums == nil {
+		return errors.Errorf("Release %v doesn't have %v so the binary can't be verified", release.GetTagName(), checksumsFile)
+	}
+
+	expected, err := c.checksum(ctx, checksums.GetBrowserDownloadURL(), name)
+	if err != nil {
+		return err
+	}
+
+	// Write the new binary next to exe so it can be renamed over exe; renames across filesystems fail.
+	f, err := os.CreateTemp(filepath.Dir(exe), filepath.Base(exe)+".new")
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create a temporary file next to %v", exe)
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	h := sha256.New()
+	err = c.download(ctx, binary.GetBrowserDownloadURL(), io.MultiWriter(f, h))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		return errors.Errorf("Checksum of %v is %v but release %v says it should be %v", name, actual, release.GetTagName(), expected)
+	}
+
+	if err := os.Chmod(tmp, 0o755); err != nil {
+		return errors.Wrapf(err, "Failed to make %v executable", tmp)
+	}
+	if err := os.Rename(tmp, exe); err != nil {
+		return errors.Wrapf(err, "Failed to replace %v", exe)
+	}
+	return nil
+}
+
+// checksum returns the checksum of name listed in the checksums file at url.
+func (c *Checker) checksum(ctx context.Context, url string, name string) (string, error) {
+	var b strings.Builder
+	if err := c.download(ctx, url, &b); err != nil {
+		return "", err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(b.String()))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == name {
+			return fields[0], nil
+		}
+	}
+	return "", errors.Errorf("%v doesn't have a checksum for %v", url, name)
+}
+
+func (c *Checker) download(ctx context.Context, url string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create request for %v", url)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to download %v", url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("Failed to download %v; status %v", url, resp.Status)
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return errors.Wrapf(err, "Failed to download %v", url)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package upgrade
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-github/v52/github"
+)
+
+func Test_Compare(t *testing.T) {
+	cases := map[string]struct {
+		current  string
+		latest   string
+		expected Skew
+	}{
+		"same":         {current: "v0.0.42", latest: "v0.0.42", expected: Skew{}},
+		"ahead":        {current: "v0.0.43", latest: "v0.0.42", expected: Skew{}},
+		"few-patches":  {current: "v0.0.40", latest: "v0.0.42", expected: Skew{Behind: true}},
+		"many-patches": {current: "v0.0.20", latest: "v0.0.42", expected: Skew{Behind: true, Significant: true}},
+		"minor":        {current: "v0.1.9", latest: "v0.2.0", expected: Skew{Behind: true, Significant: true}},
+		"no-prefix":    {current: "0.0.41", latest: "v0.0.42", expected: Skew{Behind: true}},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual, err := Compare(c.current, c.latest, DefaultMaxPatchSkew)
+			if err != nil {
+				t.Fatalf("Compare failed; %v", err)
+			}
+			c.expected.Current = c.current
+			c.expected.Latest = c.latest
+			if d := cmp.Diff(c.expected, *actual); d != "" {
+				t.Errorf("Unexpected skew; diff:\n%v", d)
+			}
+		})
+	}
+
+	if _, err := Compare("dev", "v0.0.42", DefaultMaxPatchSkew); err == nil {
+		t.Errorf("Expected an error comparing a development build")
+	}
+}
+
+// newFakeGitHub serves a latest release with a binary for the running platform and returns a Checker using it.
+func newFakeGitHub(t *testing.T, tag string, binary []byte, opts ...Option) (*Checker, *int) {
+	t.Helper()
+	name := AssetName(tag)
+	sum := sha256.Sum256(binary)
+	lookups := 0
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	mux.HandleFunc(fmt.Sprintf("/repos/%v/%v/releases/latest", Org, Repo), func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		release := &github.RepositoryRelease{
+			TagName: github.String(tag),
+			Assets: []*github.ReleaseAsset{
+				{Name: github.String(name), BrowserDownloadURL: github.String(srv.URL + "/download/" + name)},
+				{Name: github.String(checksumsFile), BrowserDownloadURL: github.String(srv.URL + "/download/" + checksumsFile)},
+			},
+		}
+		if err := json.NewEncoder(w).Encode(release); err != nil {
+			t.Errorf("Failed to encode release; %v", err)
+		}
+	})
+	mux.HandleFunc("/download/"+name, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(binary)
+	})
+	mux.HandleFunc("/download/"+checksumsFile, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%v  hydros_other_darwin_arm64\n%v  %v\n", hex.EncodeToString(make([]byte, 32)), hex.EncodeToString(sum[:]), name)
+	})
+
+	client := github.NewClient(nil)
+	u, err := url.Parse(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("Failed to parse URL; %v", err)
+	}
+	client.BaseURL = u
+	return NewChecker(append([]Option{WithClient(client)}, opts...)...), &lookups
+}
+
+func Test_CheckCachesLatestRelease(t *testing.T) {
+	checker, lookups := newFakeGitHub(t, "v0.0.42", []byte("binary"), WithCacheDir(t.TempDir()))
+
+	for i := 0; i < 2; i++ {
+		skew, err := checker.Check(context.Background(), "v0.0.10")
+		if err != nil {
+			t.Fatalf("Check failed; %v", err)
+		}
+		if !skew.Significant || skew.Latest != "v0.0.42" {
+			t.Errorf("Unexpected skew; got %+v", skew)
+		}
+	}
+	if *lookups != 1 {
+		t.Errorf("Expected the latest release to be looked up once; got %v", *lookups)
+	}
+
+	// Once the cache is stale the release is looked up again.
+	checker.now = func() time.Time { return time.Now().Add(2 * checkInterval) }
+	if _, err := checker.Check(context.Background(), "v0.0.10"); err != nil {
+		t.Fatalf("Check failed; %v", err)
+	}
+	if *lookups != 2 {
+		t.Errorf("Expected the stale cache to be refreshed; got %v lookups", *lookups)
+	}
+}
+
+func Test_Upgrade(t *testing.T) {
+	binary := []byte("new hydros")
+	checker, _ := newFakeGitHub(t, "v0.0.42", binary)
+	exe := filepath.Join(t.TempDir(), "hydros")
+	if err := os.WriteFile(exe, []byte("old hydros"), 0o755); err != nil {
+		t.Fatalf("Failed to write binary; %v", err)
+	}
+
+	release, err := checker.LatestRelease(context.Background())
+	if err != nil {
+		t.Fatalf("LatestRelease failed; %v", err)
+	}
+	if err := checker.Upgrade(context.Background(), release, exe); err != nil {
+		t.Fatalf("Upgrade failed; %+v", err)
+	}
+	actual, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatalf("Failed to read binary; %v", err)
+	}
+	if string(actual) != string(binary) {
+		t.Errorf("Binary wasn't replaced; got %q", actual)
+	}
+
+	// A binary that doesn't match the checksum must not replace exe.
+	release.Assets[0].BrowserDownloadURL = release.Assets[1].BrowserDownloadURL
+	if err := checker.Upgrade(context.Background(), release, exe); err == nil {
+		t.Errorf("Expected a checksum error")
+	}
+	actual, err = os.ReadFile(exe)
+	if err != nil {
+		t.Fatalf("Failed to read binary; %v", err)
+	}
+	if string(actual) != string(binary) {
+		t.Errorf("Binary was replaced despite the checksum mismatch; got %q", actual)
+	}
+}
@@ -0,0 +1,103 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/jlewi/hydros/pkg/config"
+	"github.com/pkg/errors"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// baseTransport is a copy of http.DefaultTransport taken before we replace it.
+// We use it as the starting point for new transports so that calling SetDefaultTransport repeatedly is idempotent.
+var baseTransport = http.DefaultTransport.(*http.Transport).Clone()
+
+// NewTransport creates a new transport configured using cfg.
+// If cfg is nil the transport is equivalent to http.DefaultTransport.
+func NewTransport(cfg *config.HTTPConfig) (*http.Transport, error) {
+	tr := baseTransport.Clone()
+	if cfg == nil {
+		return tr, nil
+	}
+
+	proxyCfg := httpproxy.FromEnvironment()
+	if cfg.Proxy != "" {
+		if _, err := url.Parse(cfg.Proxy); err != nil {
+			return nil, errors.Wrapf(err, "Invalid proxy URL %v", cfg.Proxy)
+		}
+		proxyCfg.HTTPProxy = cfg.Proxy
+		proxyCfg.HTTPSProxy = cfg.Proxy
+	}
+	if cfg.NoProxy != "" {
+		proxyCfg.NoProxy = cfg.NoProxy
+	}
+	proxyFunc := proxyCfg.ProxyFunc()
+	tr.Proxy = func(r *http.Request) (*url.URL, error) {
+		return proxyFunc(r.URL)
+	}
+
+	if cfg.Timeout != "" {
+		timeout, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid timeout %v", cfg.Timeout)
+		}
+		tr.DialContext = (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		tr.TLSHandshakeTimeout = timeout
+		tr.ResponseHeaderTimeout = timeout
+	}
+
+	if cfg.CAFile != "" || cfg.InsecureSkipVerify {
+		tlsCfg := &tls.Config{
+			InsecureSkipVerify: cfg.InsecureSkipVerify,
+		}
+		if cfg.CAFile != "" {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to load the system certificate pool")
+			}
+			pem, err := os.ReadFile(cfg.CAFile)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to read CA file %v", cfg.CAFile)
+			}
+			if ok := pool.AppendCertsFromPEM(pem); !ok {
+				return nil, errors.Errorf("CA file %v doesn't contain any PEM encoded certificates", cfg.CAFile)
+			}
+			tlsCfg.RootCAs = pool
+		}
+		tr.TLSClientConfig = tlsCfg
+	}
+	return tr, nil
+}
+
+// SetDefaultTransport replaces http.DefaultTransport and the default transport used by crane with transports
+// configured using cfg. This way all code paths (GitHub, container registries, GCS) use the same proxy and TLS
+// settings. Google Cloud clients copy http.DefaultTransport when they are created so this should be called
+// before creating any clients.
+func SetDefaultTransport(cfg *config.HTTPConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	tr, err := NewTransport(cfg)
+	if err != nil {
+		return err
+	}
+	http.DefaultTransport = tr
+
+	// go-containerregistry uses its own default transport which allows more idle connections per host.
+	rTr := tr.Clone()
+	if defaultTr, ok := remote.DefaultTransport.(*http.Transport); ok {
+		rTr.MaxIdleConnsPerHost = defaultTr.MaxIdleConnsPerHost
+	}
+	remote.DefaultTransport = rTr
+	return nil
+}
@@ -0,0 +1,86 @@
+package util
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jlewi/hydros/pkg/config"
+)
+
+func Test_NewTransport(t *testing.T) {
+	type testCase struct {
+		name     string
+		cfg      *config.HTTPConfig
+		url      string
+		expected string
+	}
+
+	cases := []testCase{
+		{
+			name:     "proxy",
+			cfg:      &config.HTTPConfig{Proxy: "http://proxy.corp.com:3128"},
+			url:      "https://api.github.com/repos",
+			expected: "http://proxy.corp.com:3128",
+		},
+		{
+			name: "no-proxy",
+			cfg: &config.HTTPConfig{
+				Proxy:   "http://proxy.corp.com:3128",
+				NoProxy: "github.com,.internal.corp.com",
+			},
+			url:      "https://api.github.com/repos",
+			expected: "",
+		},
+		{
+			name: "no-proxy-other-host",
+			cfg: &config.HTTPConfig{
+				Proxy:   "http://proxy.corp.com:3128",
+				NoProxy: ".internal.corp.com",
+			},
+			url:      "https://storage.googleapis.com/bucket",
+			expected: "http://proxy.corp.com:3128",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tr, err := NewTransport(c.cfg)
+			if err != nil {
+				t.Fatalf("Failed to create transport; %v", err)
+			}
+			req, err := http.NewRequest(http.MethodGet, c.url, nil)
+			if err != nil {
+				t.Fatalf("Failed to create request; %v", err)
+			}
+			actual, err := tr.Proxy(req)
+			if err != nil {
+				t.Fatalf("Failed to get proxy; %v", err)
+			}
+			actualStr := ""
+			if actual != nil {
+				actualStr = actual.String()
+			}
+			if actualStr != c.expected {
+				t.Errorf("Got proxy %v; want %v", actualStr, c.expected)
+			}
+		})
+	}
+}
+
+func Test_NewTransportTimeout(t *testing.T) {
+	tr, err := NewTransport(&config.HTTPConfig{Timeout: "15s"})
+	if err != nil {
+		t.Fatalf("Failed to create transport; %v", err)
+	}
+	if tr.TLSHandshakeTimeout != 15*time.Second {
+		t.Errorf("Got TLSHandshakeTimeout %v; want 15s", tr.TLSHandshakeTimeout)
+	}
+	if tr.ResponseHeaderTimeout != 15*time.Second {
+		t.Errorf("Got ResponseHeaderTimeout %v; want 15s", tr.ResponseHeaderTimeout)
+	}
+
+	if _, err := NewTransport(&config.HTTPConfig{Timeout: "notaduration"}); err == nil {
+		t.Errorf("Expected an error for an invalid timeout")
+	}
+}
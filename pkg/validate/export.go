@@ -0,0 +1,113 @@
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// jsonSchemaDialect is the JSON schema dialect of exported schemas.
+	jsonSchemaDialect = "http://json-schema.org/draft-07/schema#"
+	// AllKindsSchemaFile is the name of the exported schema that accepts a resource of any of the hydros kinds.
+	AllKindsSchemaFile = "hydros.json"
+)
+
+// APISchemas returns the schemas of all the kinds in api/v1alpha1 keyed by the name of the file they are
+// exported to. Unlike the schemas returned by SchemaFor, the apiVersion and kind of exported schemas are
+// constrained so editors can tell which schema a resource matches.
+func APISchemas() map[string]*Schema {
+	apiVersion := v1alpha1.Group + "/" + v1alpha1.Version
+
+	kinds := make([]string, 0, len(hydrosKinds))
+	for k := range hydrosKinds {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+
+	result := map[string]*Schema{}
+	all := &Schema{
+		SchemaVersion: jsonSchemaDialect,
+		Title:         "hydros resource",
+		OneOf:         []*Schema{},
+	}
+	for _, k := range kinds {
+		s := ForType(hydrosKinds[k])
+		s.Title = k
+		s.Properties["apiVersion"] = &Schema{Type: stringType, Enum: []string{apiVersion}}
+		s.Properties["kind"] = &Schema{Type: stringType, Enum: []string{k}}
+		s.Required = addRequired(s.Required, "apiVersion", "kind")
+		all.OneOf = append(all.OneOf, s)
+
+		// N.B. The schemas are shared between the individual files and the combined schema so copy the root
+		// before setting $schema.
+		root := *s
+		root.SchemaVersion = jsonSchemaDialect
+		result[k+".json"] = &root
+	}
+	result[AllKindsSchemaFile] = all
+	return result
+}
+
+// addRequired adds the fields to the list of required fields if they aren't already in it.
+func addRequired(required []string, fields ...string) []string {
+	for _, f := range fields {
+		found := false
+		for _, r := range required {
+			if r == f {
+				found = true
+				break
+			}
+		}
+		if !found {
+			required = append(required, f)
+		}
+	}
+	sort.Strings(required)
+	return required
+}
+
+// Export writes the JSON schemas of all the kinds in api/v1alpha1 to dir. It returns the paths of the files
+// that were written.
+func Export(dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrapf(err, "Failed to create directory %v", dir)
+	}
+
+	schemas := APISchemas()
+	names := make([]string, 0, len(schemas))
+	for name := range schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		b, err := marshalSchema(schemas[name])
+		if err != nil {
+			return nil, err
+		}
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, b, 0o644); err != nil {
+			return nil, errors.Wrapf(err, "Failed to write %v", p)
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// marshalSchema marshals the schema the way it is exported.
+func marshalSchema(s *Schema) ([]byte, error) {
+	b := &bytes.Buffer{}
+	e := json.NewEncoder(b)
+	e.SetIndent("", "  ")
+	if err := e.Encode(s); err != nil {
+		return nil, errors.Wrapf(err, "Failed to marshal schema %v", s.Title)
+	}
+	return b.Bytes(), nil
+}
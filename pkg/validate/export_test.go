@@ -0,0 +1,29 @@
+package validate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// Test_SchemasUpToDate verifies the schemas checked into the repository match the API.
+func Test_SchemasUpToDate(t *testing.T) {
+	dir := filepath.Join("..", "..", "schemas")
+	for name, s := range APISchemas() {
+		t.Run(name, func(t *testing.T) {
+			expected, err := marshalSchema(s)
+			if err != nil {
+				t.Fatalf("Failed to marshal schema; %v", err)
+			}
+			actual, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatalf("Failed to read schema; %v; run make schemas", err)
+			}
+			if d := cmp.Diff(string(expected), string(actual)); d != "" {
+				t.Errorf("Schema %v is out of date; run make schemas; diff:\n%v", name, d)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package validate
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+)
+
+const (
+	objectType  = "object"
+	arrayType   = "array"
+	stringType  = "string"
+	integerType = "integer"
+	numberType  = "number"
+	booleanType = "boolean"
+)
+
+// Schema is a JSON schema for a resource. Only the subset of JSON schema needed to describe hydros resources is
+// supported. A schema without a type accepts any value.
+type Schema struct {
+	// SchemaVersion is the JSON schema dialect; it is only set on the root of exported schemas.
+	SchemaVersion        string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	// OneOf is only used to combine the schemas of all the kinds into a single schema for editors; Validate
+	// ignores it.
+	OneOf []*Schema `json:"oneOf,omitempty"`
+}
+
+// MarshalJSON marshals the schema. Objects with properties but no additionalProperties are closed; i.e. they
+// don't allow unknown fields. This matches how Validate treats them.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	// N.B. schema has the same fields as Schema but not its methods which avoids infinite recursion.
+	type schema Schema
+	if s.Type != objectType || s.Properties == nil || s.AdditionalProperties != nil {
+		return json.Marshal((*schema)(s))
+	}
+	return json.Marshal(struct {
+		*schema
+		AdditionalProperties bool `json:"additionalProperties"`
+	}{schema: (*schema)(s), AdditionalProperties: false})
+}
+
+// enums are the allowed values of the string types that are enums. Go doesn't let us discover the values of
+// an enum using reflection so they have to be listed here.
+var enums = map[reflect.Type][]string{
+	reflect.TypeOf(v1alpha1.Strategy("")): {
+		string(v1alpha1.SourceCommitStrategy),
+		string(v1alpha1.MutableTagStrategy),
+		string(v1alpha1.LatestTagPrefix),
+		string(v1alpha1.NewestMatchingTagStrategy),
+		string(v1alpha1.SemverStrategy),
+		string(v1alpha1.PromotedTagStrategy),
+	},
+	reflect.TypeOf(v1alpha1.ConditionType("")): {
+		string(v1alpha1.ReadyCondition),
+		string(v1alpha1.SyncedCondition),
+		string(v1alpha1.BuildingCondition),
+		string(v1alpha1.BlockedCondition),
+	},
+	reflect.TypeOf(v1alpha1.ConditionStatus("")): {
+		string(v1alpha1.ConditionTrue),
+		string(v1alpha1.ConditionFalse),
+		string(v1alpha1.ConditionUnknown),
+	},
+	reflect.TypeOf(v1alpha1.RepoMatchType("")): {
+		string(v1alpha1.IncludeRepo),
+		string(v1alpha1.ExcludeRepo),
+	},
+	reflect.TypeOf(v1alpha1.SourceFormat("")): {
+		string(v1alpha1.KustomizeSourceFormat),
+		string(v1alpha1.YAMLSourceFormat),
+	},
+	reflect.TypeOf(v1alpha1.GuardAction("")): {
+		string(v1alpha1.FailGuardAction),
+		string(v1alpha1.WarnGuardAction),
+	},
+	reflect.TypeOf(v1alpha1.DestLayoutType("")): {
+		string(v1alpha1.StripOverlayDestLayout),
+		string(v1alpha1.KeepOverlayDestLayout),
+		string(v1alpha1.FlattenDestLayout),
+		string(v1alpha1.TemplateDestLayout),
+	},
+	reflect.TypeOf(v1alpha1.HydrationMode("")): {
+		string(v1alpha1.FullHydrationMode),
+		string(v1alpha1.PinOnlyHydrationMode),
+	},
+	reflect.TypeOf(v1alpha1.LabelSelectorOperator("")): {
+		string(v1alpha1.LabelSelectorOpIn),
+		string(v1alpha1.LabelSelectorOpNotIn),
+		string(v1alpha1.LabelSelectorOpExists),
+		string(v1alpha1.LabelSelectorOpDoesNotExist),
+	},
+}
+
+var (
+	jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// ForType generates the schema for the YAML serialization of the Go type t. Field names are determined the way
+// the YAML decoder determines them. Fields tagged with yamltags:"required" are required.
+func ForType(t reflect.Type) *Schema {
+	return forType(t, map[reflect.Type]bool{})
+}
+
+func forType(t reflect.Type, visiting map[reflect.Type]bool) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// Types with custom unmarshaling e.g. resource.Quantity or metav1.Time can't be described by their fields.
+	if hasCustomUnmarshaler(t) {
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: booleanType}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: integerType}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: numberType}
+	case reflect.String:
+		s := &Schema{Type: stringType}
+		if values, ok := enums[t]; ok {
+			s.Enum = values
+		}
+		return s
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: stringType}
+		}
+		return &Schema{Type: arrayType, Items: forType(t.Elem(), visiting)}
+	case reflect.Map:
+		return &Schema{Type: objectType, AdditionalProperties: forType(t.Elem(), visiting)}
+	case reflect.Struct:
+		// N.B. Recursive types are cut off by accepting any value at the point of recursion.
+		if visiting[t] {
+			return &Schema{}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		s := &Schema{Type: objectType, Properties: map[string]*Schema{}}
+		addFields(s, t, visiting)
+		sort.Strings(s.Required)
+		return s
+	default:
+		// e.g. interfaces
+		return &Schema{}
+	}
+}
+
+// addFields adds the fields of the struct type t to the schema s.
+func addFields(s *Schema, t reflect.Type, visiting map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		switch f.Type.Kind() {
+		case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+			continue
+		}
+
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if strings.Contains(opts, "inline") {
+			fs := forType(f.Type, visiting)
+			for k, v := range fs.Properties {
+				s.Properties[k] = v
+			}
+			s.Required = append(s.Required, fs.Required...)
+			if fs.AdditionalProperties != nil {
+				s.AdditionalProperties = fs.AdditionalProperties
+			}
+			continue
+		}
+
+		if name == "" {
+			// This matches the default used by the YAML decoder.
+			name = strings.ToLower(f.Name)
+		}
+		s.Properties[name] = forType(f.Type, visiting)
+		if f.Tag.Get("yamltags") == "required" {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+func hasCustomUnmarshaler(t reflect.Type) bool {
+	for _, c := range []reflect.Type{t, reflect.PtrTo(t)} {
+		if _, ok := c.MethodByName("UnmarshalYAML"); ok {
+			return true
+		}
+		if c.Implements(jsonUnmarshaler) || c.Implements(textUnmarshaler) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package validate
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jlewi/hydros/api/v1alpha1"
+	"github.com/jlewi/hydros/pkg/kustomize"
+	"github.com/jlewi/hydros/pkg/util"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// FieldError is a schema violation in a resource.
+type FieldError struct {
+	// Path is the path of the resource file.
+	Path string
+	Line int
+	// Column is the column of the node in violation.
+	Column int
+	// Field is the path of the field e.g. .spec.destRepos[0].org
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%v:%v:%v: %v: %v", e.Path, e.Line, e.Column, e.Field, e.Message)
+}
+
+// hydrosKinds are the types of the hydros resources.
+var hydrosKinds = map[string]reflect.Type{
+	v1alpha1.ManifestSyncKind:        reflect.TypeOf(v1alpha1.ManifestSync{}),
+	v1alpha1.ImageGVK.Kind:           reflect.TypeOf(v1alpha1.Image{}),
+	v1alpha1.ReplicatedImageGVK.Kind: reflect.TypeOf(v1alpha1.ReplicatedImage{}),
+	v1alpha1.RepoGVK.Kind:            reflect.TypeOf(v1alpha1.RepoConfig{}),
+	v1alpha1.GitHubReleaserGVK.Kind:  reflect.TypeOf(v1alpha1.GitHubReleaser{}),
+	v1alpha1.EcrPolicySyncGVK.Kind:   reflect.TypeOf(v1alpha1.EcrPolicySync{}),
+	v1alpha1.BaseImageWatchGVK.Kind:  reflect.TypeOf(v1alpha1.BaseImageWatch{}),
+	v1alpha1.ImagePromotionGVK.Kind:  reflect.TypeOf(v1alpha1.ImagePromotion{}),
+}
+
+// SchemaFor returns the schema for the resource with the given apiVersion and kind. It returns nil if the
+// resource isn't a hydros resource or the config of a KRM function known to hydros.
+func SchemaFor(apiVersion string, kind string) *Schema {
+	if t, ok := hydrosKinds[kind]; ok && strings.HasPrefix(apiVersion, v1alpha1.Group+"/") {
+		return ForType(t)
+	}
+	// N.B. Function configs are matched by kind regardless of their apiVersion just like the dispatcher does.
+	if newFn, ok := kustomize.Filters()[kind]; ok {
+		return ForType(reflect.TypeOf(newFn()))
+	}
+	return nil
+}
+
+// Kinds returns the kinds that can be validated.
+func Kinds() []string {
+	kinds := make([]string, 0, len(hydrosKinds))
+	for k := range hydrosKinds {
+		kinds = append(kinds, k)
+	}
+	for k := range kustomize.Filters() {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// File validates all the resources in the YAML file at path. Resources that hydros doesn't know about are
+// ignored.
+func File(path string) ([]FieldError, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open %v", path)
+	}
+	defer f.Close()
+
+	result := []FieldError{}
+	// N.B. We decode the documents ourselves rather than using util.ReadYaml because kio parses each document
+	// separately so line numbers would be relative to the start of the document rather than the file.
+	d := yaml.NewDecoder(f)
+	for {
+		doc := &yaml.Node{}
+		if err := d.Decode(doc); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, errors.Wrapf(err, "Failed to parse %v", path)
+		}
+		if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+			continue
+		}
+		n := yaml.NewRNode(doc.Content[0])
+		s := SchemaFor(n.GetApiVersion(), n.GetKind())
+		if s == nil {
+			continue
+		}
+		for _, e := range s.Validate(doc) {
+			e.Path = path
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+// Paths validates all the YAML files in paths; paths can be files or directories.
+func Paths(paths []string) ([]FieldError, error) {
+	result := []FieldError{}
+	for _, p := range paths {
+		files, err := util.FindYamlFiles(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to find YAML files in %v", p)
+		}
+		for _, f := range files {
+			fieldErrs, err := File(f)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, fieldErrs...)
+		}
+	}
+	return result, nil
+}
+
+// Validate validates the node against the schema.
+func (s *Schema) Validate(n *yaml.Node) []FieldError {
+	errs := []FieldError{}
+	s.validate(n, "", &errs)
+	return errs
+}
+
+func (s *Schema) validate(n *yaml.Node, field string, errs *[]FieldError) {
+	for n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	// A null value decodes to the zero value so it is valid for any type.
+	if s.Type == "" || (n.Kind == yaml.ScalarNode && n.ShortTag() == yaml.NodeTagNull) {
+		return
+	}
+
+	addErr := func(n *yaml.Node, field string, format string, args ...interface{}) {
+		*errs = append(*errs, FieldError{
+			Line:    n.Line,
+			Column:  n.Column,
+			Field:   field,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	switch s.Type {
+	case objectType:
+		if n.Kind != yaml.MappingNode {
+			addErr(n, field, "expected an object")
+			return
+		}
+		present := map[string]bool{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k := n.Content[i]
+			v := n.Content[i+1]
+			if k.Value == "<<" {
+				continue
+			}
+			present[k.Value] = true
+			child := field + "." + k.Value
+			if p, ok := s.Properties[k.Value]; ok {
+				p.validate(v, child, errs)
+				continue
+			}
+			if s.AdditionalProperties != nil {
+				s.AdditionalProperties.validate(v, child, errs)
+				continue
+			}
+			addErr(k, child, "unknown field %q", k.Value)
+		}
+		for _, r := range s.Required {
+			if !present[r] {
+				addErr(n, field+"."+r, "required field is missing")
+			}
+		}
+	case arrayType:
+		if n.Kind != yaml.SequenceNode {
+			addErr(n, field, "expected an array")
+			return
+		}
+		for i, item := range n.Content {
+			s.Items.validate(item, fmt.Sprintf("%v[%v]", field, i), errs)
+		}
+	default:
+		if n.Kind != yaml.ScalarNode {
+			addErr(n, field, "expected a %v", s.Type)
+			return
+		}
+		tag := n.ShortTag()
+		switch s.Type {
+		case integerType:
+			if tag != yaml.NodeTagInt {
+				addErr(n, field, "expected an integer but got %q", n.Value)
+			}
+		case numberType:
+			if tag != yaml.NodeTagInt && tag != yaml.NodeTagFloat {
+				addErr(n, field, "expected a number but got %q", n.Value)
+			}
+		case booleanType:
+			if tag != yaml.NodeTagBool {
+				addErr(n, field, "expected a boolean but got %q", n.Value)
+			}
+		}
+		// N.B. The empty string means the default should be used.
+		if len(s.Enum) > 0 && n.Value != "" {
+			for _, e := range s.Enum {
+				if n.Value == e {
+					return
+				}
+			}
+			addErr(n, field, "%q isn't one of %v", n.Value, strings.Join(s.Enum, ", "))
+		}
+	}
+}
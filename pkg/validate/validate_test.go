@@ -0,0 +1,99 @@
+package validate
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const testResources = `apiVersion: hydros.dev/v1alpha1
+kind: ManifestSync
+metadata:
+  name: test
+spec:
+  sourceRepo:
+    org: acme
+    repo: app
+    branch: main
+  destPath: hydrated
+  sourceFormat: json
+  imageTagsToPin:
+    - tags:
+        - latest
+      strategy: sourceCommit
+      stratgy: mutableTag
+  merge:
+    approvals: two
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ignored
+data:
+  anything: goes
+---
+apiVersion: v1alpha1
+kind: CommonLabels
+metadata:
+  name: labels
+spec:
+  labels:
+    app: test
+  removeLabels:
+    - old
+`
+
+func Test_File(t *testing.T) {
+	dir, err := os.MkdirTemp("", "testValidate")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir; %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "resources.yaml")
+	if err := os.WriteFile(path, []byte(testResources), 0o644); err != nil {
+		t.Fatalf("Failed to write resources; %v", err)
+	}
+
+	actual, err := File(path)
+	if err != nil {
+		t.Fatalf("File failed; %v", err)
+	}
+
+	expected := []FieldError{
+		{Path: path, Line: 11, Column: 17, Field: ".spec.sourceFormat", Message: `"json" isn't one of kustomize, yaml`},
+		{Path: path, Line: 16, Column: 7, Field: ".spec.imageTagsToPin[0].stratgy", Message: `unknown field "stratgy"`},
+		{Path: path, Line: 18, Column: 16, Field: ".spec.merge.approvals", Message: `expected an integer but got "two"`},
+		{Path: path, Line: 34, Column: 3, Field: ".spec.removeLabels", Message: `unknown field "removeLabels"`},
+	}
+	if d := cmp.Diff(expected, actual); d != "" {
+		t.Errorf("Unexpected errors; diff:\n%v", d)
+	}
+}
+
+func Test_Required(t *testing.T) {
+	type inner struct {
+		Name string `yaml:"name" yamltags:"required"`
+	}
+	type outer struct {
+		Inner inner `yaml:"inner"`
+	}
+
+	s := ForType(reflect.TypeOf(outer{}))
+	n, err := yaml.Parse("inner:\n  other: 1\n")
+	if err != nil {
+		t.Fatalf("Failed to parse YAML; %v", err)
+	}
+
+	expected := []FieldError{
+		{Line: 2, Column: 3, Field: ".inner.other", Message: `unknown field "other"`},
+		{Line: 2, Column: 3, Field: ".inner.name", Message: "required field is missing"},
+	}
+	if d := cmp.Diff(expected, s.Validate(n.YNode())); d != "" {
+		t.Errorf("Unexpected errors; diff:\n%v", d)
+	}
+}